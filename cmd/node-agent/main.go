@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"blytz/internal/config"
+	"blytz/internal/egress"
+	"blytz/internal/logs"
+	"blytz/internal/nodeagent"
+	"blytz/internal/provisioner"
+	"go.uber.org/zap"
+)
+
+func main() {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync()
+
+	cfg, err := config.LoadNodeAgent()
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+
+	if err := os.MkdirAll(cfg.CustomersDir, 0755); err != nil {
+		logger.Fatal("Failed to create customers directory", zap.Error(err))
+	}
+
+	docker := provisioner.NewDockerProvisioner(cfg.CustomersDir)
+	if cfg.EgressFirewall {
+		docker.UseFirewall(egress.NewFirewall())
+		restored, err := docker.RestoreEgress(context.Background())
+		if err != nil {
+			logger.Fatal("Failed to restore egress rules", zap.Error(err))
+		}
+		logger.Info("Restored tenant egress rules", zap.Int("tenants", restored))
+	}
+
+	agent := nodeagent.NewServer(docker, cfg.CustomersDir, cfg.Token, logger)
+	agent.UseLogs(logs.NewDockerSource())
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: agent.Handler(),
+	}
+
+	go func() {
+		logger.Info("Node agent starting", zap.String("addr", cfg.Addr))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("Node agent failed to start", zap.Error(err))
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	logger.Info("Shutting down node agent...")
+
+	// Let in-flight container operations finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Node agent forced to shutdown", zap.Error(err))
+	}
+
+	logger.Info("Node agent exited")
+}
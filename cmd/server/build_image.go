@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/images"
+)
+
+// buildImage implements the build-image command: it builds a versioned image
+// of an agent type, tags it under the image repository and pins new tenants
+// of the agent type to its digest
+func buildImage(cfg *config.Config, args []string, logger *zap.Logger) error {
+	flags := flag.NewFlagSet("build-image", flag.ContinueOnError)
+	agentType := flags.String("agent", "", "agent type to build, e.g. openclaw")
+	version := flags.String("version", "", "agent version to install and tag the image with")
+	repository := flags.String("repository", cfg.ImageRepository, "repository images are tagged under")
+	push := flags.Bool("push", false, "push the image to its registry so worker nodes can pull it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *agentType == "" || *version == "" {
+		flags.Usage()
+		return fmt.Errorf("-agent and -version are required")
+	}
+
+	database, err := db.New(cfg.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("connect to database: %w", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(); err != nil {
+		return fmt.Errorf("run migrations: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	image, err := images.NewBuilder(database, *repository, *push, logger).Build(ctx, *agentType, *version)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n%s\n", image.Tag, image.Digest)
+	return nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,8 +13,18 @@ import (
 	"blytz/internal/caddy"
 	"blytz/internal/config"
 	"blytz/internal/db"
+	"blytz/internal/domains"
+	"blytz/internal/egress"
+	"blytz/internal/metrics"
+	"blytz/internal/nodeagent"
+	"blytz/internal/privacy"
 	"blytz/internal/provisioner"
+	"blytz/internal/rollout"
+	"blytz/internal/scheduler"
 	"blytz/internal/stripe"
+	"blytz/internal/supervisor"
+	"blytz/internal/telemetry"
+	"blytz/internal/waitlist"
 	"go.uber.org/zap"
 )
 
@@ -29,6 +40,13 @@ func main() {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "build-image" {
+		if err := buildImage(cfg, os.Args[2:], logger); err != nil {
+			logger.Fatal("Failed to build agent image", zap.Error(err))
+		}
+		return
+	}
+
 	database, err := db.New(cfg.DatabasePath)
 	if err != nil {
 		logger.Fatal("Failed to connect to database", zap.Error(err))
@@ -39,32 +57,160 @@ func main() {
 		logger.Fatal("Failed to run migrations", zap.Error(err))
 	}
 
-	ctx := context.Background()
-	if err := loadAllocatedPorts(ctx, database); err != nil {
-		logger.Fatal("Failed to load allocated ports", zap.Error(err))
-	}
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
 
 	var caddyClient *caddy.Client
 	if cfg.CaddyAdminURL != "" {
-		caddyClient = caddy.NewClient(cfg.CaddyAdminURL)
+		caddyClient = caddy.NewClient(cfg.CaddyAdminURL,
+			caddy.WithServer(cfg.CaddyServerName),
+			caddy.WithDomainServer(cfg.CaddyDomainServerName),
+			caddy.WithToken(cfg.CaddyAdminToken),
+			caddy.WithTimeout(time.Duration(cfg.CaddyTimeoutSeconds)*time.Second),
+		)
+		telemetry.RegisterCircuitBreaker("caddy", caddyClient)
 	}
 
 	prov := provisioner.NewService(
 		database,
 		cfg.TemplatesDir,
 		cfg.CustomersDir,
-		cfg.OpenAIAPIKey,
+		cfg.LLMProxyURL,
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		caddyClient,
 		cfg.BaseDomain,
 		logger,
 	)
+	// Tenants on this host join the network instead of publishing host ports
+	if cfg.TenantNetwork != "" {
+		prov.UseNetwork(cfg.TenantNetwork)
+	}
+	if err := prov.RecoverPorts(ctx); err != nil {
+		logger.Fatal("Failed to recover port allocations", zap.Error(err))
+	}
+	prov.UseHardening(provisioner.Hardening{
+		PidsLimit:   cfg.ContainerPidsLimit,
+		NoFileLimit: cfg.ContainerNoFileLimit,
+		Runtime:     cfg.ContainerRuntime,
+	})
+	// Tenants on this host are confined by their egress rules
+	if cfg.EgressFirewall {
+		firewall := egress.NewFirewall()
+		prov.UseFirewall(firewall)
+		if err := prov.RestoreEgress(ctx); err != nil {
+			logger.Fatal("Failed to restore egress rules", zap.Error(err))
+		}
+		// Tenants sharing the network reach only Caddy and the control plane over it
+		if cfg.TenantNetwork != "" {
+			rules := egress.RenderNetwork(cfg.TenantNetwork, cfg.TenantNetworkPeers)
+			if err := firewall.IsolateNetwork(ctx, cfg.TenantNetwork, []byte(rules)); err != nil {
+				logger.Fatal("Failed to isolate tenant network", zap.Error(err))
+			}
+		}
+	}
+
+	// With an agent token, tenants are placed on registered worker nodes
+	sched := scheduler.New(database)
+	if cfg.NodeAgentToken != "" {
+		prov.UseNodes(sched, func(node *db.Node) provisioner.Runtime {
+			return nodeagent.NewClient(node.AgentURL, cfg.NodeAgentToken, cfg.CustomersDir)
+		})
+	}
+
+	if err := telemetry.RegisterStateCollectors(prov.PortPoolStats, database.CountCustomersByStatus); err != nil {
+		logger.Fatal("Failed to register metrics collectors", zap.Error(err))
+	}
+
+	// Plans without a configured price bill at STRIPE_PRICE_ID
+	for planID, priceID := range cfg.StripePlanPrices {
+		if err := database.SetPlanStripePrice(ctx, planID, priceID); err != nil {
+			logger.Fatal("Failed to configure plan price", zap.String("plan_id", planID), zap.Error(err))
+		}
+	}
+
+	// Signups on plans with trial days start with a free trial
+	for planID, days := range cfg.PlanTrialDays {
+		if err := database.SetPlanTrialDays(ctx, planID, days); err != nil {
+			logger.Fatal("Failed to configure plan trial", zap.String("plan_id", planID), zap.Error(err))
+		}
+	}
 
-	stripeSvc := stripe.NewService(cfg.StripeSecretKey, cfg.StripePriceID)
+	// Plans default to open egress; restricted tenants reach only their allowlist
+	for planID, policy := range cfg.PlanEgressPolicies {
+		if err := database.SetPlanEgress(ctx, planID, policy); err != nil {
+			logger.Fatal("Failed to configure plan egress", zap.String("plan_id", planID), zap.Error(err))
+		}
+	}
+
+	stripeSvc := stripe.NewService(cfg.StripeSecretKey, cfg.StripePriceID, cfg.StripeMeteredPrices...)
 	stripeWebhook := stripe.NewWebhookHandler(database, prov, cfg.StripeWebhookSecret)
 
-	router := api.NewRouter(database, prov, stripeSvc, stripeWebhook, cfg, logger)
+	usageReporter := stripe.NewUsageReporter(database, cfg.StripeSecretKey, stripe.MeterEvents{
+		Messages:  cfg.StripeMessagesMeter,
+		LLMTokens: cfg.StripeTokensMeter,
+	}, logger)
+	go usageReporter.Run(ctx, time.Duration(cfg.UsageReportInterval)*time.Second)
+
+	if cfg.PendingSignupTTLHours > 0 {
+		janitor := stripe.NewCheckoutJanitor(database, stripeSvc, time.Duration(cfg.PendingSignupTTLHours)*time.Hour, logger)
+		go janitor.Run(ctx, 15*time.Minute)
+	}
+
+	waitlistSvc := waitlist.NewService(database, cfg.MaxCustomers, time.Duration(cfg.WaitlistInviteHours)*time.Hour, logger)
+	if cfg.SMTPAddr != "" {
+		waitlistSvc.UseNotifier(waitlist.NewMailNotifier(cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom, cfg.BaseDomain))
+	} else {
+		logger.Warn("SMTP_ADDR not set; waitlist invitations will not be emailed")
+	}
+	go waitlistSvc.Run(ctx, time.Minute)
+
+	privacySvc := privacy.NewService(database, prov, stripeSvc, cfg.CustomersDir, time.Duration(cfg.ErasureGraceDays)*24*time.Hour, logger)
+	go privacySvc.Run(ctx, time.Hour)
+
+	collector := metrics.NewCollector(database, metrics.NewDockerSource(), "/proc", cfg.MetricsWindow, logger)
+	go collector.Run(ctx, time.Duration(cfg.MetricsInterval)*time.Second)
+
+	policy := supervisor.DefaultPolicy()
+	policy.FailureThreshold = cfg.HealthFailureLimit
+	policy.BackoffBase = time.Duration(cfg.HealthRestartBackoff) * time.Second
+	policy.CrashLoopLimit = cfg.HealthCrashLoopLimit
+	policy.Retention = time.Duration(cfg.HealthHistoryDays) * 24 * time.Hour
+	sup := supervisor.New(database, prov, policy, logger)
+	go sup.Run(ctx, time.Duration(cfg.HealthProbeInterval)*time.Second)
+
+	rolloutPolicy := rollout.DefaultPolicy()
+	rolloutPolicy.CanarySize = cfg.RolloutCanarySize
+	rolloutPolicy.Concurrency = cfg.RolloutConcurrency
+	rolloutPolicy.BakePeriod = time.Duration(cfg.RolloutBakeSeconds) * time.Second
+	rolloutPolicy.BatchBake = time.Duration(cfg.RolloutBatchBake) * time.Second
+	rolloutPolicy.MaxErrorRate = float64(cfg.RolloutMaxErrorPct) / 100
+	orchestrator := rollout.New(database, prov, rolloutPolicy, logger)
+	go orchestrator.Run(ctx, 30*time.Second)
+
+	// Custom domains are served through Caddy routes, so they need its admin API
+	var domainSvc *domains.Service
+	if caddyClient != nil {
+		domainSvc = domains.NewService(database, net.DefaultResolver, prov, cfg.BaseDomain, logger)
+		go domainSvc.Run(ctx, 5*time.Minute)
+	}
+
+	// Caddy keeps routes added through its admin API only until it restarts
+	if caddyClient != nil && cfg.CaddySyncInterval > 0 {
+		go prov.RunRouteSync(ctx, time.Duration(cfg.CaddySyncInterval)*time.Second)
+	}
+
+	router := api.NewRouter(database, prov, stripeSvc, stripeWebhook, cfg, logger,
+		api.WithPrivacy(privacySvc),
+		api.WithMetrics(collector),
+		api.WithSupervisor(sup),
+		api.WithUsageReporter(usageReporter),
+		api.WithWaitlist(waitlistSvc),
+		api.WithScheduler(sched),
+		api.WithMigrator(prov),
+		api.WithRollouts(orchestrator),
+		api.WithDomains(domainSvc),
+	)
 
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
@@ -83,6 +229,7 @@ func main() {
 	<-quit
 
 	logger.Info("Shutting down server...")
+	stopWorkers()
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -93,16 +240,3 @@ func main() {
 
 	logger.Info("Server exited")
 }
-
-func loadAllocatedPorts(ctx context.Context, database *db.DB) error {
-	ports, err := database.GetAllocatedPorts(ctx)
-	if err != nil {
-		return err
-	}
-
-	for _, port := range ports {
-		_ = port
-	}
-
-	return nil
-}
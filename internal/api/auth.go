@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+)
+
+const (
+	authCustomerKey = "auth_customer"
+	authAdminKey    = "auth_admin"
+)
+
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+func isAdminToken(cfg *config.Config, token string) bool {
+	if cfg.AdminAPIKey == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminAPIKey)) == 1
+}
+
+// requireAdmin only lets requests carrying ADMIN_API_KEY as a bearer token through
+func requireAdmin(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdminToken(cfg, bearerToken(c)) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Admin credentials required",
+			})
+			return
+		}
+		c.Set(authAdminKey, true)
+		c.Next()
+	}
+}
+
+// requireCustomerOrAdmin lets through admins and the customer named by the :id route parameter
+func requireCustomerOrAdmin(database *db.DB, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if isAdminToken(cfg, token) {
+			c.Set(authAdminKey, true)
+			c.Next()
+			return
+		}
+
+		customer, err := database.GetCustomerByAccessToken(c.Request.Context(), token)
+		if err != nil || customer.ID != c.Param("id") {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Valid customer or admin credentials required",
+			})
+			return
+		}
+
+		c.Set(authCustomerKey, customer)
+		c.Next()
+	}
+}
+
+// requireCustomer authenticates a customer by their access token, for endpoints
+// that act on the caller's own account rather than a customer named in the path
+func requireCustomer(database *db.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		customer, err := database.GetCustomerByAccessToken(c.Request.Context(), bearerToken(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Valid customer credentials required",
+			})
+			return
+		}
+
+		c.Set(authCustomerKey, customer)
+		c.Next()
+	}
+}
+
+// requireGatewayToken authenticates a tenant's agent by the gateway token issued at provisioning
+func requireGatewayToken(database *db.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		customer, err := database.GetCustomerByGatewayToken(c.Request.Context(), bearerToken(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Valid gateway token required",
+			})
+			return
+		}
+
+		c.Set(authCustomerKey, customer)
+		c.Next()
+	}
+}
+
+// requireLLMProxyToken authenticates a tenant's LLM calls by its proxy token, sent
+// the way each SDK sends an API key: as a bearer token (OpenAI) or X-Api-Key (Anthropic)
+func requireLLMProxyToken(database *db.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			token = strings.TrimSpace(c.GetHeader("X-Api-Key"))
+		}
+
+		customer, err := database.GetCustomerByLLMProxyToken(c.Request.Context(), token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Valid LLM proxy token required",
+			})
+			return
+		}
+
+		c.Set(authCustomerKey, customer)
+		c.Next()
+	}
+}
+
+// authCustomer returns the customer authenticated by the request's token, if any
+func authCustomer(c *gin.Context) *db.Customer {
+	customer, _ := c.Get(authCustomerKey)
+	if customer, ok := customer.(*db.Customer); ok {
+		return customer
+	}
+	return nil
+}
+
+// isAdmin reports whether the request was authenticated with the admin key
+func isAdmin(c *gin.Context) bool {
+	return c.GetBool(authAdminKey)
+}
+
+// requireMetricsToken protects the scrape endpoint with METRICS_TOKEN when one is configured
+func requireMetricsToken(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.MetricsToken == "" {
+			c.Next()
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(bearerToken(c)), []byte(cfg.MetricsToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, ErrorResponse{
+				Error:   "unauthorized",
+				Message: "Metrics token required",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/llmproxy"
+	"blytz/internal/stripe"
+)
+
+// BillingHandler serves plans, metered usage and the customer's Stripe subscription
+type BillingHandler struct {
+	db       *db.DB
+	billing  stripe.Billing
+	reporter *stripe.UsageReporter
+	llm      *llmproxy.Proxy
+	cfg      *config.Config
+	logger   *zap.Logger
+}
+
+// NewBillingHandler creates a new billing handler
+func NewBillingHandler(database *db.DB, billing stripe.Billing, reporter *stripe.UsageReporter, llm *llmproxy.Proxy, cfg *config.Config, logger *zap.Logger) *BillingHandler {
+	return &BillingHandler{
+		db:       database,
+		billing:  billing,
+		reporter: reporter,
+		llm:      llm,
+		cfg:      cfg,
+		logger:   logger,
+	}
+}
+
+// newUsageReporter bills the metrics with a Stripe meter event configured
+func newUsageReporter(database *db.DB, cfg *config.Config, logger *zap.Logger) *stripe.UsageReporter {
+	return stripe.NewUsageReporter(database, cfg.StripeSecretKey, stripe.MeterEvents{
+		Messages:  cfg.StripeMessagesMeter,
+		LLMTokens: cfg.StripeTokensMeter,
+	}, logger)
+}
+
+// GetBillingUsage returns the customer's metered usage this billing period and how much Stripe has been sent
+func (h *BillingHandler) GetBillingUsage(c *gin.Context) {
+	id := c.Param("id")
+
+	customer, err := h.db.GetCustomerByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	usage, err := h.reporter.CurrentUsage(c.Request.Context(), customer)
+	if err != nil {
+		h.logger.Error("Failed to query billing usage", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to retrieve billing usage",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": id,
+		"billing":     usage,
+	})
+}
+
+// ListPlans returns the plans available at signup
+func (h *BillingHandler) ListPlans(c *gin.Context) {
+	plans, err := h.db.GetPlans(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list plans", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to retrieve plans",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plans": plans,
+		"count": len(plans),
+	})
+}
+
+// ChangePlanRequest names the plan a customer moves to
+type ChangePlanRequest struct {
+	PlanID string `json:"plan_id"`
+}
+
+// ChangePlan moves the customer's subscription to another plan with prorated
+// billing. The tenant is resized once Stripe confirms the change by webhook.
+func (h *BillingHandler) ChangePlan(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	var req ChangePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.PlanID == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: "plan_id is required",
+		})
+		return
+	}
+
+	customer, err := h.db.GetCustomerByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	if customer.StripeSubscriptionID == nil || *customer.StripeSubscriptionID == "" {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "no_subscription",
+			Message: "Complete checkout before changing plans",
+		})
+		return
+	}
+
+	plan, err := h.db.GetPlan(ctx, req.PlanID)
+	if err != nil || !plan.IsActive {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_plan",
+			Message: "Unknown plan",
+		})
+		return
+	}
+
+	if plan.ID == customer.PlanID {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "plan_unchanged",
+			Message: "Customer is already on this plan",
+		})
+		return
+	}
+
+	if !plan.AllowsAgent(customer.AgentTypeID) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "plan_agent_not_allowed",
+			Message: fmt.Sprintf("The %s plan does not include the %s agent", plan.Name, customer.AgentTypeID),
+		})
+		return
+	}
+
+	reason, err := h.exceedsPlan(ctx, customer, plan)
+	if err != nil {
+		h.logger.Error("Failed to check plan quotas", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to check usage against the plan",
+		})
+		return
+	}
+	if reason != "" {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "plan_quota_exceeded",
+			Message: reason,
+		})
+		return
+	}
+
+	if err := h.billing.ChangePlan(*customer.StripeSubscriptionID, plan.StripePriceID, plan.ID); err != nil {
+		h.logger.Error("Failed to change subscription plan", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "billing_unavailable",
+			Message: "Failed to update the subscription",
+		})
+		return
+	}
+
+	h.logger.Info("Plan change requested",
+		zap.String("customer_id", id),
+		zap.String("from", customer.PlanID),
+		zap.String("to", plan.ID))
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"customer_id": id,
+		"plan_id":     plan.ID,
+		"status":      "pending",
+	})
+}
+
+// exceedsPlan explains how the customer's usage this period is over the
+// plan's quotas, or returns "" when it fits
+func (h *BillingHandler) exceedsPlan(ctx context.Context, customer *db.Customer, plan *db.Plan) (string, error) {
+	if plan.MonthlyMessages > 0 {
+		start, end := stripe.BillingPeriod(customer, time.Now())
+		usage, err := h.db.GetMeteredUsage(ctx, customer.ID, start, end)
+		if err != nil {
+			return "", err
+		}
+		if usage.Messages > int64(plan.MonthlyMessages) {
+			return fmt.Sprintf("%d messages this billing period exceed the %s plan's %d",
+				usage.Messages, plan.Name, plan.MonthlyMessages), nil
+		}
+	}
+
+	if capMicros := planSpendCapMicros(plan, h.cfg); capMicros > 0 {
+		status, err := h.llm.Status(ctx, customer)
+		if err != nil {
+			return "", err
+		}
+		if status.SpendMicros > capMicros {
+			return fmt.Sprintf("LLM spend this month exceeds the %s plan's cap of $%d",
+				plan.Name, capMicros/1_000_000), nil
+		}
+	}
+
+	return "", nil
+}
+
+const (
+	defaultInvoiceLimit = 12
+	maxInvoiceLimit     = 100
+)
+
+// CreatePortalSession opens a Stripe billing portal session where the customer
+// updates their payment method, downloads invoices or cancels
+func (h *BillingHandler) CreatePortalSession(c *gin.Context) {
+	customer := authCustomer(c)
+	if customer.StripeCustomerID == nil || *customer.StripeCustomerID == "" {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "no_subscription",
+			Message: "Complete checkout before managing billing",
+		})
+		return
+	}
+
+	returnURL := fmt.Sprintf("https://%s/dashboard", h.cfg.BaseDomain)
+	portalURL, err := h.billing.CreatePortalSession(*customer.StripeCustomerID, returnURL)
+	if err != nil {
+		h.logger.Error("Failed to create billing portal session", zap.String("customer_id", customer.ID), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "billing_unavailable",
+			Message: "Failed to open the billing portal",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": portalURL})
+}
+
+// ListInvoices returns the customer's most recent Stripe invoices
+func (h *BillingHandler) ListInvoices(c *gin.Context) {
+	customer := authCustomer(c)
+
+	limit := defaultInvoiceLimit
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxInvoiceLimit {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_request",
+				Message: fmt.Sprintf("limit must be between 1 and %d", maxInvoiceLimit),
+			})
+			return
+		}
+		limit = n
+	}
+
+	// Nothing has been billed before checkout completes
+	invoices := []stripe.Invoice{}
+	if customer.StripeCustomerID != nil && *customer.StripeCustomerID != "" {
+		var err error
+		invoices, err = h.billing.ListInvoices(*customer.StripeCustomerID, limit)
+		if err != nil {
+			h.logger.Error("Failed to list invoices", zap.String("customer_id", customer.ID), zap.Error(err))
+			c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "billing_unavailable",
+				Message: "Failed to retrieve invoices",
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoices": invoices,
+		"count":    len(invoices),
+	})
+}
+
+// GetSubscription returns the customer's plan and their subscription as Stripe reports it
+func (h *BillingHandler) GetSubscription(c *gin.Context) {
+	customer := authCustomer(c)
+	if customer.StripeSubscriptionID == nil || *customer.StripeSubscriptionID == "" {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "no_subscription",
+			Message: "No subscription yet",
+		})
+		return
+	}
+
+	subscription, err := h.billing.GetSubscription(*customer.StripeSubscriptionID)
+	if err != nil {
+		h.logger.Error("Failed to get subscription", zap.String("customer_id", customer.ID), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "billing_unavailable",
+			Message: "Failed to retrieve the subscription",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id":  customer.ID,
+		"plan_id":      customer.PlanID,
+		"subscription": subscription,
+	})
+}
+
+// ResumeSubscription restarts a subscription paused when its free trial ended
+// without a payment method. The tenant restarts when Stripe confirms.
+func (h *BillingHandler) ResumeSubscription(c *gin.Context) {
+	customer := authCustomer(c)
+	if customer.StripeSubscriptionID == nil || *customer.StripeSubscriptionID == "" {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "no_subscription",
+			Message: "No subscription yet",
+		})
+		return
+	}
+
+	subscription, err := h.billing.GetSubscription(*customer.StripeSubscriptionID)
+	if err != nil {
+		h.logger.Error("Failed to get subscription", zap.String("customer_id", customer.ID), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "billing_unavailable",
+			Message: "Failed to retrieve the subscription",
+		})
+		return
+	}
+
+	if subscription.Status != "paused" {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "subscription_not_paused",
+			Message: "The subscription is not paused",
+		})
+		return
+	}
+
+	if subscription.PaymentMethod == nil {
+		c.JSON(http.StatusPaymentRequired, ErrorResponse{
+			Error:   "payment_method_required",
+			Message: "Add a payment method in the billing portal before resuming",
+		})
+		return
+	}
+
+	if err := h.billing.ResumeSubscription(subscription.ID); err != nil {
+		h.logger.Error("Failed to resume subscription", zap.String("customer_id", customer.ID), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "billing_unavailable",
+			Message: "Failed to resume the subscription",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"customer_id": customer.ID,
+		"status":      "pending",
+	})
+}
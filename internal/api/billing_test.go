@@ -0,0 +1,309 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripeSDK "github.com/stripe/stripe-go/v84"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/stripe"
+)
+
+// fakeBilling records calls and serves canned Stripe data
+type fakeBilling struct {
+	err          error
+	portalCalls  []string
+	invoiceLimit int
+	invoices     []stripe.Invoice
+	subscription *stripe.Subscription
+	resumed      []string
+}
+
+func (f *fakeBilling) CreatePortalSession(stripeCustomerID, returnURL string) (string, error) {
+	f.portalCalls = append(f.portalCalls, stripeCustomerID+" "+returnURL)
+	return "https://billing.stripe.com/p/session/test", f.err
+}
+
+func (f *fakeBilling) ListInvoices(stripeCustomerID string, limit int) ([]stripe.Invoice, error) {
+	f.invoiceLimit = limit
+	return f.invoices, f.err
+}
+
+func (f *fakeBilling) GetSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	return f.subscription, f.err
+}
+
+func (f *fakeBilling) ChangePlan(subscriptionID, priceID, planID string) error {
+	return f.err
+}
+
+func (f *fakeBilling) ResumeSubscription(subscriptionID string) error {
+	f.resumed = append(f.resumed, subscriptionID)
+	return f.err
+}
+
+// stubStripeAPI points the Stripe SDK's package-level API calls at handler for the test
+func stubStripeAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	previous := stripeSDK.GetBackend(stripeSDK.APIBackend)
+	stripeSDK.SetBackend(stripeSDK.APIBackend, stripeSDK.GetBackendWithConfig(stripeSDK.APIBackend, &stripeSDK.BackendConfig{
+		URL:               stripeSDK.String(srv.URL),
+		MaxNetworkRetries: stripeSDK.Int64(0),
+		LeveledLogger:     &stripeSDK.LeveledLogger{Level: stripeSDK.LevelNull},
+	}))
+	t.Cleanup(func() { stripeSDK.SetBackend(stripeSDK.APIBackend, previous) })
+}
+
+func TestGetBillingUsage(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	reporter := stripe.NewUsageReporter(database, "sk-test", stripe.MeterEvents{LLMTokens: "llm_tokens"}, zap.NewNop())
+	router, _ := setupAuthTestServerWithDB(t, database, WithUsageReporter(reporter))
+	ctx := t.Context()
+
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	createAuthedCustomer(t, database, "bob@example.com", "bob-token")
+
+	now := time.Now().UTC()
+	start := now.Add(-72 * time.Hour).Truncate(time.Hour)
+	end := start.AddDate(0, 1, 0)
+	require.NoError(t, database.UpdateStripeInfo(ctx, alice.ID, "cus_alice", "sub_alice"))
+	require.NoError(t, database.UpdateSubscriptionPeriod(ctx, "cus_alice", "active", start, end))
+
+	require.NoError(t, database.RecordUsage(ctx, alice.ID, []db.UsageEvent{
+		{OccurredAt: start.Add(-time.Hour), Messages: 40}, // previous period
+		{OccurredAt: now, Messages: 12},
+	}))
+	require.NoError(t, database.RecordLLMUsage(ctx, alice.ID, db.LLMUsage{
+		OccurredAt: now, Provider: "openai", Model: "gpt-4o", InputTokens: 900, OutputTokens: 100,
+	}))
+
+	path := "/api/customers/" + alice.ID + "/billing/usage"
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", path, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", path, "bob-token").Code)
+	assert.Equal(t, http.StatusOK, doAuthed(router, "GET", path, testAdminKey).Code)
+
+	w := doAuthed(router, "GET", path, "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		CustomerID string             `json:"customer_id"`
+		Billing    stripe.PeriodUsage `json:"billing"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, alice.ID, resp.CustomerID)
+	assert.True(t, start.Equal(resp.Billing.PeriodStart))
+	assert.True(t, end.Equal(resp.Billing.PeriodEnd))
+	assert.Equal(t, []stripe.MetricUsage{
+		{Metric: stripe.MetricMessages, Value: 12, Quota: 2000},
+		{Metric: stripe.MetricLLMTokens, Value: 1000, Metered: true},
+	}, resp.Billing.Metrics)
+}
+
+func TestChangePlan(t *testing.T) {
+	var updates []url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"id":"sub_alice","object":"subscription","items":{"object":"list","data":[{"id":"si_flat","price":{"id":"price-test"}}]}}`))
+			return
+		}
+		_ = r.ParseForm()
+		updates = append(updates, r.PostForm)
+		w.Write([]byte(`{"id":"sub_alice","object":"subscription"}`))
+	})
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	router, _ := setupAuthTestServerWithDB(t, database)
+	ctx := t.Context()
+
+	require.NoError(t, database.SetPlanStripePrice(ctx, "business", "price_business"))
+
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	createAuthedCustomer(t, database, "bob@example.com", "bob-token")
+	path := "/api/customers/" + alice.ID + "/plan"
+
+	changePlan := func(token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	errorCode := func(w *httptest.ResponseRecorder) string {
+		var resp ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Error
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, changePlan("bob-token", `{"plan_id":"pro"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, changePlan("alice-token", `{}`).Code)
+
+	w := changePlan("alice-token", `{"plan_id":"pro"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "no_subscription", errorCode(w))
+
+	require.NoError(t, database.UpdateStripeInfo(ctx, alice.ID, "cus_alice", "sub_alice"))
+
+	w = changePlan("alice-token", `{"plan_id":"enterprise"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "invalid_plan", errorCode(w))
+
+	w = changePlan("alice-token", `{"plan_id":"starter"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "plan_unchanged", errorCode(w))
+
+	// Upgrades are sent to Stripe and applied when the webhook confirms them
+	w = changePlan("alice-token", `{"plan_id":"business"}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Len(t, updates, 1)
+	assert.Equal(t, "price_business", updates[0].Get("items[0][price]"))
+	assert.Equal(t, "business", updates[0].Get("metadata[plan_id]"))
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "starter", customer.PlanID)
+
+	// Downgrades are refused while usage is over the lower plan's quotas
+	require.NoError(t, database.SetCustomerPlan(ctx, alice.ID, "business"))
+	require.NoError(t, database.RecordLLMUsage(ctx, alice.ID, db.LLMUsage{
+		OccurredAt: time.Now(), Provider: "openai", Model: "gpt-4o", InputTokens: 1000, CostMicros: 60_000_000,
+	}))
+	w = changePlan("alice-token", `{"plan_id":"pro"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "plan_quota_exceeded", errorCode(w))
+
+	require.NoError(t, database.RecordUsage(ctx, alice.ID, []db.UsageEvent{{OccurredAt: time.Now(), Messages: 2500}}))
+	w = changePlan(testAdminKey, `{"plan_id":"starter"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "2500 messages")
+	assert.Len(t, updates, 1)
+}
+
+func TestBillingEndpoints(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	billing := &fakeBilling{
+		invoices:     []stripe.Invoice{{ID: "in_1", Status: "paid", AmountPaid: 2900, Currency: "usd"}},
+		subscription: &stripe.Subscription{ID: "sub_alice", Status: "active", PlanID: "starter"},
+	}
+	router, _ := setupAuthTestServerWithDB(t, database, WithBilling(billing))
+	ctx := t.Context()
+
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+
+	// Admins act through the customer-scoped endpoints; these need the customer's own token
+	for _, token := range []string{"", testAdminKey} {
+		assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "POST", "/api/billing/portal", token).Code)
+		assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/api/billing/invoices", token).Code)
+		assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/api/billing/subscription", token).Code)
+	}
+
+	// Before checkout there is nothing to show
+	assert.Equal(t, http.StatusConflict, doAuthed(router, "POST", "/api/billing/portal", "alice-token").Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/billing/subscription", "alice-token").Code)
+	w := doAuthed(router, "GET", "/api/billing/invoices", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"invoices":[],"count":0}`, w.Body.String())
+
+	require.NoError(t, database.UpdateStripeInfo(ctx, alice.ID, "cus_alice", "sub_alice"))
+
+	w = doAuthed(router, "POST", "/api/billing/portal", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"url":"https://billing.stripe.com/p/session/test"}`, w.Body.String())
+	require.Len(t, billing.portalCalls, 1)
+	assert.True(t, strings.HasPrefix(billing.portalCalls[0], "cus_alice https://"))
+	assert.True(t, strings.HasSuffix(billing.portalCalls[0], "/dashboard"))
+
+	w = doAuthed(router, "GET", "/api/billing/invoices", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var invoices struct {
+		Invoices []stripe.Invoice `json:"invoices"`
+		Count    int              `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &invoices))
+	assert.Equal(t, 1, invoices.Count)
+	assert.Equal(t, "in_1", invoices.Invoices[0].ID)
+	assert.Equal(t, 12, billing.invoiceLimit)
+
+	assert.Equal(t, http.StatusOK, doAuthed(router, "GET", "/api/billing/invoices?limit=3", "alice-token").Code)
+	assert.Equal(t, 3, billing.invoiceLimit)
+	assert.Equal(t, http.StatusBadRequest, doAuthed(router, "GET", "/api/billing/invoices?limit=0", "alice-token").Code)
+	assert.Equal(t, http.StatusBadRequest, doAuthed(router, "GET", "/api/billing/invoices?limit=101", "alice-token").Code)
+
+	w = doAuthed(router, "GET", "/api/billing/subscription", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var sub struct {
+		CustomerID   string              `json:"customer_id"`
+		PlanID       string              `json:"plan_id"`
+		Subscription stripe.Subscription `json:"subscription"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sub))
+	assert.Equal(t, alice.ID, sub.CustomerID)
+	assert.Equal(t, "starter", sub.PlanID)
+	assert.Equal(t, "active", sub.Subscription.Status)
+
+	// Stripe failures surface as a gateway error
+	billing.err = errors.New("stripe down")
+	assert.Equal(t, http.StatusBadGateway, doAuthed(router, "POST", "/api/billing/portal", "alice-token").Code)
+	assert.Equal(t, http.StatusBadGateway, doAuthed(router, "GET", "/api/billing/invoices", "alice-token").Code)
+	assert.Equal(t, http.StatusBadGateway, doAuthed(router, "GET", "/api/billing/subscription", "alice-token").Code)
+}
+
+func TestResumeSubscription(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	billing := &fakeBilling{subscription: &stripe.Subscription{ID: "sub_alice", Status: "active"}}
+	router, _ := setupAuthTestServerWithDB(t, database, WithBilling(billing))
+	ctx := t.Context()
+
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	path := "/api/billing/subscription/resume"
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "POST", path, testAdminKey).Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "POST", path, "alice-token").Code)
+
+	require.NoError(t, database.UpdateStripeInfo(ctx, alice.ID, "cus_alice", "sub_alice"))
+	assert.Equal(t, http.StatusConflict, doAuthed(router, "POST", path, "alice-token").Code)
+
+	// A trial that ended without a card cannot resume until one is added
+	billing.subscription.Status = "paused"
+	assert.Equal(t, http.StatusPaymentRequired, doAuthed(router, "POST", path, "alice-token").Code)
+	assert.Empty(t, billing.resumed)
+
+	billing.subscription.PaymentMethod = &stripe.PaymentMethod{Type: "card", Last4: "4242"}
+	w := doAuthed(router, "POST", path, "alice-token")
+	require.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"customer_id":"`+alice.ID+`","status":"pending"}`, w.Body.String())
+	assert.Equal(t, []string{"sub_alice"}, billing.resumed)
+
+	billing.err = errors.New("stripe down")
+	assert.Equal(t, http.StatusBadGateway, doAuthed(router, "POST", path, "alice-token").Code)
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/domains"
+)
+
+// DomainHandler lets customers serve their agent from a domain of their own
+type DomainHandler struct {
+	db      *db.DB
+	domains *domains.Service
+	logger  *zap.Logger
+}
+
+// NewDomainHandler creates a new custom domain handler
+func NewDomainHandler(database *db.DB, svc *domains.Service, logger *zap.Logger) *DomainHandler {
+	return &DomainHandler{
+		db:      database,
+		domains: svc,
+		logger:  logger,
+	}
+}
+
+// ClaimDomainRequest is the body of POST /api/customers/:id/domain
+type ClaimDomainRequest struct {
+	Domain string `json:"domain" binding:"required"`
+}
+
+// DomainResponse is a custom domain with the DNS records that verify it
+type DomainResponse struct {
+	*db.CustomDomain
+	// Records lists the DNS records that verify the domain; either one is enough
+	Records []domains.Record `json:"records"`
+}
+
+// GetDomain returns the customer's custom domain and how to verify it
+func (h *DomainHandler) GetDomain(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	domain, err := h.db.GetCustomDomain(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "No custom domain",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.response(domain))
+}
+
+// ClaimDomain sets the customer's custom domain, replacing the one they had.
+// The domain is served once its DNS records are verified.
+func (h *DomainHandler) ClaimDomain(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	var req ClaimDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	id := c.Param("id")
+	domain, err := h.domains.Claim(c.Request.Context(), id, req.Domain)
+	if err != nil {
+		switch {
+		case errors.Is(err, domains.ErrInvalidDomain):
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_failed",
+				Message: err.Error(),
+			})
+		case errors.Is(err, domains.ErrDomainTaken):
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "domain_taken",
+				Message: "This domain is already in use",
+			})
+		default:
+			h.logger.Error("Failed to claim custom domain", zap.String("customer_id", id), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "internal_error",
+				Message: "Failed to set custom domain",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, h.response(domain))
+}
+
+// VerifyDomain checks the DNS records of the customer's domain now instead
+// of waiting for the next background check
+func (h *DomainHandler) VerifyDomain(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if _, err := h.db.GetCustomDomain(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "No custom domain",
+		})
+		return
+	}
+
+	domain, err := h.domains.Verify(ctx, id)
+	if err != nil {
+		if errors.Is(err, domains.ErrNotVerified) {
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "verification_failed",
+				Message: err.Error(),
+			})
+			return
+		}
+		h.logger.Error("Failed to verify custom domain", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to verify custom domain",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.response(domain))
+}
+
+// RemoveDomain stops serving the customer's custom domain and releases it
+func (h *DomainHandler) RemoveDomain(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if _, err := h.db.GetCustomDomain(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "No custom domain",
+		})
+		return
+	}
+
+	if err := h.domains.Remove(ctx, id); err != nil {
+		h.logger.Error("Failed to remove custom domain", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to remove custom domain",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "removed"})
+}
+
+// AllowDomain answers Caddy's on-demand TLS ask: 200 lets it obtain a
+// certificate for the domain, anything else refuses
+func (h *DomainHandler) AllowDomain(c *gin.Context) {
+	if h.domains == nil || !h.domains.Allowed(c.Request.Context(), c.Query("domain")) {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (h *DomainHandler) response(domain *db.CustomDomain) DomainResponse {
+	return DomainResponse{CustomDomain: domain, Records: h.domains.Records(domain)}
+}
+
+// enabled answers 503 unless the server routes custom domains through Caddy
+func (h *DomainHandler) enabled(c *gin.Context) bool {
+	if h.domains == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "domains_disabled",
+			Message: "Custom domains are not available on this server",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/domains"
+	"blytz/internal/provisioner"
+	"blytz/internal/stripe"
+)
+
+// stubDNS answers CNAME lookups from a map and has no TXT records
+type stubDNS map[string]string
+
+func (s stubDNS) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (s stubDNS) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if cname, ok := s[host]; ok {
+		return cname, nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// nopDomainRouter accepts every routing change
+type nopDomainRouter struct{}
+
+func (nopDomainRouter) RouteDomain(ctx context.Context, customerID, domain string) error { return nil }
+func (nopDomainRouter) UnrouteDomain(ctx context.Context, customerID string) error       { return nil }
+
+func setupDomainsTest(t *testing.T) (*gin.Engine, *db.DB, stubDNS) {
+	gin.SetMode(gin.TestMode)
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	cfg := &config.Config{
+		MaxCustomers:   20,
+		PortRangeStart: 30000,
+		PortRangeEnd:   30999,
+		CustomersDir:   t.TempDir(),
+		AdminAPIKey:    testAdminKey,
+	}
+	logger := zap.NewNop()
+	prov := provisioner.NewService(database, "", cfg.CustomersDir, "", cfg.PortRangeStart, cfg.PortRangeEnd, nil, "blytz.cloud", logger)
+	stripeSvc := stripe.NewService("sk-test", "price-test")
+
+	dns := stubDNS{}
+	svc := domains.NewService(database, dns, nopDomainRouter{}, "blytz.cloud", logger)
+	router := NewRouter(database, prov, stripeSvc, stripe.NewWebhookHandler(database, prov, "whsec-test"), cfg, logger,
+		WithDomains(svc))
+	return router, database, dns
+}
+
+func TestCustomDomainEndpoints(t *testing.T) {
+	router, database, dns := setupDomainsTest(t)
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	bob := createAuthedCustomer(t, database, "bob@example.com", "bob-token")
+	path := "/api/customers/" + alice.ID + "/domain"
+
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", path, "alice-token").Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", path, "bob-token").Code)
+
+	w := postJSON(router, path, "alice-token", ClaimDomainRequest{Domain: "alice.blytz.cloud"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON(router, path, "alice-token", ClaimDomainRequest{Domain: "Assistant.Alice.Example"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var claimed DomainResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &claimed))
+	assert.Equal(t, "assistant.alice.example", claimed.Domain)
+	assert.Equal(t, db.DomainPending, claimed.Status)
+	require.Len(t, claimed.Records, 2)
+	assert.Equal(t, domains.Record{Type: "CNAME", Name: "assistant.alice.example", Value: alice.ID + ".blytz.cloud"}, claimed.Records[0])
+
+	// A pending claim does not keep others from claiming the domain
+	bobPath := "/api/customers/" + bob.ID + "/domain"
+	w = postJSON(router, bobPath, "bob-token", ClaimDomainRequest{Domain: "assistant.alice.example"})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Caddy may not obtain a certificate until the domain is verified
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/domains/allowed?domain=assistant.alice.example", "").Code)
+
+	w = doAuthed(router, "POST", path+"/verify", "alice-token")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "verification_failed")
+
+	dns["assistant.alice.example"] = alice.ID + ".blytz.cloud."
+	w = doAuthed(router, "POST", path+"/verify", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"status":"verified"`)
+	assert.Equal(t, http.StatusOK, doAuthed(router, "GET", "/api/domains/allowed?domain=assistant.alice.example", "").Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/domains/allowed?domain=other.example", "").Code)
+
+	// Once verified it does
+	w = doAuthed(router, "POST", bobPath+"/verify", "bob-token")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "already in use")
+	w = postJSON(router, bobPath, "bob-token", ClaimDomainRequest{Domain: "assistant.alice.example"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "domain_taken")
+
+	w = doAuthed(router, "GET", path, testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "assistant.alice.example")
+
+	assert.Equal(t, http.StatusOK, doAuthed(router, "DELETE", path, "alice-token").Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "DELETE", path, "alice-token").Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "POST", path+"/verify", "alice-token").Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/domains/allowed?domain=assistant.alice.example", "").Code)
+}
+
+func TestCustomDomainsDisabled(t *testing.T) {
+	router, database := setupNodesTest(t, "")
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+
+	w := postJSON(router, "/api/customers/"+alice.ID+"/domain", "alice-token", ClaimDomainRequest{Domain: "assistant.alice.example"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/domains/allowed?domain=assistant.alice.example", "").Code)
+}
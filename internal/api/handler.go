@@ -2,23 +2,32 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 
 	"blytz/internal/config"
 	"blytz/internal/db"
+	"blytz/internal/metrics"
 	"blytz/internal/provisioner"
 	"blytz/internal/stripe"
+	"blytz/internal/supervisor"
+	"blytz/internal/waitlist"
 )
 
 type Handler struct {
 	db          *db.DB
 	provisioner provisioner.Provisioner
 	stripe      *stripe.Service
+	metrics     *metrics.Collector
+	supervisor  *supervisor.Supervisor
+	waitlist    *waitlist.Service
 	cfg         *config.Config
 	logger      *zap.Logger
 }
@@ -33,32 +42,23 @@ func NewHandler(database *db.DB, prov provisioner.Provisioner, stripeSvc *stripe
 	}
 }
 
+// HealthCheck is the original lightweight health endpoint, kept for existing
+// monitors. Orchestrators should use /livez and /readyz instead.
 func (h *Handler) HealthCheck(c *gin.Context) {
-	ctx := c.Request.Context()
-
-	// Check database connectivity
-	dbHealthy := h.checkDatabase(ctx)
-
-	// Check Docker availability (if provisioner is configured)
-	dockerHealthy := h.checkDocker(ctx)
-
-	allHealthy := dbHealthy && dockerHealthy
+	dbHealthy := h.checkDatabase(c.Request.Context())
 
 	status := http.StatusOK
-	if !allHealthy {
+	if !dbHealthy {
 		status = http.StatusServiceUnavailable
 	}
 
 	c.JSON(status, gin.H{
-		"status":  map[bool]string{true: "healthy", false: "unhealthy"}[allHealthy],
+		"status":  map[bool]string{true: "healthy", false: "unhealthy"}[dbHealthy],
 		"version": "1.0.0",
 		"checks": gin.H{
 			"database": map[string]interface{}{
 				"status": map[bool]string{true: "pass", false: "fail"}[dbHealthy],
 			},
-			"docker": map[string]interface{}{
-				"status": map[bool]string{true: "pass", false: "fail"}[dockerHealthy],
-			},
 		},
 		"timestamp": time.Now().UTC(),
 	})
@@ -73,12 +73,6 @@ func (h *Handler) checkDatabase(ctx context.Context) bool {
 	return err == nil
 }
 
-func (h *Handler) checkDocker(ctx context.Context) bool {
-	// Since we don't have a direct Docker check method, we assume it's healthy
-	// In production, you might want to check if Docker daemon is reachable
-	return true
-}
-
 // SystemStatus returns detailed system metrics for monitoring
 func (h *Handler) SystemStatus(c *gin.Context) {
 	ctx := c.Request.Context()
@@ -111,24 +105,127 @@ func (h *Handler) SystemStatus(c *gin.Context) {
 			"usage_percentage": fmt.Sprintf("%.1f%%", capacityPercentage),
 			"available_slots":  h.cfg.MaxCustomers - activeCount,
 		},
-		"resources": gin.H{
-			"message":                "For detailed resource metrics, check your server monitoring (htop, docker stats)",
-			"estimated_memory_usage": fmt.Sprintf("~%dMB", activeCount*512),
-			"estimated_cpu_usage":    fmt.Sprintf("~%.1f cores", float64(activeCount)*0.25),
-		},
+		"resources": h.resourceStatus(isAdminToken(h.cfg, bearerToken(c))),
 		"timestamp": time.Now().UTC(),
 	})
 }
 
+// resourceStatus reports host and container usage from the latest metrics sample.
+// The per-tenant breakdown identifies customers, so it is only included for admins.
+func (h *Handler) resourceStatus(includeTenants bool) gin.H {
+	var sample *metrics.Sample
+	if h.metrics != nil {
+		sample = h.metrics.Latest()
+	}
+	if sample == nil {
+		return gin.H{
+			"message": "No metrics have been collected yet",
+		}
+	}
+
+	resources := gin.H{
+		"sampled_at": sample.Timestamp.UTC(),
+		"host":       sample.Host,
+		"containers": sample.Totals(),
+	}
+
+	if includeTenants {
+		tenants := make([]metrics.ContainerStats, 0, len(sample.Containers))
+		for _, stats := range sample.Containers {
+			tenants = append(tenants, stats)
+		}
+		sort.Slice(tenants, func(i, j int) bool {
+			return tenants[i].CustomerID < tenants[j].CustomerID
+		})
+		resources["tenants"] = tenants
+	}
+
+	return resources
+}
+
 func (h *Handler) getTotalCustomers(ctx context.Context) (int, error) {
-	// This is a simple count - you might want to add a dedicated method to db package
-	// For now, we'll estimate based on active customers
-	active, err := h.db.CountActiveCustomers(ctx)
+	counts, err := h.db.CountCustomersByStatus(ctx)
 	if err != nil {
 		return 0, err
 	}
-	// Rough estimate: 20% more than active (some cancelled/suspended)
-	return int(float64(active) * 1.2), nil
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	return total, nil
+}
+
+// CustomerMetrics returns the latest and recent resource samples for a customer's container
+func (h *Handler) CustomerMetrics(c *gin.Context) {
+	id := c.Param("id")
+
+	if h.metrics == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "metrics_unavailable",
+			Message: "Metrics collection is not enabled",
+		})
+		return
+	}
+
+	history := h.metrics.CustomerHistory(id)
+
+	var latest *metrics.ContainerStats
+	if len(history) > 0 {
+		latest = &history[len(history)-1]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": id,
+		"latest":      latest,
+		"history":     history,
+	})
+}
+
+// CustomerHealth returns the tenant's uptime, recent probes and supervisor actions
+func (h *Handler) CustomerHealth(c *gin.Context) {
+	id := c.Param("id")
+
+	if h.supervisor == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "health_unavailable",
+			Message: "Tenant health supervision is not enabled",
+		})
+		return
+	}
+
+	report, err := h.supervisor.Report(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to build health report", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ListNotifications returns the customer's most recent notifications, such as
+// trial reminders
+func (h *Handler) ListNotifications(c *gin.Context) {
+	id := c.Param("id")
+
+	notifications, err := h.db.ListNotifications(c.Request.Context(), id, 50)
+	if err != nil {
+		h.logger.Error("Failed to list notifications", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to list notifications",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id":   id,
+		"notifications": notifications,
+	})
 }
 
 func (h *Handler) CreateCustomer(c *gin.Context) {
@@ -157,27 +254,27 @@ func (h *Handler) CreateCustomer(c *gin.Context) {
 
 	ctx := c.Request.Context()
 
-	count, err := h.db.CountActiveCustomers(ctx)
+	existing, err := h.db.GetCustomerByEmail(ctx, req.Email)
 	if err != nil {
-		h.logger.Error("Failed to count customers", zap.Error(err))
+		h.logger.Error("Failed to check existing customer", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error:   "internal_error",
-			Message: "Failed to check capacity",
+			Message: "Failed to check existing customer",
 		})
 		return
 	}
 
-	if count >= h.cfg.MaxCustomers {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Error:   "at_capacity",
-			Message: "Platform is at maximum capacity. Please try again later.",
+	if existing != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "already_exists",
+			Message: "An account with this email already exists",
 		})
 		return
 	}
 
-	existing, err := h.db.GetCustomerByEmail(ctx, req.Email)
+	waitlisted, err := h.db.IsWaitlisted(ctx, req.Email)
 	if err != nil {
-		h.logger.Error("Failed to check existing customer", zap.Error(err))
+		h.logger.Error("Failed to check waitlist", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error:   "internal_error",
 			Message: "Failed to check existing customer",
@@ -185,22 +282,113 @@ func (h *Handler) CreateCustomer(c *gin.Context) {
 		return
 	}
 
-	if existing != nil {
+	if waitlisted {
 		c.JSON(http.StatusConflict, ErrorResponse{
-			Error:   "already_exists",
-			Message: "An account with this email already exists",
+			Error:   "already_waitlisted",
+			Message: "This email is already on the waitlist",
 		})
 		return
 	}
 
+	signup, ok := h.validateSignup(c, req)
+	if !ok {
+		return
+	}
+
+	admit, err := h.waitlist.Admit(ctx)
+	if err != nil {
+		h.logger.Error("Failed to check capacity", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to check capacity",
+		})
+		return
+	}
+
+	if !admit {
+		h.joinWaitlist(c, req)
+		return
+	}
+
+	h.completeSignup(c, req, signup)
+}
+
+// validatedSignup is what validateSignup resolves from a signup request
+type validatedSignup struct {
+	plan        *db.Plan
+	checkout    stripe.CheckoutOptions
+	botUsername string
+}
+
+// validateSignup checks the requested plan, promo code and bot token,
+// writing the error response and returning false if any is invalid
+func (h *Handler) validateSignup(c *gin.Context, req *CreateCustomerRequest) (*validatedSignup, bool) {
+	plan, err := h.db.GetPlan(c.Request.Context(), planID(req))
+	if err != nil || !plan.IsActive {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_plan",
+			Message: "Unknown plan",
+		})
+		return nil, false
+	}
+
+	agentTypeID := req.AgentTypeID
+	if agentTypeID == "" {
+		agentTypeID = "openclaw"
+	}
+	if !plan.AllowsAgent(agentTypeID) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "plan_agent_not_allowed",
+			Message: fmt.Sprintf("The %s plan does not include the %s agent", plan.Name, agentTypeID),
+		})
+		return nil, false
+	}
+
+	signup := &validatedSignup{
+		plan: plan,
+		checkout: stripe.CheckoutOptions{
+			PriceID:   plan.StripePriceID,
+			TrialDays: plan.TrialDays,
+		},
+	}
+	if req.PromoCode != "" {
+		signup.checkout.PromotionCodeID, err = h.stripe.ResolvePromotionCode(req.PromoCode)
+		if errors.Is(err, stripe.ErrInvalidPromotionCode) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_promo_code",
+				Message: "Unknown or expired promo code",
+			})
+			return nil, false
+		}
+		if err != nil {
+			h.logger.Error("Failed to resolve promo code", zap.Error(err))
+			c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "billing_unavailable",
+				Message: "Failed to check the promo code",
+			})
+			return nil, false
+		}
+	}
+
 	botInfo, err := h.provisioner.ValidateBotToken(req.TelegramBotToken)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error:   "invalid_bot_token",
 			Message: "Invalid Telegram bot token: " + err.Error(),
 		})
-		return
+		return nil, false
 	}
+	if botInfo != nil {
+		signup.botUsername = botInfo.Result.Username
+	}
+
+	return signup, true
+}
+
+// completeSignup creates the customer and their checkout session, returning
+// nil after writing an error response on failure
+func (h *Handler) completeSignup(c *gin.Context, req *CreateCustomerRequest, signup *validatedSignup) *db.Customer {
+	ctx := c.Request.Context()
 
 	dbReq := &db.CreateCustomerRequest{
 		Email:              req.Email,
@@ -210,6 +398,7 @@ func (h *Handler) CreateCustomer(c *gin.Context) {
 		AgentTypeID:        req.AgentTypeID,
 		LLMProviderID:      req.LLMProviderID,
 		LLMAPIKey:          req.LLMAPIKey,
+		PlanID:             signup.plan.ID,
 	}
 
 	customer, err := h.db.CreateCustomer(ctx, dbReq)
@@ -219,31 +408,150 @@ func (h *Handler) CreateCustomer(c *gin.Context) {
 			Error:   "internal_error",
 			Message: "Failed to create customer",
 		})
-		return
+		return nil
+	}
+
+	accessToken := uuid.New().String()
+	if err := h.db.SetCustomerAccessToken(ctx, customer.ID, accessToken); err != nil {
+		h.logger.Error("Failed to store access token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create customer",
+		})
+		return nil
 	}
 
-	if botInfo != nil && botInfo.Result.Username != "" {
-		h.db.UpdateCustomerTelegramUsername(ctx, customer.ID, botInfo.Result.Username)
+	if signup.botUsername != "" {
+		h.db.UpdateCustomerTelegramUsername(ctx, customer.ID, signup.botUsername)
 	}
 
-	checkoutURL, err := h.stripe.CreateCheckoutSession(customer.ID, customer.Email)
+	session, err := h.startCheckout(ctx, customer, signup.checkout)
 	if err != nil {
 		h.logger.Error("Failed to create checkout session", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error:   "internal_error",
 			Message: "Failed to create checkout session",
 		})
-		return
+		return nil
 	}
 
 	c.JSON(http.StatusCreated, CreateCustomerResponse{
 		CustomerID:  customer.ID,
 		Email:       customer.Email,
 		Status:      customer.Status,
-		CheckoutURL: checkoutURL,
+		CheckoutURL: session.URL,
+		AccessToken: accessToken,
 	})
+	return customer
 }
 
+// ResumeCheckout replaces a pending customer's checkout session with a fresh
+// one, for customers who closed or let the first one expire. The previous
+// session is expired so only one can be paid.
+func (h *Handler) ResumeCheckout(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	customer, err := h.db.GetCustomerByID(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	if customer.Status != "pending" {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "checkout_completed",
+			Message: "Checkout is already complete",
+		})
+		return
+	}
+
+	plan, err := h.db.GetPlan(ctx, customer.PlanID)
+	if err != nil {
+		h.logger.Error("Failed to get plan", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to create checkout session",
+		})
+		return
+	}
+
+	if customer.StripeCheckoutSessionID != nil && *customer.StripeCheckoutSessionID != "" {
+		err := h.stripe.ExpireCheckoutSession(*customer.StripeCheckoutSessionID)
+		if errors.Is(err, stripe.ErrCheckoutCompleted) {
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "checkout_completed",
+				Message: "Payment received; your assistant is being set up",
+			})
+			return
+		}
+		if err != nil {
+			h.logger.Error("Failed to expire checkout session", zap.String("customer_id", id), zap.Error(err))
+			c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "billing_unavailable",
+				Message: "Failed to create checkout session",
+			})
+			return
+		}
+	}
+
+	session, err := h.startCheckout(ctx, customer, stripe.CheckoutOptions{
+		PriceID:   plan.StripePriceID,
+		TrialDays: plan.TrialDays,
+	})
+	if err != nil {
+		h.logger.Error("Failed to create checkout session", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "billing_unavailable",
+			Message: "Failed to create checkout session",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id":  customer.ID,
+		"checkout_url": session.URL,
+	})
+}
+
+// startCheckout creates a checkout session and records it so abandoned
+// sessions can be expired
+func (h *Handler) startCheckout(ctx context.Context, customer *db.Customer, opts stripe.CheckoutOptions) (*stripe.CheckoutSession, error) {
+	session, err := h.stripe.CreateCheckoutSession(customer.ID, customer.Email, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.db.SetCheckoutSession(ctx, customer.ID, session.ID); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// CustomerStatusResponse is a customer's account and subscription status.
+// Secrets such as the Telegram bot token and custom instructions are left out.
+type CustomerStatusResponse struct {
+	ID                  string     `json:"id"`
+	Email               string     `json:"email"`
+	AssistantName       string     `json:"assistant_name"`
+	TelegramBotUsername *string    `json:"telegram_bot_username"`
+	Status              string     `json:"status"`
+	SubscriptionStatus  *string    `json:"subscription_status"`
+	CurrentPeriodEnd    *time.Time `json:"current_period_end"`
+	AgentTypeID         string     `json:"agent_type_id"`
+	LLMProviderID       string     `json:"llm_provider_id"`
+	PlanID              string     `json:"plan_id"`
+	CreatedAt           time.Time  `json:"created_at"`
+	PaidAt              *time.Time `json:"paid_at"`
+	SuspendedAt         *time.Time `json:"suspended_at"`
+	CancelledAt         *time.Time `json:"cancelled_at"`
+}
+
+// GetCustomerStatus returns the status of the authenticated customer's account
 func (h *Handler) GetCustomerStatus(c *gin.Context) {
 	id := c.Param("id")
 
@@ -256,7 +564,22 @@ func (h *Handler) GetCustomerStatus(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, customer)
+	c.JSON(http.StatusOK, CustomerStatusResponse{
+		ID:                  customer.ID,
+		Email:               customer.Email,
+		AssistantName:       customer.AssistantName,
+		TelegramBotUsername: customer.TelegramBotUsername,
+		Status:              customer.Status,
+		SubscriptionStatus:  customer.SubscriptionStatus,
+		CurrentPeriodEnd:    customer.CurrentPeriodEnd,
+		AgentTypeID:         customer.AgentTypeID,
+		LLMProviderID:       customer.LLMProviderID,
+		PlanID:              customer.PlanID,
+		CreatedAt:           customer.CreatedAt,
+		PaidAt:              customer.PaidAt,
+		SuspendedAt:         customer.SuspendedAt,
+		CancelledAt:         customer.CancelledAt,
+	})
 }
 
 type CreateCustomerRequest struct {
@@ -268,6 +591,16 @@ type CreateCustomerRequest struct {
 	AgentTypeID   string `json:"agent_type_id"`   // e.g., "openclaw", "myrai"
 	LLMProviderID string `json:"llm_provider_id"` // e.g., "openai", "anthropic"
 	LLMAPIKey     string `json:"llm_api_key"`     // The actual API key
+	PlanID        string `json:"plan_id"`         // e.g., "starter", "pro"; defaults to starter
+	PromoCode     string `json:"promo_code"`      // Stripe promotion code, e.g., "LAUNCH20"
+}
+
+// planID returns the plan a signup asked for, or the default plan
+func planID(req *CreateCustomerRequest) string {
+	if req.PlanID == "" {
+		return db.DefaultPlanID
+	}
+	return req.PlanID
 }
 
 type CreateCustomerResponse struct {
@@ -275,6 +608,8 @@ type CreateCustomerResponse struct {
 	Email       string `json:"email"`
 	Status      string `json:"status"`
 	CheckoutURL string `json:"checkout_url"`
+	// AccessToken authenticates the customer's own API calls; it is only returned once
+	AccessToken string `json:"access_token"`
 }
 
 type ErrorResponse struct {
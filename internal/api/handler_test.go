@@ -2,18 +2,25 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"blytz/internal/config"
 	"blytz/internal/db"
+	"blytz/internal/metrics"
 	"blytz/internal/provisioner"
 	"blytz/internal/stripe"
+	"blytz/internal/supervisor"
 )
 
 func setupTestServer(t *testing.T) (*gin.Engine, *db.DB) {
@@ -40,7 +47,7 @@ func setupTestServer(t *testing.T) (*gin.Engine, *db.DB) {
 		database,
 		"./internal/workspace/templates",
 		t.TempDir(),
-		"sk-test",
+		"",
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -132,36 +139,42 @@ func TestCreateCustomerValidation(t *testing.T) {
 }
 
 func TestGetCustomerStatus(t *testing.T) {
-	router, database := setupTestServer(t)
-
-	// Create a customer first
-	ctx := t.Context()
-	_, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
-		Email:              "test@example.com",
-		AssistantName:      "Test",
-		CustomInstructions: "Help me",
-		TelegramBotToken:   "123:abc",
-	})
-	if err != nil {
-		t.Fatalf("Failed to create customer: %v", err)
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "test@example.com", "test-token")
+	createAuthedCustomer(t, database, "other@example.com", "other-token")
+
+	for _, token := range []string{"", "other-token"} {
+		if w := doAuthed(router, "GET", "/api/status/"+customer.ID, token); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 with token %q, got %d", token, w.Code)
+		}
 	}
 
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/api/status/test-example-com", nil)
-	router.ServeHTTP(w, req)
-
+	w := doAuthed(router, "GET", "/api/status/"+customer.ID, "test-token")
 	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp["status"] != "pending" {
+		t.Errorf("Expected status pending, got %v", resp["status"])
+	}
+	for _, secret := range []string{"telegram_bot_token", "custom_instructions", "stripe_customer_id"} {
+		if _, ok := resp[secret]; ok {
+			t.Errorf("Expected %s to be left out of the status", secret)
+		}
+	}
+	if strings.Contains(w.Body.String(), "123:abc") {
+		t.Error("Expected the bot token not to appear in the status")
 	}
 }
 
 func TestGetCustomerStatusNotFound(t *testing.T) {
-	router, _ := setupTestServer(t)
-
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/api/status/nonexistent", nil)
-	router.ServeHTTP(w, req)
+	router, _ := setupAuthTestServer(t)
 
+	w := doAuthed(router, "GET", "/api/status/nonexistent", testAdminKey)
 	if w.Code != http.StatusNotFound {
 		t.Errorf("Expected status 404, got %d", w.Code)
 	}
@@ -234,7 +247,7 @@ func TestCreateCustomerAtCapacity(t *testing.T) {
 		database,
 		"./internal/workspace/templates",
 		t.TempDir(),
-		"sk-test",
+		"",
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -245,7 +258,7 @@ func TestCreateCustomerAtCapacity(t *testing.T) {
 	stripeSvc := stripe.NewService("sk-test", "price-test")
 	stripeWebhook := stripe.NewWebhookHandler(database, prov, "whsec-test")
 
-	router := NewRouter(database, prov, stripeSvc, stripeWebhook, cfg, logger)
+	router := NewRouter(database, acceptingBotProvisioner{prov}, stripeSvc, stripeWebhook, cfg, logger)
 
 	// Create first customer to reach capacity
 	ctx := t.Context()
@@ -272,17 +285,26 @@ func TestCreateCustomerAtCapacity(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	if w.Code != http.StatusServiceUnavailable {
-		t.Errorf("Expected status 503, got %d", w.Code)
+	// At capacity the signup joins the waitlist instead of being turned away
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected status 202, got %d", w.Code)
 	}
 
-	var response ErrorResponse
+	var response WaitlistResponse
 	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
 		t.Fatalf("Failed to parse response: %v", err)
 	}
 
-	if response.Error != "at_capacity" {
-		t.Errorf("Expected error 'at_capacity', got %q", response.Error)
+	if response.Status != db.WaitlistWaiting || response.Position != 1 {
+		t.Errorf("Expected waiting at position 1, got %q at %d", response.Status, response.Position)
+	}
+
+	count, err := database.CountActiveCustomers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count customers: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected no customer to be created, got %d", count)
 	}
 }
 
@@ -334,3 +356,311 @@ func TestCheckDatabaseWithError(t *testing.T) {
 		t.Error("Expected checkDatabase to return true with valid DB")
 	}
 }
+
+type fakeContainerSource struct {
+	stats map[string]metrics.ContainerStats
+}
+
+func (f *fakeContainerSource) ContainerStats(ctx context.Context, names []string) (map[string]metrics.ContainerStats, error) {
+	return f.stats, nil
+}
+
+func TestSystemStatusWithMetrics(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	source := &fakeContainerSource{}
+	collector := metrics.NewCollector(database, source, t.TempDir(), 10, nil)
+	router, _ := setupAuthTestServerWithDB(t, database, WithMetrics(collector))
+
+	customer := createAuthedCustomer(t, database, "metrics@example.com", "token")
+	require.NoError(t, database.UpdateCustomerStatus(t.Context(), customer.ID, "active"))
+	createAuthedCustomer(t, database, "other@example.com", "other-token")
+
+	// Before any sample the endpoint still answers
+	w := doAuthed(router, "GET", "/api/status/system", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	source.stats = map[string]metrics.ContainerStats{
+		metrics.ContainerName(customer.ID): {MemoryUsageBytes: 64 << 20, CPUPercent: 1.5, RestartCount: 2},
+	}
+	collector.Collect(t.Context()) // host stats are unavailable in the temp procfs
+
+	var response struct {
+		Capacity  map[string]interface{} `json:"capacity"`
+		Resources map[string]interface{} `json:"resources"`
+	}
+
+	w = doAuthed(router, "GET", "/api/status/system", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, float64(2), response.Capacity["total_customers"])
+	containers := response.Resources["containers"].(map[string]interface{})
+	assert.Equal(t, float64(1), containers["containers"])
+	assert.Equal(t, float64(64<<20), containers["memory_usage_bytes"])
+	assert.NotContains(t, response.Resources, "tenants", "tenant breakdown is admin-only")
+
+	w = doAuthed(router, "GET", "/api/status/system", testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	tenants := response.Resources["tenants"].([]interface{})
+	require.Len(t, tenants, 1)
+	assert.Equal(t, customer.ID, tenants[0].(map[string]interface{})["customer_id"])
+
+	w = doAuthed(router, "GET", "/api/customers/"+customer.ID+"/metrics", "token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var perCustomer struct {
+		Latest  *metrics.ContainerStats  `json:"latest"`
+		History []metrics.ContainerStats `json:"history"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &perCustomer))
+	require.NotNil(t, perCustomer.Latest)
+	assert.Equal(t, 2, perCustomer.Latest.RestartCount)
+	assert.Len(t, perCustomer.History, 1)
+
+	w = doAuthed(router, "GET", "/api/customers/"+customer.ID+"/metrics", "other-token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCustomerMetricsWithoutCollector(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "metrics@example.com", "token")
+
+	w := doAuthed(router, "GET", "/api/customers/"+customer.ID+"/metrics", "token")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestCustomerHealth(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	sup := supervisor.New(database, nil, supervisor.DefaultPolicy(), zap.NewNop())
+	router, _ := setupAuthTestServerWithDB(t, database, WithSupervisor(sup))
+	customer := createAuthedCustomer(t, database, "health@example.com", "token")
+
+	require.NoError(t, database.RecordHealthCheck(t.Context(), customer.ID, db.HealthCheck{CheckedAt: time.Now(), Healthy: true}))
+	require.NoError(t, database.RecordHealthEvent(t.Context(), customer.ID, "restart", "after 3 consecutive failed probes"))
+
+	w := doAuthed(router, "GET", "/api/customers/"+customer.ID+"/health", "token")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var report supervisor.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.Equal(t, "operational", report.Status)
+	require.NotNil(t, report.UptimePercent)
+	assert.Equal(t, 100.0, *report.UptimePercent)
+	require.Len(t, report.Incidents, 1)
+	assert.Equal(t, "restart", report.Incidents[0].Action)
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/api/customers/"+customer.ID+"/health", "").Code)
+}
+
+func TestListNotifications(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	router, _ := setupAuthTestServerWithDB(t, database)
+	customer := createAuthedCustomer(t, database, "notify@example.com", "token")
+	createAuthedCustomer(t, database, "other@example.com", "other-token")
+	require.NoError(t, database.CreateNotification(t.Context(), customer.ID, "trial_will_end", "Your free trial ends soon"))
+
+	path := "/api/customers/" + customer.ID + "/notifications"
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", path, "other-token").Code)
+
+	w := doAuthed(router, "GET", path, "token")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Notifications []db.Notification `json:"notifications"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Notifications, 1)
+	assert.Equal(t, "trial_will_end", resp.Notifications[0].Kind)
+}
+
+func TestCustomerHealthWithoutSupervisor(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "health@example.com", "token")
+
+	w := doAuthed(router, "GET", "/api/customers/"+customer.ID+"/health", "token")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	router, _ := setupTestServer(t)
+
+	w := doAuthed(router, "GET", "/api/health", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = doAuthed(router, "GET", "/metrics", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `blytz_http_requests_total{method="GET",route="/api/health",status="200"}`)
+}
+
+func TestMetricsEndpointToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/metrics", requireMetricsToken(&config.Config{MetricsToken: "scrape"}), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/metrics", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/metrics", "wrong").Code)
+	assert.Equal(t, http.StatusOK, doAuthed(router, "GET", "/metrics", "scrape").Code)
+}
+
+func TestCreateCustomerPlan(t *testing.T) {
+	router, database := setupTestServer(t)
+	require.NoError(t, database.SavePlan(t.Context(), &db.Plan{
+		ID:                "lite",
+		Name:              "Lite",
+		Memory:            "256M",
+		CPU:               "0.1",
+		AllowedAgentTypes: []string{"myrai"},
+		IsActive:          true,
+	}))
+	require.NoError(t, database.SavePlan(t.Context(), &db.Plan{ID: "legacy", Name: "Legacy", Memory: "256M", CPU: "0.1"}))
+
+	tests := []struct {
+		name      string
+		planID    string
+		agentType string
+		wantError string
+	}{
+		{name: "unknown plan", planID: "enterprise", wantError: "invalid_plan"},
+		{name: "retired plan", planID: "legacy", wantError: "invalid_plan"},
+		{name: "agent not on plan", planID: "lite", wantError: "plan_agent_not_allowed"},
+		{name: "agent not on plan explicitly", planID: "lite", agentType: "openclaw", wantError: "plan_agent_not_allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{
+				"email":               "plan@example.com",
+				"assistant_name":      "Test",
+				"custom_instructions": "Help me",
+				"telegram_bot_token":  "123:abc",
+				"plan_id":             tt.planID,
+				"agent_type_id":       tt.agentType,
+			})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/signup", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			var response ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, tt.wantError, response.Error)
+		})
+	}
+}
+
+func TestListPlans(t *testing.T) {
+	router, database := setupTestServer(t)
+	require.NoError(t, database.SetPlanStripePrice(t.Context(), "pro", "price_secret"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/plans", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "price_secret")
+
+	var resp struct {
+		Plans []db.Plan `json:"plans"`
+		Count int       `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Count)
+	assert.Equal(t, "starter", resp.Plans[0].ID)
+	assert.Equal(t, "1G", resp.Plans[1].Memory)
+}
+
+func TestCreateCustomerPromoCode(t *testing.T) {
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	})
+
+	router, database := setupTestServer(t)
+
+	body, _ := json.Marshal(map[string]string{
+		"email":               "promo@example.com",
+		"assistant_name":      "Test",
+		"custom_instructions": "Help me",
+		"telegram_bot_token":  "123:abc",
+		"promo_code":          "EXPIRED",
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/signup", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	var response ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "invalid_promo_code", response.Error)
+
+	// No account is created for a rejected signup
+	existing, err := database.GetCustomerByEmail(t.Context(), "promo@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+}
+
+func TestResumeCheckout(t *testing.T) {
+	var expired []string
+	created := 0
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/checkout/sessions/cs_paid":
+			w.Write([]byte(`{"id":"cs_paid","object":"checkout.session","status":"complete"}`))
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"id":"cs_old","object":"checkout.session","status":"open"}`))
+		case strings.HasSuffix(r.URL.Path, "/expire"):
+			expired = append(expired, r.URL.Path)
+			w.Write([]byte(`{"id":"cs_old","object":"checkout.session","status":"expired"}`))
+		default:
+			created++
+			w.Write([]byte(`{"id":"cs_new","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_new"}`))
+		}
+	})
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	router, _ := setupAuthTestServerWithDB(t, database)
+	ctx := t.Context()
+
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	createAuthedCustomer(t, database, "bob@example.com", "bob-token")
+	require.NoError(t, database.SetCheckoutSession(ctx, alice.ID, "cs_old"))
+	path := "/api/customers/" + alice.ID + "/checkout"
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "POST", path, "bob-token").Code)
+
+	w := doAuthed(router, "POST", path, "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"customer_id":"`+alice.ID+`","checkout_url":"https://checkout.stripe.com/c/pay/cs_new"}`, w.Body.String())
+	assert.Equal(t, []string{"/v1/checkout/sessions/cs_old/expire"}, expired)
+
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	require.NotNil(t, customer.StripeCheckoutSessionID)
+	assert.Equal(t, "cs_new", *customer.StripeCheckoutSessionID)
+
+	// A session paid before its webhook arrived is not replaced
+	require.NoError(t, database.SetCheckoutSession(ctx, alice.ID, "cs_paid"))
+	assert.Equal(t, http.StatusConflict, doAuthed(router, "POST", path, "alice-token").Code)
+	assert.Equal(t, 1, created)
+
+	require.NoError(t, database.UpdateStripeInfo(ctx, alice.ID, "cus_alice", "sub_alice"))
+	assert.Equal(t, http.StatusConflict, doAuthed(router, "POST", path, "alice-token").Code)
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/health"
+	"blytz/internal/workspace"
+)
+
+const (
+	healthCheckTimeout = 2 * time.Second
+	healthCacheTTL     = 5 * time.Second
+)
+
+// HealthHandler serves the liveness and readiness probes
+type HealthHandler struct {
+	readiness *health.Service
+}
+
+// NewHealthHandler creates a new health handler
+func NewHealthHandler(readiness *health.Service) *HealthHandler {
+	return &HealthHandler{readiness: readiness}
+}
+
+// Livez reports that the process is up and serving requests. It never touches
+// dependencies so a broken database or daemon does not get the process restarted.
+func (h *HealthHandler) Livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "alive",
+		"timestamp": time.Now().UTC(),
+	})
+}
+
+// Readyz runs the readiness checks and returns 503 if any of them fail
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	report := h.readiness.Check(c.Request.Context())
+
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// newReadiness registers the default dependency checks for the control plane
+func newReadiness(database *db.DB, cfg *config.Config) *health.Service {
+	svc := health.NewService(healthCheckTimeout, healthCacheTTL)
+
+	templates := make([]string, len(workspace.Templates))
+	for i, tmpl := range workspace.Templates {
+		templates[i] = tmpl.Name
+	}
+
+	svc.Register(
+		health.NewChecker("database", database.WriteProbe),
+		health.DockerChecker(),
+		health.DiskSpaceChecker(cfg.CustomersDir, uint64(cfg.DiskMinFreeMB)<<20),
+		health.FilesChecker("templates", cfg.TemplatesDir, templates...),
+		health.NewChecker("stripe", func(ctx context.Context) error {
+			return checkStripeConfig(cfg)
+		}),
+	)
+	if cfg.CaddyAdminURL != "" {
+		svc.Register(health.CaddyChecker(cfg.CaddyAdminURL))
+	}
+
+	return svc
+}
+
+// checkStripeConfig catches missing or swapped Stripe keys before the first checkout fails
+func checkStripeConfig(cfg *config.Config) error {
+	var problems []string
+	if !strings.HasPrefix(cfg.StripeSecretKey, "sk_") && !strings.HasPrefix(cfg.StripeSecretKey, "rk_") {
+		problems = append(problems, "STRIPE_SECRET_KEY must be a secret or restricted key")
+	}
+	if !strings.HasPrefix(cfg.StripeWebhookSecret, "whsec_") {
+		problems = append(problems, "STRIPE_WEBHOOK_SECRET must be a webhook signing secret")
+	}
+	if !strings.HasPrefix(cfg.StripePriceID, "price_") {
+		problems = append(problems, "STRIPE_PRICE_ID must be a price ID")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
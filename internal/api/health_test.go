@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/config"
+	"blytz/internal/health"
+)
+
+func TestLivez(t *testing.T) {
+	failing := health.NewService(time.Second, 0)
+	failing.Register(health.NewChecker("docker", func(ctx context.Context) error { return errors.New("down") }))
+	router, _ := setupAuthTestServer(t, WithHealth(failing))
+
+	// Liveness ignores dependencies entirely
+	w := doAuthed(router, "GET", "/livez", "")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "alive", response["status"])
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerErr  error
+		wantCode   int
+		wantStatus string
+	}{
+		{"all dependencies up", nil, http.StatusOK, "ready"},
+		{"docker down", errors.New("cannot connect to the Docker daemon"), http.StatusServiceUnavailable, "not_ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readiness := health.NewService(time.Second, 0)
+			readiness.Register(
+				health.NewChecker("database", func(ctx context.Context) error { return nil }),
+				health.NewChecker("docker", func(ctx context.Context) error { return tt.dockerErr }),
+			)
+			router, _ := setupAuthTestServer(t, WithHealth(readiness))
+
+			w := doAuthed(router, "GET", "/readyz", "")
+			require.Equal(t, tt.wantCode, w.Code)
+
+			var report health.Report
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+			assert.Equal(t, tt.wantStatus, report.Status)
+			require.Len(t, report.Checks, 2)
+			assert.Equal(t, "database", report.Checks[0].Name)
+			assert.Equal(t, "docker", report.Checks[1].Name)
+			if tt.dockerErr != nil {
+				assert.Equal(t, tt.dockerErr.Error(), report.Checks[1].Error)
+			}
+		})
+	}
+}
+
+func TestDefaultReadinessChecks(t *testing.T) {
+	router, _ := setupAuthTestServer(t)
+
+	w := doAuthed(router, "GET", "/readyz", "")
+
+	var report health.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+
+	results := make(map[string]health.Result)
+	for _, result := range report.Checks {
+		results[result.Name] = result
+	}
+	assert.ElementsMatch(t, []string{"database", "docker", "disk", "templates", "stripe"}, slices.Collect(maps.Keys(results)))
+	assert.Equal(t, "pass", results["database"].Status)
+	assert.Equal(t, "pass", results["disk"].Status)
+	// The test config has no templates directory or real Stripe keys
+	assert.Equal(t, "fail", results["templates"].Status)
+	assert.Equal(t, "fail", results["stripe"].Status)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestCheckStripeConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cfg:  config.Config{StripeSecretKey: "sk_live_x", StripeWebhookSecret: "whsec_x", StripePriceID: "price_x"},
+		},
+		{
+			name: "restricted key",
+			cfg:  config.Config{StripeSecretKey: "rk_live_x", StripeWebhookSecret: "whsec_x", StripePriceID: "price_x"},
+		},
+		{
+			name:    "publishable key instead of secret",
+			cfg:     config.Config{StripeSecretKey: "pk_live_x", StripeWebhookSecret: "whsec_x", StripePriceID: "price_x"},
+			wantErr: "STRIPE_SECRET_KEY",
+		},
+		{
+			name:    "missing webhook secret and price",
+			cfg:     config.Config{StripeSecretKey: "sk_test_x"},
+			wantErr: "STRIPE_WEBHOOK_SECRET must be a webhook signing secret; STRIPE_PRICE_ID",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkStripeConfig(&tt.cfg)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
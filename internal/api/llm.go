@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/llmproxy"
+)
+
+// LLMHandler serves the tenant LLM proxy and reports proxied spend
+type LLMHandler struct {
+	db     *db.DB
+	proxy  *llmproxy.Proxy
+	logger *zap.Logger
+}
+
+// NewLLMHandler creates a new LLM proxy handler
+func NewLLMHandler(database *db.DB, proxy *llmproxy.Proxy, logger *zap.Logger) *LLMHandler {
+	return &LLMHandler{
+		db:     database,
+		proxy:  proxy,
+		logger: logger,
+	}
+}
+
+// newLLMProxy forwards to the providers configured with platform keys, under
+// each tenant's plan spend cap and the platform rate limit
+func newLLMProxy(database *db.DB, cfg *config.Config, logger *zap.Logger) *llmproxy.Proxy {
+	upstreams := map[string]llmproxy.Upstream{
+		"openai":    {BaseURL: cfg.LLMOpenAIUpstream, APIKey: cfg.OpenAIAPIKey},
+		"anthropic": {BaseURL: cfg.LLMAnthropicUpstream, APIKey: cfg.AnthropicAPIKey},
+	}
+	return llmproxy.New(database, upstreams, planLimits(database, cfg, logger), logger)
+}
+
+// planLimits applies the spend cap of the customer's plan, or the platform cap
+// when the plan sets none
+func planLimits(database *db.DB, cfg *config.Config, logger *zap.Logger) llmproxy.LimitsFunc {
+	return func(customer *db.Customer) llmproxy.Limits {
+		limits := llmproxy.Limits{
+			MonthlySpendMicros: int64(cfg.LLMMonthlySpendCapUSD) * 1_000_000,
+			RequestsPerMinute:  int64(cfg.LLMRateLimitPerMinute),
+		}
+
+		plan, err := database.GetPlan(context.Background(), customer.PlanID)
+		if err != nil {
+			logger.Warn("Using platform LLM limits", zap.String("customer_id", customer.ID), zap.Error(err))
+			return limits
+		}
+		limits.MonthlySpendMicros = planSpendCapMicros(plan, cfg)
+		return limits
+	}
+}
+
+// planSpendCapMicros is the monthly LLM spend cap for tenants on the plan; 0 means no cap
+func planSpendCapMicros(plan *db.Plan, cfg *config.Config) int64 {
+	if plan.LLMSpendCapUSD != nil {
+		return int64(*plan.LLMSpendCapUSD) * 1_000_000
+	}
+	return int64(cfg.LLMMonthlySpendCapUSD) * 1_000_000
+}
+
+// Proxy forwards an OpenAI- or Anthropic-compatible request from a tenant's agent
+func (h *LLMHandler) Proxy(c *gin.Context) {
+	customer := authCustomer(c)
+	provider := c.Param("provider")
+
+	path := c.Param("path")
+
+	err := h.proxy.Admit(c.Request.Context(), customer, provider, path)
+	switch {
+	case err == nil:
+		h.proxy.Forward(flushWriter{c.Writer}, c.Request, customer, provider, path)
+	case errors.Is(err, llmproxy.ErrUnknownProvider):
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "unknown_provider",
+			Message: "LLM provider is not available through the proxy",
+		})
+	case errors.Is(err, llmproxy.ErrPathNotAllowed):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "endpoint_not_allowed",
+			Message: "Only chat completions, messages and embeddings are available through the proxy",
+		})
+	case errors.Is(err, llmproxy.ErrInactive):
+		c.JSON(http.StatusForbidden, ErrorResponse{
+			Error:   "inactive",
+			Message: "Subscription is not active",
+		})
+	case errors.Is(err, llmproxy.ErrRateLimited):
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{
+			Error:   "rate_limit_exceeded",
+			Message: "Too many LLM requests. Please try again later.",
+		})
+	case errors.Is(err, llmproxy.ErrSpendCapReached):
+		c.JSON(http.StatusPaymentRequired, ErrorResponse{
+			Error:   "spend_cap_reached",
+			Message: "Monthly LLM spend cap reached",
+		})
+	default:
+		h.logger.Error("Failed to admit LLM request", zap.String("customer_id", customer.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to check LLM limits",
+		})
+	}
+}
+
+// flushWriter hides gin's CloseNotify, which panics when the underlying writer
+// lacks it; the reverse proxy falls back to the request context instead
+type flushWriter struct {
+	http.ResponseWriter
+}
+
+func (w flushWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// GetLLMUsage returns the customer's proxied LLM spend this month against their cap
+func (h *LLMHandler) GetLLMUsage(c *gin.Context) {
+	id := c.Param("id")
+
+	customer, err := h.db.GetCustomerByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	status, err := h.proxy.Status(c.Request.Context(), customer)
+	if err != nil {
+		h.logger.Error("Failed to query LLM usage", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to retrieve LLM usage",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": id,
+		"llm":         status,
+	})
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/llmproxy"
+)
+
+func setupLLMProxyTest(t *testing.T, limits llmproxy.Limits) (*gin.Engine, *db.DB, *http.Header) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	var seen http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"model":"gpt-4o","usage":{"prompt_tokens":400000,"completion_tokens":0}}`)
+	}))
+	t.Cleanup(upstream.Close)
+
+	proxy := llmproxy.New(database, map[string]llmproxy.Upstream{
+		"openai":    {BaseURL: upstream.URL, APIKey: "sk-platform"},
+		"anthropic": {BaseURL: upstream.URL, APIKey: "sk-ant-platform"},
+	}, llmproxy.FixedLimits(limits), nil)
+
+	router, _ := setupAuthTestServerWithDB(t, database, WithLLMProxy(proxy))
+	return router, database, &seen
+}
+
+func doLLM(router *gin.Engine, path string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestLLMProxyAuth(t *testing.T) {
+	router, database, seen := setupLLMProxyTest(t, llmproxy.Limits{})
+	ctx := t.Context()
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+	require.NoError(t, database.SetLLMProxyToken(ctx, customer.ID, "blytz-llm-alice"))
+
+	path := "/llm/openai/v1/chat/completions"
+	assert.Equal(t, http.StatusUnauthorized, doLLM(router, path, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doLLM(router, path, map[string]string{"Authorization": "Bearer alice-token"}).Code)
+	assert.Equal(t, http.StatusUnauthorized, doLLM(router, path, map[string]string{"Authorization": "Bearer " + testAdminKey}).Code)
+
+	w := doLLM(router, path, map[string]string{"Authorization": "Bearer blytz-llm-alice"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Bearer sk-platform", seen.Get("Authorization"))
+
+	// Anthropic SDKs send the key as X-Api-Key
+	w = doLLM(router, "/llm/anthropic/v1/messages", map[string]string{"X-Api-Key": "blytz-llm-alice"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "sk-ant-platform", seen.Get("X-Api-Key"))
+
+	w = doLLM(router, "/llm/ollama/v1/chat", map[string]string{"Authorization": "Bearer blytz-llm-alice"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Unmetered endpoints would bypass the spend cap
+	*seen = nil
+	w = doLLM(router, "/llm/openai/v1/files", map[string]string{"Authorization": "Bearer blytz-llm-alice"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "endpoint_not_allowed")
+	assert.Nil(t, *seen, "not forwarded upstream")
+
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "suspended"))
+	w = doLLM(router, path, map[string]string{"Authorization": "Bearer blytz-llm-alice"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestLLMProxySpendCapAndUsage(t *testing.T) {
+	// Each stubbed response costs 400k gpt-4o input tokens: $1.00
+	router, database, _ := setupLLMProxyTest(t, llmproxy.Limits{MonthlySpendMicros: 2_000_000})
+	ctx := t.Context()
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+	require.NoError(t, database.SetLLMProxyToken(ctx, customer.ID, "blytz-llm-alice"))
+
+	auth := map[string]string{"Authorization": "Bearer blytz-llm-alice"}
+	path := "/llm/openai/v1/chat/completions"
+	require.Equal(t, http.StatusOK, doLLM(router, path, auth).Code)
+	require.Equal(t, http.StatusOK, doLLM(router, path, auth).Code)
+
+	w := doLLM(router, path, auth)
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Contains(t, w.Body.String(), "spend_cap_reached")
+
+	usagePath := "/api/customers/" + customer.ID + "/llm-usage"
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", usagePath, "blytz-llm-alice").Code)
+
+	w = doAuthed(router, "GET", usagePath, "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		LLM llmproxy.Status `json:"llm"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, int64(2_000_000), resp.LLM.SpendMicros)
+	assert.Equal(t, int64(2_000_000), resp.LLM.CapMicros)
+	assert.Equal(t, 1, resp.LLM.PeriodStart.Day())
+	assert.False(t, resp.LLM.PeriodStart.After(time.Now()))
+	require.Len(t, resp.LLM.Models, 1)
+	assert.Equal(t, 2, resp.LLM.Models[0].Requests)
+}
+
+func TestLLMProxyRateLimit(t *testing.T) {
+	router, database, _ := setupLLMProxyTest(t, llmproxy.Limits{RequestsPerMinute: 1})
+	ctx := t.Context()
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+	require.NoError(t, database.SetLLMProxyToken(ctx, customer.ID, "blytz-llm-alice"))
+
+	auth := map[string]string{"Authorization": "Bearer blytz-llm-alice"}
+	require.Equal(t, http.StatusOK, doLLM(router, "/llm/openai/v1/chat/completions", auth).Code)
+	assert.Equal(t, http.StatusTooManyRequests, doLLM(router, "/llm/openai/v1/chat/completions", auth).Code)
+}
+
+func TestPlanLimits(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	limits := planLimits(database, &config.Config{LLMMonthlySpendCapUSD: 20, LLMRateLimitPerMinute: 60}, zap.NewNop())
+
+	tests := []struct {
+		name       string
+		planID     string
+		wantMicros int64
+	}{
+		{name: "plan without cap uses platform cap", planID: "starter", wantMicros: 20_000_000},
+		{name: "plan cap", planID: "pro", wantMicros: 50_000_000},
+		{name: "unknown plan falls back", planID: "missing", wantMicros: 20_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limits(&db.Customer{ID: "c1", PlanID: tt.planID})
+			assert.Equal(t, tt.wantMicros, got.MonthlySpendMicros)
+			assert.Equal(t, int64(60), got.RequestsPerMinute)
+		})
+	}
+}
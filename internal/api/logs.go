@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/logs"
+	"blytz/internal/nodeagent"
+)
+
+const (
+	defaultLogTail = 200
+	maxLogTail     = 5000
+	// sseKeepAlive keeps idle follow streams from being closed by proxies
+	sseKeepAlive = 15 * time.Second
+)
+
+// nodeLogs reads node tenants' logs through their agents, when node agents are configured
+func nodeLogs(cfg *config.Config) logs.NodeDialer {
+	if cfg.NodeAgentToken == "" {
+		return nil
+	}
+	return func(node *db.Node) logs.NodeSource {
+		return nodeagent.NewClient(node.AgentURL, cfg.NodeAgentToken, cfg.CustomersDir)
+	}
+}
+
+// LogsHandler serves tenant container logs
+type LogsHandler struct {
+	db     *db.DB
+	logs   *logs.Service
+	logger *zap.Logger
+}
+
+// NewLogsHandler creates a new logs handler
+func NewLogsHandler(database *db.DB, svc *logs.Service, logger *zap.Logger) *LogsHandler {
+	return &LogsHandler{
+		db:     database,
+		logs:   svc,
+		logger: logger,
+	}
+}
+
+// GetLogs returns the customer's container logs. With follow=true the response
+// is a Server-Sent Events stream of "log" events that ends with "end" or "error".
+func (h *LogsHandler) GetLogs(c *gin.Context) {
+	id := c.Param("id")
+
+	opts, err := parseLogOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	customer, err := h.db.GetCustomerByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+	if customer.Status == "pending" || customer.Status == "cancelled" {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "no_container",
+			Message: "Customer has no running container",
+		})
+		return
+	}
+
+	if opts.Follow {
+		h.streamLogs(c, id, opts)
+		return
+	}
+
+	lines := []string{}
+	err = h.logs.Stream(c.Request.Context(), id, opts, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if errors.Is(err, logs.ErrNodeLogsUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "logs_unavailable",
+			Message: "Logs are unavailable for tenants on worker nodes",
+		})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read container logs", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "logs_unavailable",
+			Message: "Failed to read container logs",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": id,
+		"lines":       lines,
+	})
+}
+
+func (h *LogsHandler) streamLogs(c *gin.Context, id string, opts logs.Options) {
+	ctx := c.Request.Context()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.logs.Stream(ctx, id, opts, func(line string) error {
+			select {
+			case lines <- line:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case line := <-lines:
+			c.SSEvent("log", line)
+			c.Writer.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+		case err := <-done:
+			switch {
+			case err == nil:
+				c.SSEvent("end", "log stream closed")
+			case errors.Is(err, context.Canceled):
+				// Client disconnected; nobody is listening for a final event
+				return
+			default:
+				h.logger.Warn("Log stream failed", zap.String("customer_id", id), zap.Error(err))
+				c.SSEvent("error", "log stream failed")
+			}
+			c.Writer.Flush()
+			return
+		}
+	}
+}
+
+func parseLogOptions(c *gin.Context) (logs.Options, error) {
+	opts := logs.Options{Tail: defaultLogTail}
+
+	if raw := c.Query("tail"); raw != "" {
+		tail, err := strconv.Atoi(raw)
+		if err != nil || tail < 0 || tail > maxLogTail {
+			return opts, fmt.Errorf("tail must be a number between 0 and %d", maxLogTail)
+		}
+		opts.Tail = tail
+	}
+
+	if raw := c.Query("since"); raw != "" {
+		if _, err := time.ParseDuration(raw); err != nil {
+			if _, err := time.Parse(time.RFC3339, raw); err != nil {
+				return opts, fmt.Errorf("since must be a duration such as 15m or an RFC 3339 timestamp")
+			}
+		}
+		opts.Since = raw
+	}
+
+	if raw := c.Query("follow"); raw != "" {
+		follow, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("follow must be true or false")
+		}
+		opts.Follow = follow
+	}
+
+	return opts, nil
+}
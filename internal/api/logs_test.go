@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+	"blytz/internal/logs"
+)
+
+type fakeLogSource struct {
+	output string
+	err    error
+	opts   logs.Options
+}
+
+func (f *fakeLogSource) Logs(ctx context.Context, container string, opts logs.Options) (io.ReadCloser, error) {
+	f.opts = opts
+	if f.err != nil {
+		return nil, f.err
+	}
+	return io.NopCloser(strings.NewReader(f.output)), nil
+}
+
+func setupLogsTest(t *testing.T, source *fakeLogSource) (*gin.Engine, string) {
+	t.Helper()
+	dir := t.TempDir()
+	router, database := setupAuthTestServer(t, WithLogs(logs.NewService(source, dir)))
+
+	customer := createAuthedCustomer(t, database, "logs@example.com", "token")
+	require.NoError(t, database.UpdateCustomerStatus(t.Context(), customer.ID, "active"))
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, customer.ID), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, customer.ID, ".env.secret"), []byte("OPENAI_API_KEY=sk-live-secret\n"), 0600))
+
+	return router, customer.ID
+}
+
+func TestGetLogs(t *testing.T) {
+	source := &fakeLogSource{output: "2026-01-01T00:00:00Z boot\n2026-01-01T00:00:01Z key sk-live-secret loaded\n"}
+	router, id := setupLogsTest(t, source)
+
+	w := doAuthed(router, "GET", "/api/customers/"+id+"/logs?tail=50&since=10m", "token")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Lines []string `json:"lines"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{
+		"2026-01-01T00:00:00Z boot",
+		"2026-01-01T00:00:01Z key [REDACTED] loaded",
+	}, response.Lines)
+	assert.Equal(t, logs.Options{Tail: 50, Since: "10m"}, source.opts)
+	assert.NotContains(t, w.Body.String(), "sk-live-secret")
+}
+
+func TestGetLogsFollow(t *testing.T) {
+	source := &fakeLogSource{output: "first\nsecret sk-live-secret\n"}
+	router, id := setupLogsTest(t, source)
+
+	w := doAuthed(router, "GET", "/api/customers/"+id+"/logs?follow=true&tail=0", "token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/event-stream")
+	assert.True(t, source.opts.Follow)
+
+	body := w.Body.String()
+	assert.Contains(t, body, "event:log\ndata:first\n\n")
+	assert.Contains(t, body, "event:log\ndata:secret [REDACTED]\n\n")
+	assert.Contains(t, body, "event:end\n")
+	assert.NotContains(t, body, "sk-live-secret")
+}
+
+func TestGetLogsErrors(t *testing.T) {
+	source := &fakeLogSource{}
+	router, id := setupLogsTest(t, source)
+	path := "/api/customers/" + id + "/logs"
+
+	tests := []struct {
+		name       string
+		query      string
+		token      string
+		wantStatus int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"other token", "", "nope", http.StatusUnauthorized},
+		{"admin", "", testAdminKey, http.StatusOK},
+		{"negative tail", "?tail=-1", "token", http.StatusBadRequest},
+		{"huge tail", "?tail=100000", "token", http.StatusBadRequest},
+		{"bad since", "?since=yesterday", "token", http.StatusBadRequest},
+		{"rfc3339 since", "?since=2026-01-01T00:00:00Z", "token", http.StatusOK},
+		{"bad follow", "?follow=maybe", "token", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doAuthed(router, "GET", path+tt.query, tt.token)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+
+	source.err = errors.New("no such container")
+	assert.Equal(t, http.StatusBadGateway, doAuthed(router, "GET", path, "token").Code)
+}
+
+func TestGetLogsWithoutContainer(t *testing.T) {
+	router, database := setupAuthTestServer(t, WithLogs(logs.NewService(&fakeLogSource{}, t.TempDir())))
+	customer := createAuthedCustomer(t, database, "pending@example.com", "token")
+
+	w := doAuthed(router, "GET", "/api/customers/"+customer.ID+"/logs", "token")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestGetLogsNodeTenantWithoutAgents(t *testing.T) {
+	svc := logs.NewService(&fakeLogSource{}, t.TempDir())
+	router, database := setupAuthTestServer(t, WithLogs(svc))
+	svc.UseNodes(database, nil)
+	ctx := t.Context()
+
+	customer := createAuthedCustomer(t, database, "node@example.com", "token")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+	node := &db.Node{Name: "worker-1", Address: "10.0.0.2", AgentURL: "http://10.0.0.2:9100",
+		MemoryMB: 4096, CPUs: 2, PortStart: 30000, PortEnd: 30100}
+	require.NoError(t, database.CreateNode(ctx, node))
+	require.NoError(t, database.AssignNode(ctx, customer.ID, node.ID, 30000))
+
+	w := doAuthed(router, "GET", "/api/customers/"+customer.ID+"/logs", "token")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "worker nodes")
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -91,42 +93,109 @@ func (h *MarketplaceHandler) GetLLMProvider(c *gin.Context) {
 	c.JSON(http.StatusOK, provider)
 }
 
-// GetStacks returns popular agent + LLM combinations
+// stackUsageWindow is how far back GetStacks looks when ranking combinations
+const stackUsageWindow = 30 * 24 * time.Hour
+
+// Stack is a recommended agent type and LLM provider combination
+type Stack struct {
+	Name        string `json:"name"`
+	AgentID     string `json:"agent_id"`
+	LLMID       string `json:"llm_id"`
+	Description string `json:"description"`
+	Recommended bool   `json:"recommended"`
+	Tenants     int    `json:"tenants"`
+	Messages    int    `json:"messages"`
+}
+
+// curatedStacks are shown before there is usage to rank by, and keep their
+// descriptions once there is. The first entry is the default recommendation.
+var curatedStacks = []Stack{
+	{
+		Name:        "OpenClaw + Claude",
+		AgentID:     "openclaw",
+		LLMID:       "anthropic",
+		Description: "Best for multi-channel communication with excellent reasoning",
+	},
+	{
+		Name:        "OpenClaw + GPT-4",
+		AgentID:     "openclaw",
+		LLMID:       "openai",
+		Description: "Most popular combination, reliable for general use",
+	},
+	{
+		Name:        "Myrai + Groq",
+		AgentID:     "myrai",
+		LLMID:       "groq",
+		Description: "Fast and affordable, great for high-volume usage",
+	},
+	{
+		Name:        "Myrai + Local (Ollama)",
+		AgentID:     "myrai",
+		LLMID:       "ollama",
+		Description: "100% free, runs locally on your hardware",
+	},
+}
+
+// GetStacks returns agent and LLM combinations ranked by messages handled over
+// the last 30 days, then by running tenants. The busiest stack is recommended.
 func (h *MarketplaceHandler) GetStacks(c *gin.Context) {
-	// TODO: Implement based on actual usage analytics
-	// For now, return hardcoded popular combinations
-	stacks := []gin.H{
-		{
-			"name":        "OpenClaw + Claude",
-			"agent_id":    "openclaw",
-			"llm_id":      "anthropic",
-			"description": "Best for multi-channel communication with excellent reasoning",
-			"recommended": true,
-		},
-		{
-			"name":        "OpenClaw + GPT-4",
-			"agent_id":    "openclaw",
-			"llm_id":      "openai",
-			"description": "Most popular combination, reliable for general use",
-			"recommended": false,
-		},
-		{
-			"name":        "Myrai + Groq",
-			"agent_id":    "myrai",
-			"llm_id":      "groq",
-			"description": "Fast and affordable, great for high-volume usage",
-			"recommended": false,
-		},
-		{
-			"name":        "Myrai + Local (Ollama)",
-			"agent_id":    "myrai",
-			"llm_id":      "ollama",
-			"description": "100% free, runs locally on your hardware",
-			"recommended": false,
-		},
+	ctx := c.Request.Context()
+
+	stacks := make([]Stack, len(curatedStacks))
+	copy(stacks, curatedStacks)
+
+	usage, err := h.db.ListStackUsage(ctx, time.Now().Add(-stackUsageWindow))
+	if err != nil {
+		// Rankings are a nicety; fall back to the curated order
+		h.logger.Warn("Failed to load stack usage", zap.Error(err))
+	}
+
+	index := make(map[string]int, len(stacks))
+	for i, stack := range stacks {
+		index[stack.AgentID+"/"+stack.LLMID] = i
 	}
+	for _, u := range usage {
+		i, ok := index[u.AgentTypeID+"/"+u.LLMProviderID]
+		if !ok {
+			stacks = append(stacks, Stack{
+				Name:    h.stackName(c, u.AgentTypeID, u.LLMProviderID),
+				AgentID: u.AgentTypeID,
+				LLMID:   u.LLMProviderID,
+			})
+			i = len(stacks) - 1
+		}
+		stacks[i].Tenants = u.Tenants
+		stacks[i].Messages = u.Messages
+	}
+
+	sort.SliceStable(stacks, func(i, j int) bool {
+		if stacks[i].Messages != stacks[j].Messages {
+			return stacks[i].Messages > stacks[j].Messages
+		}
+		return stacks[i].Tenants > stacks[j].Tenants
+	})
+
+	rankedBy := "curated"
+	if stacks[0].Messages > 0 || stacks[0].Tenants > 0 {
+		rankedBy = "usage"
+	}
+	// Without usage the stable sort keeps the curated default first
+	stacks[0].Recommended = true
 
 	c.JSON(http.StatusOK, gin.H{
-		"stacks": stacks,
+		"stacks":    stacks,
+		"ranked_by": rankedBy,
 	})
 }
+
+// stackName names an uncurated combination after its agent type and LLM provider
+func (h *MarketplaceHandler) stackName(c *gin.Context, agentID, llmID string) string {
+	agentName, llmName := agentID, llmID
+	if agent, err := h.db.GetAgentType(c.Request.Context(), agentID); err == nil {
+		agentName = agent.Name
+	}
+	if provider, err := h.db.GetLLMProvider(c.Request.Context(), llmID); err == nil {
+		llmName = provider.Name
+	}
+	return agentName + " + " + llmName
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/nodeagent"
+	"blytz/internal/provisioner"
+	"blytz/internal/scheduler"
+)
+
+// Migrator moves tenants between worker nodes
+type Migrator interface {
+	Migrate(ctx context.Context, customerID, nodeID string) error
+	Drain(ctx context.Context, nodeID string) error
+}
+
+// NodeHandler manages the registry of worker nodes tenants are scheduled on
+type NodeHandler struct {
+	db        *db.DB
+	scheduler *scheduler.Scheduler
+	migrator  Migrator
+	cfg       *config.Config
+	logger    *zap.Logger
+}
+
+// NewNodeHandler creates a new node registry handler
+func NewNodeHandler(database *db.DB, sched *scheduler.Scheduler, cfg *config.Config, logger *zap.Logger) *NodeHandler {
+	return &NodeHandler{
+		db:        database,
+		scheduler: sched,
+		cfg:       cfg,
+		logger:    logger,
+	}
+}
+
+// RegisterNodeRequest is the body of POST /api/admin/nodes
+type RegisterNodeRequest struct {
+	Name      string            `json:"name" binding:"required"`
+	Address   string            `json:"address" binding:"required"`
+	AgentURL  string            `json:"agent_url" binding:"required,url"`
+	MemoryMB  int               `json:"memory_mb" binding:"required,min=1"`
+	CPUs      float64           `json:"cpus" binding:"required,gt=0"`
+	PortStart int               `json:"port_start" binding:"required,min=1,max=65535"`
+	PortEnd   int               `json:"port_end" binding:"required,gtefield=PortStart,max=65535"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// MigrateRequest is the body of POST /api/admin/customers/:id/migrate
+type MigrateRequest struct {
+	// NodeID is the target node; empty lets the scheduler choose
+	NodeID string `json:"node_id"`
+}
+
+// MigrateResponse is where a migrated tenant now runs
+type MigrateResponse struct {
+	CustomerID    string `json:"customer_id"`
+	NodeID        string `json:"node_id"`
+	ContainerPort int    `json:"container_port"`
+}
+
+// ListNodes returns every worker node with the resources its tenants reserve
+func (h *NodeHandler) ListNodes(c *gin.Context) {
+	usage, err := h.scheduler.Usage(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list nodes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to list nodes",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes": usage,
+		"count": len(usage),
+	})
+}
+
+// RegisterNode adds a worker node once its agent answers with the configured token
+func (h *NodeHandler) RegisterNode(c *gin.Context) {
+	if h.cfg.NodeAgentToken == "" {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "nodes_disabled",
+			Message: "Set NODE_AGENT_TOKEN to schedule tenants on worker nodes",
+		})
+		return
+	}
+
+	var req RegisterNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	nodes, err := h.db.ListNodes(ctx)
+	if err != nil {
+		h.logger.Error("Failed to list nodes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to register node",
+		})
+		return
+	}
+	for _, node := range nodes {
+		if node.Name == req.Name {
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "node_exists",
+				Message: "A node with this name is already registered",
+			})
+			return
+		}
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := nodeagent.NewClient(req.AgentURL, h.cfg.NodeAgentToken, h.cfg.CustomersDir).Ping(pingCtx); err != nil {
+		h.logger.Warn("Node agent unreachable", zap.String("agent_url", req.AgentURL), zap.Error(err))
+		c.JSON(http.StatusBadGateway, ErrorResponse{
+			Error:   "node_unreachable",
+			Message: "The node agent did not answer with the configured token",
+		})
+		return
+	}
+
+	node := &db.Node{
+		Name:      req.Name,
+		Address:   req.Address,
+		AgentURL:  req.AgentURL,
+		MemoryMB:  req.MemoryMB,
+		CPUs:      req.CPUs,
+		PortStart: req.PortStart,
+		PortEnd:   req.PortEnd,
+		Labels:    req.Labels,
+	}
+	if err := h.db.CreateNode(ctx, node); err != nil {
+		h.logger.Error("Failed to register node", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to register node",
+		})
+		return
+	}
+
+	h.logger.Info("Node registered", zap.String("node_id", node.ID), zap.String("name", node.Name))
+	c.JSON(http.StatusCreated, node)
+}
+
+// CordonNode stops scheduling new tenants on a node
+func (h *NodeHandler) CordonNode(c *gin.Context) {
+	h.setStatus(c, db.NodeCordoned)
+}
+
+// UncordonNode returns a cordoned node to service
+func (h *NodeHandler) UncordonNode(c *gin.Context) {
+	h.setStatus(c, db.NodeActive)
+}
+
+func (h *NodeHandler) setStatus(c *gin.Context, status string) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	if err := h.db.SetNodeStatus(ctx, id, status); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Node not found",
+		})
+		return
+	}
+
+	node, err := h.db.GetNode(ctx, id)
+	if err != nil {
+		h.logger.Error("Failed to get node", zap.String("node_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to update node",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, node)
+}
+
+// DeleteNode removes a node that no longer runs any tenants
+func (h *NodeHandler) DeleteNode(c *gin.Context) {
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	if _, err := h.db.GetNode(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Node not found",
+		})
+		return
+	}
+
+	if err := h.db.DeleteNode(ctx, id); err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "node_in_use",
+			Message: "Tenants are still placed on this node",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// MigrateCustomer moves a tenant to another worker node and waits until it
+// serves from there or the move is rolled back
+func (h *NodeHandler) MigrateCustomer(c *gin.Context) {
+	if !h.migrationEnabled(c) {
+		return
+	}
+
+	var req MigrateRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_failed",
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if _, err := h.db.GetCustomerByID(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+	if req.NodeID != "" {
+		if _, err := h.db.GetNode(ctx, req.NodeID); err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   "not_found",
+				Message: "Node not found",
+			})
+			return
+		}
+	}
+
+	if err := h.migrator.Migrate(ctx, id, req.NodeID); err != nil {
+		h.logger.Warn("Tenant migration failed", zap.String("customer_id", id), zap.Error(err))
+		switch {
+		case errors.Is(err, provisioner.ErrNotMigratable):
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "not_migratable",
+				Message: "Only provisioned tenants can be migrated",
+			})
+		case errors.Is(err, scheduler.ErrNoCapacity):
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "no_capacity",
+				Message: "No other node has room for the tenant",
+			})
+		default:
+			c.JSON(http.StatusBadGateway, ErrorResponse{
+				Error:   "migration_failed",
+				Message: "Migration failed and was rolled back: " + err.Error(),
+			})
+		}
+		return
+	}
+
+	customer, err := h.db.GetCustomerByID(ctx, id)
+	if err != nil || customer.NodeID == nil || customer.ContainerPort == nil {
+		h.logger.Error("Failed to get migrated customer", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Migration finished but the customer could not be read",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, MigrateResponse{
+		CustomerID:    id,
+		NodeID:        *customer.NodeID,
+		ContainerPort: *customer.ContainerPort,
+	})
+}
+
+// DrainNode cordons a node and migrates its tenants to other nodes in the
+// background. Progress shows in the node's tenant count.
+func (h *NodeHandler) DrainNode(c *gin.Context) {
+	if !h.migrationEnabled(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if _, err := h.db.GetNode(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Node not found",
+		})
+		return
+	}
+	// Cordon before answering so no tenant is placed on the node meanwhile
+	if err := h.db.SetNodeStatus(ctx, id, db.NodeCordoned); err != nil {
+		h.logger.Error("Failed to cordon node", zap.String("node_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to cordon node",
+		})
+		return
+	}
+
+	go func() {
+		if err := h.migrator.Drain(context.Background(), id); err != nil {
+			h.logger.Error("Node drain incomplete", zap.String("node_id", id), zap.Error(err))
+			return
+		}
+		h.logger.Info("Node drained", zap.String("node_id", id))
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"node_id": id,
+		"status":  db.NodeCordoned,
+	})
+}
+
+// migrationEnabled answers 503 unless tenants can be moved between nodes
+func (h *NodeHandler) migrationEnabled(c *gin.Context) bool {
+	if h.migrator == nil || h.cfg.NodeAgentToken == "" {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "nodes_disabled",
+			Message: "Set NODE_AGENT_TOKEN to schedule tenants on worker nodes",
+		})
+		return false
+	}
+	return true
+}
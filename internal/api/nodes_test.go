@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/nodeagent"
+	"blytz/internal/provisioner"
+	"blytz/internal/scheduler"
+	"blytz/internal/stripe"
+)
+
+const testNodeToken = "node-secret"
+
+func setupNodesTest(t *testing.T, nodeToken string, opts ...RouterOption) (*gin.Engine, *db.DB) {
+	gin.SetMode(gin.TestMode)
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	cfg := &config.Config{
+		MaxCustomers:   20,
+		PortRangeStart: 30000,
+		PortRangeEnd:   30999,
+		CustomersDir:   t.TempDir(),
+		AdminAPIKey:    testAdminKey,
+		NodeAgentToken: nodeToken,
+	}
+	logger := zap.NewNop()
+	prov := provisioner.NewService(database, "", cfg.CustomersDir, "", cfg.PortRangeStart, cfg.PortRangeEnd, nil, "localhost", logger)
+	stripeSvc := stripe.NewService("sk-test", "price-test")
+	return NewRouter(database, prov, stripeSvc, stripe.NewWebhookHandler(database, prov, "whsec-test"), cfg, logger, opts...), database
+}
+
+// fakeMigrator records moves, placing migrated tenants on the requested node
+type fakeMigrator struct {
+	database *db.DB
+	err      error
+	drained  chan string
+}
+
+func (f *fakeMigrator) Migrate(ctx context.Context, customerID, nodeID string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.database.AssignNode(ctx, customerID, nodeID, 30005)
+}
+
+func (f *fakeMigrator) Drain(ctx context.Context, nodeID string) error {
+	f.drained <- nodeID
+	return nil
+}
+
+// startNodeAgent runs a node agent; registration only checks its health
+func startNodeAgent(t *testing.T, token string) string {
+	t.Helper()
+	dir := t.TempDir()
+	srv := httptest.NewServer(nodeagent.NewServer(provisioner.NewDockerProvisioner(dir), dir, token, zap.NewNop()).Handler())
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func nodeBody(name, agentURL string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":       name,
+		"address":    "10.0.0.2",
+		"agent_url":  agentURL,
+		"memory_mb":  4096,
+		"cpus":       2,
+		"port_start": 30000,
+		"port_end":   30099,
+		"labels":     map[string]string{"region": "eu"},
+	}
+}
+
+func TestRegisterNode(t *testing.T) {
+	router, _ := setupNodesTest(t, testNodeToken)
+	agentURL := startNodeAgent(t, testNodeToken)
+
+	assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/api/admin/nodes", "", nodeBody("worker-1", agentURL)).Code)
+
+	w := postJSON(router, "/api/admin/nodes", testAdminKey, nodeBody("worker-1", agentURL))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var node db.Node
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &node))
+	assert.NotEmpty(t, node.ID)
+	assert.Equal(t, db.NodeActive, node.Status)
+	assert.Equal(t, map[string]string{"region": "eu"}, node.Labels)
+
+	w = postJSON(router, "/api/admin/nodes", testAdminKey, nodeBody("worker-1", agentURL))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "node_exists")
+
+	// The agent must hold the control plane's token
+	w = postJSON(router, "/api/admin/nodes", testAdminKey, nodeBody("worker-2", startNodeAgent(t, "other-secret")))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "node_unreachable")
+
+	tests := []struct {
+		name   string
+		modify func(body map[string]interface{})
+	}{
+		{"missing name", func(body map[string]interface{}) { delete(body, "name") }},
+		{"bad agent url", func(body map[string]interface{}) { body["agent_url"] = "not a url" }},
+		{"no memory", func(body map[string]interface{}) { body["memory_mb"] = 0 }},
+		{"reversed ports", func(body map[string]interface{}) { body["port_end"] = 29999 }},
+		{"port out of range", func(body map[string]interface{}) { body["port_end"] = 70000 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := nodeBody("worker-3", agentURL)
+			tt.modify(body)
+			w := postJSON(router, "/api/admin/nodes", testAdminKey, body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "validation_failed")
+		})
+	}
+}
+
+func TestRegisterNodeWithoutToken(t *testing.T) {
+	router, _ := setupNodesTest(t, "")
+
+	w := postJSON(router, "/api/admin/nodes", testAdminKey, nodeBody("worker-1", startNodeAgent(t, "")))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "nodes_disabled")
+}
+
+func TestManageNodes(t *testing.T) {
+	router, database := setupNodesTest(t, testNodeToken)
+	ctx := t.Context()
+
+	node := &db.Node{Name: "worker-1", Address: "10.0.0.2", AgentURL: "http://10.0.0.2:9100",
+		MemoryMB: 4096, CPUs: 2, PortStart: 30000, PortEnd: 30099}
+	require.NoError(t, database.CreateNode(ctx, node))
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.AssignNode(ctx, customer.ID, node.ID, 30000))
+
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/api/admin/nodes", "alice-token").Code)
+
+	w := doAuthed(router, "GET", "/api/admin/nodes", testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var list struct {
+		Nodes []struct {
+			ID           string `json:"id"`
+			Tenants      int    `json:"tenants"`
+			UsedMemoryMB int    `json:"used_memory_mb"`
+			UsedPorts    int    `json:"used_ports"`
+		} `json:"nodes"`
+		Count int `json:"count"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Equal(t, 1, list.Count)
+	assert.Equal(t, node.ID, list.Nodes[0].ID)
+	assert.Equal(t, 1, list.Nodes[0].Tenants)
+	assert.Equal(t, 512, list.Nodes[0].UsedMemoryMB)
+	assert.Equal(t, 2, list.Nodes[0].UsedPorts, "gateway and bridge ports")
+
+	w = doAuthed(router, "POST", "/api/admin/nodes/"+node.ID+"/cordon", testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"cordoned"`)
+
+	w = doAuthed(router, "POST", "/api/admin/nodes/"+node.ID+"/uncordon", testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"active"`)
+
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "POST", "/api/admin/nodes/missing/cordon", testAdminKey).Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "DELETE", "/api/admin/nodes/missing", testAdminKey).Code)
+
+	// Nodes are removed only once their tenants are gone
+	w = doAuthed(router, "DELETE", "/api/admin/nodes/"+node.ID, testAdminKey)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "node_in_use")
+
+	require.NoError(t, database.ClearCustomerNode(ctx, customer.ID))
+	assert.Equal(t, http.StatusNoContent, doAuthed(router, "DELETE", "/api/admin/nodes/"+node.ID, testAdminKey).Code)
+	_, err := database.GetNode(ctx, node.ID)
+	assert.Error(t, err)
+}
+
+func TestMigrateCustomer(t *testing.T) {
+	migrator := &fakeMigrator{}
+	router, database := setupNodesTest(t, testNodeToken, WithMigrator(migrator))
+	migrator.database = database
+	ctx := t.Context()
+
+	node := &db.Node{Name: "worker-2", Address: "10.0.0.3", AgentURL: "http://10.0.0.3:9100",
+		MemoryMB: 4096, CPUs: 2, PortStart: 30000, PortEnd: 30099}
+	require.NoError(t, database.CreateNode(ctx, node))
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	path := "/api/admin/customers/" + customer.ID + "/migrate"
+
+	assert.Equal(t, http.StatusUnauthorized, postJSON(router, path, "alice-token", MigrateRequest{NodeID: node.ID}).Code)
+
+	w := postJSON(router, path, testAdminKey, MigrateRequest{NodeID: node.ID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp MigrateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, MigrateResponse{CustomerID: customer.ID, NodeID: node.ID, ContainerPort: 30005}, resp)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     MigrateRequest
+		err      error
+		wantCode int
+		wantBody string
+	}{
+		{"unknown customer", "/api/admin/customers/missing/migrate", MigrateRequest{}, nil, http.StatusNotFound, "not_found"},
+		{"unknown node", path, MigrateRequest{NodeID: "missing"}, nil, http.StatusNotFound, "not_found"},
+		{"not provisioned", path, MigrateRequest{}, fmt.Errorf("%w: tenant is pending", provisioner.ErrNotMigratable), http.StatusConflict, "not_migratable"},
+		{"no capacity", path, MigrateRequest{}, fmt.Errorf("reserve target node: %w", scheduler.ErrNoCapacity), http.StatusConflict, "no_capacity"},
+		{"rolled back", path, MigrateRequest{}, errors.New("tenant did not become healthy"), http.StatusBadGateway, "did not become healthy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrator.err = tt.err
+			w := postJSON(router, tt.path, testAdminKey, tt.body)
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestDrainNode(t *testing.T) {
+	migrator := &fakeMigrator{drained: make(chan string, 1)}
+	router, database := setupNodesTest(t, testNodeToken, WithMigrator(migrator))
+	ctx := t.Context()
+
+	node := &db.Node{Name: "worker-1", Address: "10.0.0.2", AgentURL: "http://10.0.0.2:9100",
+		MemoryMB: 4096, CPUs: 2, PortStart: 30000, PortEnd: 30099}
+	require.NoError(t, database.CreateNode(ctx, node))
+
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "POST", "/api/admin/nodes/missing/drain", testAdminKey).Code)
+
+	w := doAuthed(router, "POST", "/api/admin/nodes/"+node.ID+"/drain", testAdminKey)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	// The node is cordoned before the answer; tenants move in the background
+	got, err := database.GetNode(ctx, node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.NodeCordoned, got.Status)
+	select {
+	case drained := <-migrator.drained:
+		assert.Equal(t, node.ID, drained)
+	case <-time.After(time.Second):
+		t.Fatal("drain did not start")
+	}
+}
+
+func TestMigrationWithoutNodes(t *testing.T) {
+	router, _ := setupNodesTest(t, "", WithMigrator(&fakeMigrator{}))
+
+	w := postJSON(router, "/api/admin/customers/any/migrate", testAdminKey, MigrateRequest{})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, http.StatusServiceUnavailable, doAuthed(router, "POST", "/api/admin/nodes/any/drain", testAdminKey).Code)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/privacy"
+)
+
+// PrivacyHandler serves customer data export and erasure endpoints
+type PrivacyHandler struct {
+	privacy *privacy.Service
+	logger  *zap.Logger
+}
+
+// NewPrivacyHandler creates a new privacy handler
+func NewPrivacyHandler(svc *privacy.Service, logger *zap.Logger) *PrivacyHandler {
+	return &PrivacyHandler{
+		privacy: svc,
+		logger:  logger,
+	}
+}
+
+// ExportData returns every piece of data held about the customer as JSON
+func (h *PrivacyHandler) ExportData(c *gin.Context) {
+	id := c.Param("id")
+
+	export, err := h.privacy.Export(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to export customer data", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=\""+id+"-export.json\"")
+	c.JSON(http.StatusOK, export)
+}
+
+// RequestErasure schedules deletion of the customer's data after the grace period
+func (h *PrivacyHandler) RequestErasure(c *gin.Context) {
+	id := c.Param("id")
+
+	req, err := h.privacy.RequestErasure(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to schedule erasure", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to schedule erasure",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, req)
+}
+
+// GetErasure returns the state of the customer's erasure request
+func (h *PrivacyHandler) GetErasure(c *gin.Context) {
+	id := c.Param("id")
+
+	req, err := h.privacy.GetErasure(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to get erasure request", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to get erasure request",
+		})
+		return
+	}
+	if req == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "No erasure request found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+// CancelErasure withdraws a scheduled erasure during the grace period
+func (h *PrivacyHandler) CancelErasure(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.privacy.CancelErasure(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "not_scheduled",
+			Message: "No scheduled erasure request to cancel",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "cancelled"})
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/provisioner"
+	"blytz/internal/stripe"
+)
+
+const testAdminKey = "admin-secret"
+
+func setupAuthTestServer(t *testing.T, opts ...RouterOption) (*gin.Engine, *db.DB) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	return setupAuthTestServerWithDB(t, database, opts...)
+}
+
+func setupAuthTestServerWithDB(t *testing.T, database *db.DB, opts ...RouterOption) (*gin.Engine, *db.DB) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := &config.Config{
+		MaxCustomers:     20,
+		PortRangeStart:   30000,
+		PortRangeEnd:     30999,
+		CustomersDir:     t.TempDir(),
+		AdminAPIKey:      testAdminKey,
+		ErasureGraceDays: 30,
+	}
+
+	logger := zap.NewNop()
+	prov := provisioner.NewService(database, "", cfg.CustomersDir, "", cfg.PortRangeStart, cfg.PortRangeEnd, nil, "localhost", logger)
+	stripeSvc := stripe.NewService("sk-test", "price-test")
+	stripeWebhook := stripe.NewWebhookHandler(database, prov, "whsec-test")
+
+	return NewRouter(database, prov, stripeSvc, stripeWebhook, cfg, logger, opts...), database
+}
+
+func createAuthedCustomer(t *testing.T, database *db.DB, email, token string) *db.Customer {
+	t.Helper()
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.SetCustomerAccessToken(ctx, customer.ID, token))
+	return customer
+}
+
+func doAuthed(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCustomerEndpointsRequireAuth(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	createAuthedCustomer(t, database, "bob@example.com", "bob-token")
+
+	path := "/api/customers/" + alice.ID + "/export"
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"wrong token", "nope", http.StatusUnauthorized},
+		{"other customer's token", "bob-token", http.StatusUnauthorized},
+		{"own token", "alice-token", http.StatusOK},
+		{"admin token", testAdminKey, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doAuthed(router, "GET", path, tt.token)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestAdminAuthDisabledWithoutKey(t *testing.T) {
+	cfg := &config.Config{}
+	assert.False(t, isAdminToken(cfg, ""))
+	assert.False(t, isAdminToken(cfg, "anything"))
+}
+
+func TestExportDataEndpoint(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "export@example.com", "token")
+
+	w := doAuthed(router, "GET", "/api/customers/"+customer.ID+"/export", "token")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+
+	var export map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+	assert.Contains(t, export, "customer")
+	assert.Contains(t, export, "audit_log")
+	assert.Contains(t, export, "files")
+	assert.Contains(t, export, "exported_at")
+}
+
+func TestErasureEndpoints(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "erase@example.com", "token")
+	path := "/api/customers/" + customer.ID + "/erasure"
+
+	w := doAuthed(router, "GET", path, "token")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = doAuthed(router, "DELETE", path, "token")
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = doAuthed(router, "POST", path, "token")
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var req db.ErasureRequest
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &req))
+	assert.Equal(t, "scheduled", req.Status)
+	assert.True(t, req.ScheduledFor.After(req.RequestedAt))
+
+	w = doAuthed(router, "DELETE", path, "token")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = doAuthed(router, "GET", path, "token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &req))
+	assert.Equal(t, "cancelled", req.Status)
+}
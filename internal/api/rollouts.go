@@ -0,0 +1,219 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/rollout"
+)
+
+// RolloutHandler starts, reports on and halts agent image rollouts
+type RolloutHandler struct {
+	db           *db.DB
+	orchestrator *rollout.Orchestrator
+	logger       *zap.Logger
+}
+
+// NewRolloutHandler creates a new rollout handler
+func NewRolloutHandler(database *db.DB, orchestrator *rollout.Orchestrator, logger *zap.Logger) *RolloutHandler {
+	return &RolloutHandler{
+		db:           database,
+		orchestrator: orchestrator,
+		logger:       logger,
+	}
+}
+
+// StartRolloutRequest is the body of POST /api/admin/rollouts. Omitted
+// settings take the configured defaults.
+type StartRolloutRequest struct {
+	AgentTypeID string `json:"agent_type_id" binding:"required"`
+	// ImageDigest is the image to roll out; empty rolls out the agent type's latest build
+	ImageDigest      string   `json:"image_digest"`
+	CanarySize       *int     `json:"canary_size" binding:"omitempty,min=1"`
+	Concurrency      *int     `json:"concurrency" binding:"omitempty,min=1"`
+	BakeSeconds      *int     `json:"bake_seconds" binding:"omitempty,min=0"`
+	BatchBakeSeconds *int     `json:"batch_bake_seconds" binding:"omitempty,min=0"`
+	MaxErrorRate     *float64 `json:"max_error_rate" binding:"omitempty,min=0,max=1"`
+}
+
+// RolloutResponse is a rollout with the tenants it upgrades
+type RolloutResponse struct {
+	*db.Rollout
+	Tenants []db.RolloutTenant `json:"tenants"`
+}
+
+// ListRollouts returns every rollout, newest first
+func (h *RolloutHandler) ListRollouts(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	rollouts, err := h.db.ListRollouts(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list rollouts", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to list rollouts",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rollouts": rollouts,
+		"count":    len(rollouts),
+	})
+}
+
+// StartRollout plans a rollout of an agent image to the agent type's active
+// tenants. It runs in the background; progress shows in GetRollout.
+func (h *RolloutHandler) StartRollout(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	var req StartRolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.db.GetAgentType(ctx, req.AgentTypeID); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Agent type not found",
+		})
+		return
+	}
+
+	policy := h.orchestrator.Policy()
+	if req.CanarySize != nil {
+		policy.CanarySize = *req.CanarySize
+	}
+	if req.Concurrency != nil {
+		policy.Concurrency = *req.Concurrency
+	}
+	if req.BakeSeconds != nil {
+		policy.BakePeriod = time.Duration(*req.BakeSeconds) * time.Second
+	}
+	if req.BatchBakeSeconds != nil {
+		policy.BatchBake = time.Duration(*req.BatchBakeSeconds) * time.Second
+	}
+	if req.MaxErrorRate != nil {
+		policy.MaxErrorRate = *req.MaxErrorRate
+	}
+
+	started, err := h.orchestrator.Start(ctx, req.AgentTypeID, req.ImageDigest, policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, rollout.ErrInvalidPolicy):
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "validation_failed",
+				Message: err.Error(),
+			})
+		case errors.Is(err, rollout.ErrNoImage):
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "no_image",
+				Message: "Build an image for the agent type before rolling it out",
+			})
+		case errors.Is(err, rollout.ErrInProgress):
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "rollout_in_progress",
+				Message: "A rollout of this agent type has not finished",
+			})
+		case errors.Is(err, rollout.ErrUpToDate):
+			c.JSON(http.StatusConflict, ErrorResponse{
+				Error:   "up_to_date",
+				Message: "Every active tenant already runs this image",
+			})
+		default:
+			h.logger.Error("Failed to start rollout", zap.String("agent_type", req.AgentTypeID), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Error:   "internal_error",
+				Message: "Failed to start rollout",
+			})
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, started)
+}
+
+// GetRollout returns a rollout's progress and the state of each of its tenants
+func (h *RolloutHandler) GetRollout(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	r, err := h.db.GetRollout(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Rollout not found",
+		})
+		return
+	}
+
+	tenants, err := h.db.ListRolloutTenants(ctx, r.ID)
+	if err != nil {
+		h.logger.Error("Failed to list rollout tenants", zap.String("rollout_id", r.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to get rollout",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, RolloutResponse{Rollout: r, Tenants: tenants})
+}
+
+// HaltRollout stops a rollout in progress. The orchestrator rolls back every
+// tenant it upgraded.
+func (h *RolloutHandler) HaltRollout(c *gin.Context) {
+	if !h.enabled(c) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	if _, err := h.db.GetRollout(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Rollout not found",
+		})
+		return
+	}
+	if err := h.db.HaltRollout(ctx, id); err != nil {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "not_in_progress",
+			Message: "Only rollouts in progress can be halted",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"rollout_id": id,
+		"status":     db.RolloutHalting,
+	})
+}
+
+// enabled answers 503 unless the server runs a rollout orchestrator
+func (h *RolloutHandler) enabled(c *gin.Context) bool {
+	if h.orchestrator == nil {
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "rollouts_disabled",
+			Message: "Agent image rollouts are not available on this server",
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/provisioner"
+	"blytz/internal/rollout"
+	"blytz/internal/stripe"
+)
+
+// instantUpgrader moves tenants to an image without touching containers
+type instantUpgrader struct {
+	database *db.DB
+}
+
+func (u *instantUpgrader) UpgradeImage(ctx context.Context, customerID, imageDigest string) error {
+	return u.database.SetCustomerImage(ctx, customerID, imageDigest)
+}
+
+func (u *instantUpgrader) CheckHealth(ctx context.Context, customerID string) error {
+	return nil
+}
+
+func setupRolloutsTest(t *testing.T) (*gin.Engine, *db.DB, *rollout.Orchestrator) {
+	gin.SetMode(gin.TestMode)
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	cfg := &config.Config{
+		MaxCustomers:   20,
+		PortRangeStart: 30000,
+		PortRangeEnd:   30999,
+		CustomersDir:   t.TempDir(),
+		AdminAPIKey:    testAdminKey,
+	}
+	logger := zap.NewNop()
+	prov := provisioner.NewService(database, "", cfg.CustomersDir, "", cfg.PortRangeStart, cfg.PortRangeEnd, nil, "localhost", logger)
+	stripeSvc := stripe.NewService("sk-test", "price-test")
+
+	policy := rollout.DefaultPolicy()
+	policy.BakePeriod = 0
+	policy.BatchBake = 0
+	orchestrator := rollout.New(database, &instantUpgrader{database: database}, policy, logger)
+
+	router := NewRouter(database, prov, stripeSvc, stripe.NewWebhookHandler(database, prov, "whsec-test"), cfg, logger,
+		WithRollouts(orchestrator))
+	return router, database, orchestrator
+}
+
+func TestRollouts(t *testing.T) {
+	router, database, orchestrator := setupRolloutsTest(t)
+	ctx := t.Context()
+
+	const image = "registry.local/blytz/openclaw@sha256:2222"
+	for i, email := range []string{"alice@example.com", "bob@example.com"} {
+		customer := createAuthedCustomer(t, database, email, email)
+		require.NoError(t, database.UpdateCustomerPort(ctx, customer.ID, 30000+i))
+		require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/api/admin/rollouts", "alice@example.com",
+		StartRolloutRequest{AgentTypeID: "openclaw"}).Code)
+
+	// Nothing has been built yet
+	w := postJSON(router, "/api/admin/rollouts", testAdminKey, StartRolloutRequest{AgentTypeID: "openclaw"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "no_image")
+
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", "registry.local/blytz/openclaw:1.5.0", image))
+	concurrency := 1
+	w = postJSON(router, "/api/admin/rollouts", testAdminKey, StartRolloutRequest{AgentTypeID: "openclaw", Concurrency: &concurrency})
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var started db.Rollout
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &started))
+	assert.Equal(t, image, started.ImageDigest)
+	assert.Equal(t, db.RolloutPending, started.Status)
+	assert.Equal(t, 2, started.Batches)
+	assert.Equal(t, 2, started.Progress.Pending)
+
+	w = postJSON(router, "/api/admin/rollouts", testAdminKey, StartRolloutRequest{AgentTypeID: "openclaw"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "rollout_in_progress")
+
+	require.NoError(t, orchestrator.RunPending(ctx))
+
+	w = doAuthed(router, "GET", "/api/admin/rollouts/"+started.ID, testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	var got RolloutResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, db.RolloutCompleted, got.Status)
+	assert.Equal(t, db.RolloutProgress{Total: 2, Upgraded: 2}, got.Progress)
+	require.Len(t, got.Tenants, 2)
+	assert.Equal(t, 0, got.Tenants[0].Batch)
+
+	w = doAuthed(router, "GET", "/api/admin/rollouts", testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"count":1`)
+
+	// Finished rollouts cannot be halted, and every tenant is up to date
+	assert.Equal(t, http.StatusConflict, doAuthed(router, "POST", "/api/admin/rollouts/"+started.ID+"/halt", testAdminKey).Code)
+	w = postJSON(router, "/api/admin/rollouts", testAdminKey, StartRolloutRequest{AgentTypeID: "openclaw"})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "up_to_date")
+
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/admin/rollouts/missing", testAdminKey).Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "POST", "/api/admin/rollouts/missing/halt", testAdminKey).Code)
+	assert.Equal(t, http.StatusNotFound, postJSON(router, "/api/admin/rollouts", testAdminKey,
+		StartRolloutRequest{AgentTypeID: "missing"}).Code)
+	rate := 2.0
+	assert.Equal(t, http.StatusBadRequest, postJSON(router, "/api/admin/rollouts", testAdminKey,
+		StartRolloutRequest{AgentTypeID: "openclaw", MaxErrorRate: &rate}).Code)
+}
+
+func TestHaltRollout(t *testing.T) {
+	router, database, orchestrator := setupRolloutsTest(t)
+	ctx := t.Context()
+
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.UpdateCustomerPort(ctx, customer.ID, 30000))
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+
+	started, err := orchestrator.Start(ctx, "openclaw", "registry.local/blytz/openclaw@sha256:2222", orchestrator.Policy())
+	require.NoError(t, err)
+
+	w := doAuthed(router, "POST", "/api/admin/rollouts/"+started.ID+"/halt", testAdminKey)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	require.NoError(t, orchestrator.RunPending(ctx))
+	got, err := database.GetRollout(ctx, started.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutRolledBack, got.Status)
+	assert.Equal(t, "halted by operator", got.Error)
+}
+
+func TestRolloutsDisabled(t *testing.T) {
+	router, _ := setupNodesTest(t, "")
+
+	assert.Equal(t, http.StatusServiceUnavailable, doAuthed(router, "GET", "/api/admin/rollouts", testAdminKey).Code)
+	w := postJSON(router, "/api/admin/rollouts", testAdminKey, StartRolloutRequest{AgentTypeID: "openclaw"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
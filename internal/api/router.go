@@ -2,25 +2,198 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
 	"blytz/internal/config"
 	"blytz/internal/db"
+	"blytz/internal/domains"
+	"blytz/internal/health"
+	"blytz/internal/llmproxy"
+	"blytz/internal/logs"
+	"blytz/internal/metrics"
+	"blytz/internal/privacy"
 	"blytz/internal/provisioner"
+	"blytz/internal/rollout"
+	"blytz/internal/scheduler"
 	"blytz/internal/stripe"
+	"blytz/internal/supervisor"
+	"blytz/internal/telemetry"
+	"blytz/internal/waitlist"
 )
 
-func NewRouter(database *db.DB, prov provisioner.Provisioner, stripeSvc *stripe.Service, stripeWebhook *stripe.WebhookHandler, cfg *config.Config, logger *zap.Logger) *gin.Engine {
+// routerDeps holds optional services wired into the router
+type routerDeps struct {
+	privacy    *privacy.Service
+	metrics    *metrics.Collector
+	health     *health.Service
+	supervisor *supervisor.Supervisor
+	logs       *logs.Service
+	llm        *llmproxy.Proxy
+	usage      *stripe.UsageReporter
+	billing    stripe.Billing
+	waitlist   *waitlist.Service
+	scheduler  *scheduler.Scheduler
+	migrator   Migrator
+	rollouts   *rollout.Orchestrator
+	domains    *domains.Service
+}
+
+// RouterOption supplies an optional service to NewRouter
+type RouterOption func(*routerDeps)
+
+// WithPrivacy shares a privacy service with the router instead of creating a new one
+func WithPrivacy(svc *privacy.Service) RouterOption {
+	return func(d *routerDeps) {
+		d.privacy = svc
+	}
+}
+
+// WithMetrics reports samples from the collector in the status endpoints
+func WithMetrics(collector *metrics.Collector) RouterOption {
+	return func(d *routerDeps) {
+		d.metrics = collector
+	}
+}
+
+// WithHealth replaces the default readiness checks
+func WithHealth(svc *health.Service) RouterOption {
+	return func(d *routerDeps) {
+		d.health = svc
+	}
+}
+
+// WithSupervisor reports tenant health history from the supervisor
+func WithSupervisor(sup *supervisor.Supervisor) RouterOption {
+	return func(d *routerDeps) {
+		d.supervisor = sup
+	}
+}
+
+// WithLogs replaces the docker-backed log service
+func WithLogs(svc *logs.Service) RouterOption {
+	return func(d *routerDeps) {
+		d.logs = svc
+	}
+}
+
+// WithLLMProxy replaces the LLM proxy built from the configuration
+func WithLLMProxy(proxy *llmproxy.Proxy) RouterOption {
+	return func(d *routerDeps) {
+		d.llm = proxy
+	}
+}
+
+// WithUsageReporter replaces the Stripe usage reporter built from the configuration
+func WithUsageReporter(reporter *stripe.UsageReporter) RouterOption {
+	return func(d *routerDeps) {
+		d.usage = reporter
+	}
+}
+
+// WithBilling replaces the Stripe service used for subscriptions, invoices and the billing portal
+func WithBilling(billing stripe.Billing) RouterOption {
+	return func(d *routerDeps) {
+		d.billing = billing
+	}
+}
+
+// WithWaitlist shares the waitlist whose worker issues invitations
+func WithWaitlist(svc *waitlist.Service) RouterOption {
+	return func(d *routerDeps) {
+		d.waitlist = svc
+	}
+}
+
+// WithScheduler shares the scheduler that places tenants on worker nodes
+func WithScheduler(sched *scheduler.Scheduler) RouterOption {
+	return func(d *routerDeps) {
+		d.scheduler = sched
+	}
+}
+
+// WithMigrator enables moving tenants between worker nodes
+func WithMigrator(m Migrator) RouterOption {
+	return func(d *routerDeps) {
+		d.migrator = m
+	}
+}
+
+// WithRollouts enables staged rollouts of agent images through the orchestrator
+func WithRollouts(o *rollout.Orchestrator) RouterOption {
+	return func(d *routerDeps) {
+		d.rollouts = o
+	}
+}
+
+// WithDomains enables customers' custom domains
+func WithDomains(svc *domains.Service) RouterOption {
+	return func(d *routerDeps) {
+		d.domains = svc
+	}
+}
+
+func NewRouter(database *db.DB, prov provisioner.Provisioner, stripeSvc *stripe.Service, stripeWebhook *stripe.WebhookHandler, cfg *config.Config, logger *zap.Logger, opts ...RouterOption) *gin.Engine {
+	deps := &routerDeps{}
+	for _, opt := range opts {
+		opt(deps)
+	}
+	if deps.privacy == nil {
+		grace := time.Duration(cfg.ErasureGraceDays) * 24 * time.Hour
+		deps.privacy = privacy.NewService(database, prov, stripeSvc, cfg.CustomersDir, grace, logger)
+	}
+	if deps.logs == nil {
+		deps.logs = logs.NewService(logs.NewDockerSource(), cfg.CustomersDir)
+		deps.logs.UseNodes(database, nodeLogs(cfg))
+	}
+	if deps.health == nil {
+		deps.health = newReadiness(database, cfg)
+	}
+	if deps.llm == nil {
+		deps.llm = newLLMProxy(database, cfg, logger)
+	}
+	if deps.usage == nil {
+		deps.usage = newUsageReporter(database, cfg, logger)
+	}
+	if deps.billing == nil {
+		deps.billing = stripeSvc
+	}
+	if deps.waitlist == nil {
+		deps.waitlist = waitlist.NewService(database, cfg.MaxCustomers, waitlistInviteTTL(cfg), logger)
+	}
+	if deps.scheduler == nil {
+		deps.scheduler = scheduler.New(database)
+	}
+
 	router := gin.New()
 	router.Use(gin.Recovery())
 	router.Use(loggingMiddleware(logger))
+	router.Use(telemetry.Middleware())
 
 	handler := NewHandler(database, prov, stripeSvc, cfg, logger)
+	handler.metrics = deps.metrics
+	handler.supervisor = deps.supervisor
+	handler.waitlist = deps.waitlist
 	marketplaceHandler := NewMarketplaceHandler(database, logger)
+	privacyHandler := NewPrivacyHandler(deps.privacy, logger)
+	healthHandler := NewHealthHandler(deps.health)
+	logsHandler := NewLogsHandler(database, deps.logs, logger)
+	usageHandler := NewUsageHandler(database, logger)
+	llmHandler := NewLLMHandler(database, deps.llm, logger)
+	billingHandler := NewBillingHandler(database, deps.billing, deps.usage, deps.llm, cfg, logger)
+	nodeHandler := NewNodeHandler(database, deps.scheduler, cfg, logger)
+	nodeHandler.migrator = deps.migrator
+	rolloutHandler := NewRolloutHandler(database, deps.rollouts, logger)
+	domainHandler := NewDomainHandler(database, deps.domains, logger)
+
+	// Prometheus scrape endpoint
+	router.GET("/metrics", requireMetricsToken(cfg), gin.WrapH(telemetry.Handler()))
 
 	// Health and status checks
+	router.GET("/livez", healthHandler.Livez)
+	router.GET("/readyz", healthHandler.Readyz)
 	router.GET("/api/health", handler.HealthCheck)
 	router.GET("/api/status/system", handler.SystemStatus)
 
@@ -30,16 +203,74 @@ func NewRouter(database *db.DB, prov provisioner.Provisioner, stripeSvc *stripe.
 	router.GET("/api/marketplace/llm-providers", marketplaceHandler.ListLLMProviders)
 	router.GET("/api/marketplace/llm-providers/:id", marketplaceHandler.GetLLMProvider)
 	router.GET("/api/marketplace/stacks", marketplaceHandler.GetStacks)
+	router.GET("/api/plans", billingHandler.ListPlans)
 
 	// API endpoints with rate limiting
 	router.POST("/api/signup", signupRateLimit(), handler.CreateCustomer)
-	router.GET("/api/status/:id", handler.GetCustomerStatus)
+	router.GET("/api/status/:id", requireCustomerOrAdmin(database, cfg), handler.GetCustomerStatus)
 	router.POST("/api/webhook/stripe", webhookRateLimit(), stripeWebhook.HandleWebhook)
 
+	// Waitlist entries are addressed by their unguessable ID, returned once at signup
+	router.GET("/api/waitlist/:id", handler.GetWaitlistEntry)
+	router.POST("/api/waitlist/:id/claim", signupRateLimit(), handler.ClaimWaitlistInvite)
+
+	// Agent-reported activity, authenticated by the tenant's gateway token
+	router.POST("/api/usage/events", requireGatewayToken(database), usageHandler.IngestEvents)
+
+	// Caddy's on-demand TLS ask; only verified custom domains get certificates
+	router.GET("/api/domains/allowed", domainHandler.AllowDomain)
+
+	// OpenAI- and Anthropic-compatible proxy used by tenants in place of the platform keys
+	router.Any("/llm/:provider/*path", requireLLMProxyToken(database), llmHandler.Proxy)
+
+	// Customer-scoped endpoints (owning customer or admin)
+	customers := router.Group("/api/customers/:id", requireCustomerOrAdmin(database, cfg))
+	customers.GET("/export", privacyHandler.ExportData)
+	customers.GET("/erasure", privacyHandler.GetErasure)
+	customers.POST("/erasure", privacyHandler.RequestErasure)
+	customers.DELETE("/erasure", privacyHandler.CancelErasure)
+	customers.GET("/metrics", handler.CustomerMetrics)
+	customers.GET("/health", handler.CustomerHealth)
+	customers.GET("/logs", logsHandler.GetLogs)
+	customers.GET("/usage", usageHandler.GetUsage)
+	customers.GET("/llm-usage", llmHandler.GetLLMUsage)
+	customers.GET("/billing/usage", billingHandler.GetBillingUsage)
+	customers.POST("/plan", billingHandler.ChangePlan)
+	customers.POST("/checkout", handler.ResumeCheckout)
+	customers.GET("/notifications", handler.ListNotifications)
+	customers.GET("/domain", domainHandler.GetDomain)
+	customers.POST("/domain", domainHandler.ClaimDomain)
+	customers.POST("/domain/verify", domainHandler.VerifyDomain)
+	customers.DELETE("/domain", domainHandler.RemoveDomain)
+
+	// Subscription management for the customer holding the access token
+	billing := router.Group("/api/billing", requireCustomer(database))
+	billing.POST("/portal", billingHandler.CreatePortalSession)
+	billing.GET("/invoices", billingHandler.ListInvoices)
+	billing.GET("/subscription", billingHandler.GetSubscription)
+	billing.POST("/subscription/resume", billingHandler.ResumeSubscription)
+
+	// Operator endpoints
+	admin := router.Group("/api/admin", requireAdmin(cfg))
+	admin.GET("/waitlist", handler.ListWaitlist)
+	admin.POST("/waitlist/:id/position", handler.MoveWaitlistEntry)
+	admin.GET("/nodes", nodeHandler.ListNodes)
+	admin.POST("/nodes", nodeHandler.RegisterNode)
+	admin.POST("/nodes/:id/cordon", nodeHandler.CordonNode)
+	admin.POST("/nodes/:id/uncordon", nodeHandler.UncordonNode)
+	admin.DELETE("/nodes/:id", nodeHandler.DeleteNode)
+	admin.POST("/nodes/:id/drain", nodeHandler.DrainNode)
+	admin.POST("/customers/:id/migrate", nodeHandler.MigrateCustomer)
+	admin.GET("/rollouts", rolloutHandler.ListRollouts)
+	admin.POST("/rollouts", rolloutHandler.StartRollout)
+	admin.GET("/rollouts/:id", rolloutHandler.GetRollout)
+	admin.POST("/rollouts/:id/halt", rolloutHandler.HaltRollout)
+
 	// HTML pages
 	router.GET("/", serveIndex)
 	router.GET("/configure", serveConfigure)
 	router.GET("/success", serveSuccess)
+	router.GET("/waitlist", serveWaitlist)
 
 	return router
 }
@@ -69,3 +300,8 @@ func serveSuccess(c *gin.Context) {
 	c.Header("Content-Type", "text/html")
 	c.String(http.StatusOK, successHTML)
 }
+
+func serveWaitlist(c *gin.Context) {
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, waitlistHTML)
+}
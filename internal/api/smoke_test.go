@@ -39,7 +39,7 @@ func TestSmokeSignupFlow(t *testing.T) {
 		database,
 		"./internal/workspace/templates",
 		t.TempDir(),
-		"sk-test",
+		"",
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -80,10 +80,8 @@ func TestSmokeSignupFlow(t *testing.T) {
 
 	// Step 3: Verify customer was NOT created
 	t.Run("customer not created", func(t *testing.T) {
-		w := httptest.NewRecorder()
-		req, _ := http.NewRequest("GET", "/api/status/test-example-com", nil)
-		router.ServeHTTP(w, req)
-		assert.Equal(t, http.StatusNotFound, w.Code)
+		_, err := database.GetCustomerByID(t.Context(), "test-example-com")
+		assert.Error(t, err)
 	})
 }
 
@@ -107,7 +105,7 @@ func TestSmokeCapacityLimit(t *testing.T) {
 		database,
 		"./internal/workspace/templates",
 		t.TempDir(),
-		"sk-test",
+		"",
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -118,7 +116,7 @@ func TestSmokeCapacityLimit(t *testing.T) {
 	stripeSvc := stripe.NewService("sk-test", "price-test")
 	stripeWebhook := stripe.NewWebhookHandler(database, prov, "whsec-test")
 
-	router := NewRouter(database, prov, stripeSvc, stripeWebhook, cfg, logger)
+	router := NewRouter(database, acceptingBotProvisioner{prov}, stripeSvc, stripeWebhook, cfg, logger)
 
 	// Fill up capacity
 	ctx := t.Context()
@@ -145,7 +143,11 @@ func TestSmokeCapacityLimit(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	// The signup is queued rather than turned away
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	waitlisted, err := database.IsWaitlisted(ctx, "third@example.com")
+	require.NoError(t, err)
+	assert.True(t, waitlisted)
 }
 
 func TestSmokeConcurrentRequests(t *testing.T) {
@@ -168,7 +170,7 @@ func TestSmokeConcurrentRequests(t *testing.T) {
 		database,
 		"./internal/workspace/templates",
 		t.TempDir(),
-		"sk-test",
+		"",
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -285,7 +287,7 @@ func TestSmokeWebhookInvalidPayload(t *testing.T) {
 		database,
 		"./internal/workspace/templates",
 		t.TempDir(),
-		"sk-test",
+		"",
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -335,6 +337,8 @@ func TestSmokeHtmlPages(t *testing.T) {
 		{"/", http.StatusOK, "Your Personal AI Assistant"},
 		{"/configure", http.StatusOK, "Configure Your Assistant"},
 		{"/success", http.StatusOK, "Your Assistant is Ready"},
+		{"/waitlist", http.StatusOK, "You're on the Waitlist"},
+		{"/configure?queued", http.StatusOK, "window.location.href = '/waitlist?id='"},
 		{"/nonexistent", http.StatusNotFound, "404"},
 	}
 
@@ -351,3 +355,52 @@ func TestSmokeHtmlPages(t *testing.T) {
 		})
 	}
 }
+
+// The success page reads the status with the access token the signup page kept
+func TestSmokeSuccessPageAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	cfg := &config.Config{}
+	logger := zap.NewNop()
+	prov := provisioner.NewService(database, "", "", "", 30000, 30005, nil, "localhost", logger)
+	router := NewRouter(database, prov, stripe.NewService("", ""), stripe.NewWebhookHandler(database, prov, ""), cfg, logger)
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Contains(t, get("/configure", "").Body.String(), "sessionStorage.setItem('blytz_access_token', result.access_token)")
+	success := get("/success", "").Body.String()
+	assert.Contains(t, success, "sessionStorage.getItem('blytz_access_token')")
+	assert.Contains(t, success, "'Authorization': 'Bearer ' + accessToken")
+
+	customer, err := database.CreateCustomer(t.Context(), &db.CreateCustomerRequest{
+		Email:              "test@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.SetCustomerAccessToken(t.Context(), customer.ID, "signup-token"))
+	require.NoError(t, database.UpdateCustomerTelegramUsername(t.Context(), customer.ID, "test_bot"))
+
+	assert.Equal(t, http.StatusUnauthorized, get("/api/status/"+customer.ID, "").Code)
+
+	w := get("/api/status/"+customer.ID, "signup-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status CustomerStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	require.NotNil(t, status.TelegramBotUsername)
+	assert.Equal(t, "test_bot", *status.TelegramBotUsername)
+}
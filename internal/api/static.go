@@ -314,7 +314,12 @@ const configureHTML = `<!DOCTYPE html>
                 
                 const result = await response.json();
                 
-                if (response.ok) {
+                if (response.status === 202) {
+                    // At capacity the signup is queued; its page shows the position and any invitation
+                    window.location.href = '/waitlist?id=' + encodeURIComponent(result.waitlist_id);
+                } else if (response.ok) {
+                    // The access token is only returned once; the success page needs it to read the status
+                    sessionStorage.setItem('blytz_access_token', result.access_token);
                     window.location.href = result.checkout_url;
                 } else {
                     document.getElementById('error').textContent = result.message || 'Something went wrong';
@@ -403,9 +408,12 @@ const successHTML = `<!DOCTYPE html>
     <script>
         const urlParams = new URLSearchParams(window.location.search);
         const customerId = urlParams.get('customer_id');
+        const accessToken = sessionStorage.getItem('blytz_access_token');
         
-        if (customerId) {
-            fetch('/api/status/' + customerId)
+        if (customerId && accessToken) {
+            fetch('/api/status/' + customerId, {
+                headers: { 'Authorization': 'Bearer ' + accessToken }
+            })
                 .then(function(res) { return res.json(); })
                 .then(function(data) {
                     if (data.telegram_bot_username) {
@@ -417,3 +425,108 @@ const successHTML = `<!DOCTYPE html>
     </script>
 </body>
 </html>`
+
+const waitlistHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Waitlist - Blytz</title>
+    <style>
+        * { margin: 0; padding: 0; box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            background: linear-gradient(135deg, #667eea 0%, #764ba2 100%);
+            min-height: 100vh;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+            color: white;
+        }
+        .container {
+            text-align: center;
+            max-width: 600px;
+            padding: 2rem;
+        }
+        h1 { font-size: 2.5rem; margin-bottom: 1rem; }
+        p { font-size: 1.25rem; margin-bottom: 2rem; opacity: 0.9; }
+        .position { font-size: 4rem; font-weight: bold; margin-bottom: 1rem; }
+        button {
+            display: none;
+            padding: 1rem 2rem;
+            background: white;
+            color: #667eea;
+            border: none;
+            border-radius: 50px;
+            font-size: 1rem;
+            font-weight: bold;
+            cursor: pointer;
+            transition: transform 0.2s;
+        }
+        button:hover { transform: scale(1.05); }
+        button:disabled { opacity: 0.6; cursor: not-allowed; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1 id="title">You're on the Waitlist</h1>
+        <div id="position" class="position"></div>
+        <p id="message">We're at capacity right now. We'll email you as soon as a spot opens up.</p>
+        <button id="claimBtn">Continue to Payment →</button>
+    </div>
+    <script>
+        const urlParams = new URLSearchParams(window.location.search);
+        const waitlistId = urlParams.get('id');
+
+        function show(title, message) {
+            document.getElementById('title').textContent = title;
+            document.getElementById('message').textContent = message;
+            document.getElementById('position').textContent = '';
+        }
+
+        async function refresh() {
+            const response = await fetch('/api/waitlist/' + encodeURIComponent(waitlistId));
+            if (!response.ok) {
+                show('Invitation Not Found', 'This waitlist link has expired or was already used.');
+                return;
+            }
+
+            const entry = await response.json();
+            if (entry.status === 'invited') {
+                let message = 'A spot has opened up for you.';
+                if (entry.invite_expires_at) {
+                    message += ' It is held until ' + new Date(entry.invite_expires_at).toLocaleString() + '.';
+                }
+                show("You're In!", message);
+                document.getElementById('claimBtn').style.display = 'inline-block';
+                return;
+            }
+
+            document.getElementById('position').textContent = '#' + entry.position;
+            // Keep the position current while the page stays open
+            setTimeout(refresh, 60000);
+        }
+
+        document.getElementById('claimBtn').addEventListener('click', async function() {
+            this.disabled = true;
+            const response = await fetch('/api/waitlist/' + encodeURIComponent(waitlistId) + '/claim', { method: 'POST' });
+            const result = await response.json();
+            if (!response.ok) {
+                show('Something Went Wrong', result.message || 'Please try again.');
+                this.style.display = 'none';
+                return;
+            }
+
+            // The access token is only returned once; the success page needs it to read the status
+            sessionStorage.setItem('blytz_access_token', result.access_token);
+            window.location.href = result.checkout_url;
+        });
+
+        if (waitlistId) {
+            refresh();
+        } else {
+            show('Invitation Not Found', 'This waitlist link is incomplete.');
+        }
+    </script>
+</body>
+</html>`
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+const (
+	maxUsageEventsPerRequest = 500
+	// usageMaxAge rejects backfills old enough to rewrite history the customer has already seen
+	usageMaxAge = 7 * 24 * time.Hour
+	// usageMaxSkew tolerates agent clocks running slightly ahead of ours
+	usageMaxSkew = 5 * time.Minute
+	maxUsageDays = 90
+)
+
+// UsageEventsRequest is a batch of usage events pushed by a tenant's agent
+type UsageEventsRequest struct {
+	Events []db.UsageEvent `json:"events"`
+}
+
+// UsageHandler ingests and reports agent activity
+type UsageHandler struct {
+	db     *db.DB
+	logger *zap.Logger
+}
+
+// NewUsageHandler creates a new usage handler
+func NewUsageHandler(database *db.DB, logger *zap.Logger) *UsageHandler {
+	return &UsageHandler{
+		db:     database,
+		logger: logger,
+	}
+}
+
+// IngestEvents records usage events for the agent identified by its gateway token
+func (h *UsageHandler) IngestEvents(c *gin.Context) {
+	customer := authCustomer(c)
+
+	var req UsageEventsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: "Invalid request body",
+		})
+		return
+	}
+
+	if err := validateUsageEvents(req.Events, time.Now()); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := h.db.RecordUsage(c.Request.Context(), customer.ID, req.Events); err != nil {
+		h.logger.Error("Failed to record usage", zap.String("customer_id", customer.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to record usage",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"accepted": len(req.Events),
+	})
+}
+
+// validateUsageEvents checks a batch and fills in missing timestamps with now
+func validateUsageEvents(events []db.UsageEvent, now time.Time) error {
+	if len(events) == 0 || len(events) > maxUsageEventsPerRequest {
+		return fmt.Errorf("events must contain between 1 and %d entries", maxUsageEventsPerRequest)
+	}
+
+	for i := range events {
+		event := &events[i]
+		if event.OccurredAt.IsZero() {
+			event.OccurredAt = now
+		}
+		switch {
+		case event.Messages < 0 || event.Tokens < 0 || event.LatencyMS < 0:
+			return fmt.Errorf("event %d: counts and latency must not be negative", i)
+		case event.OccurredAt.After(now.Add(usageMaxSkew)):
+			return fmt.Errorf("event %d: occurred_at is in the future", i)
+		case event.OccurredAt.Before(now.Add(-usageMaxAge)):
+			return fmt.Errorf("event %d: occurred_at is more than %d days old", i, int(usageMaxAge.Hours()/24))
+		}
+	}
+	return nil
+}
+
+// GetUsage returns the customer's headline activity figures and a usage series
+// for the dashboard. Query: days (1-90, default 7) and granularity (hour or day).
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	days := 7
+	if raw := c.Query("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUsageDays {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   "invalid_request",
+				Message: fmt.Sprintf("days must be between 1 and %d", maxUsageDays),
+			})
+			return
+		}
+		days = n
+	}
+
+	granularity := c.DefaultQuery("granularity", "day")
+	size := map[string]time.Duration{"hour": time.Hour, "day": 24 * time.Hour}[granularity]
+	if size == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "invalid_request",
+			Message: "granularity must be hour or day",
+		})
+		return
+	}
+
+	if _, err := h.db.GetCustomerByID(ctx, id); err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Customer not found",
+		})
+		return
+	}
+
+	now := time.Now()
+	summary, err := h.db.GetUsageSummary(ctx, id, now)
+	if err != nil {
+		h.usageQueryFailed(c, id, err)
+		return
+	}
+
+	series, err := h.db.ListUsage(ctx, id, now.AddDate(0, 0, -days), size)
+	if err != nil {
+		h.usageQueryFailed(c, id, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": id,
+		"summary":     summary,
+		"granularity": granularity,
+		"series":      series,
+	})
+}
+
+func (h *UsageHandler) usageQueryFailed(c *gin.Context, id string, err error) {
+	h.logger.Error("Failed to query usage", zap.String("customer_id", id), zap.Error(err))
+	c.JSON(http.StatusInternalServerError, ErrorResponse{
+		Error:   "internal_error",
+		Message: "Failed to retrieve usage",
+	})
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+func postUsage(router *gin.Engine, token string, body any) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/usage/events", bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIngestUsageRequiresGatewayToken(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.SetGatewayToken(t.Context(), customer.ID, "gw-token"))
+
+	body := UsageEventsRequest{Events: []db.UsageEvent{{Messages: 1}}}
+
+	assert.Equal(t, http.StatusUnauthorized, postUsage(router, "", body).Code)
+	assert.Equal(t, http.StatusUnauthorized, postUsage(router, "wrong", body).Code)
+	// Dashboard tokens cannot impersonate an agent
+	assert.Equal(t, http.StatusUnauthorized, postUsage(router, "alice-token", body).Code)
+	assert.Equal(t, http.StatusUnauthorized, postUsage(router, testAdminKey, body).Code)
+
+	w := postUsage(router, "gw-token", body)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.JSONEq(t, `{"accepted":1}`, w.Body.String())
+}
+
+func TestIngestUsageValidation(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	customer := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	require.NoError(t, database.SetGatewayToken(t.Context(), customer.ID, "gw-token"))
+
+	now := time.Now()
+	tooMany := make([]db.UsageEvent, maxUsageEventsPerRequest+1)
+
+	tests := []struct {
+		name   string
+		events []db.UsageEvent
+	}{
+		{"empty batch", nil},
+		{"too many events", tooMany},
+		{"negative messages", []db.UsageEvent{{Messages: -1}}},
+		{"negative latency", []db.UsageEvent{{Messages: 1, LatencyMS: -5}}},
+		{"future timestamp", []db.UsageEvent{{OccurredAt: now.Add(time.Hour), Messages: 1}}},
+		{"stale timestamp", []db.UsageEvent{{OccurredAt: now.AddDate(0, 0, -8), Messages: 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postUsage(router, "gw-token", UsageEventsRequest{Events: tt.events})
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+
+	summary, err := database.GetUsageSummary(t.Context(), customer.ID, now)
+	require.NoError(t, err)
+	assert.Zero(t, summary.MessagesTotal, "rejected batches record nothing")
+}
+
+func TestGetUsage(t *testing.T) {
+	router, database := setupAuthTestServer(t)
+	alice := createAuthedCustomer(t, database, "alice@example.com", "alice-token")
+	createAuthedCustomer(t, database, "bob@example.com", "bob-token")
+	require.NoError(t, database.SetGatewayToken(t.Context(), alice.ID, "gw-token"))
+
+	now := time.Now()
+	w := postUsage(router, "gw-token", UsageEventsRequest{Events: []db.UsageEvent{
+		{OccurredAt: now.Add(-time.Minute), Messages: 3, Tokens: 300, LatencyMS: 100},
+		{OccurredAt: now.Add(-2 * time.Minute), Messages: 1, Tokens: 50, LatencyMS: 300},
+	}})
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	path := "/api/customers/" + alice.ID + "/usage"
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", path, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", path, "bob-token").Code)
+
+	w = doAuthed(router, "GET", path+"?granularity=hour&days=1", "alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		CustomerID  string           `json:"customer_id"`
+		Summary     db.UsageSummary  `json:"summary"`
+		Granularity string           `json:"granularity"`
+		Series      []db.UsageBucket `json:"series"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, alice.ID, resp.CustomerID)
+	assert.Equal(t, "hour", resp.Granularity)
+	assert.Equal(t, 4, resp.Summary.MessagesTotal)
+	assert.Equal(t, 350, resp.Summary.TokensTotal)
+	require.NotNil(t, resp.Summary.AvgLatencyMS)
+	assert.Equal(t, 200.0, *resp.Summary.AvgLatencyMS)
+	require.NotNil(t, resp.Summary.LastActiveAt)
+
+	var messages int
+	for _, bucket := range resp.Series {
+		messages += bucket.Messages
+	}
+	assert.Equal(t, 4, messages)
+
+	for _, query := range []string{"?days=0", "?days=91", "?days=abc", "?granularity=minute"} {
+		w = doAuthed(router, "GET", path+query, "alice-token")
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestGetStacksRankedByUsage(t *testing.T) {
+	router, database := setupMarketplaceTest(t)
+	ctx := t.Context()
+
+	getStacks := func() ([]Stack, string) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/marketplace/stacks", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp struct {
+			Stacks   []Stack `json:"stacks"`
+			RankedBy string  `json:"ranked_by"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Stacks, resp.RankedBy
+	}
+
+	recommended := func(stacks []Stack) []string {
+		var names []string
+		for _, stack := range stacks {
+			if stack.Recommended {
+				names = append(names, stack.Name)
+			}
+		}
+		return names
+	}
+
+	// Without usage the curated order and default recommendation are kept
+	stacks, rankedBy := getStacks()
+	assert.Equal(t, "curated", rankedBy)
+	assert.Equal(t, curatedStacks[0].Name, stacks[0].Name)
+	assert.Equal(t, []string{curatedStacks[0].Name}, recommended(stacks))
+
+	addTenant := func(email, agent, llm string, messages int) {
+		customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+			Email:         email,
+			AgentTypeID:   agent,
+			LLMProviderID: llm,
+		})
+		require.NoError(t, err)
+		require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+		require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{
+			{OccurredAt: time.Now(), Messages: messages},
+		}))
+	}
+	addTenant("a@example.com", "myrai", "groq", 50)
+	addTenant("b@example.com", "myrai", "anthropic", 20)
+	addTenant("c@example.com", "openclaw", "anthropic", 10)
+
+	stacks, rankedBy = getStacks()
+	assert.Equal(t, "usage", rankedBy)
+	require.Len(t, stacks, len(curatedStacks)+1)
+
+	assert.Equal(t, "Myrai + Groq", stacks[0].Name)
+	assert.Equal(t, 50, stacks[0].Messages)
+	assert.Equal(t, 1, stacks[0].Tenants)
+	assert.NotEmpty(t, stacks[0].Description, "curated descriptions are kept")
+	assert.Equal(t, []string{"Myrai + Groq"}, recommended(stacks))
+
+	// Combinations outside the curated list are named after the marketplace entries
+	assert.Equal(t, "Myrai + Anthropic", stacks[1].Name)
+	assert.Equal(t, "myrai", stacks[1].AgentID)
+	assert.Equal(t, "anthropic", stacks[1].LLMID)
+
+	assert.Equal(t, "OpenClaw + Claude", stacks[2].Name)
+	assert.Equal(t, 10, stacks[2].Messages)
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+)
+
+// waitlistInviteTTL is how long a waitlist invitation may be claimed
+func waitlistInviteTTL(cfg *config.Config) time.Duration {
+	return time.Duration(cfg.WaitlistInviteHours) * time.Hour
+}
+
+// WaitlistResponse describes a signup's place on the waitlist
+type WaitlistResponse struct {
+	WaitlistID string `json:"waitlist_id"`
+	Email      string `json:"email"`
+	Status     string `json:"status"`
+	// Position is the 1-based place in the queue while waiting
+	Position int `json:"position,omitempty"`
+	// InviteExpiresAt is when an invitation must be claimed by
+	InviteExpiresAt *time.Time `json:"invite_expires_at,omitempty"`
+}
+
+func newWaitlistResponse(entry *db.WaitlistEntry) WaitlistResponse {
+	return WaitlistResponse{
+		WaitlistID:      entry.ID,
+		Email:           entry.Email,
+		Status:          entry.Status,
+		Position:        entry.Position,
+		InviteExpiresAt: entry.InviteExpiresAt,
+	}
+}
+
+// joinWaitlist queues a validated signup received at capacity
+func (h *Handler) joinWaitlist(c *gin.Context, req *CreateCustomerRequest) {
+	entry := &db.WaitlistEntry{
+		Email:              req.Email,
+		AssistantName:      req.AssistantName,
+		CustomInstructions: req.CustomInstructions,
+		TelegramBotToken:   req.TelegramBotToken,
+		AgentTypeID:        req.AgentTypeID,
+		LLMProviderID:      req.LLMProviderID,
+		PlanID:             req.PlanID,
+		PromoCode:          req.PromoCode,
+	}
+
+	if err := h.db.AddToWaitlist(c.Request.Context(), entry); err != nil {
+		h.logger.Error("Failed to add to waitlist", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to join the waitlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, newWaitlistResponse(entry))
+}
+
+// GetWaitlistEntry reports a waitlisted signup's position, or its invitation
+func (h *Handler) GetWaitlistEntry(c *gin.Context) {
+	entry, err := h.db.GetWaitlistEntry(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Waitlist entry not found",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, newWaitlistResponse(entry))
+}
+
+// ClaimWaitlistInvite completes the signup of an invited waitlist entry,
+// returning the same response as /api/signup. The stored request is validated
+// again since the plan, promo code or bot token may have changed meanwhile.
+func (h *Handler) ClaimWaitlistInvite(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	entry, err := h.db.GetWaitlistEntry(ctx, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Waitlist entry not found",
+		})
+		return
+	}
+
+	if entry.Status != db.WaitlistInvited {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "not_invited",
+			Message: "This signup is still on the waitlist",
+		})
+		return
+	}
+
+	if entry.InviteExpiresAt != nil && time.Now().After(*entry.InviteExpiresAt) {
+		c.JSON(http.StatusGone, ErrorResponse{
+			Error:   "invite_expired",
+			Message: "This invitation has expired",
+		})
+		return
+	}
+
+	req := &CreateCustomerRequest{
+		Email:              entry.Email,
+		AssistantName:      entry.AssistantName,
+		CustomInstructions: entry.CustomInstructions,
+		TelegramBotToken:   entry.TelegramBotToken,
+		AgentTypeID:        entry.AgentTypeID,
+		LLMProviderID:      entry.LLMProviderID,
+		PlanID:             entry.PlanID,
+		PromoCode:          entry.PromoCode,
+	}
+
+	signup, ok := h.validateSignup(c, req)
+	if !ok {
+		return
+	}
+
+	if h.completeSignup(c, req, signup) == nil {
+		return
+	}
+
+	// The new pending customer now holds the slot the invitation reserved
+	if err := h.db.DeleteWaitlistEntry(ctx, entry.ID); err != nil {
+		h.logger.Error("Failed to remove claimed waitlist entry", zap.String("waitlist_id", entry.ID), zap.Error(err))
+	}
+}
+
+// ListWaitlist returns open invitations followed by the queue in order
+func (h *Handler) ListWaitlist(c *gin.Context) {
+	entries, err := h.db.ListWaitlist(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to list waitlist", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to list the waitlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"count":   len(entries),
+	})
+}
+
+// MoveWaitlistEntryRequest is the body of POST /api/admin/waitlist/:id/position
+type MoveWaitlistEntryRequest struct {
+	Position int `json:"position" binding:"required,min=1"`
+}
+
+// MoveWaitlistEntry places a waiting entry at a new position in the queue
+func (h *Handler) MoveWaitlistEntry(c *gin.Context) {
+	var req MoveWaitlistEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   "validation_failed",
+			Message: "position must be a positive integer",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	entry, err := h.db.GetWaitlistEntry(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   "not_found",
+			Message: "Waitlist entry not found",
+		})
+		return
+	}
+
+	if entry.Status != db.WaitlistWaiting {
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error:   "already_invited",
+			Message: "Invited entries are no longer queued",
+		})
+		return
+	}
+
+	if err := h.db.MoveWaitlistEntry(ctx, id, req.Position); err != nil {
+		h.logger.Error("Failed to move waitlist entry", zap.String("waitlist_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to reorder the waitlist",
+		})
+		return
+	}
+
+	entry, err = h.db.GetWaitlistEntry(ctx, id)
+	if err != nil {
+		h.logger.Error("Failed to get waitlist entry", zap.String("waitlist_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to reorder the waitlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/config"
+	"blytz/internal/db"
+	"blytz/internal/provisioner"
+	"blytz/internal/stripe"
+	"blytz/internal/telegram"
+	"blytz/internal/waitlist"
+)
+
+// acceptingBotProvisioner accepts every bot token without calling Telegram
+type acceptingBotProvisioner struct {
+	provisioner.Provisioner
+}
+
+func (acceptingBotProvisioner) ValidateBotToken(token string) (*telegram.BotInfo, error) {
+	info := &telegram.BotInfo{OK: true}
+	info.Result.Username = "test_bot"
+	return info, nil
+}
+
+func postJSON(router *gin.Engine, path, token string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func signupBody(email string) map[string]string {
+	return map[string]string{
+		"email":               email,
+		"assistant_name":      "Test",
+		"custom_instructions": "Help me",
+		"telegram_bot_token":  "123:abc",
+		"plan_id":             "pro",
+	}
+}
+
+func TestWaitlist(t *testing.T) {
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"cs_new","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_new"}`))
+	})
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	ctx := t.Context()
+
+	cfg := &config.Config{
+		MaxCustomers:   1,
+		PortRangeStart: 30000,
+		PortRangeEnd:   30999,
+		CustomersDir:   t.TempDir(),
+		AdminAPIKey:    testAdminKey,
+	}
+	wl := waitlist.NewService(database, cfg.MaxCustomers, time.Hour, zap.NewNop())
+	stripeSvc := stripe.NewService("sk-test", "price-test")
+	router := NewRouter(database, acceptingBotProvisioner{}, stripeSvc, stripe.NewWebhookHandler(database, nil, "whsec-test"),
+		cfg, zap.NewNop(), WithWaitlist(wl))
+
+	existing := createAuthedCustomer(t, database, "existing@example.com", "existing-token")
+
+	w := postJSON(router, "/api/signup", "", signupBody("first@example.com"))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var first WaitlistResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Equal(t, 1, first.Position)
+
+	w = postJSON(router, "/api/signup", "", signupBody("second@example.com"))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	var second WaitlistResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &second))
+	assert.Equal(t, 2, second.Position)
+
+	w = postJSON(router, "/api/signup", "", signupBody("first@example.com"))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "already_waitlisted")
+
+	// Admins see and reorder the queue; bot tokens stay private
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(router, "GET", "/api/admin/waitlist", "existing-token").Code)
+	w = doAuthed(router, "GET", "/api/admin/waitlist", testAdminKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "123:abc")
+
+	movePath := "/api/admin/waitlist/" + second.WaitlistID + "/position"
+	assert.Equal(t, http.StatusBadRequest, postJSON(router, movePath, testAdminKey, map[string]int{"position": 0}).Code)
+	assert.Equal(t, http.StatusNotFound, postJSON(router, "/api/admin/waitlist/missing/position", testAdminKey, map[string]int{"position": 1}).Code)
+	w = postJSON(router, movePath, testAdminKey, map[string]int{"position": 1})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = doAuthed(router, "GET", "/api/waitlist/"+first.WaitlistID, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var status WaitlistResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 2, status.Position)
+
+	// Claiming before an invitation is refused
+	assert.Equal(t, http.StatusConflict, doAuthed(router, "POST", "/api/waitlist/"+second.WaitlistID+"/claim", "").Code)
+
+	// A freed slot invites the head of the queue
+	require.NoError(t, database.UpdateCustomerStatus(ctx, existing.ID, "terminated"))
+	invited, err := wl.InviteAvailable(ctx)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Equal(t, second.WaitlistID, invited[0].ID)
+
+	w = doAuthed(router, "GET", "/api/waitlist/"+second.WaitlistID, "")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, db.WaitlistInvited, status.Status)
+	require.NotNil(t, status.InviteExpiresAt)
+	assert.Equal(t, http.StatusConflict, postJSON(router, movePath, testAdminKey, map[string]int{"position": 2}).Code)
+
+	// New signups queue behind the waitlist even though the invitation is unclaimed
+	w = postJSON(router, "/api/signup", "", signupBody("third@example.com"))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	w = doAuthed(router, "POST", "/api/waitlist/"+second.WaitlistID+"/claim", "")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created CreateCustomerResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "second@example.com", created.Email)
+	assert.Equal(t, "https://checkout.stripe.com/c/pay/cs_new", created.CheckoutURL)
+	assert.NotEmpty(t, created.AccessToken)
+
+	customer, err := database.GetCustomerByID(ctx, created.CustomerID)
+	require.NoError(t, err)
+	assert.Equal(t, "pro", customer.PlanID)
+	require.NotNil(t, customer.TelegramBotUsername)
+	assert.Equal(t, "test_bot", *customer.TelegramBotUsername)
+
+	// The claimed entry is gone
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "GET", "/api/waitlist/"+second.WaitlistID, "").Code)
+	assert.Equal(t, http.StatusNotFound, doAuthed(router, "POST", "/api/waitlist/"+second.WaitlistID+"/claim", "").Code)
+}
+
+func TestClaimExpiredInvite(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	router, _ := setupAuthTestServerWithDB(t, database)
+
+	entry := &db.WaitlistEntry{Email: "late@example.com", AssistantName: "Test", CustomInstructions: "Help me", TelegramBotToken: "123:abc"}
+	require.NoError(t, database.AddToWaitlist(t.Context(), entry))
+	expired := time.Now().Add(-time.Minute)
+	_, err = database.InviteFromWaitlist(t.Context(), 1, &expired)
+	require.NoError(t, err)
+
+	w := doAuthed(router, "POST", "/api/waitlist/"+entry.ID+"/claim", "")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "invite_expired"))
+}
@@ -0,0 +1,71 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestWriteAndExtract(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"docker-compose.yml":          "services: {}",
+		".openclaw/workspace/SOUL.md": "# Soul",
+		"data/state.db":               "state",
+	})
+
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+		skip  []string
+	}{
+		{name: "whole directory", want: []string{"docker-compose.yml", ".openclaw/workspace/SOUL.md", "data/state.db"}},
+		{name: "selected paths", paths: []string{"docker-compose.yml", ".openclaw", "missing"},
+			want: []string{"docker-compose.yml", ".openclaw/workspace/SOUL.md"}, skip: []string{"data/state.db"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, Write(&buf, src, tt.paths...))
+
+			dst := t.TempDir()
+			require.NoError(t, Extract(&buf, dst))
+			for _, name := range tt.want {
+				assert.FileExists(t, filepath.Join(dst, name))
+			}
+			for _, name := range tt.skip {
+				assert.NoFileExists(t, filepath.Join(dst, name))
+			}
+		})
+	}
+}
+
+func TestExtractRejectsEscapingEntries(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape.txt", Mode: 0644, Typeflag: tar.TypeReg}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	dir := filepath.Join(t.TempDir(), "tenant")
+	assert.Error(t, Extract(&buf, dir))
+	assert.NoFileExists(t, filepath.Join(filepath.Dir(dir), "escape.txt"))
+}
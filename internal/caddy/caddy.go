@@ -1,23 +1,113 @@
+// Package caddy manages tenant routes and TLS policies through Caddy's admin
+// API. Every object the platform manages carries an @id derived from its
+// tenant, so it is replaced and deleted by ID rather than by its position in
+// a list other writers may be editing.
 package caddy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
+
+	"blytz/internal/circuitbreaker"
 )
 
+// ErrRouteNotFound is returned when Caddy has no route or TLS policy with an ID
+var ErrRouteNotFound = errors.New("route not found")
+
+// managedPrefix starts the @id of every object the platform manages. Routes
+// and policies without it, such as those from the Caddyfile, are left alone.
+const managedPrefix = "blytz-"
+
+const policiesPath = "/config/apps/tls/automation/policies"
+
+// Client manages routes and TLS policies through Caddy's admin API
 type Client struct {
 	adminURL string
+	server   string
+	// domainServer serves custom domains over TLS
+	domainServer string
+	token        string
+	http         *http.Client
+	breaker      *circuitbreaker.CircuitBreaker
+	attempts     int
+	backoff      time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithServer sets the name of the HTTP server tenant routes are added to
+func WithServer(name string) Option {
+	return func(c *Client) {
+		c.server = name
+	}
+}
+
+// WithDomainServer sets the name of the HTTPS server custom domain routes are
+// added to. It needs TLS connection policies, so Caddy completes handshakes
+// for the domains' on-demand certificates.
+func WithDomainServer(name string) Option {
+	return func(c *Client) {
+		c.domainServer = name
+	}
+}
+
+// WithToken sends a bearer token with every request, for admin endpoints
+// behind an authenticating proxy
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
 }
 
-func NewClient(adminURL string) *Client {
-	return &Client{
-		adminURL: adminURL,
+// WithTimeout limits how long a single request to the admin API may take
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.http.Timeout = timeout
 	}
 }
 
+// WithRetries sets how many times a request is attempted when Caddy is
+// unreachable or fails, and the backoff before the first retry, which
+// doubles on each retry after it
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.attempts = attempts
+		c.backoff = backoff
+	}
+}
+
+// NewClient creates a client for the admin API at adminURL. By default tenant
+// routes go to the server srv0 and custom domain routes to the server
+// domains, requests time out after 10 seconds and are attempted 3 times.
+func NewClient(adminURL string, opts ...Option) *Client {
+	c := &Client{
+		adminURL:     strings.TrimSuffix(adminURL, "/"),
+		server:       "srv0",
+		domainServer: "domains",
+		http:         &http.Client{Timeout: 10 * time.Second},
+		breaker:      circuitbreaker.NewWithDefaults(),
+		attempts:     3,
+		backoff:      200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.attempts < 1 {
+		c.attempts = 1
+	}
+	return c
+}
+
 type Route struct {
+	ID     string    `json:"@id,omitempty"`
 	Handle []Handler `json:"handle"`
 	Match  []Match   `json:"match,omitempty"`
 }
@@ -36,8 +126,34 @@ type Match struct {
 	Host []string `json:"host"`
 }
 
-func (c *Client) AddSubdomain(subdomain, target string) error {
-	route := Route{
+// TLSPolicy is a TLS automation policy. On-demand policies obtain a host's
+// certificate during its first TLS handshake, once Caddy's on_demand_tls ask
+// endpoint approves the host.
+type TLSPolicy struct {
+	ID       string   `json:"@id,omitempty"`
+	Subjects []string `json:"subjects,omitempty"`
+	OnDemand bool     `json:"on_demand,omitempty"`
+}
+
+// TenantRouteID is the @id of the route serving a tenant's subdomain
+func TenantRouteID(customerID string) string {
+	return managedPrefix + "tenant-" + customerID
+}
+
+// DomainRouteID is the @id of the route serving a tenant's custom domain
+func DomainRouteID(customerID string) string {
+	return managedPrefix + "domain-" + customerID
+}
+
+// DomainPolicyID is the @id of the TLS policy for a tenant's custom domain
+func DomainPolicyID(customerID string) string {
+	return managedPrefix + "tls-" + customerID
+}
+
+// NewRoute returns a route with the given @id proxying host to target
+func NewRoute(id, host, target string) Route {
+	return Route{
+		ID: id,
 		Handle: []Handler{
 			{
 				Handler: "reverse_proxy",
@@ -48,112 +164,398 @@ func (c *Client) AddSubdomain(subdomain, target string) error {
 		},
 		Match: []Match{
 			{
-				Host: []string{subdomain},
+				Host: []string{host},
 			},
 		},
 	}
+}
 
-	data, err := json.Marshal(route)
-	if err != nil {
-		return fmt.Errorf("marshal route: %w", err)
+// NewDomainPolicy returns the on-demand TLS policy for a tenant's custom domain
+func NewDomainPolicy(customerID, domain string) TLSPolicy {
+	return TLSPolicy{ID: DomainPolicyID(customerID), Subjects: []string{domain}, OnDemand: true}
+}
+
+// UpsertRoute replaces the route with the same @id in a single config change,
+// so requests go either to the old target or to the new one. A route Caddy
+// does not have yet is added ahead of the existing routes.
+func (c *Client) UpsertRoute(ctx context.Context, route Route) error {
+	return c.upsertRoute(ctx, c.routesPath(), route)
+}
+
+func (c *Client) upsertRoute(ctx context.Context, path string, route Route) error {
+	if err := c.upsert(ctx, path, route.ID, route); err != nil {
+		return fmt.Errorf("upsert route %s: %w", route.ID, err)
 	}
+	return nil
+}
 
-	url := fmt.Sprintf("%s/config/apps/http/servers/srv0/routes", c.adminURL)
-	resp, err := http.Post(url, "application/json", bytes.NewReader(data))
-	if err != nil {
-		return fmt.Errorf("add route: %w", err)
+// DeleteRoute removes the route with the given @id. It returns
+// ErrRouteNotFound if Caddy has no such route.
+func (c *Client) DeleteRoute(ctx context.Context, id string) error {
+	if err := c.deleteID(ctx, id); err != nil {
+		return fmt.Errorf("delete route %s: %w", id, err)
+	}
+	return nil
+}
+
+// AddDomain routes a tenant's custom domain to target on the HTTPS server and
+// adds an on-demand TLS policy for it. Adding it again points it at the new
+// target.
+func (c *Client) AddDomain(ctx context.Context, customerID, domain, target string) error {
+	if err := c.upsertRoute(ctx, c.domainRoutesPath(), NewRoute(DomainRouteID(customerID), domain, target)); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("add route failed: %s", resp.Status)
+	policy := NewDomainPolicy(customerID, domain)
+	if err := c.upsert(ctx, policiesPath, policy.ID, policy); err != nil {
+		if err := c.DeleteRoute(ctx, DomainRouteID(customerID)); err != nil && !errors.Is(err, ErrRouteNotFound) {
+			return fmt.Errorf("remove route after failed tls policy: %w", err)
+		}
+		return fmt.Errorf("upsert tls policy %s: %w", policy.ID, err)
+	}
+
+	return nil
+}
+
+// RemoveDomain removes a tenant's custom domain route and TLS policy. Either
+// may already be gone; it returns ErrRouteNotFound only if both are.
+func (c *Client) RemoveDomain(ctx context.Context, customerID string) error {
+	routeErr := c.DeleteRoute(ctx, DomainRouteID(customerID))
+	if routeErr != nil && !errors.Is(routeErr, ErrRouteNotFound) {
+		return routeErr
+	}
+
+	if err := c.deleteID(ctx, DomainPolicyID(customerID)); err != nil {
+		if errors.Is(err, ErrRouteNotFound) {
+			return routeErr
+		}
+		return fmt.Errorf("delete tls policy %s: %w", DomainPolicyID(customerID), err)
 	}
 
 	return nil
 }
 
-func (c *Client) RemoveSubdomain(subdomain string) error {
-	// First, get all routes
-	url := fmt.Sprintf("%s/config/apps/http/servers/srv0/routes", c.adminURL)
+// Sync makes the tenant routes, custom domain routes and TLS policies the
+// platform manages exactly the given ones: missing ones are added, changed
+// ones replaced and the rest removed. Unmanaged routes and policies are kept
+// after the managed ones. Nothing is written when Caddy already matches.
+func (c *Client) Sync(ctx context.Context, routes, domainRoutes []Route, policies []TLSPolicy) error {
+	if err := c.syncRoutes(ctx, c.routesPath(), routes); err != nil {
+		return err
+	}
+	if err := c.syncRoutes(ctx, c.domainRoutesPath(), domainRoutes); err != nil {
+		return err
+	}
 
-	resp, err := http.Get(url)
+	desiredPolicies, err := marshalItems(len(policies), func(i int) interface{} { return policies[i] })
 	if err != nil {
-		return fmt.Errorf("get routes: %w", err)
+		return fmt.Errorf("marshal tls policies: %w", err)
+	}
+	if err := c.editList(ctx, policiesPath, replaceManaged(desiredPolicies)); err != nil {
+		return fmt.Errorf("sync tls policies: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("get routes failed: %s", resp.Status)
+	return nil
+}
+
+// Stats returns the circuit breaker statistics of the admin API
+func (c *Client) Stats() map[string]interface{} {
+	return c.breaker.Stats()
+}
+
+func (c *Client) syncRoutes(ctx context.Context, path string, routes []Route) error {
+	desired, err := marshalItems(len(routes), func(i int) interface{} { return routes[i] })
+	if err != nil {
+		return fmt.Errorf("marshal routes: %w", err)
 	}
+	if err := c.editList(ctx, path, replaceManaged(desired)); err != nil {
+		return fmt.Errorf("sync routes: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) routesPath() string {
+	return "/config/apps/http/servers/" + c.server + "/routes"
+}
+
+func (c *Client) domainRoutesPath() string {
+	return "/config/apps/http/servers/" + c.domainServer + "/routes"
+}
 
-	// Parse routes
-	var routes []Route
-	if err := json.NewDecoder(resp.Body).Decode(&routes); err != nil {
-		return fmt.Errorf("decode routes: %w", err)
+// upsert replaces the object with the given @id, or puts it first in the list
+// at path if Caddy has none, so it matches before catch-all routes
+func (c *Client) upsert(ctx context.Context, path, id string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
 	}
 
-	// Find route index by subdomain
-	var routeIndex *int
-	for i, route := range routes {
-		for _, match := range route.Match {
-			for _, host := range match.Host {
-				if host == subdomain {
-					idx := i
-					routeIndex = &idx
-					break
-				}
+	resp, err := c.do(ctx, http.MethodPatch, "/id/"+id, data, "")
+	if err != nil {
+		return err
+	}
+	switch resp.status {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return resp.error("replace")
+	}
+
+	return c.editList(ctx, path, func(items []json.RawMessage) []json.RawMessage {
+		edited := []json.RawMessage{data}
+		for _, item := range items {
+			if itemID(item) != id {
+				edited = append(edited, item)
+			}
+		}
+		return edited
+	})
+}
+
+// deleteID removes the object with the given @id
+func (c *Client) deleteID(ctx context.Context, id string) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/id/"+id, nil, "")
+	if err != nil {
+		return err
+	}
+	switch resp.status {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrRouteNotFound, id)
+	default:
+		return resp.error("delete")
+	}
+}
+
+// editList rewrites the list at path with edit's result. The write only
+// applies if the list is unchanged since it was read; when another writer
+// got there first, the list is read and edited again. A list Caddy cannot
+// read because its parents are missing, such as TLS policies before any TLS
+// app is configured, is created along with them.
+func (c *Client) editList(ctx context.Context, path string, edit func([]json.RawMessage) []json.RawMessage) error {
+	for attempt := 1; ; attempt++ {
+		resp, err := c.do(ctx, http.MethodGet, path, nil, "")
+		if err != nil {
+			return err
+		}
+		// Caddy cannot traverse a path whose parents are missing
+		if resp.status == http.StatusBadRequest || resp.status == http.StatusNotFound {
+			edited := edit(nil)
+			if len(edited) == 0 {
+				return nil
+			}
+			resp, err := c.createList(ctx, path, edited)
+			if err != nil {
+				return err
+			}
+			if resp.status == http.StatusOK {
+				return nil
 			}
-			if routeIndex != nil {
-				break
+			if attempt >= c.attempts {
+				return resp.error("create")
 			}
+			continue
 		}
-		if routeIndex != nil {
+		if resp.status != http.StatusOK {
+			return resp.error("read")
+		}
+
+		// An unset list reads as null
+		var items []json.RawMessage
+		if err := json.Unmarshal(resp.body, &items); err != nil {
+			return fmt.Errorf("decode %s: %w", path, err)
+		}
+		edited := edit(items)
+		if sameItems(items, edited) {
+			return nil
+		}
+
+		data, err := json.Marshal(edited)
+		if err != nil {
+			return fmt.Errorf("marshal %s: %w", path, err)
+		}
+		// PATCH only replaces a value that exists; POST creates it
+		method := http.MethodPatch
+		if items == nil {
+			method = http.MethodPost
+		}
+		resp, err = c.do(ctx, method, path, data, resp.etag)
+		if err != nil {
+			return err
+		}
+		if resp.status == http.StatusOK {
+			return nil
+		}
+		if resp.status != http.StatusPreconditionFailed || attempt >= c.attempts {
+			return resp.error("write")
+		}
+	}
+}
+
+// createList creates the list at path holding items, together with whichever
+// of its parent objects Caddy does not have. The write fails if the first
+// missing parent exists by the time it is written, as when another writer
+// created it first.
+func (c *Client) createList(ctx context.Context, path string, items []json.RawMessage) (*response, error) {
+	var value interface{} = items
+	parent, key := splitPath(path)
+	for parent != "/config" {
+		resp, err := c.do(ctx, http.MethodGet, parent, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if resp.status == http.StatusOK && strings.TrimSpace(string(resp.body)) != "null" {
 			break
 		}
+		value = map[string]interface{}{key: value}
+		parent, key = splitPath(parent)
 	}
 
-	if routeIndex == nil {
-		return fmt.Errorf("route not found for subdomain: %s", subdomain)
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s: %w", path, err)
 	}
+	// PUT only creates a value that does not exist yet
+	return c.do(ctx, http.MethodPut, parent+"/"+key, data, "")
+}
+
+// splitPath splits a config path into its parent and last key
+func splitPath(path string) (string, string) {
+	i := strings.LastIndex(path, "/")
+	return path[:i], path[i+1:]
+}
 
-	// Delete the specific route using index
-	deleteURL := fmt.Sprintf("%s/config/apps/http/servers/srv0/routes/%d", c.adminURL, *routeIndex)
+// response is an admin API response Caddy answered without a server error
+type response struct {
+	status int
+	etag   string
+	body   []byte
+}
 
-	req, err := http.NewRequest(http.MethodDelete, deleteURL, nil)
+func (r *response) error(action string) error {
+	return fmt.Errorf("%s failed: %d %s", action, r.status, strings.TrimSpace(string(r.body)))
+}
+
+// do sends a request to the admin API through the circuit breaker. Transport
+// errors and 5xx responses are retried with backoff and, once the attempts
+// run out, count against the breaker. Other responses are returned for the
+// caller to interpret.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, ifMatch string) (*response, error) {
+	var resp *response
+	err := c.breaker.Execute(ctx, func() error {
+		var err error
+		for attempt := 0; attempt < c.attempts; attempt++ {
+			if attempt > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(c.backoff << (attempt - 1)):
+				}
+			}
+
+			resp, err = c.send(ctx, method, path, body, ifMatch)
+			if err == nil && resp.status < 500 {
+				return nil
+			}
+			if err == nil {
+				err = resp.error(method + " " + path)
+			}
+		}
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("create delete request: %w", err)
+		return nil, fmt.Errorf("caddy admin api: %w", err)
 	}
+	return resp, nil
+}
 
-	resp, err = http.DefaultClient.Do(req)
+func (c *Client) send(ctx context.Context, method, path string, body []byte, ifMatch string) (*response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.adminURL+path, reader)
 	if err != nil {
-		return fmt.Errorf("delete route: %w", err)
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("delete route failed: %s", resp.Status)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
 	}
+	defer res.Body.Close()
 
-	return nil
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	return &response{status: res.StatusCode, etag: res.Header.Get("Etag"), body: data}, nil
 }
 
-func (c *Client) Reload() error {
-	url := fmt.Sprintf("%s/load", c.adminURL)
-
-	req, err := http.NewRequest("POST", url, nil)
-	if err != nil {
-		return fmt.Errorf("create reload request: %w", err)
+// replaceManaged returns a list edit that puts desired first, followed by the
+// unmanaged items in their current order
+func replaceManaged(desired []json.RawMessage) func([]json.RawMessage) []json.RawMessage {
+	return func(items []json.RawMessage) []json.RawMessage {
+		edited := append([]json.RawMessage{}, desired...)
+		for _, item := range items {
+			if !strings.HasPrefix(itemID(item), managedPrefix) {
+				edited = append(edited, item)
+			}
+		}
+		return edited
 	}
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("reload caddy: %w", err)
+func marshalItems(n int, item func(int) interface{}) ([]json.RawMessage, error) {
+	items := make([]json.RawMessage, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := json.Marshal(item(i))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, data)
 	}
-	defer resp.Body.Close()
+	return items, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("reload failed: %s", resp.Status)
+// itemID returns the @id of a list item, or "" if it has none
+func itemID(item json.RawMessage) string {
+	var object struct {
+		ID string `json:"@id"`
+	}
+	if err := json.Unmarshal(item, &object); err != nil {
+		return ""
 	}
+	return object.ID
+}
 
-	return nil
+// sameItems reports whether two lists hold the same JSON values. Caddy
+// returns objects with their keys sorted, so values are compared decoded.
+func sameItems(a, b []json.RawMessage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		var x, y interface{}
+		if json.Unmarshal(a[i], &x) != nil || json.Unmarshal(b[i], &y) != nil {
+			return false
+		}
+		xs, _ := json.Marshal(x)
+		ys, _ := json.Marshal(y)
+		if !bytes.Equal(xs, ys) {
+			return false
+		}
+	}
+	return true
 }
@@ -1,13 +1,427 @@
 package caddy
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+const (
+	routesPath       = "/config/apps/http/servers/srv0/routes"
+	domainRoutesPath = "/config/apps/http/servers/domains/routes"
+)
+
+// fakeAdmin serves the lists of Caddy's config the client edits, with Caddy's
+// @id addressing and ETag/If-Match checks. Like Caddy, it cannot traverse
+// paths whose parent objects are missing.
+type fakeAdmin struct {
+	mu       sync.Mutex
+	lists    map[string][]json.RawMessage
+	versions map[string]int
+	// objects holds the paths of the objects in the config
+	objects map[string]bool
+	// listWrites counts successful writes of whole lists
+	listWrites int
+	// conflicts is how many list writes to refuse as if another writer got there first
+	conflicts int
+	// failures is how many requests to answer with a server error
+	failures int
+	auth     string
+}
+
+func newFakeAdmin(t *testing.T) (*fakeAdmin, *httptest.Server) {
+	f := &fakeAdmin{lists: map[string][]json.RawMessage{}, versions: map[string]int{}, objects: map[string]bool{}}
+	for _, path := range []string{
+		"/config", "/config/apps", "/config/apps/http", "/config/apps/http/servers",
+		"/config/apps/http/servers/srv0", "/config/apps/http/servers/domains",
+		"/config/apps/tls", "/config/apps/tls/automation",
+	} {
+		f.objects[path] = true
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// set stores a list the way Caddy does, with object keys sorted
+func (f *fakeAdmin) set(path string, items []json.RawMessage) {
+	stored := []json.RawMessage{}
+	for _, item := range items {
+		var value interface{}
+		json.Unmarshal(item, &value)
+		data, _ := json.Marshal(value)
+		stored = append(stored, data)
+	}
+	f.lists[path] = stored
+	f.versions[path]++
+}
+
+// put stores a value at path the way Caddy does, creating objects and lists
+func (f *fakeAdmin) put(path string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		f.objects[path] = true
+		for key, child := range v {
+			f.put(path+"/"+key, child)
+		}
+	case []interface{}:
+		items := []json.RawMessage{}
+		for _, item := range v {
+			data, _ := json.Marshal(item)
+			items = append(items, data)
+		}
+		f.set(path, items)
+	}
+}
+
+func parent(path string) string {
+	return path[:strings.LastIndex(path, "/")]
+}
+
+func (f *fakeAdmin) etag(path string) string {
+	return fmt.Sprintf(`"%s %d"`, path, f.versions[path])
+}
+
+func (f *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auth = r.Header.Get("Authorization")
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "config busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	if id, ok := strings.CutPrefix(r.URL.Path, "/id/"); ok {
+		for path, items := range f.lists {
+			for i, item := range items {
+				if itemID(item) != id {
+					continue
+				}
+				edited := append([]json.RawMessage{}, items...)
+				switch r.Method {
+				case http.MethodPatch:
+					var data json.RawMessage
+					json.NewDecoder(r.Body).Decode(&data)
+					edited[i] = data
+				case http.MethodDelete:
+					edited = append(edited[:i], edited[i+1:]...)
+				}
+				f.set(path, edited)
+				return
+			}
+		}
+		http.Error(w, `{"error":"unknown object ID"}`, http.StatusNotFound)
+		return
+	}
+
+	path := r.URL.Path
+	if !f.objects[parent(path)] {
+		http.Error(w, `{"error":"invalid traversal path at: `+path+`"}`, http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Etag", f.etag(path))
+		if f.objects[path] {
+			w.Write([]byte("{}"))
+			return
+		}
+		json.NewEncoder(w).Encode(f.lists[path])
+	case http.MethodPut:
+		if _, exists := f.lists[path]; exists || f.objects[path] {
+			http.Error(w, `{"error":"key already exists"}`, http.StatusConflict)
+			return
+		}
+		var value interface{}
+		json.NewDecoder(r.Body).Decode(&value)
+		f.put(path, value)
+		f.listWrites++
+	case http.MethodPatch, http.MethodPost:
+		if r.Header.Get("If-Match") != f.etag(path) {
+			http.Error(w, "If-Match does not match", http.StatusPreconditionFailed)
+			return
+		}
+		if f.conflicts > 0 {
+			f.conflicts--
+			f.versions[path]++
+			http.Error(w, "If-Match does not match", http.StatusPreconditionFailed)
+			return
+		}
+		if _, exists := f.lists[path]; exists == (r.Method == http.MethodPost) {
+			http.Error(w, "PATCH replaces existing values, POST creates them", http.StatusBadRequest)
+			return
+		}
+		var items []json.RawMessage
+		json.NewDecoder(r.Body).Decode(&items)
+		f.set(path, items)
+		f.listWrites++
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+path, http.StatusMethodNotAllowed)
+	}
+}
+
+// ids returns the @id of each item in a list, "" for unmanaged ones
+func (f *fakeAdmin) ids(path string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := []string{}
+	for _, item := range f.lists[path] {
+		ids = append(ids, itemID(item))
+	}
+	return ids
+}
+
+func (f *fakeAdmin) route(id string) Route {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, path := range []string{routesPath, domainRoutesPath} {
+		for _, item := range f.lists[path] {
+			if itemID(item) == id {
+				var route Route
+				json.Unmarshal(item, &route)
+				return route
+			}
+		}
+	}
+	return Route{}
+}
+
+func catchAll() json.RawMessage {
+	return json.RawMessage(`{"handle":[{"handler":"reverse_proxy","upstreams":[{"dial":"frontend:3000"}]}],"terminal":true}`)
+}
+
 func TestClient(t *testing.T) {
-	client := NewClient("http://localhost:2019")
+	client := NewClient("http://localhost:2019/", WithServer("tenants"), WithDomainServer("https"), WithTimeout(time.Second))
 
 	if client.adminURL != "http://localhost:2019" {
 		t.Errorf("Expected admin URL http://localhost:2019, got %s", client.adminURL)
 	}
+	if client.routesPath() != "/config/apps/http/servers/tenants/routes" {
+		t.Errorf("Expected routes of server tenants, got %s", client.routesPath())
+	}
+	if client.domainRoutesPath() != "/config/apps/http/servers/https/routes" {
+		t.Errorf("Expected domain routes of server https, got %s", client.domainRoutesPath())
+	}
+	if client.http.Timeout != time.Second {
+		t.Errorf("Expected a 1s timeout, got %v", client.http.Timeout)
+	}
+}
+
+func TestUpsertRoute(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	fake.set(routesPath, []json.RawMessage{catchAll()})
+	client := NewClient(srv.URL, WithToken("secret"))
+	ctx := t.Context()
+
+	if err := client.UpsertRoute(ctx, NewRoute(TenantRouteID("a"), "a.blytz.cloud", "localhost:30000")); err != nil {
+		t.Fatalf("UpsertRoute: %v", err)
+	}
+	if err := client.UpsertRoute(ctx, NewRoute(TenantRouteID("b"), "b.blytz.cloud", "localhost:30002")); err != nil {
+		t.Fatalf("UpsertRoute: %v", err)
+	}
+	if got, want := fake.ids(routesPath), []string{"blytz-tenant-b", "blytz-tenant-a", ""}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("Expected new routes ahead of the catch-all %v, got %v", want, got)
+	}
+	if fake.auth != "Bearer secret" {
+		t.Errorf("Expected the bearer token to be sent, got %q", fake.auth)
+	}
+
+	writes := fake.listWrites
+	if err := client.UpsertRoute(ctx, NewRoute(TenantRouteID("a"), "a.blytz.cloud", "10.0.0.2:40000")); err != nil {
+		t.Fatalf("UpsertRoute: %v", err)
+	}
+	if fake.listWrites != writes {
+		t.Error("Expected an existing route to be replaced by its ID")
+	}
+	if dial := fake.route(TenantRouteID("a")).Handle[0].Upstreams[0].Dial; dial != "10.0.0.2:40000" {
+		t.Errorf("Expected the route to point at 10.0.0.2:40000, got %s", dial)
+	}
+
+	if err := client.DeleteRoute(ctx, TenantRouteID("a")); err != nil {
+		t.Fatalf("DeleteRoute: %v", err)
+	}
+	if got := fake.ids(routesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-tenant-b", ""}) {
+		t.Errorf("Expected only the deleted route to be gone, got %v", got)
+	}
+	if err := client.DeleteRoute(ctx, TenantRouteID("a")); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("Expected ErrRouteNotFound, got %v", err)
+	}
+}
+
+func TestUpsertRouteConflict(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	fake.set(routesPath, []json.RawMessage{catchAll()})
+	fake.conflicts = 1
+	client := NewClient(srv.URL)
+
+	if err := client.UpsertRoute(t.Context(), NewRoute(TenantRouteID("a"), "a.blytz.cloud", "localhost:30000")); err != nil {
+		t.Fatalf("UpsertRoute: %v", err)
+	}
+	if got := fake.ids(routesPath); len(got) != 2 {
+		t.Errorf("Expected the route to be added after re-reading the list, got %v", got)
+	}
+
+	fake.conflicts = 10
+	if err := client.UpsertRoute(t.Context(), NewRoute(TenantRouteID("b"), "b.blytz.cloud", "localhost:30002")); err == nil {
+		t.Error("Expected an error once every write conflicts")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	fake.set(routesPath, nil)
+	fake.failures = 2
+	client := NewClient(srv.URL, WithRetries(3, time.Millisecond))
+
+	if err := client.UpsertRoute(t.Context(), NewRoute(TenantRouteID("a"), "a.blytz.cloud", "localhost:30000")); err != nil {
+		t.Fatalf("Expected server errors to be retried, got %v", err)
+	}
+
+	// Each request that runs out of attempts counts against the breaker
+	for i := 0; i < 5; i++ {
+		fake.mu.Lock()
+		fake.failures = 3
+		fake.mu.Unlock()
+		if err := client.DeleteRoute(t.Context(), TenantRouteID("a")); err == nil {
+			t.Fatal("Expected an error once the attempts run out")
+		}
+	}
+	if state := client.Stats()["state"]; state != "open" {
+		t.Errorf("Expected the circuit to open, got %v", state)
+	}
+}
+
+func TestCustomDomain(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	fake.set(routesPath, nil)
+	fake.set(domainRoutesPath, nil)
+	fake.set(policiesPath, []json.RawMessage{json.RawMessage(`{"subjects":["other.example"],"on_demand":true}`)})
+	client := NewClient(srv.URL)
+	ctx := t.Context()
+
+	if err := client.AddDomain(ctx, "cust", "assistant.example.com", "localhost:30000"); err != nil {
+		t.Fatalf("AddDomain: %v", err)
+	}
+	if got := fake.ids(domainRoutesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-domain-cust"}) {
+		t.Fatalf("Expected the domain to be routed on the HTTPS server, got %v", got)
+	}
+	if len(fake.ids(routesPath)) != 0 {
+		t.Errorf("Expected no domain route on the HTTP server, got %v", fake.ids(routesPath))
+	}
+	if route := fake.route(DomainRouteID("cust")); len(route.Match) == 0 || route.Match[0].Host[0] != "assistant.example.com" {
+		t.Fatalf("Expected a route for the domain, got %+v", route)
+	}
+	if got := fake.ids(policiesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-tls-cust", ""}) {
+		t.Fatalf("Expected an on-demand policy for the domain, got %v", got)
+	}
+
+	if err := client.RemoveDomain(ctx, "cust"); err != nil {
+		t.Fatalf("RemoveDomain: %v", err)
+	}
+	if len(fake.ids(domainRoutesPath)) != 0 || len(fake.ids(policiesPath)) != 1 {
+		t.Errorf("Expected the route and policy to be removed, got %v and %v", fake.ids(domainRoutesPath), fake.ids(policiesPath))
+	}
+
+	if err := client.RemoveDomain(ctx, "cust"); !errors.Is(err, ErrRouteNotFound) {
+		t.Errorf("Expected ErrRouteNotFound, got %v", err)
+	}
+}
+
+func TestCustomDomainWithoutTLSApp(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	fake.set(domainRoutesPath, nil)
+	// Caddy runs a config with no TLS settings at all
+	delete(fake.objects, "/config/apps/tls")
+	delete(fake.objects, "/config/apps/tls/automation")
+	client := NewClient(srv.URL)
+	ctx := t.Context()
+
+	if err := client.Sync(ctx, nil, nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if fake.objects["/config/apps/tls"] {
+		t.Error("Expected no TLS app to be created without policies")
+	}
+
+	if err := client.AddDomain(ctx, "cust", "assistant.example.com", "localhost:30000"); err != nil {
+		t.Fatalf("AddDomain: %v", err)
+	}
+	if got := fake.ids(policiesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-tls-cust"}) {
+		t.Fatalf("Expected the TLS app to be created with the domain's policy, got %v", got)
+	}
+
+	if err := client.AddDomain(ctx, "other", "assistant.other.example", "localhost:30002"); err != nil {
+		t.Fatalf("AddDomain: %v", err)
+	}
+	if got := fake.ids(policiesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-tls-other", "blytz-tls-cust"}) {
+		t.Errorf("Expected the policy to be added to the existing list, got %v", got)
+	}
+}
+
+func TestSyncWithoutTLSApp(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	fake.set(routesPath, nil)
+	fake.set(domainRoutesPath, nil)
+	delete(fake.objects, "/config/apps/tls")
+	delete(fake.objects, "/config/apps/tls/automation")
+	client := NewClient(srv.URL)
+
+	routes := []Route{NewRoute(DomainRouteID("a"), "assistant.example.com", "localhost:30000")}
+	policies := []TLSPolicy{NewDomainPolicy("a", "assistant.example.com")}
+	if err := client.Sync(t.Context(), nil, routes, policies); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := fake.ids(policiesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-tls-a"}) {
+		t.Errorf("Expected the TLS app to be created with the policy, got %v", got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	fake, srv := newFakeAdmin(t)
+	stale, _ := json.Marshal(NewRoute(TenantRouteID("gone"), "gone.blytz.cloud", "localhost:30004"))
+	// A domain route left on the HTTP server is moved to the HTTPS one
+	misplaced, _ := json.Marshal(NewRoute(DomainRouteID("a"), "assistant.example.com", "localhost:30000"))
+	fake.set(routesPath, []json.RawMessage{stale, misplaced, catchAll()})
+	fake.set(domainRoutesPath, nil)
+	client := NewClient(srv.URL)
+	ctx := t.Context()
+
+	routes := []Route{NewRoute(TenantRouteID("a"), "a.blytz.cloud", "localhost:30000")}
+	domainRoutes := []Route{NewRoute(DomainRouteID("a"), "assistant.example.com", "localhost:30000")}
+	policies := []TLSPolicy{NewDomainPolicy("a", "assistant.example.com")}
+	if err := client.Sync(ctx, routes, domainRoutes, policies); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, want := fake.ids(routesPath), []string{"blytz-tenant-a", ""}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected routes %v, got %v", want, got)
+	}
+	if got, want := fake.ids(domainRoutesPath), []string{"blytz-domain-a"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected domain routes %v, got %v", want, got)
+	}
+	if got := fake.ids(policiesPath); fmt.Sprint(got) != fmt.Sprint([]string{"blytz-tls-a"}) {
+		t.Errorf("Expected the policy list to be created, got %v", got)
+	}
+
+	writes := fake.listWrites
+	if err := client.Sync(ctx, routes, domainRoutes, policies); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if fake.listWrites != writes {
+		t.Error("Expected no writes when Caddy already matches")
+	}
+
+	if err := client.Sync(ctx, nil, nil, nil); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got := fake.ids(routesPath); fmt.Sprint(got) != fmt.Sprint([]string{""}) {
+		t.Errorf("Expected only the unmanaged route to be left, got %v", got)
+	}
 }
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+
+	"blytz/internal/egress"
 )
 
 type Config struct {
@@ -16,11 +19,68 @@ type Config struct {
 	PortRangeEnd          int
 	BaseDomain            string
 	CaddyAdminURL         string
+	CaddyAdminToken       string
+	CaddyServerName       string
+	CaddyDomainServerName string
+	CaddyTimeoutSeconds   int
+	CaddySyncInterval     int
 	OpenAIAPIKey          string
 	StripeSecretKey       string
 	StripeWebhookSecret   string
 	StripePriceID         string
 	OpenClawGatewayPrefix string
+	AdminAPIKey           string
+	ErasureGraceDays      int
+	MetricsInterval       int
+	MetricsWindow         int
+	MetricsToken          string
+	DiskMinFreeMB         int
+	HealthProbeInterval   int
+	HealthFailureLimit    int
+	HealthRestartBackoff  int
+	HealthCrashLoopLimit  int
+	HealthHistoryDays     int
+	AnthropicAPIKey       string
+	LLMProxyURL           string
+	LLMOpenAIUpstream     string
+	LLMAnthropicUpstream  string
+	LLMMonthlySpendCapUSD int
+	LLMRateLimitPerMinute int
+	StripeMeteredPrices   []string
+	StripeMessagesMeter   string
+	StripeTokensMeter     string
+	UsageReportInterval   int
+	StripePlanPrices      map[string]string
+	PlanTrialDays         map[string]int
+	PendingSignupTTLHours int
+	WaitlistInviteHours   int
+	SMTPAddr              string
+	SMTPUsername          string
+	SMTPPassword          string
+	SMTPFrom              string
+	NodeAgentToken        string
+	TenantNetwork         string
+	TenantNetworkPeers    []string
+	PlanEgressPolicies    map[string]string
+	EgressFirewall        bool
+	ContainerPidsLimit    int
+	ContainerNoFileLimit  int
+	ContainerRuntime      string
+	ImageRepository       string
+	RolloutCanarySize     int
+	RolloutConcurrency    int
+	RolloutBakeSeconds    int
+	RolloutBatchBake      int
+	RolloutMaxErrorPct    int
+}
+
+// NodeAgentConfig configures the agent running on a worker node
+type NodeAgentConfig struct {
+	Addr         string
+	CustomersDir string
+	Token        string
+	// EgressFirewall installs the egress rules shipped with each tenant
+	EgressFirewall bool
 }
 
 func Load() (*Config, error) {
@@ -34,11 +94,63 @@ func Load() (*Config, error) {
 		PortRangeEnd:          getEnvInt("PORT_RANGE_END", 30999),
 		BaseDomain:            getEnv("BASE_DOMAIN", "localhost"),
 		CaddyAdminURL:         getEnv("CADDY_ADMIN_URL", ""),
+		CaddyAdminToken:       os.Getenv("CADDY_ADMIN_TOKEN"),
+		CaddyServerName:       getEnv("CADDY_SERVER_NAME", "srv0"),
+		CaddyDomainServerName: getEnv("CADDY_DOMAIN_SERVER_NAME", "domains"),
+		CaddyTimeoutSeconds:   getEnvInt("CADDY_TIMEOUT_SECONDS", 10),
+		CaddySyncInterval:     getEnvInt("CADDY_SYNC_INTERVAL_SECONDS", 300),
 		OpenAIAPIKey:          os.Getenv("OPENAI_API_KEY"),
 		StripeSecretKey:       os.Getenv("STRIPE_SECRET_KEY"),
 		StripeWebhookSecret:   os.Getenv("STRIPE_WEBHOOK_SECRET"),
 		StripePriceID:         os.Getenv("STRIPE_PRICE_ID"),
 		OpenClawGatewayPrefix: getEnv("OPENCLAW_GATEWAY_TOKEN_PREFIX", "blytz_"),
+		AdminAPIKey:           os.Getenv("ADMIN_API_KEY"),
+		ErasureGraceDays:      getEnvInt("ERASURE_GRACE_DAYS", 30),
+		MetricsInterval:       getEnvInt("METRICS_INTERVAL_SECONDS", 30),
+		MetricsWindow:         getEnvInt("METRICS_WINDOW", 120),
+		MetricsToken:          os.Getenv("METRICS_TOKEN"),
+		DiskMinFreeMB:         getEnvInt("DISK_MIN_FREE_MB", 1024),
+		HealthProbeInterval:   getEnvInt("HEALTH_PROBE_INTERVAL_SECONDS", 30),
+		HealthFailureLimit:    getEnvInt("HEALTH_FAILURE_THRESHOLD", 3),
+		HealthRestartBackoff:  getEnvInt("HEALTH_RESTART_BACKOFF_SECONDS", 30),
+		HealthCrashLoopLimit:  getEnvInt("HEALTH_CRASH_LOOP_LIMIT", 5),
+		HealthHistoryDays:     getEnvInt("HEALTH_HISTORY_DAYS", 7),
+		AnthropicAPIKey:       os.Getenv("ANTHROPIC_API_KEY"),
+		LLMProxyURL:           os.Getenv("LLM_PROXY_URL"),
+		LLMOpenAIUpstream:     getEnv("LLM_OPENAI_UPSTREAM", "https://api.openai.com"),
+		LLMAnthropicUpstream:  getEnv("LLM_ANTHROPIC_UPSTREAM", "https://api.anthropic.com"),
+		LLMMonthlySpendCapUSD: getEnvInt("LLM_MONTHLY_SPEND_CAP_USD", 20),
+		LLMRateLimitPerMinute: getEnvInt("LLM_RATE_LIMIT_PER_MINUTE", 60),
+		StripeMeteredPrices:   getEnvList("STRIPE_METERED_PRICE_IDS"),
+		StripeMessagesMeter:   os.Getenv("STRIPE_MESSAGES_METER_EVENT"),
+		StripeTokensMeter:     os.Getenv("STRIPE_TOKENS_METER_EVENT"),
+		UsageReportInterval:   getEnvInt("USAGE_REPORT_INTERVAL_SECONDS", 300),
+		StripePlanPrices:      getEnvMap("STRIPE_PLAN_PRICE_IDS"),
+		PlanTrialDays:         getEnvIntMap("PLAN_TRIAL_DAYS"),
+		PendingSignupTTLHours: getEnvInt("PENDING_SIGNUP_TTL_HOURS", 24),
+		WaitlistInviteHours:   getEnvInt("WAITLIST_INVITE_HOURS", 48),
+		SMTPAddr:              os.Getenv("SMTP_ADDR"),
+		SMTPUsername:          os.Getenv("SMTP_USERNAME"),
+		SMTPPassword:          os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:              os.Getenv("SMTP_FROM"),
+		NodeAgentToken:        os.Getenv("NODE_AGENT_TOKEN"),
+		TenantNetwork:         os.Getenv("TENANT_NETWORK"),
+		TenantNetworkPeers:    getEnvList("TENANT_NETWORK_PEERS"),
+		PlanEgressPolicies:    getEnvMap("PLAN_EGRESS_POLICIES"),
+		EgressFirewall:        os.Getenv("EGRESS_FIREWALL") == "true",
+		ContainerPidsLimit:    getEnvInt("CONTAINER_PIDS_LIMIT", 256),
+		ContainerNoFileLimit:  getEnvInt("CONTAINER_NOFILE_LIMIT", 4096),
+		ContainerRuntime:      os.Getenv("CONTAINER_RUNTIME"),
+		ImageRepository:       getEnv("IMAGE_REPOSITORY", "blytz"),
+		RolloutCanarySize:     getEnvInt("ROLLOUT_CANARY_SIZE", 1),
+		RolloutConcurrency:    getEnvInt("ROLLOUT_CONCURRENCY", 5),
+		RolloutBakeSeconds:    getEnvInt("ROLLOUT_BAKE_SECONDS", 900),
+		RolloutBatchBake:      getEnvInt("ROLLOUT_BATCH_BAKE_SECONDS", 120),
+		RolloutMaxErrorPct:    getEnvInt("ROLLOUT_MAX_ERROR_PERCENT", 10),
+	}
+	if cfg.LLMProxyURL == "" {
+		// Tenant containers reach the control plane through the host gateway
+		cfg.LLMProxyURL = "http://host.docker.internal:" + cfg.Port
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -58,9 +170,84 @@ func (c *Config) Validate() error {
 	if c.PortRangeEnd-c.PortRangeStart < c.MaxCustomers {
 		return fmt.Errorf("port range must accommodate MAX_CUSTOMERS")
 	}
+	if c.CaddyAdminURL != "" && c.CaddyTimeoutSeconds <= 0 {
+		return fmt.Errorf("CADDY_TIMEOUT_SECONDS must be positive")
+	}
+	if c.CaddySyncInterval < 0 {
+		return fmt.Errorf("CADDY_SYNC_INTERVAL_SECONDS must not be negative")
+	}
+	if c.ErasureGraceDays < 0 {
+		return fmt.Errorf("ERASURE_GRACE_DAYS must not be negative")
+	}
+	if c.DiskMinFreeMB < 0 {
+		return fmt.Errorf("DISK_MIN_FREE_MB must not be negative")
+	}
+	if c.LLMMonthlySpendCapUSD < 0 {
+		return fmt.Errorf("LLM_MONTHLY_SPEND_CAP_USD must not be negative")
+	}
+	if c.LLMRateLimitPerMinute < 0 {
+		return fmt.Errorf("LLM_RATE_LIMIT_PER_MINUTE must not be negative")
+	}
+	if c.PendingSignupTTLHours < 0 {
+		return fmt.Errorf("PENDING_SIGNUP_TTL_HOURS must not be negative")
+	}
+	if c.WaitlistInviteHours < 0 {
+		return fmt.Errorf("WAITLIST_INVITE_HOURS must not be negative")
+	}
+	if c.SMTPAddr != "" && c.SMTPFrom == "" {
+		return fmt.Errorf("SMTP_FROM is required when SMTP_ADDR is set")
+	}
+	if c.ContainerPidsLimit < 0 {
+		return fmt.Errorf("CONTAINER_PIDS_LIMIT must not be negative")
+	}
+	if c.ContainerNoFileLimit < 0 {
+		return fmt.Errorf("CONTAINER_NOFILE_LIMIT must not be negative")
+	}
+	if c.RolloutCanarySize < 0 {
+		return fmt.Errorf("ROLLOUT_CANARY_SIZE must not be negative")
+	}
+	if c.RolloutConcurrency < 0 {
+		return fmt.Errorf("ROLLOUT_CONCURRENCY must not be negative")
+	}
+	if c.RolloutBakeSeconds < 0 || c.RolloutBatchBake < 0 {
+		return fmt.Errorf("ROLLOUT_BAKE_SECONDS and ROLLOUT_BATCH_BAKE_SECONDS must not be negative")
+	}
+	if c.RolloutMaxErrorPct < 0 || c.RolloutMaxErrorPct > 100 {
+		return fmt.Errorf("ROLLOUT_MAX_ERROR_PERCENT must be between 0 and 100")
+	}
+	for planID, policy := range c.PlanEgressPolicies {
+		if !egress.ValidPolicy(policy) {
+			return fmt.Errorf("PLAN_EGRESS_POLICIES: unknown policy %q for plan %s", policy, planID)
+		}
+	}
+	for _, peer := range c.TenantNetworkPeers {
+		if !egress.ValidPeer(peer) {
+			return fmt.Errorf("TENANT_NETWORK_PEERS: %q is not an IPv4 address or network", peer)
+		}
+	}
+	// Without peers the isolation rules would cut Caddy off from tenants too
+	if c.EgressFirewall && c.TenantNetwork != "" && len(c.TenantNetworkPeers) == 0 {
+		return fmt.Errorf("TENANT_NETWORK_PEERS is required with EGRESS_FIREWALL and TENANT_NETWORK")
+	}
 	return nil
 }
 
+// LoadNodeAgent reads the node agent's configuration. The agent refuses to
+// start without the token the control plane authenticates with.
+func LoadNodeAgent() (*NodeAgentConfig, error) {
+	cfg := &NodeAgentConfig{
+		Addr:         getEnv("NODE_AGENT_ADDR", ":9100"),
+		CustomersDir: getEnv("CUSTOMERS_DIR", "./tmp/customers"),
+		Token:        os.Getenv("NODE_AGENT_TOKEN"),
+
+		EgressFirewall: os.Getenv("EGRESS_FIREWALL") == "true",
+	}
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("NODE_AGENT_TOKEN is required")
+	}
+	return cfg, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -76,3 +263,39 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvList splits a comma-separated variable, dropping empty entries
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvMap parses a comma-separated list of key=value pairs, dropping malformed entries
+func getEnvMap(key string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range getEnvList(key) {
+		k, v, ok := strings.Cut(pair, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if ok && k != "" && v != "" {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// getEnvIntMap parses a comma-separated list of key=days pairs, dropping
+// entries that are not non-negative integers
+func getEnvIntMap(key string) map[string]int {
+	values := map[string]int{}
+	for k, v := range getEnvMap(key) {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			values[k] = n
+		}
+	}
+	return values
+}
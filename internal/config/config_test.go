@@ -87,6 +87,120 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative llm spend cap",
+			cfg: &Config{
+				MaxCustomers:          20,
+				PortRangeStart:        30000,
+				PortRangeEnd:          30999,
+				LLMMonthlySpendCapUSD: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative pending signup ttl",
+			cfg: &Config{
+				MaxCustomers:          20,
+				PortRangeStart:        30000,
+				PortRangeEnd:          30999,
+				PendingSignupTTLHours: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative waitlist invite hours",
+			cfg: &Config{
+				MaxCustomers:        20,
+				PortRangeStart:      30000,
+				PortRangeEnd:        30999,
+				WaitlistInviteHours: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative pids limit",
+			cfg: &Config{
+				MaxCustomers:       20,
+				PortRangeStart:     30000,
+				PortRangeEnd:       30999,
+				ContainerPidsLimit: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative rollout concurrency",
+			cfg: &Config{
+				MaxCustomers:       20,
+				PortRangeStart:     30000,
+				PortRangeEnd:       30999,
+				RolloutConcurrency: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "caddy without a timeout",
+			cfg: &Config{
+				MaxCustomers:   20,
+				PortRangeStart: 30000,
+				PortRangeEnd:   30999,
+				CaddyAdminURL:  "http://caddy:2019",
+			},
+			wantErr: true,
+		},
+		{
+			name: "rollout error rate above 100 percent",
+			cfg: &Config{
+				MaxCustomers:       20,
+				PortRangeStart:     30000,
+				PortRangeEnd:       30999,
+				RolloutMaxErrorPct: 101,
+			},
+			wantErr: true,
+		},
+		{
+			name: "firewalled tenant network without peers",
+			cfg: &Config{
+				MaxCustomers:   20,
+				PortRangeStart: 30000,
+				PortRangeEnd:   30999,
+				TenantNetwork:  "blytz-tenants",
+				EgressFirewall: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "tenant network peer by name",
+			cfg: &Config{
+				MaxCustomers:       20,
+				PortRangeStart:     30000,
+				PortRangeEnd:       30999,
+				TenantNetwork:      "blytz-tenants",
+				TenantNetworkPeers: []string{"caddy"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "firewalled tenant network with peers",
+			cfg: &Config{
+				MaxCustomers:       20,
+				PortRangeStart:     30000,
+				PortRangeEnd:       30999,
+				TenantNetwork:      "blytz-tenants",
+				TenantNetworkPeers: []string{"172.30.0.2", "172.30.0.3"},
+				EgressFirewall:     true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "smtp relay without a sender",
+			cfg: &Config{
+				MaxCustomers:   20,
+				PortRangeStart: 30000,
+				PortRangeEnd:   30999,
+				SMTPAddr:       "smtp.example.com:587",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -98,3 +212,91 @@ func TestConfigValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadMeteredPrices(t *testing.T) {
+	t.Setenv("STRIPE_METERED_PRICE_IDS", "price_messages, ,price_tokens ")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.StripeMeteredPrices) != 2 || cfg.StripeMeteredPrices[0] != "price_messages" || cfg.StripeMeteredPrices[1] != "price_tokens" {
+		t.Errorf("StripeMeteredPrices = %q", cfg.StripeMeteredPrices)
+	}
+	if cfg.UsageReportInterval != 300 {
+		t.Errorf("UsageReportInterval = %d, want 300", cfg.UsageReportInterval)
+	}
+}
+
+func TestLoadPlanPrices(t *testing.T) {
+	t.Setenv("STRIPE_PLAN_PRICE_IDS", "pro=price_pro, business = price_business,broken,=price_x")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := map[string]string{"pro": "price_pro", "business": "price_business"}
+	if len(cfg.StripePlanPrices) != len(want) {
+		t.Fatalf("StripePlanPrices = %v, want %v", cfg.StripePlanPrices, want)
+	}
+	for plan, price := range want {
+		if cfg.StripePlanPrices[plan] != price {
+			t.Errorf("StripePlanPrices[%q] = %q, want %q", plan, cfg.StripePlanPrices[plan], price)
+		}
+	}
+}
+
+func TestLoadPlanTrialDays(t *testing.T) {
+	t.Setenv("PLAN_TRIAL_DAYS", "starter=7,pro=14,business=-1,broken=two")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want := map[string]int{"starter": 7, "pro": 14}
+	if len(cfg.PlanTrialDays) != len(want) {
+		t.Fatalf("PlanTrialDays = %v, want %v", cfg.PlanTrialDays, want)
+	}
+	for plan, days := range want {
+		if cfg.PlanTrialDays[plan] != days {
+			t.Errorf("PlanTrialDays[%q] = %d, want %d", plan, cfg.PlanTrialDays[plan], days)
+		}
+	}
+}
+
+func TestLoadPlanEgressPolicies(t *testing.T) {
+	t.Setenv("PLAN_EGRESS_POLICIES", "starter=restricted,business=open")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.PlanEgressPolicies["starter"] != "restricted" || cfg.PlanEgressPolicies["business"] != "open" {
+		t.Errorf("PlanEgressPolicies = %v", cfg.PlanEgressPolicies)
+	}
+
+	t.Setenv("PLAN_EGRESS_POLICIES", "starter=closed")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with an unknown egress policy should fail")
+	}
+}
+
+func TestLoadNodeAgent(t *testing.T) {
+	t.Setenv("NODE_AGENT_TOKEN", "")
+	if _, err := LoadNodeAgent(); err == nil {
+		t.Fatal("LoadNodeAgent() without a token should fail")
+	}
+
+	t.Setenv("NODE_AGENT_TOKEN", "secret")
+	t.Setenv("NODE_AGENT_ADDR", ":9200")
+	cfg, err := LoadNodeAgent()
+	if err != nil {
+		t.Fatalf("LoadNodeAgent() error = %v", err)
+	}
+	if cfg.Addr != ":9200" || cfg.Token != "secret" || cfg.CustomersDir != "./tmp/customers" {
+		t.Errorf("LoadNodeAgent() = %+v", cfg)
+	}
+}
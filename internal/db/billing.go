@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MeteredUsage is the billable usage a customer accrued over a billing period
+type MeteredUsage struct {
+	Messages  int64 `json:"messages"`
+	LLMTokens int64 `json:"llm_tokens"`
+}
+
+// UsageReport tracks how much of one metric has been reported to Stripe for a
+// billing period. PendingValue is set before a report is sent and cleared once
+// Stripe accepts it, so an interrupted report is resent with the same total.
+type UsageReport struct {
+	CustomerID    string     `json:"customer_id"`
+	Metric        string     `json:"metric"`
+	PeriodStart   time.Time  `json:"period_start"`
+	PeriodEnd     time.Time  `json:"period_end"`
+	ReportedValue int64      `json:"reported_value"`
+	PendingValue  *int64     `json:"pending_value,omitempty"`
+	ReportedAt    *time.Time `json:"reported_at,omitempty"`
+}
+
+// UpdateSubscriptionPeriod records the subscription status and current billing
+// period of the customer with the given Stripe customer ID
+func (db *DB) UpdateSubscriptionPeriod(ctx context.Context, stripeCustomerID, status string, start, end time.Time) error {
+	query := `UPDATE customers SET
+		subscription_status = ?,
+		current_period_start = ?,
+		current_period_end = ?,
+		updated_at = ?
+		WHERE stripe_customer_id = ?`
+	result, err := db.conn.ExecContext(ctx, query, status, start.UTC(), end.UTC(), time.Now(), stripeCustomerID)
+	if err != nil {
+		return fmt.Errorf("update subscription period: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("customer not found")
+	}
+	return nil
+}
+
+// GetMeteredUsage totals the customer's billable usage in the hourly buckets
+// starting in [start, end). Bounds are truncated to the bucket size so every
+// bucket falls in exactly one period.
+func (db *DB) GetMeteredUsage(ctx context.Context, customerID string, start, end time.Time) (*MeteredUsage, error) {
+	from := start.UTC().Truncate(UsageBucketSize)
+	to := end.UTC().Truncate(UsageBucketSize)
+
+	usage := &MeteredUsage{}
+	query := `SELECT COALESCE(SUM(messages), 0) FROM usage_buckets
+			  WHERE customer_id = ? AND bucket_start >= ? AND bucket_start < ?`
+	if err := db.conn.QueryRowContext(ctx, query, customerID, from, to).Scan(&usage.Messages); err != nil {
+		return nil, fmt.Errorf("query metered messages: %w", err)
+	}
+
+	query = `SELECT COALESCE(SUM(input_tokens + output_tokens), 0) FROM llm_usage
+			 WHERE customer_id = ? AND bucket_start >= ? AND bucket_start < ?`
+	if err := db.conn.QueryRowContext(ctx, query, customerID, from, to).Scan(&usage.LLMTokens); err != nil {
+		return nil, fmt.Errorf("query metered llm tokens: %w", err)
+	}
+
+	return usage, nil
+}
+
+// GetUsageReport returns the report state for a metric and period, or nil if nothing has been reported
+func (db *DB) GetUsageReport(ctx context.Context, customerID, metric string, periodStart time.Time) (*UsageReport, error) {
+	query := `SELECT customer_id, metric, period_start, period_end, reported_value, pending_value, reported_at
+			  FROM usage_reports WHERE customer_id = ? AND metric = ? AND period_start = ?`
+	row := db.conn.QueryRowContext(ctx, query, customerID, metric, periodStart.UTC())
+
+	r, err := scanUsageReport(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query usage report: %w", err)
+	}
+	return r, nil
+}
+
+// SetPendingUsageReport records the period total about to be reported
+func (db *DB) SetPendingUsageReport(ctx context.Context, customerID, metric string, start, end time.Time, value int64) error {
+	query := `INSERT INTO usage_reports (customer_id, metric, period_start, period_end, pending_value)
+			  VALUES (?, ?, ?, ?, ?)
+			  ON CONFLICT(customer_id, metric, period_start) DO UPDATE SET
+				period_end = excluded.period_end,
+				pending_value = excluded.pending_value`
+	_, err := db.conn.ExecContext(ctx, query, customerID, metric, start.UTC(), end.UTC(), value)
+	if err != nil {
+		return fmt.Errorf("set pending usage report: %w", err)
+	}
+	return nil
+}
+
+// CompleteUsageReport marks the pending total for a period as accepted by Stripe
+func (db *DB) CompleteUsageReport(ctx context.Context, customerID, metric string, periodStart time.Time) error {
+	query := `UPDATE usage_reports SET
+		reported_value = pending_value,
+		pending_value = NULL,
+		reported_at = ?
+		WHERE customer_id = ? AND metric = ? AND period_start = ? AND pending_value IS NOT NULL`
+	_, err := db.conn.ExecContext(ctx, query, time.Now().UTC(), customerID, metric, periodStart.UTC())
+	if err != nil {
+		return fmt.Errorf("complete usage report: %w", err)
+	}
+	return nil
+}
+
+// ListUnsettledUsageReports returns reports for periods ending after the given
+// time, or still holding an unsent total, so late usage can be reported
+func (db *DB) ListUnsettledUsageReports(ctx context.Context, endedAfter time.Time) ([]UsageReport, error) {
+	query := `SELECT customer_id, metric, period_start, period_end, reported_value, pending_value, reported_at
+			  FROM usage_reports WHERE period_end > ? OR pending_value IS NOT NULL
+			  ORDER BY customer_id, period_start, metric`
+	return db.queryUsageReports(ctx, query, endedAfter.UTC())
+}
+
+// ListUsageReports returns every usage report kept for a customer, oldest period first
+func (db *DB) ListUsageReports(ctx context.Context, customerID string) ([]UsageReport, error) {
+	query := `SELECT customer_id, metric, period_start, period_end, reported_value, pending_value, reported_at
+			  FROM usage_reports WHERE customer_id = ?
+			  ORDER BY period_start, metric`
+	return db.queryUsageReports(ctx, query, customerID)
+}
+
+func (db *DB) queryUsageReports(ctx context.Context, query string, args ...interface{}) ([]UsageReport, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query usage reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []UsageReport{}
+	for rows.Next() {
+		r, err := scanUsageReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan usage report: %w", err)
+		}
+		reports = append(reports, *r)
+	}
+
+	return reports, rows.Err()
+}
+
+func scanUsageReport(row interface{ Scan(...interface{}) error }) (*UsageReport, error) {
+	var r UsageReport
+	err := row.Scan(&r.CustomerID, &r.Metric, &r.PeriodStart, &r.PeriodEnd,
+		&r.ReportedValue, &r.PendingValue, &r.ReportedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateSubscriptionPeriod(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "period@example.com")
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_period", "sub_period"))
+
+	start := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	require.NoError(t, database.UpdateSubscriptionPeriod(ctx, "cus_period", "active", start, end))
+
+	updated, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	require.NotNil(t, updated.CurrentPeriodStart)
+	require.NotNil(t, updated.CurrentPeriodEnd)
+	assert.True(t, start.Equal(*updated.CurrentPeriodStart))
+	assert.True(t, end.Equal(*updated.CurrentPeriodEnd))
+	assert.Equal(t, "active", *updated.SubscriptionStatus)
+
+	assert.Error(t, database.UpdateSubscriptionPeriod(ctx, "cus_missing", "active", start, end))
+}
+
+func TestGetMeteredUsage(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "metered@example.com")
+	other := createTestCustomer(t, database, "other@example.com")
+
+	start := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []UsageEvent{
+		{OccurredAt: start.Add(-2 * time.Hour), Messages: 100},  // previous period
+		{OccurredAt: start.Add(-10 * time.Minute), Messages: 1}, // same hour as the period start
+		{OccurredAt: start.Add(time.Hour), Messages: 2},
+		{OccurredAt: end.Add(-time.Hour), Messages: 3},
+		{OccurredAt: end.Add(time.Minute), Messages: 100}, // next period
+	}))
+	require.NoError(t, database.RecordUsage(ctx, other.ID, []UsageEvent{{OccurredAt: start.Add(time.Hour), Messages: 50}}))
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, LLMUsage{
+		OccurredAt: start.Add(time.Hour), Provider: "openai", Model: "gpt-4o", InputTokens: 1000, OutputTokens: 200,
+	}))
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, LLMUsage{
+		OccurredAt: start.Add(2 * time.Hour), Provider: "anthropic", Model: "claude-sonnet-4", InputTokens: 10, OutputTokens: 5,
+	}))
+
+	usage, err := database.GetMeteredUsage(ctx, customer.ID, start, end)
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), usage.Messages)
+	assert.Equal(t, int64(1215), usage.LLMTokens)
+
+	// Consecutive periods never count a bucket twice
+	previous, err := database.GetMeteredUsage(ctx, customer.ID, start.AddDate(0, -1, 0), start)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), previous.Messages)
+
+	usage, err = database.GetMeteredUsage(ctx, "missing", start, end)
+	require.NoError(t, err)
+	assert.Zero(t, usage.Messages)
+	assert.Zero(t, usage.LLMTokens)
+}
+
+func TestUsageReportLifecycle(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "reports@example.com")
+
+	start := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	report, err := database.GetUsageReport(ctx, customer.ID, "messages", start)
+	require.NoError(t, err)
+	assert.Nil(t, report)
+
+	require.NoError(t, database.SetPendingUsageReport(ctx, customer.ID, "messages", start, end, 40))
+	report, err = database.GetUsageReport(ctx, customer.ID, "messages", start)
+	require.NoError(t, err)
+	require.NotNil(t, report)
+	assert.Zero(t, report.ReportedValue)
+	require.NotNil(t, report.PendingValue)
+	assert.Equal(t, int64(40), *report.PendingValue)
+	assert.Nil(t, report.ReportedAt)
+
+	require.NoError(t, database.CompleteUsageReport(ctx, customer.ID, "messages", start))
+	report, err = database.GetUsageReport(ctx, customer.ID, "messages", start)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), report.ReportedValue)
+	assert.Nil(t, report.PendingValue)
+	assert.NotNil(t, report.ReportedAt)
+
+	// Completing again without a pending total keeps the reported value
+	require.NoError(t, database.CompleteUsageReport(ctx, customer.ID, "messages", start))
+	report, err = database.GetUsageReport(ctx, customer.ID, "messages", start)
+	require.NoError(t, err)
+	assert.Equal(t, int64(40), report.ReportedValue)
+
+	reports, err := database.ListUsageReports(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Len(t, reports, 1)
+}
+
+func TestListUnsettledUsageReports(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "unsettled@example.com")
+
+	now := time.Date(2026, 5, 14, 12, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, -2, 0)
+	closed := now.AddDate(0, -1, 0)
+
+	// Settled long ago
+	require.NoError(t, database.SetPendingUsageReport(ctx, customer.ID, "messages", old, closed.Add(-24*time.Hour), 5))
+	require.NoError(t, database.CompleteUsageReport(ctx, customer.ID, "messages", old))
+	// Old but never accepted by Stripe
+	require.NoError(t, database.SetPendingUsageReport(ctx, customer.ID, "llm_tokens", old, closed.Add(-24*time.Hour), 7))
+	// Ended within the grace window
+	require.NoError(t, database.SetPendingUsageReport(ctx, customer.ID, "messages", closed, now.Add(-time.Minute), 9))
+	require.NoError(t, database.CompleteUsageReport(ctx, customer.ID, "messages", closed))
+
+	reports, err := database.ListUnsettledUsageReports(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, reports, 2)
+	assert.Equal(t, "llm_tokens", reports[0].Metric)
+	assert.Equal(t, "messages", reports[1].Metric)
+	assert.True(t, closed.Equal(reports[1].PeriodStart))
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PendingSignup is a customer who has not completed checkout
+type PendingSignup struct {
+	CustomerID        string
+	CheckoutSessionID string
+	UpdatedAt         time.Time
+}
+
+// SetCheckoutSession records the customer's latest checkout session. Storing
+// a session restarts the pending signup's expiry.
+func (db *DB) SetCheckoutSession(ctx context.Context, customerID, sessionID string) error {
+	query := `UPDATE customers SET stripe_checkout_session_id = ?, updated_at = ? WHERE id = ?`
+	result, err := db.conn.ExecContext(ctx, query, sessionID, time.Now(), customerID)
+	if err != nil {
+		return fmt.Errorf("set checkout session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("customer not found")
+	}
+	return nil
+}
+
+// ListPendingSignups returns customers still awaiting checkout whose signup
+// or latest checkout session is older than before
+func (db *DB) ListPendingSignups(ctx context.Context, before time.Time) ([]PendingSignup, error) {
+	query := `SELECT id, COALESCE(stripe_checkout_session_id, ''), updated_at FROM customers
+			  WHERE status = 'pending' AND stripe_customer_id IS NULL AND updated_at < ?
+			  ORDER BY updated_at`
+	rows, err := db.conn.QueryContext(ctx, query, before)
+	if err != nil {
+		return nil, fmt.Errorf("query pending signups: %w", err)
+	}
+	defer rows.Close()
+
+	var signups []PendingSignup
+	for rows.Next() {
+		var signup PendingSignup
+		if err := rows.Scan(&signup.CustomerID, &signup.CheckoutSessionID, &signup.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan pending signup: %w", err)
+		}
+		signups = append(signups, signup)
+	}
+
+	return signups, rows.Err()
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPendingSignups(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	abandoned := createTestCustomer(t, database, "abandoned@example.com")
+	require.NoError(t, database.SetCheckoutSession(ctx, abandoned.ID, "cs_abandoned"))
+	noSession := createTestCustomer(t, database, "nosession@example.com")
+	paid := createTestCustomer(t, database, "paid@example.com")
+	require.NoError(t, database.UpdateStripeInfo(ctx, paid.ID, "cus_paid", "sub_paid"))
+
+	assert.Error(t, database.SetCheckoutSession(ctx, "missing", "cs_x"))
+
+	customer, err := database.GetCustomerByID(ctx, abandoned.ID)
+	require.NoError(t, err)
+	require.NotNil(t, customer.StripeCheckoutSessionID)
+	assert.Equal(t, "cs_abandoned", *customer.StripeCheckoutSessionID)
+
+	signups, err := database.ListPendingSignups(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, signups, 2)
+	byID := map[string]string{}
+	for _, signup := range signups {
+		byID[signup.CustomerID] = signup.CheckoutSessionID
+	}
+	assert.Equal(t, map[string]string{abandoned.ID: "cs_abandoned", noSession.ID: ""}, byID)
+
+	// Signups younger than the cutoff are kept
+	signups, err = database.ListPendingSignups(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, signups)
+}
@@ -118,6 +118,192 @@ func (db *DB) Migrate() error {
 		`CREATE INDEX IF NOT EXISTS idx_customers_agent_type ON customers(agent_type_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_agent_types_active ON agent_types(is_active)`,
 		`CREATE INDEX IF NOT EXISTS idx_llm_providers_active ON llm_providers(is_active)`,
+		// Customer API access and data erasure
+		`ALTER TABLE customers ADD COLUMN access_token_hash TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_customers_access_token ON customers(access_token_hash)`,
+		`CREATE TABLE IF NOT EXISTS erasure_requests (
+			customer_id TEXT PRIMARY KEY,
+			status TEXT NOT NULL DEFAULT 'scheduled',
+			requested_at TIMESTAMP NOT NULL,
+			scheduled_for TIMESTAMP NOT NULL,
+			completed_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_erasure_requests_due ON erasure_requests(status, scheduled_for)`,
+		// Readiness write probe
+		`CREATE TABLE IF NOT EXISTS health_probe (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			checked_at TIMESTAMP NOT NULL
+		)`,
+		// Tenant agent health history
+		`CREATE TABLE IF NOT EXISTS health_checks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			customer_id TEXT NOT NULL,
+			checked_at TIMESTAMP NOT NULL,
+			healthy INTEGER NOT NULL,
+			latency_ms REAL NOT NULL DEFAULT 0,
+			status_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT ''
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_health_checks_customer ON health_checks(customer_id, checked_at)`,
+		// Agent usage analytics
+		`ALTER TABLE customers ADD COLUMN gateway_token_hash TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_customers_gateway_token ON customers(gateway_token_hash)`,
+		`CREATE TABLE IF NOT EXISTS usage_buckets (
+			customer_id TEXT NOT NULL,
+			bucket_start TIMESTAMP NOT NULL,
+			messages INTEGER NOT NULL DEFAULT 0,
+			tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms_sum REAL NOT NULL DEFAULT 0,
+			latency_samples INTEGER NOT NULL DEFAULT 0,
+			last_event_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (customer_id, bucket_start)
+		)`,
+		// LLM proxy metering
+		`ALTER TABLE customers ADD COLUMN llm_proxy_token_hash TEXT`,
+		`CREATE INDEX IF NOT EXISTS idx_customers_llm_proxy_token ON customers(llm_proxy_token_hash)`,
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+			customer_id TEXT NOT NULL,
+			bucket_start TIMESTAMP NOT NULL,
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL,
+			requests INTEGER NOT NULL DEFAULT 0,
+			input_tokens INTEGER NOT NULL DEFAULT 0,
+			output_tokens INTEGER NOT NULL DEFAULT 0,
+			cost_micros INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (customer_id, bucket_start, provider, model)
+		)`,
+		// Metered billing
+		`ALTER TABLE customers ADD COLUMN current_period_start TIMESTAMP`,
+		`CREATE TABLE IF NOT EXISTS usage_reports (
+			customer_id TEXT NOT NULL,
+			metric TEXT NOT NULL,
+			period_start TIMESTAMP NOT NULL,
+			period_end TIMESTAMP NOT NULL,
+			reported_value INTEGER NOT NULL DEFAULT 0,
+			pending_value INTEGER,
+			reported_at TIMESTAMP,
+			PRIMARY KEY (customer_id, metric, period_start)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_usage_reports_period_end ON usage_reports(period_end)`,
+		// Subscription plans
+		`CREATE TABLE IF NOT EXISTS plans (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			description TEXT NOT NULL DEFAULT '',
+			stripe_price_id TEXT NOT NULL DEFAULT '',
+			price_cents INTEGER NOT NULL DEFAULT 0,
+			memory TEXT NOT NULL,
+			cpu TEXT NOT NULL,
+			monthly_messages INTEGER NOT NULL DEFAULT 0,
+			llm_spend_cap_usd INTEGER,
+			allowed_agent_types TEXT NOT NULL DEFAULT '[]',
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			is_active BOOLEAN DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE customers ADD COLUMN plan_id TEXT NOT NULL DEFAULT 'starter'`,
+		`CREATE INDEX IF NOT EXISTS idx_customers_plan ON customers(plan_id)`,
+		// Free trials and customer notifications
+		`ALTER TABLE plans ADD COLUMN trial_days INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			customer_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			message TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (customer_id) REFERENCES customers(id)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_notifications_customer ON notifications(customer_id, created_at)`,
+		// Capacity waitlist
+		`CREATE TABLE IF NOT EXISTS waitlist (
+			id TEXT PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE,
+			assistant_name TEXT NOT NULL,
+			custom_instructions TEXT NOT NULL,
+			telegram_bot_token TEXT NOT NULL,
+			agent_type_id TEXT NOT NULL DEFAULT '',
+			llm_provider_id TEXT NOT NULL DEFAULT '',
+			plan_id TEXT NOT NULL DEFAULT '',
+			promo_code TEXT NOT NULL DEFAULT '',
+			sort_order INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'waiting',
+			invite_expires_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_waitlist_order ON waitlist(status, sort_order)`,
+		// Worker nodes tenants can be placed on
+		`CREATE TABLE IF NOT EXISTS nodes (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			address TEXT NOT NULL,
+			agent_url TEXT NOT NULL,
+			memory_mb INTEGER NOT NULL,
+			cpus REAL NOT NULL,
+			port_start INTEGER NOT NULL,
+			port_end INTEGER NOT NULL,
+			labels TEXT NOT NULL DEFAULT '{}',
+			status TEXT NOT NULL DEFAULT 'active',
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`ALTER TABLE customers ADD COLUMN node_id TEXT`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_customers_node_port ON customers(node_id, container_port) WHERE node_id IS NOT NULL`,
+		`ALTER TABLE customers ADD COLUMN container_network TEXT`,
+		// Per-plan egress policy
+		`ALTER TABLE plans ADD COLUMN egress TEXT NOT NULL DEFAULT 'open'`,
+		// Pre-built agent images tenants are pinned to
+		`ALTER TABLE agent_types ADD COLUMN image TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE agent_types ADD COLUMN image_digest TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE customers ADD COLUMN image_digest TEXT`,
+		// Staged rollouts of agent images to tenants
+		`CREATE TABLE IF NOT EXISTS rollouts (
+			id TEXT PRIMARY KEY,
+			agent_type_id TEXT NOT NULL,
+			image_digest TEXT NOT NULL,
+			previous_digest TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			canary_size INTEGER NOT NULL,
+			concurrency INTEGER NOT NULL,
+			bake_seconds INTEGER NOT NULL,
+			batch_bake_seconds INTEGER NOT NULL,
+			max_error_rate REAL NOT NULL,
+			batch INTEGER NOT NULL DEFAULT 0,
+			batches INTEGER NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			finished_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_rollouts_status ON rollouts(status, created_at)`,
+		`CREATE TABLE IF NOT EXISTS rollout_tenants (
+			rollout_id TEXT NOT NULL,
+			customer_id TEXT NOT NULL,
+			batch INTEGER NOT NULL,
+			previous_digest TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			error TEXT NOT NULL DEFAULT '',
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (rollout_id, customer_id),
+			FOREIGN KEY (rollout_id) REFERENCES rollouts(id)
+		)`,
+		// Customers' own host names, routed once DNS verifies them
+		customDomainsTable,
+		// Every agent image recorded, so its tag can be found again by digest
+		`CREATE TABLE IF NOT EXISTS agent_images (
+			agent_type_id TEXT NOT NULL,
+			image_digest TEXT NOT NULL,
+			image TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (agent_type_id, image_digest)
+		)`,
+		`INSERT OR IGNORE INTO agent_images (agent_type_id, image_digest, image, created_at)
+			SELECT id, image_digest, image, CURRENT_TIMESTAMP FROM agent_types WHERE image_digest != ''`,
+		// Image tags a rollout moves tenants to and restores on rollback
+		`ALTER TABLE rollouts ADD COLUMN image TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE rollouts ADD COLUMN previous_image TEXT NOT NULL DEFAULT ''`,
+		// A domain belongs to whoever verifies it; pending claims may share it
+		customDomainsVerifiedIndex,
+		// Waitlist invitations sent to the invitee, so failed sends are retried
+		`ALTER TABLE waitlist ADD COLUMN invite_notified_at TIMESTAMP`,
 	}
 
 	for _, migration := range migrations {
@@ -130,11 +316,19 @@ func (db *DB) Migrate() error {
 		}
 	}
 
+	if err := db.rebuildCustomDomains(); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
 	// Seed default agents and LLM providers
 	if err := db.seedMarketplaceData(); err != nil {
 		return fmt.Errorf("seed marketplace data: %w", err)
 	}
 
+	if err := db.seedPlans(); err != nil {
+		return fmt.Errorf("seed plans: %w", err)
+	}
+
 	return nil
 }
 
@@ -190,11 +384,15 @@ type Customer struct {
 	TelegramBotUsername     *string    `json:"telegram_bot_username" db:"telegram_bot_username"`
 	ContainerPort           *int       `json:"container_port" db:"container_port"`
 	ContainerID             *string    `json:"container_id" db:"container_id"`
+	NodeID                  *string    `json:"node_id" db:"node_id"`
+	ContainerNetwork        *string    `json:"container_network" db:"container_network"`
+	ImageDigest             *string    `json:"image_digest" db:"image_digest"`
 	Status                  string     `json:"status" db:"status"`
 	StripeCustomerID        *string    `json:"stripe_customer_id" db:"stripe_customer_id"`
 	StripeSubscriptionID    *string    `json:"stripe_subscription_id" db:"stripe_subscription_id"`
 	StripeCheckoutSessionID *string    `json:"stripe_checkout_session_id" db:"stripe_checkout_session_id"`
 	SubscriptionStatus      *string    `json:"subscription_status" db:"subscription_status"`
+	CurrentPeriodStart      *time.Time `json:"current_period_start" db:"current_period_start"`
 	CurrentPeriodEnd        *time.Time `json:"current_period_end" db:"current_period_end"`
 	CreatedAt               time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt               time.Time  `json:"updated_at" db:"updated_at"`
@@ -205,6 +403,17 @@ type Customer struct {
 	AgentTypeID   string `json:"agent_type_id" db:"agent_type_id"`
 	LLMProviderID string `json:"llm_provider_id" db:"llm_provider_id"`
 	CustomConfig  string `json:"custom_config" db:"custom_config"`
+	PlanID        string `json:"plan_id" db:"plan_id"`
+}
+
+// InternalHost returns the name a tenant's container answers to on its
+// ContainerNetwork, where it listens on ContainerPort, or "" when the tenant
+// publishes ContainerPort on its host instead
+func (c *Customer) InternalHost() string {
+	if c.ContainerNetwork == nil {
+		return ""
+	}
+	return "blytz-" + c.ID
 }
 
 type AgentType struct {
@@ -220,6 +429,8 @@ type AgentType struct {
 	MinCPU             string    `json:"min_cpu" db:"min_cpu"`
 	ConfigTemplate     string    `json:"config_template" db:"config_template"`
 	EnvVars            string    `json:"env_vars" db:"env_vars"`
+	Image              string    `json:"image" db:"image"`
+	ImageDigest        string    `json:"image_digest" db:"image_digest"`
 	IsActive           bool      `json:"is_active" db:"is_active"`
 	CreatedAt          time.Time `json:"created_at" db:"created_at"`
 }
@@ -244,6 +455,7 @@ type CreateCustomerRequest struct {
 	LLMProviderID string `json:"llm_provider_id"`
 	LLMAPIKey     string `json:"llm_api_key"`
 	CustomConfig  string `json:"custom_config"`
+	PlanID        string `json:"plan_id"`
 }
 
 func (db *DB) CreateCustomer(ctx context.Context, req *CreateCustomerRequest) (*Customer, error) {
@@ -261,6 +473,10 @@ func (db *DB) CreateCustomer(ctx context.Context, req *CreateCustomerRequest) (*
 	if llmProviderID == "" {
 		llmProviderID = "openai"
 	}
+	planID := req.PlanID
+	if planID == "" {
+		planID = DefaultPlanID
+	}
 
 	customer := &Customer{
 		ID:                 id,
@@ -274,16 +490,17 @@ func (db *DB) CreateCustomer(ctx context.Context, req *CreateCustomerRequest) (*
 		AgentTypeID:        agentTypeID,
 		LLMProviderID:      llmProviderID,
 		CustomConfig:       req.CustomConfig,
+		PlanID:             planID,
 	}
 
-	query := `INSERT INTO customers (id, email, assistant_name, custom_instructions, telegram_bot_token, status, created_at, updated_at, agent_type_id, llm_provider_id, custom_config) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO customers (id, email, assistant_name, custom_instructions, telegram_bot_token, status, created_at, updated_at, agent_type_id, llm_provider_id, custom_config, plan_id) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	_, err := db.conn.ExecContext(ctx, query,
 		customer.ID, customer.Email, customer.AssistantName,
 		customer.CustomInstructions, customer.TelegramBotToken,
 		customer.Status, customer.CreatedAt, customer.UpdatedAt,
-		customer.AgentTypeID, customer.LLMProviderID, customer.CustomConfig)
+		customer.AgentTypeID, customer.LLMProviderID, customer.CustomConfig, customer.PlanID)
 
 	if err != nil {
 		return nil, fmt.Errorf("insert customer: %w", err)
@@ -300,8 +517,9 @@ func (db *DB) GetCustomerByID(ctx context.Context, id string) (*Customer, error)
 	query := `SELECT id, email, assistant_name, custom_instructions, telegram_bot_token, 
 			  telegram_bot_username, container_port, container_id, status, 
 			  stripe_customer_id, stripe_subscription_id, stripe_checkout_session_id,
-			  subscription_status, current_period_end, created_at, updated_at, 
-			  paid_at, suspended_at, cancelled_at, agent_type_id, llm_provider_id, custom_config
+			  subscription_status, current_period_start, current_period_end, created_at, updated_at, 
+			  paid_at, suspended_at, cancelled_at, agent_type_id, llm_provider_id, custom_config, plan_id, node_id,
+			  container_network, image_digest
 			  FROM customers WHERE id = ?`
 
 	row := db.conn.QueryRowContext(ctx, query, id)
@@ -312,10 +530,11 @@ func (db *DB) GetCustomerByID(ctx context.Context, id string) (*Customer, error)
 		&customer.CustomInstructions, &customer.TelegramBotToken,
 		&customer.TelegramBotUsername, &customer.ContainerPort, &customer.ContainerID,
 		&customer.Status, &customer.StripeCustomerID, &customer.StripeSubscriptionID,
-		&customer.StripeCheckoutSessionID, &customer.SubscriptionStatus, &customer.CurrentPeriodEnd,
+		&customer.StripeCheckoutSessionID, &customer.SubscriptionStatus, &customer.CurrentPeriodStart, &customer.CurrentPeriodEnd,
 		&customer.CreatedAt, &customer.UpdatedAt, &customer.PaidAt,
 		&customer.SuspendedAt, &customer.CancelledAt, &customer.AgentTypeID,
-		&customer.LLMProviderID, &customer.CustomConfig,
+		&customer.LLMProviderID, &customer.CustomConfig, &customer.PlanID, &customer.NodeID,
+		&customer.ContainerNetwork, &customer.ImageDigest,
 	)
 
 	if err == sql.ErrNoRows {
@@ -354,7 +573,7 @@ func (db *DB) UpdateCustomerStatus(ctx context.Context, id string, status string
 }
 
 func (db *DB) CountActiveCustomers(ctx context.Context) (int, error) {
-	query := `SELECT COUNT(*) FROM customers WHERE status IN ('pending', 'provisioning', 'active')`
+	query := `SELECT COUNT(*) FROM customers WHERE status IN ('pending', 'provisioning', 'active', 'degraded')`
 	row := db.conn.QueryRowContext(ctx, query)
 
 	var count int
@@ -366,6 +585,59 @@ func (db *DB) CountActiveCustomers(ctx context.Context) (int, error) {
 	return count, nil
 }
 
+// CountCustomersByStatus returns the number of customers in each status
+func (db *DB) CountCustomersByStatus(ctx context.Context) (map[string]int, error) {
+	query := `SELECT status, COUNT(*) FROM customers GROUP BY status`
+	rows, err := db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("count customers by status: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("scan status count: %w", err)
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// ListCustomerIDsByStatus returns the IDs of customers in any of the given statuses
+func (db *DB) ListCustomerIDsByStatus(ctx context.Context, statuses ...string) ([]string, error) {
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	query := `SELECT id FROM customers WHERE status IN (` + placeholders + `) ORDER BY id`
+	args := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		args[i] = status
+	}
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list customers by status: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan customer id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
 func (db *DB) AllocatePort(ctx context.Context, customerID string, port int) error {
 	query := `INSERT INTO port_allocations (port, customer_id) VALUES (?, ?)`
 	_, err := db.conn.ExecContext(ctx, query, port, customerID)
@@ -414,7 +686,7 @@ func (db *DB) UpdateCustomerTelegramUsername(ctx context.Context, id string, use
 }
 
 func (db *DB) ClearCustomerPort(ctx context.Context, id string) error {
-	query := `UPDATE customers SET container_port = NULL, updated_at = ? WHERE id = ?`
+	query := `UPDATE customers SET container_port = NULL, container_network = NULL, updated_at = ? WHERE id = ?`
 	_, err := db.conn.ExecContext(ctx, query, time.Now(), id)
 	if err != nil {
 		return fmt.Errorf("clear customer port: %w", err)
@@ -463,6 +735,33 @@ func (db *DB) UpdateCustomerPort(ctx context.Context, id string, port int) error
 	return nil
 }
 
+// SetCustomerImage pins a tenant to an agent image by digest
+func (db *DB) SetCustomerImage(ctx context.Context, id, digest string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE customers SET image_digest = ?, updated_at = ? WHERE id = ?`,
+		digest, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set customer image: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("customer not found")
+	}
+	return nil
+}
+
+// SetCustomerNetwork records that a tenant is reached on a Docker network at
+// its container's internal port rather than on a published host port
+func (db *DB) SetCustomerNetwork(ctx context.Context, id, network string, port int) error {
+	query := `UPDATE customers SET container_network = ?, container_port = ?, updated_at = ? WHERE id = ?`
+	result, err := db.conn.ExecContext(ctx, query, network, port, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set customer network: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("customer not found")
+	}
+	return nil
+}
+
 func (db *DB) logAudit(ctx context.Context, customerID, action string, details interface{}) error {
 	query := `INSERT INTO audit_log (customer_id, action, details) VALUES (?, ?, ?)`
 	_, err := db.conn.ExecContext(ctx, query, customerID, action, details)
@@ -475,7 +774,8 @@ func (db *DB) logAudit(ctx context.Context, customerID, action string, details i
 // GetAgentTypes returns all active agent types
 func (db *DB) GetAgentTypes(ctx context.Context) ([]AgentType, error) {
 	query := `SELECT id, name, description, language, base_image, internal_port, internal_port_bridge,
-			health_endpoint, min_memory, min_cpu, COALESCE(config_template, ''), COALESCE(env_vars, ''), is_active, created_at
+			health_endpoint, min_memory, min_cpu, COALESCE(config_template, ''), COALESCE(env_vars, ''), image, image_digest,
+			is_active, created_at
 			FROM agent_types WHERE is_active = true ORDER BY name`
 
 	rows, err := db.conn.QueryContext(ctx, query)
@@ -491,7 +791,7 @@ func (db *DB) GetAgentTypes(ctx context.Context) ([]AgentType, error) {
 			&agent.ID, &agent.Name, &agent.Description, &agent.Language, &agent.BaseImage,
 			&agent.InternalPort, &agent.InternalPortBridge, &agent.HealthEndpoint,
 			&agent.MinMemory, &agent.MinCPU, &agent.ConfigTemplate, &agent.EnvVars,
-			&agent.IsActive, &agent.CreatedAt,
+			&agent.Image, &agent.ImageDigest, &agent.IsActive, &agent.CreatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan agent type: %w", err)
@@ -505,7 +805,8 @@ func (db *DB) GetAgentTypes(ctx context.Context) ([]AgentType, error) {
 // GetAgentType returns a specific agent type by ID
 func (db *DB) GetAgentType(ctx context.Context, id string) (*AgentType, error) {
 	query := `SELECT id, name, description, language, base_image, internal_port, internal_port_bridge,
-			health_endpoint, min_memory, min_cpu, COALESCE(config_template, ''), COALESCE(env_vars, ''), is_active, created_at
+			health_endpoint, min_memory, min_cpu, COALESCE(config_template, ''), COALESCE(env_vars, ''), image, image_digest,
+			is_active, created_at
 			FROM agent_types WHERE id = ? AND is_active = true`
 
 	row := db.conn.QueryRowContext(ctx, query, id)
@@ -515,7 +816,7 @@ func (db *DB) GetAgentType(ctx context.Context, id string) (*AgentType, error) {
 		&agent.ID, &agent.Name, &agent.Description, &agent.Language, &agent.BaseImage,
 		&agent.InternalPort, &agent.InternalPortBridge, &agent.HealthEndpoint,
 		&agent.MinMemory, &agent.MinCPU, &agent.ConfigTemplate, &agent.EnvVars,
-		&agent.IsActive, &agent.CreatedAt,
+		&agent.Image, &agent.ImageDigest, &agent.IsActive, &agent.CreatedAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("agent type not found: %s", id)
@@ -527,6 +828,53 @@ func (db *DB) GetAgentType(ctx context.Context, id string) (*AgentType, error) {
 	return &agent, nil
 }
 
+// SetAgentTypeImage records a pre-built image of an agent type: its versioned
+// tag and the reference by digest new tenants are pinned to. Until one is
+// recorded tenants run BaseImage. Every image recorded is kept so
+// AgentImageTag can find its tag again.
+func (db *DB) SetAgentTypeImage(ctx context.Context, id, image, digest string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin set agent type image: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE agent_types SET image = ?, image_digest = ? WHERE id = ?`, image, digest, id)
+	if err != nil {
+		return fmt.Errorf("set agent type image: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("agent type not found: %s", id)
+	}
+	if digest != "" {
+		_, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO agent_images (agent_type_id, image_digest, image, created_at)
+			VALUES (?, ?, ?, ?)`, id, digest, image, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("record agent image: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit agent type image: %w", err)
+	}
+	return nil
+}
+
+// AgentImageTag returns the tag an agent type's image was recorded with, or
+// an empty string if no image with the digest was ever recorded
+func (db *DB) AgentImageTag(ctx context.Context, agentTypeID, digest string) (string, error) {
+	var image string
+	err := db.conn.QueryRowContext(ctx, `SELECT image FROM agent_images WHERE agent_type_id = ? AND image_digest = ?`,
+		agentTypeID, digest).Scan(&image)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("query agent image: %w", err)
+	}
+	return image, nil
+}
+
 // GetLLMProviders returns all active LLM providers
 func (db *DB) GetLLMProviders(ctx context.Context) ([]LLMProvider, error) {
 	query := `SELECT id, name, description, env_key, base_url, is_active, created_at 
@@ -493,3 +493,52 @@ func TestDBClose(t *testing.T) {
 	err = database.Close()
 	require.NoError(t, err)
 }
+
+func TestCountCustomersByStatus(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	a := createTestCustomer(t, database, "a@example.com")
+	createTestCustomer(t, database, "b@example.com")
+	c := createTestCustomer(t, database, "c@example.com")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, a.ID, "active"))
+	require.NoError(t, database.UpdateCustomerStatus(ctx, c.ID, "cancelled"))
+
+	counts, err := database.CountCustomersByStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"active": 1, "pending": 1, "cancelled": 1}, counts)
+}
+
+func TestListCustomerIDsByStatus(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	a := createTestCustomer(t, database, "a@example.com")
+	b := createTestCustomer(t, database, "b@example.com")
+	createTestCustomer(t, database, "c@example.com")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, a.ID, "active"))
+	require.NoError(t, database.UpdateCustomerStatus(ctx, b.ID, "suspended"))
+
+	ids, err := database.ListCustomerIDsByStatus(ctx, "active", "suspended")
+	require.NoError(t, err)
+	assert.Equal(t, []string{a.ID, b.ID}, ids)
+
+	ids, err = database.ListCustomerIDsByStatus(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
+
+func TestWriteProbe(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	require.NoError(t, database.WriteProbe(ctx))
+	require.NoError(t, database.WriteProbe(ctx))
+
+	var rows int
+	require.NoError(t, database.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM health_probe").Scan(&rows))
+	assert.Equal(t, 1, rows)
+
+	database.Close()
+	assert.Error(t, database.WriteProbe(ctx))
+}
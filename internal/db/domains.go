@@ -0,0 +1,218 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Custom domain statuses
+const (
+	// DomainPending domains wait for their DNS records to be found
+	DomainPending = "pending"
+	// DomainVerified domains passed the DNS check and are routed to the tenant
+	DomainVerified = "verified"
+)
+
+// CustomDomain is a customer's own host name served by their agent
+type CustomDomain struct {
+	CustomerID string `json:"customer_id"`
+	Domain     string `json:"domain"`
+	// Token is published in a TXT record to prove control of the domain
+	Token  string `json:"token"`
+	Status string `json:"status"`
+	// Error is why the last DNS check failed
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+const customDomainColumns = `customer_id, domain, token, status, error, created_at, checked_at, verified_at`
+
+const customDomainsTable = `CREATE TABLE IF NOT EXISTS custom_domains (
+			customer_id TEXT PRIMARY KEY,
+			domain TEXT NOT NULL,
+			token TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			checked_at TIMESTAMP,
+			verified_at TIMESTAMP,
+			FOREIGN KEY (customer_id) REFERENCES customers(id)
+		)`
+
+const customDomainsVerifiedIndex = `CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_domains_verified
+			ON custom_domains(domain) WHERE verified_at IS NOT NULL`
+
+// rebuildCustomDomains drops the UNIQUE constraint the first custom_domains
+// table put on every domain, which let an unverified claim hold a name
+// forever. SQLite cannot drop a constraint, so the rows are copied into a new
+// table.
+func (db *DB) rebuildCustomDomains() error {
+	var schema string
+	err := db.conn.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'custom_domains'`).Scan(&schema)
+	if err != nil {
+		return fmt.Errorf("read custom domains schema: %w", err)
+	}
+	if !strings.Contains(schema, "domain TEXT NOT NULL UNIQUE") {
+		return nil
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("begin custom domains rebuild: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`ALTER TABLE custom_domains RENAME TO custom_domains_old`,
+		customDomainsTable,
+		`INSERT INTO custom_domains (` + customDomainColumns + `) SELECT ` + customDomainColumns + ` FROM custom_domains_old`,
+		`DROP TABLE custom_domains_old`,
+		customDomainsVerifiedIndex,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("rebuild custom domains: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit custom domains rebuild: %w", err)
+	}
+	return nil
+}
+
+// SetCustomDomain gives a customer a pending custom domain with a new
+// verification token, replacing the domain they had. Several customers may
+// have the same domain pending; only one of them can verify it.
+func (db *DB) SetCustomDomain(ctx context.Context, customerID, domain, token string) (*CustomDomain, error) {
+	now := time.Now().UTC()
+	query := `INSERT INTO custom_domains (customer_id, domain, token, status, error, created_at)
+			  VALUES (?, ?, ?, ?, '', ?)
+			  ON CONFLICT(customer_id) DO UPDATE SET domain = excluded.domain, token = excluded.token,
+			  status = excluded.status, error = '', created_at = excluded.created_at, checked_at = NULL, verified_at = NULL`
+	if _, err := db.conn.ExecContext(ctx, query, customerID, domain, token, DomainPending, now); err != nil {
+		return nil, fmt.Errorf("set custom domain: %w", err)
+	}
+
+	return &CustomDomain{
+		CustomerID: customerID,
+		Domain:     domain,
+		Token:      token,
+		Status:     DomainPending,
+		CreatedAt:  now,
+	}, nil
+}
+
+// GetCustomDomain returns the customer's custom domain
+func (db *DB) GetCustomDomain(ctx context.Context, customerID string) (*CustomDomain, error) {
+	d, err := db.findCustomDomain(ctx, `customer_id = ?`, customerID)
+	if err == nil && d == nil {
+		return nil, fmt.Errorf("custom domain not found")
+	}
+	return d, err
+}
+
+// GetVerifiedCustomDomain returns the verified custom domain with the given host name
+func (db *DB) GetVerifiedCustomDomain(ctx context.Context, domain string) (*CustomDomain, error) {
+	d, err := db.findCustomDomain(ctx, `domain = ? AND verified_at IS NOT NULL`, domain)
+	if err == nil && d == nil {
+		return nil, fmt.Errorf("custom domain not found")
+	}
+	return d, err
+}
+
+// findCustomDomain returns the custom domain matching the condition, or nil if there is none
+func (db *DB) findCustomDomain(ctx context.Context, condition string, arg interface{}) (*CustomDomain, error) {
+	row := db.conn.QueryRowContext(ctx, `SELECT `+customDomainColumns+` FROM custom_domains WHERE `+condition, arg)
+	d, err := scanCustomDomain(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query custom domain: %w", err)
+	}
+	return d, nil
+}
+
+// ListCustomDomains returns the custom domains with the given status, oldest first
+func (db *DB) ListCustomDomains(ctx context.Context, status string) ([]CustomDomain, error) {
+	query := `SELECT ` + customDomainColumns + ` FROM custom_domains WHERE status = ? ORDER BY created_at, customer_id`
+	rows, err := db.conn.QueryContext(ctx, query, status)
+	if err != nil {
+		return nil, fmt.Errorf("query custom domains: %w", err)
+	}
+	defer rows.Close()
+
+	domains := []CustomDomain{}
+	for rows.Next() {
+		d, err := scanCustomDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan custom domain: %w", err)
+		}
+		domains = append(domains, *d)
+	}
+
+	return domains, rows.Err()
+}
+
+// MarkCustomDomainChecked records a failed DNS check of a pending domain. It
+// is a no-op if the customer has since changed their domain.
+func (db *DB) MarkCustomDomainChecked(ctx context.Context, customerID, domain, reason string) error {
+	query := `UPDATE custom_domains SET error = ?, checked_at = ? WHERE customer_id = ? AND domain = ?`
+	if _, err := db.conn.ExecContext(ctx, query, reason, time.Now().UTC(), customerID, domain); err != nil {
+		return fmt.Errorf("mark custom domain checked: %w", err)
+	}
+	return nil
+}
+
+// VerifyCustomDomain marks a customer's domain verified. It fails if the
+// customer has since changed their domain or another customer verified it first.
+func (db *DB) VerifyCustomDomain(ctx context.Context, customerID, domain string) error {
+	now := time.Now().UTC()
+	query := `UPDATE custom_domains SET status = ?, error = '', checked_at = ?, verified_at = ?
+			  WHERE customer_id = ? AND domain = ?`
+	result, err := db.conn.ExecContext(ctx, query, DomainVerified, now, now, customerID, domain)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("domain %s is already in use", domain)
+		}
+		return fmt.Errorf("verify custom domain: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("verify custom domain: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("custom domain not found")
+	}
+
+	return nil
+}
+
+// DeleteCustomDomain removes the customer's custom domain, freeing the host name
+func (db *DB) DeleteCustomDomain(ctx context.Context, customerID string) error {
+	if _, err := db.conn.ExecContext(ctx, `DELETE FROM custom_domains WHERE customer_id = ?`, customerID); err != nil {
+		return fmt.Errorf("delete custom domain: %w", err)
+	}
+	return nil
+}
+
+func scanCustomDomain(row interface{ Scan(...interface{}) error }) (*CustomDomain, error) {
+	var d CustomDomain
+	var checkedAt, verifiedAt sql.NullTime
+	if err := row.Scan(&d.CustomerID, &d.Domain, &d.Token, &d.Status, &d.Error, &d.CreatedAt, &checkedAt, &verifiedAt); err != nil {
+		return nil, err
+	}
+	if checkedAt.Valid {
+		d.CheckedAt = &checkedAt.Time
+	}
+	if verifiedAt.Valid {
+		d.VerifiedAt = &verifiedAt.Time
+	}
+	return &d, nil
+}
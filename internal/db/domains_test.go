@@ -0,0 +1,103 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomDomains(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	alice := createTestCustomer(t, database, "alice@example.com")
+	bob := createTestCustomer(t, database, "bob@example.com")
+
+	_, err := database.GetCustomDomain(ctx, alice.ID)
+	assert.EqualError(t, err, "custom domain not found")
+
+	domain, err := database.SetCustomDomain(ctx, alice.ID, "assistant.alice.example", "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, DomainPending, domain.Status)
+
+	// A pending claim does not keep others from claiming the domain
+	_, err = database.SetCustomDomain(ctx, bob.ID, "assistant.alice.example", "token-2")
+	require.NoError(t, err)
+
+	require.NoError(t, database.MarkCustomDomainChecked(ctx, alice.ID, "assistant.alice.example", "no TXT record"))
+	pending, err := database.ListCustomDomains(ctx, DomainPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, "no TXT record", pending[0].Error)
+	assert.NotNil(t, pending[0].CheckedAt)
+
+	// A verification of a domain the customer no longer has is refused
+	assert.Error(t, database.VerifyCustomDomain(ctx, alice.ID, "old.alice.example"))
+	require.NoError(t, database.VerifyCustomDomain(ctx, alice.ID, "assistant.alice.example"))
+
+	// Only one customer can hold the verified domain
+	assert.EqualError(t, database.VerifyCustomDomain(ctx, bob.ID, "assistant.alice.example"),
+		"domain assistant.alice.example is already in use")
+
+	got, err := database.GetVerifiedCustomDomain(ctx, "assistant.alice.example")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, got.CustomerID)
+	assert.Equal(t, DomainVerified, got.Status)
+	assert.Empty(t, got.Error)
+	assert.NotNil(t, got.VerifiedAt)
+
+	// Changing the domain starts verification over
+	_, err = database.SetCustomDomain(ctx, alice.ID, "bot.alice.example", "token-3")
+	require.NoError(t, err)
+	got, err = database.GetCustomDomain(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "bot.alice.example", got.Domain)
+	assert.Equal(t, "token-3", got.Token)
+	assert.Equal(t, DomainPending, got.Status)
+	assert.Nil(t, got.VerifiedAt)
+
+	verified, err := database.ListCustomDomains(ctx, DomainVerified)
+	require.NoError(t, err)
+	assert.Empty(t, verified)
+
+	require.NoError(t, database.DeleteCustomDomain(ctx, alice.ID))
+	_, err = database.SetCustomDomain(ctx, bob.ID, "bot.alice.example", "token-4")
+	require.NoError(t, err)
+	assert.NoError(t, database.VerifyCustomDomain(ctx, bob.ID, "bot.alice.example"), "deleted domains can be claimed by others")
+}
+
+func TestRebuildCustomDomains(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	alice := createTestCustomer(t, database, "alice@example.com")
+	bob := createTestCustomer(t, database, "bob@example.com")
+
+	// Recreate the first schema, which made every domain unique
+	_, err := database.conn.Exec(`DROP TABLE custom_domains`)
+	require.NoError(t, err)
+	_, err = database.conn.Exec(`CREATE TABLE custom_domains (
+			customer_id TEXT PRIMARY KEY,
+			domain TEXT NOT NULL UNIQUE,
+			token TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL,
+			checked_at TIMESTAMP,
+			verified_at TIMESTAMP,
+			FOREIGN KEY (customer_id) REFERENCES customers(id)
+		)`)
+	require.NoError(t, err)
+	_, err = database.SetCustomDomain(ctx, alice.ID, "assistant.example", "token-1")
+	require.NoError(t, err)
+
+	require.NoError(t, database.Migrate())
+
+	got, err := database.GetCustomDomain(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "token-1", got.Token)
+
+	_, err = database.SetCustomDomain(ctx, bob.ID, "assistant.example", "token-2")
+	require.NoError(t, err)
+	require.NoError(t, database.VerifyCustomDomain(ctx, bob.ID, "assistant.example"))
+	assert.Error(t, database.VerifyCustomDomain(ctx, alice.ID, "assistant.example"))
+}
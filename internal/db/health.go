@@ -0,0 +1,21 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WriteProbe verifies the database accepts writes by upserting a single probe row.
+// A read-only or full volume fails here long before customer writes do.
+func (db *DB) WriteProbe(ctx context.Context) error {
+	_, err := db.conn.ExecContext(ctx,
+		`INSERT INTO health_probe (id, checked_at) VALUES (1, ?)
+		 ON CONFLICT(id) DO UPDATE SET checked_at = excluded.checked_at`,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("write probe: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LLMUsage is one metered request through the LLM proxy
+type LLMUsage struct {
+	OccurredAt   time.Time
+	Provider     string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+	CostMicros   int64
+}
+
+// LLMUsageBucket is a customer's proxied LLM usage for one model over one hour
+type LLMUsageBucket struct {
+	Start        time.Time `json:"start"`
+	Provider     string    `json:"provider"`
+	Model        string    `json:"model"`
+	Requests     int       `json:"requests"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CostMicros   int64     `json:"cost_micros"`
+}
+
+// LLMModelUsage is a customer's proxied LLM usage for one model over a period
+type LLMModelUsage struct {
+	Provider     string `json:"provider"`
+	Model        string `json:"model"`
+	Requests     int    `json:"requests"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	CostMicros   int64  `json:"cost_micros"`
+}
+
+// SetLLMProxyToken stores the hash of the token a tenant uses to call the LLM proxy
+func (db *DB) SetLLMProxyToken(ctx context.Context, id, token string) error {
+	query := `UPDATE customers SET llm_proxy_token_hash = ?, updated_at = ? WHERE id = ?`
+	_, err := db.conn.ExecContext(ctx, query, hashToken(token), time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set llm proxy token: %w", err)
+	}
+	return nil
+}
+
+// GetCustomerByLLMProxyToken looks up the customer holding an LLM proxy token
+func (db *DB) GetCustomerByLLMProxyToken(ctx context.Context, token string) (*Customer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("customer not found")
+	}
+
+	query := `SELECT id FROM customers WHERE llm_proxy_token_hash = ?`
+	var id string
+	err := db.conn.QueryRowContext(ctx, query, hashToken(token)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("customer not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query customer by llm proxy token: %w", err)
+	}
+
+	return db.GetCustomerByID(ctx, id)
+}
+
+// RecordLLMUsage adds a proxied request to the customer's hourly LLM usage
+func (db *DB) RecordLLMUsage(ctx context.Context, customerID string, usage LLMUsage) error {
+	query := `INSERT INTO llm_usage
+			  (customer_id, bucket_start, provider, model, requests, input_tokens, output_tokens, cost_micros)
+			  VALUES (?, ?, ?, ?, 1, ?, ?, ?)
+			  ON CONFLICT(customer_id, bucket_start, provider, model) DO UPDATE SET
+				requests = requests + 1,
+				input_tokens = input_tokens + excluded.input_tokens,
+				output_tokens = output_tokens + excluded.output_tokens,
+				cost_micros = cost_micros + excluded.cost_micros`
+
+	_, err := db.conn.ExecContext(ctx, query, customerID, usage.OccurredAt.UTC().Truncate(UsageBucketSize),
+		usage.Provider, usage.Model, usage.InputTokens, usage.OutputTokens, usage.CostMicros)
+	if err != nil {
+		return fmt.Errorf("record llm usage: %w", err)
+	}
+	return nil
+}
+
+// GetLLMSpend returns the customer's proxied LLM cost in micro-dollars since the given time
+func (db *DB) GetLLMSpend(ctx context.Context, customerID string, since time.Time) (int64, error) {
+	query := `SELECT COALESCE(SUM(cost_micros), 0) FROM llm_usage WHERE customer_id = ? AND bucket_start >= ?`
+	var spend int64
+	if err := db.conn.QueryRowContext(ctx, query, customerID, since.UTC().Truncate(UsageBucketSize)).Scan(&spend); err != nil {
+		return 0, fmt.Errorf("query llm spend: %w", err)
+	}
+	return spend, nil
+}
+
+// ListLLMUsage returns the customer's hourly LLM usage since the given time, oldest first
+func (db *DB) ListLLMUsage(ctx context.Context, customerID string, since time.Time) ([]LLMUsageBucket, error) {
+	query := `SELECT bucket_start, provider, model, requests, input_tokens, output_tokens, cost_micros
+			  FROM llm_usage WHERE customer_id = ? AND bucket_start >= ?
+			  ORDER BY bucket_start, provider, model`
+	rows, err := db.conn.QueryContext(ctx, query, customerID, since.UTC().Truncate(UsageBucketSize))
+	if err != nil {
+		return nil, fmt.Errorf("query llm usage: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []LLMUsageBucket{}
+	for rows.Next() {
+		var b LLMUsageBucket
+		if err := rows.Scan(&b.Start, &b.Provider, &b.Model, &b.Requests, &b.InputTokens, &b.OutputTokens, &b.CostMicros); err != nil {
+			return nil, fmt.Errorf("scan llm usage: %w", err)
+		}
+		buckets = append(buckets, b)
+	}
+
+	return buckets, rows.Err()
+}
+
+// SummarizeLLMUsage totals the customer's LLM usage per model since the given time, costliest first
+func (db *DB) SummarizeLLMUsage(ctx context.Context, customerID string, since time.Time) ([]LLMModelUsage, error) {
+	query := `SELECT provider, model, SUM(requests), SUM(input_tokens), SUM(output_tokens), SUM(cost_micros)
+			  FROM llm_usage WHERE customer_id = ? AND bucket_start >= ?
+			  GROUP BY provider, model
+			  ORDER BY 6 DESC, provider, model`
+	rows, err := db.conn.QueryContext(ctx, query, customerID, since.UTC().Truncate(UsageBucketSize))
+	if err != nil {
+		return nil, fmt.Errorf("query llm usage summary: %w", err)
+	}
+	defer rows.Close()
+
+	models := []LLMModelUsage{}
+	for rows.Next() {
+		var m LLMModelUsage
+		if err := rows.Scan(&m.Provider, &m.Model, &m.Requests, &m.InputTokens, &m.OutputTokens, &m.CostMicros); err != nil {
+			return nil, fmt.Errorf("scan llm usage summary: %w", err)
+		}
+		models = append(models, m)
+	}
+
+	return models, rows.Err()
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLLMProxyToken(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "proxy@example.com")
+
+	_, err := database.GetCustomerByLLMProxyToken(ctx, "llm-token")
+	assert.Error(t, err)
+
+	require.NoError(t, database.SetLLMProxyToken(ctx, customer.ID, "llm-token"))
+
+	found, err := database.GetCustomerByLLMProxyToken(ctx, "llm-token")
+	require.NoError(t, err)
+	assert.Equal(t, customer.ID, found.ID)
+
+	_, err = database.GetCustomerByLLMProxyToken(ctx, "")
+	assert.Error(t, err)
+
+	// Proxy tokens do not grant API or gateway access
+	_, err = database.GetCustomerByAccessToken(ctx, "llm-token")
+	assert.Error(t, err)
+	_, err = database.GetCustomerByGatewayToken(ctx, "llm-token")
+	assert.Error(t, err)
+}
+
+func TestRecordLLMUsage(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "llm@example.com")
+	other := createTestCustomer(t, database, "other@example.com")
+
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	records := []LLMUsage{
+		{OccurredAt: hour.Add(5 * time.Minute), Provider: "openai", Model: "gpt-4o", InputTokens: 100, OutputTokens: 50, CostMicros: 750},
+		{OccurredAt: hour.Add(40 * time.Minute), Provider: "openai", Model: "gpt-4o", InputTokens: 200, OutputTokens: 10, CostMicros: 600},
+		{OccurredAt: hour.Add(70 * time.Minute), Provider: "anthropic", Model: "claude-sonnet-4", InputTokens: 10, OutputTokens: 10, CostMicros: 180},
+	}
+	for _, r := range records {
+		require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, r))
+	}
+	require.NoError(t, database.RecordLLMUsage(ctx, other.ID, LLMUsage{
+		OccurredAt: hour, Provider: "openai", Model: "gpt-4o", CostMicros: 999999,
+	}))
+
+	buckets, err := database.ListLLMUsage(ctx, customer.ID, hour)
+	require.NoError(t, err)
+	require.Len(t, buckets, 2)
+	assert.Equal(t, 2, buckets[0].Requests)
+	assert.Equal(t, 300, buckets[0].InputTokens)
+	assert.Equal(t, int64(1350), buckets[0].CostMicros)
+	assert.True(t, buckets[1].Start.Equal(hour.Add(time.Hour)))
+
+	spend, err := database.GetLLMSpend(ctx, customer.ID, hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1530), spend)
+
+	spend, err = database.GetLLMSpend(ctx, customer.ID, hour.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(180), spend)
+
+	models, err := database.SummarizeLLMUsage(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, models, 2)
+	assert.Equal(t, "gpt-4o", models[0].Model, "costliest first")
+	assert.Equal(t, 60, models[0].OutputTokens)
+	assert.Equal(t, "anthropic", models[1].Provider)
+}
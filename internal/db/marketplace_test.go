@@ -79,6 +79,28 @@ func TestGetAgentType(t *testing.T) {
 	assert.Contains(t, err.Error(), "not found")
 }
 
+func TestSetAgentTypeImage(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", "blytz/openclaw:1.4.2", "sha256:old"))
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", "blytz/openclaw:1.5.0", "sha256:new"))
+	agent, err := database.GetAgentType(ctx, "openclaw")
+	require.NoError(t, err)
+	assert.Equal(t, "blytz/openclaw:1.5.0", agent.Image)
+	assert.Equal(t, "sha256:new", agent.ImageDigest)
+
+	// Earlier images keep their tags
+	tag, err := database.AgentImageTag(ctx, "openclaw", "sha256:old")
+	require.NoError(t, err)
+	assert.Equal(t, "blytz/openclaw:1.4.2", tag)
+	tag, err = database.AgentImageTag(ctx, "myrai", "sha256:old")
+	require.NoError(t, err)
+	assert.Empty(t, tag)
+
+	assert.Error(t, database.SetAgentTypeImage(ctx, "nonexistent", "blytz/nonexistent:1.0.0", "sha256:x"))
+}
+
 func TestGetLLMProviders(t *testing.T) {
 	database, err := New(":memory:")
 	require.NoError(t, err)
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Node statuses
+const (
+	// NodeActive nodes receive new tenants
+	NodeActive = "active"
+	// NodeCordoned nodes keep their tenants but receive no new ones
+	NodeCordoned = "cordoned"
+)
+
+// Node is a worker host running tenant containers through its node agent
+type Node struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Address is the host Caddy and the supervisor reach published tenant ports on
+	Address string `json:"address"`
+	// AgentURL is the base URL of the node agent the control plane drives
+	AgentURL  string            `json:"agent_url"`
+	MemoryMB  int               `json:"memory_mb"`
+	CPUs      float64           `json:"cpus"`
+	PortStart int               `json:"port_start"`
+	PortEnd   int               `json:"port_end"`
+	Labels    map[string]string `json:"labels"`
+	Status    string            `json:"status"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// NodeTenant is a tenant placed on a node with the resources its plan reserves
+type NodeTenant struct {
+	NodeID     string
+	CustomerID string
+	Port       int
+	Memory     string
+	CPU        string
+}
+
+const nodeColumns = `id, name, address, agent_url, memory_mb, cpus, port_start, port_end, labels, status, created_at`
+
+// CreateNode registers a worker node, filling in its ID, status and creation time
+func (db *DB) CreateNode(ctx context.Context, node *Node) error {
+	labels := []byte("{}")
+	if len(node.Labels) > 0 {
+		var err error
+		if labels, err = json.Marshal(node.Labels); err != nil {
+			return fmt.Errorf("encode node labels: %w", err)
+		}
+	}
+
+	node.ID = uuid.New().String()
+	node.Status = NodeActive
+	node.CreatedAt = time.Now().UTC()
+
+	query := `INSERT INTO nodes (` + nodeColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := db.conn.ExecContext(ctx, query, node.ID, node.Name, node.Address, node.AgentURL, node.MemoryMB,
+		node.CPUs, node.PortStart, node.PortEnd, string(labels), node.Status, node.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert node: %w", err)
+	}
+	return nil
+}
+
+// GetNode returns a node by ID
+func (db *DB) GetNode(ctx context.Context, id string) (*Node, error) {
+	node, err := scanNode(db.conn.QueryRowContext(ctx, `SELECT `+nodeColumns+` FROM nodes WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("node not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query node: %w", err)
+	}
+	return node, nil
+}
+
+// ListNodes returns every registered node by name
+func (db *DB) ListNodes(ctx context.Context) ([]Node, error) {
+	rows, err := db.conn.QueryContext(ctx, `SELECT `+nodeColumns+` FROM nodes ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("query nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := []Node{}
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan node: %w", err)
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, rows.Err()
+}
+
+// SetNodeStatus cordons a node or returns it to service
+func (db *DB) SetNodeStatus(ctx context.Context, id, status string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE nodes SET status = ? WHERE id = ?`, status, id)
+	if err != nil {
+		return fmt.Errorf("set node status: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("node not found")
+	}
+	return nil
+}
+
+// DeleteNode removes a node from the registry. Nodes still running tenants are kept.
+func (db *DB) DeleteNode(ctx context.Context, id string) error {
+	result, err := db.conn.ExecContext(ctx,
+		`DELETE FROM nodes WHERE id = ? AND NOT EXISTS (SELECT 1 FROM customers WHERE node_id = ?)`, id, id)
+	if err != nil {
+		return fmt.Errorf("delete node: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		if _, err := db.GetNode(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("node has tenants")
+	}
+	return nil
+}
+
+// ListNodeTenants returns every tenant placed on a node with its plan's limits
+func (db *DB) ListNodeTenants(ctx context.Context) ([]NodeTenant, error) {
+	query := `SELECT c.node_id, c.id, c.container_port, COALESCE(p.memory, ''), COALESCE(p.cpu, '')
+			  FROM customers c LEFT JOIN plans p ON p.id = c.plan_id
+			  WHERE c.node_id IS NOT NULL
+			  ORDER BY c.node_id, c.container_port`
+	rows, err := db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query node tenants: %w", err)
+	}
+	defer rows.Close()
+
+	tenants := []NodeTenant{}
+	for rows.Next() {
+		var t NodeTenant
+		if err := rows.Scan(&t.NodeID, &t.CustomerID, &t.Port, &t.Memory, &t.CPU); err != nil {
+			return nil, fmt.Errorf("scan node tenant: %w", err)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// AssignNode places a tenant on a node at the given host port. The port must
+// be free on that node.
+func (db *DB) AssignNode(ctx context.Context, customerID, nodeID string, port int) error {
+	result, err := db.conn.ExecContext(ctx,
+		`UPDATE customers SET node_id = ?, container_port = ?, container_network = NULL, updated_at = ? WHERE id = ?`,
+		nodeID, port, time.Now(), customerID)
+	if err != nil {
+		return fmt.Errorf("assign node: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("customer not found")
+	}
+	return nil
+}
+
+// ClearCustomerNode releases a tenant's node and the port it held there
+func (db *DB) ClearCustomerNode(ctx context.Context, customerID string) error {
+	_, err := db.conn.ExecContext(ctx,
+		`UPDATE customers SET node_id = NULL, container_port = NULL, updated_at = ? WHERE id = ?`,
+		time.Now(), customerID)
+	if err != nil {
+		return fmt.Errorf("clear customer node: %w", err)
+	}
+	return nil
+}
+
+func scanNode(row interface{ Scan(...interface{}) error }) (*Node, error) {
+	node := &Node{}
+	var labels string
+	err := row.Scan(&node.ID, &node.Name, &node.Address, &node.AgentURL, &node.MemoryMB, &node.CPUs,
+		&node.PortStart, &node.PortEnd, &labels, &node.Status, &node.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(labels), &node.Labels); err != nil {
+		return nil, fmt.Errorf("decode node labels: %w", err)
+	}
+	return node, nil
+}
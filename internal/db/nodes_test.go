@@ -0,0 +1,101 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestNode(t *testing.T, database *DB, name string) *Node {
+	t.Helper()
+	node := &Node{
+		Name:      name,
+		Address:   name + ".internal",
+		AgentURL:  "http://" + name + ".internal:9100",
+		MemoryMB:  8192,
+		CPUs:      4,
+		PortStart: 30000,
+		PortEnd:   30099,
+		Labels:    map[string]string{"region": "eu"},
+	}
+	require.NoError(t, database.CreateNode(t.Context(), node))
+	return node
+}
+
+func TestNodes(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	worker := createTestNode(t, database, "worker-b")
+	createTestNode(t, database, "worker-a")
+	assert.NotEmpty(t, worker.ID)
+	assert.Equal(t, NodeActive, worker.Status)
+
+	got, err := database.GetNode(ctx, worker.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "worker-b.internal", got.Address)
+	assert.Equal(t, 4.0, got.CPUs)
+	assert.Equal(t, map[string]string{"region": "eu"}, got.Labels)
+
+	nodes, err := database.ListNodes(ctx)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "worker-a", nodes[0].Name)
+
+	// Names are unique
+	assert.Error(t, database.CreateNode(ctx, &Node{Name: "worker-a", Address: "x", AgentURL: "http://x",
+		MemoryMB: 1, CPUs: 1, PortStart: 1, PortEnd: 2}))
+
+	require.NoError(t, database.SetNodeStatus(ctx, worker.ID, NodeCordoned))
+	got, err = database.GetNode(ctx, worker.ID)
+	require.NoError(t, err)
+	assert.Equal(t, NodeCordoned, got.Status)
+
+	assert.Error(t, database.SetNodeStatus(ctx, "missing", NodeActive))
+	_, err = database.GetNode(ctx, "missing")
+	assert.Error(t, err)
+}
+
+func TestNodeAssignment(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	first := createTestNode(t, database, "worker-1")
+	second := createTestNode(t, database, "worker-2")
+	alice := createTestCustomer(t, database, "alice@example.com")
+	bob := createTestCustomer(t, database, "bob@example.com")
+
+	require.NoError(t, database.AssignNode(ctx, alice.ID, first.ID, 30000))
+
+	// A port is held once per node
+	assert.Error(t, database.AssignNode(ctx, bob.ID, first.ID, 30000))
+	require.NoError(t, database.AssignNode(ctx, bob.ID, second.ID, 30000))
+
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	require.NotNil(t, customer.NodeID)
+	assert.Equal(t, first.ID, *customer.NodeID)
+	assert.Equal(t, 30000, *customer.ContainerPort)
+
+	tenants, err := database.ListNodeTenants(ctx)
+	require.NoError(t, err)
+	require.Len(t, tenants, 2)
+	assert.Contains(t, tenants, NodeTenant{NodeID: first.ID, CustomerID: alice.ID, Port: 30000, Memory: "512M", CPU: "0.25"})
+
+	// Nodes running tenants cannot be removed
+	assert.EqualError(t, database.DeleteNode(ctx, first.ID), "node has tenants")
+	assert.Error(t, database.DeleteNode(ctx, "missing"))
+
+	require.NoError(t, database.ClearCustomerNode(ctx, alice.ID))
+	customer, err = database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Nil(t, customer.NodeID)
+	assert.Nil(t, customer.ContainerPort)
+
+	require.NoError(t, database.DeleteNode(ctx, first.ID))
+	_, err = database.GetNode(ctx, first.ID)
+	assert.Error(t, err)
+
+	assert.Error(t, database.AssignNode(ctx, "missing", second.ID, 30001))
+}
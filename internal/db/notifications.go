@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Notification is a message for a customer shown on their dashboard
+type Notification struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Message   string    `json:"message"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateNotification records a message for the customer
+func (db *DB) CreateNotification(ctx context.Context, customerID, kind, message string) error {
+	query := `INSERT INTO notifications (customer_id, kind, message, created_at) VALUES (?, ?, ?, ?)`
+	if _, err := db.conn.ExecContext(ctx, query, customerID, kind, message, time.Now().UTC()); err != nil {
+		return fmt.Errorf("create notification: %w", err)
+	}
+	return nil
+}
+
+// ListNotifications returns the customer's notifications, newest first; limit 0 returns all
+func (db *DB) ListNotifications(ctx context.Context, customerID string, limit int) ([]Notification, error) {
+	query := `SELECT id, kind, message, created_at FROM notifications
+			  WHERE customer_id = ? ORDER BY created_at DESC, id DESC`
+	args := []interface{}{customerID}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		if err := rows.Scan(&n.ID, &n.Kind, &n.Message, &n.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
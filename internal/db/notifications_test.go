@@ -0,0 +1,33 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifications(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "notify@example.com")
+	other := createTestCustomer(t, database, "other@example.com")
+
+	require.NoError(t, database.CreateNotification(ctx, customer.ID, "trial_will_end", "Trial ends soon"))
+	require.NoError(t, database.CreateNotification(ctx, customer.ID, "trial_ended", "Trial ended"))
+	require.NoError(t, database.CreateNotification(ctx, other.ID, "trial_will_end", "Not yours"))
+
+	notifications, err := database.ListNotifications(ctx, customer.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "trial_ended", notifications[0].Kind, "newest first")
+	assert.Equal(t, "Trial ends soon", notifications[1].Message)
+
+	notifications, err = database.ListNotifications(ctx, customer.ID, 1)
+	require.NoError(t, err)
+	assert.Len(t, notifications, 1)
+
+	notifications, err = database.ListNotifications(ctx, "missing", 0)
+	require.NoError(t, err)
+	assert.Empty(t, notifications)
+}
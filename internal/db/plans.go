@@ -0,0 +1,257 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultPlanID is the plan customers get when signup names none
+const DefaultPlanID = "starter"
+
+// Plan is a subscription tier: its price and the resources tenants on it get
+type Plan struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	StripePriceID string `json:"-"`
+	PriceCents    int    `json:"price_cents"`
+	Memory        string `json:"memory"`
+	CPU           string `json:"cpu"`
+	// MonthlyMessages is the message quota per billing period; 0 means unlimited
+	MonthlyMessages int `json:"monthly_messages"`
+	// LLMSpendCapUSD overrides the platform's monthly LLM spend cap when set
+	LLMSpendCapUSD *int `json:"llm_spend_cap_usd,omitempty"`
+	// AllowedAgentTypes restricts the agent types on the plan; empty allows all
+	AllowedAgentTypes []string `json:"allowed_agent_types"`
+	// TrialDays is the free trial new subscriptions start with; 0 means none
+	TrialDays int `json:"trial_days"`
+	// Egress is the egress policy tenants on the plan get: open or restricted
+	Egress    string    `json:"egress"`
+	SortOrder int       `json:"-"`
+	IsActive  bool      `json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AllowsAgent reports whether tenants on the plan may run the given agent type
+func (p *Plan) AllowsAgent(agentTypeID string) bool {
+	if len(p.AllowedAgentTypes) == 0 {
+		return true
+	}
+	for _, id := range p.AllowedAgentTypes {
+		if id == agentTypeID {
+			return true
+		}
+	}
+	return false
+}
+
+const planColumns = `id, name, description, stripe_price_id, price_cents, memory, cpu,
+			monthly_messages, llm_spend_cap_usd, allowed_agent_types, trial_days, egress, sort_order, is_active, created_at`
+
+// GetPlans returns the plans open to new signups, cheapest first
+func (db *DB) GetPlans(ctx context.Context) ([]Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE is_active = true ORDER BY sort_order, price_cents`
+	rows, err := db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query plans: %w", err)
+	}
+	defer rows.Close()
+
+	plans := []Plan{}
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan plan: %w", err)
+		}
+		plans = append(plans, *plan)
+	}
+
+	return plans, rows.Err()
+}
+
+// GetPlan returns a plan by ID, including retired plans existing customers may still be on
+func (db *DB) GetPlan(ctx context.Context, id string) (*Plan, error) {
+	query := `SELECT ` + planColumns + ` FROM plans WHERE id = ?`
+	plan, err := scanPlan(db.conn.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("plan not found: %s", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get plan: %w", err)
+	}
+	return plan, nil
+}
+
+// SavePlan creates or replaces a plan
+func (db *DB) SavePlan(ctx context.Context, plan *Plan) error {
+	allowed := []byte("[]")
+	if len(plan.AllowedAgentTypes) > 0 {
+		var err error
+		if allowed, err = json.Marshal(plan.AllowedAgentTypes); err != nil {
+			return fmt.Errorf("encode allowed agent types: %w", err)
+		}
+	}
+
+	egress := plan.Egress
+	if egress == "" {
+		egress = "open"
+	}
+
+	query := `INSERT INTO plans (id, name, description, stripe_price_id, price_cents, memory, cpu,
+				monthly_messages, llm_spend_cap_usd, allowed_agent_types, trial_days, egress, sort_order, is_active)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(id) DO UPDATE SET
+				name = excluded.name,
+				description = excluded.description,
+				stripe_price_id = excluded.stripe_price_id,
+				price_cents = excluded.price_cents,
+				memory = excluded.memory,
+				cpu = excluded.cpu,
+				monthly_messages = excluded.monthly_messages,
+				llm_spend_cap_usd = excluded.llm_spend_cap_usd,
+				allowed_agent_types = excluded.allowed_agent_types,
+				trial_days = excluded.trial_days,
+				egress = excluded.egress,
+				sort_order = excluded.sort_order,
+				is_active = excluded.is_active`
+	_, err := db.conn.ExecContext(ctx, query, plan.ID, plan.Name, plan.Description, plan.StripePriceID,
+		plan.PriceCents, plan.Memory, plan.CPU, plan.MonthlyMessages, plan.LLMSpendCapUSD,
+		string(allowed), plan.TrialDays, egress, plan.SortOrder, plan.IsActive)
+	if err != nil {
+		return fmt.Errorf("save plan: %w", err)
+	}
+	return nil
+}
+
+// SetPlanStripePrice points a plan at the Stripe price subscriptions to it are billed with
+func (db *DB) SetPlanStripePrice(ctx context.Context, id, priceID string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE plans SET stripe_price_id = ? WHERE id = ?`, priceID, id)
+	if err != nil {
+		return fmt.Errorf("set plan price: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("plan not found: %s", id)
+	}
+	return nil
+}
+
+// SetPlanTrialDays sets the free trial new subscriptions to a plan start with
+func (db *DB) SetPlanTrialDays(ctx context.Context, id string, days int) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE plans SET trial_days = ? WHERE id = ?`, days, id)
+	if err != nil {
+		return fmt.Errorf("set plan trial: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("plan not found: %s", id)
+	}
+	return nil
+}
+
+// SetPlanEgress sets the egress policy tenants on a plan get when their
+// containers are next created or recreated
+func (db *DB) SetPlanEgress(ctx context.Context, id, policy string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE plans SET egress = ? WHERE id = ?`, policy, id)
+	if err != nil {
+		return fmt.Errorf("set plan egress: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("plan not found: %s", id)
+	}
+	return nil
+}
+
+// SetCustomerPlan moves a customer to another plan and records the change in the audit log
+func (db *DB) SetCustomerPlan(ctx context.Context, id, planID string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE customers SET plan_id = ?, updated_at = ? WHERE id = ?`,
+		planID, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set customer plan: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("customer not found: %s", id)
+	}
+
+	if err := db.logAudit(ctx, id, "plan_changed", planID); err != nil {
+		return fmt.Errorf("log plan change: %w", err)
+	}
+	return nil
+}
+
+func scanPlan(row interface{ Scan(...interface{}) error }) (*Plan, error) {
+	var plan Plan
+	var capUSD sql.NullInt64
+	var allowed string
+	err := row.Scan(&plan.ID, &plan.Name, &plan.Description, &plan.StripePriceID, &plan.PriceCents,
+		&plan.Memory, &plan.CPU, &plan.MonthlyMessages, &capUSD, &allowed, &plan.TrialDays, &plan.Egress, &plan.SortOrder,
+		&plan.IsActive, &plan.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if capUSD.Valid {
+		v := int(capUSD.Int64)
+		plan.LLMSpendCapUSD = &v
+	}
+	if err := json.Unmarshal([]byte(allowed), &plan.AllowedAgentTypes); err != nil {
+		return nil, fmt.Errorf("decode allowed agent types: %w", err)
+	}
+	return &plan, nil
+}
+
+// seedPlans creates the default plans without overwriting operator changes.
+// Starter matches the single tier the platform launched with.
+func (db *DB) seedPlans() error {
+	ctx := context.Background()
+
+	intPtr := func(v int) *int { return &v }
+	plans := []Plan{
+		{
+			ID:              "starter",
+			Name:            "Starter",
+			Description:     "One assistant for personal use",
+			PriceCents:      2900,
+			Memory:          "512M",
+			CPU:             "0.25",
+			MonthlyMessages: 2000,
+			SortOrder:       1,
+		},
+		{
+			ID:              "pro",
+			Name:            "Pro",
+			Description:     "More memory and CPU for busy assistants",
+			PriceCents:      7900,
+			Memory:          "1G",
+			CPU:             "0.5",
+			MonthlyMessages: 10000,
+			LLMSpendCapUSD:  intPtr(50),
+			SortOrder:       2,
+		},
+		{
+			ID:              "business",
+			Name:            "Business",
+			Description:     "Dedicated resources and unlimited messages",
+			PriceCents:      19900,
+			Memory:          "2G",
+			CPU:             "1.0",
+			MonthlyMessages: 0,
+			LLMSpendCapUSD:  intPtr(200),
+			SortOrder:       3,
+		},
+	}
+
+	for _, plan := range plans {
+		query := `INSERT OR IGNORE INTO plans
+			(id, name, description, price_cents, memory, cpu, monthly_messages, llm_spend_cap_usd, sort_order)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		_, err := db.conn.ExecContext(ctx, query, plan.ID, plan.Name, plan.Description, plan.PriceCents,
+			plan.Memory, plan.CPU, plan.MonthlyMessages, plan.LLMSpendCapUSD, plan.SortOrder)
+		if err != nil {
+			return fmt.Errorf("seed plan %s: %w", plan.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeededPlans(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	plans, err := database.GetPlans(ctx)
+	require.NoError(t, err)
+	require.Len(t, plans, 3)
+	assert.Equal(t, []string{"starter", "pro", "business"}, []string{plans[0].ID, plans[1].ID, plans[2].ID})
+
+	starter := plans[0]
+	assert.Equal(t, "512M", starter.Memory)
+	assert.Equal(t, "0.25", starter.CPU)
+	assert.Nil(t, starter.LLMSpendCapUSD, "starter uses the platform spend cap")
+	assert.Empty(t, starter.AllowedAgentTypes)
+	assert.True(t, starter.AllowsAgent("myrai"))
+
+	require.NotNil(t, plans[2].LLMSpendCapUSD)
+	assert.Equal(t, 200, *plans[2].LLMSpendCapUSD)
+	assert.Zero(t, plans[2].MonthlyMessages)
+
+	// Reseeding keeps operator changes
+	require.NoError(t, database.SetPlanStripePrice(ctx, "pro", "price_pro"))
+	require.NoError(t, database.Migrate())
+	pro, err := database.GetPlan(ctx, "pro")
+	require.NoError(t, err)
+	assert.Equal(t, "price_pro", pro.StripePriceID)
+
+	require.NoError(t, database.SetPlanTrialDays(ctx, "pro", 14))
+	pro, err = database.GetPlan(ctx, "pro")
+	require.NoError(t, err)
+	assert.Equal(t, 14, pro.TrialDays)
+	assert.Error(t, database.SetPlanTrialDays(ctx, "missing", 7))
+
+	assert.Equal(t, "open", pro.Egress)
+	require.NoError(t, database.SetPlanEgress(ctx, "pro", "restricted"))
+	pro, err = database.GetPlan(ctx, "pro")
+	require.NoError(t, err)
+	assert.Equal(t, "restricted", pro.Egress)
+	assert.Error(t, database.SetPlanEgress(ctx, "missing", "open"))
+
+	assert.Error(t, database.SetPlanStripePrice(ctx, "missing", "price_x"))
+	_, err = database.GetPlan(ctx, "missing")
+	assert.Error(t, err)
+}
+
+func TestSavePlan(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	plan := &Plan{
+		ID:                "legacy",
+		Name:              "Legacy",
+		Memory:            "256M",
+		CPU:               "0.1",
+		AllowedAgentTypes: []string{"myrai"},
+		TrialDays:         3,
+		IsActive:          false,
+	}
+	require.NoError(t, database.SavePlan(ctx, plan))
+
+	got, err := database.GetPlan(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"myrai"}, got.AllowedAgentTypes)
+	assert.True(t, got.AllowsAgent("myrai"))
+	assert.False(t, got.AllowsAgent("openclaw"))
+	assert.False(t, got.IsActive)
+	assert.Equal(t, 3, got.TrialDays)
+	assert.Equal(t, "open", got.Egress, "egress defaults to open")
+
+	// Retired plans stay readable but are not offered
+	plans, err := database.GetPlans(ctx)
+	require.NoError(t, err)
+	for _, p := range plans {
+		assert.NotEqual(t, "legacy", p.ID)
+	}
+
+	plan.Memory = "384M"
+	plan.AllowedAgentTypes = nil
+	require.NoError(t, database.SavePlan(ctx, plan))
+	got, err = database.GetPlan(ctx, "legacy")
+	require.NoError(t, err)
+	assert.Equal(t, "384M", got.Memory)
+	assert.Empty(t, got.AllowedAgentTypes)
+}
+
+func TestCustomerPlan(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	customer := createTestCustomer(t, database, "default@example.com")
+	got, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPlanID, got.PlanID)
+
+	pro, err := database.CreateCustomer(ctx, &CreateCustomerRequest{
+		Email:              "pro@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help",
+		TelegramBotToken:   "123:abc",
+		PlanID:             "pro",
+	})
+	require.NoError(t, err)
+	got, err = database.GetCustomerByID(ctx, pro.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pro", got.PlanID)
+
+	require.NoError(t, database.SetCustomerPlan(ctx, pro.ID, "business"))
+	got, err = database.GetCustomerByID(ctx, pro.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "business", got.PlanID)
+
+	assert.Error(t, database.SetCustomerPlan(ctx, "missing", "pro"))
+}
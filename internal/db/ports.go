@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TenantPorts is how many consecutive host ports a tenant publishes: its
+// gateway port and the bridge port after it
+const TenantPorts = 2
+
+// ReservePortBlock reserves the lowest block of TenantPorts consecutive ports
+// in [start, end] that no tenant holds and free reports as unused, records it
+// against the customer and sets the customer's container port to its first
+// port, all in one transaction. It returns the first port.
+func (db *DB) ReservePortBlock(ctx context.Context, customerID string, start, end int, free func(port int) bool) (int, error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin port reservation: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT port FROM port_allocations WHERE port BETWEEN ? AND ?`, start, end)
+	if err != nil {
+		return 0, fmt.Errorf("query allocated ports: %w", err)
+	}
+	taken := map[int]bool{}
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan port: %w", err)
+		}
+		taken[port] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("query allocated ports: %w", err)
+	}
+
+	base := 0
+	for port := start; port+TenantPorts-1 <= end && base == 0; port++ {
+		base = port
+		for p := port; p < port+TenantPorts; p++ {
+			if taken[p] || !free(p) {
+				base = 0
+				break
+			}
+		}
+	}
+	if base == 0 {
+		return 0, fmt.Errorf("no free block of %d ports in range %d-%d", TenantPorts, start, end)
+	}
+
+	for port := base; port < base+TenantPorts; port++ {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO port_allocations (port, customer_id) VALUES (?, ?)`, port, customerID); err != nil {
+			return 0, fmt.Errorf("allocate port: %w", err)
+		}
+	}
+	result, err := tx.ExecContext(ctx, `UPDATE customers SET container_port = ?, updated_at = ? WHERE id = ?`, base, time.Now(), customerID)
+	if err != nil {
+		return 0, fmt.Errorf("update customer port: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, fmt.Errorf("customer not found")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit port reservation: %w", err)
+	}
+	return base, nil
+}
+
+// ReleaseCustomerPorts frees every host port recorded against a customer and
+// returns them
+func (db *DB) ReleaseCustomerPorts(ctx context.Context, customerID string) ([]int, error) {
+	rows, err := db.conn.QueryContext(ctx, `DELETE FROM port_allocations WHERE customer_id = ? RETURNING port`, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("release customer ports: %w", err)
+	}
+	defer rows.Close()
+
+	var ports []int
+	for rows.Next() {
+		var port int
+		if err := rows.Scan(&port); err != nil {
+			return nil, fmt.Errorf("scan port: %w", err)
+		}
+		ports = append(ports, port)
+	}
+
+	return ports, rows.Err()
+}
+
+// RecoverPortAllocations makes port_allocations match the tenants publishing
+// ports on the control-plane host. Rows no such tenant's port block covers,
+// left behind by a crash mid-provisioning, are dropped; blocks of tenants
+// missing rows, such as bridge ports from before they were recorded, are
+// filled in. A port another tenant already holds is left with that tenant,
+// gateway ports taking precedence over bridges. It returns how many rows were
+// added and removed.
+func (db *DB) RecoverPortAllocations(ctx context.Context) (restored, released int, err error) {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("begin port recovery: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM port_allocations WHERE NOT EXISTS (
+			SELECT 1 FROM customers c
+			WHERE c.id = port_allocations.customer_id AND c.node_id IS NULL AND c.container_network IS NULL
+			  AND c.container_port IS NOT NULL
+			  AND port_allocations.port >= c.container_port AND port_allocations.port < c.container_port + ?)`, TenantPorts)
+	if err != nil {
+		return 0, 0, fmt.Errorf("release stale ports: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	released = int(n)
+
+	for offset := 0; offset < TenantPorts; offset++ {
+		result, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO port_allocations (port, customer_id)
+			SELECT container_port + ?, id FROM customers
+			WHERE node_id IS NULL AND container_network IS NULL AND container_port IS NOT NULL
+			ORDER BY container_port`, offset)
+		if err != nil {
+			return 0, 0, fmt.Errorf("restore port allocations: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		restored += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("commit port recovery: %w", err)
+	}
+	return restored, released, nil
+}
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func allFree(int) bool { return true }
+
+func TestReservePortBlock(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	alice := createTestCustomer(t, database, "alice@example.com")
+	bob := createTestCustomer(t, database, "bob@example.com")
+	carol := createTestCustomer(t, database, "carol@example.com")
+
+	port, err := database.ReservePortBlock(ctx, alice.ID, 30000, 30005, allFree)
+	require.NoError(t, err)
+	assert.Equal(t, 30000, port)
+
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 30000, *customer.ContainerPort)
+
+	// Ports busy on the host are skipped along with their block
+	busy := func(port int) bool { return port != 30003 }
+	port, err = database.ReservePortBlock(ctx, bob.ID, 30000, 30005, busy)
+	require.NoError(t, err)
+	assert.Equal(t, 30004, port)
+
+	ports, err := database.GetAllocatedPorts(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30000, 30001, 30004, 30005}, ports)
+
+	// A block may not run past the end of the range
+	_, err = database.ReservePortBlock(ctx, carol.ID, 30000, 30002, allFree)
+	require.Error(t, err)
+	customer, err = database.GetCustomerByID(ctx, carol.ID)
+	require.NoError(t, err)
+	assert.Nil(t, customer.ContainerPort)
+
+	// Nothing is recorded for an unknown customer
+	_, err = database.ReservePortBlock(ctx, "missing", 30000, 30005, allFree)
+	require.Error(t, err)
+	ports, err = database.GetAllocatedPorts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, ports, 4)
+
+	released, err := database.ReleaseCustomerPorts(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30000, 30001}, released)
+	port, err = database.ReservePortBlock(ctx, carol.ID, 30000, 30005, allFree)
+	require.NoError(t, err)
+	assert.Equal(t, 30000, port)
+}
+
+func TestRecoverPortAllocations(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	// Tenants provisioned before bridge ports were recorded, one a port apart
+	alice := createTestCustomer(t, database, "alice@example.com")
+	bob := createTestCustomer(t, database, "bob@example.com")
+	for _, c := range []struct {
+		id   string
+		port int
+	}{{alice.ID, 30000}, {bob.ID, 30001}} {
+		require.NoError(t, database.AllocatePort(ctx, c.id, c.port))
+		require.NoError(t, database.UpdateCustomerPort(ctx, c.id, c.port))
+	}
+
+	// Left behind by a provisioning crash and by a tenant now on a node
+	carol := createTestCustomer(t, database, "carol@example.com")
+	require.NoError(t, database.AllocatePort(ctx, carol.ID, 30010))
+	node := createTestNode(t, database, "worker-1")
+	dave := createTestCustomer(t, database, "dave@example.com")
+	require.NoError(t, database.AllocatePort(ctx, dave.ID, 30020))
+	require.NoError(t, database.AssignNode(ctx, dave.ID, node.ID, 30020))
+	// Tenants on an internal network publish nothing
+	erin := createTestCustomer(t, database, "erin@example.com")
+	require.NoError(t, database.AllocatePort(ctx, erin.ID, 30030))
+	require.NoError(t, database.SetCustomerNetwork(ctx, erin.ID, "blytz-tenants", 18789))
+
+	restored, released, err := database.RecoverPortAllocations(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, restored)
+	assert.Equal(t, 3, released)
+
+	export, err := database.ExportCustomerData(ctx, bob.ID)
+	require.NoError(t, err)
+	var bobPorts []int
+	for _, allocation := range export.PortAllocations {
+		bobPorts = append(bobPorts, allocation.Port)
+	}
+	assert.Equal(t, []int{30001, 30002}, bobPorts, "gateway ports win over bridges")
+
+	ports, err := database.GetAllocatedPorts(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{30000, 30001, 30002}, ports)
+
+	// Recovery is idempotent
+	restored, released, err = database.RecoverPortAllocations(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, restored)
+	assert.Zero(t, released)
+}
+
+func TestCustomerNetwork(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	customer := createTestCustomer(t, database, "alice@example.com")
+	assert.Empty(t, customer.InternalHost())
+
+	require.NoError(t, database.SetCustomerNetwork(ctx, customer.ID, "blytz-tenants", 18789))
+	customer, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "blytz-tenants", *customer.ContainerNetwork)
+	assert.Equal(t, 18789, *customer.ContainerPort)
+	assert.Equal(t, "blytz-"+customer.ID, customer.InternalHost())
+
+	// Moving to a node publishes the port there
+	node := createTestNode(t, database, "worker-1")
+	require.NoError(t, database.AssignNode(ctx, customer.ID, node.ID, 30000))
+	customer, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Nil(t, customer.ContainerNetwork)
+	assert.Empty(t, customer.InternalHost())
+
+	require.NoError(t, database.SetCustomerNetwork(ctx, customer.ID, "blytz-tenants", 18789))
+	require.NoError(t, database.ClearCustomerPort(ctx, customer.ID))
+	customer, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Nil(t, customer.ContainerNetwork)
+
+	assert.Error(t, database.SetCustomerNetwork(ctx, "missing", "blytz-tenants", 18789))
+}
@@ -0,0 +1,341 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// ErasureRequest tracks a customer's right-to-erasure request through its grace period
+type ErasureRequest struct {
+	CustomerID   string     `json:"customer_id"`
+	Status       string     `json:"status"`
+	RequestedAt  time.Time  `json:"requested_at"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+}
+
+// AuditEntry is a single row of the audit log
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	CustomerID string    `json:"customer_id"`
+	Action     string    `json:"action"`
+	Details    *string   `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PortAllocation is a host port reserved for a customer
+type PortAllocation struct {
+	Port        int       `json:"port"`
+	AllocatedAt time.Time `json:"allocated_at"`
+}
+
+// CustomerExport holds every database record kept about a customer
+type CustomerExport struct {
+	Customer        *Customer        `json:"customer"`
+	AuditLog        []AuditEntry     `json:"audit_log"`
+	PortAllocations []PortAllocation `json:"port_allocations"`
+	HealthChecks    []HealthCheck    `json:"health_checks"`
+	Usage           []UsageBucket    `json:"usage"`
+	LLMUsage        []LLMUsageBucket `json:"llm_usage"`
+	UsageReports    []UsageReport    `json:"usage_reports"`
+	Notifications   []Notification   `json:"notifications"`
+	CustomDomain    *CustomDomain    `json:"custom_domain,omitempty"`
+	WaitlistEntry   *WaitlistExport  `json:"waitlist_entry,omitempty"`
+	ErasureRequest  *ErasureRequest  `json:"erasure_request,omitempty"`
+}
+
+// WaitlistExport is a waitlist entry queued under the customer's email,
+// including the bot token the waitlist API never returns
+type WaitlistExport struct {
+	*WaitlistEntry
+	TelegramBotToken string `json:"telegram_bot_token"`
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetCustomerAccessToken stores the hash of the token a customer uses to call the API
+func (db *DB) SetCustomerAccessToken(ctx context.Context, id, token string) error {
+	query := `UPDATE customers SET access_token_hash = ?, updated_at = ? WHERE id = ?`
+	_, err := db.conn.ExecContext(ctx, query, hashToken(token), time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set access token: %w", err)
+	}
+	return nil
+}
+
+// GetCustomerByAccessToken looks up the customer owning an API access token
+func (db *DB) GetCustomerByAccessToken(ctx context.Context, token string) (*Customer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("customer not found")
+	}
+
+	query := `SELECT id FROM customers WHERE access_token_hash = ?`
+	row := db.conn.QueryRowContext(ctx, query, hashToken(token))
+
+	var id string
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("customer not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query customer by access token: %w", err)
+	}
+
+	return db.GetCustomerByID(ctx, id)
+}
+
+// ScheduleErasure records an erasure request that becomes due at scheduledFor.
+// Re-requesting after a cancellation reschedules the existing request.
+func (db *DB) ScheduleErasure(ctx context.Context, id string, scheduledFor time.Time) (*ErasureRequest, error) {
+	req := &ErasureRequest{
+		CustomerID:   id,
+		Status:       "scheduled",
+		RequestedAt:  time.Now(),
+		ScheduledFor: scheduledFor,
+	}
+
+	query := `INSERT INTO erasure_requests (customer_id, status, requested_at, scheduled_for)
+			  VALUES (?, ?, ?, ?)
+			  ON CONFLICT(customer_id) DO UPDATE SET
+			  status = excluded.status,
+			  requested_at = excluded.requested_at,
+			  scheduled_for = excluded.scheduled_for,
+			  completed_at = NULL`
+	_, err := db.conn.ExecContext(ctx, query, req.CustomerID, req.Status, req.RequestedAt, req.ScheduledFor)
+	if err != nil {
+		return nil, fmt.Errorf("schedule erasure: %w", err)
+	}
+
+	if err := db.logAudit(ctx, id, "erasure_requested", nil); err != nil {
+		return nil, fmt.Errorf("log audit: %w", err)
+	}
+
+	return req, nil
+}
+
+// GetErasureRequest returns the customer's erasure request, or nil if none exists
+func (db *DB) GetErasureRequest(ctx context.Context, id string) (*ErasureRequest, error) {
+	query := `SELECT customer_id, status, requested_at, scheduled_for, completed_at
+			  FROM erasure_requests WHERE customer_id = ?`
+	row := db.conn.QueryRowContext(ctx, query, id)
+
+	req := &ErasureRequest{}
+	err := row.Scan(&req.CustomerID, &req.Status, &req.RequestedAt, &req.ScheduledFor, &req.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query erasure request: %w", err)
+	}
+
+	return req, nil
+}
+
+// CancelErasure withdraws a scheduled erasure request
+func (db *DB) CancelErasure(ctx context.Context, id string) error {
+	query := `UPDATE erasure_requests SET status = 'cancelled' WHERE customer_id = ? AND status = 'scheduled'`
+	result, err := db.conn.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("cancel erasure: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("cancel erasure: %w", err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("no scheduled erasure request")
+	}
+
+	if err := db.logAudit(ctx, id, "erasure_cancelled", nil); err != nil {
+		return fmt.Errorf("log audit: %w", err)
+	}
+
+	return nil
+}
+
+// ListDueErasures returns scheduled erasure requests whose grace period ended before now
+func (db *DB) ListDueErasures(ctx context.Context, now time.Time) ([]ErasureRequest, error) {
+	query := `SELECT customer_id, status, requested_at, scheduled_for, completed_at
+			  FROM erasure_requests WHERE status = 'scheduled' AND scheduled_for <= ?
+			  ORDER BY scheduled_for`
+	rows, err := db.conn.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, fmt.Errorf("query due erasures: %w", err)
+	}
+	defer rows.Close()
+
+	var requests []ErasureRequest
+	for rows.Next() {
+		var req ErasureRequest
+		if err := rows.Scan(&req.CustomerID, &req.Status, &req.RequestedAt, &req.ScheduledFor, &req.CompletedAt); err != nil {
+			return nil, fmt.Errorf("scan erasure request: %w", err)
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, rows.Err()
+}
+
+// EraseCustomer deletes the customer row, port allocations, health and usage history, custom
+// domain and any waitlist entry under their email, and rewrites the audit trail, rollout history
+// and erasure request under pseudonym so history survives without identifying the customer. Runs
+// in a single transaction.
+func (db *DB) EraseCustomer(ctx context.Context, id, pseudonym string) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin erase: %w", err)
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM port_allocations WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM health_checks WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM usage_buckets WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM llm_usage WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM usage_reports WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM notifications WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM custom_domains WHERE customer_id = ?`, []interface{}{id}},
+		{`DELETE FROM waitlist WHERE email = (SELECT email FROM customers WHERE id = ?)`, []interface{}{id}},
+		{`UPDATE audit_log SET customer_id = ?, details = NULL WHERE customer_id = ?`, []interface{}{pseudonym, id}},
+		{`UPDATE rollout_tenants SET customer_id = ?, error = '' WHERE customer_id = ?`, []interface{}{pseudonym, id}},
+		{`UPDATE erasure_requests SET customer_id = ?, status = 'completed', completed_at = ? WHERE customer_id = ?`,
+			[]interface{}{pseudonym, time.Now(), id}},
+		{`DELETE FROM customers WHERE id = ?`, []interface{}{id}},
+		{`INSERT INTO audit_log (customer_id, action) VALUES (?, 'erased')`, []interface{}{pseudonym}},
+	}
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("erase customer: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit erase: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditLog returns the audit trail for a customer, oldest first
+func (db *DB) GetAuditLog(ctx context.Context, customerID string) ([]AuditEntry, error) {
+	query := `SELECT id, customer_id, action, details, created_at FROM audit_log
+			  WHERE customer_id = ? ORDER BY id`
+	rows, err := db.conn.QueryContext(ctx, query, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.ID, &entry.CustomerID, &entry.Action, &entry.Details, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// ExportCustomerData gathers every database record kept about a customer
+func (db *DB) ExportCustomerData(ctx context.Context, id string) (*CustomerExport, error) {
+	customer, err := db.GetCustomerByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog, err := db.GetAuditLog(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.conn.QueryContext(ctx, `SELECT port, allocated_at FROM port_allocations WHERE customer_id = ? ORDER BY port`, id)
+	if err != nil {
+		return nil, fmt.Errorf("query port allocations: %w", err)
+	}
+	defer rows.Close()
+
+	ports := []PortAllocation{}
+	for rows.Next() {
+		var pa PortAllocation
+		if err := rows.Scan(&pa.Port, &pa.AllocatedAt); err != nil {
+			return nil, fmt.Errorf("scan port allocation: %w", err)
+		}
+		ports = append(ports, pa)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query port allocations: %w", err)
+	}
+
+	// A negative limit means no limit in SQLite
+	healthChecks, err := db.ListHealthChecks(ctx, id, time.Time{}, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := db.ListUsage(ctx, id, time.Time{}, UsageBucketSize)
+	if err != nil {
+		return nil, err
+	}
+
+	llmUsage, err := db.ListLLMUsage(ctx, id, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	usageReports, err := db.ListUsageReports(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	notifications, err := db.ListNotifications(ctx, id, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	customDomain, err := db.findCustomDomain(ctx, `customer_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var waitlist *WaitlistExport
+	entry, err := db.findWaitlistEntryByEmail(ctx, customer.Email)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		waitlist = &WaitlistExport{WaitlistEntry: entry, TelegramBotToken: entry.TelegramBotToken}
+	}
+
+	erasure, err := db.GetErasureRequest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CustomerExport{
+		Customer:        customer,
+		AuditLog:        auditLog,
+		PortAllocations: ports,
+		HealthChecks:    healthChecks,
+		Usage:           usage,
+		LLMUsage:        llmUsage,
+		UsageReports:    usageReports,
+		Notifications:   notifications,
+		CustomDomain:    customDomain,
+		WaitlistEntry:   waitlist,
+		ErasureRequest:  erasure,
+	}, nil
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	database, err := New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	return database
+}
+
+func createTestCustomer(t *testing.T, database *DB, email string) *Customer {
+	t.Helper()
+	customer, err := database.CreateCustomer(context.Background(), &CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "TestBot",
+		CustomInstructions: "Test instructions",
+		TelegramBotToken:   "123456:ABCdef",
+	})
+	require.NoError(t, err)
+	return customer
+}
+
+func TestCustomerAccessToken(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "token@example.com")
+
+	require.NoError(t, database.SetCustomerAccessToken(ctx, customer.ID, "secret-token"))
+
+	found, err := database.GetCustomerByAccessToken(ctx, "secret-token")
+	require.NoError(t, err)
+	assert.Equal(t, customer.ID, found.ID)
+
+	_, err = database.GetCustomerByAccessToken(ctx, "wrong-token")
+	assert.Error(t, err)
+
+	_, err = database.GetCustomerByAccessToken(ctx, "")
+	assert.Error(t, err)
+}
+
+func TestErasureScheduleAndCancel(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "erase@example.com")
+
+	req, err := database.GetErasureRequest(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Nil(t, req)
+
+	_, err = database.ScheduleErasure(ctx, customer.ID, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	due, err := database.ListDueErasures(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, due, "request should not be due during the grace period")
+
+	due, err = database.ListDueErasures(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, customer.ID, due[0].CustomerID)
+
+	require.NoError(t, database.CancelErasure(ctx, customer.ID))
+	assert.Error(t, database.CancelErasure(ctx, customer.ID), "cancelling twice should fail")
+
+	req, err = database.GetErasureRequest(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", req.Status)
+
+	// Re-requesting reschedules
+	_, err = database.ScheduleErasure(ctx, customer.ID, time.Now())
+	require.NoError(t, err)
+	req, err = database.GetErasureRequest(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "scheduled", req.Status)
+}
+
+func TestEraseCustomer(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "gone@example.com")
+	other := createTestCustomer(t, database, "stays@example.com")
+
+	require.NoError(t, database.AllocatePort(ctx, customer.ID, 30000))
+	require.NoError(t, database.AllocatePort(ctx, other.ID, 30001))
+	require.NoError(t, database.RecordHealthCheck(ctx, customer.ID, HealthCheck{CheckedAt: time.Now(), Healthy: true}))
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []UsageEvent{{OccurredAt: time.Now(), Messages: 1}}))
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, LLMUsage{OccurredAt: time.Now(), Provider: "openai", Model: "gpt-4o"}))
+	require.NoError(t, database.SetPendingUsageReport(ctx, customer.ID, "messages", time.Now(), time.Now().Add(time.Hour), 1))
+	require.NoError(t, database.CreateNotification(ctx, customer.ID, "trial_will_end", "Trial ends soon"))
+	_, err := database.SetCustomDomain(ctx, customer.ID, "assistant.gone.example", "token")
+	require.NoError(t, err)
+	require.NoError(t, database.VerifyCustomDomain(ctx, customer.ID, "assistant.gone.example"))
+	require.NoError(t, database.AddToWaitlist(ctx, &WaitlistEntry{Email: customer.Email, AssistantName: "Test",
+		CustomInstructions: "Help me", TelegramBotToken: "123:abc"}))
+	require.NoError(t, database.AddToWaitlist(ctx, &WaitlistEntry{Email: "queued@example.com", AssistantName: "Test",
+		CustomInstructions: "Help me", TelegramBotToken: "456:def"}))
+	_, err = database.ScheduleErasure(ctx, customer.ID, time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, database.EraseCustomer(ctx, customer.ID, "erased-abc"))
+
+	_, err = database.GetCustomerByID(ctx, customer.ID)
+	assert.Error(t, err)
+
+	ports, err := database.GetAllocatedPorts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []int{30001}, ports)
+
+	total, _, err := database.CountHealthChecks(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	assert.Zero(t, total)
+
+	usage, err := database.ListUsage(ctx, customer.ID, time.Time{}, UsageBucketSize)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	llmUsage, err := database.ListLLMUsage(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, llmUsage)
+
+	reports, err := database.ListUsageReports(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+
+	notifications, err := database.ListNotifications(ctx, customer.ID, 0)
+	require.NoError(t, err)
+	assert.Empty(t, notifications)
+
+	_, err = database.GetVerifiedCustomDomain(ctx, "assistant.gone.example")
+	assert.Error(t, err, "the domain is free to claim again")
+
+	waitlisted, err := database.IsWaitlisted(ctx, customer.Email)
+	require.NoError(t, err)
+	assert.False(t, waitlisted, "the waitlist entry under the customer's email is deleted")
+	waitlisted, err = database.IsWaitlisted(ctx, "queued@example.com")
+	require.NoError(t, err)
+	assert.True(t, waitlisted)
+
+	entries, err := database.GetAuditLog(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "no audit rows should reference the erased ID")
+
+	entries, err = database.GetAuditLog(ctx, "erased-abc")
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "erased", entries[len(entries)-1].Action)
+	for _, entry := range entries {
+		assert.Nil(t, entry.Details)
+	}
+
+	req, err := database.GetErasureRequest(ctx, "erased-abc")
+	require.NoError(t, err)
+	require.NotNil(t, req)
+	assert.Equal(t, "completed", req.Status)
+	assert.NotNil(t, req.CompletedAt)
+
+	// Other customers are untouched
+	_, err = database.GetCustomerByID(ctx, other.ID)
+	assert.NoError(t, err)
+}
+
+func TestExportCustomerData(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "export@example.com")
+	require.NoError(t, database.AllocatePort(ctx, customer.ID, 30005))
+	require.NoError(t, database.RecordHealthCheck(ctx, customer.ID, HealthCheck{CheckedAt: time.Now(), Error: "timeout"}))
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []UsageEvent{{OccurredAt: time.Now(), Messages: 3}}))
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, LLMUsage{OccurredAt: time.Now(), Provider: "openai", Model: "gpt-4o", InputTokens: 10}))
+	require.NoError(t, database.SetPendingUsageReport(ctx, customer.ID, "messages", time.Now(), time.Now().Add(time.Hour), 3))
+	require.NoError(t, database.CreateNotification(ctx, customer.ID, "trial_will_end", "Trial ends soon"))
+	_, err := database.SetCustomDomain(ctx, customer.ID, "assistant.export.example", "token")
+	require.NoError(t, err)
+	require.NoError(t, database.AddToWaitlist(ctx, &WaitlistEntry{Email: customer.Email, AssistantName: "Queued",
+		CustomInstructions: "Help me", TelegramBotToken: "789:ghi"}))
+
+	export, err := database.ExportCustomerData(ctx, customer.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, customer.Email, export.Customer.Email)
+	require.Len(t, export.PortAllocations, 1)
+	assert.Equal(t, 30005, export.PortAllocations[0].Port)
+	require.Len(t, export.HealthChecks, 1)
+	assert.Equal(t, "timeout", export.HealthChecks[0].Error)
+	require.Len(t, export.Usage, 1)
+	assert.Equal(t, 3, export.Usage[0].Messages)
+	require.Len(t, export.LLMUsage, 1)
+	assert.Equal(t, 10, export.LLMUsage[0].InputTokens)
+	require.Len(t, export.UsageReports, 1)
+	assert.Equal(t, "messages", export.UsageReports[0].Metric)
+	require.Len(t, export.Notifications, 1)
+	assert.Equal(t, "trial_will_end", export.Notifications[0].Kind)
+	require.NotNil(t, export.CustomDomain)
+	assert.Equal(t, "assistant.export.example", export.CustomDomain.Domain)
+	require.NotNil(t, export.WaitlistEntry)
+	assert.Equal(t, "Queued", export.WaitlistEntry.AssistantName)
+	assert.Equal(t, "789:ghi", export.WaitlistEntry.TelegramBotToken)
+	require.NotEmpty(t, export.AuditLog)
+	assert.Equal(t, "created", export.AuditLog[0].Action)
+	assert.Nil(t, export.ErasureRequest)
+
+	_, err = database.ExportCustomerData(ctx, "missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,362 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rollout statuses
+const (
+	// RolloutPending rollouts wait for the orchestrator to pick them up
+	RolloutPending = "pending"
+	// RolloutCanary rollouts are upgrading or baking the canary cohort
+	RolloutCanary = "canary"
+	// RolloutRolling rollouts are upgrading or baking a later batch
+	RolloutRolling = "rolling"
+	// RolloutHalting rollouts were halted by an operator and are about to roll back
+	RolloutHalting = "halting"
+	// RolloutRollingBack rollouts are returning tenants to their previous image
+	RolloutRollingBack = "rolling_back"
+	// RolloutCompleted rollouts upgraded every tenant
+	RolloutCompleted = "completed"
+	// RolloutRolledBack rollouts failed or were halted and returned their tenants
+	RolloutRolledBack = "rolled_back"
+)
+
+// Rollout tenant statuses
+const (
+	RolloutTenantPending    = "pending"
+	RolloutTenantUpgraded   = "upgraded"
+	RolloutTenantFailed     = "failed"
+	RolloutTenantRolledBack = "rolled_back"
+)
+
+// activeRolloutStatuses are the statuses of rollouts the orchestrator still has work for
+var activeRolloutStatuses = []string{RolloutPending, RolloutCanary, RolloutRolling, RolloutHalting, RolloutRollingBack}
+
+// Rollout moves the tenants of an agent type to a new image in batches
+type Rollout struct {
+	ID          string `json:"id"`
+	AgentTypeID string `json:"agent_type_id"`
+	// Image is the tag of the image rolled out
+	Image       string `json:"image"`
+	ImageDigest string `json:"image_digest"`
+	// PreviousImage and PreviousDigest are the image most tenants ran
+	// before, which new tenants of the agent type are pinned to again if the
+	// rollout is rolled back
+	PreviousImage    string  `json:"previous_image"`
+	PreviousDigest   string  `json:"previous_digest"`
+	Status           string  `json:"status"`
+	CanarySize       int     `json:"canary_size"`
+	Concurrency      int     `json:"concurrency"`
+	BakeSeconds      int     `json:"bake_seconds"`
+	BatchBakeSeconds int     `json:"batch_bake_seconds"`
+	MaxErrorRate     float64 `json:"max_error_rate"`
+	// Batch is the batch being upgraded or baked; 0 is the canary cohort
+	Batch      int             `json:"batch"`
+	Batches    int             `json:"batches"`
+	Error      string          `json:"error,omitempty"`
+	Progress   RolloutProgress `json:"progress"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Active reports whether the orchestrator still has work for the rollout
+func (r *Rollout) Active() bool {
+	for _, status := range activeRolloutStatuses {
+		if r.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// RolloutProgress counts a rollout's tenants by status
+type RolloutProgress struct {
+	Total      int `json:"total"`
+	Pending    int `json:"pending"`
+	Upgraded   int `json:"upgraded"`
+	Failed     int `json:"failed"`
+	RolledBack int `json:"rolled_back"`
+}
+
+// RolloutTenant is one tenant's place in a rollout
+type RolloutTenant struct {
+	CustomerID     string    `json:"customer_id"`
+	Batch          int       `json:"batch"`
+	PreviousDigest string    `json:"previous_digest"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// RolloutCandidate is a provisioned tenant that is not on a rollout's image
+type RolloutCandidate struct {
+	CustomerID  string
+	ImageDigest string
+}
+
+const rolloutColumns = `id, agent_type_id, image, image_digest, previous_image, previous_digest, status, canary_size, concurrency,
+	bake_seconds, batch_bake_seconds, max_error_rate, batch, batches, error, created_at, updated_at, finished_at`
+
+// CreateRollout records a pending rollout and the tenants it upgrades, filling
+// in its ID, status and timestamps
+func (db *DB) CreateRollout(ctx context.Context, rollout *Rollout, tenants []RolloutTenant) error {
+	now := time.Now().UTC()
+	rollout.ID = uuid.New().String()
+	rollout.Status = RolloutPending
+	rollout.CreatedAt = now
+	rollout.UpdatedAt = now
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin create rollout: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO rollouts (` + rolloutColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, query, rollout.ID, rollout.AgentTypeID, rollout.Image, rollout.ImageDigest,
+		rollout.PreviousImage, rollout.PreviousDigest,
+		rollout.Status, rollout.CanarySize, rollout.Concurrency, rollout.BakeSeconds, rollout.BatchBakeSeconds,
+		rollout.MaxErrorRate, rollout.Batch, rollout.Batches, rollout.Error, now, now, nil)
+	if err != nil {
+		return fmt.Errorf("insert rollout: %w", err)
+	}
+
+	for _, tenant := range tenants {
+		_, err := tx.ExecContext(ctx, `INSERT INTO rollout_tenants (rollout_id, customer_id, batch, previous_digest, status, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?)`, rollout.ID, tenant.CustomerID, tenant.Batch, tenant.PreviousDigest, RolloutTenantPending, now)
+		if err != nil {
+			return fmt.Errorf("insert rollout tenant: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit rollout: %w", err)
+	}
+	rollout.Progress = RolloutProgress{Total: len(tenants), Pending: len(tenants)}
+	return nil
+}
+
+// GetRollout returns a rollout by ID with its progress
+func (db *DB) GetRollout(ctx context.Context, id string) (*Rollout, error) {
+	rollout, err := scanRollout(db.conn.QueryRowContext(ctx, `SELECT `+rolloutColumns+` FROM rollouts WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("rollout not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query rollout: %w", err)
+	}
+	if err := db.loadRolloutProgress(ctx, rollout); err != nil {
+		return nil, err
+	}
+	return rollout, nil
+}
+
+// ListRollouts returns every rollout with its progress, newest first
+func (db *DB) ListRollouts(ctx context.Context) ([]Rollout, error) {
+	return db.queryRollouts(ctx, `SELECT `+rolloutColumns+` FROM rollouts ORDER BY created_at DESC`)
+}
+
+// ListActiveRollouts returns the rollouts the orchestrator still has work for, oldest first
+func (db *DB) ListActiveRollouts(ctx context.Context) ([]Rollout, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(activeRolloutStatuses)), ", ")
+	args := make([]interface{}, len(activeRolloutStatuses))
+	for i, status := range activeRolloutStatuses {
+		args[i] = status
+	}
+	return db.queryRollouts(ctx, `SELECT `+rolloutColumns+` FROM rollouts
+		WHERE status IN (`+placeholders+`) ORDER BY created_at`, args...)
+}
+
+func (db *DB) queryRollouts(ctx context.Context, query string, args ...interface{}) ([]Rollout, error) {
+	rows, err := db.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query rollouts: %w", err)
+	}
+	defer rows.Close()
+
+	rollouts := []Rollout{}
+	for rows.Next() {
+		rollout, err := scanRollout(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan rollout: %w", err)
+		}
+		rollouts = append(rollouts, *rollout)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range rollouts {
+		if err := db.loadRolloutProgress(ctx, &rollouts[i]); err != nil {
+			return nil, err
+		}
+	}
+	return rollouts, nil
+}
+
+// ListRolloutTenants returns a rollout's tenants in batch order
+func (db *DB) ListRolloutTenants(ctx context.Context, rolloutID string) ([]RolloutTenant, error) {
+	query := `SELECT customer_id, batch, previous_digest, status, error, updated_at FROM rollout_tenants
+			  WHERE rollout_id = ? ORDER BY batch, customer_id`
+	rows, err := db.conn.QueryContext(ctx, query, rolloutID)
+	if err != nil {
+		return nil, fmt.Errorf("query rollout tenants: %w", err)
+	}
+	defer rows.Close()
+
+	tenants := []RolloutTenant{}
+	for rows.Next() {
+		var t RolloutTenant
+		if err := rows.Scan(&t.CustomerID, &t.Batch, &t.PreviousDigest, &t.Status, &t.Error, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan rollout tenant: %w", err)
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, rows.Err()
+}
+
+// ListRolloutCandidates returns the active, degraded and suspended tenants of
+// an agent type that do not run the given image
+func (db *DB) ListRolloutCandidates(ctx context.Context, agentTypeID, imageDigest string) ([]RolloutCandidate, error) {
+	query := `SELECT id, COALESCE(image_digest, '') FROM customers
+			  WHERE agent_type_id = ? AND status IN ('active', 'degraded', 'suspended') AND container_port IS NOT NULL
+			  AND COALESCE(image_digest, '') != ? ORDER BY id`
+	rows, err := db.conn.QueryContext(ctx, query, agentTypeID, imageDigest)
+	if err != nil {
+		return nil, fmt.Errorf("query rollout candidates: %w", err)
+	}
+	defer rows.Close()
+
+	candidates := []RolloutCandidate{}
+	for rows.Next() {
+		var c RolloutCandidate
+		if err := rows.Scan(&c.CustomerID, &c.ImageDigest); err != nil {
+			return nil, fmt.Errorf("scan rollout candidate: %w", err)
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// AdvanceRollout moves a rollout to the given status and batch. It reports
+// false, leaving the rollout alone, once the rollout was halted or finished.
+func (db *DB) AdvanceRollout(ctx context.Context, id, status string, batch int) (bool, error) {
+	result, err := db.conn.ExecContext(ctx, `UPDATE rollouts SET status = ?, batch = ?, updated_at = ?
+		WHERE id = ? AND status IN (?, ?, ?)`, status, batch, time.Now().UTC(), id,
+		RolloutPending, RolloutCanary, RolloutRolling)
+	if err != nil {
+		return false, fmt.Errorf("advance rollout: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("advance rollout: %w", err)
+	}
+	return n > 0, nil
+}
+
+// HaltRollout asks the orchestrator to stop a rollout in progress and roll it back
+func (db *DB) HaltRollout(ctx context.Context, id string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE rollouts SET status = ?, updated_at = ?
+		WHERE id = ? AND status IN (?, ?, ?)`, RolloutHalting, time.Now().UTC(), id,
+		RolloutPending, RolloutCanary, RolloutRolling)
+	if err != nil {
+		return fmt.Errorf("halt rollout: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		if _, err := db.GetRollout(ctx, id); err != nil {
+			return err
+		}
+		return fmt.Errorf("rollout is not in progress")
+	}
+	return nil
+}
+
+// SetRolloutStatus records a rollout rolling back or finishing, with the
+// reason it failed if it did
+func (db *DB) SetRolloutStatus(ctx context.Context, id, status, reason string) error {
+	now := time.Now().UTC()
+	var finishedAt interface{}
+	if status == RolloutCompleted || status == RolloutRolledBack {
+		finishedAt = now
+	}
+	result, err := db.conn.ExecContext(ctx, `UPDATE rollouts SET status = ?, error = ?, updated_at = ?, finished_at = ?
+		WHERE id = ?`, status, reason, now, finishedAt, id)
+	if err != nil {
+		return fmt.Errorf("set rollout status: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("rollout not found")
+	}
+	return nil
+}
+
+// SetRolloutTenant records how upgrading or rolling back a tenant went
+func (db *DB) SetRolloutTenant(ctx context.Context, rolloutID, customerID, status, reason string) error {
+	result, err := db.conn.ExecContext(ctx, `UPDATE rollout_tenants SET status = ?, error = ?, updated_at = ?
+		WHERE rollout_id = ? AND customer_id = ?`, status, reason, time.Now().UTC(), rolloutID, customerID)
+	if err != nil {
+		return fmt.Errorf("set rollout tenant: %w", err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("rollout tenant not found")
+	}
+	return nil
+}
+
+func (db *DB) loadRolloutProgress(ctx context.Context, rollout *Rollout) error {
+	rows, err := db.conn.QueryContext(ctx,
+		`SELECT status, COUNT(*) FROM rollout_tenants WHERE rollout_id = ? GROUP BY status`, rollout.ID)
+	if err != nil {
+		return fmt.Errorf("query rollout progress: %w", err)
+	}
+	defer rows.Close()
+
+	progress := RolloutProgress{}
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return fmt.Errorf("scan rollout progress: %w", err)
+		}
+		progress.Total += count
+		switch status {
+		case RolloutTenantPending:
+			progress.Pending = count
+		case RolloutTenantUpgraded:
+			progress.Upgraded = count
+		case RolloutTenantFailed:
+			progress.Failed = count
+		case RolloutTenantRolledBack:
+			progress.RolledBack = count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rollout.Progress = progress
+	return nil
+}
+
+func scanRollout(row interface{ Scan(...interface{}) error }) (*Rollout, error) {
+	rollout := &Rollout{}
+	var finishedAt sql.NullTime
+	err := row.Scan(&rollout.ID, &rollout.AgentTypeID, &rollout.Image, &rollout.ImageDigest, &rollout.PreviousImage,
+		&rollout.PreviousDigest, &rollout.Status,
+		&rollout.CanarySize, &rollout.Concurrency, &rollout.BakeSeconds, &rollout.BatchBakeSeconds, &rollout.MaxErrorRate,
+		&rollout.Batch, &rollout.Batches, &rollout.Error, &rollout.CreatedAt, &rollout.UpdatedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		rollout.FinishedAt = &finishedAt.Time
+	}
+	return rollout, nil
+}
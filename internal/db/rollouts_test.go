@@ -0,0 +1,127 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRolloutCandidates(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	running := func(email, digest string) *Customer {
+		customer := createTestCustomer(t, database, email)
+		require.NoError(t, database.UpdateCustomerPort(ctx, customer.ID, 30000+len(email)))
+		require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+		if digest != "" {
+			require.NoError(t, database.SetCustomerImage(ctx, customer.ID, digest))
+		}
+		return customer
+	}
+	unpinned := running("a@example.com", "")
+	old := running("bb@example.com", "sha256:old")
+	running("ccc@example.com", "sha256:new")
+	suspended := running("dddd@example.com", "sha256:old")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, suspended.ID, "suspended"))
+	degraded := running("eeeee@example.com", "sha256:old")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, degraded.ID, "degraded"))
+	cancelled := running("ffffff@example.com", "sha256:old")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, cancelled.ID, "cancelled"))
+	createTestCustomer(t, database, "pending@example.com")
+
+	candidates, err := database.ListRolloutCandidates(ctx, "openclaw", "sha256:new")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []RolloutCandidate{
+		{CustomerID: unpinned.ID},
+		{CustomerID: old.ID, ImageDigest: "sha256:old"},
+		{CustomerID: suspended.ID, ImageDigest: "sha256:old"},
+		{CustomerID: degraded.ID, ImageDigest: "sha256:old"},
+	}, candidates)
+
+	candidates, err = database.ListRolloutCandidates(ctx, "myrai", "sha256:new")
+	require.NoError(t, err)
+	assert.Empty(t, candidates)
+}
+
+func TestRollouts(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	rollout := &Rollout{
+		AgentTypeID:      "openclaw",
+		Image:            "blytz/openclaw:1.5.0",
+		ImageDigest:      "sha256:new",
+		PreviousImage:    "blytz/openclaw:1.4.2",
+		PreviousDigest:   "sha256:old",
+		CanarySize:       1,
+		Concurrency:      2,
+		BakeSeconds:      600,
+		BatchBakeSeconds: 60,
+		MaxErrorRate:     0.1,
+		Batches:          2,
+	}
+	require.NoError(t, database.CreateRollout(ctx, rollout, []RolloutTenant{
+		{CustomerID: "alice", Batch: 0, PreviousDigest: "sha256:old"},
+		{CustomerID: "bob", Batch: 1},
+		{CustomerID: "carol", Batch: 1, PreviousDigest: "sha256:old"},
+	}))
+	assert.NotEmpty(t, rollout.ID)
+	assert.Equal(t, RolloutPending, rollout.Status)
+	assert.True(t, rollout.Active())
+
+	active, err := database.ListActiveRollouts(ctx)
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+	assert.Equal(t, RolloutProgress{Total: 3, Pending: 3}, active[0].Progress)
+
+	advanced, err := database.AdvanceRollout(ctx, rollout.ID, RolloutCanary, 0)
+	require.NoError(t, err)
+	assert.True(t, advanced)
+	require.NoError(t, database.SetRolloutTenant(ctx, rollout.ID, "alice", RolloutTenantUpgraded, ""))
+	require.NoError(t, database.SetRolloutTenant(ctx, rollout.ID, "bob", RolloutTenantFailed, "unhealthy"))
+	assert.Error(t, database.SetRolloutTenant(ctx, rollout.ID, "dave", RolloutTenantUpgraded, ""))
+
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RolloutCanary, got.Status)
+	assert.Equal(t, 0.1, got.MaxErrorRate)
+	assert.Equal(t, "blytz/openclaw:1.5.0", got.Image)
+	assert.Equal(t, "blytz/openclaw:1.4.2", got.PreviousImage)
+	assert.Equal(t, RolloutProgress{Total: 3, Pending: 1, Upgraded: 1, Failed: 1}, got.Progress)
+	assert.Nil(t, got.FinishedAt)
+
+	tenants, err := database.ListRolloutTenants(ctx, rollout.ID)
+	require.NoError(t, err)
+	require.Len(t, tenants, 3)
+	assert.Equal(t, "alice", tenants[0].CustomerID)
+	assert.Equal(t, "sha256:old", tenants[0].PreviousDigest)
+	assert.Equal(t, "unhealthy", tenants[1].Error)
+
+	// A halted rollout is not advanced any further
+	require.NoError(t, database.HaltRollout(ctx, rollout.ID))
+	advanced, err = database.AdvanceRollout(ctx, rollout.ID, RolloutRolling, 1)
+	require.NoError(t, err)
+	assert.False(t, advanced)
+
+	require.NoError(t, database.SetRolloutStatus(ctx, rollout.ID, RolloutRolledBack, "halted by operator"))
+	got, err = database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, RolloutRolledBack, got.Status)
+	assert.Equal(t, "halted by operator", got.Error)
+	assert.NotNil(t, got.FinishedAt)
+	assert.False(t, got.Active())
+
+	assert.Error(t, database.HaltRollout(ctx, rollout.ID), "finished rollouts cannot be halted")
+	assert.Error(t, database.HaltRollout(ctx, "missing"))
+	_, err = database.GetRollout(ctx, "missing")
+	assert.Error(t, err)
+
+	active, err = database.ListActiveRollouts(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, active)
+	all, err := database.ListRollouts(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 1)
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HealthCheck is one probe of a tenant agent's health endpoint
+type HealthCheck struct {
+	CheckedAt  time.Time `json:"checked_at"`
+	Healthy    bool      `json:"healthy"`
+	LatencyMS  float64   `json:"latency_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// healthEventPrefix marks supervisor actions in the audit log
+const healthEventPrefix = "health_"
+
+// RecordHealthCheck stores the result of probing a tenant's agent
+func (db *DB) RecordHealthCheck(ctx context.Context, customerID string, check HealthCheck) error {
+	query := `INSERT INTO health_checks (customer_id, checked_at, healthy, latency_ms, status_code, error)
+			  VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := db.conn.ExecContext(ctx, query, customerID, check.CheckedAt.UTC(), check.Healthy,
+		check.LatencyMS, check.StatusCode, check.Error)
+	if err != nil {
+		return fmt.Errorf("record health check: %w", err)
+	}
+	return nil
+}
+
+// ListHealthChecks returns up to limit probes for a customer since the given time, newest first
+func (db *DB) ListHealthChecks(ctx context.Context, customerID string, since time.Time, limit int) ([]HealthCheck, error) {
+	query := `SELECT checked_at, healthy, latency_ms, status_code, error FROM health_checks
+			  WHERE customer_id = ? AND checked_at >= ? ORDER BY checked_at DESC, id DESC LIMIT ?`
+	rows, err := db.conn.QueryContext(ctx, query, customerID, since.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("query health checks: %w", err)
+	}
+	defer rows.Close()
+
+	checks := []HealthCheck{}
+	for rows.Next() {
+		var check HealthCheck
+		if err := rows.Scan(&check.CheckedAt, &check.Healthy, &check.LatencyMS, &check.StatusCode, &check.Error); err != nil {
+			return nil, fmt.Errorf("scan health check: %w", err)
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, rows.Err()
+}
+
+// CountHealthChecks returns how many probes for a customer ran since the given time and how many passed
+func (db *DB) CountHealthChecks(ctx context.Context, customerID string, since time.Time) (total, healthy int, err error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(healthy), 0) FROM health_checks WHERE customer_id = ? AND checked_at >= ?`
+	if err := db.conn.QueryRowContext(ctx, query, customerID, since.UTC()).Scan(&total, &healthy); err != nil {
+		return 0, 0, fmt.Errorf("count health checks: %w", err)
+	}
+	return total, healthy, nil
+}
+
+// PruneHealthChecks deletes probes older than before and reports how many were removed
+func (db *DB) PruneHealthChecks(ctx context.Context, before time.Time) (int64, error) {
+	result, err := db.conn.ExecContext(ctx, `DELETE FROM health_checks WHERE checked_at < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("prune health checks: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// RecordHealthEvent adds a supervisor action such as "restart" or "degraded" to the audit log
+func (db *DB) RecordHealthEvent(ctx context.Context, customerID, event, details string) error {
+	var detailsArg interface{}
+	if details != "" {
+		detailsArg = details
+	}
+	return db.logAudit(ctx, customerID, healthEventPrefix+event, detailsArg)
+}
+
+// ListHealthEvents returns supervisor actions for a customer since the given time, oldest first.
+// The "health_" prefix is stripped from each entry's action.
+func (db *DB) ListHealthEvents(ctx context.Context, customerID string, since time.Time) ([]AuditEntry, error) {
+	entries, err := db.GetAuditLog(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []AuditEntry{}
+	for _, entry := range entries {
+		event, ok := strings.CutPrefix(entry.Action, healthEventPrefix)
+		if !ok || entry.CreatedAt.Before(since) {
+			continue
+		}
+		entry.Action = event
+		events = append(events, entry)
+	}
+	return events, nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecks(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "probe@example.com")
+	other := createTestCustomer(t, database, "other@example.com")
+
+	now := time.Now()
+	checks := []HealthCheck{
+		{CheckedAt: now.Add(-48 * time.Hour), Healthy: true, StatusCode: 200},
+		{CheckedAt: now.Add(-2 * time.Hour), Healthy: false, Error: "connection refused"},
+		{CheckedAt: now.Add(-time.Hour), Healthy: true, StatusCode: 200, LatencyMS: 12.5},
+	}
+	for _, check := range checks {
+		require.NoError(t, database.RecordHealthCheck(ctx, customer.ID, check))
+	}
+	require.NoError(t, database.RecordHealthCheck(ctx, other.ID, HealthCheck{CheckedAt: now, Healthy: false}))
+
+	recent, err := database.ListHealthChecks(ctx, customer.ID, now.Add(-24*time.Hour), 10)
+	require.NoError(t, err)
+	require.Len(t, recent, 2)
+	assert.True(t, recent[0].Healthy, "newest first")
+	assert.Equal(t, 12.5, recent[0].LatencyMS)
+	assert.Equal(t, "connection refused", recent[1].Error)
+
+	limited, err := database.ListHealthChecks(ctx, customer.ID, time.Time{}, 1)
+	require.NoError(t, err)
+	assert.Len(t, limited, 1)
+
+	total, healthy, err := database.CountHealthChecks(ctx, customer.ID, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, healthy)
+
+	pruned, err := database.PruneHealthChecks(ctx, now.Add(-24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+
+	total, _, err = database.CountHealthChecks(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+}
+
+func TestHealthEvents(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "events@example.com")
+
+	require.NoError(t, database.RecordHealthEvent(ctx, customer.ID, "restart", "3 consecutive failures"))
+	require.NoError(t, database.RecordHealthEvent(ctx, customer.ID, "degraded", ""))
+
+	events, err := database.ListHealthEvents(ctx, customer.ID, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, events, 2, "non-health audit entries are skipped")
+	assert.Equal(t, "restart", events[0].Action)
+	require.NotNil(t, events[0].Details)
+	assert.Equal(t, "3 consecutive failures", *events[0].Details)
+	assert.Equal(t, "degraded", events[1].Action)
+	assert.Nil(t, events[1].Details)
+
+	events, err = database.ListHealthEvents(ctx, customer.ID, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
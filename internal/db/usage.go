@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UsageBucketSize is the granularity usage events are aggregated at
+const UsageBucketSize = time.Hour
+
+// UsageEvent is activity reported by a tenant's agent
+type UsageEvent struct {
+	OccurredAt time.Time `json:"occurred_at"`
+	Messages   int       `json:"messages"`
+	Tokens     int       `json:"tokens"`
+	LatencyMS  float64   `json:"latency_ms"`
+}
+
+// UsageBucket is the aggregate of a tenant's usage events over one period
+type UsageBucket struct {
+	Start        time.Time `json:"start"`
+	Messages     int       `json:"messages"`
+	Tokens       int       `json:"tokens"`
+	AvgLatencyMS *float64  `json:"avg_latency_ms"`
+	LastEventAt  time.Time `json:"last_event_at"`
+
+	latencySum     float64
+	latencySamples int
+}
+
+// UsageSummary holds the headline activity figures shown on the dashboard
+type UsageSummary struct {
+	MessagesToday int        `json:"messages_today"`
+	MessagesTotal int        `json:"messages_total"`
+	TokensTotal   int        `json:"tokens_total"`
+	AvgLatencyMS  *float64   `json:"avg_latency_ms"` // over the last 24 hours
+	LastActiveAt  *time.Time `json:"last_active_at"`
+}
+
+// StackUsage is how much a combination of agent type and LLM provider is used
+type StackUsage struct {
+	AgentTypeID   string `json:"agent_id"`
+	LLMProviderID string `json:"llm_id"`
+	Tenants       int    `json:"tenants"`
+	Messages      int    `json:"messages"`
+}
+
+// SetGatewayToken stores the hash of the token a tenant's agent uses to call the platform
+func (db *DB) SetGatewayToken(ctx context.Context, id, token string) error {
+	query := `UPDATE customers SET gateway_token_hash = ?, updated_at = ? WHERE id = ?`
+	_, err := db.conn.ExecContext(ctx, query, hashToken(token), time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("set gateway token: %w", err)
+	}
+	return nil
+}
+
+// GetCustomerByGatewayToken looks up the customer whose agent holds a gateway token
+func (db *DB) GetCustomerByGatewayToken(ctx context.Context, token string) (*Customer, error) {
+	if token == "" {
+		return nil, fmt.Errorf("customer not found")
+	}
+
+	query := `SELECT id FROM customers WHERE gateway_token_hash = ?`
+	var id string
+	err := db.conn.QueryRowContext(ctx, query, hashToken(token)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("customer not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query customer by gateway token: %w", err)
+	}
+
+	return db.GetCustomerByID(ctx, id)
+}
+
+// RecordUsage adds events to the customer's hourly usage buckets in one transaction
+func (db *DB) RecordUsage(ctx context.Context, customerID string, events []UsageEvent) error {
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin record usage: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO usage_buckets
+			  (customer_id, bucket_start, messages, tokens, latency_ms_sum, latency_samples, last_event_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(customer_id, bucket_start) DO UPDATE SET
+				messages = messages + excluded.messages,
+				tokens = tokens + excluded.tokens,
+				latency_ms_sum = latency_ms_sum + excluded.latency_ms_sum,
+				latency_samples = latency_samples + excluded.latency_samples,
+				last_event_at = MAX(last_event_at, excluded.last_event_at)`
+
+	for _, event := range events {
+		at := event.OccurredAt.UTC()
+		samples := 0
+		if event.LatencyMS > 0 {
+			samples = 1
+		}
+		if _, err := tx.ExecContext(ctx, query, customerID, at.Truncate(UsageBucketSize),
+			event.Messages, event.Tokens, event.LatencyMS, samples, at); err != nil {
+			return fmt.Errorf("record usage: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit usage: %w", err)
+	}
+	return nil
+}
+
+// ListUsage returns the customer's usage aggregated into buckets of size
+// (a multiple of UsageBucketSize) starting at or after since, oldest first
+func (db *DB) ListUsage(ctx context.Context, customerID string, since time.Time, size time.Duration) ([]UsageBucket, error) {
+	query := `SELECT bucket_start, messages, tokens, latency_ms_sum, latency_samples, last_event_at
+			  FROM usage_buckets WHERE customer_id = ? AND bucket_start >= ? ORDER BY bucket_start`
+	rows, err := db.conn.QueryContext(ctx, query, customerID, since.UTC().Truncate(size))
+	if err != nil {
+		return nil, fmt.Errorf("query usage: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []UsageBucket{}
+	for rows.Next() {
+		var b UsageBucket
+		if err := rows.Scan(&b.Start, &b.Messages, &b.Tokens, &b.latencySum, &b.latencySamples, &b.LastEventAt); err != nil {
+			return nil, fmt.Errorf("scan usage bucket: %w", err)
+		}
+
+		start := b.Start.UTC().Truncate(size)
+		if n := len(buckets); n > 0 && buckets[n-1].Start.Equal(start) {
+			last := &buckets[n-1]
+			last.Messages += b.Messages
+			last.Tokens += b.Tokens
+			last.latencySum += b.latencySum
+			last.latencySamples += b.latencySamples
+			if b.LastEventAt.After(last.LastEventAt) {
+				last.LastEventAt = b.LastEventAt
+			}
+			continue
+		}
+		b.Start = start
+		buckets = append(buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query usage: %w", err)
+	}
+
+	for i := range buckets {
+		buckets[i].AvgLatencyMS = avgLatency(buckets[i].latencySum, buckets[i].latencySamples)
+	}
+	return buckets, nil
+}
+
+// GetUsageSummary returns the customer's dashboard figures as of now
+func (db *DB) GetUsageSummary(ctx context.Context, customerID string, now time.Time) (*UsageSummary, error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	dayAgo := now.Add(-24 * time.Hour).Truncate(UsageBucketSize)
+
+	query := `SELECT
+				COALESCE(SUM(messages), 0),
+				COALESCE(SUM(tokens), 0),
+				COALESCE(SUM(CASE WHEN bucket_start >= ? THEN messages END), 0),
+				COALESCE(SUM(CASE WHEN bucket_start >= ? THEN latency_ms_sum END), 0),
+				COALESCE(SUM(CASE WHEN bucket_start >= ? THEN latency_samples END), 0)
+			  FROM usage_buckets WHERE customer_id = ?`
+
+	summary := &UsageSummary{}
+	var latencySum float64
+	var latencySamples int
+	err := db.conn.QueryRowContext(ctx, query, today, dayAgo, dayAgo, customerID).Scan(
+		&summary.MessagesTotal, &summary.TokensTotal, &summary.MessagesToday, &latencySum, &latencySamples)
+	if err != nil {
+		return nil, fmt.Errorf("query usage summary: %w", err)
+	}
+	summary.AvgLatencyMS = avgLatency(latencySum, latencySamples)
+
+	var lastActive time.Time
+	err = db.conn.QueryRowContext(ctx,
+		`SELECT last_event_at FROM usage_buckets WHERE customer_id = ? ORDER BY bucket_start DESC LIMIT 1`,
+		customerID).Scan(&lastActive)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("query last activity: %w", err)
+	}
+	if err == nil {
+		summary.LastActiveAt = &lastActive
+	}
+
+	return summary, nil
+}
+
+// ListStackUsage counts running tenants and messages since the given time for
+// every agent type and LLM provider combination in use, busiest first
+func (db *DB) ListStackUsage(ctx context.Context, since time.Time) ([]StackUsage, error) {
+	query := `SELECT c.agent_type_id, c.llm_provider_id, COUNT(DISTINCT c.id), COALESCE(SUM(u.messages), 0)
+			  FROM customers c
+			  LEFT JOIN usage_buckets u ON u.customer_id = c.id AND u.bucket_start >= ?
+			  WHERE c.status IN ('active', 'degraded')
+			  GROUP BY c.agent_type_id, c.llm_provider_id
+			  ORDER BY 4 DESC, 3 DESC, c.agent_type_id, c.llm_provider_id`
+	rows, err := db.conn.QueryContext(ctx, query, since.UTC().Truncate(UsageBucketSize))
+	if err != nil {
+		return nil, fmt.Errorf("query stack usage: %w", err)
+	}
+	defer rows.Close()
+
+	stacks := []StackUsage{}
+	for rows.Next() {
+		var s StackUsage
+		if err := rows.Scan(&s.AgentTypeID, &s.LLMProviderID, &s.Tenants, &s.Messages); err != nil {
+			return nil, fmt.Errorf("scan stack usage: %w", err)
+		}
+		stacks = append(stacks, s)
+	}
+
+	return stacks, rows.Err()
+}
+
+func avgLatency(sum float64, samples int) *float64 {
+	if samples == 0 {
+		return nil
+	}
+	avg := sum / float64(samples)
+	return &avg
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayToken(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "gateway@example.com")
+
+	_, err := database.GetCustomerByGatewayToken(ctx, "gw-token")
+	assert.Error(t, err)
+
+	require.NoError(t, database.SetGatewayToken(ctx, customer.ID, "gw-token"))
+
+	found, err := database.GetCustomerByGatewayToken(ctx, "gw-token")
+	require.NoError(t, err)
+	assert.Equal(t, customer.ID, found.ID)
+
+	_, err = database.GetCustomerByGatewayToken(ctx, "")
+	assert.Error(t, err)
+
+	// The access token and gateway token are independent credentials
+	_, err = database.GetCustomerByAccessToken(ctx, "gw-token")
+	assert.Error(t, err)
+}
+
+func TestRecordUsageAggregatesHourly(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "usage@example.com")
+	other := createTestCustomer(t, database, "other@example.com")
+
+	hour := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	events := []UsageEvent{
+		{OccurredAt: hour.Add(5 * time.Minute), Messages: 1, Tokens: 100, LatencyMS: 200},
+		{OccurredAt: hour.Add(50 * time.Minute), Messages: 2, Tokens: 300, LatencyMS: 400},
+		{OccurredAt: hour.Add(70 * time.Minute), Messages: 1, Tokens: 50},
+		{OccurredAt: hour.Add(25 * time.Hour), Messages: 4, Tokens: 10, LatencyMS: 100},
+	}
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, events[:2]))
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, events[2:]))
+	require.NoError(t, database.RecordUsage(ctx, other.ID, []UsageEvent{{OccurredAt: hour, Messages: 99}}))
+
+	hourly, err := database.ListUsage(ctx, customer.ID, hour, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, hourly, 3)
+	assert.Equal(t, hour, hourly[0].Start.UTC())
+	assert.Equal(t, 3, hourly[0].Messages)
+	assert.Equal(t, 400, hourly[0].Tokens)
+	require.NotNil(t, hourly[0].AvgLatencyMS)
+	assert.Equal(t, 300.0, *hourly[0].AvgLatencyMS)
+	assert.True(t, hourly[0].LastEventAt.Equal(hour.Add(50*time.Minute)))
+	assert.Nil(t, hourly[1].AvgLatencyMS, "no latency reported in that hour")
+
+	daily, err := database.ListUsage(ctx, customer.ID, hour, 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, daily, 2)
+	assert.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), daily[0].Start)
+	assert.Equal(t, 4, daily[0].Messages)
+	assert.Equal(t, 450, daily[0].Tokens)
+	assert.Equal(t, 300.0, *daily[0].AvgLatencyMS)
+	assert.Equal(t, 4, daily[1].Messages)
+
+	later, err := database.ListUsage(ctx, customer.ID, hour.Add(2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, later, 1)
+}
+
+func TestGetUsageSummary(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	customer := createTestCustomer(t, database, "summary@example.com")
+
+	summary, err := database.GetUsageSummary(ctx, customer.ID, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, summary.MessagesTotal)
+	assert.Nil(t, summary.AvgLatencyMS)
+	assert.Nil(t, summary.LastActiveAt)
+
+	now := time.Date(2026, 3, 2, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []UsageEvent{
+		{OccurredAt: now.Add(-72 * time.Hour), Messages: 10, Tokens: 1000, LatencyMS: 5000},
+		{OccurredAt: now.Add(-13 * time.Hour), Messages: 2, Tokens: 20, LatencyMS: 300},
+		{OccurredAt: now.Add(-time.Hour), Messages: 3, Tokens: 30, LatencyMS: 100},
+	}))
+
+	summary, err = database.GetUsageSummary(ctx, customer.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, 15, summary.MessagesTotal)
+	assert.Equal(t, 1050, summary.TokensTotal)
+	assert.Equal(t, 3, summary.MessagesToday, "the 23:30 event was yesterday")
+	require.NotNil(t, summary.AvgLatencyMS)
+	assert.Equal(t, 200.0, *summary.AvgLatencyMS)
+	require.NotNil(t, summary.LastActiveAt)
+	assert.True(t, summary.LastActiveAt.Equal(now.Add(-time.Hour)))
+}
+
+func TestListStackUsage(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	a := createTestCustomer(t, database, "a@example.com")
+	b := createTestCustomer(t, database, "b@example.com")
+	c := createTestCustomer(t, database, "c@example.com")
+	createTestCustomer(t, database, "pending@example.com")
+	_, err := database.conn.ExecContext(ctx, `UPDATE customers SET agent_type_id = 'myrai', llm_provider_id = 'groq' WHERE id = ?`, c.ID)
+	require.NoError(t, err)
+	for _, id := range []string{a.ID, b.ID, c.ID} {
+		require.NoError(t, database.UpdateCustomerStatus(ctx, id, "active"))
+	}
+
+	now := time.Now()
+	require.NoError(t, database.RecordUsage(ctx, c.ID, []UsageEvent{{OccurredAt: now, Messages: 50}}))
+	require.NoError(t, database.RecordUsage(ctx, a.ID, []UsageEvent{
+		{OccurredAt: now, Messages: 5},
+		{OccurredAt: now.Add(-60 * 24 * time.Hour), Messages: 1000},
+	}))
+
+	stacks, err := database.ListStackUsage(ctx, now.Add(-30*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []StackUsage{
+		{AgentTypeID: "myrai", LLMProviderID: "groq", Tenants: 1, Messages: 50},
+		{AgentTypeID: "openclaw", LLMProviderID: "openai", Tenants: 2, Messages: 5},
+	}, stacks)
+}
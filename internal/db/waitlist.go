@@ -0,0 +1,308 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Waitlist entry statuses
+const (
+	WaitlistWaiting = "waiting"
+	WaitlistInvited = "invited"
+)
+
+// WaitlistEntry is a signup received while the platform was at capacity. It
+// keeps the validated request so the customer can be created once invited.
+type WaitlistEntry struct {
+	ID                 string `json:"id"`
+	Email              string `json:"email"`
+	AssistantName      string `json:"assistant_name"`
+	CustomInstructions string `json:"custom_instructions"`
+	TelegramBotToken   string `json:"-"`
+	AgentTypeID        string `json:"agent_type_id"`
+	LLMProviderID      string `json:"llm_provider_id"`
+	PlanID             string `json:"plan_id"`
+	PromoCode          string `json:"promo_code,omitempty"`
+	Status             string `json:"status"`
+	// Position is the entry's 1-based place among waiting entries; 0 once invited
+	Position        int        `json:"position,omitempty"`
+	InviteExpiresAt *time.Time `json:"invite_expires_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+const waitlistColumns = `id, email, assistant_name, custom_instructions, telegram_bot_token,
+	agent_type_id, llm_provider_id, plan_id, promo_code, status, invite_expires_at, created_at`
+
+// AddToWaitlist appends the entry to the end of the queue, filling in its ID,
+// status and position
+func (db *DB) AddToWaitlist(ctx context.Context, entry *WaitlistEntry) error {
+	entry.ID = uuid.New().String()
+	entry.Status = WaitlistWaiting
+	entry.CreatedAt = time.Now().UTC()
+
+	query := `INSERT INTO waitlist (id, email, assistant_name, custom_instructions, telegram_bot_token,
+			  agent_type_id, llm_provider_id, plan_id, promo_code, sort_order, status, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(sort_order), 0) + 1 FROM waitlist), ?, ?)`
+	_, err := db.conn.ExecContext(ctx, query, entry.ID, entry.Email, entry.AssistantName, entry.CustomInstructions,
+		entry.TelegramBotToken, entry.AgentTypeID, entry.LLMProviderID, entry.PlanID, entry.PromoCode,
+		entry.Status, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("insert waitlist entry: %w", err)
+	}
+
+	position, err := db.waitlistPosition(ctx, entry.ID)
+	if err != nil {
+		return err
+	}
+	entry.Position = position
+	return nil
+}
+
+// GetWaitlistEntry returns the entry with its current position
+func (db *DB) GetWaitlistEntry(ctx context.Context, id string) (*WaitlistEntry, error) {
+	row := db.conn.QueryRowContext(ctx, `SELECT `+waitlistColumns+` FROM waitlist WHERE id = ?`, id)
+	entry, err := scanWaitlistEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("waitlist entry not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query waitlist entry: %w", err)
+	}
+
+	if entry.Status == WaitlistWaiting {
+		if entry.Position, err = db.waitlistPosition(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+// findWaitlistEntryByEmail returns the entry queued under email, or nil if there is none
+func (db *DB) findWaitlistEntryByEmail(ctx context.Context, email string) (*WaitlistEntry, error) {
+	row := db.conn.QueryRowContext(ctx, `SELECT `+waitlistColumns+` FROM waitlist WHERE email = ?`, email)
+	entry, err := scanWaitlistEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query waitlist entry: %w", err)
+	}
+	return entry, nil
+}
+
+// IsWaitlisted reports whether the email is already on the waitlist
+func (db *DB) IsWaitlisted(ctx context.Context, email string) (bool, error) {
+	var count int
+	if err := db.conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM waitlist WHERE email = ?`, email).Scan(&count); err != nil {
+		return false, fmt.Errorf("query waitlist: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ListWaitlist returns invited entries followed by waiting entries in queue order
+func (db *DB) ListWaitlist(ctx context.Context) ([]WaitlistEntry, error) {
+	query := `SELECT ` + waitlistColumns + ` FROM waitlist
+			  ORDER BY CASE status WHEN 'invited' THEN 0 ELSE 1 END, sort_order`
+	rows, err := db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query waitlist: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []WaitlistEntry{}
+	position := 0
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan waitlist entry: %w", err)
+		}
+		if entry.Status == WaitlistWaiting {
+			position++
+			entry.Position = position
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// CountWaitlist returns how many entries are waiting and how many hold an invite
+func (db *DB) CountWaitlist(ctx context.Context) (waiting, invited int, err error) {
+	query := `SELECT
+			  COALESCE(SUM(CASE WHEN status = 'waiting' THEN 1 ELSE 0 END), 0),
+			  COALESCE(SUM(CASE WHEN status = 'invited' THEN 1 ELSE 0 END), 0)
+			  FROM waitlist`
+	if err := db.conn.QueryRowContext(ctx, query).Scan(&waiting, &invited); err != nil {
+		return 0, 0, fmt.Errorf("count waitlist: %w", err)
+	}
+	return waiting, invited, nil
+}
+
+// MoveWaitlistEntry places a waiting entry at the given 1-based position,
+// shifting the entries behind it back. Positions past the end move it last.
+func (db *DB) MoveWaitlistEntry(ctx context.Context, id string, position int) error {
+	if position < 1 {
+		return fmt.Errorf("position must be at least 1")
+	}
+
+	tx, err := db.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin move: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM waitlist WHERE status = 'waiting' ORDER BY sort_order`)
+	if err != nil {
+		return fmt.Errorf("query waitlist: %w", err)
+	}
+	var order []string
+	found := false
+	for rows.Next() {
+		var entryID string
+		if err := rows.Scan(&entryID); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan waitlist entry: %w", err)
+		}
+		if entryID == id {
+			found = true
+			continue
+		}
+		order = append(order, entryID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query waitlist: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("waitlist entry not found")
+	}
+
+	index := min(position-1, len(order))
+	order = append(order[:index], append([]string{id}, order[index:]...)...)
+
+	// Waiting entries are renumbered after any invited ones so new entries still append last
+	var base int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(sort_order), 0) FROM waitlist`).Scan(&base); err != nil {
+		return fmt.Errorf("query waitlist order: %w", err)
+	}
+	for i, entryID := range order {
+		if _, err := tx.ExecContext(ctx, `UPDATE waitlist SET sort_order = ? WHERE id = ?`, base+i+1, entryID); err != nil {
+			return fmt.Errorf("reorder waitlist: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit move: %w", err)
+	}
+	return nil
+}
+
+// InviteFromWaitlist invites up to n waiting entries in queue order, valid
+// until expiresAt or indefinitely when nil, and returns them
+func (db *DB) InviteFromWaitlist(ctx context.Context, n int, expiresAt *time.Time) ([]WaitlistEntry, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	query := `UPDATE waitlist SET status = 'invited', invite_expires_at = ?
+			  WHERE id IN (SELECT id FROM waitlist WHERE status = 'waiting' ORDER BY sort_order LIMIT ?)
+			  RETURNING ` + waitlistColumns
+	var expires interface{}
+	if expiresAt != nil {
+		expires = expiresAt.UTC()
+	}
+	rows, err := db.conn.QueryContext(ctx, query, expires, n)
+	if err != nil {
+		return nil, fmt.Errorf("invite from waitlist: %w", err)
+	}
+	defer rows.Close()
+
+	var invited []WaitlistEntry
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan waitlist entry: %w", err)
+		}
+		invited = append(invited, *entry)
+	}
+
+	return invited, rows.Err()
+}
+
+// ExpireWaitlistInvites removes entries whose invite lapsed before now and
+// returns how many were removed
+func (db *DB) ExpireWaitlistInvites(ctx context.Context, now time.Time) (int, error) {
+	result, err := db.conn.ExecContext(ctx, `DELETE FROM waitlist WHERE status = 'invited' AND invite_expires_at < ?`, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("expire waitlist invites: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("expire waitlist invites: %w", err)
+	}
+	return int(n), nil
+}
+
+// ListUnnotifiedWaitlistInvites returns open invitations the invitee has not
+// been told about yet, oldest first
+func (db *DB) ListUnnotifiedWaitlistInvites(ctx context.Context) ([]WaitlistEntry, error) {
+	query := `SELECT ` + waitlistColumns + ` FROM waitlist
+			  WHERE status = 'invited' AND invite_notified_at IS NULL ORDER BY sort_order`
+	rows, err := db.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("query waitlist invites: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []WaitlistEntry
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan waitlist entry: %w", err)
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// MarkWaitlistInviteNotified records that the invitee was sent their invitation
+func (db *DB) MarkWaitlistInviteNotified(ctx context.Context, id string, at time.Time) error {
+	if _, err := db.conn.ExecContext(ctx, `UPDATE waitlist SET invite_notified_at = ? WHERE id = ?`, at.UTC(), id); err != nil {
+		return fmt.Errorf("mark waitlist invite notified: %w", err)
+	}
+	return nil
+}
+
+// DeleteWaitlistEntry removes an entry, once claimed or withdrawn
+func (db *DB) DeleteWaitlistEntry(ctx context.Context, id string) error {
+	if _, err := db.conn.ExecContext(ctx, `DELETE FROM waitlist WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete waitlist entry: %w", err)
+	}
+	return nil
+}
+
+func (db *DB) waitlistPosition(ctx context.Context, id string) (int, error) {
+	query := `SELECT COUNT(*) FROM waitlist
+			  WHERE status = 'waiting' AND sort_order <= (SELECT sort_order FROM waitlist WHERE id = ?)`
+	var position int
+	if err := db.conn.QueryRowContext(ctx, query, id).Scan(&position); err != nil {
+		return 0, fmt.Errorf("query waitlist position: %w", err)
+	}
+	return position, nil
+}
+
+func scanWaitlistEntry(row interface{ Scan(...interface{}) error }) (*WaitlistEntry, error) {
+	var entry WaitlistEntry
+	err := row.Scan(&entry.ID, &entry.Email, &entry.AssistantName, &entry.CustomInstructions, &entry.TelegramBotToken,
+		&entry.AgentTypeID, &entry.LLMProviderID, &entry.PlanID, &entry.PromoCode, &entry.Status,
+		&entry.InviteExpiresAt, &entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
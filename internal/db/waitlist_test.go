@@ -0,0 +1,115 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func addWaitlisted(t *testing.T, database *DB, email string) *WaitlistEntry {
+	t.Helper()
+	entry := &WaitlistEntry{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+		PlanID:             "pro",
+	}
+	require.NoError(t, database.AddToWaitlist(t.Context(), entry))
+	return entry
+}
+
+func waitlistEmails(t *testing.T, database *DB) []string {
+	t.Helper()
+	entries, err := database.ListWaitlist(t.Context())
+	require.NoError(t, err)
+	emails := make([]string, len(entries))
+	for i, entry := range entries {
+		emails[i] = entry.Email
+	}
+	return emails
+}
+
+func TestWaitlistQueue(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+
+	a := addWaitlisted(t, database, "a@example.com")
+	b := addWaitlisted(t, database, "b@example.com")
+	c := addWaitlisted(t, database, "c@example.com")
+	assert.Equal(t, 1, a.Position)
+	assert.Equal(t, 3, c.Position)
+	assert.Equal(t, WaitlistWaiting, c.Status)
+
+	assert.Error(t, database.AddToWaitlist(ctx, &WaitlistEntry{Email: "a@example.com"}), "email is unique")
+
+	waitlisted, err := database.IsWaitlisted(ctx, "b@example.com")
+	require.NoError(t, err)
+	assert.True(t, waitlisted)
+
+	got, err := database.GetWaitlistEntry(ctx, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Position)
+	assert.Equal(t, "123:abc", got.TelegramBotToken)
+	assert.Equal(t, "pro", got.PlanID)
+
+	_, err = database.GetWaitlistEntry(ctx, "missing")
+	assert.Error(t, err)
+
+	// Reorder: c to the front, then a past the end
+	require.NoError(t, database.MoveWaitlistEntry(ctx, c.ID, 1))
+	assert.Equal(t, []string{"c@example.com", "a@example.com", "b@example.com"}, waitlistEmails(t, database))
+	require.NoError(t, database.MoveWaitlistEntry(ctx, a.ID, 10))
+	assert.Equal(t, []string{"c@example.com", "b@example.com", "a@example.com"}, waitlistEmails(t, database))
+	assert.Error(t, database.MoveWaitlistEntry(ctx, a.ID, 0))
+	assert.Error(t, database.MoveWaitlistEntry(ctx, "missing", 1))
+
+	expiresAt := time.Now().Add(time.Hour)
+	invited, err := database.InviteFromWaitlist(ctx, 2, &expiresAt)
+	require.NoError(t, err)
+	require.Len(t, invited, 2)
+	invitedEmails := []string{invited[0].Email, invited[1].Email}
+	assert.ElementsMatch(t, []string{"c@example.com", "b@example.com"}, invitedEmails)
+	assert.Equal(t, WaitlistInvited, invited[0].Status)
+	require.NotNil(t, invited[0].InviteExpiresAt)
+
+	// Invited entries can no longer be moved; new entries join behind the rest
+	assert.Error(t, database.MoveWaitlistEntry(ctx, b.ID, 1))
+	d := addWaitlisted(t, database, "d@example.com")
+	assert.Equal(t, 2, d.Position)
+
+	waiting, outstanding, err := database.CountWaitlist(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, waiting)
+	assert.Equal(t, 2, outstanding)
+
+	entries, err := database.ListWaitlist(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	assert.Equal(t, WaitlistInvited, entries[0].Status)
+	assert.Equal(t, 0, entries[0].Position)
+	assert.Equal(t, "a@example.com", entries[2].Email)
+	assert.Equal(t, 1, entries[2].Position)
+
+	// Only lapsed invites expire
+	expired, err := database.ExpireWaitlistInvites(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, expired)
+	expired, err = database.ExpireWaitlistInvites(ctx, time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, expired)
+
+	// Invitations without an expiry never lapse
+	invited, err = database.InviteFromWaitlist(ctx, 1, nil)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Nil(t, invited[0].InviteExpiresAt)
+	expired, err = database.ExpireWaitlistInvites(ctx, time.Now().Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, expired)
+
+	require.NoError(t, database.DeleteWaitlistEntry(ctx, a.ID))
+	assert.Equal(t, []string{"d@example.com"}, waitlistEmails(t, database))
+}
@@ -0,0 +1,315 @@
+// Package domains lets customers serve their agent from a host name of their
+// own. A claimed domain is routed to the tenant, with a certificate obtained
+// on demand, once DNS shows the customer controls it: either a TXT record
+// holding the domain's verification token or a CNAME to the tenant's
+// subdomain.
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+var (
+	// ErrInvalidDomain means a claimed host name cannot be served as a custom domain
+	ErrInvalidDomain = errors.New("invalid domain")
+	// ErrDomainTaken means another customer has verified the domain
+	ErrDomainTaken = errors.New("domain is already in use")
+	// ErrNotVerified means the domain's DNS records do not prove the customer controls it
+	ErrNotVerified = errors.New("domain could not be verified")
+)
+
+const (
+	// ChallengeLabel is prepended to a domain to name its verification TXT record
+	ChallengeLabel = "_blytz-challenge"
+	// TokenPrefix precedes the verification token in the TXT record
+	TokenPrefix = "blytz-verification="
+)
+
+// RecheckWindow is how long after a domain is claimed its DNS records are
+// rechecked in the background. Older pending domains are only checked when
+// the customer asks.
+const RecheckWindow = 72 * time.Hour
+
+// Resolver looks up the DNS records that verify a domain. *net.Resolver
+// satisfies it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// Router routes verified domains to their tenants
+type Router interface {
+	// RouteDomain points a domain at the customer's tenant with on-demand TLS
+	RouteDomain(ctx context.Context, customerID, domain string) error
+	// UnrouteDomain removes the route of the customer's domain; domains never
+	// routed are skipped
+	UnrouteDomain(ctx context.Context, customerID string) error
+}
+
+// Record is a DNS record that verifies a domain
+type Record struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Service claims, verifies and removes customers' custom domains
+type Service struct {
+	db         *db.DB
+	resolver   Resolver
+	router     Router
+	baseDomain string
+	logger     *zap.Logger
+	now        func() time.Time
+}
+
+// NewService creates a custom domain service. baseDomain is the domain
+// tenants' own subdomains live under, which cannot be claimed.
+func NewService(database *db.DB, resolver Resolver, router Router, baseDomain string, logger *zap.Logger) *Service {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Service{
+		db:         database,
+		resolver:   resolver,
+		router:     router,
+		baseDomain: strings.ToLower(baseDomain),
+		logger:     logger,
+		now:        time.Now,
+	}
+}
+
+// Claim gives a customer a pending custom domain with a new verification
+// token. A domain they had before is unrouted and replaced. Claiming the
+// domain the customer already has returns it unchanged. Domains another
+// customer has only claimed can be claimed too; whoever proves control of
+// the domain first gets it.
+func (s *Service) Claim(ctx context.Context, customerID, domain string) (*db.CustomDomain, error) {
+	domain, err := s.normalize(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := s.db.GetVerifiedCustomDomain(ctx, domain); err == nil && existing.CustomerID != customerID {
+		return nil, ErrDomainTaken
+	}
+
+	current, err := s.db.GetCustomDomain(ctx, customerID)
+	if err == nil && current.Domain == domain {
+		return current, nil
+	}
+	if err == nil && current.Status == db.DomainVerified {
+		if err := s.router.UnrouteDomain(ctx, customerID); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.db.SetCustomDomain(ctx, customerID, domain, strings.ReplaceAll(uuid.New().String(), "-", ""))
+}
+
+// Verify checks the DNS records of the customer's domain and routes it to
+// their tenant once they prove the customer controls it. Failed checks are
+// recorded on the domain and returned wrapping ErrNotVerified; a domain
+// another customer verified first also wraps ErrDomainTaken.
+func (s *Service) Verify(ctx context.Context, customerID string) (*db.CustomDomain, error) {
+	domain, err := s.db.GetCustomDomain(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if domain.Status == db.DomainVerified {
+		return domain, nil
+	}
+
+	if err := s.checkAvailable(ctx, domain); err != nil {
+		if err := s.db.MarkCustomDomainChecked(ctx, customerID, domain.Domain, err.Error()); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %w", ErrNotVerified, err)
+	}
+
+	if err := s.check(ctx, domain); err != nil {
+		if err := s.db.MarkCustomDomainChecked(ctx, customerID, domain.Domain, err.Error()); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrNotVerified, err)
+	}
+
+	// The domain is routed before it is marked verified, so Caddy is never
+	// allowed a certificate for a host it cannot serve
+	if err := s.router.RouteDomain(ctx, customerID, domain.Domain); err != nil {
+		return nil, err
+	}
+	if err := s.db.VerifyCustomDomain(ctx, customerID, domain.Domain); err != nil {
+		// The customer changed their domain while it was checked, or another
+		// customer verified it meanwhile
+		if err := s.router.UnrouteDomain(ctx, customerID); err != nil {
+			s.logger.Warn("Failed to unroute replaced custom domain", zap.String("domain", domain.Domain), zap.Error(err))
+		}
+		if taken := s.checkAvailable(ctx, domain); taken != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNotVerified, taken)
+		}
+		return nil, err
+	}
+
+	s.logger.Info("Custom domain verified", zap.String("customer_id", customerID), zap.String("domain", domain.Domain))
+	return s.db.GetCustomDomain(ctx, customerID)
+}
+
+// Remove unroutes and deletes the customer's custom domain
+func (s *Service) Remove(ctx context.Context, customerID string) error {
+	domain, err := s.db.GetCustomDomain(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if domain.Status == db.DomainVerified {
+		if err := s.router.UnrouteDomain(ctx, customerID); err != nil {
+			return err
+		}
+	}
+	return s.db.DeleteCustomDomain(ctx, customerID)
+}
+
+// Allowed reports whether host is a verified custom domain, which Caddy may
+// obtain a certificate for
+func (s *Service) Allowed(ctx context.Context, host string) bool {
+	_, err := s.db.GetVerifiedCustomDomain(ctx, strings.TrimSuffix(strings.ToLower(host), "."))
+	return err == nil
+}
+
+// Records returns the DNS records that verify a domain; either one is enough
+func (s *Service) Records(domain *db.CustomDomain) []Record {
+	return []Record{
+		{Type: "CNAME", Name: domain.Domain, Value: s.subdomain(domain.CustomerID)},
+		{Type: "TXT", Name: ChallengeLabel + "." + domain.Domain, Value: TokenPrefix + domain.Token},
+	}
+}
+
+// VerifyPending checks the DNS records of every domain claimed within
+// RecheckWindow that is not verified yet
+func (s *Service) VerifyPending(ctx context.Context) error {
+	pending, err := s.db.ListCustomDomains(ctx, db.DomainPending)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, domain := range pending {
+		if s.now().Sub(domain.CreatedAt) > RecheckWindow {
+			continue
+		}
+		if _, err := s.Verify(ctx, domain.CustomerID); err != nil && !errors.Is(err, ErrNotVerified) {
+			errs = append(errs, fmt.Errorf("verify %s: %w", domain.Domain, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run rechecks pending domains every interval until ctx is cancelled
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.VerifyPending(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to verify custom domains", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkAvailable returns ErrDomainTaken if another customer has verified the domain
+func (s *Service) checkAvailable(ctx context.Context, domain *db.CustomDomain) error {
+	if owner, err := s.db.GetVerifiedCustomDomain(ctx, domain.Domain); err == nil && owner.CustomerID != domain.CustomerID {
+		return ErrDomainTaken
+	}
+	return nil
+}
+
+// check looks for the domain's verification TXT record, then for a CNAME to
+// the tenant's subdomain
+func (s *Service) check(ctx context.Context, domain *db.CustomDomain) error {
+	records, txtErr := s.resolver.LookupTXT(ctx, ChallengeLabel+"."+domain.Domain)
+	for _, record := range records {
+		if strings.TrimSpace(record) == TokenPrefix+domain.Token {
+			return nil
+		}
+	}
+
+	target := s.subdomain(domain.CustomerID)
+	cname, cnameErr := s.resolver.LookupCNAME(ctx, domain.Domain)
+	if cnameErr == nil && strings.EqualFold(strings.TrimSuffix(cname, "."), target) {
+		return nil
+	}
+
+	// Temporary resolver failures are reported as such rather than as missing records
+	var dnsErr *net.DNSError
+	for _, err := range []error{txtErr, cnameErr} {
+		if errors.As(err, &dnsErr) && (dnsErr.IsTemporary || dnsErr.IsTimeout) {
+			return fmt.Errorf("look up DNS records: %w", err)
+		}
+	}
+	return fmt.Errorf("found neither a TXT record at %s.%s with the verification token nor a CNAME to %s",
+		ChallengeLabel, domain.Domain, target)
+}
+
+// subdomain is the tenant's host under the base domain
+func (s *Service) subdomain(customerID string) string {
+	return customerID + "." + s.baseDomain
+}
+
+// normalize lowercases a host name and checks it can be claimed
+func (s *Service) normalize(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || len(domain) > 253 {
+		return "", fmt.Errorf("%w: %q is not a host name", ErrInvalidDomain, domain)
+	}
+	if net.ParseIP(domain) != nil {
+		return "", fmt.Errorf("%w: %s is an IP address", ErrInvalidDomain, domain)
+	}
+	if domain == s.baseDomain || strings.HasSuffix(domain, "."+s.baseDomain) {
+		return "", fmt.Errorf("%w: %s is a platform domain", ErrInvalidDomain, domain)
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", fmt.Errorf("%w: %s is not a fully qualified domain", ErrInvalidDomain, domain)
+	}
+	for _, label := range labels {
+		if !validLabel(label) {
+			return "", fmt.Errorf("%w: %q is not a valid DNS label", ErrInvalidDomain, label)
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return "", fmt.Errorf("%w: %s has a numeric top-level domain", ErrInvalidDomain, domain)
+	}
+	return domain, nil
+}
+
+// validLabel reports whether label is 1-63 letters, digits and inner hyphens.
+// Internationalized names are claimed in their punycode form.
+func validLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,250 @@
+package domains
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+// fakeResolver answers DNS lookups from maps, failing like net.Resolver for
+// names it has no records for
+type fakeResolver struct {
+	txt   map[string][]string
+	cname map[string]string
+	// temporary fails every lookup as if the resolver timed out
+	temporary bool
+}
+
+func (f *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if f.temporary {
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+	if records, ok := f.txt[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (f *fakeResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if f.temporary {
+		return "", &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+	}
+	if cname, ok := f.cname[host]; ok {
+		return cname, nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// fakeRouter records routing changes as "route <customer> <domain>" and "unroute <customer>"
+type fakeRouter struct {
+	calls []string
+	err   error
+}
+
+func (f *fakeRouter) RouteDomain(ctx context.Context, customerID, domain string) error {
+	f.calls = append(f.calls, "route "+customerID+" "+domain)
+	return f.err
+}
+
+func (f *fakeRouter) UnrouteDomain(ctx context.Context, customerID string) error {
+	f.calls = append(f.calls, "unroute "+customerID)
+	return f.err
+}
+
+func newTestService(t *testing.T) (*Service, *db.DB, *fakeResolver, *fakeRouter) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	resolver := &fakeResolver{txt: map[string][]string{}, cname: map[string]string{}}
+	router := &fakeRouter{}
+	return NewService(database, resolver, router, "blytz.cloud", nil), database, resolver, router
+}
+
+func createCustomer(t *testing.T, database *db.DB, email string) *db.Customer {
+	t.Helper()
+	customer, err := database.CreateCustomer(t.Context(), &db.CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	return customer
+}
+
+func TestClaim(t *testing.T) {
+	svc, database, _, router := newTestService(t)
+	ctx := t.Context()
+	alice := createCustomer(t, database, "alice@example.com")
+	bob := createCustomer(t, database, "bob@example.com")
+	carol := createCustomer(t, database, "carol@example.com")
+
+	for _, domain := range []string{"", "localhost", "10.0.0.1", "blytz.cloud", "alice.blytz.cloud", "-bad.example.com",
+		"bad_label.example.com", "münchen.example", "example.123"} {
+		_, err := svc.Claim(ctx, alice.ID, domain)
+		assert.ErrorIs(t, err, ErrInvalidDomain, domain)
+	}
+
+	claimed, err := svc.Claim(ctx, alice.ID, " Assistant.Alice.Example. ")
+	require.NoError(t, err)
+	assert.Equal(t, "assistant.alice.example", claimed.Domain)
+	assert.Equal(t, db.DomainPending, claimed.Status)
+	assert.Len(t, claimed.Token, 32)
+	assert.Equal(t, []Record{
+		{Type: "CNAME", Name: "assistant.alice.example", Value: alice.ID + ".blytz.cloud"},
+		{Type: "TXT", Name: "_blytz-challenge.assistant.alice.example", Value: "blytz-verification=" + claimed.Token},
+	}, svc.Records(claimed))
+
+	again, err := svc.Claim(ctx, alice.ID, "assistant.alice.example")
+	require.NoError(t, err)
+	assert.Equal(t, claimed.Token, again.Token, "claiming the same domain keeps its token")
+
+	// A pending claim does not keep others from claiming the domain
+	contested, err := svc.Claim(ctx, bob.ID, "assistant.alice.example")
+	require.NoError(t, err)
+	assert.NotEqual(t, claimed.Token, contested.Token)
+
+	// A verified one does
+	require.NoError(t, database.VerifyCustomDomain(ctx, alice.ID, "assistant.alice.example"))
+	_, err = svc.Claim(ctx, carol.ID, "assistant.alice.example")
+	assert.ErrorIs(t, err, ErrDomainTaken)
+
+	// Replacing a verified domain unroutes it
+	replaced, err := svc.Claim(ctx, alice.ID, "bot.alice.example")
+	require.NoError(t, err)
+	assert.NotEqual(t, claimed.Token, replaced.Token)
+	assert.Equal(t, []string{"unroute " + alice.ID}, router.calls)
+
+	_, err = svc.Claim(ctx, carol.ID, "assistant.alice.example")
+	assert.NoError(t, err, "the old domain is free again")
+}
+
+func TestVerify(t *testing.T) {
+	svc, database, resolver, router := newTestService(t)
+	ctx := t.Context()
+	alice := createCustomer(t, database, "alice@example.com")
+	bob := createCustomer(t, database, "bob@example.com")
+
+	claimed, err := svc.Claim(ctx, alice.ID, "assistant.alice.example")
+	require.NoError(t, err)
+	assert.False(t, svc.Allowed(ctx, "assistant.alice.example"))
+
+	// A token in the wrong place, or a CNAME to another tenant, proves nothing
+	resolver.txt["assistant.alice.example"] = []string{"blytz-verification=" + claimed.Token}
+	resolver.cname["assistant.alice.example"] = bob.ID + ".blytz.cloud."
+	_, err = svc.Verify(ctx, alice.ID)
+	assert.ErrorIs(t, err, ErrNotVerified)
+	pending, err := database.GetCustomDomain(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Contains(t, pending.Error, "found neither a TXT record at _blytz-challenge.assistant.alice.example")
+	assert.Empty(t, router.calls)
+
+	resolver.temporary = true
+	_, err = svc.Verify(ctx, alice.ID)
+	assert.ErrorContains(t, err, "look up DNS records")
+	resolver.temporary = false
+
+	resolver.txt["_blytz-challenge.assistant.alice.example"] = []string{"v=spf1 -all", "blytz-verification=" + claimed.Token}
+	verified, err := svc.Verify(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.DomainVerified, verified.Status)
+	assert.Empty(t, verified.Error)
+	assert.Equal(t, []string{"route " + alice.ID + " assistant.alice.example"}, router.calls)
+	assert.True(t, svc.Allowed(ctx, "Assistant.Alice.Example"))
+
+	// Verifying again changes nothing
+	_, err = svc.Verify(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Len(t, router.calls, 1)
+
+	// A CNAME to the tenant's own subdomain also verifies
+	_, err = svc.Claim(ctx, bob.ID, "bot.bob.example")
+	require.NoError(t, err)
+	resolver.cname["bot.bob.example"] = bob.ID + ".BLYTZ.cloud."
+	_, err = svc.Verify(ctx, bob.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, svc.Remove(ctx, alice.ID))
+	assert.Equal(t, "unroute "+alice.ID, router.calls[len(router.calls)-1])
+	assert.False(t, svc.Allowed(ctx, "assistant.alice.example"))
+	assert.Error(t, svc.Remove(ctx, alice.ID))
+}
+
+func TestVerifyContested(t *testing.T) {
+	svc, database, resolver, router := newTestService(t)
+	ctx := t.Context()
+	alice := createCustomer(t, database, "alice@example.com")
+	bob := createCustomer(t, database, "bob@example.com")
+
+	_, err := svc.Claim(ctx, bob.ID, "assistant.alice.example")
+	require.NoError(t, err)
+	claimed, err := svc.Claim(ctx, alice.ID, "assistant.alice.example")
+	require.NoError(t, err)
+
+	// Only the customer who controls the domain's DNS can verify it
+	resolver.txt["_blytz-challenge.assistant.alice.example"] = []string{"blytz-verification=" + claimed.Token}
+	require.NoError(t, svc.VerifyPending(ctx))
+	assert.Equal(t, []string{"route " + alice.ID + " assistant.alice.example"}, router.calls)
+
+	// The other claim now fails as taken, and stays unrouted even if its records appear
+	resolver.cname["assistant.alice.example"] = bob.ID + ".blytz.cloud"
+	_, err = svc.Verify(ctx, bob.ID)
+	assert.ErrorIs(t, err, ErrNotVerified)
+	assert.ErrorIs(t, err, ErrDomainTaken)
+	assert.Len(t, router.calls, 1)
+	require.NoError(t, svc.VerifyPending(ctx))
+
+	owner, err := database.GetVerifiedCustomDomain(ctx, "assistant.alice.example")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, owner.CustomerID)
+}
+
+func TestVerifyRouteFailure(t *testing.T) {
+	svc, database, resolver, router := newTestService(t)
+	ctx := t.Context()
+	alice := createCustomer(t, database, "alice@example.com")
+
+	_, err := svc.Claim(ctx, alice.ID, "assistant.alice.example")
+	require.NoError(t, err)
+	resolver.cname["assistant.alice.example"] = alice.ID + ".blytz.cloud"
+	router.err = fmt.Errorf("caddy unreachable")
+
+	_, err = svc.Verify(ctx, alice.ID)
+	assert.ErrorContains(t, err, "caddy unreachable")
+	assert.False(t, svc.Allowed(ctx, "assistant.alice.example"), "unrouted domains get no certificate")
+}
+
+func TestVerifyPending(t *testing.T) {
+	svc, database, resolver, router := newTestService(t)
+	ctx := t.Context()
+	alice := createCustomer(t, database, "alice@example.com")
+	bob := createCustomer(t, database, "bob@example.com")
+
+	_, err := svc.Claim(ctx, alice.ID, "assistant.alice.example")
+	require.NoError(t, err)
+	_, err = svc.Claim(ctx, bob.ID, "bot.bob.example")
+	require.NoError(t, err)
+	resolver.cname["assistant.alice.example"] = alice.ID + ".blytz.cloud"
+
+	// Domains still missing their records are not an error
+	require.NoError(t, svc.VerifyPending(ctx))
+	assert.Equal(t, []string{"route " + alice.ID + " assistant.alice.example"}, router.calls)
+	assert.True(t, svc.Allowed(ctx, "assistant.alice.example"))
+	assert.False(t, svc.Allowed(ctx, "bot.bob.example"))
+
+	// Domains past the recheck window wait for the customer to ask
+	svc.now = func() time.Time { return time.Now().Add(RecheckWindow + time.Hour) }
+	resolver.cname["bot.bob.example"] = bob.ID + ".blytz.cloud"
+	require.NoError(t, svc.VerifyPending(ctx))
+	assert.False(t, svc.Allowed(ctx, "bot.bob.example"))
+}
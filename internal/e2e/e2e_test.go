@@ -24,6 +24,7 @@ import (
 	"blytz/internal/db"
 	"blytz/internal/provisioner"
 	"blytz/internal/stripe"
+	"blytz/internal/telegram"
 )
 
 // setupTestServer creates a fully configured test server
@@ -51,7 +52,7 @@ func setupTestServer(t *testing.T) (*gin.Engine, *db.DB, *provisioner.Service, s
 		database,
 		cfg.TemplatesDir,
 		cfg.CustomersDir,
-		cfg.OpenAIAPIKey,
+		cfg.LLMProxyURL,
 		cfg.PortRangeStart,
 		cfg.PortRangeEnd,
 		nil,
@@ -176,26 +177,29 @@ func TestE2E_CustomerSignupFlow(t *testing.T) {
 			TelegramBotToken:   "123:abc",
 		})
 		require.NoError(t, err)
+		require.NoError(t, database.SetCustomerAccessToken(ctx, customer.ID, "status-token"))
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/status/"+customer.ID, nil)
+		req.Header.Set("Authorization", "Bearer status-token")
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 
-		var resp db.Customer
+		var resp api.CustomerStatusResponse
 		err = json.Unmarshal(w.Body.Bytes(), &resp)
 		require.NoError(t, err)
 		assert.Equal(t, customer.ID, resp.ID)
 		assert.Equal(t, "pending", resp.Status)
+		assert.NotContains(t, w.Body.String(), "123:abc")
 	})
 
-	t.Run("get_customer_status_not_found", func(t *testing.T) {
+	t.Run("get_customer_status_unauthenticated", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/status/nonexistent-customer", nil)
 		router.ServeHTTP(w, req)
 
-		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
 	})
 }
 
@@ -232,11 +236,24 @@ func TestE2E_SystemStatus(t *testing.T) {
 	assert.Equal(t, float64(10), capacity["max_capacity"])
 }
 
-// TestE2E_CapacityLimit tests capacity enforcement
+// acceptingBots accepts every bot token without calling Telegram
+type acceptingBots struct {
+	provisioner.Provisioner
+}
+
+func (acceptingBots) ValidateBotToken(token string) (*telegram.BotInfo, error) {
+	return &telegram.BotInfo{OK: true}, nil
+}
+
+// TestE2E_CapacityLimit tests that signups beyond capacity join the waitlist
 func TestE2E_CapacityLimit(t *testing.T) {
-	router, database, _, _ := setupTestServer(t)
+	_, database, prov, tmpDir := setupTestServer(t)
 	defer database.Close()
 
+	cfg := &config.Config{MaxCustomers: 10, PortRangeStart: 30000, PortRangeEnd: 30010, CustomersDir: tmpDir}
+	router := api.NewRouter(database, acceptingBots{prov}, stripe.NewService("sk_test_dummy", "price_dummy"),
+		stripe.NewWebhookHandler(database, prov, "whsec_dummy"), cfg, zap.NewNop())
+
 	ctx := context.Background()
 	// Fill to capacity
 	for i := 0; i < 10; i++ {
@@ -263,7 +280,10 @@ func TestE2E_CapacityLimit(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	waitlisted, err := database.IsWaitlisted(ctx, "overflow@cap.com")
+	require.NoError(t, err)
+	assert.True(t, waitlisted)
 }
 
 // TestE2E_WebhookHandling tests Stripe webhook handling
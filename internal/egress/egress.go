@@ -0,0 +1,256 @@
+// Package egress renders the host firewall rules that confine a tenant's
+// containers to their own network: no other tenants, no host services beyond
+// the ones they need and, on restricted plans, no internet beyond the LLM
+// provider and Telegram.
+package egress
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Egress policies a plan can give its tenants
+const (
+	// Open lets tenants reach the internet but not the host, other tenants
+	// or private networks
+	Open = "open"
+	// Restricted lets tenants reach only their allowlist
+	Restricted = "restricted"
+)
+
+// ValidPolicy reports whether policy names a known egress policy
+func ValidPolicy(policy string) bool {
+	return policy == Open || policy == Restricted
+}
+
+// HostGateway is the name tenant containers reach the host by
+const HostGateway = "host.docker.internal"
+
+// TelegramRanges are the networks Telegram's Bot API is served from
+var TelegramRanges = []string{
+	"149.154.160.0/20",
+	"91.108.4.0/22",
+	"91.108.8.0/22",
+	"91.108.12.0/22",
+	"91.108.16.0/22",
+	"91.108.56.0/22",
+}
+
+// privateRanges are dropped under the open policy: other tenants' bridges,
+// the host's networks and cloud metadata endpoints
+var privateRanges = []string{
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+}
+
+// Destination is a TCP endpoint tenants may reach. Host is a name, an IP or a
+// CIDR.
+type Destination struct {
+	Host string
+	Port int
+}
+
+// DestinationFromURL returns the endpoint a URL points at, defaulting the port
+// from the scheme
+func DestinationFromURL(raw string) (Destination, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Destination{}, fmt.Errorf("parse url: %w", err)
+	}
+	if u.Hostname() == "" {
+		return Destination{}, fmt.Errorf("url %q has no host", raw)
+	}
+
+	port := 443
+	if u.Scheme == "http" {
+		port = 80
+	}
+	if p := u.Port(); p != "" {
+		if port, err = strconv.Atoi(p); err != nil {
+			return Destination{}, fmt.Errorf("parse port: %w", err)
+		}
+	}
+	return Destination{Host: u.Hostname(), Port: port}, nil
+}
+
+// Rules describe one tenant's firewall
+type Rules struct {
+	CustomerID string
+	Policy     string
+	// Allow lists the endpoints reachable under either policy. Under the open
+	// policy only host and private endpoints need listing.
+	Allow []Destination
+}
+
+// Resolver looks up a host name's addresses
+type Resolver func(ctx context.Context, host string) ([]string, error)
+
+// BridgeName is the Linux bridge of the tenant's own Docker network. Interface
+// names are capped at 15 characters, so it is derived from a hash of the ID.
+func BridgeName(customerID string) string {
+	return "bz" + shortHash(customerID)
+}
+
+// ChainName is the iptables chain holding the tenant's rules
+func ChainName(customerID string) string {
+	return "BLYTZ-" + shortHash(customerID)
+}
+
+// NetworkChain is the iptables chain isolating tenants on a shared network
+const NetworkChain = "BLYTZ-TENANTS"
+
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Render returns the tenant's rules as iptables-restore input for the filter
+// table. Traffic entering from the tenant's bridge, whether forwarded or
+// addressed to the host, jumps to the chain; RETURN lets it continue through
+// Docker's own rules. Host names are resolved with lookup when the rules are
+// rendered; names that do not resolve are left out and noted in a comment.
+func Render(ctx context.Context, r Rules, lookup Resolver) string {
+	chain := ChainName(r.CustomerID)
+	policy := r.Policy
+	if policy == "" {
+		policy = Open
+	}
+
+	var b strings.Builder
+	rule := func(format string, args ...any) {
+		fmt.Fprintf(&b, "-A %s "+format+"\n", append([]any{chain}, args...)...)
+	}
+
+	fmt.Fprintf(&b, "# Egress rules for tenant %s (%s)\n", r.CustomerID, policy)
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", chain)
+	rule("-m conntrack --ctstate ESTABLISHED,RELATED -j RETURN")
+	rule("-o %s -j RETURN", BridgeName(r.CustomerID))
+	// Docker's embedded DNS forwards queries from the container's namespace
+	rule("-p udp --dport 53 -j RETURN")
+	rule("-p tcp --dport 53 -j RETURN")
+
+	for _, dest := range r.Allow {
+		if isHostLocal(dest.Host) {
+			// Host services are reached either directly or through a
+			// published port Docker forwards to a container
+			rule("-p tcp -m addrtype --dst-type LOCAL --dport %d -j RETURN", dest.Port)
+			rule("-p tcp -m conntrack --ctstate DNAT --ctorigdstport %d -j RETURN", dest.Port)
+			continue
+		}
+		addrs, err := resolve(ctx, dest.Host, lookup)
+		if err != nil {
+			fmt.Fprintf(&b, "# unresolved %s:%d: %v\n", dest.Host, dest.Port, err)
+			continue
+		}
+		for _, addr := range addrs {
+			rule("-d %s -p tcp --dport %d -j RETURN", addr, dest.Port)
+		}
+	}
+
+	if policy == Restricted {
+		rule("-j DROP")
+	} else {
+		rule("-m addrtype --dst-type LOCAL -j DROP")
+		rule("-m conntrack --ctstate DNAT -j DROP")
+		for _, cidr := range privateRanges {
+			rule("-d %s -j DROP", cidr)
+		}
+	}
+	b.WriteString("COMMIT\n")
+
+	return b.String()
+}
+
+// RenderNetwork returns the rules isolating tenants that share a Docker
+// network as iptables-restore input for the filter table. Tenants may only
+// exchange traffic with peers, the IPs or CIDRs of Caddy and the control plane
+// on that network; anything else crossing its bridge, tenant to tenant or to
+// the host, is dropped.
+func RenderNetwork(network string, peers []string) string {
+	var b strings.Builder
+	rule := func(format string, args ...any) {
+		fmt.Fprintf(&b, "-A %s "+format+"\n", append([]any{NetworkChain}, args...)...)
+	}
+
+	fmt.Fprintf(&b, "# Isolation rules for tenant network %s\n", network)
+	b.WriteString("*filter\n")
+	fmt.Fprintf(&b, ":%s - [0:0]\n", NetworkChain)
+	rule("-m conntrack --ctstate ESTABLISHED,RELATED -j RETURN")
+	for _, peer := range peers {
+		addr, ok := peerAddress(peer)
+		if !ok {
+			fmt.Fprintf(&b, "# skipped peer %s: not an IP or CIDR\n", peer)
+			continue
+		}
+		rule("-s %s -j RETURN", addr)
+		rule("-d %s -j RETURN", addr)
+	}
+	rule("-j DROP")
+	b.WriteString("COMMIT\n")
+
+	return b.String()
+}
+
+// ValidPeer reports whether peer is an IPv4 address or network RenderNetwork
+// accepts
+func ValidPeer(peer string) bool {
+	_, ok := peerAddress(peer)
+	return ok
+}
+
+func peerAddress(peer string) (string, bool) {
+	if _, network, err := net.ParseCIDR(peer); err == nil && network.IP.To4() != nil {
+		return network.String(), true
+	}
+	if ip := net.ParseIP(peer); ip != nil && ip.To4() != nil {
+		return ip.String() + "/32", true
+	}
+	return "", false
+}
+
+// isHostLocal reports whether a host names the machine the tenant runs on
+func isHostLocal(host string) bool {
+	if host == HostGateway || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// resolve returns the IPv4 addresses or network a host stands for, sorted.
+// Only IPv4 rules are rendered; tenant networks have no IPv6.
+func resolve(ctx context.Context, host string, lookup Resolver) ([]string, error) {
+	if _, network, err := net.ParseCIDR(host); err == nil {
+		return []string{network.String()}, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String() + "/32"}, nil
+	}
+
+	found, err := lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, addr := range found {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			addrs = append(addrs, ip.String()+"/32")
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no IPv4 addresses")
+	}
+	slices.Sort(addrs)
+	return slices.Compact(addrs), nil
+}
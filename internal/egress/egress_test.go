@@ -0,0 +1,334 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeLookup(ctx context.Context, host string) ([]string, error) {
+	switch host {
+	case "api.example.com":
+		return []string{"203.0.113.20", "2001:db8::1", "203.0.113.10", "203.0.113.20"}, nil
+	case "v6only.example.com":
+		return []string{"2001:db8::2"}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+// expand fills in the tenant's chain and bridge names
+func expand(customerID, rules string) string {
+	return strings.NewReplacer("$CHAIN", ChainName(customerID), "$BRIDGE", BridgeName(customerID)).Replace(rules)
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		want  string
+	}{
+		{
+			name: "restricted",
+			rules: Rules{
+				CustomerID: "cust-1",
+				Policy:     Restricted,
+				Allow: []Destination{
+					{Host: HostGateway, Port: 8080},
+					{Host: "api.example.com", Port: 443},
+					{Host: "149.154.160.0/20", Port: 443},
+					{Host: "198.51.100.7", Port: 8443},
+					{Host: "control-plane", Port: 8080},
+					{Host: "v6only.example.com", Port: 443},
+				},
+			},
+			want: `# Egress rules for tenant cust-1 (restricted)
+*filter
+:$CHAIN - [0:0]
+-A $CHAIN -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN
+-A $CHAIN -o $BRIDGE -j RETURN
+-A $CHAIN -p udp --dport 53 -j RETURN
+-A $CHAIN -p tcp --dport 53 -j RETURN
+-A $CHAIN -p tcp -m addrtype --dst-type LOCAL --dport 8080 -j RETURN
+-A $CHAIN -p tcp -m conntrack --ctstate DNAT --ctorigdstport 8080 -j RETURN
+-A $CHAIN -d 203.0.113.10/32 -p tcp --dport 443 -j RETURN
+-A $CHAIN -d 203.0.113.20/32 -p tcp --dport 443 -j RETURN
+-A $CHAIN -d 149.154.160.0/20 -p tcp --dport 443 -j RETURN
+-A $CHAIN -d 198.51.100.7/32 -p tcp --dport 8443 -j RETURN
+# unresolved control-plane:8080: no such host
+# unresolved v6only.example.com:443: no IPv4 addresses
+-A $CHAIN -j DROP
+COMMIT
+`,
+		},
+		{
+			name: "open",
+			rules: Rules{
+				CustomerID: "cust-2",
+				Policy:     Open,
+				Allow:      []Destination{{Host: "localhost", Port: 8080}, {Host: "10.0.0.5", Port: 8080}},
+			},
+			want: `# Egress rules for tenant cust-2 (open)
+*filter
+:$CHAIN - [0:0]
+-A $CHAIN -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN
+-A $CHAIN -o $BRIDGE -j RETURN
+-A $CHAIN -p udp --dport 53 -j RETURN
+-A $CHAIN -p tcp --dport 53 -j RETURN
+-A $CHAIN -p tcp -m addrtype --dst-type LOCAL --dport 8080 -j RETURN
+-A $CHAIN -p tcp -m conntrack --ctstate DNAT --ctorigdstport 8080 -j RETURN
+-A $CHAIN -d 10.0.0.5/32 -p tcp --dport 8080 -j RETURN
+-A $CHAIN -m addrtype --dst-type LOCAL -j DROP
+-A $CHAIN -m conntrack --ctstate DNAT -j DROP
+-A $CHAIN -d 10.0.0.0/8 -j DROP
+-A $CHAIN -d 100.64.0.0/10 -j DROP
+-A $CHAIN -d 169.254.0.0/16 -j DROP
+-A $CHAIN -d 172.16.0.0/12 -j DROP
+-A $CHAIN -d 192.168.0.0/16 -j DROP
+COMMIT
+`,
+		},
+		{
+			name:  "unset policy is open",
+			rules: Rules{CustomerID: "cust-3"},
+			want: `# Egress rules for tenant cust-3 (open)
+*filter
+:$CHAIN - [0:0]
+-A $CHAIN -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN
+-A $CHAIN -o $BRIDGE -j RETURN
+-A $CHAIN -p udp --dport 53 -j RETURN
+-A $CHAIN -p tcp --dport 53 -j RETURN
+-A $CHAIN -m addrtype --dst-type LOCAL -j DROP
+-A $CHAIN -m conntrack --ctstate DNAT -j DROP
+-A $CHAIN -d 10.0.0.0/8 -j DROP
+-A $CHAIN -d 100.64.0.0/10 -j DROP
+-A $CHAIN -d 169.254.0.0/16 -j DROP
+-A $CHAIN -d 172.16.0.0/12 -j DROP
+-A $CHAIN -d 192.168.0.0/16 -j DROP
+COMMIT
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Render(t.Context(), tt.rules, fakeLookup)
+			assert.Equal(t, expand(tt.rules.CustomerID, tt.want), got)
+		})
+	}
+}
+
+// verdict walks rendered rules for a new connection from src to dst and
+// returns the target of the first rule it matches
+func verdict(t *testing.T, rules, src, dst string) string {
+	t.Helper()
+	for _, line := range strings.Split(rules, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "-A" || strings.Contains(line, "--ctstate") {
+			continue
+		}
+		matched := true
+		for i := 2; i+1 < len(fields); i += 2 {
+			var addr string
+			switch fields[i] {
+			case "-s":
+				addr = src
+			case "-d":
+				addr = dst
+			default:
+				continue
+			}
+			_, network, err := net.ParseCIDR(fields[i+1])
+			require.NoError(t, err)
+			matched = matched && network.Contains(net.ParseIP(addr))
+		}
+		if matched {
+			return fields[len(fields)-1]
+		}
+	}
+	return "ACCEPT"
+}
+
+func TestRenderNetwork(t *testing.T) {
+	rules := RenderNetwork("blytz-tenants", []string{"172.30.0.2", "172.30.0.3/32", "caddy"})
+	assert.Equal(t, `# Isolation rules for tenant network blytz-tenants
+*filter
+:BLYTZ-TENANTS - [0:0]
+-A BLYTZ-TENANTS -m conntrack --ctstate ESTABLISHED,RELATED -j RETURN
+-A BLYTZ-TENANTS -s 172.30.0.2/32 -j RETURN
+-A BLYTZ-TENANTS -d 172.30.0.2/32 -j RETURN
+-A BLYTZ-TENANTS -s 172.30.0.3/32 -j RETURN
+-A BLYTZ-TENANTS -d 172.30.0.3/32 -j RETURN
+# skipped peer caddy: not an IP or CIDR
+-A BLYTZ-TENANTS -j DROP
+COMMIT
+`, rules)
+
+	tests := []struct {
+		name     string
+		src, dst string
+		want     string
+	}{
+		{name: "caddy to tenant", src: "172.30.0.3", dst: "172.30.0.10", want: "RETURN"},
+		{name: "control plane to tenant", src: "172.30.0.2", dst: "172.30.0.11", want: "RETURN"},
+		{name: "tenant to control plane", src: "172.30.0.10", dst: "172.30.0.2", want: "RETURN"},
+		{name: "tenant to tenant", src: "172.30.0.10", dst: "172.30.0.11", want: "DROP"},
+		{name: "tenant to host", src: "172.30.0.10", dst: "172.30.0.1", want: "DROP"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, verdict(t, rules, tt.src, tt.dst))
+		})
+	}
+}
+
+func TestValidPeer(t *testing.T) {
+	assert.True(t, ValidPeer("172.30.0.2"))
+	assert.True(t, ValidPeer("172.30.0.0/29"))
+	assert.False(t, ValidPeer("caddy"))
+	assert.False(t, ValidPeer("2001:db8::1"))
+}
+
+func TestNames(t *testing.T) {
+	id := "0b6f1c1e-3a8e-4f7b-9a39-2f0f5c8d7e61"
+
+	// Linux caps interface names at 15 characters and iptables chains at 28
+	assert.LessOrEqual(t, len(BridgeName(id)), 15)
+	assert.LessOrEqual(t, len(ChainName(id)), 28)
+	assert.Equal(t, BridgeName(id), BridgeName(id))
+	assert.NotEqual(t, BridgeName(id), BridgeName("other"))
+}
+
+func TestDestinationFromURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Destination
+		wantErr bool
+	}{
+		{url: "http://host.docker.internal:8080", want: Destination{Host: HostGateway, Port: 8080}},
+		{url: "https://api.openai.com/v1", want: Destination{Host: "api.openai.com", Port: 443}},
+		{url: "http://llm.internal/v1", want: Destination{Host: "llm.internal", Port: 80}},
+		{url: "/v1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			got, err := DestinationFromURL(tt.url)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// fakeIptables records commands and answers like iptables would
+type fakeIptables struct {
+	commands []string
+	stdin    []byte
+	// hooked holds the jumps already present
+	hooked map[string]bool
+	chains map[string]bool
+}
+
+func (f *fakeIptables) run(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	command := name + " " + strings.Join(args, " ")
+	f.commands = append(f.commands, command)
+	if name == "iptables-restore" {
+		f.stdin = stdin
+		return nil, nil
+	}
+
+	op, rest := args[1], strings.Join(args[2:], " ")
+	switch op {
+	case "-C":
+		if !f.hooked[rest] {
+			return []byte("iptables: Bad rule"), errors.New("exit status 1")
+		}
+	case "-I":
+		f.hooked[rest] = true
+	case "-D":
+		if !f.hooked[rest] {
+			return []byte("iptables: Bad rule"), errors.New("exit status 1")
+		}
+		delete(f.hooked, rest)
+	case "-F", "-X":
+		if !f.chains[rest] {
+			return []byte("iptables: No chain/target/match by that name."), errors.New("exit status 1")
+		}
+		if op == "-X" {
+			delete(f.chains, rest)
+		}
+	}
+	return nil, nil
+}
+
+func TestFirewall(t *testing.T) {
+	ctx := t.Context()
+	id := "cust-1"
+	chain, bridge := ChainName(id), BridgeName(id)
+	fake := &fakeIptables{hooked: map[string]bool{}, chains: map[string]bool{}}
+	fw := &Firewall{run: fake.run}
+
+	rules := []byte(Render(ctx, Rules{CustomerID: id, Policy: Restricted}, fakeLookup))
+	require.NoError(t, fw.Apply(ctx, id, rules))
+	assert.Equal(t, rules, fake.stdin)
+	assert.Equal(t, []string{
+		"iptables-restore -w --noflush",
+		fmt.Sprintf("iptables -w -C DOCKER-USER -i %s -j %s", bridge, chain),
+		fmt.Sprintf("iptables -w -I DOCKER-USER -i %s -j %s", bridge, chain),
+		fmt.Sprintf("iptables -w -C INPUT -i %s -j %s", bridge, chain),
+		fmt.Sprintf("iptables -w -I INPUT -i %s -j %s", bridge, chain),
+	}, fake.commands)
+	fake.chains[chain] = true
+
+	// Reapplying reloads the chain without hooking it twice
+	fake.commands = nil
+	require.NoError(t, fw.Apply(ctx, id, rules))
+	assert.Len(t, fake.commands, 3)
+	assert.Len(t, fake.hooked, 2)
+
+	require.NoError(t, fw.Remove(ctx, id))
+	assert.Empty(t, fake.hooked)
+	assert.Empty(t, fake.chains)
+
+	// Removing a tenant without rules is a no-op
+	require.NoError(t, fw.Remove(ctx, id))
+}
+
+func TestFirewallIsolateNetwork(t *testing.T) {
+	ctx := t.Context()
+	fake := &fakeIptables{hooked: map[string]bool{}, chains: map[string]bool{}}
+	fw := &Firewall{run: fake.run}
+
+	rules := []byte(RenderNetwork("blytz-tenants", []string{"172.30.0.2"}))
+	require.NoError(t, fw.IsolateNetwork(ctx, "blytz-tenants", rules))
+	assert.Equal(t, rules, fake.stdin)
+	assert.Equal(t, []string{
+		"iptables-restore -w --noflush",
+		"iptables -w -C DOCKER-USER -i blytz-tenants -j BLYTZ-TENANTS",
+		"iptables -w -I DOCKER-USER -i blytz-tenants -j BLYTZ-TENANTS",
+		"iptables -w -C INPUT -i blytz-tenants -j BLYTZ-TENANTS",
+		"iptables -w -I INPUT -i blytz-tenants -j BLYTZ-TENANTS",
+	}, fake.commands)
+
+	fake.commands = nil
+	require.NoError(t, fw.IsolateNetwork(ctx, "blytz-tenants", rules))
+	assert.Len(t, fake.commands, 3)
+	assert.Len(t, fake.hooked, 2)
+}
+
+func TestFirewallApplyFails(t *testing.T) {
+	fw := &Firewall{run: func(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+		return []byte("iptables-restore: line 3 failed"), errors.New("exit status 1")
+	}}
+	err := fw.Apply(t.Context(), "cust-1", []byte("*filter\nCOMMIT\n"))
+	assert.ErrorContains(t, err, "line 3 failed")
+}
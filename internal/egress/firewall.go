@@ -0,0 +1,87 @@
+package egress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// hooks are the built-in chains a tenant's traffic is diverted from: traffic
+// forwarded to containers or out to the internet, and traffic addressed to
+// the host itself
+var hooks = []string{"DOCKER-USER", "INPUT"}
+
+// Firewall installs rendered rules on the host with iptables
+type Firewall struct {
+	// run executes a command, feeding it stdin, and returns its combined output
+	run func(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error)
+}
+
+func NewFirewall() *Firewall {
+	return &Firewall{run: runCommand}
+}
+
+// Apply loads a tenant's rendered rules, replacing any loaded before, and
+// diverts traffic from the tenant's bridge to them
+func (f *Firewall) Apply(ctx context.Context, customerID string, rules []byte) error {
+	return f.load(ctx, BridgeName(customerID), ChainName(customerID), rules)
+}
+
+// IsolateNetwork loads the rules RenderNetwork rendered for a shared tenant
+// network, replacing any loaded before, and diverts traffic from its bridge
+// to them. Docker names the bridge after the network's
+// com.docker.network.bridge.name option.
+func (f *Firewall) IsolateNetwork(ctx context.Context, bridge string, rules []byte) error {
+	return f.load(ctx, bridge, NetworkChain, rules)
+}
+
+func (f *Firewall) load(ctx context.Context, bridge, chain string, rules []byte) error {
+	if output, err := f.run(ctx, rules, "iptables-restore", "-w", "--noflush"); err != nil {
+		return fmt.Errorf("load egress rules: %w (output: %s)", err, output)
+	}
+
+	for _, hook := range hooks {
+		jump := []string{hook, "-i", bridge, "-j", chain}
+		if _, err := f.iptables(ctx, "-C", jump...); err == nil {
+			continue
+		}
+		if output, err := f.iptables(ctx, "-I", jump...); err != nil {
+			return fmt.Errorf("hook egress rules into %s: %w (output: %s)", hook, err, output)
+		}
+	}
+	return nil
+}
+
+// Remove deletes a tenant's rules. Tenants without rules are left alone.
+func (f *Firewall) Remove(ctx context.Context, customerID string) error {
+	chain := ChainName(customerID)
+	for _, hook := range hooks {
+		// Fails when the jump was never added
+		f.iptables(ctx, "-D", hook, "-i", BridgeName(customerID), "-j", chain)
+	}
+
+	if output, err := f.iptables(ctx, "-F", chain); err != nil {
+		if strings.Contains(string(output), "No chain") {
+			return nil
+		}
+		return fmt.Errorf("flush egress rules: %w (output: %s)", err, output)
+	}
+	if output, err := f.iptables(ctx, "-X", chain); err != nil {
+		return fmt.Errorf("delete egress rules: %w (output: %s)", err, output)
+	}
+	return nil
+}
+
+func (f *Firewall) iptables(ctx context.Context, op string, args ...string) ([]byte, error) {
+	return f.run(ctx, nil, "iptables", append([]string{"-w", op}, args...)...)
+}
+
+func runCommand(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	return cmd.CombinedOutput()
+}
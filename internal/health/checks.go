@@ -0,0 +1,79 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DockerChecker pings the Docker daemon through the same CLI the provisioner uses
+func DockerChecker() Checker {
+	return NewChecker("docker", func(ctx context.Context) error {
+		out, err := exec.CommandContext(ctx, "docker", "version", "--format", "{{.Server.Version}}").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("docker version: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
+
+// HTTPChecker expects a 2xx response from url
+func HTTPChecker(name, url string, client *http.Client) Checker {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return NewChecker(name, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("create request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("request %s: %w", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// CaddyChecker verifies the Caddy admin API is reachable
+func CaddyChecker(adminURL string) Checker {
+	return HTTPChecker("caddy", strings.TrimSuffix(adminURL, "/")+"/config/", nil)
+}
+
+// DiskSpaceChecker fails when the filesystem holding path has less than minFreeBytes available
+func DiskSpaceChecker(path string, minFreeBytes uint64) Checker {
+	return NewChecker("disk", func(ctx context.Context) error {
+		free, err := freeBytes(path)
+		if err != nil {
+			return fmt.Errorf("stat filesystem for %s: %w", path, err)
+		}
+		if free < minFreeBytes {
+			return fmt.Errorf("%d MB free under %s, need at least %d MB", free>>20, path, minFreeBytes>>20)
+		}
+		return nil
+	})
+}
+
+// FilesChecker fails when any of files is missing from dir
+func FilesChecker(name, dir string, files ...string) Checker {
+	return NewChecker(name, func(ctx context.Context) error {
+		var missing []string
+		for _, file := range files {
+			if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+				missing = append(missing, file)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("missing from %s: %s", dir, strings.Join(missing, ", "))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,151 @@
+// Package health runs pluggable readiness checks against the platform's dependencies
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Checker probes a single dependency
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+type checkerFunc struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (c checkerFunc) Name() string                    { return c.name }
+func (c checkerFunc) Check(ctx context.Context) error { return c.fn(ctx) }
+
+// NewChecker wraps a function as a named Checker
+func NewChecker(name string, fn func(ctx context.Context) error) Checker {
+	return checkerFunc{name: name, fn: fn}
+}
+
+// Result is the outcome of one checker
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"` // "pass" or "fail"
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of a full readiness run
+type Report struct {
+	Status    string    `json:"status"` // "ready" or "not_ready"
+	Checks    []Result  `json:"checks"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached"`
+}
+
+// Ready reports whether every check passed
+func (r *Report) Ready() bool {
+	return r.Status == "ready"
+}
+
+// Service runs registered checkers concurrently, each with its own timeout,
+// and caches the report briefly so probes cannot hammer the dependencies.
+type Service struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	mu       sync.Mutex
+	checkers []Checker
+	cached   *Report
+}
+
+// NewService creates a health service. Each checker gets timeout to finish and
+// reports are reused for cacheTTL.
+func NewService(timeout, cacheTTL time.Duration) *Service {
+	return &Service{
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+	}
+}
+
+// Register adds a checker to every subsequent run
+func (s *Service) Register(checkers ...Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkers = append(s.checkers, checkers...)
+	s.cached = nil
+}
+
+// Check returns the cached report if it is fresh, otherwise runs every checker
+func (s *Service) Check(ctx context.Context) Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cached != nil && time.Since(s.cached.CheckedAt) < s.cacheTTL {
+		report := *s.cached
+		report.Cached = true
+		return report
+	}
+
+	report := s.run(ctx)
+	s.cached = &report
+	return report
+}
+
+func (s *Service) run(ctx context.Context) Report {
+	results := make([]Result, len(s.checkers))
+
+	var wg sync.WaitGroup
+	for i, checker := range s.checkers {
+		wg.Add(1)
+		go func(i int, checker Checker) {
+			defer wg.Done()
+			results[i] = s.runOne(ctx, checker)
+		}(i, checker)
+	}
+	wg.Wait()
+
+	report := Report{
+		Status:    "ready",
+		Checks:    results,
+		CheckedAt: time.Now(),
+	}
+	for _, result := range results {
+		if result.Status != "pass" {
+			report.Status = "not_ready"
+			break
+		}
+	}
+
+	return report
+}
+
+func (s *Service) runOne(ctx context.Context, checker Checker) Result {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Checkers that ignore ctx must not hold up the whole report
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Name:      checker.Name(),
+		Status:    "pass",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "fail"
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,145 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		checkers   []Checker
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "no checkers",
+			wantStatus: "ready",
+			wantChecks: map[string]string{},
+		},
+		{
+			name: "all pass",
+			checkers: []Checker{
+				NewChecker("a", func(ctx context.Context) error { return nil }),
+				NewChecker("b", func(ctx context.Context) error { return nil }),
+			},
+			wantStatus: "ready",
+			wantChecks: map[string]string{"a": "pass", "b": "pass"},
+		},
+		{
+			name: "one fails",
+			checkers: []Checker{
+				NewChecker("a", func(ctx context.Context) error { return nil }),
+				NewChecker("b", func(ctx context.Context) error { return errors.New("down") }),
+			},
+			wantStatus: "not_ready",
+			wantChecks: map[string]string{"a": "pass", "b": "fail"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService(time.Second, 0)
+			svc.Register(tt.checkers...)
+
+			report := svc.Check(t.Context())
+			assert.Equal(t, tt.wantStatus, report.Status)
+			assert.Equal(t, tt.wantStatus == "ready", report.Ready())
+
+			got := make(map[string]string)
+			for _, result := range report.Checks {
+				got[result.Name] = result.Status
+				if result.Status == "fail" {
+					assert.NotEmpty(t, result.Error)
+				}
+			}
+			assert.Equal(t, tt.wantChecks, got)
+		})
+	}
+}
+
+func TestServiceTimeout(t *testing.T) {
+	svc := NewService(20*time.Millisecond, 0)
+	// Ignores its context entirely; the service must still give up on it
+	svc.Register(NewChecker("stuck", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	start := time.Now()
+	report := svc.Check(t.Context())
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+
+	require.Len(t, report.Checks, 1)
+	assert.Equal(t, "fail", report.Checks[0].Status)
+	assert.Contains(t, report.Checks[0].Error, "deadline exceeded")
+}
+
+func TestServiceCachesReport(t *testing.T) {
+	var calls atomic.Int32
+	svc := NewService(time.Second, time.Hour)
+	svc.Register(NewChecker("counted", func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}))
+
+	first := svc.Check(t.Context())
+	second := svc.Check(t.Context())
+	assert.False(t, first.Cached)
+	assert.True(t, second.Cached)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Registering a checker invalidates the cache
+	svc.Register(NewChecker("other", func(ctx context.Context) error { return nil }))
+	third := svc.Check(t.Context())
+	assert.False(t, third.Cached)
+	assert.Len(t, third.Checks, 2)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestHTTPChecker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/config/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	assert.NoError(t, CaddyChecker(server.URL).Check(t.Context()))
+	assert.NoError(t, CaddyChecker(server.URL+"/").Check(t.Context()))
+	assert.Error(t, HTTPChecker("broken", server.URL+"/broken", nil).Check(t.Context()))
+	assert.Error(t, CaddyChecker("http://127.0.0.1:1").Check(t.Context()))
+}
+
+func TestDiskSpaceChecker(t *testing.T) {
+	dir := t.TempDir()
+
+	assert.NoError(t, DiskSpaceChecker(dir, 0).Check(t.Context()))
+	assert.Error(t, DiskSpaceChecker(dir, ^uint64(0)).Check(t.Context()))
+	assert.Error(t, DiskSpaceChecker(filepath.Join(dir, "missing"), 0).Check(t.Context()))
+}
+
+func TestFilesChecker(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "a.tmpl"), []byte("a"), 0644))
+
+	assert.NoError(t, FilesChecker("templates", dir, "sub/a.tmpl").Check(t.Context()))
+
+	err := FilesChecker("templates", dir, "sub/a.tmpl", "sub/b.tmpl").Check(t.Context())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sub/b.tmpl")
+	assert.NotContains(t, err.Error(), "sub/a.tmpl")
+}
@@ -0,0 +1,196 @@
+// Package images builds versioned agent images from Dockerfile templates and
+// records their digests, so tenants run a fixed build instead of installing
+// their agent when the container starts.
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+// DockerfileTemplates holds the Dockerfile each agent type's image is built from
+var DockerfileTemplates = map[string]string{
+	"openclaw": `FROM {{.BaseImage}}
+RUN npm install -g openclaw@{{.Version}} && npm cache clean --force
+LABEL org.opencontainers.image.title="blytz-openclaw" \
+      org.opencontainers.image.version="{{.Version}}"
+USER 1000:1000
+WORKDIR /home/node
+`,
+	"myrai": `FROM {{.BaseRepository}}:{{.Version}}
+LABEL org.opencontainers.image.title="blytz-myrai" \
+      org.opencontainers.image.version="{{.Version}}"
+`,
+}
+
+// versionPattern is what Docker accepts as a tag
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// dockerfileData fills a Dockerfile template
+type dockerfileData struct {
+	BaseImage      string
+	BaseRepository string
+	Version        string
+}
+
+// Dockerfile renders the Dockerfile for a version of an agent type
+func Dockerfile(agentType *db.AgentType, version string) (string, error) {
+	templateStr, ok := DockerfileTemplates[agentType.ID]
+	if !ok {
+		return "", fmt.Errorf("no Dockerfile template for agent type %s", agentType.ID)
+	}
+	if !versionPattern.MatchString(version) {
+		return "", fmt.Errorf("invalid version %q", version)
+	}
+
+	tmpl, err := template.New("dockerfile").Parse(templateStr)
+	if err != nil {
+		return "", fmt.Errorf("parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, dockerfileData{
+		BaseImage:      agentType.BaseImage,
+		BaseRepository: repository(agentType.BaseImage),
+		Version:        version,
+	})
+	if err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// repository strips the tag or digest from an image reference
+func repository(ref string) string {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	// A colon after the last slash starts the tag; one before it is a registry port
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// Image is a built agent image
+type Image struct {
+	// Tag is the versioned tag the image was built as
+	Tag string
+	// Digest is the reference by digest tenants are pinned to: the registry
+	// digest of a pushed image, or the local image ID otherwise
+	Digest string
+}
+
+// Builder builds agent images with the Docker CLI
+type Builder struct {
+	db *db.DB
+	// repository prefixes image tags: <repository>/<agent type>:<version>
+	repository string
+	// push sends images to their registry so every node can pull them
+	push   bool
+	logger *zap.Logger
+	// run executes a command, feeding it stdin, and returns its standard output
+	run func(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error)
+}
+
+func NewBuilder(database *db.DB, repository string, push bool, logger *zap.Logger) *Builder {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Builder{
+		db:         database,
+		repository: strings.TrimSuffix(repository, "/"),
+		push:       push,
+		logger:     logger,
+		run:        runCommand,
+	}
+}
+
+// Build builds and tags a version of an agent type's image, pushes it when the
+// builder pushes, and records it as the image new tenants of the agent type
+// are pinned to. Tenants already provisioned keep the image they run.
+func (b *Builder) Build(ctx context.Context, agentTypeID, version string) (*Image, error) {
+	agentType, err := b.db.GetAgentType(ctx, agentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile, err := Dockerfile(agentType, version)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := fmt.Sprintf("%s/%s:%s", b.repository, agentType.ID, version)
+	logger := b.logger.With(zap.String("agent_type", agentType.ID), zap.String("tag", tag))
+	logger.Info("Building agent image")
+
+	// The Dockerfile comes on stdin with no build context
+	if output, err := b.run(ctx, []byte(dockerfile), "docker", "build", "--pull", "-t", tag, "-"); err != nil {
+		return nil, fmt.Errorf("build image: %w (output: %s)", err, output)
+	}
+
+	image := &Image{Tag: tag}
+	if b.push {
+		if output, err := b.run(ctx, nil, "docker", "push", tag); err != nil {
+			return nil, fmt.Errorf("push image: %w (output: %s)", err, output)
+		}
+		if image.Digest, err = b.repoDigest(ctx, tag); err != nil {
+			return nil, err
+		}
+	} else {
+		output, err := b.run(ctx, nil, "docker", "image", "inspect", "--format", "{{.Id}}", tag)
+		if err != nil {
+			return nil, fmt.Errorf("inspect image: %w (output: %s)", err, output)
+		}
+		image.Digest = strings.TrimSpace(string(output))
+		logger.Warn("Image not pushed; only this host can run it")
+	}
+
+	if err := b.db.SetAgentTypeImage(ctx, agentType.ID, image.Tag, image.Digest); err != nil {
+		return nil, err
+	}
+	logger.Info("Agent image built", zap.String("digest", image.Digest))
+	return image, nil
+}
+
+// repoDigest returns the pushed image's reference by registry digest
+func (b *Builder) repoDigest(ctx context.Context, tag string) (string, error) {
+	output, err := b.run(ctx, nil, "docker", "image", "inspect", "--format", "{{json .RepoDigests}}", tag)
+	if err != nil {
+		return "", fmt.Errorf("inspect image: %w (output: %s)", err, output)
+	}
+	var digests []string
+	if err := json.Unmarshal(output, &digests); err != nil {
+		return "", fmt.Errorf("decode repo digests: %w", err)
+	}
+
+	repo := repository(tag)
+	for _, digest := range digests {
+		if strings.HasPrefix(digest, repo+"@") {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("no registry digest for %s after push", tag)
+}
+
+func runCommand(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return append(output, stderr.Bytes()...), err
+	}
+	return output, nil
+}
@@ -0,0 +1,164 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	return database
+}
+
+func TestDockerfile(t *testing.T) {
+	database := newTestDB(t)
+
+	for agentTypeID := range DockerfileTemplates {
+		t.Run(agentTypeID, func(t *testing.T) {
+			agentType, err := database.GetAgentType(t.Context(), agentTypeID)
+			require.NoError(t, err, "templates are for seeded agent types")
+
+			dockerfile, err := Dockerfile(agentType, "1.4.2")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(dockerfile, "FROM "))
+			assert.Contains(t, dockerfile, `org.opencontainers.image.version="1.4.2"`)
+			assert.NotContains(t, dockerfile, "latest")
+		})
+	}
+
+	openclaw, err := database.GetAgentType(t.Context(), "openclaw")
+	require.NoError(t, err)
+	dockerfile, err := Dockerfile(openclaw, "1.4.2")
+	require.NoError(t, err)
+	assert.Contains(t, dockerfile, "RUN npm install -g openclaw@1.4.2 ")
+
+	myrai, err := database.GetAgentType(t.Context(), "myrai")
+	require.NoError(t, err)
+	dockerfile, err = Dockerfile(myrai, "v0.9.0")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(dockerfile, "FROM ghcr.io/gmsas95/myrai:v0.9.0\n"))
+
+	_, err = Dockerfile(openclaw, "1.0 && curl evil")
+	assert.Error(t, err)
+	_, err = Dockerfile(&db.AgentType{ID: "unknown"}, "1.0")
+	assert.Error(t, err)
+}
+
+func TestRepository(t *testing.T) {
+	tests := map[string]string{
+		"node:22-bookworm":                    "node",
+		"ghcr.io/gmsas95/myrai:latest":        "ghcr.io/gmsas95/myrai",
+		"registry.local:5000/blytz/openclaw":  "registry.local:5000/blytz/openclaw",
+		"registry.local:5000/blytz/myrai:1.0": "registry.local:5000/blytz/myrai",
+		"blytz/openclaw@sha256:abc":           "blytz/openclaw",
+	}
+	for ref, want := range tests {
+		assert.Equal(t, want, repository(ref), ref)
+	}
+}
+
+// fakeDocker records docker commands and answers inspections
+type fakeDocker struct {
+	commands   []string
+	dockerfile string
+	failPush   bool
+}
+
+func (f *fakeDocker) run(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	f.commands = append(f.commands, command)
+	switch {
+	case args[0] == "build":
+		f.dockerfile = string(stdin)
+	case args[0] == "push" && f.failPush:
+		return []byte("denied: requested access to the resource is denied"), errors.New("exit status 1")
+	case strings.Contains(command, "{{.Id}}"):
+		return []byte("sha256:1111\n"), nil
+	case strings.Contains(command, "{{json .RepoDigests}}"):
+		return []byte(`["mirror.local/openclaw@sha256:2222","registry.local:5000/blytz/openclaw@sha256:3333"]`), nil
+	}
+	return nil, nil
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name         string
+		push         bool
+		failPush     bool
+		wantDigest   string
+		wantCommands []string
+		wantErr      bool
+	}{
+		{
+			name:       "local",
+			wantDigest: "sha256:1111",
+			wantCommands: []string{
+				"docker build --pull -t registry.local:5000/blytz/openclaw:1.4.2 -",
+				"docker image inspect --format {{.Id}} registry.local:5000/blytz/openclaw:1.4.2",
+			},
+		},
+		{
+			name:       "pushed",
+			push:       true,
+			wantDigest: "registry.local:5000/blytz/openclaw@sha256:3333",
+			wantCommands: []string{
+				"docker build --pull -t registry.local:5000/blytz/openclaw:1.4.2 -",
+				"docker push registry.local:5000/blytz/openclaw:1.4.2",
+				"docker image inspect --format {{json .RepoDigests}} registry.local:5000/blytz/openclaw:1.4.2",
+			},
+		},
+		{
+			name:     "push refused",
+			push:     true,
+			failPush: true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := newTestDB(t)
+			ctx := t.Context()
+			docker := &fakeDocker{failPush: tt.failPush}
+			builder := NewBuilder(database, "registry.local:5000/blytz/", tt.push, nil)
+			builder.run = docker.run
+
+			image, err := builder.Build(ctx, "openclaw", "1.4.2")
+			agentType, getErr := database.GetAgentType(ctx, "openclaw")
+			require.NoError(t, getErr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Empty(t, agentType.ImageDigest, "a failed build records nothing")
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "registry.local:5000/blytz/openclaw:1.4.2", image.Tag)
+			assert.Equal(t, tt.wantDigest, image.Digest)
+			assert.Equal(t, tt.wantCommands, docker.commands)
+			assert.Contains(t, docker.dockerfile, "openclaw@1.4.2")
+
+			assert.Equal(t, image.Tag, agentType.Image)
+			assert.Equal(t, image.Digest, agentType.ImageDigest)
+		})
+	}
+}
+
+func TestBuildUnknownAgentType(t *testing.T) {
+	builder := NewBuilder(newTestDB(t), "blytz", false, nil)
+	builder.run = (&fakeDocker{}).run
+
+	_, err := builder.Build(t.Context(), "missing", "1.0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package llmproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+const (
+	// maxMeteredBody bounds how much of a non-streaming response is kept for parsing
+	maxMeteredBody = 8 << 20
+	// maxEventLine bounds a single server-sent event line
+	maxEventLine = 1 << 20
+)
+
+// tokenUsage is what a response reported about its own cost
+type tokenUsage struct {
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
+// usageFields covers the usage objects of both APIs: OpenAI reports
+// prompt/completion tokens, Anthropic and the OpenAI Responses API input/output
+type usageFields struct {
+	PromptTokens             int `json:"prompt_tokens"`
+	CompletionTokens         int `json:"completion_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type usageHolder struct {
+	Model string       `json:"model"`
+	Usage *usageFields `json:"usage"`
+}
+
+// usagePayload is a response body or stream event. Anthropic's message_start
+// nests usage under "message" and OpenAI's response.completed under "response".
+type usagePayload struct {
+	usageHolder
+	Message  *usageHolder `json:"message"`
+	Response *usageHolder `json:"response"`
+}
+
+// observe merges the usage reported in one JSON payload. Streams report
+// cumulative counts, so the largest value seen wins.
+func (u *tokenUsage) observe(data []byte) {
+	var payload usagePayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return
+	}
+
+	for _, holder := range []*usageHolder{&payload.usageHolder, payload.Message, payload.Response} {
+		if holder == nil {
+			continue
+		}
+		if u.Model == "" {
+			u.Model = holder.Model
+		}
+		if holder.Usage == nil {
+			continue
+		}
+		f := holder.Usage
+		u.InputTokens = max(u.InputTokens, f.PromptTokens,
+			f.InputTokens+f.CacheCreationInputTokens+f.CacheReadInputTokens)
+		u.OutputTokens = max(u.OutputTokens, f.CompletionTokens, f.OutputTokens)
+	}
+}
+
+// meter passes a response body through while reading the usage it reports,
+// calling done once when the body is exhausted or closed
+type meter struct {
+	body   io.ReadCloser
+	stream bool
+	buf    bytes.Buffer
+	usage  tokenUsage
+	once   sync.Once
+	done   func(tokenUsage)
+}
+
+func newMeter(body io.ReadCloser, stream bool, done func(tokenUsage)) *meter {
+	return &meter{body: body, stream: stream, done: done}
+}
+
+func (m *meter) Read(p []byte) (int, error) {
+	n, err := m.body.Read(p)
+	m.write(p[:n])
+	if err == io.EOF {
+		m.finish()
+	}
+	return n, err
+}
+
+// Close reports whatever was seen so far; a client that disconnects
+// mid-stream is billed for the usage reported up to that point
+func (m *meter) Close() error {
+	m.finish()
+	return m.body.Close()
+}
+
+func (m *meter) write(p []byte) {
+	if !m.stream {
+		if m.buf.Len()+len(p) <= maxMeteredBody {
+			m.buf.Write(p)
+		}
+		return
+	}
+
+	m.buf.Write(p)
+	for {
+		line, err := m.buf.ReadBytes('\n')
+		if err != nil {
+			// Keep the partial line for the next read unless it is runaway
+			if len(line) <= maxEventLine {
+				m.buf.Write(line)
+			}
+			return
+		}
+		m.event(line)
+	}
+}
+
+func (m *meter) event(line []byte) {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !ok {
+		return
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+		return
+	}
+	m.usage.observe(data)
+}
+
+func (m *meter) finish() {
+	m.once.Do(func() {
+		if m.stream {
+			m.event(m.buf.Bytes())
+		} else {
+			m.usage.observe(m.buf.Bytes())
+		}
+		m.buf.Reset()
+		m.done(m.usage)
+	})
+}
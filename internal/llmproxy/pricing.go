@@ -0,0 +1,63 @@
+package llmproxy
+
+import (
+	"math"
+	"strings"
+)
+
+// Price is the cost of a model in US dollars per million tokens
+type Price struct {
+	Input  float64
+	Output float64
+}
+
+// Prices maps model name prefixes to prices; the longest matching prefix wins,
+// so dated snapshots such as "gpt-4o-2024-08-06" are priced as their family.
+type Prices map[string]Price
+
+// DefaultPrices are the providers' list prices for the models tenants commonly use
+var DefaultPrices = Prices{
+	"gpt-4o":            {Input: 2.50, Output: 10.00},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4.1":           {Input: 2.00, Output: 8.00},
+	"gpt-4.1-mini":      {Input: 0.40, Output: 1.60},
+	"gpt-4.1-nano":      {Input: 0.10, Output: 0.40},
+	"gpt-4-turbo":       {Input: 10.00, Output: 30.00},
+	"gpt-4":             {Input: 30.00, Output: 60.00},
+	"gpt-3.5-turbo":     {Input: 0.50, Output: 1.50},
+	"o1":                {Input: 15.00, Output: 60.00},
+	"o3-mini":           {Input: 1.10, Output: 4.40},
+	"o4-mini":           {Input: 1.10, Output: 4.40},
+	"text-embedding-3":  {Input: 0.13, Output: 0},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4.00},
+	"claude-3-5-sonnet": {Input: 3.00, Output: 15.00},
+	"claude-3-7-sonnet": {Input: 3.00, Output: 15.00},
+	"claude-sonnet-4":   {Input: 3.00, Output: 15.00},
+	"claude-3-opus":     {Input: 15.00, Output: 75.00},
+	"claude-opus-4":     {Input: 15.00, Output: 75.00},
+}
+
+// fallbackPrice bills unknown models at the top of the market so spend caps still hold
+var fallbackPrice = Price{Input: 15.00, Output: 75.00}
+
+// Lookup returns the price for a model
+func (p Prices) Lookup(model string) Price {
+	best, found := "", false
+	for prefix := range p {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, found = prefix, true
+		}
+	}
+	if !found {
+		return fallbackPrice
+	}
+	return p[best]
+}
+
+// Cost returns the cost of a request in micro-dollars, rounded up
+func (p Prices) Cost(model string, inputTokens, outputTokens int) int64 {
+	price := p.Lookup(model)
+	// Dollars per million tokens is the same number as micro-dollars per token
+	return int64(math.Ceil(float64(inputTokens)*price.Input + float64(outputTokens)*price.Output))
+}
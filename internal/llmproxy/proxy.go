@@ -0,0 +1,364 @@
+// Package llmproxy forwards tenant LLM calls to the providers with the platform's
+// keys, metering tokens and enforcing per-tenant spend caps and rate limits
+package llmproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ulule/limiter/v3"
+	"github.com/ulule/limiter/v3/drivers/store/memory"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/telemetry"
+)
+
+// maxRequestBytes bounds request bodies, which are buffered to read the model
+const maxRequestBytes = 10 << 20
+
+var (
+	ErrUnknownProvider = errors.New("llm provider is not available through the proxy")
+	ErrPathNotAllowed  = errors.New("llm endpoint is not available through the proxy")
+	ErrInactive        = errors.New("customer is not active")
+	ErrRateLimited     = errors.New("llm proxy rate limit exceeded")
+	ErrSpendCapReached = errors.New("monthly llm spend cap reached")
+)
+
+// provider describes how a provider's API authenticates and how tenants find it
+type provider struct {
+	// authHeader carries the API key, prefixed with authPrefix
+	authHeader string
+	authPrefix string
+	// baseURLEnv is read by the provider's SDKs to override the API location
+	baseURLEnv string
+	// sdkPath is appended to the proxy URL to form the base URL the SDKs expect
+	sdkPath string
+	// paths are the metered endpoints tenants may call; anything else, such as
+	// files or fine-tuning, would spend the platform key outside the cap
+	paths []string
+}
+
+var providers = map[string]provider{
+	"openai": {
+		authHeader: "Authorization",
+		authPrefix: "Bearer ",
+		baseURLEnv: "OPENAI_BASE_URL",
+		sdkPath:    "/v1",
+		paths:      []string{"/v1/chat/completions", "/v1/embeddings"},
+	},
+	"anthropic": {
+		authHeader: "X-Api-Key",
+		baseURLEnv: "ANTHROPIC_BASE_URL",
+		paths:      []string{"/v1/messages"},
+	},
+}
+
+// Proxied reports whether tenants reach a provider through the proxy
+func Proxied(providerID string) bool {
+	_, ok := providers[providerID]
+	return ok
+}
+
+// TenantEnv returns the environment a tenant container needs to call a proxied
+// provider: the proxy token in place of the API key and the proxy's base URL.
+// proxyURL is the platform's base URL as reachable from tenant containers.
+func TenantEnv(providerID, envKey, proxyURL, token string) map[string]string {
+	env := map[string]string{envKey: token}
+	if p, ok := providers[providerID]; ok && proxyURL != "" {
+		env[p.baseURLEnv] = strings.TrimRight(proxyURL, "/") + "/llm/" + providerID + p.sdkPath
+	}
+	return env
+}
+
+// Upstream is a provider API the proxy forwards to with the platform's key
+type Upstream struct {
+	BaseURL string
+	APIKey  string
+}
+
+// Limits bound a tenant's use of the proxy; zero values mean unlimited
+type Limits struct {
+	MonthlySpendMicros int64
+	RequestsPerMinute  int64
+}
+
+// LimitsFunc returns the limits that apply to a customer, typically from their plan
+type LimitsFunc func(customer *db.Customer) Limits
+
+// FixedLimits applies the same limits to every customer
+func FixedLimits(limits Limits) LimitsFunc {
+	return func(*db.Customer) Limits { return limits }
+}
+
+// Status is a customer's proxied LLM spend in the current calendar month (UTC)
+type Status struct {
+	PeriodStart time.Time          `json:"period_start"`
+	SpendMicros int64              `json:"spend_micros"`
+	CapMicros   int64              `json:"cap_micros"` // 0 means no cap
+	Models      []db.LLMModelUsage `json:"models"`
+}
+
+// Proxy meters and forwards tenant LLM requests
+type Proxy struct {
+	db        *db.DB
+	upstreams map[string]Upstream
+	limits    LimitsFunc
+	prices    Prices
+	transport http.RoundTripper
+	logger    *zap.Logger
+	now       func() time.Time
+
+	mu       sync.Mutex
+	store    limiter.Store
+	limiters map[int64]*limiter.Limiter
+}
+
+// New creates a proxy. Providers without an upstream API key are not served.
+func New(database *db.DB, upstreams map[string]Upstream, limits LimitsFunc, logger *zap.Logger) *Proxy {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	configured := make(map[string]Upstream)
+	for id, upstream := range upstreams {
+		if Proxied(id) && upstream.APIKey != "" {
+			configured[id] = upstream
+		}
+	}
+
+	return &Proxy{
+		db:        database,
+		upstreams: configured,
+		limits:    limits,
+		prices:    DefaultPrices,
+		transport: http.DefaultTransport,
+		logger:    logger,
+		now:       time.Now,
+		store:     memory.NewStore(),
+		limiters:  make(map[int64]*limiter.Limiter),
+	}
+}
+
+// Admit checks that a customer may send a request to a provider's endpoint at path now
+func (p *Proxy) Admit(ctx context.Context, customer *db.Customer, providerID, path string) error {
+	if _, ok := p.upstreams[providerID]; !ok {
+		return ErrUnknownProvider
+	}
+
+	if !slices.Contains(providers[providerID].paths, path) {
+		telemetry.RecordLLMProxyRequest(providerID, "path_not_allowed")
+		return ErrPathNotAllowed
+	}
+
+	switch customer.Status {
+	case "active", "degraded", "provisioning":
+	default:
+		return ErrInactive
+	}
+
+	limits := p.limits(customer)
+	if limits.RequestsPerMinute > 0 {
+		result, err := p.limiter(limits.RequestsPerMinute).Get(ctx, customer.ID)
+		if err != nil {
+			return fmt.Errorf("rate limit: %w", err)
+		}
+		if result.Reached {
+			telemetry.RecordLLMProxyRequest(providerID, "rate_limited")
+			return ErrRateLimited
+		}
+	}
+
+	// The cap is checked before each request, so the request that crosses it
+	// still completes; everything after is refused until the month rolls over
+	if limits.MonthlySpendMicros > 0 {
+		spend, err := p.db.GetLLMSpend(ctx, customer.ID, monthStart(p.now()))
+		if err != nil {
+			return err
+		}
+		if spend >= limits.MonthlySpendMicros {
+			telemetry.RecordLLMProxyRequest(providerID, "spend_capped")
+			return ErrSpendCapReached
+		}
+	}
+
+	return nil
+}
+
+func (p *Proxy) limiter(perMinute int64) *limiter.Limiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.limiters[perMinute]
+	if !ok {
+		l = limiter.New(p.store, limiter.Rate{Period: time.Minute, Limit: perMinute})
+		p.limiters[perMinute] = l
+	}
+	return l
+}
+
+// Status returns the customer's spend against their cap for the current month
+func (p *Proxy) Status(ctx context.Context, customer *db.Customer) (*Status, error) {
+	start := monthStart(p.now())
+
+	spend, err := p.db.GetLLMSpend(ctx, customer.ID, start)
+	if err != nil {
+		return nil, err
+	}
+	models, err := p.db.SummarizeLLMUsage(ctx, customer.ID, start)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Status{
+		PeriodStart: start,
+		SpendMicros: spend,
+		CapMicros:   p.limits(customer).MonthlySpendMicros,
+		Models:      models,
+	}, nil
+}
+
+// Forward sends an admitted request to the provider at path, replacing the
+// tenant's proxy token with the platform key and metering the response
+func (p *Proxy) Forward(w http.ResponseWriter, r *http.Request, customer *db.Customer, providerID, path string) {
+	upstream := p.upstreams[providerID]
+	target, err := url.Parse(upstream.BaseURL)
+	if err != nil {
+		p.logger.Error("Invalid LLM upstream URL", zap.String("provider", providerID), zap.Error(err))
+		writeError(w, http.StatusBadGateway, "upstream_unavailable", "LLM provider is misconfigured")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, "request_too_large", "Request body is too large")
+		return
+	}
+	body, requestModel := prepareBody(providerID, path, body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Path = path
+			pr.Out.URL.RawPath = ""
+			pr.SetURL(target)
+
+			// Only the platform's credential reaches the provider, and the
+			// response must be uncompressed for the meter to read it
+			for _, header := range []string{"Authorization", "X-Api-Key", "Cookie", "Accept-Encoding"} {
+				pr.Out.Header.Del(header)
+			}
+			prov := providers[providerID]
+			pr.Out.Header.Set(prov.authHeader, prov.authPrefix+upstream.APIKey)
+		},
+		Transport: p.transport,
+		ModifyResponse: func(resp *http.Response) error {
+			telemetry.RecordLLMProxyRequest(providerID, "forwarded")
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return nil
+			}
+			resp.Body = newMeter(resp.Body, isEventStream(resp), func(usage tokenUsage) {
+				if usage.Model == "" {
+					usage.Model = requestModel
+				}
+				p.record(customer.ID, providerID, usage)
+			})
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			telemetry.RecordLLMProxyRequest(providerID, "upstream_error")
+			p.logger.Warn("LLM upstream request failed",
+				zap.String("customer_id", customer.ID), zap.String("provider", providerID), zap.Error(err))
+			writeError(w, http.StatusBadGateway, "upstream_unavailable", "LLM provider is unreachable")
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// record stores a response's usage. It runs after the client may have gone, so
+// it does not use the request context.
+func (p *Proxy) record(customerID, providerID string, usage tokenUsage) {
+	model := usage.Model
+	if model == "" {
+		model = "unknown"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := p.db.RecordLLMUsage(ctx, customerID, db.LLMUsage{
+		OccurredAt:   p.now(),
+		Provider:     providerID,
+		Model:        model,
+		InputTokens:  usage.InputTokens,
+		OutputTokens: usage.OutputTokens,
+		CostMicros:   p.prices.Cost(model, usage.InputTokens, usage.OutputTokens),
+	})
+	if err != nil {
+		p.logger.Error("Failed to record LLM usage", zap.String("customer_id", customerID), zap.Error(err))
+		return
+	}
+	telemetry.AddLLMProxyTokens(providerID, usage.InputTokens, usage.OutputTokens)
+}
+
+// prepareBody reads the requested model and, for OpenAI streaming completions,
+// asks for the final usage chunk that streams otherwise omit
+func prepareBody(providerID, path string, body []byte) ([]byte, string) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body, ""
+	}
+
+	var model string
+	json.Unmarshal(fields["model"], &model)
+
+	if providerID != "openai" || !strings.HasSuffix(path, "/completions") || string(fields["stream"]) != "true" {
+		return body, model
+	}
+
+	options := map[string]json.RawMessage{}
+	if raw, ok := fields["stream_options"]; ok {
+		json.Unmarshal(raw, &options)
+	}
+	options["include_usage"] = json.RawMessage("true")
+
+	var err error
+	if fields["stream_options"], err = json.Marshal(options); err != nil {
+		return body, model
+	}
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return body, model
+	}
+	return rewritten, model
+}
+
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+func monthStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// writeError matches the API's error body so proxy failures look like any other
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
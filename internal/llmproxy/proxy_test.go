@@ -0,0 +1,302 @@
+package llmproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+const platformKey = "sk-platform-secret"
+
+// stubUpstream imitates the OpenAI and Anthropic APIs and records what it received
+type stubUpstream struct {
+	server   *httptest.Server
+	requests []*http.Request
+	bodies   []map[string]any
+}
+
+func newStubUpstream(t *testing.T) *stubUpstream {
+	stub := &stubUpstream{}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		stub.requests = append(stub.requests, r)
+		stub.bodies = append(stub.bodies, body)
+
+		stream, _ := body["stream"].(bool)
+		switch {
+		case r.URL.Path == "/v1/chat/completions" && stream:
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: {\"model\":\"gpt-4o-2024-08-06\",\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n")
+			if opts, ok := body["stream_options"].(map[string]any); ok && opts["include_usage"] == true {
+				fmt.Fprint(w, "data: {\"model\":\"gpt-4o-2024-08-06\",\"choices\":[],\"usage\":{\"prompt_tokens\":1000,\"completion_tokens\":200}}\n\n")
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		case r.URL.Path == "/v1/chat/completions":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"model":"gpt-4o-mini","choices":[],"usage":{"prompt_tokens":100,"completion_tokens":50}}`)
+		case r.URL.Path == "/v1/messages":
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"claude-sonnet-4-20250514\",\"usage\":{\"input_tokens\":400,\"output_tokens\":1}}}\n\n")
+			fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"usage\":{\"output_tokens\":300}}\n\n")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":{"message":"bad request"}}`)
+		}
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func setup(t *testing.T, limits Limits) (*Proxy, *db.DB, *db.Customer, *stubUpstream) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	customer, err := database.CreateCustomer(t.Context(), &db.CreateCustomerRequest{
+		Email:         "tenant@example.com",
+		AssistantName: "Test",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateCustomerStatus(t.Context(), customer.ID, "active"))
+	customer, err = database.GetCustomerByID(t.Context(), customer.ID)
+	require.NoError(t, err)
+
+	stub := newStubUpstream(t)
+	proxy := New(database, map[string]Upstream{
+		"openai":    {BaseURL: stub.server.URL, APIKey: platformKey},
+		"anthropic": {BaseURL: stub.server.URL, APIKey: platformKey},
+	}, FixedLimits(limits), nil)
+
+	return proxy, database, customer, stub
+}
+
+func forward(p *Proxy, customer *db.Customer, provider, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/llm/"+provider+path, strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	p.Forward(w, r, customer, provider, path)
+	return w
+}
+
+func llmUsage(t *testing.T, database *db.DB, customerID string) []db.LLMModelUsage {
+	t.Helper()
+	models, err := database.SummarizeLLMUsage(t.Context(), customerID, time.Time{})
+	require.NoError(t, err)
+	return models
+}
+
+func TestForwardReplacesCredentials(t *testing.T) {
+	proxy, database, customer, stub := setup(t, Limits{})
+
+	w := forward(proxy, customer, "openai", "/v1/chat/completions", `{"model":"gpt-4o-mini"}`, map[string]string{
+		"Authorization":   "Bearer blytz-llm-tenant",
+		"Cookie":          "session=abc",
+		"Accept-Encoding": "gzip",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"prompt_tokens":100`)
+
+	require.Len(t, stub.requests, 1)
+	upstream := stub.requests[0]
+	assert.Equal(t, "Bearer "+platformKey, upstream.Header.Get("Authorization"))
+	assert.Empty(t, upstream.Header.Get("Cookie"))
+
+	models := llmUsage(t, database, customer.ID)
+	require.Len(t, models, 1)
+	assert.Equal(t, "gpt-4o-mini", models[0].Model)
+	assert.Equal(t, 100, models[0].InputTokens)
+	assert.Equal(t, 50, models[0].OutputTokens)
+	// 100 * $0.15/M + 50 * $0.60/M = 45 micro-dollars
+	assert.Equal(t, int64(45), models[0].CostMicros)
+}
+
+func TestForwardMetersOpenAIStream(t *testing.T) {
+	proxy, database, customer, stub := setup(t, Limits{})
+
+	w := forward(proxy, customer, "openai", "/v1/chat/completions", `{"model":"gpt-4o","stream":true}`, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "[DONE]")
+
+	options, ok := stub.bodies[0]["stream_options"].(map[string]any)
+	require.True(t, ok, "proxy asks for the usage chunk")
+	assert.Equal(t, true, options["include_usage"])
+
+	models := llmUsage(t, database, customer.ID)
+	require.Len(t, models, 1)
+	assert.Equal(t, "gpt-4o-2024-08-06", models[0].Model)
+	assert.Equal(t, 1000, models[0].InputTokens)
+	assert.Equal(t, 200, models[0].OutputTokens)
+	assert.Equal(t, int64(4500), models[0].CostMicros)
+}
+
+func TestForwardMetersAnthropicStream(t *testing.T) {
+	proxy, database, customer, stub := setup(t, Limits{})
+
+	w := forward(proxy, customer, "anthropic", "/v1/messages", `{"model":"claude-sonnet-4","stream":true}`, map[string]string{
+		"X-Api-Key":         "blytz-llm-tenant",
+		"Anthropic-Version": "2023-06-01",
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+
+	upstream := stub.requests[0]
+	assert.Equal(t, platformKey, upstream.Header.Get("X-Api-Key"))
+	assert.Equal(t, "2023-06-01", upstream.Header.Get("Anthropic-Version"))
+	assert.Nil(t, stub.bodies[0]["stream_options"], "anthropic bodies are passed through")
+
+	models := llmUsage(t, database, customer.ID)
+	require.Len(t, models, 1)
+	assert.Equal(t, "anthropic", models[0].Provider)
+	assert.Equal(t, 400, models[0].InputTokens)
+	assert.Equal(t, 300, models[0].OutputTokens)
+}
+
+func TestForwardDoesNotMeterErrors(t *testing.T) {
+	proxy, database, customer, _ := setup(t, Limits{})
+
+	w := forward(proxy, customer, "openai", "/v1/unknown", `{}`, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, llmUsage(t, database, customer.ID))
+}
+
+func TestForwardUpstreamUnavailable(t *testing.T) {
+	proxy, _, customer, stub := setup(t, Limits{})
+	stub.server.Close()
+
+	w := forward(proxy, customer, "openai", "/v1/chat/completions", `{}`, nil)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "upstream_unavailable")
+}
+
+func TestAdmitSpendCap(t *testing.T) {
+	proxy, database, customer, _ := setup(t, Limits{MonthlySpendMicros: 1_000_000})
+	ctx := t.Context()
+	now := time.Date(2026, 5, 20, 12, 0, 0, 0, time.UTC)
+	proxy.now = func() time.Time { return now }
+
+	// Last month's spend does not count against this month's cap
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, db.LLMUsage{
+		OccurredAt: time.Date(2026, 4, 30, 23, 0, 0, 0, time.UTC), Provider: "openai", Model: "gpt-4o", CostMicros: 5_000_000,
+	}))
+	require.NoError(t, proxy.Admit(ctx, customer, "openai", "/v1/chat/completions"))
+
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, db.LLMUsage{
+		OccurredAt: now, Provider: "openai", Model: "gpt-4o", CostMicros: 1_000_000,
+	}))
+	assert.ErrorIs(t, proxy.Admit(ctx, customer, "openai", "/v1/chat/completions"), ErrSpendCapReached)
+
+	status, err := proxy.Status(ctx, customer)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC), status.PeriodStart)
+	assert.Equal(t, int64(1_000_000), status.SpendMicros)
+	assert.Equal(t, int64(1_000_000), status.CapMicros)
+	require.Len(t, status.Models, 1)
+}
+
+func TestAdmitRateLimit(t *testing.T) {
+	proxy, database, customer, _ := setup(t, Limits{RequestsPerMinute: 2})
+	ctx := t.Context()
+
+	require.NoError(t, proxy.Admit(ctx, customer, "openai", "/v1/chat/completions"))
+	require.NoError(t, proxy.Admit(ctx, customer, "anthropic", "/v1/messages"))
+	assert.ErrorIs(t, proxy.Admit(ctx, customer, "openai", "/v1/chat/completions"), ErrRateLimited)
+
+	// Limits are per tenant
+	other, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{Email: "other@example.com"})
+	require.NoError(t, err)
+	other.Status = "active"
+	assert.NoError(t, proxy.Admit(ctx, other, "openai", "/v1/chat/completions"))
+}
+
+func TestAdmitRejects(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	// Providers without a platform key are not served
+	proxy := New(database, map[string]Upstream{
+		"openai":    {BaseURL: "http://unused", APIKey: platformKey},
+		"anthropic": {BaseURL: "http://unused"},
+		"groq":      {BaseURL: "http://unused", APIKey: "gsk"},
+	}, FixedLimits(Limits{}), nil)
+
+	active := &db.Customer{ID: "c1", Status: "active"}
+	assert.NoError(t, proxy.Admit(t.Context(), active, "openai", "/v1/chat/completions"))
+	assert.ErrorIs(t, proxy.Admit(t.Context(), active, "anthropic", "/v1/messages"), ErrUnknownProvider)
+	assert.ErrorIs(t, proxy.Admit(t.Context(), active, "groq", "/v1/chat/completions"), ErrUnknownProvider)
+
+	// Only metered endpoints may spend the platform key
+	assert.NoError(t, proxy.Admit(t.Context(), active, "openai", "/v1/embeddings"))
+	for _, path := range []string{"/v1/files", "/v1/images/generations", "/v1/audio/speech", "/v1/fine_tuning/jobs", "/v1/chat/completions/../../files", "/v1/messages"} {
+		assert.ErrorIs(t, proxy.Admit(t.Context(), active, "openai", path), ErrPathNotAllowed, path)
+	}
+
+	for _, status := range []string{"pending", "suspended", "cancelled"} {
+		customer := &db.Customer{ID: "c2", Status: status}
+		assert.ErrorIs(t, proxy.Admit(t.Context(), customer, "openai", "/v1/chat/completions"), ErrInactive, status)
+	}
+}
+
+func TestTenantEnv(t *testing.T) {
+	env := TenantEnv("openai", "OPENAI_API_KEY", "http://host.docker.internal:8080/", "tok")
+	assert.Equal(t, map[string]string{
+		"OPENAI_API_KEY":  "tok",
+		"OPENAI_BASE_URL": "http://host.docker.internal:8080/llm/openai/v1",
+	}, env)
+
+	env = TenantEnv("anthropic", "ANTHROPIC_API_KEY", "http://proxy", "tok")
+	assert.Equal(t, "http://proxy/llm/anthropic", env["ANTHROPIC_BASE_URL"])
+
+	assert.True(t, Proxied("openai"))
+	assert.False(t, Proxied("ollama"))
+}
+
+func TestPricesCost(t *testing.T) {
+	tests := []struct {
+		model         string
+		input, output int
+		want          int64
+	}{
+		{"gpt-4o", 1_000_000, 0, 2_500_000},
+		{"gpt-4o-mini-2024-07-18", 1_000_000, 1_000_000, 750_000},
+		{"claude-3-5-haiku-20241022", 10, 10, 48},
+		{"some-new-model", 1, 1, 90},
+		{"gpt-4o", 1, 0, 3}, // rounded up
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, DefaultPrices.Cost(tt.model, tt.input, tt.output), tt.model)
+	}
+}
+
+func TestMeterSplitEvents(t *testing.T) {
+	var got tokenUsage
+	body := "data: {\"message\":{\"model\":\"claude-3-opus\",\"usage\":{\"input_tokens\":7,\"cache_read_input_tokens\":3}}}\n\ndata: {\"usage\":{\"output_tokens\":9}}"
+	m := newMeter(io.NopCloser(strings.NewReader(body)), true, func(u tokenUsage) { got = u })
+
+	// Read in tiny chunks so events straddle reads
+	buf := make([]byte, 5)
+	for {
+		if _, err := m.Read(buf); err != nil {
+			break
+		}
+	}
+	require.NoError(t, m.Close())
+
+	assert.Equal(t, tokenUsage{Model: "claude-3-opus", InputTokens: 10, OutputTokens: 9}, got)
+}
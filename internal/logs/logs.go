@@ -0,0 +1,170 @@
+// Package logs reads tenant container logs from the runtime with platform secrets redacted
+package logs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"blytz/internal/db"
+	"blytz/internal/metrics"
+)
+
+// maxLineBytes bounds a single log line; longer lines end the stream with an error
+const maxLineBytes = 1 << 20
+
+// Options selects which log lines to read
+type Options struct {
+	// Tail is the number of lines to return from the end of the log; negative means all
+	Tail int
+	// Since is passed to the runtime as-is: a duration such as "15m" or an RFC 3339 timestamp
+	Since string
+	// Follow keeps the stream open for new lines until the context is cancelled
+	Follow bool
+}
+
+// Source reads a container's combined stdout and stderr from the runtime
+type Source interface {
+	Logs(ctx context.Context, container string, opts Options) (io.ReadCloser, error)
+}
+
+// NodeSource reads the logs of tenants placed on a worker node through its agent
+type NodeSource interface {
+	TenantLogs(ctx context.Context, customerID string, opts Options) (io.ReadCloser, error)
+}
+
+// NodeDialer returns the log source of a worker node
+type NodeDialer func(node *db.Node) NodeSource
+
+// ErrNodeLogsUnavailable is returned for tenants on a worker node when node
+// agents are not configured
+var ErrNodeLogsUnavailable = errors.New("logs unavailable for node tenants")
+
+// DockerSource reads logs through the docker CLI
+type DockerSource struct{}
+
+// NewDockerSource creates a log source backed by `docker logs`
+func NewDockerSource() *DockerSource {
+	return &DockerSource{}
+}
+
+func (d *DockerSource) Logs(ctx context.Context, container string, opts Options) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, "docker", dockerLogsArgs(container, opts)...)
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		pw.Close()
+		return nil, fmt.Errorf("docker logs: %w", err)
+	}
+
+	go func() {
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			pw.CloseWithError(fmt.Errorf("docker logs: %w", err))
+			return
+		}
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+func dockerLogsArgs(container string, opts Options) []string {
+	tail := "all"
+	if opts.Tail >= 0 {
+		tail = strconv.Itoa(opts.Tail)
+	}
+
+	args := []string{"logs", "--timestamps", "--tail", tail}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	return append(args, container)
+}
+
+// Service streams redacted logs for a tenant's container
+type Service struct {
+	source       Source
+	customersDir string
+	db           *db.DB
+	dialNode     NodeDialer
+}
+
+// NewService creates a log service. Secrets are read from each customer's
+// .env.secret under customersDir.
+func NewService(source Source, customersDir string) *Service {
+	return &Service{
+		source:       source,
+		customersDir: customersDir,
+	}
+}
+
+// UseNodes reads the logs of tenants placed on worker nodes through dial; the
+// rest keep coming from the local source. A nil dial fails node tenants with
+// ErrNodeLogsUnavailable.
+func (s *Service) UseNodes(database *db.DB, dial NodeDialer) {
+	s.db = database
+	s.dialNode = dial
+}
+
+// Stream calls fn with each log line of the customer's container, secrets redacted.
+// It returns when the log ends, fn returns an error or ctx is cancelled.
+func (s *Service) Stream(ctx context.Context, customerID string, opts Options, fn func(line string) error) error {
+	redactor, err := LoadRedactor(filepath.Join(s.customersDir, customerID, ".env.secret"))
+	if err != nil {
+		return err
+	}
+
+	rc, err := s.open(ctx, customerID, opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for scanner.Scan() {
+		if err := fn(redactor.Redact(scanner.Text())); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return fmt.Errorf("read logs: %w", err)
+	}
+	return ctx.Err()
+}
+
+// open reads the customer's log from wherever their container runs
+func (s *Service) open(ctx context.Context, customerID string, opts Options) (io.ReadCloser, error) {
+	if s.db == nil {
+		return s.source.Logs(ctx, metrics.ContainerName(customerID), opts)
+	}
+
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if customer.NodeID == nil {
+		return s.source.Logs(ctx, metrics.ContainerName(customerID), opts)
+	}
+	if s.dialNode == nil {
+		return nil, ErrNodeLogsUnavailable
+	}
+
+	node, err := s.db.GetNode(ctx, *customer.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get node: %w", err)
+	}
+	return s.dialNode(node).TenantLogs(ctx, customerID, opts)
+}
@@ -0,0 +1,192 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+type fakeSource struct {
+	output    string
+	err       error
+	container string
+	opts      Options
+}
+
+func (f *fakeSource) Logs(ctx context.Context, container string, opts Options) (io.ReadCloser, error) {
+	f.container = container
+	f.opts = opts
+	if f.err != nil {
+		return nil, f.err
+	}
+	return io.NopCloser(strings.NewReader(f.output)), nil
+}
+
+type fakeNodeSource struct {
+	node       string
+	customerID string
+	output     string
+}
+
+func (f *fakeNodeSource) TenantLogs(ctx context.Context, customerID string, opts Options) (io.ReadCloser, error) {
+	f.customerID = customerID
+	return io.NopCloser(strings.NewReader(f.output)), nil
+}
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor([]string{"sk-abcdef", "sk-abcdef123456", "short", "", "sk-abcdef"})
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"no secrets here", "no secrets here"},
+		{"key=sk-abcdef123456 used", "key=[REDACTED] used"},
+		{"key=sk-abcdef used", "key=[REDACTED] used"},
+		{"sk-abcdef and sk-abcdef123456", "[REDACTED] and [REDACTED]"},
+		{"short values are left alone", "short values are left alone"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, r.Redact(tt.line))
+	}
+
+	assert.Equal(t, "unchanged", NewRedactor(nil).Redact("unchanged"))
+}
+
+func TestLoadRedactor(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".env.secret")
+	content := "# comment\nOPENAI_API_KEY=sk-live-secret\nMYRAI_GATEWAY_TOKEN=\"quoted-token\"\nnot a pair\nEMPTY=\n"
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	r, err := LoadRedactor(path)
+	require.NoError(t, err)
+	assert.Equal(t, "auth [REDACTED] / [REDACTED]", r.Redact("auth sk-live-secret / quoted-token"))
+
+	r, err = LoadRedactor(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Equal(t, "sk-live-secret", r.Redact("sk-live-secret"))
+}
+
+func TestDockerLogsArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{"tail only", Options{Tail: 100}, []string{"logs", "--timestamps", "--tail", "100", "blytz-x"}},
+		{"all lines", Options{Tail: -1}, []string{"logs", "--timestamps", "--tail", "all", "blytz-x"}},
+		{"since and follow", Options{Tail: 0, Since: "15m", Follow: true},
+			[]string{"logs", "--timestamps", "--tail", "0", "--since", "15m", "--follow", "blytz-x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, dockerLogsArgs("blytz-x", tt.opts))
+		})
+	}
+}
+
+func TestServiceStream(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cust"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cust", ".env.secret"), []byte("OPENAI_API_KEY=sk-live-secret\n"), 0600))
+
+	source := &fakeSource{output: "starting\nusing key sk-live-secret\nready\n"}
+	svc := NewService(source, dir)
+
+	var lines []string
+	opts := Options{Tail: 10, Since: "1h"}
+	err := svc.Stream(t.Context(), "cust", opts, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"starting", "using key [REDACTED]", "ready"}, lines)
+	assert.Equal(t, "blytz-cust", source.container)
+	assert.Equal(t, opts, source.opts)
+}
+
+func TestServiceStreamErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	svc := NewService(&fakeSource{err: errors.New("no such container")}, dir)
+	err := svc.Stream(t.Context(), "cust", Options{}, func(string) error { return nil })
+	assert.ErrorContains(t, err, "no such container")
+
+	// An error from the callback stops the stream
+	stop := errors.New("client went away")
+	calls := 0
+	svc = NewService(&fakeSource{output: "a\nb\nc\n"}, dir)
+	err = svc.Stream(t.Context(), "cust", Options{}, func(string) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestServiceStreamNodeTenant(t *testing.T) {
+	ctx := t.Context()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	local, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{Email: "local@example.com", AssistantName: "Local", TelegramBotToken: "1:a"})
+	require.NoError(t, err)
+	remote, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{Email: "remote@example.com", AssistantName: "Remote", TelegramBotToken: "2:b"})
+	require.NoError(t, err)
+	node := &db.Node{Name: "worker-1", Address: "10.0.0.2", AgentURL: "http://10.0.0.2:9100",
+		MemoryMB: 4096, CPUs: 2, PortStart: 30000, PortEnd: 30100}
+	require.NoError(t, database.CreateNode(ctx, node))
+	require.NoError(t, database.AssignNode(ctx, remote.ID, node.ID, 30000))
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, remote.ID), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, remote.ID, ".env.secret"), []byte("OPENAI_API_KEY=sk-node-secret\n"), 0600))
+
+	source := &fakeSource{output: "on the control plane\n"}
+	nodeSource := &fakeNodeSource{output: "on the node with sk-node-secret\n"}
+	svc := NewService(source, dir)
+	svc.UseNodes(database, func(n *db.Node) NodeSource {
+		nodeSource.node = n.ID
+		return nodeSource
+	})
+
+	collect := func(customerID string) ([]string, error) {
+		var lines []string
+		err := svc.Stream(ctx, customerID, Options{}, func(line string) error {
+			lines = append(lines, line)
+			return nil
+		})
+		return lines, err
+	}
+
+	lines, err := collect(remote.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"on the node with [REDACTED]"}, lines)
+	assert.Equal(t, node.ID, nodeSource.node)
+	assert.Equal(t, remote.ID, nodeSource.customerID)
+
+	lines, err = collect(local.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"on the control plane"}, lines)
+	assert.Equal(t, "blytz-"+local.ID, source.container)
+
+	// Without node agents the tenant's logs cannot be reached
+	svc.UseNodes(database, nil)
+	_, err = collect(remote.ID)
+	assert.ErrorIs(t, err, ErrNodeLogsUnavailable)
+}
@@ -0,0 +1,78 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces every secret value found in a log line
+const Redacted = "[REDACTED]"
+
+// minSecretLength skips values too short to be credentials, so redacting
+// something like "1" does not mangle every line.
+const minSecretLength = 6
+
+// Redactor masks known secret values in log output
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// NewRedactor creates a redactor for the given secret values
+func NewRedactor(secrets []string) *Redactor {
+	var values []string
+	seen := make(map[string]bool)
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength || seen[secret] {
+			continue
+		}
+		seen[secret] = true
+		values = append(values, secret)
+	}
+
+	// Replacer prefers earlier arguments at the same position, so longer
+	// secrets go first and are never half-masked by a shorter prefix
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	pairs := make([]string, 0, len(values)*2)
+	for _, value := range values {
+		pairs = append(pairs, value, Redacted)
+	}
+	return &Redactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// LoadRedactor reads KEY=VALUE pairs from an env file and redacts every value.
+// A missing file yields a redactor with nothing to mask.
+func LoadRedactor(envPath string) (*Redactor, error) {
+	data, err := os.ReadFile(envPath)
+	if os.IsNotExist(err) {
+		return NewRedactor(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read secrets: %w", err)
+	}
+
+	var secrets []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		_, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		secrets = append(secrets, strings.Trim(value, `"'`))
+	}
+
+	return NewRedactor(secrets), scanner.Err()
+}
+
+// Redact returns line with every secret replaced
+func (r *Redactor) Redact(line string) string {
+	return r.replacer.Replace(line)
+}
@@ -0,0 +1,205 @@
+// Package metrics samples tenant container and host resource usage
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+// containerStatuses are the customer statuses that may have a container running
+var containerStatuses = []string{"provisioning", "active", "degraded", "suspended"}
+
+// Sample is one collection pass over the host and every tenant container
+type Sample struct {
+	Timestamp  time.Time                 `json:"timestamp"`
+	Host       HostStats                 `json:"host"`
+	Containers map[string]ContainerStats `json:"containers"` // keyed by customer ID
+}
+
+// Totals sums container usage across a sample
+type Totals struct {
+	Containers       int     `json:"containers"`
+	CPUPercent       float64 `json:"cpu_percent"`
+	MemoryUsageBytes uint64  `json:"memory_usage_bytes"`
+	NetRxBytes       uint64  `json:"net_rx_bytes"`
+	NetTxBytes       uint64  `json:"net_tx_bytes"`
+	Restarts         int     `json:"restarts"`
+}
+
+// Collector periodically samples resource usage and keeps a rolling window in memory
+type Collector struct {
+	db      *db.DB
+	source  ContainerSource
+	procDir string
+	window  int
+	logger  *zap.Logger
+
+	mu      sync.RWMutex
+	samples []Sample // oldest first, at most window entries
+}
+
+// NewCollector creates a collector that keeps the last window samples.
+// procDir is normally "/proc".
+func NewCollector(database *db.DB, source ContainerSource, procDir string, window int, logger *zap.Logger) *Collector {
+	if window < 1 {
+		window = 1
+	}
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Collector{
+		db:      database,
+		source:  source,
+		procDir: procDir,
+		window:  window,
+		logger:  logger,
+	}
+}
+
+// Collect takes one sample and appends it to the window. A sample is recorded
+// even when part of the collection fails, so host numbers stay available while
+// the container runtime is down; the first error is returned.
+func (c *Collector) Collect(ctx context.Context) (*Sample, error) {
+	var firstErr error
+	sample := Sample{
+		Timestamp:  time.Now(),
+		Containers: make(map[string]ContainerStats),
+	}
+
+	host, err := readHostStats(c.procDir)
+	if err != nil {
+		firstErr = fmt.Errorf("host stats: %w", err)
+	} else {
+		if prev := c.Latest(); prev != nil {
+			host.CPUPercent = cpuPercentBetween(prev.Host, host)
+		}
+		sample.Host = host
+	}
+
+	if err := c.collectContainers(ctx, sample.Containers); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	c.mu.Lock()
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > c.window {
+		c.samples = c.samples[len(c.samples)-c.window:]
+	}
+	c.mu.Unlock()
+
+	return &sample, firstErr
+}
+
+func (c *Collector) collectContainers(ctx context.Context, into map[string]ContainerStats) error {
+	ids, err := c.db.ListCustomerIDsByStatus(ctx, containerStatuses...)
+	if err != nil {
+		return fmt.Errorf("list customers: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(ids))
+	byName := make(map[string]string, len(ids))
+	for i, id := range ids {
+		names[i] = ContainerName(id)
+		byName[names[i]] = id
+	}
+
+	stats, err := c.source.ContainerStats(ctx, names)
+	if err != nil {
+		return fmt.Errorf("container stats: %w", err)
+	}
+
+	for name, s := range stats {
+		id, ok := byName[name]
+		if !ok {
+			continue
+		}
+		s.CustomerID = id
+		into[id] = s
+	}
+
+	return nil
+}
+
+// Run collects a sample every interval until ctx is cancelled
+func (c *Collector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.Collect(ctx); err != nil {
+			c.logger.Warn("Metrics collection incomplete", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Latest returns the most recent sample, or nil before the first collection
+func (c *Collector) Latest() *Sample {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.samples) == 0 {
+		return nil
+	}
+	latest := c.samples[len(c.samples)-1]
+	return &latest
+}
+
+// CustomerHistory returns the customer's container samples in the window, oldest first
+func (c *Collector) CustomerHistory(customerID string) []ContainerStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	history := []ContainerStats{}
+	for _, sample := range c.samples {
+		if s, ok := sample.Containers[customerID]; ok {
+			history = append(history, s)
+		}
+	}
+	return history
+}
+
+// HostHistory returns the host samples in the window, oldest first
+func (c *Collector) HostHistory() []HostStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	history := make([]HostStats, 0, len(c.samples))
+	for _, sample := range c.samples {
+		history = append(history, sample.Host)
+	}
+	return history
+}
+
+// Totals sums container usage across the sample
+func (s *Sample) Totals() Totals {
+	var t Totals
+	for _, c := range s.Containers {
+		t.Containers++
+		t.CPUPercent += c.CPUPercent
+		t.MemoryUsageBytes += c.MemoryUsageBytes
+		t.NetRxBytes += c.NetRxBytes
+		t.NetTxBytes += c.NetTxBytes
+		t.Restarts += c.RestartCount
+	}
+	return t
+}
+
+// ContainerName returns the docker container name used for a customer
+func ContainerName(customerID string) string {
+	return "blytz-" + customerID
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+type fakeSource struct {
+	stats map[string]ContainerStats
+	err   error
+}
+
+func (f *fakeSource) ContainerStats(ctx context.Context, names []string) (map[string]ContainerStats, error) {
+	return f.stats, f.err
+}
+
+// writeProc creates a fake procfs with the given cumulative cpu counters
+func writeProc(t *testing.T, dir string, user, idle int) {
+	t.Helper()
+	meminfo := "MemTotal:        2048000 kB\nMemFree:          100000 kB\nMemAvailable:    1024000 kB\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "meminfo"), []byte(meminfo), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "loadavg"), []byte("0.50 0.25 0.10 1/100 1234\n"), 0644))
+	stat := fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 1 0 0 1 0 0 0 0 0 0\n", user, idle)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644))
+}
+
+func setupCollector(t *testing.T, source ContainerSource, window int) (*Collector, *db.DB, string) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	procDir := t.TempDir()
+	writeProc(t, procDir, 100, 900)
+	return NewCollector(database, source, procDir, window, nil), database, procDir
+}
+
+func createActiveCustomer(t *testing.T, database *db.DB, email string) string {
+	t.Helper()
+	ctx := context.Background()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+	return customer.ID
+}
+
+func TestCollectorCollect(t *testing.T) {
+	source := &fakeSource{}
+	collector, database, procDir := setupCollector(t, source, 10)
+	alice := createActiveCustomer(t, database, "alice@example.com")
+	bob := createActiveCustomer(t, database, "bob@example.com")
+
+	source.stats = map[string]ContainerStats{
+		ContainerName(alice): {CPUPercent: 2, MemoryUsageBytes: 100, NetRxBytes: 10, RestartCount: 1},
+		ContainerName(bob):   {CPUPercent: 3, MemoryUsageBytes: 200, NetTxBytes: 20},
+	}
+
+	sample, err := collector.Collect(t.Context())
+	require.NoError(t, err)
+	require.Len(t, sample.Containers, 2)
+	assert.Equal(t, alice, sample.Containers[alice].CustomerID)
+	assert.Equal(t, uint64(2048000*1024), sample.Host.MemoryTotalBytes)
+	assert.Equal(t, uint64(1024000*1024), sample.Host.MemoryUsedBytes)
+	assert.Equal(t, [3]float64{0.5, 0.25, 0.1}, sample.Host.LoadAverage)
+	assert.Zero(t, sample.Host.CPUPercent, "first sample has no CPU baseline")
+
+	totals := sample.Totals()
+	assert.Equal(t, 2, totals.Containers)
+	assert.InDelta(t, 5.0, totals.CPUPercent, 0.001)
+	assert.Equal(t, uint64(300), totals.MemoryUsageBytes)
+	assert.Equal(t, 1, totals.Restarts)
+
+	// 100 more busy jiffies and 100 more idle ones -> 50% utilisation
+	writeProc(t, procDir, 200, 1000)
+	sample, err = collector.Collect(t.Context())
+	require.NoError(t, err)
+	assert.InDelta(t, 50.0, sample.Host.CPUPercent, 0.001)
+
+	assert.Len(t, collector.CustomerHistory(alice), 2)
+	assert.Len(t, collector.HostHistory(), 2)
+	assert.Empty(t, collector.CustomerHistory("nobody"))
+}
+
+func TestCollectorRollingWindow(t *testing.T) {
+	collector, _, _ := setupCollector(t, &fakeSource{}, 3)
+
+	for i := 0; i < 5; i++ {
+		_, err := collector.Collect(t.Context())
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, collector.HostHistory(), 3)
+}
+
+func TestCollectorKeepsHostStatsWhenRuntimeFails(t *testing.T) {
+	source := &fakeSource{err: errors.New("docker unavailable")}
+	collector, database, _ := setupCollector(t, source, 10)
+	createActiveCustomer(t, database, "alice@example.com")
+
+	sample, err := collector.Collect(t.Context())
+	assert.Error(t, err)
+	require.NotNil(t, sample)
+	assert.NotZero(t, sample.Host.MemoryTotalBytes)
+	require.NotNil(t, collector.Latest())
+}
+
+func TestCollectorLatestBeforeCollect(t *testing.T) {
+	collector, _, _ := setupCollector(t, &fakeSource{}, 10)
+	assert.Nil(t, collector.Latest())
+}
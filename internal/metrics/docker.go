@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ContainerStats is a point-in-time resource sample for one tenant container
+type ContainerStats struct {
+	CustomerID       string    `json:"customer_id"`
+	ContainerName    string    `json:"container_name"`
+	CPUPercent       float64   `json:"cpu_percent"`
+	MemoryUsageBytes uint64    `json:"memory_usage_bytes"`
+	MemoryLimitBytes uint64    `json:"memory_limit_bytes"`
+	NetRxBytes       uint64    `json:"net_rx_bytes"`
+	NetTxBytes       uint64    `json:"net_tx_bytes"`
+	RestartCount     int       `json:"restart_count"`
+	SampledAt        time.Time `json:"sampled_at"`
+}
+
+// ContainerSource reads resource usage from the container runtime
+type ContainerSource interface {
+	// ContainerStats returns stats keyed by container name for the named containers
+	// that exist. Missing containers are omitted rather than reported as errors.
+	ContainerStats(ctx context.Context, names []string) (map[string]ContainerStats, error)
+}
+
+type runFunc func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// DockerSource samples container stats through the docker CLI
+type DockerSource struct {
+	run runFunc
+}
+
+// NewDockerSource creates a container source backed by `docker stats` and `docker inspect`
+func NewDockerSource() *DockerSource {
+	return &DockerSource{run: runCommand}
+}
+
+type dockerStatsLine struct {
+	Name     string `json:"Name"`
+	CPUPerc  string `json:"CPUPerc"`
+	MemUsage string `json:"MemUsage"`
+	NetIO    string `json:"NetIO"`
+}
+
+func (d *DockerSource) ContainerStats(ctx context.Context, names []string) (map[string]ContainerStats, error) {
+	stats := make(map[string]ContainerStats)
+	if len(names) == 0 {
+		return stats, nil
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	output, err := d.run(ctx, "docker", "stats", "--no-stream", "--all", "--format", "{{json .}}")
+	if err != nil {
+		return nil, fmt.Errorf("docker stats: %w", err)
+	}
+
+	now := time.Now()
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var raw dockerStatsLine
+		if err := json.Unmarshal([]byte(line), &raw); err != nil {
+			return nil, fmt.Errorf("parse docker stats: %w", err)
+		}
+		if !wanted[raw.Name] {
+			continue
+		}
+
+		s, err := parseStatsLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("parse stats for %s: %w", raw.Name, err)
+		}
+		s.SampledAt = now
+		stats[raw.Name] = s
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read docker stats: %w", err)
+	}
+
+	if err := d.addRestartCounts(ctx, stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func (d *DockerSource) addRestartCounts(ctx context.Context, stats map[string]ContainerStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	args := []string{"inspect", "--format", "{{.Name}} {{.RestartCount}}"}
+	for name := range stats {
+		args = append(args, name)
+	}
+
+	output, err := d.run(ctx, "docker", args...)
+	if err != nil {
+		return fmt.Errorf("docker inspect: %w", err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(fields[0], "/")
+		count, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		if s, ok := stats[name]; ok {
+			s.RestartCount = count
+			stats[name] = s
+		}
+	}
+
+	return nil
+}
+
+func parseStatsLine(raw dockerStatsLine) (ContainerStats, error) {
+	s := ContainerStats{ContainerName: raw.Name}
+
+	cpu, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(raw.CPUPerc), "%"), 64)
+	if err != nil {
+		return s, fmt.Errorf("cpu %q: %w", raw.CPUPerc, err)
+	}
+	s.CPUPercent = cpu
+
+	if s.MemoryUsageBytes, s.MemoryLimitBytes, err = parsePair(raw.MemUsage); err != nil {
+		return s, fmt.Errorf("memory %q: %w", raw.MemUsage, err)
+	}
+	if s.NetRxBytes, s.NetTxBytes, err = parsePair(raw.NetIO); err != nil {
+		return s, fmt.Errorf("network %q: %w", raw.NetIO, err)
+	}
+
+	return s, nil
+}
+
+// parsePair parses docker's "used / limit" style values
+func parsePair(value string) (uint64, uint64, error) {
+	left, right, ok := strings.Cut(value, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected two values")
+	}
+	a, err := parseSize(left)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := parseSize(right)
+	if err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	// Longest suffixes first so "KiB" is not read as "B"
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize converts human-readable sizes such as "12.5MiB" or "3.4kB" to bytes
+func parseSize(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	for _, unit := range sizeUnits {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", value)
+			}
+			return uint64(n * unit.multiplier), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown size unit in %q", value)
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected uint64
+		wantErr  bool
+	}{
+		{"0B", 0, false},
+		{"512B", 512, false},
+		{"1.5KiB", 1536, false},
+		{"12MiB", 12 << 20, false},
+		{"2GiB", 2 << 30, false},
+		{"3.4kB", 3400, false},
+		{"1.2MB", 1200000, false},
+		{" 7GB ", 7000000000, false},
+		{"12 parsecs", 0, true},
+		{"abcMiB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestDockerSourceContainerStats(t *testing.T) {
+	statsOutput := strings.Join([]string{
+		`{"Name":"blytz-alice","CPUPerc":"1.50%","MemUsage":"100MiB / 512MiB","NetIO":"1.2kB / 3.4kB"}`,
+		`{"Name":"blytz-bob","CPUPerc":"0.00%","MemUsage":"0B / 0B","NetIO":"0B / 0B"}`,
+		`{"Name":"unrelated","CPUPerc":"90.00%","MemUsage":"1GiB / 2GiB","NetIO":"0B / 0B"}`,
+	}, "\n")
+
+	var inspectArgs []string
+	source := &DockerSource{run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		switch args[0] {
+		case "stats":
+			return []byte(statsOutput), nil
+		case "inspect":
+			inspectArgs = args
+			return []byte("/blytz-alice 3\n/blytz-bob 0\n"), nil
+		}
+		return nil, errors.New("unexpected command")
+	}}
+
+	stats, err := source.ContainerStats(t.Context(), []string{"blytz-alice", "blytz-bob", "blytz-missing"})
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+
+	alice := stats["blytz-alice"]
+	assert.InDelta(t, 1.5, alice.CPUPercent, 0.001)
+	assert.Equal(t, uint64(100<<20), alice.MemoryUsageBytes)
+	assert.Equal(t, uint64(512<<20), alice.MemoryLimitBytes)
+	assert.Equal(t, uint64(1200), alice.NetRxBytes)
+	assert.Equal(t, uint64(3400), alice.NetTxBytes)
+	assert.Equal(t, 3, alice.RestartCount)
+	assert.False(t, alice.SampledAt.IsZero())
+
+	assert.NotContains(t, inspectArgs, "unrelated")
+	assert.NotContains(t, stats, "unrelated")
+}
+
+func TestDockerSourceNoContainers(t *testing.T) {
+	source := &DockerSource{run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		t.Fatal("docker should not be called without containers")
+		return nil, nil
+	}}
+
+	stats, err := source.ContainerStats(t.Context(), nil)
+	require.NoError(t, err)
+	assert.Empty(t, stats)
+}
+
+func TestDockerSourceRuntimeError(t *testing.T) {
+	source := &DockerSource{run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return nil, errors.New("cannot connect to the Docker daemon")
+	}}
+
+	_, err := source.ContainerStats(t.Context(), []string{"blytz-alice"})
+	assert.Error(t, err)
+}
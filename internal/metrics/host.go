@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HostStats is a point-in-time resource sample for the host machine
+type HostStats struct {
+	CPUCores             int        `json:"cpu_cores"`
+	CPUPercent           float64    `json:"cpu_percent"`
+	LoadAverage          [3]float64 `json:"load_average"`
+	MemoryTotalBytes     uint64     `json:"memory_total_bytes"`
+	MemoryAvailableBytes uint64     `json:"memory_available_bytes"`
+	MemoryUsedBytes      uint64     `json:"memory_used_bytes"`
+	SampledAt            time.Time  `json:"sampled_at"`
+	cpuTotal, cpuIdle    uint64
+}
+
+// readHostStats reads memory, load and cumulative CPU counters from a procfs mount.
+// CPUPercent is left at zero; it needs two samples and is filled in by the collector.
+func readHostStats(procDir string) (HostStats, error) {
+	stats := HostStats{CPUCores: runtime.NumCPU(), SampledAt: time.Now()}
+
+	meminfo, err := readKeyValues(filepath.Join(procDir, "meminfo"))
+	if err != nil {
+		return stats, fmt.Errorf("read meminfo: %w", err)
+	}
+	stats.MemoryTotalBytes = meminfo["MemTotal"] * 1024
+	stats.MemoryAvailableBytes = meminfo["MemAvailable"] * 1024
+	if stats.MemoryTotalBytes > stats.MemoryAvailableBytes {
+		stats.MemoryUsedBytes = stats.MemoryTotalBytes - stats.MemoryAvailableBytes
+	}
+
+	loadavg, err := os.ReadFile(filepath.Join(procDir, "loadavg"))
+	if err != nil {
+		return stats, fmt.Errorf("read loadavg: %w", err)
+	}
+	fields := strings.Fields(string(loadavg))
+	for i := 0; i < 3 && i < len(fields); i++ {
+		stats.LoadAverage[i], _ = strconv.ParseFloat(fields[i], 64)
+	}
+
+	if stats.cpuTotal, stats.cpuIdle, err = readCPUTimes(filepath.Join(procDir, "stat")); err != nil {
+		return stats, fmt.Errorf("read stat: %w", err)
+	}
+
+	return stats, nil
+}
+
+// readKeyValues parses "Key:   value kB" lines such as /proc/meminfo
+func readKeyValues(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			values[key] = v
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// readCPUTimes returns the total and idle (idle + iowait) jiffies from the aggregate cpu line
+func readCPUTimes(path string) (total, idle uint64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("parse cpu field %q: %w", field, err)
+			}
+			total += v
+			if i == 3 || i == 4 {
+				idle += v
+			}
+		}
+		return total, idle, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	return 0, 0, fmt.Errorf("no cpu line found")
+}
+
+// cpuPercentBetween computes host CPU utilisation between two samples
+func cpuPercentBetween(prev, cur HostStats) float64 {
+	if cur.cpuTotal <= prev.cpuTotal || cur.cpuIdle < prev.cpuIdle {
+		return 0
+	}
+	totalDelta := float64(cur.cpuTotal - prev.cpuTotal)
+	idleDelta := float64(cur.cpuIdle - prev.cpuIdle)
+	return (totalDelta - idleDelta) / totalDelta * 100
+}
@@ -0,0 +1,211 @@
+package nodeagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"blytz/internal/archive"
+	"blytz/internal/logs"
+)
+
+// bundlePaths are the files the control plane generates for a tenant and
+// ships to its node. Agent data directories stay on the node.
+var bundlePaths = []string{"docker-compose.yml", "egress.rules", ".env.secret", ".openclaw"}
+
+// Client drives one node's agent. It implements the Runtime the agent
+// exposes and copies whole tenant directories for migrations. The tenant's
+// generated files are shipped before its containers are created or started,
+// so the node always runs the control plane's latest compose file.
+type Client struct {
+	baseURL string
+	token   string
+	baseDir string
+	http    *http.Client
+	// stream carries log streams, which stay open until the caller cancels
+	stream *http.Client
+}
+
+var (
+	_ Runtime         = (*Client)(nil)
+	_ logs.NodeSource = (*Client)(nil)
+)
+
+// NewClient creates a client for the agent at agentURL. baseDir is the
+// control plane's tenant directory the generated files are read from.
+func NewClient(agentURL, token, baseDir string) *Client {
+	return &Client{
+		baseURL: strings.TrimRight(agentURL, "/"),
+		token:   token,
+		baseDir: baseDir,
+		// Creating containers may pull images
+		http:   &http.Client{Timeout: 10 * time.Minute},
+		stream: &http.Client{},
+	}
+}
+
+// Ping checks the agent is reachable and accepts the token
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/v1/health", nil, "")
+}
+
+func (c *Client) Create(ctx context.Context, customerID string) error {
+	if err := c.putFiles(ctx, customerID); err != nil {
+		return err
+	}
+	return c.tenantAction(ctx, customerID, "create")
+}
+
+func (c *Client) Start(ctx context.Context, customerID string) error {
+	if err := c.putFiles(ctx, customerID); err != nil {
+		return err
+	}
+	return c.tenantAction(ctx, customerID, "start")
+}
+
+func (c *Client) Stop(ctx context.Context, customerID string) error {
+	return c.tenantAction(ctx, customerID, "stop")
+}
+
+func (c *Client) Restart(ctx context.Context, customerID string) error {
+	return c.tenantAction(ctx, customerID, "restart")
+}
+
+func (c *Client) Recreate(ctx context.Context, customerID string) error {
+	if err := c.putFiles(ctx, customerID); err != nil {
+		return err
+	}
+	return c.tenantAction(ctx, customerID, "recreate")
+}
+
+func (c *Client) Remove(ctx context.Context, customerID string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/tenants/"+customerID, nil, "")
+}
+
+// Snapshot writes the tenant's whole directory on the node, agent data
+// included, to w as a gzipped tarball
+func (c *Client) Snapshot(ctx context.Context, customerID string, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/tenants/"+customerID+"/snapshot", nil, "")
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("node agent GET snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("node agent GET snapshot: %w", err)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("download snapshot: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the tenant's directory on the node with a snapshot
+func (c *Client) Restore(ctx context.Context, customerID string, r io.Reader) error {
+	if err := c.do(ctx, http.MethodPut, "/v1/tenants/"+customerID+"/snapshot", r, "application/gzip"); err != nil {
+		return fmt.Errorf("upload snapshot: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the tenant's directory from the node
+func (c *Client) Purge(ctx context.Context, customerID string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/tenants/"+customerID+"/files", nil, "")
+}
+
+// TenantLogs streams the tenant container's log from the node, unredacted
+func (c *Client) TenantLogs(ctx context.Context, customerID string, opts logs.Options) (io.ReadCloser, error) {
+	query := url.Values{"tail": {strconv.Itoa(opts.Tail)}}
+	if opts.Since != "" {
+		query.Set("since", opts.Since)
+	}
+	if opts.Follow {
+		query.Set("follow", "true")
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, "/v1/tenants/"+customerID+"/logs?"+query.Encode(), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("node agent GET logs: %w", err)
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("node agent GET logs: %w", err)
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) tenantAction(ctx context.Context, customerID, action string) error {
+	return c.do(ctx, http.MethodPost, "/v1/tenants/"+customerID+"/"+action, nil, "")
+}
+
+// putFiles streams the tenant's generated files to the node
+func (c *Client) putFiles(ctx context.Context, customerID string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(archive.Write(pw, filepath.Join(c.baseDir, customerID), bundlePaths...))
+	}()
+	defer pr.Close()
+
+	if err := c.do(ctx, http.MethodPut, "/v1/tenants/"+customerID+"/files", pr, "application/gzip"); err != nil {
+		return fmt.Errorf("upload tenant files: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, contentType string) error {
+	req, err := c.newRequest(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("node agent %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return fmt.Errorf("node agent %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("create node agent request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+// checkResponse returns the agent's error for an unsuccessful response
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var errResp errorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+		errResp.Error = resp.Status
+	}
+	return errors.New(errResp.Error)
+}
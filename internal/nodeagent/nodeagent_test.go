@@ -0,0 +1,242 @@
+package nodeagent
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/logs"
+)
+
+const testToken = "node-secret"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeRuntime records operations and snapshots the tenant's compose file at each
+type fakeRuntime struct {
+	mu      sync.Mutex
+	baseDir string
+	calls   []string
+	compose []string
+	fail    error
+}
+
+func (f *fakeRuntime) record(op, customerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, op+" "+customerID)
+	content, _ := os.ReadFile(filepath.Join(f.baseDir, customerID, "docker-compose.yml"))
+	f.compose = append(f.compose, string(content))
+	return f.fail
+}
+
+func (f *fakeRuntime) Create(ctx context.Context, id string) error   { return f.record("create", id) }
+func (f *fakeRuntime) Start(ctx context.Context, id string) error    { return f.record("start", id) }
+func (f *fakeRuntime) Stop(ctx context.Context, id string) error     { return f.record("stop", id) }
+func (f *fakeRuntime) Restart(ctx context.Context, id string) error  { return f.record("restart", id) }
+func (f *fakeRuntime) Recreate(ctx context.Context, id string) error { return f.record("recreate", id) }
+func (f *fakeRuntime) Remove(ctx context.Context, id string) error   { return f.record("remove", id) }
+
+// startAgent runs an agent over a fake runtime and returns a client for it
+// reading tenant files from its own control-plane directory
+func startAgent(t *testing.T) (*Client, *fakeRuntime, string, string) {
+	t.Helper()
+	nodeDir, controlDir := t.TempDir(), t.TempDir()
+	runtime := &fakeRuntime{baseDir: nodeDir}
+	srv := httptest.NewServer(NewServer(runtime, nodeDir, testToken, nil).Handler())
+	t.Cleanup(srv.Close)
+	return NewClient(srv.URL, testToken, controlDir), runtime, nodeDir, controlDir
+}
+
+func writeTenantFile(t *testing.T, dir, name, content string, perm os.FileMode) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte(content), perm))
+}
+
+func TestClientShipsFilesBeforeStarting(t *testing.T) {
+	client, runtime, nodeDir, controlDir := startAgent(t)
+	ctx := t.Context()
+
+	tenant := filepath.Join(controlDir, "alice")
+	writeTenantFile(t, tenant, "docker-compose.yml", "memory: 512M", 0644)
+	writeTenantFile(t, tenant, ".env.secret", "OPENAI_API_KEY=blytz-llm-token", 0600)
+	writeTenantFile(t, tenant, ".openclaw/workspace/SOUL.md", "# Soul", 0644)
+	writeTenantFile(t, tenant, "data/state.db", "control-plane copy", 0644)
+
+	// Agent data on the node is never overwritten
+	writeTenantFile(t, filepath.Join(nodeDir, "alice"), "data/state.db", "node state", 0644)
+
+	require.NoError(t, client.Create(ctx, "alice"))
+	require.NoError(t, client.Start(ctx, "alice"))
+
+	node := filepath.Join(nodeDir, "alice")
+	content, err := os.ReadFile(filepath.Join(node, ".openclaw", "workspace", "SOUL.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "# Soul", string(content))
+
+	info, err := os.Stat(filepath.Join(node, ".env.secret"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	content, err = os.ReadFile(filepath.Join(node, "data", "state.db"))
+	require.NoError(t, err)
+	assert.Equal(t, "node state", string(content))
+
+	// A recreate picks up a regenerated compose file
+	writeTenantFile(t, tenant, "docker-compose.yml", "memory: 1G", 0644)
+	require.NoError(t, client.Recreate(ctx, "alice"))
+	require.NoError(t, client.Stop(ctx, "alice"))
+	require.NoError(t, client.Restart(ctx, "alice"))
+	require.NoError(t, client.Remove(ctx, "alice"))
+
+	assert.Equal(t, []string{"create alice", "start alice", "recreate alice", "stop alice", "restart alice", "remove alice"}, runtime.calls)
+	assert.Equal(t, "memory: 512M", runtime.compose[0])
+	assert.Equal(t, "memory: 1G", runtime.compose[2])
+}
+
+func TestClientReportsAgentErrors(t *testing.T) {
+	client, runtime, _, _ := startAgent(t)
+	runtime.fail = errors.New("compose up: no such image")
+
+	err := client.Stop(t.Context(), "alice")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no such image")
+
+	unreachable := NewClient("http://127.0.0.1:1", testToken, t.TempDir())
+	assert.Error(t, unreachable.Ping(t.Context()))
+}
+
+func TestServerRequiresToken(t *testing.T) {
+	client, runtime, _, controlDir := startAgent(t)
+	require.NoError(t, client.Ping(t.Context()))
+
+	wrong := NewClient(client.baseURL, "wrong", controlDir)
+	assert.Error(t, wrong.Ping(t.Context()))
+	assert.Error(t, wrong.Stop(t.Context(), "alice"))
+
+	// An agent without a token accepts nobody
+	srv := httptest.NewServer(NewServer(runtime, t.TempDir(), "", nil).Handler())
+	t.Cleanup(srv.Close)
+	assert.Error(t, NewClient(srv.URL, "", controlDir).Ping(t.Context()))
+
+	assert.Empty(t, runtime.calls)
+}
+
+func TestServerRejectsUnsafeInput(t *testing.T) {
+	nodeDir := t.TempDir()
+	runtime := &fakeRuntime{baseDir: nodeDir}
+	handler := NewServer(runtime, nodeDir, testToken, nil).Handler()
+
+	send := func(method, path string, body []byte) int {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/v1/tenants/.env/stop", nil))
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPost, "/v1/tenants/Alice/stop", nil))
+
+	// Bundle entries may not escape the tenant directory
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	content := []byte("pwned")
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../escape.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/v1/tenants/alice/files", buf.Bytes()))
+	_, err = os.Stat(filepath.Join(nodeDir, "escape.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, http.StatusBadRequest, send(http.MethodPut, "/v1/tenants/alice/files", []byte("not a tarball")))
+	assert.Empty(t, runtime.calls)
+}
+
+func TestClientCopiesTenantBetweenNodes(t *testing.T) {
+	source, _, sourceDir, _ := startAgent(t)
+	target, _, targetDir, _ := startAgent(t)
+	ctx := t.Context()
+
+	tenant := filepath.Join(sourceDir, "alice")
+	writeTenantFile(t, tenant, "docker-compose.yml", "memory: 512M", 0644)
+	writeTenantFile(t, tenant, "data/state.db", "conversation history", 0600)
+	// Leftovers from an earlier attempt on the target are replaced
+	writeTenantFile(t, filepath.Join(targetDir, "alice"), "data/stale.db", "old", 0644)
+
+	var snapshot bytes.Buffer
+	require.NoError(t, source.Snapshot(ctx, "alice", &snapshot))
+	require.NoError(t, target.Restore(ctx, "alice", &snapshot))
+
+	content, err := os.ReadFile(filepath.Join(targetDir, "alice", "data", "state.db"))
+	require.NoError(t, err)
+	assert.Equal(t, "conversation history", string(content))
+	info, err := os.Stat(filepath.Join(targetDir, "alice", "data", "state.db"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.NoFileExists(t, filepath.Join(targetDir, "alice", "data", "stale.db"))
+
+	require.NoError(t, source.Purge(ctx, "alice"))
+	assert.NoDirExists(t, tenant)
+	assert.Error(t, source.Snapshot(ctx, "alice", &snapshot))
+	assert.Error(t, target.Restore(ctx, "alice", bytes.NewReader([]byte("not a tarball"))))
+}
+
+type fakeLogSource struct {
+	container string
+	opts      logs.Options
+	output    string
+}
+
+func (f *fakeLogSource) Logs(ctx context.Context, container string, opts logs.Options) (io.ReadCloser, error) {
+	f.container = container
+	f.opts = opts
+	return io.NopCloser(strings.NewReader(f.output)), nil
+}
+
+func TestClientStreamsTenantLogs(t *testing.T) {
+	source := &fakeLogSource{output: "boot\nready\n"}
+	server := NewServer(&fakeRuntime{baseDir: t.TempDir()}, t.TempDir(), testToken, nil)
+	server.UseLogs(source)
+	srv := httptest.NewServer(server.Handler())
+	t.Cleanup(srv.Close)
+	client := NewClient(srv.URL, testToken, t.TempDir())
+
+	opts := logs.Options{Tail: 20, Since: "15m", Follow: true}
+	rc, err := client.TenantLogs(t.Context(), "alice", opts)
+	require.NoError(t, err)
+	defer rc.Close()
+
+	body, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	assert.Equal(t, "boot\nready\n", string(body))
+	assert.Equal(t, "blytz-alice", source.container)
+	assert.Equal(t, opts, source.opts)
+
+	// An agent without a log source says so rather than streaming nothing
+	client, _, _, _ = startAgent(t)
+	_, err = client.TenantLogs(t.Context(), "alice", logs.Options{Tail: -1})
+	assert.ErrorContains(t, err, "logs are not served by this node")
+}
@@ -0,0 +1,236 @@
+// Package nodeagent is the lightweight agent running on each worker node. The
+// control plane drives it over HTTP, authenticated with a shared bearer token,
+// to manage the tenant containers placed on the node.
+package nodeagent
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"blytz/internal/archive"
+	"blytz/internal/logs"
+	"blytz/internal/metrics"
+)
+
+// Runtime runs tenants' compose projects on the node
+type Runtime interface {
+	Create(ctx context.Context, customerID string) error
+	Start(ctx context.Context, customerID string) error
+	Stop(ctx context.Context, customerID string) error
+	Restart(ctx context.Context, customerID string) error
+	Recreate(ctx context.Context, customerID string) error
+	Remove(ctx context.Context, customerID string) error
+}
+
+// tenantIDPattern matches the customer IDs the control plane generates; it
+// keeps IDs usable as directory names
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// errorResponse is the body of every failed agent request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server exposes a node's Runtime to the control plane
+type Server struct {
+	runtime Runtime
+	logs    logs.Source
+	baseDir string
+	token   string
+	logger  *zap.Logger
+}
+
+// NewServer creates an agent serving tenants under baseDir. Requests must
+// carry token as a bearer token.
+func NewServer(runtime Runtime, baseDir, token string, logger *zap.Logger) *Server {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Server{
+		runtime: runtime,
+		baseDir: baseDir,
+		token:   token,
+		logger:  logger,
+	}
+}
+
+// UseLogs serves tenants' container logs from source
+func (s *Server) UseLogs(source logs.Source) {
+	s.logs = source
+}
+
+// Handler returns the agent's HTTP API
+func (s *Server) Handler() http.Handler {
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	v1 := router.Group("/v1", s.requireToken)
+	v1.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	tenants := v1.Group("/tenants/:id", s.requireTenantID)
+	tenants.PUT("/files", s.putFiles)
+	tenants.DELETE("/files", s.purge)
+	tenants.GET("/snapshot", s.snapshot)
+	tenants.PUT("/snapshot", s.restore)
+	tenants.GET("/logs", s.streamLogs)
+	tenants.POST("/create", s.action("create", s.runtime.Create))
+	tenants.POST("/start", s.action("start", s.runtime.Start))
+	tenants.POST("/stop", s.action("stop", s.runtime.Stop))
+	tenants.POST("/restart", s.action("restart", s.runtime.Restart))
+	tenants.POST("/recreate", s.action("recreate", s.runtime.Recreate))
+	tenants.DELETE("", s.action("remove", s.runtime.Remove))
+
+	return router
+}
+
+func (s *Server) requireToken(c *gin.Context) {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+func (s *Server) requireTenantID(c *gin.Context) {
+	if !tenantIDPattern.MatchString(c.Param("id")) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse{Error: "invalid tenant id"})
+		return
+	}
+	c.Next()
+}
+
+// putFiles unpacks the tenant's generated files sent by the control plane
+func (s *Server) putFiles(c *gin.Context) {
+	id := c.Param("id")
+	if err := archive.Extract(c.Request.Body, filepath.Join(s.baseDir, id)); err != nil {
+		s.logger.Warn("Failed to unpack tenant files", zap.String("customer_id", id), zap.Error(err))
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// snapshot streams the tenant's whole directory, agent data included, so it
+// can be restored on another node
+func (s *Server) snapshot(c *gin.Context) {
+	id := c.Param("id")
+	dir := filepath.Join(s.baseDir, id)
+	if _, err := os.Stat(dir); err != nil {
+		c.JSON(http.StatusNotFound, errorResponse{Error: "tenant has no files on this node"})
+		return
+	}
+
+	c.Header("Content-Type", "application/gzip")
+	c.Status(http.StatusOK)
+	if err := archive.Write(c.Writer, dir); err != nil {
+		// The status is already sent; the truncated archive fails to extract
+		s.logger.Error("Failed to snapshot tenant", zap.String("customer_id", id), zap.Error(err))
+		return
+	}
+	s.logger.Info("Tenant snapshot taken", zap.String("customer_id", id))
+}
+
+// restore replaces the tenant's directory with a snapshot from another node
+func (s *Server) restore(c *gin.Context) {
+	id := c.Param("id")
+	dir := filepath.Join(s.baseDir, id)
+	if err := os.RemoveAll(dir); err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	if err := archive.Extract(c.Request.Body, dir); err != nil {
+		s.logger.Warn("Failed to restore tenant snapshot", zap.String("customer_id", id), zap.Error(err))
+		os.RemoveAll(dir)
+		c.JSON(http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	s.logger.Info("Tenant snapshot restored", zap.String("customer_id", id))
+	c.Status(http.StatusNoContent)
+}
+
+// streamLogs copies the tenant container's log to the response as it is
+// written. It is sent unredacted: the control plane holds the tenant's secrets
+// and redacts it.
+func (s *Server) streamLogs(c *gin.Context) {
+	if s.logs == nil {
+		c.JSON(http.StatusNotImplemented, errorResponse{Error: "logs are not served by this node"})
+		return
+	}
+
+	opts := logs.Options{Tail: -1, Since: c.Query("since"), Follow: c.Query("follow") == "true"}
+	if raw := c.Query("tail"); raw != "" {
+		tail, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorResponse{Error: "invalid tail"})
+			return
+		}
+		opts.Tail = tail
+	}
+
+	id := c.Param("id")
+	rc, err := s.logs.Logs(c.Request.Context(), metrics.ContainerName(id), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	defer rc.Close()
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Status(http.StatusOK)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		if err != nil {
+			if err != io.EOF && c.Request.Context().Err() == nil {
+				// The status is already sent; the control plane sees the stream end early
+				s.logger.Warn("Tenant log stream failed", zap.String("customer_id", id), zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+// purge deletes the tenant's directory once it no longer runs on the node
+func (s *Server) purge(c *gin.Context) {
+	id := c.Param("id")
+	if err := os.RemoveAll(filepath.Join(s.baseDir, id)); err != nil {
+		c.JSON(http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	s.logger.Info("Tenant files purged", zap.String("customer_id", id))
+	c.Status(http.StatusNoContent)
+}
+
+// action runs a runtime operation on the tenant named in the path
+func (s *Server) action(name string, op func(context.Context, string) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := op(c.Request.Context(), id); err != nil {
+			s.logger.Error("Tenant operation failed", zap.String("action", name),
+				zap.String("customer_id", id), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, errorResponse{Error: err.Error()})
+			return
+		}
+		s.logger.Info("Tenant operation", zap.String("action", name), zap.String("customer_id", id))
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,310 @@
+// Package privacy implements customer data export and the right-to-erasure workflow
+package privacy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/provisioner"
+)
+
+// secretFiles are workspace files holding platform credentials rather than customer data.
+// They are never exported.
+var secretFiles = map[string]bool{
+	".env.secret": true,
+}
+
+// redactedFiles are workspace files that also embed platform credentials.
+// They are exported with those values masked.
+var redactedFiles = map[string]func([]byte) ([]byte, bool){
+	"docker-compose.yml": redactComposeFile,
+	"openclaw.json":      redactOpenClawConfig,
+}
+
+// redacted replaces credential values in exported files
+const redacted = "[REDACTED]"
+
+// composeSecretPattern matches compose environment entries carrying keys or tokens.
+// Workspaces provisioned before secrets moved to .env.secret still have them inline.
+var composeSecretPattern = regexp.MustCompile(`(?m)^(\s*-?\s*[A-Z0-9_]*(?:KEY|TOKEN|SECRET|PASSWORD)[A-Z0-9_]*\s*[=:]\s*)\S.*$`)
+
+// openClawSecretKeys are the openclaw.json fields holding the gateway and bot tokens
+var openClawSecretKeys = map[string]bool{
+	"token":    true,
+	"botToken": true,
+}
+
+// Subscriptions cancels customers' Stripe subscriptions. stripe.Service implements it.
+type Subscriptions interface {
+	CancelSubscription(subscriptionID string) error
+}
+
+// Service schedules and carries out customer data erasure and export
+type Service struct {
+	db            *db.DB
+	provisioner   provisioner.Provisioner
+	subscriptions Subscriptions
+	customersDir  string
+	gracePeriod   time.Duration
+	logger        *zap.Logger
+}
+
+// ExportedFile is a file from the customer's workspace directory
+type ExportedFile struct {
+	Path     string `json:"path"`
+	Encoding string `json:"encoding"` // "utf-8" or "base64"
+	Content  string `json:"content"`
+}
+
+// Export is the machine-readable bundle of everything held about a customer
+type Export struct {
+	*db.CustomerExport
+	Files      []ExportedFile `json:"files"`
+	ExportedAt time.Time      `json:"exported_at"`
+}
+
+// NewService creates a privacy service. Erasure requests become due after
+// gracePeriod; erasing a customer cancels their subscription through subs.
+func NewService(database *db.DB, prov provisioner.Provisioner, subs Subscriptions, customersDir string, gracePeriod time.Duration, logger *zap.Logger) *Service {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Service{
+		db:            database,
+		provisioner:   prov,
+		subscriptions: subs,
+		customersDir:  customersDir,
+		gracePeriod:   gracePeriod,
+		logger:        logger,
+	}
+}
+
+// RequestErasure schedules the customer's data for erasure once the grace period ends
+func (s *Service) RequestErasure(ctx context.Context, customerID string) (*db.ErasureRequest, error) {
+	if _, err := s.db.GetCustomerByID(ctx, customerID); err != nil {
+		return nil, err
+	}
+
+	return s.db.ScheduleErasure(ctx, customerID, time.Now().Add(s.gracePeriod))
+}
+
+// GetErasure returns the customer's erasure request, or nil if none exists
+func (s *Service) GetErasure(ctx context.Context, customerID string) (*db.ErasureRequest, error) {
+	return s.db.GetErasureRequest(ctx, customerID)
+}
+
+// CancelErasure withdraws a pending erasure request during the grace period
+func (s *Service) CancelErasure(ctx context.Context, customerID string) error {
+	return s.db.CancelErasure(ctx, customerID)
+}
+
+// Erase cancels the customer's subscription, tears down their container,
+// removes their workspace directory and deletes their records, pseudonymizing
+// the audit trail. Nothing is erased unless the subscription is cancelled, so
+// an erased customer is never billed again.
+func (s *Service) Erase(ctx context.Context, customerID string) error {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.cancelSubscription(customer); err != nil {
+		return err
+	}
+
+	switch customer.Status {
+	case "provisioning", "active", "degraded", "suspended":
+		if err := s.provisioner.Terminate(ctx, customerID); err != nil {
+			return fmt.Errorf("terminate customer: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(s.customerDir(customerID)); err != nil {
+		return fmt.Errorf("remove customer directory: %w", err)
+	}
+
+	pseudonym := "erased-" + uuid.New().String()
+	if err := s.db.EraseCustomer(ctx, customerID, pseudonym); err != nil {
+		return err
+	}
+
+	s.logger.Info("Customer data erased", zap.String("pseudonym", pseudonym))
+	return nil
+}
+
+// cancelSubscription ends the customer's subscription unless Stripe already has
+func (s *Service) cancelSubscription(customer *db.Customer) error {
+	if customer.StripeSubscriptionID == nil || *customer.StripeSubscriptionID == "" {
+		return nil
+	}
+	if customer.SubscriptionStatus != nil {
+		switch *customer.SubscriptionStatus {
+		case "canceled", "incomplete_expired":
+			return nil
+		}
+	}
+
+	if s.subscriptions == nil {
+		return fmt.Errorf("cancel subscription %s: no billing configured", *customer.StripeSubscriptionID)
+	}
+	if err := s.subscriptions.CancelSubscription(*customer.StripeSubscriptionID); err != nil {
+		return fmt.Errorf("cancel subscription: %w", err)
+	}
+	return nil
+}
+
+// ProcessDue erases every customer whose grace period has ended.
+// It returns the number of customers erased.
+func (s *Service) ProcessDue(ctx context.Context) (int, error) {
+	due, err := s.db.ListDueErasures(ctx, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, req := range due {
+		if err := s.Erase(ctx, req.CustomerID); err != nil {
+			// Leave the request scheduled so the next run retries it
+			s.logger.Error("Failed to erase customer", zap.String("customer_id", req.CustomerID), zap.Error(err))
+			continue
+		}
+		erased++
+	}
+
+	return erased, nil
+}
+
+// Run processes due erasure requests every interval until ctx is cancelled
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.ProcessDue(ctx); err != nil {
+				s.logger.Error("Failed to process erasure requests", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Export collects every database record and workspace file held about a customer
+func (s *Service) Export(ctx context.Context, customerID string) (*Export, error) {
+	records, err := s.db.ExportCustomerData(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.exportFiles(customerID)
+	if err != nil {
+		return nil, fmt.Errorf("export files: %w", err)
+	}
+
+	return &Export{
+		CustomerExport: records,
+		Files:          files,
+		ExportedAt:     time.Now().UTC(),
+	}, nil
+}
+
+func (s *Service) customerDir(customerID string) string {
+	return filepath.Join(s.customersDir, customerID)
+}
+
+func (s *Service) exportFiles(customerID string) ([]ExportedFile, error) {
+	root := s.customerDir(customerID)
+	files := []ExportedFile{}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() || secretFiles[d.Name()] {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if redact, ok := redactedFiles[d.Name()]; ok {
+			if data, ok = redact(data); !ok {
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		file := ExportedFile{Path: filepath.ToSlash(rel), Encoding: "utf-8", Content: string(data)}
+		if !utf8.Valid(data) {
+			file.Encoding = "base64"
+			file.Content = base64.StdEncoding.EncodeToString(data)
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// redactComposeFile masks the values of credential environment entries
+func redactComposeFile(data []byte) ([]byte, bool) {
+	return composeSecretPattern.ReplaceAll(data, []byte("${1}"+redacted)), true
+}
+
+// redactOpenClawConfig masks the gateway and bot tokens. A config that does not
+// parse cannot be redacted reliably, so it is left out of the export.
+func redactOpenClawConfig(data []byte) ([]byte, bool) {
+	var config any
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, false
+	}
+
+	out, err := json.MarshalIndent(redactJSON(config), "", "  ")
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if _, isString := value.(string); isString && openClawSecretKeys[key] {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactJSON(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactJSON(value)
+		}
+	}
+	return v
+}
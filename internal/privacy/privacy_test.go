@@ -0,0 +1,253 @@
+package privacy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+	"blytz/internal/telegram"
+)
+
+type fakeProvisioner struct {
+	terminated []string
+}
+
+func (f *fakeProvisioner) Provision(ctx context.Context, customerID string) error { return nil }
+func (f *fakeProvisioner) Suspend(ctx context.Context, customerID string) error   { return nil }
+func (f *fakeProvisioner) Resume(ctx context.Context, customerID string) error    { return nil }
+func (f *fakeProvisioner) Terminate(ctx context.Context, customerID string) error {
+	f.terminated = append(f.terminated, customerID)
+	return nil
+}
+func (f *fakeProvisioner) ChangePlan(ctx context.Context, customerID, planID string) error {
+	return nil
+}
+func (f *fakeProvisioner) ValidateBotToken(token string) (*telegram.BotInfo, error) {
+	return &telegram.BotInfo{OK: true}, nil
+}
+
+type fakeSubscriptions struct {
+	cancelled []string
+	err       error
+}
+
+func (f *fakeSubscriptions) CancelSubscription(subscriptionID string) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.cancelled = append(f.cancelled, subscriptionID)
+	return nil
+}
+
+func setupService(t *testing.T, grace time.Duration) (*Service, *db.DB, *fakeProvisioner, string) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	prov := &fakeProvisioner{}
+	dir := t.TempDir()
+	return NewService(database, prov, &fakeSubscriptions{}, dir, grace, nil), database, prov, dir
+}
+
+func createCustomer(t *testing.T, database *db.DB, status string) *db.Customer {
+	t.Helper()
+	ctx := context.Background()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "privacy@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, status))
+	return customer
+}
+
+func TestRequestErasureRespectsGracePeriod(t *testing.T) {
+	svc, database, prov, _ := setupService(t, time.Hour)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "active")
+
+	req, err := svc.RequestErasure(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.True(t, req.ScheduledFor.After(time.Now()))
+
+	erased, err := svc.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, erased)
+	assert.Empty(t, prov.terminated)
+
+	_, err = database.GetCustomerByID(ctx, customer.ID)
+	assert.NoError(t, err, "customer must survive the grace period")
+}
+
+func TestCancelErasure(t *testing.T) {
+	svc, database, _, _ := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "active")
+
+	_, err := svc.RequestErasure(ctx, customer.ID)
+	require.NoError(t, err)
+	require.NoError(t, svc.CancelErasure(ctx, customer.ID))
+
+	erased, err := svc.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, erased)
+}
+
+func TestProcessDueErasesCustomer(t *testing.T) {
+	svc, database, prov, dir := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "active")
+
+	customerDir := filepath.Join(dir, customer.ID)
+	require.NoError(t, os.MkdirAll(customerDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(customerDir, "docker-compose.yml"), []byte("services: {}"), 0644))
+
+	_, err := svc.RequestErasure(ctx, customer.ID)
+	require.NoError(t, err)
+
+	erased, err := svc.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, erased)
+	assert.Equal(t, []string{customer.ID}, prov.terminated)
+
+	_, err = os.Stat(customerDir)
+	assert.True(t, os.IsNotExist(err), "customer directory should be removed")
+
+	_, err = database.GetCustomerByID(ctx, customer.ID)
+	assert.Error(t, err)
+
+	entries, err := database.GetAuditLog(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestEraseSkipsTerminateWithoutContainer(t *testing.T) {
+	svc, database, prov, _ := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "pending")
+
+	require.NoError(t, svc.Erase(ctx, customer.ID))
+	assert.Empty(t, prov.terminated)
+}
+
+func TestEraseCancelsSubscription(t *testing.T) {
+	svc, database, _, _ := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "active")
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_erase", "sub_erase"))
+
+	require.NoError(t, svc.Erase(ctx, customer.ID))
+	assert.Equal(t, []string{"sub_erase"}, svc.subscriptions.(*fakeSubscriptions).cancelled)
+}
+
+func TestEraseFailsWhenCancelFails(t *testing.T) {
+	svc, database, prov, dir := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "active")
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_erase", "sub_erase"))
+	svc.subscriptions.(*fakeSubscriptions).err = errors.New("stripe unavailable")
+
+	customerDir := filepath.Join(dir, customer.ID)
+	require.NoError(t, os.MkdirAll(customerDir, 0755))
+
+	_, err := svc.RequestErasure(ctx, customer.ID)
+	require.NoError(t, err)
+
+	erased, err := svc.ProcessDue(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, erased)
+	assert.Empty(t, prov.terminated, "nothing is torn down while the subscription is live")
+
+	_, err = os.Stat(customerDir)
+	assert.NoError(t, err)
+	_, err = database.GetCustomerByID(ctx, customer.ID)
+	assert.NoError(t, err)
+
+	req, err := svc.GetErasure(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "scheduled", req.Status, "the erasure is retried on the next run")
+}
+
+func TestEraseSkipsCancelledSubscription(t *testing.T) {
+	svc, database, _, _ := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "cancelled")
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_erase", "sub_erase"))
+	require.NoError(t, database.UpdateSubscriptionPeriod(ctx, "cus_erase", "canceled", time.Now(), time.Now()))
+	svc.subscriptions.(*fakeSubscriptions).err = errors.New("no such subscription")
+
+	require.NoError(t, svc.Erase(ctx, customer.ID))
+}
+
+func TestExportIncludesFilesButNotSecrets(t *testing.T) {
+	svc, database, _, dir := setupService(t, 0)
+	ctx := t.Context()
+	customer := createCustomer(t, database, "active")
+
+	workspaceDir := filepath.Join(dir, customer.ID, ".openclaw", "workspace")
+	require.NoError(t, os.MkdirAll(workspaceDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(workspaceDir, "SOUL.md"), []byte("# Soul"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, customer.ID, "state.bin"), []byte{0xff, 0xfe}, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, customer.ID, ".env.secret"), []byte("OPENAI_API_KEY=sk"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, customer.ID, "docker-compose.yml"),
+		[]byte("services:\n  agent:\n    environment:\n      - OPENAI_API_KEY=blytz_proxy\n      - HOME=/home/node\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, customer.ID, ".openclaw", "openclaw.json"),
+		[]byte(`{"gateway":{"port":18789,"auth":{"token":"gw_secret"}},"channels":{"telegram":{"botToken":"123:bot_secret","dmPolicy":"pairing"}}}`), 0644))
+
+	export, err := svc.Export(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, customer.Email, export.Customer.Email)
+
+	files := map[string]ExportedFile{}
+	for _, f := range export.Files {
+		files[f.Path] = f
+	}
+	assert.Equal(t, "# Soul", files[".openclaw/workspace/SOUL.md"].Content)
+	assert.Equal(t, "base64", files["state.bin"].Encoding)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte{0xff, 0xfe}), files["state.bin"].Content)
+	assert.NotContains(t, files, ".env.secret")
+
+	compose := files["docker-compose.yml"].Content
+	assert.NotContains(t, compose, "blytz_proxy")
+	assert.Contains(t, compose, "OPENAI_API_KEY=[REDACTED]")
+	assert.Contains(t, compose, "HOME=/home/node")
+
+	config := files[".openclaw/openclaw.json"].Content
+	assert.NotContains(t, config, "gw_secret")
+	assert.NotContains(t, config, "bot_secret")
+	assert.Contains(t, config, `"dmPolicy": "pairing"`)
+}
+
+func TestExportOmitsUnparseableOpenClawConfig(t *testing.T) {
+	svc, database, _, dir := setupService(t, 0)
+	customer := createCustomer(t, database, "active")
+
+	configDir := filepath.Join(dir, customer.ID, ".openclaw")
+	require.NoError(t, os.MkdirAll(configDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(configDir, "openclaw.json"), []byte(`{"botToken": "123:bot_secret"`), 0644))
+
+	export, err := svc.Export(t.Context(), customer.ID)
+	require.NoError(t, err)
+	assert.Empty(t, export.Files)
+}
+
+func TestExportWithoutWorkspace(t *testing.T) {
+	svc, database, _, _ := setupService(t, 0)
+	customer := createCustomer(t, database, "pending")
+
+	export, err := svc.Export(t.Context(), customer.ID)
+	require.NoError(t, err)
+	assert.Empty(t, export.Files)
+}
@@ -17,19 +17,59 @@ type ComposeGenerator struct {
 type AgentConfig struct {
 	CustomerID         string
 	AgentType          string // "openclaw", "myrai", etc.
-	ExternalPort       int
+	ExternalPort       int    // unused when the tenant joins Network
 	ExternalPortBridge int
 	InternalPort       int
 	InternalPortBridge int
 	BaseImage          string
-	LLMEnvKey          string
-	LLMKey             string
-	GatewayToken       string
+	Prebuilt           bool // BaseImage is a pinned agent image, not a base the agent installs itself into
 	HealthEndpoint     string
-	MinMemory          string
-	MinCPU             string
+	MemoryLimit        string // from the customer's plan
+	CPULimit           string
+	// Network is the external Docker network the tenant joins instead of
+	// publishing ports on the host
+	Network string
+	// Bridge names the Linux bridge of the tenant's own network, which its
+	// egress rules match on
+	Bridge string
+	Hardening
 }
 
+// Hardening tunes the security profile every tenant service runs under
+type Hardening struct {
+	PidsLimit   int
+	NoFileLimit int
+	// Runtime is the OCI runtime containers run under, e.g. runsc for gVisor;
+	// empty uses Docker's default. Every host running tenants must have it.
+	Runtime string
+}
+
+// DefaultHardening is the profile tenants get unless configured otherwise
+func DefaultHardening() Hardening {
+	return Hardening{PidsLimit: 256, NoFileLimit: 4096}
+}
+
+// hardeningTemplate is the security profile every agent template includes:
+// no capabilities or privilege escalation, a read-only root filesystem, and
+// caps on processes and open files. Templates mount tmpfs wherever their
+// agent writes outside its volumes.
+const hardeningTemplate = `{{define "hardening"}}
+    read_only: true
+    cap_drop:
+      - ALL
+    security_opt:
+      - no-new-privileges:true
+    pids_limit: {{.PidsLimit}}
+    ulimits:
+      nofile:
+        soft: {{.NoFileLimit}}
+        hard: {{.NoFileLimit}}
+      core: 0
+{{- if .Runtime}}
+    runtime: {{.Runtime}}
+{{- end}}
+{{- end}}`
+
 // AgentTemplates contains docker-compose templates for each agent type
 var AgentTemplates = map[string]string{
 	"openclaw": `version: '3.8'
@@ -39,25 +79,46 @@ services:
     container_name: blytz-{{.CustomerID}}
     working_dir: /app
     user: "1000:1000"
+{{- template "hardening" .}}
+    tmpfs:
+{{- if .Prebuilt}}
+      - /tmp:size=64m,uid=1000,gid=1000
+    command: ["openclaw", "gateway", "--port", "{{.InternalPort}}", "--bind", "lan"]
+{{- else}}
+      - /tmp:size=256m,uid=1000,gid=1000
+      - /home/node/.npm-global:size=512m,uid=1000,gid=1000,exec
     command: >
       sh -c "npm install -g openclaw@latest &&
              mkdir -p /home/node/.openclaw &&
              openclaw gateway --port {{.InternalPort}} --bind lan"
+{{- end}}
+    networks:
+      - tenant
+{{- if .Network}}
+      - tenants
+{{- else}}
     ports:
       - "{{.ExternalPort}}:{{.InternalPort}}"
       - "{{.ExternalPortBridge}}:{{.InternalPortBridge}}"
+{{- end}}
     volumes:
       - ./config:/home/node/.openclaw
+    extra_hosts:
+      - "host.docker.internal:host-gateway"
     env_file:
       - .env.secret
     environment:
       - HOME=/home/node
-      - {{.LLMEnvKey}}=${{.LLMEnvKey}}
+{{- if not .Prebuilt}}
+      - NPM_CONFIG_PREFIX=/home/node/.npm-global
+      - NPM_CONFIG_CACHE=/tmp/.npm
+      - PATH=/home/node/.npm-global/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin
+{{- end}}
     deploy:
       resources:
         limits:
-          memory: {{.MinMemory}}
-          cpus: '{{.MinCPU}}'
+          memory: {{.MemoryLimit}}
+          cpus: '{{.CPULimit}}'
         reservations:
           memory: 128M
           cpus: '0.1'
@@ -73,6 +134,16 @@ services:
       options:
         max-size: "10m"
         max-file: "3"
+networks:
+  tenant:
+    name: blytz-net-{{.CustomerID}}
+    driver_opts:
+      com.docker.network.bridge.name: {{.Bridge}}
+{{- if .Network}}
+  tenants:
+    name: {{.Network}}
+    external: true
+{{- end}}
 `,
 	"myrai": `version: '3.8'
 services:
@@ -80,22 +151,31 @@ services:
     image: {{.BaseImage}}
     container_name: blytz-{{.CustomerID}}
     command: ["myrai", "server", "--port", "{{.InternalPort}}"]
+{{- template "hardening" .}}
+    tmpfs:
+      - /tmp:size=64m
+    networks:
+      - tenant
+{{- if .Network}}
+      - tenants
+{{- else}}
     ports:
       - "{{.ExternalPort}}:{{.InternalPort}}"
+{{- end}}
     volumes:
       - ./data:/app/data
+    extra_hosts:
+      - "host.docker.internal:host-gateway"
     env_file:
       - .env.secret
     environment:
-      - {{.LLMEnvKey}}=${{.LLMEnvKey}}
-      - MYRAI_GATEWAY_TOKEN={{.GatewayToken}}
       - MYRAI_SERVER_PORT={{.InternalPort}}
       - MYRAI_SERVER_ADDRESS=0.0.0.0
     deploy:
       resources:
         limits:
-          memory: {{.MinMemory}}
-          cpus: '{{.MinCPU}}'
+          memory: {{.MemoryLimit}}
+          cpus: '{{.CPULimit}}'
         reservations:
           memory: 128M
           cpus: '0.1'
@@ -111,6 +191,16 @@ services:
       options:
         max-size: "10m"
         max-file: "3"
+networks:
+  tenant:
+    name: blytz-net-{{.CustomerID}}
+    driver_opts:
+      com.docker.network.bridge.name: {{.Bridge}}
+{{- if .Network}}
+  tenants:
+    name: {{.Network}}
+    external: true
+{{- end}}
 `,
 }
 
@@ -126,11 +216,22 @@ func (cg *ComposeGenerator) Generate(config AgentConfig) error {
 		return fmt.Errorf("unknown agent type: %s", config.AgentType)
 	}
 
-	tmpl, err := template.New("compose").Parse(templateStr)
+	tmpl, err := template.New("compose").Parse(hardeningTemplate)
+	if err == nil {
+		tmpl, err = tmpl.Parse(templateStr)
+	}
 	if err != nil {
 		return fmt.Errorf("parse template: %w", err)
 	}
 
+	defaults := DefaultHardening()
+	if config.PidsLimit <= 0 {
+		config.PidsLimit = defaults.PidsLimit
+	}
+	if config.NoFileLimit <= 0 {
+		config.NoFileLimit = defaults.NoFileLimit
+	}
+
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, config); err != nil {
 		return fmt.Errorf("execute template: %w", err)
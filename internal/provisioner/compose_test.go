@@ -8,6 +8,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"blytz/internal/egress"
 )
 
 func TestGenerateOpenClawCompose(t *testing.T) {
@@ -22,12 +24,9 @@ func TestGenerateOpenClawCompose(t *testing.T) {
 		InternalPort:       18789,
 		InternalPortBridge: 18790,
 		BaseImage:          "node:22-bookworm",
-		LLMEnvKey:          "OPENAI_API_KEY",
-		LLMKey:             "sk-test",
-		GatewayToken:       "test-token",
 		HealthEndpoint:     "/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 
 	err := gen.Generate(config)
@@ -61,12 +60,9 @@ func TestGenerateMyraiCompose(t *testing.T) {
 		InternalPort:       8080,
 		InternalPortBridge: 0,
 		BaseImage:          "ghcr.io/gmsas95/myrai:latest",
-		LLMEnvKey:          "ANTHROPIC_API_KEY",
-		LLMKey:             "sk-ant-test",
-		GatewayToken:       "myrai-token",
 		HealthEndpoint:     "/api/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 
 	err := gen.Generate(config)
@@ -82,7 +78,7 @@ func TestGenerateMyraiCompose(t *testing.T) {
 	assert.Contains(t, contentStr, "image: ghcr.io/gmsas95/myrai:latest")
 	assert.Contains(t, contentStr, `["myrai", "server", "--port", "8080"]`)
 	assert.Contains(t, contentStr, "30100:8080")
-	assert.Contains(t, contentStr, "MYRAI_GATEWAY_TOKEN=myrai-token")
+	assert.NotContains(t, contentStr, "MYRAI_GATEWAY_TOKEN", "the token is read from .env.secret")
 	assert.Contains(t, contentStr, "MYRAI_SERVER_PORT=8080")
 	assert.Contains(t, contentStr, "http://localhost:8080/api/health")
 
@@ -138,6 +134,36 @@ func TestGenerateUnknownAgentType(t *testing.T) {
 	assert.Contains(t, err.Error(), "unknown agent type")
 }
 
+func TestComposeDoesNotInterpolateHostKeys(t *testing.T) {
+	// docker compose substitutes ${VAR} from the control plane's own
+	// environment, which holds the platform's LLM keys
+	for agentType := range AgentTemplates {
+		t.Run(agentType, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			gen := NewComposeGenerator(tmpDir)
+
+			err := gen.Generate(AgentConfig{
+				CustomerID:   "customer-789",
+				AgentType:    agentType,
+				ExternalPort: 30200,
+				InternalPort: 8080,
+				BaseImage:    "agent:latest",
+				MemoryLimit:  "512M",
+				CPULimit:     "0.25",
+			})
+			require.NoError(t, err)
+
+			content, err := os.ReadFile(filepath.Join(tmpDir, "customer-789", "docker-compose.yml"))
+			require.NoError(t, err)
+			assert.NotContains(t, string(content), "${")
+			assert.NotContains(t, string(content), "$OPENAI_API_KEY")
+			// The LLM token reaches the container only through the 0600 env file
+			assert.NotContains(t, string(content), "OPENAI_API_KEY")
+			assert.Contains(t, string(content), "host.docker.internal:host-gateway")
+		})
+	}
+}
+
 func TestAgentTemplateCompleteness(t *testing.T) {
 	// Verify all templates have required fields
 	requiredPlaceholders := []string{
@@ -145,9 +171,8 @@ func TestAgentTemplateCompleteness(t *testing.T) {
 		"{{.ExternalPort}}",
 		"{{.InternalPort}}",
 		"{{.BaseImage}}",
-		"{{.LLMEnvKey}}",
-		"{{.MinMemory}}",
-		"{{.MinCPU}}",
+		"{{.MemoryLimit}}",
+		"{{.CPULimit}}",
 	}
 
 	for agentType, template := range AgentTemplates {
@@ -168,10 +193,9 @@ func TestComposeGenerationIdempotency(t *testing.T) {
 		ExternalPort:   30001,
 		InternalPort:   18789,
 		BaseImage:      "node:22-bookworm",
-		LLMEnvKey:      "OPENAI_API_KEY",
 		HealthEndpoint: "/health",
-		MinMemory:      "512M",
-		MinCPU:         "0.25",
+		MemoryLimit:    "512M",
+		CPULimit:       "0.25",
 	}
 
 	// Generate first time
@@ -210,11 +234,9 @@ func TestDockerComposeValidity(t *testing.T) {
 				InternalPort:       18789,
 				InternalPortBridge: 18790,
 				BaseImage:          "node:22-bookworm",
-				LLMEnvKey:          "OPENAI_API_KEY",
-				GatewayToken:       "token",
 				HealthEndpoint:     "/health",
-				MinMemory:          "512M",
-				MinCPU:             "0.25",
+				MemoryLimit:        "512M",
+				CPULimit:           "0.25",
 			},
 		},
 		{
@@ -225,11 +247,9 @@ func TestDockerComposeValidity(t *testing.T) {
 				ExternalPort:   30100,
 				InternalPort:   8080,
 				BaseImage:      "ghcr.io/gmsas95/myrai:latest",
-				LLMEnvKey:      "ANTHROPIC_API_KEY",
-				GatewayToken:   "token",
 				HealthEndpoint: "/api/health",
-				MinMemory:      "512M",
-				MinCPU:         "0.25",
+				MemoryLimit:    "512M",
+				CPULimit:       "0.25",
 			},
 		},
 	}
@@ -254,3 +274,134 @@ func TestDockerComposeValidity(t *testing.T) {
 		})
 	}
 }
+
+func TestComposeOnTenantNetwork(t *testing.T) {
+	for agentType := range AgentTemplates {
+		t.Run(agentType, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			gen := NewComposeGenerator(tmpDir)
+
+			config := AgentConfig{
+				CustomerID:         "net-test",
+				AgentType:          agentType,
+				ExternalPort:       30001,
+				ExternalPortBridge: 30002,
+				InternalPort:       18789,
+				InternalPortBridge: 18790,
+				BaseImage:          "node:22-bookworm",
+				HealthEndpoint:     "/health",
+				MemoryLimit:        "512M",
+				CPULimit:           "0.25",
+				Network:            "blytz-tenants",
+			}
+			require.NoError(t, gen.Generate(config))
+
+			content, err := os.ReadFile(filepath.Join(tmpDir, "net-test", "docker-compose.yml"))
+			require.NoError(t, err)
+			contentStr := string(content)
+
+			// Nothing is published on the host
+			assert.NotContains(t, contentStr, "ports:")
+			assert.NotContains(t, contentStr, "30001")
+			assert.Contains(t, contentStr, "    networks:\n      - tenant\n      - tenants\n")
+			assert.True(t, strings.HasSuffix(contentStr, "  tenants:\n    name: blytz-tenants\n    external: true\n"),
+				"Should declare the external network last")
+		})
+	}
+}
+
+func TestComposeOwnNetwork(t *testing.T) {
+	for agentType := range AgentTemplates {
+		t.Run(agentType, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			gen := NewComposeGenerator(tmpDir)
+
+			config := AgentConfig{
+				CustomerID:         "own-net",
+				AgentType:          agentType,
+				ExternalPort:       30001,
+				ExternalPortBridge: 30002,
+				InternalPort:       18789,
+				InternalPortBridge: 18790,
+				BaseImage:          "node:22-bookworm",
+				HealthEndpoint:     "/health",
+				MemoryLimit:        "512M",
+				CPULimit:           "0.25",
+				Bridge:             egress.BridgeName("own-net"),
+			}
+			require.NoError(t, gen.Generate(config))
+
+			content, err := os.ReadFile(filepath.Join(tmpDir, "own-net", "docker-compose.yml"))
+			require.NoError(t, err)
+			contentStr := string(content)
+
+			// Off Docker's shared default bridge, on a bridge the egress rules can match
+			assert.Contains(t, contentStr, "    networks:\n      - tenant\n    ports:\n")
+			assert.True(t, strings.HasSuffix(contentStr, "networks:\n  tenant:\n    name: blytz-net-own-net\n"+
+				"    driver_opts:\n      com.docker.network.bridge.name: "+egress.BridgeName("own-net")+"\n"),
+				"Should declare the tenant network last")
+			assert.NotContains(t, contentStr, "tenants")
+		})
+	}
+}
+
+func TestComposeHardening(t *testing.T) {
+	for agentType := range AgentTemplates {
+		t.Run(agentType, func(t *testing.T) {
+			tests := []struct {
+				name      string
+				hardening Hardening
+				want      []string
+			}{
+				{
+					name: "defaults",
+					want: []string{"    pids_limit: 256\n", "        soft: 4096\n        hard: 4096\n"},
+				},
+				{
+					name:      "gvisor",
+					hardening: Hardening{PidsLimit: 128, NoFileLimit: 1024, Runtime: "runsc"},
+					want: []string{"    pids_limit: 128\n", "        soft: 1024\n        hard: 1024\n",
+						"    runtime: runsc\n"},
+				},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					tmpDir := t.TempDir()
+					config := AgentConfig{
+						CustomerID:         "hardened",
+						AgentType:          agentType,
+						ExternalPort:       30001,
+						ExternalPortBridge: 30002,
+						InternalPort:       18789,
+						InternalPortBridge: 18790,
+						BaseImage:          "node:22-bookworm",
+						HealthEndpoint:     "/health",
+						MemoryLimit:        "512M",
+						CPULimit:           "0.25",
+						Hardening:          tt.hardening,
+					}
+					require.NoError(t, NewComposeGenerator(tmpDir).Generate(config))
+
+					content, err := os.ReadFile(filepath.Join(tmpDir, "hardened", "docker-compose.yml"))
+					require.NoError(t, err)
+					contentStr := string(content)
+
+					assert.Contains(t, contentStr, "    read_only: true\n")
+					assert.Contains(t, contentStr, "    cap_drop:\n      - ALL\n")
+					assert.Contains(t, contentStr, "    security_opt:\n      - no-new-privileges:true\n")
+					assert.Contains(t, contentStr, "      core: 0\n")
+					assert.Contains(t, contentStr, "    tmpfs:\n      - /tmp:")
+					for _, want := range tt.want {
+						assert.Contains(t, contentStr, want)
+					}
+					if tt.hardening.Runtime == "" {
+						assert.NotContains(t, contentStr, "runtime:")
+					}
+					// Every service gets the profile
+					assert.Equal(t, strings.Count(contentStr, "    container_name: "), strings.Count(contentStr, "    read_only: true\n"))
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/caddy"
+	"blytz/internal/db"
+)
+
+// RouteDomain points a customer's verified custom domain at their tenant
+// and lets Caddy obtain its certificate on demand. Tenants not provisioned
+// yet get the route when they are.
+func (s *Service) RouteDomain(ctx context.Context, customerID, domain string) error {
+	if s.caddy == nil {
+		return nil
+	}
+
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	if customer.ContainerPort == nil {
+		return nil
+	}
+
+	p, err := s.locate(ctx, customer)
+	if err != nil {
+		return err
+	}
+	if err := s.caddy.AddDomain(ctx, customerID, domain, fmt.Sprintf("%s:%d", p.host, p.port)); err != nil {
+		return fmt.Errorf("route custom domain: %w", err)
+	}
+	return nil
+}
+
+// UnrouteDomain removes the route and TLS policy of a customer's custom
+// domain. Domains that were never routed are skipped.
+func (s *Service) UnrouteDomain(ctx context.Context, customerID string) error {
+	if s.caddy == nil {
+		return nil
+	}
+	if err := s.caddy.RemoveDomain(ctx, customerID); err != nil && !errors.Is(err, caddy.ErrRouteNotFound) {
+		return fmt.Errorf("remove custom domain route: %w", err)
+	}
+	return nil
+}
+
+// verifiedDomain returns the customer's verified custom domain, or "" if they have none
+func (s *Service) verifiedDomain(ctx context.Context, customerID string) string {
+	domain, err := s.db.GetCustomDomain(ctx, customerID)
+	if err != nil || domain.Status != db.DomainVerified {
+		return ""
+	}
+	return domain.Domain
+}
+
+// releaseDomain removes a cancelled customer's custom domain so the host name
+// stops reaching the platform and can be claimed again
+func (s *Service) releaseDomain(ctx context.Context, customerID string) error {
+	if domain := s.verifiedDomain(ctx, customerID); domain != "" {
+		if err := s.UnrouteDomain(ctx, customerID); err != nil {
+			s.logger.Warn("Failed to remove custom domain route", zap.String("customer_id", customerID),
+				zap.String("domain", domain), zap.Error(err))
+		}
+	}
+	return s.db.DeleteCustomDomain(ctx, customerID)
+}
@@ -0,0 +1,55 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/caddy"
+	"blytz/internal/db"
+)
+
+func TestCustomDomainRoutes(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}, "worker-2": {}}
+	svc, database, proxy, _ := newMigrationService(t, fleet)
+	ctx := t.Context()
+
+	// A domain verified before provisioning is routed once the tenant runs
+	alice := createNodeCustomer(t, database, "alice@example.com", "starter")
+	_, err := database.SetCustomDomain(ctx, alice.ID, "assistant.alice.example", "token")
+	require.NoError(t, err)
+	require.NoError(t, database.VerifyCustomDomain(ctx, alice.ID, "assistant.alice.example"))
+	require.NoError(t, svc.RouteDomain(ctx, alice.ID, "assistant.alice.example"))
+	assert.Empty(t, proxy.hosts())
+	assert.Empty(t, proxy.domainHosts())
+
+	worker2 := nodeByName(t, database, "worker-2")
+	require.NoError(t, database.SetNodeStatus(ctx, worker2.ID, db.NodeCordoned))
+	require.NoError(t, svc.Provision(ctx, alice.ID))
+	require.NoError(t, database.SetNodeStatus(ctx, worker2.ID, db.NodeActive))
+	assert.Equal(t, []string{alice.ID + ".blytz.cloud"}, proxy.hosts())
+	assert.Equal(t, []string{"assistant.alice.example"}, proxy.domainHosts(), "custom domains are served over HTTPS")
+	assert.Equal(t, []string{"worker-1.internal:40000", "worker-1.internal:40000"}, proxy.dials())
+	assert.Equal(t, []caddy.TLSPolicy{caddy.NewDomainPolicy(alice.ID, "assistant.alice.example")}, proxy.policies)
+
+	// Both routes follow the tenant to another node
+	require.NoError(t, svc.Migrate(ctx, alice.ID, worker2.ID))
+	assert.Equal(t, []string{"worker-2.internal:40000", "worker-2.internal:40000"}, proxy.dials())
+
+	// Removing a domain twice is harmless
+	require.NoError(t, svc.UnrouteDomain(ctx, alice.ID))
+	require.NoError(t, svc.UnrouteDomain(ctx, alice.ID))
+	assert.Equal(t, []string{alice.ID + ".blytz.cloud"}, proxy.hosts())
+	assert.Empty(t, proxy.domainHosts())
+	assert.Empty(t, proxy.policies)
+
+	// Cancelling the tenant removes its routes and domain
+	require.NoError(t, svc.RouteDomain(ctx, alice.ID, "assistant.alice.example"))
+	require.NoError(t, svc.Terminate(ctx, alice.ID))
+	assert.Empty(t, proxy.hosts())
+	assert.Empty(t, proxy.domainHosts())
+	assert.Empty(t, proxy.policies)
+	_, err = database.GetCustomDomain(ctx, alice.ID)
+	assert.Error(t, err)
+}
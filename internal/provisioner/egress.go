@@ -0,0 +1,132 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/egress"
+	"blytz/internal/llmproxy"
+)
+
+// egressRulesFile holds a tenant's rendered egress rules next to its compose file
+const egressRulesFile = "egress.rules"
+
+// installEgress lists what each agent type reaches beyond its LLM provider and
+// Telegram when it installs itself at start rather than running a pre-built
+// image. OpenClaw installs itself from npm.
+var installEgress = map[string][]egress.Destination{
+	"openclaw": {{Host: "registry.npmjs.org", Port: 443}},
+}
+
+// UseFirewall installs each tenant's egress rules on this host before its
+// containers are created or started, and removes them with the tenant
+func (s *Service) UseFirewall(fw *egress.Firewall) {
+	s.docker.UseFirewall(fw)
+}
+
+// egressRules describes the firewall of a tenant on the plan: the endpoint its
+// LLM provider is reached at, Telegram and whatever its agent type needs
+func (s *Service) egressRules(customer *db.Customer, agentType *db.AgentType, llmProvider *db.LLMProvider, plan *db.Plan) egress.Rules {
+	rules := egress.Rules{CustomerID: customer.ID, Policy: plan.Egress}
+
+	llmURL := llmProvider.BaseURL
+	if llmproxy.Proxied(llmProvider.ID) {
+		llmURL = s.llmProxyURL
+	}
+	if llmURL != "" {
+		dest, err := egress.DestinationFromURL(llmURL)
+		if err != nil {
+			s.logger.Warn("LLM endpoint left out of egress rules",
+				zap.String("customer_id", customer.ID), zap.Error(err))
+		} else {
+			rules.Allow = append(rules.Allow, dest)
+		}
+	}
+
+	for _, cidr := range egress.TelegramRanges {
+		rules.Allow = append(rules.Allow, egress.Destination{Host: cidr, Port: 443})
+	}
+	if customer.ImageDigest == nil || *customer.ImageDigest == "" {
+		rules.Allow = append(rules.Allow, installEgress[agentType.ID]...)
+	}
+
+	return rules
+}
+
+// writeEgressRules renders a tenant's egress rules into its directory, where
+// the runtime running its containers picks them up
+func (s *Service) writeEgressRules(ctx context.Context, customer *db.Customer, agentType *db.AgentType, llmProvider *db.LLMProvider, plan *db.Plan) error {
+	rendered := egress.Render(ctx, s.egressRules(customer, agentType, llmProvider, plan), s.lookupHost)
+
+	path := filepath.Join(s.baseDir, customer.ID, egressRulesFile)
+	if err := os.WriteFile(path, []byte(rendered), 0644); err != nil {
+		return fmt.Errorf("write egress rules: %w", err)
+	}
+	return nil
+}
+
+// UseFirewall installs tenants' egress rules before their containers are
+// created or started
+func (dp *DockerProvisioner) UseFirewall(fw *egress.Firewall) {
+	dp.firewall = fw
+}
+
+// applyEgress installs a tenant's egress rules. Tenants provisioned before
+// rules were rendered have none until their compose file is next written.
+func (dp *DockerProvisioner) applyEgress(ctx context.Context, customerID string) error {
+	if dp.firewall == nil {
+		return nil
+	}
+	rules, err := os.ReadFile(filepath.Join(dp.baseDir, customerID, egressRulesFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read egress rules: %w", err)
+	}
+	return dp.firewall.Apply(ctx, customerID, rules)
+}
+
+// RestoreEgress reinstalls the egress rules of every tenant on this host, which
+// do not survive a reboot. It returns how many tenants' rules were installed.
+func (dp *DockerProvisioner) RestoreEgress(ctx context.Context) (int, error) {
+	if dp.firewall == nil {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(dp.baseDir)
+	if err != nil {
+		return 0, fmt.Errorf("read customers directory: %w", err)
+	}
+
+	restored := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dp.baseDir, entry.Name(), egressRulesFile)); err != nil {
+			continue
+		}
+		if err := dp.applyEgress(ctx, entry.Name()); err != nil {
+			return restored, fmt.Errorf("restore egress rules for %s: %w", entry.Name(), err)
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// RestoreEgress reinstalls the egress rules of tenants on this host
+func (s *Service) RestoreEgress(ctx context.Context) error {
+	restored, err := s.docker.RestoreEgress(ctx)
+	if err != nil {
+		return err
+	}
+	if restored > 0 {
+		s.logger.Info("Restored tenant egress rules", zap.Int("tenants", restored))
+	}
+	return nil
+}
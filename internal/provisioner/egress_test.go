@@ -0,0 +1,47 @@
+package provisioner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/egress"
+)
+
+func TestEgressRulesFollowPlan(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}}
+	svc, database, _ := newNodeService(t, fleet)
+	ctx := t.Context()
+
+	require.NoError(t, database.SetPlanEgress(ctx, "starter", egress.Restricted))
+	customer := createNodeCustomer(t, database, "alice@example.com", "starter")
+	require.NoError(t, svc.Provision(ctx, customer.ID))
+
+	rulesPath := filepath.Join(svc.baseDir, customer.ID, egressRulesFile)
+	rules, err := os.ReadFile(rulesPath)
+	require.NoError(t, err)
+	chain := egress.ChainName(customer.ID)
+	// The LLM proxy on the control plane, Telegram and npm for OpenClaw
+	assert.Contains(t, string(rules), "-A "+chain+" -d 10.0.0.2/32 -p tcp --dport 8080 -j RETURN\n")
+	for _, cidr := range egress.TelegramRanges {
+		assert.Contains(t, string(rules), "-A "+chain+" -d "+cidr+" -p tcp --dport 443 -j RETURN\n")
+	}
+	assert.Contains(t, string(rules), "-A "+chain+" -d 104.16.0.35/32 -p tcp --dport 443 -j RETURN\n")
+	assert.Contains(t, string(rules), "-A "+chain+" -j DROP\n")
+
+	compose, err := os.ReadFile(filepath.Join(svc.baseDir, customer.ID, "docker-compose.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(compose), "com.docker.network.bridge.name: "+egress.BridgeName(customer.ID))
+
+	// Moving to an open plan rewrites the rules with the compose file
+	require.NoError(t, svc.ChangePlan(ctx, customer.ID, "pro"))
+	rules, err = os.ReadFile(rulesPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(rules), "(open)")
+	assert.NotContains(t, string(rules), "-A "+chain+" -j DROP\n")
+	assert.Contains(t, string(rules), "-A "+chain+" -d 10.0.0.2/32 -p tcp --dport 8080 -j RETURN\n",
+		"the proxy stays reachable past the private range drops")
+}
@@ -0,0 +1,66 @@
+package provisioner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServicePinsAgentImage(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}}
+	svc, database, _ := newNodeService(t, fleet)
+	ctx := t.Context()
+
+	const pinned = "registry.local/blytz/openclaw@sha256:1111"
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", "registry.local/blytz/openclaw:1.4.2", pinned))
+
+	customer := createNodeCustomer(t, database, "alice@example.com", "starter")
+	require.NoError(t, svc.Provision(ctx, customer.ID))
+
+	customer, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	require.NotNil(t, customer.ImageDigest)
+	assert.Equal(t, pinned, *customer.ImageDigest)
+
+	composePath := filepath.Join(svc.baseDir, customer.ID, "docker-compose.yml")
+	compose, err := os.ReadFile(composePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(compose), "    image: "+pinned+"\n")
+	assert.Contains(t, string(compose), `command: ["openclaw", "gateway", "--port", "18789", "--bind", "lan"]`)
+	assert.NotContains(t, string(compose), "npm")
+
+	// The agent needs nothing from npm at start
+	rules, err := os.ReadFile(filepath.Join(svc.baseDir, customer.ID, egressRulesFile))
+	require.NoError(t, err)
+	assert.NotContains(t, string(rules), "104.16.0.35")
+
+	// A newer build is for new tenants; rewriting the compose file keeps the pin
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", "registry.local/blytz/openclaw:1.5.0",
+		"registry.local/blytz/openclaw@sha256:2222"))
+	require.NoError(t, svc.ChangePlan(ctx, customer.ID, "pro"))
+	compose, err = os.ReadFile(composePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(compose), "    image: "+pinned+"\n")
+}
+
+func TestServiceWithoutAgentImage(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}}
+	svc, database, _ := newNodeService(t, fleet)
+	ctx := t.Context()
+
+	customer := createNodeCustomer(t, database, "alice@example.com", "starter")
+	require.NoError(t, svc.Provision(ctx, customer.ID))
+
+	customer, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Nil(t, customer.ImageDigest)
+
+	// Until an image is built the agent installs itself into the base image
+	compose, err := os.ReadFile(filepath.Join(svc.baseDir, customer.ID, "docker-compose.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(compose), "    image: node:22-bookworm\n")
+	assert.Contains(t, string(compose), "npm install -g openclaw@latest")
+}
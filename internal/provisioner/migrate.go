@@ -0,0 +1,252 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+	"blytz/internal/scheduler"
+)
+
+// ErrNotMigratable means the tenant has no containers to move
+var ErrNotMigratable = errors.New("tenant cannot be migrated")
+
+// migratableStatuses are the statuses of tenants whose containers exist
+var migratableStatuses = map[string]bool{"active": true, "degraded": true, "suspended": true}
+
+// healthPollInterval is how often a migrated tenant's health endpoint is polled
+const healthPollInterval = 2 * time.Second
+
+// Migrate moves a tenant to the worker node targetNodeID, or to the node the
+// scheduler picks when it is empty. The tenant keeps serving from its current
+// host while its directory is copied and its containers are brought up on the
+// target with a newly allocated port. Once the target answers its health
+// endpoint the Caddy route is switched and the source is torn down. If any
+// step before the switch fails, the target is removed and the tenant stays
+// where it was.
+//
+// Agent state written on the source after the snapshot is not carried over.
+func (s *Service) Migrate(ctx context.Context, customerID, targetNodeID string) error {
+	if s.scheduler == nil || s.dialNode == nil {
+		return errors.New("node agents are not configured")
+	}
+
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	if customer.ContainerPort == nil || !migratableStatuses[customer.Status] {
+		return fmt.Errorf("%w: tenant is %s", ErrNotMigratable, customer.Status)
+	}
+
+	agentType, err := s.db.GetAgentType(ctx, customer.AgentTypeID)
+	if err != nil {
+		return fmt.Errorf("get agent type: %w", err)
+	}
+	llmProvider, err := s.db.GetLLMProvider(ctx, customer.LLMProviderID)
+	if err != nil {
+		return fmt.Errorf("get llm provider: %w", err)
+	}
+	plan, err := s.db.GetPlan(ctx, customer.PlanID)
+	if err != nil {
+		return fmt.Errorf("get plan: %w", err)
+	}
+	req, err := scheduler.RequirementsForPlan(plan)
+	if err != nil {
+		return err
+	}
+
+	source, err := s.locate(ctx, customer)
+	if err != nil {
+		return err
+	}
+
+	dest, err := s.scheduler.Reserve(ctx, customerID, targetNodeID, req)
+	if err != nil {
+		return fmt.Errorf("reserve target node: %w", err)
+	}
+	defer s.scheduler.Release(customerID)
+	target := s.dialNode(&dest.Node)
+
+	logger := s.logger.With(zap.String("customer_id", customerID),
+		zap.String("from_node", source.nodeID), zap.String("to_node", dest.Node.ID))
+	logger.Info("Migrating tenant")
+
+	rollback := func(cause error) error {
+		bg := context.Background()
+		if err := target.Remove(bg, customerID); err != nil {
+			logger.Warn("Failed to remove tenant from target node", zap.Error(err))
+		}
+		if err := target.Purge(bg, customerID); err != nil {
+			logger.Warn("Failed to purge tenant from target node", zap.Error(err))
+		}
+		// Point the tenant's files back at the port it still runs on
+		if err := s.writeCompose(bg, customer, agentType, llmProvider, plan, source.port, source.network); err != nil {
+			logger.Error("Failed to restore compose file", zap.Error(err))
+		}
+		logger.Warn("Tenant migration rolled back", zap.Error(cause))
+		return cause
+	}
+
+	if err := copyTenant(ctx, source.runtime, target, customerID); err != nil {
+		return rollback(fmt.Errorf("copy tenant directory: %w", err))
+	}
+	if err := s.writeCompose(ctx, customer, agentType, llmProvider, plan, dest.Port, ""); err != nil {
+		return rollback(err)
+	}
+	if err := target.Create(ctx, customerID); err != nil {
+		return rollback(fmt.Errorf("create container: %w", err))
+	}
+
+	// Suspended tenants move stopped; degraded ones are not expected to be healthy
+	if customer.Status != "suspended" {
+		if err := target.Start(ctx, customerID); err != nil {
+			return rollback(fmt.Errorf("start container: %w", err))
+		}
+	}
+	if customer.Status == "active" {
+		if err := s.waitHealthy(ctx, dest.Node.Address, dest.Port, agentType.HealthEndpoint); err != nil {
+			return rollback(err)
+		}
+	}
+
+	// The tenant's subdomain and verified custom domain move together. Routes
+	// are replaced by ID, so switching back restores whichever had moved.
+	switchBack := func() {
+		if err := s.routeTenant(context.Background(), customerID, fmt.Sprintf("%s:%d", source.host, source.port)); err != nil {
+			logger.Error("Failed to switch routes back to source", zap.Error(err))
+		}
+	}
+	if s.caddy != nil {
+		if err := s.routeTenant(ctx, customerID, fmt.Sprintf("%s:%d", dest.Node.Address, dest.Port)); err != nil {
+			switchBack()
+			return rollback(fmt.Errorf("switch route: %w", err))
+		}
+	}
+
+	if err := s.db.AssignNode(ctx, customerID, dest.Node.ID, dest.Port); err != nil {
+		if s.caddy != nil {
+			switchBack()
+		}
+		return rollback(fmt.Errorf("record placement: %w", err))
+	}
+
+	// The move is recorded; the source is torn down on a best-effort basis
+	bg := context.Background()
+	if source.nodeID == "" {
+		if err := s.ports.Release(bg, s.db, customerID); err != nil {
+			logger.Warn("Failed to release source ports", zap.Error(err))
+		}
+	}
+	if err := source.runtime.Remove(bg, customerID); err != nil {
+		logger.Warn("Failed to remove tenant from source", zap.Error(err))
+	}
+	// On the control-plane host the directory also holds the generated files
+	// shipped to the tenant's node, so only worker nodes are purged
+	if source.nodeID != "" {
+		if err := source.runtime.Purge(bg, customerID); err != nil {
+			logger.Warn("Failed to purge tenant from source node", zap.Error(err))
+		}
+	}
+
+	logger.Info("Tenant migrated", zap.Int("port", dest.Port))
+	return nil
+}
+
+// Drain cordons a worker node and migrates every tenant on it to the other
+// nodes. It carries on past tenants that fail to move and reports them all.
+func (s *Service) Drain(ctx context.Context, nodeID string) error {
+	if s.scheduler == nil || s.dialNode == nil {
+		return errors.New("node agents are not configured")
+	}
+
+	if err := s.db.SetNodeStatus(ctx, nodeID, db.NodeCordoned); err != nil {
+		return err
+	}
+
+	tenants, err := s.db.ListNodeTenants(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tenant := range tenants {
+		if tenant.NodeID != nodeID {
+			continue
+		}
+		if err := s.Migrate(ctx, tenant.CustomerID, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tenant.CustomerID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// copyTenant streams a tenant's directory from one host to another
+func copyTenant(ctx context.Context, from, to Runtime, customerID string) error {
+	pr, pw := io.Pipe()
+	snapshotErr := make(chan error, 1)
+	go func() {
+		err := from.Snapshot(ctx, customerID, pw)
+		pw.CloseWithError(err)
+		snapshotErr <- err
+	}()
+
+	restoreErr := to.Restore(ctx, customerID, pr)
+	// Unblock the snapshot if the restore stopped reading early
+	pr.Close()
+
+	if err := <-snapshotErr; err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	if restoreErr != nil {
+		return fmt.Errorf("restore: %w", restoreErr)
+	}
+	return nil
+}
+
+// waitHealthy polls a tenant's health endpoint until it answers or
+// migrationHealthTimeout passes
+func (s *Service) waitHealthy(ctx context.Context, host string, port int, endpoint string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.migrationHealthTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d%s", host, port, endpoint)
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := s.checkHealth(ctx, url)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("tenant did not become healthy: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth reports whether a health endpoint answers with a 2xx status
+func checkHealth(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health endpoint returned %s", resp.Status)
+	}
+	return nil
+}
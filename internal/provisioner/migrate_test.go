@@ -0,0 +1,251 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+	"blytz/internal/scheduler"
+)
+
+// healthChecks stands in for tenants' health endpoints
+type healthChecks struct {
+	mu   sync.Mutex
+	urls []string
+	err  error
+}
+
+func (h *healthChecks) check(ctx context.Context, url string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.urls = append(h.urls, url)
+	return h.err
+}
+
+func newMigrationService(t *testing.T, fleet fakeFleet) (*Service, *db.DB, *fakeCaddy, *healthChecks) {
+	t.Helper()
+	svc, database, proxy := newNodeService(t, fleet)
+	health := &healthChecks{}
+	svc.checkHealth = health.check
+	svc.migrationHealthTimeout = 50 * time.Millisecond
+	return svc, database, proxy, health
+}
+
+// provisionOn provisions a starter tenant on the named node
+func provisionOn(t *testing.T, svc *Service, database *db.DB, email, nodeName string) *db.Customer {
+	t.Helper()
+	ctx := t.Context()
+
+	nodes, err := database.ListNodes(ctx)
+	require.NoError(t, err)
+	for _, node := range nodes {
+		if node.Name != nodeName {
+			require.NoError(t, database.SetNodeStatus(ctx, node.ID, db.NodeCordoned))
+		}
+	}
+	customer := createNodeCustomer(t, database, email, "starter")
+	require.NoError(t, svc.Provision(ctx, customer.ID))
+	for _, node := range nodes {
+		require.NoError(t, database.SetNodeStatus(ctx, node.ID, node.Status))
+	}
+	return customer
+}
+
+func nodeByName(t *testing.T, database *db.DB, name string) *db.Node {
+	t.Helper()
+	nodes, err := database.ListNodes(t.Context())
+	require.NoError(t, err)
+	for _, node := range nodes {
+		if node.Name == name {
+			return &node
+		}
+	}
+	t.Fatalf("node %s not registered", name)
+	return nil
+}
+
+func readComposeFile(t *testing.T, svc *Service, customerID string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(svc.baseDir, customerID, "docker-compose.yml"))
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestMigrate(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}, "worker-2": {}}
+	svc, database, proxy, health := newMigrationService(t, fleet)
+	ctx := t.Context()
+
+	alice := provisionOn(t, svc, database, "alice@example.com", "worker-1")
+	state := filepath.Join(fleet["worker-1"].dir, alice.ID, "data", "state.db")
+	require.NoError(t, os.MkdirAll(filepath.Dir(state), 0755))
+	require.NoError(t, os.WriteFile(state, []byte("conversation history"), 0600))
+
+	// Another tenant already holds the target's first port block
+	bob := createNodeCustomer(t, database, "bob@example.com", "starter")
+	target := nodeByName(t, database, "worker-2")
+	require.NoError(t, database.AssignNode(ctx, bob.ID, target.ID, 40000))
+	fleet["worker-1"].calls = nil
+
+	require.NoError(t, svc.Migrate(ctx, alice.ID, ""))
+
+	content, err := os.ReadFile(filepath.Join(fleet["worker-2"].dir, alice.ID, "data", "state.db"))
+	require.NoError(t, err)
+	assert.Equal(t, "conversation history", string(content))
+	assert.Equal(t, []string{"restore " + alice.ID, "create " + alice.ID, "start " + alice.ID}, fleet["worker-2"].calls)
+	assert.Equal(t, []string{"http://worker-2.internal:40002/health"}, health.urls)
+
+	// The source is torn down only after the route points at the target
+	assert.Equal(t, []string{"worker-2.internal:40002"}, proxy.dials())
+	assert.Equal(t, []string{"snapshot " + alice.ID, "remove " + alice.ID, "purge " + alice.ID}, fleet["worker-1"].calls)
+	assert.NoDirExists(t, filepath.Join(fleet["worker-1"].dir, alice.ID))
+
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, target.ID, *customer.NodeID)
+	assert.Equal(t, 40002, *customer.ContainerPort)
+	assert.Equal(t, "active", customer.Status)
+	assert.Contains(t, readComposeFile(t, svc, alice.ID), `"40002:`)
+
+	usage, err := svc.scheduler.Usage(ctx)
+	require.NoError(t, err)
+	for _, u := range usage {
+		if u.ID == target.ID {
+			assert.Equal(t, 2, u.Tenants, "reservation released once the move is recorded")
+		} else {
+			assert.Zero(t, u.Tenants)
+		}
+	}
+}
+
+func TestMigrateRollsBack(t *testing.T) {
+	tests := []struct {
+		name    string
+		breakIt func(fleet fakeFleet, proxy *fakeCaddy, health *healthChecks)
+		wantErr string
+	}{
+		{
+			name:    "start fails",
+			breakIt: func(fleet fakeFleet, _ *fakeCaddy, _ *healthChecks) { fleet["worker-2"].failStart = true },
+			wantErr: "start container",
+		},
+		{
+			name:    "never healthy",
+			breakIt: func(_ fakeFleet, _ *fakeCaddy, health *healthChecks) { health.err = errors.New("connection refused") },
+			wantErr: "did not become healthy",
+		},
+		{
+			name:    "route switch fails",
+			breakIt: func(_ fakeFleet, proxy *fakeCaddy, _ *healthChecks) { proxy.failPatch = true },
+			wantErr: "switch route",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fleet := fakeFleet{"worker-1": {}, "worker-2": {}}
+			svc, database, proxy, health := newMigrationService(t, fleet)
+			ctx := t.Context()
+
+			alice := provisionOn(t, svc, database, "alice@example.com", "worker-1")
+			source := nodeByName(t, database, "worker-1")
+			fleet["worker-1"].calls = nil
+			tt.breakIt(fleet, proxy, health)
+
+			err := svc.Migrate(ctx, alice.ID, nodeByName(t, database, "worker-2").ID)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+
+			// The tenant still runs where it was and the target is cleaned up
+			assert.Equal(t, []string{"snapshot " + alice.ID}, fleet["worker-1"].calls)
+			assert.DirExists(t, filepath.Join(fleet["worker-1"].dir, alice.ID))
+			assert.Contains(t, fleet["worker-2"].calls, "remove "+alice.ID)
+			assert.NoDirExists(t, filepath.Join(fleet["worker-2"].dir, alice.ID))
+			assert.Equal(t, []string{"worker-1.internal:40000"}, proxy.dials())
+
+			customer, err := database.GetCustomerByID(ctx, alice.ID)
+			require.NoError(t, err)
+			assert.Equal(t, source.ID, *customer.NodeID)
+			assert.Equal(t, 40000, *customer.ContainerPort)
+			assert.Contains(t, readComposeFile(t, svc, alice.ID), `"40000:`)
+
+			usage, err := svc.scheduler.Usage(ctx)
+			require.NoError(t, err)
+			for _, u := range usage {
+				assert.Equal(t, u.ID == source.ID, u.Tenants == 1, u.Name)
+			}
+		})
+	}
+}
+
+func TestMigrateRejects(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}}
+	svc, database, _, _ := newMigrationService(t, fleet)
+	ctx := t.Context()
+
+	alice := provisionOn(t, svc, database, "alice@example.com", "worker-1")
+	worker := nodeByName(t, database, "worker-1")
+
+	err := svc.Migrate(ctx, alice.ID, worker.ID)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already on node")
+
+	// No other node to move to
+	assert.ErrorIs(t, svc.Migrate(ctx, alice.ID, ""), scheduler.ErrNoCapacity)
+
+	pending := createNodeCustomer(t, database, "bob@example.com", "starter")
+	assert.ErrorIs(t, svc.Migrate(ctx, pending.ID, ""), ErrNotMigratable)
+
+	svc.UseNodes(nil, nil)
+	assert.Error(t, svc.Migrate(ctx, alice.ID, ""))
+}
+
+func TestDrain(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}, "worker-2": {}, "worker-3": {}}
+	svc, database, proxy, _ := newMigrationService(t, fleet)
+	ctx := t.Context()
+
+	alice := provisionOn(t, svc, database, "alice@example.com", "worker-1")
+	bob := provisionOn(t, svc, database, "bob@example.com", "worker-1")
+	require.NoError(t, svc.Suspend(ctx, bob.ID))
+	drained := nodeByName(t, database, "worker-1")
+
+	require.NoError(t, svc.Drain(ctx, drained.ID))
+
+	node, err := database.GetNode(ctx, drained.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.NodeCordoned, node.Status)
+
+	// Tenants spread over the remaining nodes and keep their status
+	moved := map[string]string{}
+	for _, id := range []string{alice.ID, bob.ID} {
+		customer, err := database.GetCustomerByID(ctx, id)
+		require.NoError(t, err)
+		require.NotNil(t, customer.NodeID)
+		assert.NotEqual(t, drained.ID, *customer.NodeID)
+		moved[id] = *customer.NodeID
+	}
+	assert.NotEqual(t, moved[alice.ID], moved[bob.ID])
+
+	suspended, err := database.GetCustomerByID(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "suspended", suspended.Status)
+	for name, fake := range fleet {
+		if name != drained.Name {
+			assert.NotContains(t, fake.calls, "start "+bob.ID, "suspended tenants move stopped")
+		}
+	}
+	assert.Len(t, proxy.dials(), 2)
+
+	// Nothing is left to drain
+	require.NoError(t, svc.Drain(ctx, drained.ID))
+	require.NoError(t, database.DeleteNode(ctx, drained.ID))
+}
@@ -0,0 +1,132 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"blytz/internal/db"
+	"blytz/internal/scheduler"
+)
+
+// Runtime runs tenants' compose projects on one host: the local Docker
+// daemon, or a worker node through its agent
+type Runtime interface {
+	Create(ctx context.Context, customerID string) error
+	Start(ctx context.Context, customerID string) error
+	Stop(ctx context.Context, customerID string) error
+	Restart(ctx context.Context, customerID string) error
+	Recreate(ctx context.Context, customerID string) error
+	Remove(ctx context.Context, customerID string) error
+
+	// Snapshot, Restore and Purge copy a tenant's whole directory between
+	// hosts when it migrates
+	Snapshot(ctx context.Context, customerID string, w io.Writer) error
+	Restore(ctx context.Context, customerID string, r io.Reader) error
+	Purge(ctx context.Context, customerID string) error
+}
+
+var _ Runtime = (*DockerProvisioner)(nil)
+
+// NodeDialer returns the runtime that drives a worker node
+type NodeDialer func(node *db.Node) Runtime
+
+// placement is where a tenant's containers run
+type placement struct {
+	runtime Runtime
+	// host is where Caddy reaches the tenant's published port, or its
+	// container on network
+	host    string
+	port    int
+	nodeID  string // empty on the control-plane host
+	network string // empty when the port is published
+}
+
+// UseNodes places new tenants on the worker nodes registered with the
+// scheduler, driving them through dial. While no nodes are registered tenants
+// keep running on the control-plane host.
+func (s *Service) UseNodes(sched *scheduler.Scheduler, dial NodeDialer) {
+	s.scheduler = sched
+	s.dialNode = dial
+}
+
+// UseNetwork makes new tenants on the control-plane host join the external
+// Docker network instead of publishing host ports. Caddy, and the control
+// plane for health probes, must be on the network to reach them.
+func (s *Service) UseNetwork(network string) {
+	s.network = network
+}
+
+// place chooses a node and host port for a new tenant and records them
+func (s *Service) place(ctx context.Context, customerID string, agentType *db.AgentType, plan *db.Plan) (*placement, error) {
+	if s.scheduler != nil {
+		req, err := scheduler.RequirementsForPlan(plan)
+		if err != nil {
+			return nil, err
+		}
+		p, err := s.scheduler.Place(ctx, customerID, req)
+		if err == nil {
+			return &placement{runtime: s.dialNode(&p.Node), host: p.Node.Address, port: p.Port, nodeID: p.Node.ID}, nil
+		}
+		if !errors.Is(err, scheduler.ErrNoNodes) {
+			return nil, fmt.Errorf("schedule tenant: %w", err)
+		}
+	}
+
+	if s.network != "" {
+		if err := s.db.SetCustomerNetwork(ctx, customerID, s.network, agentType.InternalPort); err != nil {
+			return nil, fmt.Errorf("record network: %w", err)
+		}
+		return &placement{runtime: s.docker, host: containerName(customerID), port: agentType.InternalPort, network: s.network}, nil
+	}
+
+	port, err := s.ports.Reserve(ctx, s.db, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("allocate port: %w", err)
+	}
+	return &placement{runtime: s.docker, host: "localhost", port: port}, nil
+}
+
+// runtimeFor returns the runtime managing a provisioned tenant's containers
+func (s *Service) runtimeFor(ctx context.Context, customer *db.Customer) (Runtime, error) {
+	p, err := s.locate(ctx, customer)
+	if err != nil {
+		return nil, err
+	}
+	return p.runtime, nil
+}
+
+// locate returns where a provisioned tenant's containers run
+func (s *Service) locate(ctx context.Context, customer *db.Customer) (*placement, error) {
+	port := 0
+	if customer.ContainerPort != nil {
+		port = *customer.ContainerPort
+	}
+	if customer.NodeID == nil {
+		if host := customer.InternalHost(); host != "" {
+			return &placement{runtime: s.docker, host: host, port: port, network: *customer.ContainerNetwork}, nil
+		}
+		return &placement{runtime: s.docker, host: "localhost", port: port}, nil
+	}
+	if s.dialNode == nil {
+		return nil, fmt.Errorf("tenant %s is on node %s but node agents are not configured", customer.ID, *customer.NodeID)
+	}
+	node, err := s.db.GetNode(ctx, *customer.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get node: %w", err)
+	}
+	return &placement{runtime: s.dialNode(node), host: node.Address, port: port, nodeID: node.ID}, nil
+}
+
+// release undoes a placement after provisioning failed
+func (s *Service) release(customerID string, p *placement) {
+	if p.nodeID == "" {
+		s.cleanup(customerID)
+		return
+	}
+	ctx := context.Background()
+	p.runtime.Remove(ctx, customerID)
+	s.db.ClearCustomerNode(ctx, customerID)
+	s.removeEnvFile(customerID)
+}
@@ -0,0 +1,382 @@
+package provisioner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/archive"
+	"blytz/internal/caddy"
+	"blytz/internal/db"
+	"blytz/internal/scheduler"
+)
+
+// fakeNode stands in for a worker node's agent, keeping tenant directories
+// under its own base directory
+type fakeNode struct {
+	mu        sync.Mutex
+	dir       string
+	calls     []string
+	failStart bool
+}
+
+func (n *fakeNode) record(op, customerID string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls = append(n.calls, op+" "+customerID)
+	if op == "start" && n.failStart {
+		return errors.New("container exited")
+	}
+	return nil
+}
+
+func (n *fakeNode) Snapshot(ctx context.Context, id string, w io.Writer) error {
+	n.record("snapshot", id)
+	return archive.Write(w, filepath.Join(n.dir, id))
+}
+
+func (n *fakeNode) Restore(ctx context.Context, id string, r io.Reader) error {
+	n.record("restore", id)
+	return archive.Extract(r, filepath.Join(n.dir, id))
+}
+
+func (n *fakeNode) Purge(ctx context.Context, id string) error {
+	n.record("purge", id)
+	return os.RemoveAll(filepath.Join(n.dir, id))
+}
+
+// Create stands in for the agent receiving the tenant's files
+func (n *fakeNode) Create(ctx context.Context, id string) error {
+	if err := os.MkdirAll(filepath.Join(n.dir, id), 0755); err != nil {
+		return err
+	}
+	return n.record("create", id)
+}
+
+func (n *fakeNode) Start(ctx context.Context, id string) error    { return n.record("start", id) }
+func (n *fakeNode) Stop(ctx context.Context, id string) error     { return n.record("stop", id) }
+func (n *fakeNode) Restart(ctx context.Context, id string) error  { return n.record("restart", id) }
+func (n *fakeNode) Recreate(ctx context.Context, id string) error { return n.record("recreate", id) }
+func (n *fakeNode) Remove(ctx context.Context, id string) error   { return n.record("remove", id) }
+
+// fakeFleet is a set of fake nodes addressed by name
+type fakeFleet map[string]*fakeNode
+
+func (f fakeFleet) dial(node *db.Node) Runtime {
+	return f[node.Name]
+}
+
+// fakeCaddy keeps the routes and TLS policies managed through Caddy's admin
+// API in memory, addressing them by @id
+type fakeCaddy struct {
+	mu     sync.Mutex
+	routes []caddy.Route
+	// domainRoutes are the routes of the HTTPS server serving custom domains
+	domainRoutes []caddy.Route
+	policies     []caddy.TLSPolicy
+	// failPatch refuses route replacements
+	failPatch bool
+}
+
+func (f *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := strings.CutPrefix(r.URL.Path, "/id/"); ok {
+		f.serveID(w, r, id)
+		return
+	}
+
+	switch r.Method + " " + r.URL.Path {
+	case "GET /config/apps/http/servers/srv0/routes":
+		json.NewEncoder(w).Encode(f.routes)
+	case "PATCH /config/apps/http/servers/srv0/routes", "POST /config/apps/http/servers/srv0/routes":
+		f.routes = nil
+		json.NewDecoder(r.Body).Decode(&f.routes)
+	case "GET /config/apps/http/servers/domains/routes":
+		json.NewEncoder(w).Encode(f.domainRoutes)
+	case "PATCH /config/apps/http/servers/domains/routes", "POST /config/apps/http/servers/domains/routes":
+		f.domainRoutes = nil
+		json.NewDecoder(r.Body).Decode(&f.domainRoutes)
+	case "GET /config/apps/tls/automation/policies":
+		json.NewEncoder(w).Encode(f.policies)
+	case "PATCH /config/apps/tls/automation/policies", "POST /config/apps/tls/automation/policies":
+		f.policies = nil
+		json.NewDecoder(r.Body).Decode(&f.policies)
+	default:
+		http.Error(w, "unexpected "+r.Method+" "+r.URL.Path, http.StatusMethodNotAllowed)
+	}
+}
+
+// serveID replaces or deletes the route or policy with the given @id
+func (f *fakeCaddy) serveID(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method == http.MethodPatch && f.failPatch {
+		http.Error(w, "config locked", http.StatusInternalServerError)
+		return
+	}
+	for _, routes := range []*[]caddy.Route{&f.routes, &f.domainRoutes} {
+		for i := range *routes {
+			if (*routes)[i].ID != id {
+				continue
+			}
+			if r.Method == http.MethodDelete {
+				*routes = append((*routes)[:i], (*routes)[i+1:]...)
+			} else {
+				json.NewDecoder(r.Body).Decode(&(*routes)[i])
+			}
+			return
+		}
+	}
+	for i := range f.policies {
+		if f.policies[i].ID != id {
+			continue
+		}
+		if r.Method == http.MethodDelete {
+			f.policies = append(f.policies[:i], f.policies[i+1:]...)
+		} else {
+			json.NewDecoder(r.Body).Decode(&f.policies[i])
+		}
+		return
+	}
+	http.Error(w, "unknown object ID", http.StatusNotFound)
+}
+
+// hosts returns each tenant route's host
+func (f *fakeCaddy) hosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return routeHosts(f.routes)
+}
+
+// domainHosts returns each custom domain route's host
+func (f *fakeCaddy) domainHosts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return routeHosts(f.domainRoutes)
+}
+
+func routeHosts(routes []caddy.Route) []string {
+	hosts := []string{}
+	for _, route := range routes {
+		hosts = append(hosts, route.Match[0].Host[0])
+	}
+	return hosts
+}
+
+// dials returns each route's upstream, tenant routes first
+func (f *fakeCaddy) dials() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dials := []string{}
+	for _, route := range append(append([]caddy.Route{}, f.routes...), f.domainRoutes...) {
+		dials = append(dials, route.Handle[0].Upstreams[0].Dial)
+	}
+	return dials
+}
+
+func newNodeService(t *testing.T, fleet fakeFleet) (*Service, *db.DB, *fakeCaddy) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	for name, fake := range fleet {
+		fake.dir = t.TempDir()
+		node := &db.Node{Name: name, Address: name + ".internal", AgentURL: "http://" + name + ".internal:9100",
+			MemoryMB: 1024, CPUs: 1, PortStart: 40000, PortEnd: 40009}
+		require.NoError(t, database.CreateNode(t.Context(), node))
+	}
+
+	proxy := &fakeCaddy{}
+	srv := httptest.NewServer(proxy)
+	t.Cleanup(srv.Close)
+
+	templatesDir := filepath.Join("..", "workspace", "templates")
+	svc := NewService(database, templatesDir, t.TempDir(), "http://control-plane:8080", 30000, 30005, caddy.NewClient(srv.URL, caddy.WithRetries(1, 0)), "blytz.cloud", nil)
+	svc.UseNodes(scheduler.New(database), fleet.dial)
+	svc.lookupHost = fakeLookup
+	return svc, database, proxy
+}
+
+// fakeLookup resolves the hosts tenants' egress rules name without DNS
+func fakeLookup(ctx context.Context, host string) ([]string, error) {
+	switch host {
+	case "control-plane":
+		return []string{"10.0.0.2"}, nil
+	case "registry.npmjs.org":
+		return []string{"104.16.0.35"}, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func createNodeCustomer(t *testing.T, database *db.DB, email, planID string) *db.Customer {
+	t.Helper()
+	customer, err := database.CreateCustomer(t.Context(), &db.CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+		PlanID:             planID,
+	})
+	require.NoError(t, err)
+	return customer
+}
+
+func TestServiceLifecycleOnNodes(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}, "worker-2": {}}
+	svc, database, proxy := newNodeService(t, fleet)
+	ctx := t.Context()
+
+	alice := createNodeCustomer(t, database, "alice@example.com", "pro")
+	bob := createNodeCustomer(t, database, "bob@example.com", "pro")
+	require.NoError(t, svc.Provision(ctx, alice.ID))
+	require.NoError(t, svc.Provision(ctx, bob.ID))
+
+	// Each 1G tenant fills a node, so they land on different ones
+	aliceNode := nodeOf(t, database, alice.ID)
+	bobNode := nodeOf(t, database, bob.ID)
+	assert.NotEqual(t, aliceNode.ID, bobNode.ID)
+	assert.Equal(t, []string{"create " + alice.ID, "start " + alice.ID}, fleet[aliceNode.Name].calls)
+	assert.Equal(t, []string{"create " + bob.ID, "start " + bob.ID}, fleet[bobNode.Name].calls)
+
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "active", customer.Status)
+	assert.Equal(t, 40000, *customer.ContainerPort)
+	assert.ElementsMatch(t, []string{"worker-1.internal:40000", "worker-2.internal:40000"}, proxy.dials())
+
+	// The control-plane host's port pool is untouched
+	ports, err := database.GetAllocatedPorts(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ports)
+
+	// The fleet is full
+	carol := createNodeCustomer(t, database, "carol@example.com", "starter")
+	err = svc.Provision(ctx, carol.ID)
+	require.ErrorIs(t, err, scheduler.ErrNoCapacity)
+	customer, err = database.GetCustomerByID(ctx, carol.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", customer.Status)
+	assert.Nil(t, customer.NodeID)
+
+	// Later operations go to the tenant's node
+	node := fleet[aliceNode.Name]
+	node.calls = nil
+	require.NoError(t, svc.Suspend(ctx, alice.ID))
+	require.NoError(t, svc.Resume(ctx, alice.ID))
+	require.NoError(t, svc.Restart(ctx, alice.ID))
+	require.NoError(t, svc.ChangePlan(ctx, alice.ID, "starter"))
+	require.NoError(t, svc.Terminate(ctx, alice.ID))
+	assert.Equal(t, []string{"stop " + alice.ID, "start " + alice.ID, "restart " + alice.ID,
+		"recreate " + alice.ID, "remove " + alice.ID}, node.calls)
+	assert.Len(t, fleet[bobNode.Name].calls, 2)
+
+	customer, err = database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "cancelled", customer.Status)
+	assert.Nil(t, customer.NodeID)
+	assert.Nil(t, customer.ContainerPort)
+
+	// The freed node takes the waiting tenant
+	require.NoError(t, svc.Provision(ctx, carol.ID))
+	assert.Equal(t, aliceNode.ID, nodeOf(t, database, carol.ID).ID)
+}
+
+func TestServiceProvisionOnNodeRollsBack(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {failStart: true}}
+	svc, database, proxy := newNodeService(t, fleet)
+	ctx := t.Context()
+
+	customer := createNodeCustomer(t, database, "alice@example.com", "starter")
+	err := svc.Provision(ctx, customer.ID)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "start container")
+
+	assert.Equal(t, []string{"create " + customer.ID, "start " + customer.ID, "remove " + customer.ID}, fleet["worker-1"].calls)
+	assert.Empty(t, proxy.dials())
+
+	updated, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", updated.Status)
+	assert.Nil(t, updated.NodeID)
+	assert.Nil(t, updated.ContainerPort)
+}
+
+func TestServiceNodeTenantWithoutAgents(t *testing.T) {
+	svc, database, _ := newNodeService(t, fakeFleet{"worker-1": {}})
+	ctx := t.Context()
+
+	customer := createNodeCustomer(t, database, "alice@example.com", "starter")
+	require.NoError(t, svc.Provision(ctx, customer.ID))
+
+	// A control plane restarted without NODE_AGENT_TOKEN cannot reach the node
+	svc.UseNodes(nil, nil)
+	err := svc.Suspend(ctx, customer.ID)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "node agents are not configured")
+}
+
+func nodeOf(t *testing.T, database *db.DB, customerID string) *db.Node {
+	t.Helper()
+	customer, err := database.GetCustomerByID(t.Context(), customerID)
+	require.NoError(t, err)
+	require.NotNil(t, customer.NodeID)
+	node, err := database.GetNode(t.Context(), *customer.NodeID)
+	require.NoError(t, err)
+	return node
+}
+
+func TestPlaceOnTenantNetwork(t *testing.T) {
+	svc, database, _ := newNodeService(t, fakeFleet{})
+	svc.UseNetwork("blytz-tenants")
+	ctx := t.Context()
+
+	customer := createNodeCustomer(t, database, "alice@example.com", "starter")
+	agentType, err := database.GetAgentType(ctx, customer.AgentTypeID)
+	require.NoError(t, err)
+	plan, err := database.GetPlan(ctx, customer.PlanID)
+	require.NoError(t, err)
+
+	p, err := svc.place(ctx, customer.ID, agentType, plan)
+	require.NoError(t, err)
+	assert.Equal(t, "blytz-"+customer.ID, p.host)
+	assert.Equal(t, agentType.InternalPort, p.port)
+
+	// No host ports are taken
+	ports, err := database.GetAllocatedPorts(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, ports)
+
+	customer, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	located, err := svc.locate(ctx, customer)
+	require.NoError(t, err)
+	assert.Equal(t, p.host, located.host)
+	assert.Equal(t, p.port, located.port)
+	assert.Equal(t, "blytz-tenants", located.network)
+
+	// A regenerated compose file keeps the tenant on the network
+	require.NoError(t, svc.compose.GenerateEnvFile(customer.ID, map[string]string{}))
+	require.NoError(t, svc.ChangePlan(ctx, customer.ID, "pro"))
+	content := readComposeFile(t, svc, customer.ID)
+	assert.Contains(t, content, "name: blytz-tenants")
+	assert.NotContains(t, content, "ports:")
+
+	svc.release(customer.ID, p)
+	customer, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Nil(t, customer.ContainerNetwork)
+	assert.Nil(t, customer.ContainerPort)
+}
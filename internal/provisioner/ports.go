@@ -3,14 +3,22 @@ package provisioner
 import (
 	"context"
 	"fmt"
+	"net"
 	"sync"
+
+	"blytz/internal/db"
 )
 
+// PortAllocator reserves host port blocks on the control-plane host. The
+// port_allocations table is authoritative; the allocator keeps a count of it,
+// reloaded by Recover, so the pool can be reported without a query.
 type PortAllocator struct {
 	mu        sync.Mutex
 	startPort int
 	endPort   int
 	allocated map[int]bool
+	// available reports whether nothing else on the host listens on a port
+	available func(port int) bool
 }
 
 func NewPortAllocator(startPort, endPort int) *PortAllocator {
@@ -18,43 +26,79 @@ func NewPortAllocator(startPort, endPort int) *PortAllocator {
 		startPort: startPort,
 		endPort:   endPort,
 		allocated: make(map[int]bool),
+		available: hostPortFree,
 	}
 }
 
-func (pa *PortAllocator) LoadAllocatedPorts(ctx context.Context, db interface {
-	GetAllocatedPorts(context.Context) ([]int, error)
-}) error {
+// Reserve reserves a block of db.TenantPorts ports for a customer in the
+// database, skipping ports something else on the host already listens on, and
+// returns the first port, which becomes the customer's container port
+func (pa *PortAllocator) Reserve(ctx context.Context, database *db.DB, customerID string) (int, error) {
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
-	ports, err := db.GetAllocatedPorts(ctx)
+	port, err := database.ReservePortBlock(ctx, customerID, pa.startPort, pa.endPort, pa.available)
 	if err != nil {
-		return fmt.Errorf("load allocated ports: %w", err)
+		return 0, err
+	}
+	for p := port; p < port+db.TenantPorts; p++ {
+		pa.allocated[p] = true
 	}
+	return port, nil
+}
+
+// Release frees every port reserved for a customer
+func (pa *PortAllocator) Release(ctx context.Context, database *db.DB, customerID string) error {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
 
+	ports, err := database.ReleaseCustomerPorts(ctx, customerID)
+	if err != nil {
+		return err
+	}
 	for _, port := range ports {
-		pa.allocated[port] = true
+		delete(pa.allocated, port)
 	}
-
 	return nil
 }
 
-func (pa *PortAllocator) AllocatePort() (int, error) {
+// Recover reconciles the recorded allocations with the tenants running on the
+// host and reloads the pool from them. It returns how many allocations were
+// restored and released.
+func (pa *PortAllocator) Recover(ctx context.Context, database *db.DB) (restored, released int, err error) {
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
-	for port := pa.startPort; port <= pa.endPort; port++ {
-		if !pa.allocated[port] {
-			pa.allocated[port] = true
-			return port, nil
-		}
+	restored, released, err = database.RecoverPortAllocations(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("recover port allocations: %w", err)
 	}
-	return 0, fmt.Errorf("no available ports in range %d-%d", pa.startPort, pa.endPort)
+	ports, err := database.GetAllocatedPorts(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load allocated ports: %w", err)
+	}
+
+	pa.allocated = make(map[int]bool, len(ports))
+	for _, port := range ports {
+		pa.allocated[port] = true
+	}
+	return restored, released, nil
 }
 
-func (pa *PortAllocator) ReleasePort(port int) {
+// Stats reports how many ports are allocated out of the pool's capacity
+func (pa *PortAllocator) Stats() (allocated, capacity int) {
 	pa.mu.Lock()
 	defer pa.mu.Unlock()
 
-	delete(pa.allocated, port)
+	return len(pa.allocated), pa.endPort - pa.startPort + 1
+}
+
+// hostPortFree reports whether a TCP port can be bound on all interfaces
+func hostPortFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
 }
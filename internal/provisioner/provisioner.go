@@ -3,10 +3,13 @@ package provisioner
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 
+	"blytz/internal/archive"
+	"blytz/internal/egress"
 	"blytz/internal/telegram"
 )
 
@@ -16,11 +19,13 @@ type Provisioner interface {
 	Suspend(ctx context.Context, customerID string) error
 	Resume(ctx context.Context, customerID string) error
 	Terminate(ctx context.Context, customerID string) error
+	ChangePlan(ctx context.Context, customerID, planID string) error
 	ValidateBotToken(token string) (*telegram.BotInfo, error)
 }
 
 type DockerProvisioner struct {
-	baseDir string
+	baseDir  string
+	firewall *egress.Firewall
 }
 
 func NewDockerProvisioner(baseDir string) *DockerProvisioner {
@@ -34,6 +39,9 @@ func (dp *DockerProvisioner) Create(ctx context.Context, customerID string) erro
 	if _, err := os.Stat(composePath); os.IsNotExist(err) {
 		return fmt.Errorf("docker-compose.yml not found for customer %s", customerID)
 	}
+	if err := dp.applyEgress(ctx, customerID); err != nil {
+		return err
+	}
 
 	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", composePath, "create")
 	cmd.Dir = customerDir
@@ -49,6 +57,9 @@ func (dp *DockerProvisioner) Create(ctx context.Context, customerID string) erro
 func (dp *DockerProvisioner) Start(ctx context.Context, customerID string) error {
 	customerDir := filepath.Join(dp.baseDir, customerID)
 	composePath := filepath.Join(customerDir, "docker-compose.yml")
+	if err := dp.applyEgress(ctx, customerID); err != nil {
+		return err
+	}
 
 	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", composePath, "up", "-d")
 	cmd.Dir = customerDir
@@ -76,6 +87,44 @@ func (dp *DockerProvisioner) Stop(ctx context.Context, customerID string) error
 	return nil
 }
 
+func (dp *DockerProvisioner) Restart(ctx context.Context, customerID string) error {
+	customerDir := filepath.Join(dp.baseDir, customerID)
+	composePath := filepath.Join(customerDir, "docker-compose.yml")
+	if err := dp.applyEgress(ctx, customerID); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", composePath, "restart")
+	cmd.Dir = customerDir
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("restart container: %w (output: %s)", err, string(output))
+	}
+
+	return nil
+}
+
+// Recreate replaces the containers so changes to the compose file take effect.
+// Workspace and data are bind mounts and carry over.
+func (dp *DockerProvisioner) Recreate(ctx context.Context, customerID string) error {
+	customerDir := filepath.Join(dp.baseDir, customerID)
+	composePath := filepath.Join(customerDir, "docker-compose.yml")
+	if err := dp.applyEgress(ctx, customerID); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", "compose", "-f", composePath, "up", "-d", "--force-recreate")
+	cmd.Dir = customerDir
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("recreate container: %w (output: %s)", err, string(output))
+	}
+
+	return nil
+}
+
 func (dp *DockerProvisioner) Remove(ctx context.Context, customerID string) error {
 	customerDir := filepath.Join(dp.baseDir, customerID)
 	composePath := filepath.Join(customerDir, "docker-compose.yml")
@@ -87,14 +136,46 @@ func (dp *DockerProvisioner) Remove(ctx context.Context, customerID string) erro
 	if err != nil {
 		return fmt.Errorf("remove container: %w (output: %s)", err, string(output))
 	}
+	if dp.firewall != nil {
+		if err := dp.firewall.Remove(ctx, customerID); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (dp *DockerProvisioner) GetStatus(ctx context.Context, customerID string) (string, error) {
-	containerName := fmt.Sprintf("blytz-%s", customerID)
+// Snapshot writes the tenant's whole directory, agent data included, to w as
+// a gzipped tarball
+func (dp *DockerProvisioner) Snapshot(ctx context.Context, customerID string, w io.Writer) error {
+	if err := archive.Write(w, filepath.Join(dp.baseDir, customerID)); err != nil {
+		return fmt.Errorf("snapshot tenant: %w", err)
+	}
+	return nil
+}
 
-	cmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.State.Status}}", containerName)
+// Restore replaces the tenant's directory with a snapshot
+func (dp *DockerProvisioner) Restore(ctx context.Context, customerID string, r io.Reader) error {
+	customerDir := filepath.Join(dp.baseDir, customerID)
+	if err := os.RemoveAll(customerDir); err != nil {
+		return fmt.Errorf("clear tenant directory: %w", err)
+	}
+	if err := archive.Extract(r, customerDir); err != nil {
+		return fmt.Errorf("restore tenant: %w", err)
+	}
+	return nil
+}
+
+// Purge deletes the tenant's directory
+func (dp *DockerProvisioner) Purge(ctx context.Context, customerID string) error {
+	if err := os.RemoveAll(filepath.Join(dp.baseDir, customerID)); err != nil {
+		return fmt.Errorf("purge tenant: %w", err)
+	}
+	return nil
+}
+
+func (dp *DockerProvisioner) GetStatus(ctx context.Context, customerID string) (string, error) {
+	cmd := exec.CommandContext(ctx, "docker", "inspect", "-f", "{{.State.Status}}", containerName(customerID))
 	output, err := cmd.CombinedOutput()
 
 	if err != nil {
@@ -106,3 +187,9 @@ func (dp *DockerProvisioner) GetStatus(ctx context.Context, customerID string) (
 
 	return string(output), nil
 }
+
+// containerName is the name of a tenant's agent container, which is also its
+// host name on Docker networks
+func containerName(customerID string) string {
+	return "blytz-" + customerID
+}
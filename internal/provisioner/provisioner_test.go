@@ -3,49 +3,9 @@ package provisioner
 import (
 	"os"
 	"testing"
-)
-
-func TestPortAllocator(t *testing.T) {
-	allocator := NewPortAllocator(30000, 30005)
-
-	// Test allocation
-	port1, err := allocator.AllocatePort()
-	if err != nil {
-		t.Fatalf("First allocation failed: %v", err)
-	}
-	if port1 != 30000 {
-		t.Errorf("Expected port 30000, got %d", port1)
-	}
-
-	port2, err := allocator.AllocatePort()
-	if err != nil {
-		t.Fatalf("Second allocation failed: %v", err)
-	}
-	if port2 != 30001 {
-		t.Errorf("Expected port 30001, got %d", port2)
-	}
-
-	// Test release and reallocation
-	allocator.ReleasePort(30000)
-	port3, err := allocator.AllocatePort()
-	if err != nil {
-		t.Fatalf("Reallocation after release failed: %v", err)
-	}
-	if port3 != 30000 {
-		t.Errorf("Expected port 30000 after release, got %d", port3)
-	}
-
-	// Test exhaustion
-	allocator.AllocatePort() // 30002
-	allocator.AllocatePort() // 30003
-	allocator.AllocatePort() // 30004
-	allocator.AllocatePort() // 30005
 
-	_, err = allocator.AllocatePort() // Should fail
-	if err == nil {
-		t.Error("Expected error when no ports available, got nil")
-	}
-}
+	"blytz/internal/db"
+)
 
 func TestComposeGenerator(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -59,12 +19,9 @@ func TestComposeGenerator(t *testing.T) {
 		InternalPort:       18789,
 		InternalPortBridge: 18790,
 		BaseImage:          "node:22-bookworm",
-		LLMEnvKey:          "OPENAI_API_KEY",
-		LLMKey:             "sk-test-key",
-		GatewayToken:       "test-token",
 		HealthEndpoint:     "/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 
 	err := generator.Generate(config)
@@ -78,3 +35,32 @@ func TestComposeGenerator(t *testing.T) {
 		t.Errorf("Expected compose file at %s", expectedPath)
 	}
 }
+
+func TestPortAllocatorStats(t *testing.T) {
+	database, err := db.New(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer database.Close()
+	if err := database.Migrate(); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	pa := NewPortAllocator(30000, 30009)
+	pa.available = func(int) bool { return true }
+
+	allocated, capacity := pa.Stats()
+	if allocated != 0 || capacity != 10 {
+		t.Errorf("Expected 0/10, got %d/%d", allocated, capacity)
+	}
+
+	customer := createNodeCustomer(t, database, "stats@example.com", "starter")
+	if _, err := pa.Reserve(t.Context(), database, customer.ID); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+
+	allocated, _ = pa.Stats()
+	if allocated != db.TenantPorts {
+		t.Errorf("Expected %d allocated ports, got %d", db.TenantPorts, allocated)
+	}
+}
@@ -0,0 +1,104 @@
+package provisioner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/caddy"
+)
+
+// routedStatuses are the statuses of tenants Caddy routes to. Tenants being
+// provisioned are included so a sync racing their first route never drops it.
+var routedStatuses = []string{"provisioning", "active", "degraded", "suspended"}
+
+// routeTenant points the tenant's subdomain and verified custom domain at target
+func (s *Service) routeTenant(ctx context.Context, customerID, target string) error {
+	subdomain := fmt.Sprintf("%s.%s", customerID, s.baseDomain)
+	if err := s.caddy.UpsertRoute(ctx, caddy.NewRoute(caddy.TenantRouteID(customerID), subdomain, target)); err != nil {
+		return err
+	}
+	if domain := s.verifiedDomain(ctx, customerID); domain != "" {
+		if err := s.caddy.AddDomain(ctx, customerID, domain, target); err != nil {
+			return fmt.Errorf("route custom domain: %w", err)
+		}
+	}
+	return nil
+}
+
+// unrouteTenant removes the route to the tenant's subdomain. Routes already
+// gone are skipped.
+func (s *Service) unrouteTenant(ctx context.Context, customerID string) error {
+	if s.caddy == nil {
+		return nil
+	}
+	if err := s.caddy.DeleteRoute(ctx, caddy.TenantRouteID(customerID)); err != nil && !errors.Is(err, caddy.ErrRouteNotFound) {
+		return err
+	}
+	return nil
+}
+
+// SyncRoutes makes Caddy's tenant routes and custom domain TLS policies match
+// the database, restoring routes lost when Caddy restarted and removing those
+// of tenants that are gone. It fails without changing anything if any
+// tenant cannot be located, so a partial view never drops live routes.
+func (s *Service) SyncRoutes(ctx context.Context) error {
+	if s.caddy == nil {
+		return nil
+	}
+
+	ids, err := s.db.ListCustomerIDsByStatus(ctx, routedStatuses...)
+	if err != nil {
+		return fmt.Errorf("list routed tenants: %w", err)
+	}
+
+	var routes, domainRoutes []caddy.Route
+	var policies []caddy.TLSPolicy
+	for _, id := range ids {
+		customer, err := s.db.GetCustomerByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("get customer %s: %w", id, err)
+		}
+		if customer.ContainerPort == nil {
+			continue
+		}
+		p, err := s.locate(ctx, customer)
+		if err != nil {
+			return err
+		}
+
+		target := fmt.Sprintf("%s:%d", p.host, p.port)
+		routes = append(routes, caddy.NewRoute(caddy.TenantRouteID(id), fmt.Sprintf("%s.%s", id, s.baseDomain), target))
+		if domain := s.verifiedDomain(ctx, id); domain != "" {
+			domainRoutes = append(domainRoutes, caddy.NewRoute(caddy.DomainRouteID(id), domain, target))
+			policies = append(policies, caddy.NewDomainPolicy(id, domain))
+		}
+	}
+
+	if err := s.caddy.Sync(ctx, routes, domainRoutes, policies); err != nil {
+		return fmt.Errorf("sync caddy: %w", err)
+	}
+	return nil
+}
+
+// RunRouteSync syncs Caddy's routes with the database every interval until
+// ctx is cancelled
+func (s *Service) RunRouteSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.SyncRoutes(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Error("Failed to sync Caddy routes", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
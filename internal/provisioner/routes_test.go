@@ -0,0 +1,41 @@
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/caddy"
+)
+
+func TestSyncRoutes(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}}
+	svc, database, proxy := newNodeService(t, fleet)
+	ctx := t.Context()
+
+	alice := createNodeCustomer(t, database, "alice@example.com", "starter")
+	bob := createNodeCustomer(t, database, "bob@example.com", "starter")
+	carol := createNodeCustomer(t, database, "carol@example.com", "starter")
+	require.NoError(t, svc.Provision(ctx, alice.ID))
+	require.NoError(t, svc.Provision(ctx, bob.ID))
+	_, err := database.SetCustomDomain(ctx, alice.ID, "assistant.alice.example", "token")
+	require.NoError(t, err)
+	require.NoError(t, database.VerifyCustomDomain(ctx, alice.ID, "assistant.alice.example"))
+
+	// Caddy restarted from its Caddyfile and a cancelled tenant's route lingers
+	catchAll := caddy.Route{Match: []caddy.Match{{Host: []string{"blytz.cloud"}}}, Handle: []caddy.Handler{{Handler: "reverse_proxy"}}}
+	proxy.routes = []caddy.Route{caddy.NewRoute(caddy.TenantRouteID("gone"), "gone.blytz.cloud", "localhost:30004"), catchAll}
+	proxy.policies = nil
+
+	require.NoError(t, svc.SyncRoutes(ctx))
+	assert.Equal(t, []string{alice.ID + ".blytz.cloud", bob.ID + ".blytz.cloud", "blytz.cloud"}, proxy.hosts())
+	assert.Equal(t, []string{"assistant.alice.example"}, proxy.domainHosts())
+	assert.Equal(t, []caddy.TLSPolicy{caddy.NewDomainPolicy(alice.ID, "assistant.alice.example")}, proxy.policies)
+	assert.NotContains(t, proxy.hosts(), carol.ID+".blytz.cloud", "unprovisioned tenants get no route")
+
+	require.NoError(t, svc.Terminate(ctx, bob.ID))
+	require.NoError(t, svc.SyncRoutes(ctx))
+	assert.Equal(t, []string{alice.ID + ".blytz.cloud", "blytz.cloud"}, proxy.hosts())
+	assert.Equal(t, []string{"assistant.alice.example"}, proxy.domainHosts())
+}
@@ -3,12 +3,18 @@ package provisioner
 import (
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"time"
 
 	"blytz/internal/caddy"
 	"blytz/internal/db"
+	"blytz/internal/egress"
+	"blytz/internal/llmproxy"
+	"blytz/internal/scheduler"
 	"blytz/internal/telegram"
+	"blytz/internal/telemetry"
 	"blytz/internal/workspace"
 
 	"github.com/google/uuid"
@@ -19,163 +25,331 @@ func generateGatewayToken() string {
 	return uuid.New().String()
 }
 
+// generateLLMProxyToken returns the credential a tenant uses in place of an LLM API key
+func generateLLMProxyToken() string {
+	return "blytz-llm-" + uuid.New().String()
+}
+
 type Service struct {
-	db         *db.DB
-	workspace  *workspace.Generator
-	docker     *DockerProvisioner
-	compose    *ComposeGenerator
-	ports      *PortAllocator
-	caddy      *caddy.Client
-	logger     *zap.Logger
-	baseDomain string
-	openAIKey  string
-	baseDir    string
-	portStart  int
-	portEnd    int
-}
-
-func NewService(database *db.DB, templatesDir, baseDir, openAIKey string, portStart, portEnd int, caddyClient *caddy.Client, baseDomain string, logger *zap.Logger) *Service {
+	db          *db.DB
+	workspace   *workspace.Generator
+	docker      *DockerProvisioner
+	compose     *ComposeGenerator
+	ports       *PortAllocator
+	caddy       *caddy.Client
+	logger      *zap.Logger
+	baseDomain  string
+	llmProxyURL string
+	baseDir     string
+	portStart   int
+	portEnd     int
+	scheduler   *scheduler.Scheduler
+	dialNode    NodeDialer
+	network     string
+	// lookupHost resolves the hosts in tenants' egress allowlists
+	lookupHost egress.Resolver
+	hardening  Hardening
+
+	// migrationHealthTimeout bounds the wait for a migrated or upgraded tenant
+	// to answer its health endpoint, polled with checkHealth
+	migrationHealthTimeout time.Duration
+	checkHealth            func(ctx context.Context, url string) error
+}
+
+// NewService creates a provisioning service. llmProxyURL is the control plane's
+// base URL as reachable from tenant containers, used for proxied LLM calls.
+func NewService(database *db.DB, templatesDir, baseDir, llmProxyURL string, portStart, portEnd int, caddyClient *caddy.Client, baseDomain string, logger *zap.Logger) *Service {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	ports := NewPortAllocator(portStart, portEnd)
 	return &Service{
-		db:         database,
-		workspace:  workspace.NewWithBaseDir(templatesDir, baseDir),
-		docker:     NewDockerProvisioner(baseDir),
-		compose:    NewComposeGenerator(baseDir),
-		ports:      NewPortAllocator(portStart, portEnd),
-		caddy:      caddyClient,
-		logger:     logger,
-		baseDomain: baseDomain,
-		openAIKey:  openAIKey,
-		baseDir:    baseDir,
-		portStart:  portStart,
-		portEnd:    portEnd,
+		db:          database,
+		workspace:   workspace.NewWithBaseDir(templatesDir, baseDir),
+		docker:      NewDockerProvisioner(baseDir),
+		compose:     NewComposeGenerator(baseDir),
+		ports:       ports,
+		caddy:       caddyClient,
+		logger:      logger,
+		baseDomain:  baseDomain,
+		llmProxyURL: llmProxyURL,
+		baseDir:     baseDir,
+		portStart:   portStart,
+		portEnd:     portEnd,
+		lookupHost:  net.DefaultResolver.LookupHost,
+		hardening:   DefaultHardening(),
+
+		migrationHealthTimeout: 2 * time.Minute,
+		checkHealth:            checkHealth,
 	}
 }
 
+// UseHardening sets the security profile tenant containers run under. Running
+// tenants pick it up when their compose file is next written.
+func (s *Service) UseHardening(h Hardening) {
+	s.hardening = h
+}
+
 func (s *Service) Provision(ctx context.Context, customerID string) error {
+	start := time.Now()
+	agentTypeID, err := s.provision(ctx, customerID)
+	telemetry.ObserveProvisioning(agentTypeID, err, time.Since(start))
+	return err
+}
+
+// provision does the work of Provision and reports the agent type for metrics
+func (s *Service) provision(ctx context.Context, customerID string) (string, error) {
 	customer, err := s.db.GetCustomerByID(ctx, customerID)
 	if err != nil {
-		return fmt.Errorf("get customer: %w", err)
+		return "", fmt.Errorf("get customer: %w", err)
 	}
 
 	if err := s.db.UpdateCustomerStatus(ctx, customerID, "provisioning"); err != nil {
-		return fmt.Errorf("update status to provisioning: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("update status to provisioning: %w", err)
 	}
 
 	// Get agent type configuration
 	agentType, err := s.db.GetAgentType(ctx, customer.AgentTypeID)
 	if err != nil {
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("get agent type: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("get agent type: %w", err)
+	}
+
+	plan, err := s.db.GetPlan(ctx, customer.PlanID)
+	if err != nil {
+		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
+		return customer.AgentTypeID, fmt.Errorf("get plan: %w", err)
 	}
 
 	// Get LLM provider configuration
 	llmProvider, err := s.db.GetLLMProvider(ctx, customer.LLMProviderID)
 	if err != nil {
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("get llm provider: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("get llm provider: %w", err)
 	}
 
 	if err := s.workspace.Generate(customerID, customer.AssistantName, customer.CustomInstructions); err != nil {
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("generate workspace: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("generate workspace: %w", err)
 	}
 
-	port, err := s.ports.AllocatePort()
+	placed, err := s.place(ctx, customerID, agentType, plan)
 	if err != nil {
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("allocate port: %w", err)
+		return customer.AgentTypeID, err
 	}
+	port := placed.port
 
-	if err := s.db.AllocatePort(ctx, customerID, port); err != nil {
-		s.ports.ReleasePort(port)
-		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("record port allocation: %w", err)
-	}
-
-	// Also update the customer's container_port field
-	if err := s.db.UpdateCustomerPort(ctx, customerID, port); err != nil {
-		s.cleanup(customerID, port)
-		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("update customer port: %w", err)
+	// New tenants run the agent type's latest pre-built image and stay on it
+	// until they are upgraded
+	if agentType.ImageDigest != "" {
+		if err := s.db.SetCustomerImage(ctx, customerID, agentType.ImageDigest); err != nil {
+			s.release(customerID, placed)
+			s.db.UpdateCustomerStatus(ctx, customerID, "pending")
+			return customer.AgentTypeID, fmt.Errorf("pin agent image: %w", err)
+		}
+		customer.ImageDigest = &agentType.ImageDigest
 	}
 
 	// Build agent configuration
 	gatewayToken := generateGatewayToken()
-	agentConfig := AgentConfig{
-		CustomerID:         customerID,
-		AgentType:          customer.AgentTypeID,
-		ExternalPort:       port,
-		ExternalPortBridge: port + 1,
-		InternalPort:       agentType.InternalPort,
-		InternalPortBridge: agentType.InternalPortBridge,
-		BaseImage:          agentType.BaseImage,
-		LLMEnvKey:          llmProvider.EnvKey,
-		LLMKey:             s.openAIKey,
-		GatewayToken:       gatewayToken,
-		HealthEndpoint:     agentType.HealthEndpoint,
-		MinMemory:          agentType.MinMemory,
-		MinCPU:             agentType.MinCPU,
+	if err := s.db.SetGatewayToken(ctx, customerID, gatewayToken); err != nil {
+		s.release(customerID, placed)
+		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
+		return customer.AgentTypeID, fmt.Errorf("store gateway token: %w", err)
 	}
 
-	// Generate environment variables map
-	envVars := map[string]string{
-		llmProvider.EnvKey: s.openAIKey,
-	}
-	if customer.AgentTypeID == "myrai" {
-		envVars["MYRAI_GATEWAY_TOKEN"] = gatewayToken
+	// Tenants never see the platform's LLM keys; proxied providers get a
+	// per-tenant proxy token metered and capped by the control plane
+	llmToken := ""
+	if llmproxy.Proxied(llmProvider.ID) {
+		llmToken = generateLLMProxyToken()
+		if err := s.db.SetLLMProxyToken(ctx, customerID, llmToken); err != nil {
+			s.release(customerID, placed)
+			s.db.UpdateCustomerStatus(ctx, customerID, "pending")
+			return customer.AgentTypeID, fmt.Errorf("store llm proxy token: %w", err)
+		}
 	}
+	agentConfig := newAgentConfig(customer, agentType, plan, port)
+	agentConfig.Network = placed.network
+	agentConfig.Hardening = s.hardening
 
-	if err := s.compose.GenerateEnvFile(customerID, envVars); err != nil {
-		s.cleanup(customerID, port)
+	if err := s.compose.GenerateEnvFile(customerID, s.tenantEnv(customer.AgentTypeID, llmProvider, gatewayToken, llmToken)); err != nil {
+		s.release(customerID, placed)
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("generate env file: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("generate env file: %w", err)
 	}
 
 	if err := s.compose.Generate(agentConfig); err != nil {
-		s.cleanup(customerID, port)
+		s.release(customerID, placed)
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("generate compose: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("generate compose: %w", err)
+	}
+
+	if err := s.writeEgressRules(ctx, customer, agentType, llmProvider, plan); err != nil {
+		s.release(customerID, placed)
+		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
+		return customer.AgentTypeID, err
 	}
 
 	// Generate agent-specific config
 	if customer.AgentTypeID == "openclaw" {
 		if err := workspace.GenerateOpenClawConfig(s.baseDir, customerID, customer.TelegramBotToken, gatewayToken, port); err != nil {
-			s.cleanup(customerID, port)
+			s.release(customerID, placed)
 			s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-			return fmt.Errorf("generate openclaw config: %w", err)
+			return customer.AgentTypeID, fmt.Errorf("generate openclaw config: %w", err)
 		}
 	}
 
-	if err := s.docker.Create(ctx, customerID); err != nil {
-		s.cleanup(customerID, port)
+	if err := placed.runtime.Create(ctx, customerID); err != nil {
+		s.release(customerID, placed)
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("create container: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("create container: %w", err)
 	}
 
-	if err := s.docker.Start(ctx, customerID); err != nil {
-		s.docker.Remove(ctx, customerID)
-		s.cleanup(customerID, port)
+	if err := placed.runtime.Start(ctx, customerID); err != nil {
+		s.release(customerID, placed)
 		s.db.UpdateCustomerStatus(ctx, customerID, "pending")
-		return fmt.Errorf("start container: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("start container: %w", err)
 	}
 
 	if err := s.db.UpdateCustomerStatus(ctx, customerID, "active"); err != nil {
-		return fmt.Errorf("update status to active: %w", err)
+		return customer.AgentTypeID, fmt.Errorf("update status to active: %w", err)
 	}
 
+	// Domains verified before the tenant was provisioned are routed now too.
+	// Routes that fail to be added are restored by the next route sync.
 	if s.caddy != nil {
-		subdomain := fmt.Sprintf("%s.%s", customerID, s.baseDomain)
-		target := fmt.Sprintf("localhost:%d", port)
-		if err := s.caddy.AddSubdomain(subdomain, target); err != nil {
-			s.logger.Warn("Failed to add Caddy subdomain (non-fatal)", zap.Error(err))
+		if err := s.routeTenant(ctx, customerID, fmt.Sprintf("%s:%d", placed.host, port)); err != nil {
+			s.logger.Warn("Failed to add Caddy routes (non-fatal)", zap.Error(err))
+		}
+	}
+
+	return customer.AgentTypeID, nil
+}
+
+// tenantEnv returns the secrets written to a tenant's .env.secret: the gateway
+// token for agents that read it from the environment, whatever their provider,
+// and for proxied providers the LLM proxy token and base URL
+func (s *Service) tenantEnv(agentTypeID string, llmProvider *db.LLMProvider, gatewayToken, llmToken string) map[string]string {
+	envVars := map[string]string{}
+	if llmToken != "" {
+		envVars = llmproxy.TenantEnv(llmProvider.ID, llmProvider.EnvKey, s.llmProxyURL, llmToken)
+	}
+	if agentTypeID == "myrai" {
+		envVars["MYRAI_GATEWAY_TOKEN"] = gatewayToken
+	}
+	return envVars
+}
+
+// newAgentConfig describes a tenant's containers from its agent type and
+// plan. Tenants pinned to a pre-built image run it; others install their
+// agent into the agent type's base image.
+func newAgentConfig(customer *db.Customer, agentType *db.AgentType, plan *db.Plan, port int) AgentConfig {
+	image, prebuilt := agentType.BaseImage, false
+	if customer.ImageDigest != nil && *customer.ImageDigest != "" {
+		image, prebuilt = *customer.ImageDigest, true
+	}
+
+	return AgentConfig{
+		CustomerID:         customer.ID,
+		AgentType:          customer.AgentTypeID,
+		ExternalPort:       port,
+		ExternalPortBridge: port + 1,
+		InternalPort:       agentType.InternalPort,
+		InternalPortBridge: agentType.InternalPortBridge,
+		BaseImage:          image,
+		Prebuilt:           prebuilt,
+		HealthEndpoint:     agentType.HealthEndpoint,
+		MemoryLimit:        plan.Memory,
+		CPULimit:           plan.CPU,
+		Bridge:             egress.BridgeName(customer.ID),
+	}
+}
+
+// ChangePlan moves a tenant to another plan. The compose file is regenerated
+// with the plan's limits and a running container is recreated in place; its
+// workspace and data are bind mounts and carry over. A stopped tenant picks
+// up the limits when it is next started.
+func (s *Service) ChangePlan(ctx context.Context, customerID, planID string) error {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+
+	plan, err := s.db.GetPlan(ctx, planID)
+	if err != nil {
+		return fmt.Errorf("get plan: %w", err)
+	}
+
+	// Tenants not provisioned yet get the plan's limits when they are
+	if customer.ContainerPort != nil {
+		if err := s.resize(ctx, customer, plan); err != nil {
+			return err
 		}
 	}
 
+	if err := s.db.SetCustomerPlan(ctx, customerID, plan.ID); err != nil {
+		return fmt.Errorf("update plan: %w", err)
+	}
+
+	return nil
+}
+
+// resize rewrites a provisioned tenant's compose file for the plan and
+// recreates the container if it is running
+func (s *Service) resize(ctx context.Context, customer *db.Customer, plan *db.Plan) error {
+	agentType, err := s.db.GetAgentType(ctx, customer.AgentTypeID)
+	if err != nil {
+		return fmt.Errorf("get agent type: %w", err)
+	}
+
+	llmProvider, err := s.db.GetLLMProvider(ctx, customer.LLMProviderID)
+	if err != nil {
+		return fmt.Errorf("get llm provider: %w", err)
+	}
+
+	network := ""
+	if customer.ContainerNetwork != nil {
+		network = *customer.ContainerNetwork
+	}
+	if err := s.writeCompose(ctx, customer, agentType, llmProvider, plan, *customer.ContainerPort, network); err != nil {
+		return err
+	}
+
+	if customer.Status != "active" && customer.Status != "degraded" {
+		return nil
+	}
+	runtime, err := s.runtimeFor(ctx, customer)
+	if err != nil {
+		return err
+	}
+	if err := runtime.Recreate(ctx, customer.ID); err != nil {
+		return fmt.Errorf("recreate container: %w", err)
+	}
+
 	return nil
 }
 
+// writeCompose regenerates a provisioned tenant's compose file and egress
+// rules for the plan and host port, or the internal network when one is
+// given. The tokens issued at provisioning stay in the tenant's .env.secret.
+func (s *Service) writeCompose(ctx context.Context, customer *db.Customer, agentType *db.AgentType, llmProvider *db.LLMProvider, plan *db.Plan, port int, network string) error {
+	agentConfig := newAgentConfig(customer, agentType, plan, port)
+	agentConfig.Network = network
+	agentConfig.Hardening = s.hardening
+	if err := s.compose.Generate(agentConfig); err != nil {
+		return fmt.Errorf("generate compose: %w", err)
+	}
+	return s.writeEgressRules(ctx, customer, agentType, llmProvider, plan)
+}
+
 func (s *Service) Suspend(ctx context.Context, customerID string) error {
-	if err := s.docker.Stop(ctx, customerID); err != nil {
+	runtime, err := s.customerRuntime(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if err := runtime.Stop(ctx, customerID); err != nil {
 		return fmt.Errorf("stop container: %w", err)
 	}
 
@@ -187,7 +361,11 @@ func (s *Service) Suspend(ctx context.Context, customerID string) error {
 }
 
 func (s *Service) Resume(ctx context.Context, customerID string) error {
-	if err := s.docker.Start(ctx, customerID); err != nil {
+	runtime, err := s.customerRuntime(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if err := runtime.Start(ctx, customerID); err != nil {
 		return fmt.Errorf("start container: %w", err)
 	}
 
@@ -198,25 +376,54 @@ func (s *Service) Resume(ctx context.Context, customerID string) error {
 	return nil
 }
 
+// Restart restarts a tenant's containers in place without changing its status
+func (s *Service) Restart(ctx context.Context, customerID string) error {
+	runtime, err := s.customerRuntime(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if err := runtime.Restart(ctx, customerID); err != nil {
+		return fmt.Errorf("restart container: %w", err)
+	}
+	return nil
+}
+
 func (s *Service) Terminate(ctx context.Context, customerID string) error {
 	customer, err := s.db.GetCustomerByID(ctx, customerID)
 	if err != nil {
 		return fmt.Errorf("get customer: %w", err)
 	}
 
-	if customer.ContainerPort != nil {
-		s.db.ReleasePort(ctx, *customer.ContainerPort)
-		s.ports.ReleasePort(*customer.ContainerPort)
+	runtime, err := s.runtimeFor(ctx, customer)
+	if err != nil {
+		return err
+	}
+
+	if customer.NodeID != nil {
+		if err := s.db.ClearCustomerNode(ctx, customerID); err != nil {
+			return err
+		}
+	} else if customer.ContainerPort != nil {
+		if err := s.ports.Release(ctx, s.db, customerID); err != nil {
+			return fmt.Errorf("release ports: %w", err)
+		}
 		// Clear the container_port from customer record
 		if err := s.db.ClearCustomerPort(ctx, customerID); err != nil {
 			return fmt.Errorf("clear customer port: %w", err)
 		}
 	}
 
-	if err := s.docker.Remove(ctx, customerID); err != nil {
+	if err := runtime.Remove(ctx, customerID); err != nil {
 		return fmt.Errorf("remove container: %w", err)
 	}
 
+	if err := s.unrouteTenant(ctx, customerID); err != nil {
+		s.logger.Warn("Failed to remove Caddy route", zap.String("customer_id", customerID), zap.Error(err))
+	}
+	if err := s.releaseDomain(ctx, customerID); err != nil {
+		return err
+	}
+
 	if err := s.db.UpdateCustomerStatus(ctx, customerID, "cancelled"); err != nil {
 		return fmt.Errorf("update status: %w", err)
 	}
@@ -224,16 +431,47 @@ func (s *Service) Terminate(ctx context.Context, customerID string) error {
 	return nil
 }
 
+// RecoverPorts reconciles the control-plane host's port allocations with the
+// tenants running on it, so ports held before a restart are never handed out
+// again
+func (s *Service) RecoverPorts(ctx context.Context) error {
+	restored, released, err := s.ports.Recover(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	allocated, capacity := s.ports.Stats()
+	s.logger.Info("Recovered port allocations", zap.Int("allocated", allocated), zap.Int("capacity", capacity),
+		zap.Int("restored", restored), zap.Int("released", released))
+	return nil
+}
+
+// PortPoolStats reports how many host ports are allocated out of the pool
+func (s *Service) PortPoolStats() (allocated, capacity int) {
+	return s.ports.Stats()
+}
+
 func (s *Service) ValidateBotToken(token string) (*telegram.BotInfo, error) {
 	return telegram.ValidateToken(token)
 }
 
-func (s *Service) cleanup(customerID string, port int) {
-	s.docker.Remove(context.Background(), customerID)
-	s.db.ReleasePort(context.Background(), port)
-	s.ports.ReleasePort(port)
+func (s *Service) cleanup(customerID string) {
+	ctx := context.Background()
+	s.docker.Remove(ctx, customerID)
+	s.ports.Release(ctx, s.db, customerID)
+	s.db.ClearCustomerPort(ctx, customerID)
+	s.removeEnvFile(customerID)
+}
+
+// customerRuntime returns the runtime managing the tenant's containers
+func (s *Service) customerRuntime(ctx context.Context, customerID string) (Runtime, error) {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("get customer: %w", err)
+	}
+	return s.runtimeFor(ctx, customer)
+}
 
-	// Remove env file
+func (s *Service) removeEnvFile(customerID string) {
 	envPath := filepath.Join(s.baseDir, customerID, ".env.secret")
 	os.Remove(envPath) // Ignore errors
 }
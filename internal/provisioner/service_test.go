@@ -2,6 +2,7 @@ package provisioner
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -23,7 +24,7 @@ func TestNewService(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -61,7 +62,7 @@ func TestServiceProvisionRollback(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -110,7 +111,7 @@ func TestServiceSuspendResume(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -156,7 +157,7 @@ func TestServiceTerminate(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -184,7 +185,7 @@ func TestServiceValidateBotToken(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -200,26 +201,32 @@ func TestServiceValidateBotToken(t *testing.T) {
 }
 
 func TestPortExhaustion(t *testing.T) {
-	// Test that port allocator correctly handles exhaustion
-	allocator := NewPortAllocator(30000, 30002)
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
 
-	// Allocate all 3 ports
-	for i := 0; i < 3; i++ {
-		port, err := allocator.AllocatePort()
-		require.NoError(t, err, "Should allocate port %d", i)
-		assert.Equal(t, 30000+i, port)
-	}
+	ctx := t.Context()
+	alice := createNodeCustomer(t, database, "alice@example.com", "starter")
+	bob := createNodeCustomer(t, database, "bob@example.com", "starter")
+
+	// Room for a single block
+	allocator := NewPortAllocator(30000, 30002)
+	allocator.available = func(int) bool { return true }
 
-	// 4th allocation should fail
-	_, err := allocator.AllocatePort()
-	assert.Error(t, err, "Should fail when no ports available")
-	assert.Contains(t, err.Error(), "no available ports")
+	_, err = allocator.Reserve(ctx, database, alice.ID)
+	require.NoError(t, err)
+	_, err = allocator.Reserve(ctx, database, bob.ID)
+	require.Error(t, err, "Should fail when no block is free")
+	assert.Contains(t, err.Error(), "no free block")
+	allocated, _ := allocator.Stats()
+	assert.Equal(t, db.TenantPorts, allocated, "a failed reservation is not counted")
 
-	// Release one port and try again
-	allocator.ReleasePort(30001)
-	port, err := allocator.AllocatePort()
-	require.NoError(t, err, "Should allocate after release")
-	assert.Equal(t, 30001, port)
+	// Releasing a block frees it for the next tenant
+	require.NoError(t, allocator.Release(ctx, database, alice.ID))
+	port, err := allocator.Reserve(ctx, database, bob.ID)
+	require.NoError(t, err, "Should reserve after release")
+	assert.Equal(t, 30000, port)
 }
 
 func TestComposeFileGeneration(t *testing.T) {
@@ -234,12 +241,9 @@ func TestComposeFileGeneration(t *testing.T) {
 		InternalPort:       18789,
 		InternalPortBridge: 18790,
 		BaseImage:          "node:22-bookworm",
-		LLMEnvKey:          "OPENAI_API_KEY",
-		LLMKey:             "sk-test-key",
-		GatewayToken:       "test-token",
 		HealthEndpoint:     "/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 
 	err := gen.Generate(config)
@@ -284,11 +288,9 @@ func TestWorkspaceGeneration(t *testing.T) {
 		InternalPort:       18789,
 		InternalPortBridge: 18790,
 		BaseImage:          "node:22-bookworm",
-		LLMEnvKey:          "OPENAI_API_KEY",
-		GatewayToken:       "test-token",
 		HealthEndpoint:     "/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 
 	err := gen.Generate(config)
@@ -305,35 +307,52 @@ func TestWorkspaceGeneration(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestLoadAllocatedPorts(t *testing.T) {
+func TestPortAllocatorReserve(t *testing.T) {
 	database, err := db.New(":memory:")
 	require.NoError(t, err)
 	defer database.Close()
 	require.NoError(t, database.Migrate())
 
 	ctx := t.Context()
+	alice := createNodeCustomer(t, database, "alice@example.com", "starter")
+	bob := createNodeCustomer(t, database, "bob@example.com", "starter")
+
+	allocator := NewPortAllocator(30000, 30010)
+	// Something outside blytz listens on 30002
+	allocator.available = func(port int) bool { return port != 30002 }
 
-	// Allocate some ports
-	err = database.AllocatePort(ctx, "customer-1", 30001)
+	port, err := allocator.Reserve(ctx, database, alice.ID)
 	require.NoError(t, err)
-	err = database.AllocatePort(ctx, "customer-2", 30002)
+	assert.Equal(t, 30000, port)
+	port, err = allocator.Reserve(ctx, database, bob.ID)
 	require.NoError(t, err)
+	assert.Equal(t, 30003, port, "the bridge port and the busy port are skipped")
 
-	allocator := NewPortAllocator(30000, 30010)
+	allocated, _ := allocator.Stats()
+	assert.Equal(t, 4, allocated)
 
-	// Load allocated ports
-	err = allocator.LoadAllocatedPorts(ctx, database)
-	require.NoError(t, err)
+	// A restarted service sees the reservations
+	svc := NewService(database, t.TempDir(), t.TempDir(), "", 30000, 30010, nil, "localhost", nil)
+	require.NoError(t, svc.RecoverPorts(ctx))
+	allocated, _ = svc.PortPoolStats()
+	assert.Equal(t, 4, allocated)
 
-	// Try to allocate - should skip 30001 and 30002
-	port, err := allocator.AllocatePort()
+	require.NoError(t, allocator.Release(ctx, database, alice.ID))
+	allocated, _ = allocator.Stats()
+	assert.Equal(t, 2, allocated)
+	ports, err := database.GetAllocatedPorts(ctx)
 	require.NoError(t, err)
-	assert.Equal(t, 30000, port)
+	assert.ElementsMatch(t, []int{30003, 30004}, ports)
+}
 
-	// Next allocation should be 30003 (skipping 30001, 30002)
-	port, err = allocator.AllocatePort()
+func TestHostPortFree(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
 	require.NoError(t, err)
-	assert.Equal(t, 30003, port)
+	port := l.Addr().(*net.TCPAddr).Port
+
+	assert.False(t, hostPortFree(port))
+	require.NoError(t, l.Close())
+	assert.True(t, hostPortFree(port))
 }
 
 func TestDockerProvisionerMethods(t *testing.T) {
@@ -391,7 +410,7 @@ func TestServiceCleanup(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -411,12 +430,9 @@ func TestServiceCleanup(t *testing.T) {
 		InternalPort:       18789,
 		InternalPortBridge: 18790,
 		BaseImage:          "node:22-bookworm",
-		LLMEnvKey:          "OPENAI_API_KEY",
-		LLMKey:             "sk-test",
-		GatewayToken:       "test-token",
 		HealthEndpoint:     "/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 
 	err = svc.compose.Generate(config)
@@ -434,7 +450,7 @@ func TestServiceCleanup(t *testing.T) {
 	require.NoError(t, err)
 
 	// Cleanup
-	svc.cleanup(customer.ID, 30001)
+	svc.cleanup(customer.ID)
 
 	// Verify env file removed
 	_, err = os.Stat(envPath)
@@ -459,7 +475,7 @@ func TestServiceProvisionErrorPaths(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -486,7 +502,7 @@ func TestServiceTerminateNonExistentCustomer(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -513,7 +529,7 @@ func TestServiceSuspendResumeNonExistent(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -524,14 +540,13 @@ func TestServiceSuspendResumeNonExistent(t *testing.T) {
 	// Test suspend non-existent customer
 	err = svc.Suspend(ctx, "nonexistent")
 	require.Error(t, err)
-	// Service.Suspend doesn't call GetCustomerByID first, it directly calls docker.Stop
-	// So we get a docker error instead of "get customer"
-	assert.Contains(t, err.Error(), "stop container")
+	// The customer's record says which host its containers run on
+	assert.Contains(t, err.Error(), "get customer")
 
 	// Test resume non-existent customer
 	err = svc.Resume(ctx, "nonexistent")
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "start container")
+	assert.Contains(t, err.Error(), "get customer")
 }
 
 func TestGenerateGatewayToken(t *testing.T) {
@@ -605,28 +620,6 @@ services:
 	t.Logf("Create returned: %v", err)
 }
 
-func TestPortAllocatorConcurrency(t *testing.T) {
-	allocator := NewPortAllocator(30000, 30010)
-
-	// Test concurrent allocations
-	ports := make(map[int]bool)
-	for i := 0; i < 5; i++ {
-		port, err := allocator.AllocatePort()
-		require.NoError(t, err)
-		ports[port] = true
-	}
-
-	// All ports should be unique
-	assert.Len(t, ports, 5)
-
-	// Test releasing and reallocating
-	allocator.ReleasePort(30000)
-	port, err := allocator.AllocatePort()
-	require.NoError(t, err)
-	// Should get 30000 back since it was released
-	assert.True(t, port >= 30000 && port <= 30010)
-}
-
 func TestComposeGeneratorGenerateMultiple(t *testing.T) {
 	tmpDir := t.TempDir()
 	gen := NewComposeGenerator(tmpDir)
@@ -641,11 +634,9 @@ func TestComposeGeneratorGenerateMultiple(t *testing.T) {
 			InternalPort:       18789,
 			InternalPortBridge: 18790,
 			BaseImage:          "node:22-bookworm",
-			LLMEnvKey:          "OPENAI_API_KEY",
-			GatewayToken:       "test-token",
 			HealthEndpoint:     "/health",
-			MinMemory:          "512M",
-			MinCPU:             "0.25",
+			MemoryLimit:        "512M",
+			CPULimit:           "0.25",
 		}
 		err := gen.Generate(config)
 		require.NoError(t, err)
@@ -722,7 +713,7 @@ func TestServiceTerminateWithPort(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30010,
 		nil,
@@ -739,12 +730,9 @@ func TestServiceTerminateWithPort(t *testing.T) {
 		InternalPort:       18789,
 		InternalPortBridge: 18790,
 		BaseImage:          "node:22-bookworm",
-		LLMEnvKey:          "OPENAI_API_KEY",
-		LLMKey:             "sk-test",
-		GatewayToken:       "test-token",
 		HealthEndpoint:     "/health",
-		MinMemory:          "512M",
-		MinCPU:             "0.25",
+		MemoryLimit:        "512M",
+		CPULimit:           "0.25",
 	}
 	err = svc.compose.Generate(agentConfig)
 	require.NoError(t, err)
@@ -793,7 +781,7 @@ func TestServiceProvisionErrorPathsDetailed(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30010,
 		nil,
@@ -801,10 +789,6 @@ func TestServiceProvisionErrorPathsDetailed(t *testing.T) {
 		nil,
 	)
 
-	// Load allocated ports first
-	err = svc.ports.LoadAllocatedPorts(ctx, database)
-	require.NoError(t, err)
-
 	// This will fail at docker create, testing the full path
 	err = svc.Provision(ctx, customer.ID)
 	// May error or succeed depending on Docker
@@ -869,6 +853,10 @@ services:
 	err := dp.Start(ctx, customerID)
 	t.Logf("Start returned: %v", err)
 
+	// Test Restart
+	err = dp.Restart(ctx, customerID)
+	t.Logf("Restart returned: %v", err)
+
 	// Test Stop
 	err = dp.Stop(ctx, customerID)
 	t.Logf("Stop returned: %v", err)
@@ -895,6 +883,9 @@ func TestDockerProvisionerErrorCases(t *testing.T) {
 	err = dp.Stop(ctx, "nonexistent")
 	require.Error(t, err)
 
+	err = dp.Restart(ctx, "nonexistent")
+	require.Error(t, err)
+
 	err = dp.Remove(ctx, "nonexistent")
 	require.Error(t, err)
 
@@ -925,7 +916,7 @@ func TestServiceMultipleOperations(t *testing.T) {
 		database,
 		tmpDir,
 		tmpDir,
-		"sk-test",
+		"",
 		30000,
 		30005,
 		nil,
@@ -959,35 +950,125 @@ func TestServiceMultipleOperations(t *testing.T) {
 	require.NoError(t, err)
 }
 
-func TestPortAllocatorEdgeCases(t *testing.T) {
-	// Test with single port range
-	allocator := NewPortAllocator(30000, 30000)
+func TestServiceProvisionUsesPlanLimits(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
 
-	// Should allocate the single port
-	port, err := allocator.AllocatePort()
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "pro@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+		PlanID:             "pro",
+	})
 	require.NoError(t, err)
-	assert.Equal(t, 30000, port)
 
-	// Should fail when exhausted
-	_, err = allocator.AllocatePort()
+	tmpDir := t.TempDir()
+	templatesDir := filepath.Join("..", "workspace", "templates")
+	svc := NewService(database, templatesDir, tmpDir, "", 30000, 30005, nil, "localhost", nil)
+
+	// Docker isn't available, but the compose file is written before the container is created
+	_ = svc.Provision(ctx, customer.ID)
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, customer.ID, "docker-compose.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "memory: 1G")
+	assert.Contains(t, string(content), "cpus: '0.5'")
+}
+
+func TestServiceProvisionUnknownPlan(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "retired@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+		PlanID:             "missing",
+	})
+	require.NoError(t, err)
+
+	tmpDir := t.TempDir()
+	svc := NewService(database, tmpDir, tmpDir, "", 30000, 30005, nil, "localhost", nil)
+
+	err = svc.Provision(ctx, customer.ID)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "no available ports")
+	assert.Contains(t, err.Error(), "get plan")
 
-	// Release and reallocate
-	allocator.ReleasePort(30000)
-	port, err = allocator.AllocatePort()
+	updated, err := database.GetCustomerByID(ctx, customer.ID)
 	require.NoError(t, err)
-	assert.Equal(t, 30000, port)
+	assert.Equal(t, "pending", updated.Status)
 }
 
-func TestPortAllocatorReleaseNonExistent(t *testing.T) {
-	allocator := NewPortAllocator(30000, 30010)
+func TestServiceChangePlan(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
 
-	// Should not panic when releasing non-existent port
-	allocator.ReleasePort(99999)
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "resize@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+
+	tmpDir := t.TempDir()
+	svc := NewService(database, tmpDir, tmpDir, "http://control-plane:8080", 30000, 30005, nil, "localhost", nil)
 
-	// Should still work normally after releasing non-existent port
-	port, err := allocator.AllocatePort()
+	// Not provisioned yet: only the plan changes
+	require.NoError(t, svc.ChangePlan(ctx, customer.ID, "pro"))
+	updated, err := database.GetCustomerByID(ctx, customer.ID)
 	require.NoError(t, err)
-	assert.Equal(t, 30000, port)
+	assert.Equal(t, "pro", updated.PlanID)
+
+	// A provisioned but suspended tenant gets a new compose file without a restart
+	require.NoError(t, database.UpdateCustomerPort(ctx, customer.ID, 30002))
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "suspended"))
+
+	require.NoError(t, svc.compose.GenerateEnvFile(customer.ID, map[string]string{"OPENAI_API_KEY": "blytz-llm-token"}))
+	require.NoError(t, svc.ChangePlan(ctx, customer.ID, "business"))
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, customer.ID, "docker-compose.yml"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "memory: 2G")
+	assert.Contains(t, string(content), "cpus: '1.0'")
+	assert.Contains(t, string(content), `"30002:`)
+	assert.NotContains(t, string(content), "blytz-llm-token")
+
+	updated, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "business", updated.PlanID)
+	assert.Equal(t, "suspended", updated.Status)
+
+	assert.Error(t, svc.ChangePlan(ctx, customer.ID, "missing"))
+}
+
+func TestTenantEnv(t *testing.T) {
+	svc := &Service{llmProxyURL: "http://control-plane:8080"}
+	openai := &db.LLMProvider{ID: "openai", EnvKey: "OPENAI_API_KEY"}
+	direct := &db.LLMProvider{ID: "groq", EnvKey: "GROQ_API_KEY"}
+
+	// Myrai reads its gateway token from the environment whatever the provider
+	env := svc.tenantEnv("myrai", direct, "gw-token", "")
+	assert.Equal(t, map[string]string{"MYRAI_GATEWAY_TOKEN": "gw-token"}, env)
+
+	env = svc.tenantEnv("myrai", openai, "gw-token", "blytz-llm-token")
+	assert.Equal(t, "gw-token", env["MYRAI_GATEWAY_TOKEN"])
+	assert.Equal(t, "blytz-llm-token", env["OPENAI_API_KEY"])
+	assert.Equal(t, "http://control-plane:8080/llm/openai/v1", env["OPENAI_BASE_URL"])
+
+	// OpenClaw keeps its gateway token in its own config
+	env = svc.tenantEnv("openclaw", openai, "gw-token", "blytz-llm-token")
+	assert.NotContains(t, env, "MYRAI_GATEWAY_TOKEN")
+	assert.Equal(t, "blytz-llm-token", env["OPENAI_API_KEY"])
 }
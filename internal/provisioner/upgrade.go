@@ -0,0 +1,79 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+)
+
+// UpgradeImage pins a provisioned tenant to an agent image and recreates its
+// running containers on it, waiting until an active tenant answers its health
+// endpoint again. An empty digest returns the tenant to installing its agent
+// into the agent type's base image. Stopped tenants run the image when they
+// are next started.
+func (s *Service) UpgradeImage(ctx context.Context, customerID, imageDigest string) error {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	if customer.ContainerPort == nil {
+		return fmt.Errorf("tenant %s is not provisioned", customerID)
+	}
+
+	agentType, err := s.db.GetAgentType(ctx, customer.AgentTypeID)
+	if err != nil {
+		return fmt.Errorf("get agent type: %w", err)
+	}
+	llmProvider, err := s.db.GetLLMProvider(ctx, customer.LLMProviderID)
+	if err != nil {
+		return fmt.Errorf("get llm provider: %w", err)
+	}
+	plan, err := s.db.GetPlan(ctx, customer.PlanID)
+	if err != nil {
+		return fmt.Errorf("get plan: %w", err)
+	}
+
+	if err := s.db.SetCustomerImage(ctx, customerID, imageDigest); err != nil {
+		return err
+	}
+	customer.ImageDigest = &imageDigest
+
+	network := ""
+	if customer.ContainerNetwork != nil {
+		network = *customer.ContainerNetwork
+	}
+	if err := s.writeCompose(ctx, customer, agentType, llmProvider, plan, *customer.ContainerPort, network); err != nil {
+		return err
+	}
+
+	if customer.Status != "active" && customer.Status != "degraded" {
+		return nil
+	}
+	p, err := s.locate(ctx, customer)
+	if err != nil {
+		return err
+	}
+	if err := p.runtime.Recreate(ctx, customerID); err != nil {
+		return fmt.Errorf("recreate container: %w", err)
+	}
+	if customer.Status == "active" {
+		return s.waitHealthy(ctx, p.host, p.port, agentType.HealthEndpoint)
+	}
+	return nil
+}
+
+// CheckHealth probes a provisioned tenant's health endpoint once
+func (s *Service) CheckHealth(ctx context.Context, customerID string) error {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return fmt.Errorf("get customer: %w", err)
+	}
+	agentType, err := s.db.GetAgentType(ctx, customer.AgentTypeID)
+	if err != nil {
+		return fmt.Errorf("get agent type: %w", err)
+	}
+	p, err := s.locate(ctx, customer)
+	if err != nil {
+		return err
+	}
+	return s.checkHealth(ctx, fmt.Sprintf("http://%s:%d%s", p.host, p.port, agentType.HealthEndpoint))
+}
@@ -0,0 +1,49 @@
+package provisioner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpgradeImage(t *testing.T) {
+	fleet := fakeFleet{"worker-1": {}}
+	svc, database, _, health := newMigrationService(t, fleet)
+	ctx := t.Context()
+
+	const (
+		pinned = "registry.local/blytz/openclaw@sha256:1111"
+		newer  = "registry.local/blytz/openclaw@sha256:2222"
+	)
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", "registry.local/blytz/openclaw:1.4.2", pinned))
+	alice := provisionOn(t, svc, database, "alice@example.com", "worker-1")
+	fleet["worker-1"].calls = nil
+
+	require.NoError(t, svc.UpgradeImage(ctx, alice.ID, newer))
+	assert.Contains(t, readComposeFile(t, svc, alice.ID), "    image: "+newer+"\n")
+	assert.Equal(t, []string{"recreate " + alice.ID}, fleet["worker-1"].calls)
+	assert.Equal(t, []string{"http://worker-1.internal:40000/health"}, health.urls)
+
+	customer, err := database.GetCustomerByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, newer, *customer.ImageDigest)
+
+	require.NoError(t, svc.CheckHealth(ctx, alice.ID))
+	health.err = errors.New("health endpoint returned 502 Bad Gateway")
+	assert.Error(t, svc.CheckHealth(ctx, alice.ID))
+
+	// A tenant that does not come back healthy is reported; the caller rolls it back
+	assert.ErrorContains(t, svc.UpgradeImage(ctx, alice.ID, pinned), "did not become healthy")
+
+	// Suspended tenants run the image when they are resumed
+	fleet["worker-1"].calls = nil
+	require.NoError(t, database.UpdateCustomerStatus(ctx, alice.ID, "suspended"))
+	require.NoError(t, svc.UpgradeImage(ctx, alice.ID, ""))
+	assert.Empty(t, fleet["worker-1"].calls)
+	assert.Contains(t, readComposeFile(t, svc, alice.ID), "npm install -g openclaw@latest")
+
+	pending := createNodeCustomer(t, database, "bob@example.com", "starter")
+	assert.Error(t, svc.UpgradeImage(ctx, pending.ID, newer))
+}
@@ -0,0 +1,485 @@
+// Package rollout moves the tenants of an agent type to a new image in stages:
+// a canary cohort first, then batches of tenants, watching their health after
+// each stage and returning every upgraded tenant to its previous image when
+// the new one misbehaves.
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+var (
+	// ErrNoImage means the agent type has no built image to roll out
+	ErrNoImage = errors.New("agent type has no built image")
+	// ErrInProgress means another rollout of the agent type has not finished
+	ErrInProgress = errors.New("a rollout of this agent type is in progress")
+	// ErrUpToDate means every provisioned tenant of the agent type already runs the image
+	ErrUpToDate = errors.New("every tenant already runs this image")
+	// ErrInvalidPolicy means a rollout was started with settings it cannot follow
+	ErrInvalidPolicy = errors.New("invalid rollout policy")
+)
+
+// errHalted is the reason recorded for rollouts an operator halted
+var errHalted = errors.New("halted by operator")
+
+// Upgrader moves tenants between agent images
+type Upgrader interface {
+	// UpgradeImage pins a tenant to an image, recreates its containers on it
+	// and waits until it answers its health endpoint
+	UpgradeImage(ctx context.Context, customerID, imageDigest string) error
+	// CheckHealth probes a tenant's health endpoint once
+	CheckHealth(ctx context.Context, customerID string) error
+}
+
+// Policy controls how a rollout proceeds
+type Policy struct {
+	// CanarySize is the number of tenants upgraded first
+	CanarySize int
+	// Concurrency is the number of tenants upgraded at once, and the size of
+	// each batch after the canary cohort
+	Concurrency int
+	// BakePeriod is how long the canary cohort is watched before the batches
+	BakePeriod time.Duration
+	// BatchBake is how long the upgraded tenants are watched after each batch
+	BatchBake time.Duration
+	// MaxErrorRate is the share of failed health probes during a bake above
+	// which the rollout is rolled back
+	MaxErrorRate float64
+	// ProbeInterval is how often upgraded tenants are probed while baking
+	ProbeInterval time.Duration
+}
+
+// DefaultPolicy returns the policy used when nothing is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		CanarySize:    1,
+		Concurrency:   5,
+		BakePeriod:    15 * time.Minute,
+		BatchBake:     2 * time.Minute,
+		MaxErrorRate:  0.1,
+		ProbeInterval: 15 * time.Second,
+	}
+}
+
+func (p Policy) validate() error {
+	if p.CanarySize < 1 {
+		return fmt.Errorf("%w: canary size must be at least 1", ErrInvalidPolicy)
+	}
+	if p.Concurrency < 1 {
+		return fmt.Errorf("%w: concurrency must be at least 1", ErrInvalidPolicy)
+	}
+	if p.BakePeriod < 0 || p.BatchBake < 0 {
+		return fmt.Errorf("%w: bake periods must not be negative", ErrInvalidPolicy)
+	}
+	if p.MaxErrorRate < 0 || p.MaxErrorRate > 1 {
+		return fmt.Errorf("%w: max error rate must be between 0 and 1", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+// Orchestrator plans rollouts and carries them out one at a time
+type Orchestrator struct {
+	db       *db.DB
+	upgrader Upgrader
+	policy   Policy
+	logger   *zap.Logger
+	// shuffle orders the candidates before the canary cohort is taken from the front
+	shuffle func(n int, swap func(i, j int))
+
+	// mu keeps two rollouts of one agent type from being started at once
+	mu sync.Mutex
+}
+
+// New creates an orchestrator whose rollouts default to the policy
+func New(database *db.DB, upgrader Upgrader, policy Policy, logger *zap.Logger) *Orchestrator {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Orchestrator{
+		db:       database,
+		upgrader: upgrader,
+		policy:   policy,
+		logger:   logger,
+		shuffle:  rand.Shuffle,
+	}
+}
+
+// Policy returns the policy rollouts follow unless they are started with another
+func (o *Orchestrator) Policy() Policy {
+	return o.policy
+}
+
+// Start plans a rollout of an image to the active, degraded and suspended
+// tenants of an agent type that run another one. An empty digest rolls out
+// the agent type's latest build; any other becomes the image new tenants of
+// the agent type are pinned to. The canary cohort is picked at random and the
+// rest are split into batches of policy.Concurrency tenants. The rollout runs
+// on the next pass of the orchestrator.
+func (o *Orchestrator) Start(ctx context.Context, agentTypeID, imageDigest string, policy Policy) (*db.Rollout, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+
+	agentType, err := o.db.GetAgentType(ctx, agentTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if imageDigest == "" {
+		imageDigest = agentType.ImageDigest
+	}
+	if imageDigest == "" {
+		return nil, ErrNoImage
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	active, err := o.db.ListActiveRollouts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range active {
+		if r.AgentTypeID == agentTypeID {
+			return nil, ErrInProgress
+		}
+	}
+
+	candidates, err := o.db.ListRolloutCandidates(ctx, agentTypeID, imageDigest)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, ErrUpToDate
+	}
+	o.shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	image, err := o.imageTag(ctx, agentTypeID, imageDigest)
+	if err != nil {
+		return nil, err
+	}
+	previousDigest, previousImage := commonDigest(candidates), ""
+	if previousDigest != "" {
+		if previousImage, err = o.imageTag(ctx, agentTypeID, previousDigest); err != nil {
+			return nil, err
+		}
+	}
+
+	canary := min(policy.CanarySize, len(candidates))
+	tenants := make([]db.RolloutTenant, len(candidates))
+	for i, c := range candidates {
+		batch := 0
+		if i >= canary {
+			batch = 1 + (i-canary)/policy.Concurrency
+		}
+		tenants[i] = db.RolloutTenant{CustomerID: c.CustomerID, Batch: batch, PreviousDigest: c.ImageDigest}
+	}
+
+	rollout := &db.Rollout{
+		AgentTypeID:      agentTypeID,
+		Image:            image,
+		ImageDigest:      imageDigest,
+		PreviousImage:    previousImage,
+		PreviousDigest:   previousDigest,
+		CanarySize:       canary,
+		Concurrency:      policy.Concurrency,
+		BakeSeconds:      int(policy.BakePeriod / time.Second),
+		BatchBakeSeconds: int(policy.BatchBake / time.Second),
+		MaxErrorRate:     policy.MaxErrorRate,
+		Batches:          tenants[len(tenants)-1].Batch + 1,
+	}
+	// New tenants get the image as well, until the rollout is rolled back
+	if agentType.ImageDigest != imageDigest {
+		if err := o.db.SetAgentTypeImage(ctx, agentTypeID, image, imageDigest); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.db.CreateRollout(ctx, rollout, tenants); err != nil {
+		return nil, err
+	}
+
+	o.logger.Info("Rollout planned", zap.String("rollout_id", rollout.ID), zap.String("agent_type", agentTypeID),
+		zap.String("digest", imageDigest), zap.Int("tenants", len(tenants)), zap.Int("batches", rollout.Batches))
+	return rollout, nil
+}
+
+// imageTag returns the tag an agent image was recorded with. Images never
+// recorded here, such as ones built elsewhere, go by their digest reference.
+func (o *Orchestrator) imageTag(ctx context.Context, agentTypeID, digest string) (string, error) {
+	tag, err := o.db.AgentImageTag(ctx, agentTypeID, digest)
+	if err != nil || tag != "" {
+		return tag, err
+	}
+	return digest, nil
+}
+
+// commonDigest returns the image most candidates run, which new tenants go
+// back to if the rollout is rolled back
+func commonDigest(candidates []db.RolloutCandidate) string {
+	counts := make(map[string]int)
+	common := ""
+	for _, c := range candidates {
+		if c.ImageDigest == "" {
+			continue
+		}
+		counts[c.ImageDigest]++
+		if n := counts[c.ImageDigest]; n > counts[common] || (n == counts[common] && c.ImageDigest < common) {
+			common = c.ImageDigest
+		}
+	}
+	return common
+}
+
+// Run carries out rollouts every interval until ctx is cancelled
+func (o *Orchestrator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := o.RunPending(ctx); err != nil && ctx.Err() == nil {
+			o.logger.Error("Rollout did not finish cleanly", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunPending carries every unfinished rollout through to completion or
+// rollback, oldest first. Rollouts interrupted by a restart resume from the
+// batch they were on.
+func (o *Orchestrator) RunPending(ctx context.Context) error {
+	rollouts, err := o.db.ListActiveRollouts(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range rollouts {
+		if err := o.execute(ctx, &rollouts[i]); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			errs = append(errs, fmt.Errorf("rollout %s: %w", rollouts[i].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// execute upgrades a rollout's batches in order, baking after each, and rolls
+// the rollout back when a tenant fails to upgrade, the error rate while
+// baking is too high or an operator halts it
+func (o *Orchestrator) execute(ctx context.Context, r *db.Rollout) error {
+	switch r.Status {
+	case db.RolloutHalting:
+		return o.rollBack(ctx, r, errHalted.Error())
+	case db.RolloutRollingBack:
+		return o.rollBack(ctx, r, r.Error)
+	}
+
+	tenants, err := o.db.ListRolloutTenants(ctx, r.ID)
+	if err != nil {
+		return err
+	}
+	batches := make([][]db.RolloutTenant, r.Batches)
+	for _, t := range tenants {
+		batches[t.Batch] = append(batches[t.Batch], t)
+	}
+
+	logger := o.logger.With(zap.String("rollout_id", r.ID), zap.String("agent_type", r.AgentTypeID))
+	for batch := r.Batch; batch < r.Batches; batch++ {
+		status, bake := db.RolloutRolling, time.Duration(r.BatchBakeSeconds)*time.Second
+		if batch == 0 {
+			status, bake = db.RolloutCanary, time.Duration(r.BakeSeconds)*time.Second
+		}
+		advanced, err := o.db.AdvanceRollout(ctx, r.ID, status, batch)
+		if err != nil {
+			return err
+		}
+		if !advanced {
+			return o.rollBack(ctx, r, errHalted.Error())
+		}
+
+		logger.Info("Upgrading rollout batch", zap.Int("batch", batch), zap.Int("tenants", len(batches[batch])))
+		err = o.upgradeBatch(ctx, r, batches[batch])
+		if err == nil {
+			err = o.bake(ctx, r, bake)
+		}
+		if err != nil {
+			// Interrupted rollouts pick up from this batch when the orchestrator next runs
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return o.rollBack(ctx, r, err.Error())
+		}
+	}
+
+	if err := o.db.SetRolloutStatus(ctx, r.ID, db.RolloutCompleted, ""); err != nil {
+		return err
+	}
+	logger.Info("Rollout completed", zap.String("digest", r.ImageDigest))
+	return nil
+}
+
+// upgradeBatch moves a batch's pending tenants to the rollout's image,
+// r.Concurrency at a time
+func (o *Orchestrator) upgradeBatch(ctx context.Context, r *db.Rollout, tenants []db.RolloutTenant) error {
+	return forEach(r.Concurrency, tenants, db.RolloutTenantPending, func(t db.RolloutTenant) error {
+		if err := o.upgrader.UpgradeImage(ctx, t.CustomerID, r.ImageDigest); err != nil {
+			if ctx.Err() == nil {
+				o.db.SetRolloutTenant(ctx, r.ID, t.CustomerID, db.RolloutTenantFailed, err.Error())
+			}
+			return err
+		}
+		return o.db.SetRolloutTenant(ctx, r.ID, t.CustomerID, db.RolloutTenantUpgraded, "")
+	})
+}
+
+// bake probes every active tenant upgraded so far until the period passes,
+// probing at least once, and fails when too many probes fail or the rollout
+// is halted. Suspended and degraded tenants are not expected to answer.
+func (o *Orchestrator) bake(ctx context.Context, r *db.Rollout, period time.Duration) error {
+	deadline := time.Now().Add(period)
+	probes, failures := 0, 0
+	for {
+		current, err := o.db.GetRollout(ctx, r.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status == db.RolloutHalting {
+			return errHalted
+		}
+
+		tenants, err := o.db.ListRolloutTenants(ctx, r.ID)
+		if err != nil {
+			return err
+		}
+		for _, t := range tenants {
+			if t.Status != db.RolloutTenantUpgraded {
+				continue
+			}
+			customer, err := o.db.GetCustomerByID(ctx, t.CustomerID)
+			if err != nil {
+				return err
+			}
+			if customer.Status != "active" {
+				continue
+			}
+			probes++
+			if err := o.upgrader.CheckHealth(ctx, t.CustomerID); err != nil {
+				failures++
+				o.logger.Debug("Upgraded tenant failed a health probe", zap.String("rollout_id", r.ID),
+					zap.String("customer_id", t.CustomerID), zap.Error(err))
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(o.policy.ProbeInterval, remaining)):
+		}
+	}
+
+	if probes > 0 && float64(failures) > r.MaxErrorRate*float64(probes) {
+		return fmt.Errorf("%d of %d health probes failed while baking", failures, probes)
+	}
+	return nil
+}
+
+// rollBack returns every tenant the rollout touched to its previous image and
+// points new tenants of the agent type back at the image most ran before.
+// Tenants that fail to roll back are left marked failed and reported.
+func (o *Orchestrator) rollBack(ctx context.Context, r *db.Rollout, reason string) error {
+	logger := o.logger.With(zap.String("rollout_id", r.ID), zap.String("agent_type", r.AgentTypeID))
+	logger.Warn("Rolling back rollout", zap.String("reason", reason))
+
+	if err := o.db.SetRolloutStatus(ctx, r.ID, db.RolloutRollingBack, reason); err != nil {
+		return err
+	}
+	tenants, err := o.db.ListRolloutTenants(ctx, r.ID)
+	if err != nil {
+		return err
+	}
+
+	upgraded := forEach(r.Concurrency, tenants, db.RolloutTenantUpgraded, o.restore(ctx, r))
+	failed := forEach(r.Concurrency, tenants, db.RolloutTenantFailed, o.restore(ctx, r))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	agentType, err := o.db.GetAgentType(ctx, r.AgentTypeID)
+	if err != nil {
+		return err
+	}
+	if agentType.ImageDigest == r.ImageDigest && r.PreviousDigest != "" {
+		if err := o.db.SetAgentTypeImage(ctx, r.AgentTypeID, r.PreviousImage, r.PreviousDigest); err != nil {
+			return err
+		}
+	}
+
+	if err := o.db.SetRolloutStatus(ctx, r.ID, db.RolloutRolledBack, reason); err != nil {
+		return err
+	}
+	logger.Warn("Rollout rolled back")
+	return errors.Join(upgraded, failed)
+}
+
+// restore returns a tenant to the image it ran before the rollout, keeping
+// the reason its upgrade failed if it did
+func (o *Orchestrator) restore(ctx context.Context, r *db.Rollout) func(t db.RolloutTenant) error {
+	return func(t db.RolloutTenant) error {
+		if err := o.upgrader.UpgradeImage(ctx, t.CustomerID, t.PreviousDigest); err != nil {
+			if ctx.Err() == nil {
+				o.db.SetRolloutTenant(ctx, r.ID, t.CustomerID, db.RolloutTenantFailed, "roll back: "+err.Error())
+			}
+			return err
+		}
+		return o.db.SetRolloutTenant(ctx, r.ID, t.CustomerID, db.RolloutTenantRolledBack, t.Error)
+	}
+}
+
+// forEach runs fn for the tenants with the given status, concurrency at a time,
+// and reports every failure
+func forEach(concurrency int, tenants []db.RolloutTenant, status string, fn func(t db.RolloutTenant) error) error {
+	slots := make(chan struct{}, max(concurrency, 1))
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, t := range tenants {
+		if t.Status != status {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(t db.RolloutTenant) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := fn(t); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", t.CustomerID, err))
+				mu.Unlock()
+			}
+		}(t)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
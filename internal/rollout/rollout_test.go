@@ -0,0 +1,353 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+const (
+	oldTag   = "registry.local/blytz/openclaw:1.4.2"
+	oldImage = "registry.local/blytz/openclaw@sha256:1111"
+	newTag   = "registry.local/blytz/openclaw:1.5.0"
+	newImage = "registry.local/blytz/openclaw@sha256:2222"
+)
+
+// fakeUpgrader keeps each tenant's image in the database and fails upgrades
+// and probes on demand
+type fakeUpgrader struct {
+	db *db.DB
+
+	mu sync.Mutex
+	// upgrades lists each upgrade as "<customer> <digest>" in order
+	upgrades []string
+	// failUpgrade refuses to move these tenants to the new image
+	failUpgrade map[string]bool
+	// unhealthy tenants fail their health probes
+	unhealthy map[string]bool
+	// onUpgrade runs after each tenant moves to the new image
+	onUpgrade func(customerID string)
+}
+
+func (f *fakeUpgrader) UpgradeImage(ctx context.Context, customerID, imageDigest string) error {
+	f.mu.Lock()
+	f.upgrades = append(f.upgrades, customerID+" "+imageDigest)
+	fail := imageDigest == newImage && f.failUpgrade[customerID]
+	f.mu.Unlock()
+
+	if err := f.db.SetCustomerImage(ctx, customerID, imageDigest); err != nil {
+		return err
+	}
+	if fail {
+		return errors.New("tenant did not become healthy")
+	}
+	if f.onUpgrade != nil && imageDigest == newImage {
+		f.onUpgrade(customerID)
+	}
+	return nil
+}
+
+func (f *fakeUpgrader) CheckHealth(ctx context.Context, customerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.unhealthy[customerID] {
+		return errors.New("health endpoint returned 502 Bad Gateway")
+	}
+	return nil
+}
+
+func newTestOrchestrator(t *testing.T, tenants int) (*Orchestrator, *db.DB, *fakeUpgrader, []string) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	ctx := t.Context()
+
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", oldTag, oldImage))
+	require.NoError(t, database.SetAgentTypeImage(ctx, "openclaw", newTag, newImage))
+
+	ids := make([]string, tenants)
+	for i := range ids {
+		customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+			Email:              fmt.Sprintf("tenant%d@example.com", i),
+			AssistantName:      "Test",
+			CustomInstructions: "Help me",
+			TelegramBotToken:   "123:abc",
+		})
+		require.NoError(t, err)
+		require.NoError(t, database.UpdateCustomerPort(ctx, customer.ID, 30000+i))
+		require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+		require.NoError(t, database.SetCustomerImage(ctx, customer.ID, oldImage))
+		ids[i] = customer.ID
+	}
+
+	upgrader := &fakeUpgrader{db: database, failUpgrade: map[string]bool{}, unhealthy: map[string]bool{}}
+	policy := DefaultPolicy()
+	policy.Concurrency = 2
+	policy.BakePeriod = 0
+	policy.BatchBake = 0
+	policy.ProbeInterval = time.Millisecond
+	o := New(database, upgrader, policy, nil)
+	// Candidates keep the database's order, so the canary is the first tenant by ID
+	o.shuffle = func(n int, swap func(i, j int)) {}
+	return o, database, upgrader, ids
+}
+
+func imageOf(t *testing.T, database *db.DB, customerID string) string {
+	t.Helper()
+	customer, err := database.GetCustomerByID(t.Context(), customerID)
+	require.NoError(t, err)
+	require.NotNil(t, customer.ImageDigest)
+	return *customer.ImageDigest
+}
+
+func TestStart(t *testing.T) {
+	o, database, _, ids := newTestOrchestrator(t, 5)
+	ctx := t.Context()
+
+	// One tenant already runs the image and is left out
+	require.NoError(t, database.SetCustomerImage(ctx, ids[4], newImage))
+
+	rollout, err := o.Start(ctx, "openclaw", "", o.Policy())
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutPending, rollout.Status)
+	assert.Equal(t, newTag, rollout.Image)
+	assert.Equal(t, newImage, rollout.ImageDigest)
+	assert.Equal(t, oldTag, rollout.PreviousImage)
+	assert.Equal(t, oldImage, rollout.PreviousDigest)
+	assert.Equal(t, 3, rollout.Batches, "a canary and two batches of two")
+	assert.Equal(t, 4, rollout.Progress.Total)
+
+	tenants, err := database.ListRolloutTenants(ctx, rollout.ID)
+	require.NoError(t, err)
+	batches := map[int]int{}
+	for _, tenant := range tenants {
+		batches[tenant.Batch]++
+		assert.Equal(t, oldImage, tenant.PreviousDigest)
+	}
+	assert.Equal(t, map[int]int{0: 1, 1: 2, 2: 1}, batches)
+
+	_, err = o.Start(ctx, "openclaw", "", o.Policy())
+	assert.ErrorIs(t, err, ErrInProgress)
+
+	_, err = o.Start(ctx, "myrai", "", o.Policy())
+	assert.ErrorIs(t, err, ErrNoImage)
+	_, err = o.Start(ctx, "myrai", "ghcr.io/gmsas95/myrai@sha256:3333", o.Policy())
+	assert.ErrorIs(t, err, ErrUpToDate)
+
+	policy := o.Policy()
+	policy.Concurrency = 0
+	_, err = o.Start(ctx, "myrai", "ghcr.io/gmsas95/myrai@sha256:3333", policy)
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+}
+
+func TestRolloutCompletes(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 5)
+	ctx := t.Context()
+
+	rollout, err := o.Start(ctx, "openclaw", "", o.Policy())
+	require.NoError(t, err)
+	require.NoError(t, o.RunPending(ctx))
+
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutCompleted, got.Status)
+	assert.Equal(t, 2, got.Batch)
+	assert.Equal(t, db.RolloutProgress{Total: 5, Upgraded: 5}, got.Progress)
+	assert.NotNil(t, got.FinishedAt)
+
+	// The canary goes first, alone
+	require.Len(t, upgrader.upgrades, 5)
+	assert.Equal(t, ids[0]+" "+newImage, upgrader.upgrades[0])
+	for _, id := range ids {
+		assert.Equal(t, newImage, imageOf(t, database, id))
+	}
+
+	// Nothing is left to do
+	upgrader.upgrades = nil
+	require.NoError(t, o.RunPending(ctx))
+	assert.Empty(t, upgrader.upgrades)
+}
+
+func TestRolloutRollsBackFailedUpgrade(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 5)
+	ctx := t.Context()
+	upgrader.failUpgrade[ids[2]] = true
+
+	rollout, err := o.Start(ctx, "openclaw", "", o.Policy())
+	require.NoError(t, err)
+	require.NoError(t, o.RunPending(ctx), "every tenant rolled back")
+
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutRolledBack, got.Status)
+	assert.Contains(t, got.Error, "tenant did not become healthy")
+	assert.Equal(t, db.RolloutProgress{Total: 5, Pending: 2, RolledBack: 3}, got.Progress)
+
+	// The canary and the first batch are back on the old image; the rest were never touched
+	for _, id := range ids {
+		assert.Equal(t, oldImage, imageOf(t, database, id))
+	}
+	assert.NotContains(t, upgrader.upgrades, ids[3]+" "+newImage)
+	assert.NotContains(t, upgrader.upgrades, ids[4]+" "+newImage)
+
+	tenants, err := database.ListRolloutTenants(ctx, rollout.ID)
+	require.NoError(t, err)
+	for _, tenant := range tenants {
+		if tenant.CustomerID == ids[2] {
+			assert.Equal(t, db.RolloutTenantRolledBack, tenant.Status)
+			assert.Contains(t, tenant.Error, "did not become healthy")
+		}
+	}
+
+	// New tenants are pinned to the previous image again
+	agentType, err := database.GetAgentType(ctx, "openclaw")
+	require.NoError(t, err)
+	assert.Equal(t, oldTag, agentType.Image)
+	assert.Equal(t, oldImage, agentType.ImageDigest)
+}
+
+func TestRolloutToDigest(t *testing.T) {
+	o, database, upgrader, _ := newTestOrchestrator(t, 3)
+	ctx := t.Context()
+	const pinned = "registry.local/blytz/openclaw@sha256:3333"
+
+	// New tenants are pinned to a digest rolled out by hand while it rolls out
+	rollout, err := o.Start(ctx, "openclaw", pinned, o.Policy())
+	require.NoError(t, err)
+	assert.Equal(t, pinned, rollout.Image, "an image never recorded goes by its digest")
+	agentType, err := database.GetAgentType(ctx, "openclaw")
+	require.NoError(t, err)
+	assert.Equal(t, pinned, agentType.ImageDigest)
+
+	// and go back to the previous tag when it is rolled back
+	require.NoError(t, database.HaltRollout(ctx, rollout.ID))
+	require.NoError(t, o.RunPending(ctx))
+	agentType, err = database.GetAgentType(ctx, "openclaw")
+	require.NoError(t, err)
+	assert.Equal(t, oldTag, agentType.Image)
+	assert.Equal(t, oldImage, agentType.ImageDigest)
+	assert.Empty(t, upgrader.upgrades)
+}
+
+func TestRolloutIncludesStoppedTenants(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 3)
+	ctx := t.Context()
+	require.NoError(t, database.UpdateCustomerStatus(ctx, ids[1], "suspended"))
+	require.NoError(t, database.UpdateCustomerStatus(ctx, ids[2], "degraded"))
+	// Neither answers its health endpoint, which does not count against the rollout
+	upgrader.unhealthy[ids[1]] = true
+	upgrader.unhealthy[ids[2]] = true
+
+	policy := o.Policy()
+	policy.MaxErrorRate = 0
+	rollout, err := o.Start(ctx, "openclaw", "", policy)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rollout.Progress.Total)
+	require.NoError(t, o.RunPending(ctx))
+
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutCompleted, got.Status)
+	for _, id := range ids {
+		assert.Equal(t, newImage, imageOf(t, database, id))
+	}
+}
+
+func TestRolloutRollsBackOnErrorRate(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 3)
+	ctx := t.Context()
+	// The canary starts answering with errors once it runs the new image
+	upgrader.onUpgrade = func(customerID string) {
+		upgrader.mu.Lock()
+		defer upgrader.mu.Unlock()
+		upgrader.unhealthy[customerID] = customerID == ids[0]
+	}
+
+	rollout, err := o.Start(ctx, "openclaw", "", o.Policy())
+	require.NoError(t, err)
+	require.NoError(t, o.RunPending(ctx))
+
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutRolledBack, got.Status)
+	assert.Equal(t, "1 of 1 health probes failed while baking", got.Error)
+	assert.Equal(t, 0, got.Batch, "halted after the canary")
+	assert.Equal(t, []string{ids[0] + " " + newImage, ids[0] + " " + oldImage}, upgrader.upgrades)
+}
+
+func TestRolloutToleratesErrorRate(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 3)
+	ctx := t.Context()
+	upgrader.unhealthy[ids[1]] = true
+
+	policy := o.Policy()
+	policy.MaxErrorRate = 0.5
+	rollout, err := o.Start(ctx, "openclaw", "", policy)
+	require.NoError(t, err)
+	require.NoError(t, o.RunPending(ctx))
+
+	// One of the three tenants failing its probe is within the limit
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutCompleted, got.Status)
+}
+
+func TestRolloutHalt(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 3)
+	ctx := t.Context()
+
+	rollout, err := o.Start(ctx, "openclaw", "", o.Policy())
+	require.NoError(t, err)
+	// The operator halts the rollout while the canary upgrades
+	upgrader.onUpgrade = func(customerID string) {
+		require.NoError(t, database.HaltRollout(ctx, rollout.ID))
+	}
+	require.NoError(t, o.RunPending(ctx))
+
+	got, err := database.GetRollout(ctx, rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutRolledBack, got.Status)
+	assert.Equal(t, "halted by operator", got.Error)
+	assert.Equal(t, oldImage, imageOf(t, database, ids[0]))
+	assert.Len(t, upgrader.upgrades, 2)
+}
+
+func TestRolloutResumes(t *testing.T) {
+	o, database, upgrader, ids := newTestOrchestrator(t, 3)
+
+	rollout, err := o.Start(t.Context(), "openclaw", "", o.Policy())
+	require.NoError(t, err)
+
+	// Shutting down during the first batch leaves the rollout where it was
+	ctx, cancel := context.WithCancel(t.Context())
+	upgrader.onUpgrade = func(customerID string) {
+		if customerID != ids[0] {
+			cancel()
+		}
+	}
+	assert.ErrorIs(t, o.RunPending(ctx), context.Canceled)
+
+	got, err := database.GetRollout(t.Context(), rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutRolling, got.Status)
+	assert.Equal(t, 1, got.Batch)
+
+	upgrader.onUpgrade = nil
+	upgrader.upgrades = nil
+	require.NoError(t, o.RunPending(t.Context()))
+	got, err = database.GetRollout(t.Context(), rollout.ID)
+	require.NoError(t, err)
+	assert.Equal(t, db.RolloutCompleted, got.Status)
+	assert.Equal(t, db.RolloutProgress{Total: 3, Upgraded: 3}, got.Progress)
+	assert.NotContains(t, upgrader.upgrades, ids[0]+" "+newImage, "the canary is not upgraded twice")
+}
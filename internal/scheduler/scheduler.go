@@ -0,0 +1,319 @@
+// Package scheduler places tenants on worker nodes with enough free memory,
+// CPU and host ports for their plan.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"blytz/internal/db"
+)
+
+var (
+	// ErrNoNodes means no worker nodes are registered and tenants run on the control-plane host
+	ErrNoNodes = errors.New("no worker nodes registered")
+	// ErrNoCapacity means no active node has room for the tenant
+	ErrNoCapacity = errors.New("no node has capacity for the tenant")
+)
+
+// Requirements are what a tenant needs from the node it runs on
+type Requirements struct {
+	MemoryMB int
+	CPUs     float64
+	// Labels must all be set on the node with the same values
+	Labels map[string]string
+}
+
+// RequirementsForPlan returns the resources a plan's container limits reserve
+func RequirementsForPlan(plan *db.Plan) (Requirements, error) {
+	memory, err := ParseMemoryMB(plan.Memory)
+	if err != nil {
+		return Requirements{}, fmt.Errorf("plan %s: %w", plan.ID, err)
+	}
+	cpus, err := ParseCPUs(plan.CPU)
+	if err != nil {
+		return Requirements{}, fmt.Errorf("plan %s: %w", plan.ID, err)
+	}
+	return Requirements{MemoryMB: memory, CPUs: cpus}, nil
+}
+
+// NodeUsage is a node with the resources reserved by the tenants placed on it
+type NodeUsage struct {
+	db.Node
+	Tenants      int     `json:"tenants"`
+	UsedMemoryMB int     `json:"used_memory_mb"`
+	UsedCPUs     float64 `json:"used_cpus"`
+	UsedPorts    int     `json:"used_ports"`
+
+	ports map[int]bool
+}
+
+// FreeMemoryMB is the memory not reserved by tenants
+func (u *NodeUsage) FreeMemoryMB() int {
+	return u.MemoryMB - u.UsedMemoryMB
+}
+
+// FreeCPUs is the CPU not reserved by tenants
+func (u *NodeUsage) FreeCPUs() float64 {
+	return u.CPUs - u.UsedCPUs
+}
+
+// freePort returns the first port of the lowest block of db.TenantPorts
+// ports in the node's range no tenant holds
+func (u *NodeUsage) freePort() (int, bool) {
+next:
+	for port := u.PortStart; port+db.TenantPorts-1 <= u.PortEnd; port++ {
+		for p := port; p < port+db.TenantPorts; p++ {
+			if u.ports[p] {
+				continue next
+			}
+		}
+		return port, true
+	}
+	return 0, false
+}
+
+// fits reports whether the node can take a tenant with the given requirements
+func (u *NodeUsage) fits(req Requirements) bool {
+	if u.Status != db.NodeActive {
+		return false
+	}
+	for key, value := range req.Labels {
+		if u.Labels[key] != value {
+			return false
+		}
+	}
+	// Allow for float rounding when CPU shares add up exactly to the node's
+	const epsilon = 1e-9
+	return u.FreeMemoryMB() >= req.MemoryMB && u.FreeCPUs()+epsilon >= req.CPUs
+}
+
+// Placement is the node and host port chosen for a tenant
+type Placement struct {
+	Node db.Node
+	Port int
+}
+
+// reservation holds room on a node for a tenant moving there
+type reservation struct {
+	nodeID string
+	port   int
+	req    Requirements
+}
+
+// Scheduler chooses worker nodes for new tenants
+type Scheduler struct {
+	db *db.DB
+	// mu serializes placements so two tenants never pick the same port
+	mu sync.Mutex
+	// reserved is keyed by customer ID
+	reserved map[string]reservation
+}
+
+// New creates a scheduler over the nodes registered in the database
+func New(database *db.DB) *Scheduler {
+	return &Scheduler{db: database, reserved: make(map[string]reservation)}
+}
+
+// Usage returns every registered node with the resources its tenants reserve,
+// counting tenants still moving onto a node
+func (s *Scheduler) Usage(ctx context.Context) ([]NodeUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage(ctx)
+}
+
+// usage computes Usage; the caller holds mu
+func (s *Scheduler) usage(ctx context.Context) ([]NodeUsage, error) {
+	nodes, err := s.db.ListNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := s.db.ListNodeTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]NodeUsage, len(nodes))
+	byID := make(map[string]*NodeUsage, len(nodes))
+	for i, node := range nodes {
+		usage[i] = NodeUsage{Node: node, ports: map[int]bool{}}
+		byID[node.ID] = &usage[i]
+	}
+
+	for _, tenant := range tenants {
+		u, ok := byID[tenant.NodeID]
+		if !ok {
+			continue
+		}
+		memory, err := ParseMemoryMB(tenant.Memory)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant.CustomerID, err)
+		}
+		cpus, err := ParseCPUs(tenant.CPU)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", tenant.CustomerID, err)
+		}
+		u.add(tenant.Port, memory, cpus)
+	}
+
+	for _, r := range s.reserved {
+		if u, ok := byID[r.nodeID]; ok {
+			u.add(r.port, r.req.MemoryMB, r.req.CPUs)
+		}
+	}
+
+	return usage, nil
+}
+
+// add counts a tenant holding the port block starting at port against the node
+func (u *NodeUsage) add(port, memoryMB int, cpus float64) {
+	u.Tenants++
+	u.UsedMemoryMB += memoryMB
+	u.UsedCPUs += cpus
+	for p := port; p < port+db.TenantPorts; p++ {
+		u.ports[p] = true
+	}
+	u.UsedPorts = len(u.ports)
+}
+
+// Place picks the active node with the most free memory that satisfies req
+// and has a free port, and assigns the customer to it. It returns ErrNoNodes
+// when no nodes are registered at all.
+func (s *Scheduler) Place(ctx context.Context, customerID string, req Requirements) (*Placement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage, err := s.usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(usage) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	p, err := choose(usage, req, func(*NodeUsage) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.AssignNode(ctx, customerID, p.Node.ID, p.Port); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reserve holds room for a tenant that is moving to another node while it
+// still runs where it is. The tenant goes to nodeID, or when nodeID is empty
+// to the node Place would pick, never the node it is on now. The reservation
+// counts against the node until Release; record the move with
+// db.AssignNode before releasing it.
+func (s *Scheduler) Reserve(ctx context.Context, customerID, nodeID string, req Requirements) (*Placement, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.reserved[customerID]; ok {
+		return nil, fmt.Errorf("tenant %s is already moving", customerID)
+	}
+
+	usage, err := s.usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(usage) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	tenants, err := s.db.ListNodeTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := ""
+	for _, tenant := range tenants {
+		if tenant.CustomerID == customerID {
+			current = tenant.NodeID
+		}
+	}
+	if nodeID != "" && nodeID == current {
+		return nil, fmt.Errorf("tenant %s is already on node %s", customerID, nodeID)
+	}
+
+	p, err := choose(usage, req, func(u *NodeUsage) bool {
+		if nodeID != "" {
+			return u.ID == nodeID
+		}
+		return u.ID != current
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.reserved[customerID] = reservation{nodeID: p.Node.ID, port: p.Port, req: req}
+	return p, nil
+}
+
+// Release drops a tenant's reservation
+func (s *Scheduler) Release(customerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reserved, customerID)
+}
+
+// choose returns the first eligible node that fits req and has a free port
+func choose(usage []NodeUsage, req Requirements, eligible func(*NodeUsage) bool) (*Placement, error) {
+	// Spread tenants: emptiest node first, then fewest tenants, then by name
+	sort.SliceStable(usage, func(i, j int) bool {
+		if usage[i].FreeMemoryMB() != usage[j].FreeMemoryMB() {
+			return usage[i].FreeMemoryMB() > usage[j].FreeMemoryMB()
+		}
+		return usage[i].Tenants < usage[j].Tenants
+	})
+
+	for i := range usage {
+		if !eligible(&usage[i]) || !usage[i].fits(req) {
+			continue
+		}
+		port, ok := usage[i].freePort()
+		if !ok {
+			continue
+		}
+		return &Placement{Node: usage[i].Node, Port: port}, nil
+	}
+
+	return nil, ErrNoCapacity
+}
+
+// memoryUnits are the Docker memory suffixes in megabytes
+var memoryUnits = map[byte]float64{'k': 1.0 / 1024, 'm': 1, 'g': 1024}
+
+// ParseMemoryMB converts a Docker memory limit such as "512M" or "1G" to megabytes, rounding up
+func ParseMemoryMB(value string) (int, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "b")
+
+	multiplier := 1.0 / (1024 * 1024)
+	if n := len(s); n > 0 {
+		if unit, ok := memoryUnits[s[n-1]]; ok {
+			multiplier, s = unit, s[:n-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory limit %q", value)
+	}
+	return int(math.Ceil(n * multiplier)), nil
+}
+
+// ParseCPUs converts a Docker CPU limit such as "0.5" to a number of CPUs
+func ParseCPUs(value string) (float64, error) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid cpu limit %q", value)
+	}
+	return n, nil
+}
@@ -0,0 +1,285 @@
+package scheduler
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	return database
+}
+
+func addNode(t *testing.T, database *db.DB, name string, memoryMB int, cpus float64, ports int, labels map[string]string) *db.Node {
+	t.Helper()
+	node := &db.Node{
+		Name:      name,
+		Address:   name + ".internal",
+		AgentURL:  "http://" + name + ".internal:9100",
+		MemoryMB:  memoryMB,
+		CPUs:      cpus,
+		PortStart: 30000,
+		PortEnd:   30000 + ports - 1,
+		Labels:    labels,
+	}
+	require.NoError(t, database.CreateNode(t.Context(), node))
+	return node
+}
+
+func addCustomer(t *testing.T, database *db.DB, n int) string {
+	t.Helper()
+	customer, err := database.CreateCustomer(t.Context(), &db.CreateCustomerRequest{
+		Email:              fmt.Sprintf("tenant%d@example.com", n),
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	return customer.ID
+}
+
+// starter is the seeded starter plan's reservation
+var starter = Requirements{MemoryMB: 512, CPUs: 0.25}
+
+func TestParseMemoryMB(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "512M", want: 512},
+		{value: "512m", want: 512},
+		{value: "512MB", want: 512},
+		{value: "1G", want: 1024},
+		{value: "1.5g", want: 1536},
+		{value: "2048k", want: 2},
+		{value: "1048576", want: 1},
+		{value: "1000", want: 1},
+		{value: "", wantErr: true},
+		{value: "lots", wantErr: true},
+		{value: "-1G", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMemoryMB(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRequirementsForPlan(t *testing.T) {
+	req, err := RequirementsForPlan(&db.Plan{ID: "pro", Memory: "1G", CPU: "0.5"})
+	require.NoError(t, err)
+	assert.Equal(t, Requirements{MemoryMB: 1024, CPUs: 0.5}, req)
+
+	_, err = RequirementsForPlan(&db.Plan{ID: "bad", Memory: "1G", CPU: "half"})
+	assert.Error(t, err)
+}
+
+func TestPlaceWithoutNodes(t *testing.T) {
+	database := newTestDB(t)
+	sched := New(database)
+
+	_, err := sched.Place(t.Context(), addCustomer(t, database, 1), starter)
+	assert.ErrorIs(t, err, ErrNoNodes)
+}
+
+func TestPlaceSpreadsAcrossNodes(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	sched := New(database)
+
+	small := addNode(t, database, "small", 1024, 1, 10, nil)
+	large := addNode(t, database, "large", 2048, 1, 10, nil)
+
+	// The emptiest node takes each tenant until the two are even
+	placed := map[string]int{}
+	var ports []int
+	for i := range 6 {
+		p, err := sched.Place(ctx, addCustomer(t, database, i), starter)
+		require.NoError(t, err)
+		placed[p.Node.Name]++
+		if p.Node.ID == large.ID {
+			ports = append(ports, p.Port)
+		}
+	}
+	assert.Equal(t, map[string]int{"large": 4, "small": 2}, placed)
+	assert.Equal(t, []int{30000, 30002, 30004, 30006}, ports, "lowest free port block first")
+
+	usage, err := sched.Usage(ctx)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	for _, u := range usage {
+		assert.Equal(t, 0, u.FreeMemoryMB(), u.Name)
+	}
+
+	// Both nodes are full
+	_, err = sched.Place(ctx, addCustomer(t, database, 6), starter)
+	assert.ErrorIs(t, err, ErrNoCapacity)
+
+	// A tenant leaving frees its memory and port
+	tenants, err := database.ListNodeTenants(ctx)
+	require.NoError(t, err)
+	for _, tenant := range tenants {
+		if tenant.NodeID == small.ID {
+			require.NoError(t, database.ClearCustomerNode(ctx, tenant.CustomerID))
+			break
+		}
+	}
+	p, err := sched.Place(ctx, addCustomer(t, database, 7), starter)
+	require.NoError(t, err)
+	assert.Equal(t, small.ID, p.Node.ID)
+	assert.Equal(t, 30000, p.Port)
+}
+
+func TestPlaceConstraints(t *testing.T) {
+	tests := []struct {
+		name     string
+		nodes    func(t *testing.T, database *db.DB)
+		req      Requirements
+		wantNode string
+		wantErr  error
+	}{
+		{
+			name: "cpu",
+			nodes: func(t *testing.T, database *db.DB) {
+				addNode(t, database, "roomy", 8192, 0.2, 10, nil)
+				addNode(t, database, "busy", 1024, 2, 10, nil)
+			},
+			req:      starter,
+			wantNode: "busy",
+		},
+		{
+			name: "ports",
+			nodes: func(t *testing.T, database *db.DB) {
+				full := addNode(t, database, "full", 8192, 4, 1, nil)
+				require.NoError(t, database.AssignNode(t.Context(), addCustomer(t, database, 100), full.ID, 30000))
+				addNode(t, database, "open", 1024, 1, 10, nil)
+			},
+			req:      starter,
+			wantNode: "open",
+		},
+		{
+			name: "bridge ports",
+			nodes: func(t *testing.T, database *db.DB) {
+				// A tenant at 30001 holds 30002 too, leaving no two free ports in a row
+				crowded := addNode(t, database, "crowded", 8192, 4, 4, nil)
+				require.NoError(t, database.AssignNode(t.Context(), addCustomer(t, database, 100), crowded.ID, 30001))
+				addNode(t, database, "single", 8192, 4, 1, nil)
+				addNode(t, database, "open", 1024, 1, 10, nil)
+			},
+			req:      starter,
+			wantNode: "open",
+		},
+		{
+			name: "labels",
+			nodes: func(t *testing.T, database *db.DB) {
+				addNode(t, database, "us", 8192, 4, 10, map[string]string{"region": "us"})
+				addNode(t, database, "eu", 1024, 1, 10, map[string]string{"region": "eu", "runtime": "runsc"})
+			},
+			req:      Requirements{MemoryMB: 512, CPUs: 0.25, Labels: map[string]string{"region": "eu"}},
+			wantNode: "eu",
+		},
+		{
+			name: "cordoned",
+			nodes: func(t *testing.T, database *db.DB) {
+				drained := addNode(t, database, "drained", 8192, 4, 10, nil)
+				require.NoError(t, database.SetNodeStatus(t.Context(), drained.ID, db.NodeCordoned))
+				addNode(t, database, "serving", 1024, 1, 10, nil)
+			},
+			req:      starter,
+			wantNode: "serving",
+		},
+		{
+			name: "no fit",
+			nodes: func(t *testing.T, database *db.DB) {
+				addNode(t, database, "tiny", 256, 1, 10, nil)
+				cordoned := addNode(t, database, "cordoned", 8192, 4, 10, nil)
+				require.NoError(t, database.SetNodeStatus(t.Context(), cordoned.ID, db.NodeCordoned))
+			},
+			req:     starter,
+			wantErr: ErrNoCapacity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database := newTestDB(t)
+			tt.nodes(t, database)
+
+			customerID := addCustomer(t, database, 1)
+			p, err := New(database).Place(t.Context(), customerID, tt.req)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNode, p.Node.Name)
+
+			customer, err := database.GetCustomerByID(t.Context(), customerID)
+			require.NoError(t, err)
+			require.NotNil(t, customer.NodeID)
+			assert.Equal(t, p.Node.ID, *customer.NodeID)
+			assert.Equal(t, p.Port, *customer.ContainerPort)
+		})
+	}
+}
+
+func TestReserve(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	sched := New(database)
+
+	source := addNode(t, database, "source", 4096, 4, 10, nil)
+	target := addNode(t, database, "target", 1024, 1, 10, nil)
+	moving := addCustomer(t, database, 1)
+	require.NoError(t, database.AssignNode(ctx, moving, source.ID, 30000))
+
+	// The tenant's own node is never picked, even with the most room
+	p, err := sched.Reserve(ctx, moving, "", starter)
+	require.NoError(t, err)
+	assert.Equal(t, target.ID, p.Node.ID)
+	assert.Equal(t, 30000, p.Port)
+
+	_, err = sched.Reserve(ctx, moving, "", starter)
+	assert.Error(t, err, "one move at a time")
+
+	// The reservation holds its resources and port against new placements
+	usage, err := sched.Usage(ctx)
+	require.NoError(t, err)
+	for _, u := range usage {
+		if u.ID == target.ID {
+			assert.Equal(t, 512, u.FreeMemoryMB())
+		}
+	}
+	_, err = sched.Reserve(ctx, addCustomer(t, database, 2), target.ID, starter)
+	require.NoError(t, err)
+	_, err = sched.Reserve(ctx, addCustomer(t, database, 3), target.ID, starter)
+	assert.ErrorIs(t, err, ErrNoCapacity)
+
+	sched.Release(moving)
+	p, err = sched.Reserve(ctx, moving, target.ID, starter)
+	require.NoError(t, err)
+	assert.Equal(t, 30000, p.Port)
+
+	_, err = sched.Reserve(ctx, addCustomer(t, database, 4), source.ID, Requirements{MemoryMB: 8192})
+	assert.ErrorIs(t, err, ErrNoCapacity)
+	sched.Release(moving)
+	_, err = sched.Reserve(ctx, moving, source.ID, starter)
+	assert.Error(t, err, "already on the node")
+}
@@ -0,0 +1,184 @@
+package stripe
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	stripeSDK "github.com/stripe/stripe-go/v84"
+	portalsession "github.com/stripe/stripe-go/v84/billingportal/session"
+	"github.com/stripe/stripe-go/v84/invoice"
+	"github.com/stripe/stripe-go/v84/subscription"
+)
+
+// Billing is the subscription management the dashboard needs from Stripe.
+// Service implements it; handlers depend on the interface so they can be
+// tested without Stripe.
+type Billing interface {
+	CreatePortalSession(stripeCustomerID, returnURL string) (string, error)
+	ListInvoices(stripeCustomerID string, limit int) ([]Invoice, error)
+	GetSubscription(subscriptionID string) (*Subscription, error)
+	ChangePlan(subscriptionID, priceID, planID string) error
+	ResumeSubscription(subscriptionID string) error
+}
+
+var _ Billing = (*Service)(nil)
+
+// Invoice is the part of a Stripe invoice shown to customers. Amounts are in
+// the currency's smallest unit.
+type Invoice struct {
+	ID          string    `json:"id"`
+	Number      string    `json:"number"`
+	Status      string    `json:"status"`
+	AmountDue   int64     `json:"amount_due"`
+	AmountPaid  int64     `json:"amount_paid"`
+	Currency    string    `json:"currency"`
+	Created     time.Time `json:"created"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	HostedURL   string    `json:"hosted_url,omitempty"`
+	PDFURL      string    `json:"pdf_url,omitempty"`
+}
+
+// PaymentMethod summarizes the card a subscription is charged to
+type PaymentMethod struct {
+	Type     string `json:"type"`
+	Brand    string `json:"brand,omitempty"`
+	Last4    string `json:"last4,omitempty"`
+	ExpMonth int64  `json:"exp_month,omitempty"`
+	ExpYear  int64  `json:"exp_year,omitempty"`
+}
+
+// Subscription is the state of a customer's subscription as Stripe sees it
+type Subscription struct {
+	ID                 string         `json:"id"`
+	Status             string         `json:"status"`
+	PlanID             string         `json:"plan_id,omitempty"`
+	PriceID            string         `json:"price_id,omitempty"`
+	CurrentPeriodStart *time.Time     `json:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time     `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd  bool           `json:"cancel_at_period_end"`
+	CancelAt           *time.Time     `json:"cancel_at,omitempty"`
+	TrialEnd           *time.Time     `json:"trial_end,omitempty"`
+	PaymentMethod      *PaymentMethod `json:"payment_method,omitempty"`
+}
+
+// CreatePortalSession returns the URL of a Stripe billing portal session where
+// the customer manages payment methods, invoices and cancellation
+func (s *Service) CreatePortalSession(stripeCustomerID, returnURL string) (string, error) {
+	params := &stripeSDK.BillingPortalSessionParams{
+		Customer:  stripeSDK.String(stripeCustomerID),
+		ReturnURL: stripeSDK.String(returnURL),
+	}
+
+	result, err := portalsession.New(params)
+	if err != nil {
+		return "", fmt.Errorf("create portal session: %w", err)
+	}
+
+	return result.URL, nil
+}
+
+// ListInvoices returns the customer's most recent invoices, newest first
+func (s *Service) ListInvoices(stripeCustomerID string, limit int) ([]Invoice, error) {
+	params := &stripeSDK.InvoiceListParams{Customer: stripeSDK.String(stripeCustomerID)}
+	params.Limit = stripeSDK.Int64(int64(limit))
+	params.Single = true
+
+	invoices := []Invoice{}
+	iter := invoice.List(params)
+	for iter.Next() {
+		inv := iter.Invoice()
+		invoices = append(invoices, Invoice{
+			ID:          inv.ID,
+			Number:      inv.Number,
+			Status:      string(inv.Status),
+			AmountDue:   inv.AmountDue,
+			AmountPaid:  inv.AmountPaid,
+			Currency:    string(inv.Currency),
+			Created:     unixTime(inv.Created),
+			PeriodStart: unixTime(inv.PeriodStart),
+			PeriodEnd:   unixTime(inv.PeriodEnd),
+			HostedURL:   inv.HostedInvoiceURL,
+			PDFURL:      inv.InvoicePDF,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("list invoices: %w", err)
+	}
+
+	return invoices, nil
+}
+
+// GetSubscription returns the subscription with the payment method it is
+// charged to, falling back to the customer's default
+func (s *Service) GetSubscription(subscriptionID string) (*Subscription, error) {
+	params := &stripeSDK.SubscriptionParams{}
+	params.AddExpand("default_payment_method")
+	params.AddExpand("customer.invoice_settings.default_payment_method")
+
+	sub, err := subscription.Get(subscriptionID, params)
+	if err != nil {
+		return nil, fmt.Errorf("get subscription: %w", err)
+	}
+
+	result := &Subscription{
+		ID:                sub.ID,
+		Status:            string(sub.Status),
+		PlanID:            sub.Metadata[PlanMetadataKey],
+		CancelAtPeriodEnd: sub.CancelAtPeriodEnd,
+	}
+	if sub.CancelAt > 0 {
+		cancelAt := unixTime(sub.CancelAt)
+		result.CancelAt = &cancelAt
+	}
+	if sub.TrialEnd > 0 {
+		trialEnd := unixTime(sub.TrialEnd)
+		result.TrialEnd = &trialEnd
+	}
+
+	// Billing periods live on the items in current API versions
+	if item := s.planItem(sub); item != nil {
+		if item.Price != nil {
+			result.PriceID = item.Price.ID
+		}
+		if item.CurrentPeriodStart > 0 {
+			start, end := unixTime(item.CurrentPeriodStart), unixTime(item.CurrentPeriodEnd)
+			result.CurrentPeriodStart, result.CurrentPeriodEnd = &start, &end
+		}
+	}
+
+	pm := sub.DefaultPaymentMethod
+	if pm == nil && sub.Customer != nil && sub.Customer.InvoiceSettings != nil {
+		pm = sub.Customer.InvoiceSettings.DefaultPaymentMethod
+	}
+	if pm != nil {
+		result.PaymentMethod = &PaymentMethod{Type: string(pm.Type)}
+		if pm.Card != nil {
+			result.PaymentMethod.Brand = string(pm.Card.Brand)
+			result.PaymentMethod.Last4 = pm.Card.Last4
+			result.PaymentMethod.ExpMonth = pm.Card.ExpMonth
+			result.PaymentMethod.ExpYear = pm.Card.ExpYear
+		}
+	}
+
+	return result, nil
+}
+
+// planItem returns the subscription item carrying the flat plan price rather
+// than a metered price
+func (s *Service) planItem(sub *stripeSDK.Subscription) *stripeSDK.SubscriptionItem {
+	if sub.Items == nil {
+		return nil
+	}
+	for _, item := range sub.Items.Data {
+		if item.Price != nil && !slices.Contains(s.meteredPriceIDs, item.Price.ID) {
+			return item
+		}
+	}
+	return nil
+}
+
+func unixTime(sec int64) time.Time {
+	return time.Unix(sec, 0).UTC()
+}
@@ -0,0 +1,151 @@
+package stripe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripeSDK "github.com/stripe/stripe-go/v84"
+)
+
+// stubStripeAPI points the Stripe SDK's package-level API calls at handler for the test
+func stubStripeAPI(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	previous := stripeSDK.GetBackend(stripeSDK.APIBackend)
+	stripeSDK.SetBackend(stripeSDK.APIBackend, stripeSDK.GetBackendWithConfig(stripeSDK.APIBackend, &stripeSDK.BackendConfig{
+		URL:               stripeSDK.String(srv.URL),
+		MaxNetworkRetries: stripeSDK.Int64(0),
+		LeveledLogger:     &stripeSDK.LeveledLogger{Level: stripeSDK.LevelNull},
+	}))
+	t.Cleanup(func() { stripeSDK.SetBackend(stripeSDK.APIBackend, previous) })
+}
+
+func TestCreatePortalSession(t *testing.T) {
+	var form url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/billing_portal/sessions", r.URL.Path)
+		_ = r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"id":"bps_123","object":"billing_portal.session","url":"https://billing.stripe.com/p/session/bps_123"}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+	portalURL, err := svc.CreatePortalSession("cus_123", "https://blytz.cloud/dashboard")
+	require.NoError(t, err)
+	assert.Equal(t, "https://billing.stripe.com/p/session/bps_123", portalURL)
+	assert.Equal(t, "cus_123", form.Get("customer"))
+	assert.Equal(t, "https://blytz.cloud/dashboard", form.Get("return_url"))
+}
+
+func TestListInvoices(t *testing.T) {
+	var query url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/invoices", r.URL.Path)
+		query = r.URL.Query()
+		w.Write([]byte(`{"object":"list","has_more":true,"data":[
+			{"id":"in_2","object":"invoice","number":"BLYTZ-0002","status":"open","amount_due":7900,"amount_paid":0,
+			 "currency":"usd","created":1773500000,"period_start":1773400000,"period_end":1773500000,
+			 "hosted_invoice_url":"https://invoice.stripe.com/i/in_2","invoice_pdf":"https://pay.stripe.com/invoice/in_2/pdf"},
+			{"id":"in_1","object":"invoice","number":"BLYTZ-0001","status":"paid","amount_due":2900,"amount_paid":2900,
+			 "currency":"usd","created":1770900000,"period_start":1770800000,"period_end":1770900000}]}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+	invoices, err := svc.ListInvoices("cus_123", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "cus_123", query.Get("customer"))
+	assert.Equal(t, "2", query.Get("limit"))
+
+	// A single page is returned even when Stripe has more
+	require.Len(t, invoices, 2)
+	assert.Equal(t, Invoice{
+		ID:          "in_2",
+		Number:      "BLYTZ-0002",
+		Status:      "open",
+		AmountDue:   7900,
+		Currency:    "usd",
+		Created:     time.Unix(1773500000, 0).UTC(),
+		PeriodStart: time.Unix(1773400000, 0).UTC(),
+		PeriodEnd:   time.Unix(1773500000, 0).UTC(),
+		HostedURL:   "https://invoice.stripe.com/i/in_2",
+		PDFURL:      "https://pay.stripe.com/invoice/in_2/pdf",
+	}, invoices[0])
+	assert.Equal(t, int64(2900), invoices[1].AmountPaid)
+}
+
+func TestGetSubscription(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCard *PaymentMethod
+	}{
+		{
+			name: "subscription payment method",
+			body: `{"id":"sub_123","object":"subscription","status":"active","cancel_at_period_end":true,"cancel_at":1775000000,
+				"metadata":{"plan_id":"pro"},
+				"default_payment_method":{"id":"pm_1","object":"payment_method","type":"card",
+					"card":{"brand":"visa","last4":"4242","exp_month":12,"exp_year":2030}},
+				"items":{"object":"list","data":[
+					{"id":"si_metered","price":{"id":"price_metered"},"current_period_start":1773400000,"current_period_end":1775000000},
+					{"id":"si_flat","price":{"id":"price_pro"},"current_period_start":1773400000,"current_period_end":1775000000}]}}`,
+			wantCard: &PaymentMethod{Type: "card", Brand: "visa", Last4: "4242", ExpMonth: 12, ExpYear: 2030},
+		},
+		{
+			name: "customer default payment method",
+			body: `{"id":"sub_123","object":"subscription","status":"active","metadata":{"plan_id":"pro"},
+				"customer":{"id":"cus_123","object":"customer","invoice_settings":{"default_payment_method":
+					{"id":"pm_2","object":"payment_method","type":"card","card":{"brand":"mastercard","last4":"4444","exp_month":1,"exp_year":2031}}}},
+				"items":{"object":"list","data":[
+					{"id":"si_flat","price":{"id":"price_pro"},"current_period_start":1773400000,"current_period_end":1775000000}]}}`,
+			wantCard: &PaymentMethod{Type: "card", Brand: "mastercard", Last4: "4444", ExpMonth: 1, ExpYear: 2031},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query url.Values
+			stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v1/subscriptions/sub_123", r.URL.Path)
+				query = r.URL.Query()
+				w.Write([]byte(tt.body))
+			})
+
+			svc := NewService("sk_test_123", "price_default", "price_metered")
+			sub, err := svc.GetSubscription("sub_123")
+			require.NoError(t, err)
+			assert.Equal(t, "default_payment_method", query.Get("expand[0]"))
+			assert.Equal(t, "customer.invoice_settings.default_payment_method", query.Get("expand[1]"))
+
+			assert.Equal(t, "active", sub.Status)
+			assert.Equal(t, "pro", sub.PlanID)
+			assert.Equal(t, "price_pro", sub.PriceID)
+			require.NotNil(t, sub.CurrentPeriodEnd)
+			assert.True(t, time.Unix(1775000000, 0).Equal(*sub.CurrentPeriodEnd))
+			assert.Equal(t, tt.wantCard, sub.PaymentMethod)
+		})
+	}
+}
+
+func TestGetSubscriptionError(t *testing.T) {
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"No such subscription"}}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+	_, err := svc.GetSubscription("sub_missing")
+	assert.Error(t, err)
+
+	_, err = svc.ListInvoices("cus_missing", 10)
+	assert.Error(t, err)
+}
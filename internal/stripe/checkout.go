@@ -1,27 +1,68 @@
 package stripe
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	stripeSDK "github.com/stripe/stripe-go/v84"
 	"github.com/stripe/stripe-go/v84/checkout/session"
+	"github.com/stripe/stripe-go/v84/promotioncode"
 )
 
+// ErrInvalidPromotionCode is returned for a promotion code that does not
+// exist or can no longer be redeemed
+var ErrInvalidPromotionCode = errors.New("invalid promotion code")
+
+// ErrCheckoutCompleted is returned when expiring a checkout session the
+// customer has already paid
+var ErrCheckoutCompleted = errors.New("checkout session already completed")
+
 type Service struct {
-	secretKey string
-	priceID   string
+	secretKey       string
+	priceID         string
+	meteredPriceIDs []string
 }
 
-func NewService(secretKey, priceID string) *Service {
+// NewService creates the checkout service. Metered prices are added to every
+// subscription alongside the flat price and billed from reported usage.
+func NewService(secretKey, priceID string, meteredPriceIDs ...string) *Service {
 	stripeSDK.Key = secretKey
 	return &Service{
-		secretKey: secretKey,
-		priceID:   priceID,
+		secretKey:       secretKey,
+		priceID:         priceID,
+		meteredPriceIDs: meteredPriceIDs,
 	}
 }
 
-func (s *Service) CreateCheckoutSession(customerID, email string) (string, error) {
+// CheckoutOptions customizes the subscription a checkout session creates
+type CheckoutOptions struct {
+	// PriceID is the customer's plan price; the default price is used when empty
+	PriceID string
+	// TrialDays starts the subscription with a free trial when positive
+	TrialDays int
+	// PromotionCodeID applies a resolved promotion code to the subscription.
+	// Without one the customer may enter a code on the checkout page.
+	PromotionCodeID string
+}
+
+// CheckoutSession identifies a checkout session and the page where the
+// customer completes it
+type CheckoutSession struct {
+	ID  string
+	URL string
+}
+
+// CreateCheckoutSession starts a subscription checkout for the customer.
+// Trials do not require a payment method up front; a trial that ends without
+// one pauses the subscription, which Stripe reports with
+// customer.subscription.paused.
+func (s *Service) CreateCheckoutSession(customerID, email string, opts CheckoutOptions) (*CheckoutSession, error) {
+	priceID := opts.PriceID
+	if priceID == "" {
+		priceID = s.priceID
+	}
+
 	domain := os.Getenv("BASE_DOMAIN")
 	if domain == "" {
 		domain = "localhost:8080"
@@ -30,7 +71,7 @@ func (s *Service) CreateCheckoutSession(customerID, email string) (string, error
 	params := &stripeSDK.CheckoutSessionParams{
 		LineItems: []*stripeSDK.CheckoutSessionLineItemParams{
 			{
-				Price:    stripeSDK.String(s.priceID),
+				Price:    stripeSDK.String(priceID),
 				Quantity: stripeSDK.Int64(1),
 			},
 		},
@@ -43,10 +84,82 @@ func (s *Service) CreateCheckoutSession(customerID, email string) (string, error
 		},
 	}
 
+	for _, id := range s.meteredPriceIDs {
+		// Metered prices take no quantity; Stripe bills the meter's usage
+		params.LineItems = append(params.LineItems, &stripeSDK.CheckoutSessionLineItemParams{
+			Price: stripeSDK.String(id),
+		})
+	}
+
+	if opts.TrialDays > 0 {
+		params.SubscriptionData = &stripeSDK.CheckoutSessionSubscriptionDataParams{
+			TrialPeriodDays: stripeSDK.Int64(int64(opts.TrialDays)),
+			TrialSettings: &stripeSDK.CheckoutSessionSubscriptionDataTrialSettingsParams{
+				EndBehavior: &stripeSDK.CheckoutSessionSubscriptionDataTrialSettingsEndBehaviorParams{
+					MissingPaymentMethod: stripeSDK.String("pause"),
+				},
+			},
+		}
+		params.PaymentMethodCollection = stripeSDK.String("if_required")
+	}
+
+	// Stripe rejects sessions that both apply a discount and allow codes
+	if opts.PromotionCodeID != "" {
+		params.Discounts = []*stripeSDK.CheckoutSessionDiscountParams{
+			{PromotionCode: stripeSDK.String(opts.PromotionCodeID)},
+		}
+	} else {
+		params.AllowPromotionCodes = stripeSDK.Bool(true)
+	}
+
 	result, err := session.New(params)
 	if err != nil {
-		return "", fmt.Errorf("create checkout session: %w", err)
+		return nil, fmt.Errorf("create checkout session: %w", err)
+	}
+
+	return &CheckoutSession{ID: result.ID, URL: result.URL}, nil
+}
+
+// ExpireCheckoutSession closes an open checkout session so it can no longer
+// be paid. Sessions that already expired are left as they are; paid sessions
+// return ErrCheckoutCompleted.
+func (s *Service) ExpireCheckoutSession(sessionID string) error {
+	current, err := session.Get(sessionID, nil)
+	if err != nil {
+		return fmt.Errorf("get checkout session: %w", err)
+	}
+
+	switch current.Status {
+	case stripeSDK.CheckoutSessionStatusComplete:
+		return ErrCheckoutCompleted
+	case stripeSDK.CheckoutSessionStatusExpired:
+		return nil
+	}
+
+	if _, err := session.Expire(sessionID, nil); err != nil {
+		return fmt.Errorf("expire checkout session: %w", err)
+	}
+
+	return nil
+}
+
+// ResolvePromotionCode returns the ID of the active promotion code customers
+// know as code
+func (s *Service) ResolvePromotionCode(code string) (string, error) {
+	params := &stripeSDK.PromotionCodeListParams{
+		Code:   stripeSDK.String(code),
+		Active: stripeSDK.Bool(true),
+	}
+	params.Limit = stripeSDK.Int64(1)
+	params.Single = true
+
+	iter := promotioncode.List(params)
+	if iter.Next() {
+		return iter.PromotionCode().ID, nil
+	}
+	if err := iter.Err(); err != nil {
+		return "", fmt.Errorf("list promotion codes: %w", err)
 	}
 
-	return result.URL, nil
+	return "", ErrInvalidPromotionCode
 }
@@ -0,0 +1,88 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+// CheckoutJanitor removes signups that never completed checkout. Pending
+// customers count toward capacity and hold their email address, so an
+// abandoned checkout would otherwise block both indefinitely.
+type CheckoutJanitor struct {
+	db       *db.DB
+	checkout *Service
+	ttl      time.Duration
+	logger   *zap.Logger
+	now      func() time.Time
+}
+
+// NewCheckoutJanitor creates a janitor expiring signups left pending for longer than ttl
+func NewCheckoutJanitor(database *db.DB, checkout *Service, ttl time.Duration, logger *zap.Logger) *CheckoutJanitor {
+	return &CheckoutJanitor{
+		db:       database,
+		checkout: checkout,
+		ttl:      ttl,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// ExpireAbandoned expires the checkout session of every signup pending longer
+// than the TTL and erases the customer. It returns the number of signups removed.
+func (j *CheckoutJanitor) ExpireAbandoned(ctx context.Context) (int, error) {
+	signups, err := j.db.ListPendingSignups(ctx, j.now().Add(-j.ttl))
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, signup := range signups {
+		// An expired session can no longer be paid, so the customer cannot
+		// complete checkout after their row is gone
+		if signup.CheckoutSessionID != "" {
+			err := j.checkout.ExpireCheckoutSession(signup.CheckoutSessionID)
+			if errors.Is(err, ErrCheckoutCompleted) {
+				// Paid; checkout.session.completed will activate the customer
+				continue
+			}
+			if err != nil {
+				j.logger.Error("Failed to expire checkout session", zap.String("customer_id", signup.CustomerID), zap.Error(err))
+				continue
+			}
+		}
+
+		pseudonym := "expired-" + uuid.New().String()
+		if err := j.db.EraseCustomer(ctx, signup.CustomerID, pseudonym); err != nil {
+			j.logger.Error("Failed to remove abandoned signup", zap.String("customer_id", signup.CustomerID), zap.Error(err))
+			continue
+		}
+
+		j.logger.Info("Abandoned signup removed", zap.String("pseudonym", pseudonym))
+		expired++
+	}
+
+	return expired, nil
+}
+
+// Run removes abandoned signups every interval until ctx is cancelled
+func (j *CheckoutJanitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := j.ExpireAbandoned(ctx); err != nil {
+				j.logger.Error("Failed to remove abandoned signups", zap.Error(err))
+			}
+		}
+	}
+}
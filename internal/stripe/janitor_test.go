@@ -0,0 +1,104 @@
+package stripe
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+func TestExpireCheckoutSession(t *testing.T) {
+	var expired []string
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/checkout/sessions/")
+		if r.Method == http.MethodPost {
+			expired = append(expired, strings.TrimSuffix(id, "/expire"))
+			w.Write([]byte(`{"id":"cs","object":"checkout.session","status":"expired"}`))
+			return
+		}
+		status := strings.TrimPrefix(id, "cs_")
+		w.Write([]byte(`{"id":"` + id + `","object":"checkout.session","status":"` + status + `"}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+
+	require.NoError(t, svc.ExpireCheckoutSession("cs_open"))
+	require.NoError(t, svc.ExpireCheckoutSession("cs_expired"))
+	assert.ErrorIs(t, svc.ExpireCheckoutSession("cs_complete"), ErrCheckoutCompleted)
+	assert.Equal(t, []string{"cs_open"}, expired)
+}
+
+func TestCheckoutJanitor(t *testing.T) {
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/v1/checkout/sessions/")
+		switch {
+		case id == "cs_unreachable":
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":{"type":"api_error","message":"boom"}}`))
+		case id == "cs_paid":
+			w.Write([]byte(`{"id":"cs_paid","object":"checkout.session","status":"complete"}`))
+		default:
+			w.Write([]byte(`{"id":"cs","object":"checkout.session","status":"open"}`))
+		}
+	})
+
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+	ctx := t.Context()
+
+	create := func(email, sessionID string) *db.Customer {
+		customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+			Email:              email,
+			AssistantName:      "Test",
+			CustomInstructions: "Help me",
+			TelegramBotToken:   "123:abc",
+		})
+		require.NoError(t, err)
+		if sessionID != "" {
+			require.NoError(t, database.SetCheckoutSession(ctx, customer.ID, sessionID))
+		}
+		return customer
+	}
+
+	abandoned := create("abandoned@example.com", "cs_abandoned")
+	legacy := create("legacy@example.com", "")
+	paid := create("paid@example.com", "cs_paid")
+	unreachable := create("unreachable@example.com", "cs_unreachable")
+
+	janitor := NewCheckoutJanitor(database, NewService("sk_test_123", "price_default"), time.Hour, zap.NewNop())
+
+	// Nothing is older than the TTL yet
+	removed, err := janitor.ExpireAbandoned(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+
+	janitor.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	removed, err = janitor.ExpireAbandoned(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	for _, gone := range []*db.Customer{abandoned, legacy} {
+		_, err := database.GetCustomerByID(ctx, gone.ID)
+		assert.Error(t, err, gone.Email)
+	}
+
+	// A paid session waits for its webhook; a Stripe failure is retried next run
+	for _, kept := range []*db.Customer{paid, unreachable} {
+		customer, err := database.GetCustomerByID(ctx, kept.ID)
+		require.NoError(t, err, kept.Email)
+		assert.Equal(t, "pending", customer.Status)
+	}
+
+	// The freed email can sign up again
+	existing, err := database.GetCustomerByEmail(ctx, "abandoned@example.com")
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+}
@@ -2,10 +2,13 @@ package stripe
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -52,7 +55,7 @@ func TestCreateCheckoutSession(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			svc := NewService("sk_test_123", "price_test_456")
-			url, err := svc.CreateCheckoutSession(tt.customerID, tt.email)
+			checkout, err := svc.CreateCheckoutSession(tt.customerID, tt.email, CheckoutOptions{})
 
 			// Without real Stripe API, this will fail, but we test the structure
 			if tt.expectError {
@@ -60,7 +63,7 @@ func TestCreateCheckoutSession(t *testing.T) {
 				_ = err
 			} else {
 				// In real scenario, would check for valid URL
-				_ = url
+				_ = checkout
 			}
 		})
 	}
@@ -247,3 +250,316 @@ func TestWebhookEventParsing(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "test-123", data["metadata"].(map[string]interface{})["customer_id"])
 }
+
+func TestHandleSubscriptionUpdated(t *testing.T) {
+	start := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "period on the subscription",
+			payload: map[string]interface{}{
+				"customer":             "cus_test_123",
+				"status":               "active",
+				"current_period_start": start.Unix(),
+				"current_period_end":   end.Unix(),
+			},
+		},
+		{
+			name: "period on subscription items",
+			payload: map[string]interface{}{
+				"customer": "cus_test_123",
+				"status":   "active",
+				"items": map[string]interface{}{
+					"data": []map[string]interface{}{
+						{"current_period_start": start.Unix(), "current_period_end": end.Unix()},
+					},
+				},
+			},
+		},
+		{
+			name:    "no period",
+			payload: map[string]interface{}{"customer": "cus_test_123", "status": "active"},
+			wantErr: true,
+		},
+		{
+			name: "unknown customer",
+			payload: map[string]interface{}{
+				"customer":             "cus_missing",
+				"status":               "active",
+				"current_period_start": start.Unix(),
+				"current_period_end":   end.Unix(),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			database, err := db.New(":memory:")
+			require.NoError(t, err)
+			defer database.Close()
+			require.NoError(t, database.Migrate())
+
+			ctx := t.Context()
+			customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+				Email:              "test@example.com",
+				AssistantName:      "Test",
+				CustomInstructions: "Help me",
+				TelegramBotToken:   "123:abc",
+			})
+			require.NoError(t, err)
+			require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_test_123", "sub_test_456"))
+
+			handler := NewWebhookHandler(database, nil, "whsec_test")
+			data, _ := json.Marshal(tt.payload)
+			err = handler.handleSubscriptionUpdated(ctx, data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			updated, err := database.GetCustomerByID(ctx, customer.ID)
+			require.NoError(t, err)
+			require.NotNil(t, updated.CurrentPeriodStart)
+			assert.True(t, start.Equal(*updated.CurrentPeriodStart))
+			assert.True(t, end.Equal(*updated.CurrentPeriodEnd))
+		})
+	}
+}
+
+func TestHandleSubscriptionPlanChange(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "test@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_test_123", "sub_test_456"))
+
+	tmpDir := t.TempDir()
+	prov := provisioner.NewService(database, tmpDir, tmpDir, "", 30000, 30005, nil, "localhost", nil)
+	handler := NewWebhookHandler(database, prov, "whsec_test")
+
+	start := time.Now().Add(-time.Hour)
+	event := func(planID string) json.RawMessage {
+		data, _ := json.Marshal(map[string]interface{}{
+			"customer":             "cus_test_123",
+			"status":               "active",
+			"current_period_start": start.Unix(),
+			"current_period_end":   start.AddDate(0, 1, 0).Unix(),
+			"metadata":             map[string]string{PlanMetadataKey: planID},
+		})
+		return data
+	}
+
+	require.NoError(t, handler.handleSubscriptionUpdated(ctx, event("pro")))
+	updated, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pro", updated.PlanID)
+
+	// Redelivery of the same plan is a no-op
+	require.NoError(t, handler.handleSubscriptionUpdated(ctx, event("pro")))
+
+	assert.Error(t, handler.handleSubscriptionUpdated(ctx, event("missing")))
+	updated, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "pro", updated.PlanID)
+}
+
+func TestChangePlan(t *testing.T) {
+	var updates []url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"id":"sub_123","object":"subscription","items":{"object":"list","data":[
+				{"id":"si_metered","price":{"id":"price_metered"}},
+				{"id":"si_flat","price":{"id":"price_default"}}]}}`))
+			return
+		}
+		_ = r.ParseForm()
+		updates = append(updates, r.PostForm)
+		w.Write([]byte(`{"id":"sub_123","object":"subscription"}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default", "price_metered")
+
+	require.NoError(t, svc.ChangePlan("sub_123", "price_pro", "pro"))
+	require.Len(t, updates, 1)
+	assert.Equal(t, "si_flat", updates[0].Get("items[0][id]"))
+	assert.Equal(t, "price_pro", updates[0].Get("items[0][price]"))
+	assert.Equal(t, "create_prorations", updates[0].Get("proration_behavior"))
+	assert.Equal(t, "pro", updates[0].Get("metadata[plan_id]"))
+
+	// Plans without their own price move back to the default price
+	require.NoError(t, svc.ChangePlan("sub_123", "", "starter"))
+	assert.Equal(t, "price_default", updates[1].Get("items[0][price]"))
+}
+
+func TestCreateCheckoutSessionPrices(t *testing.T) {
+	var form url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"id":"cs_test","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test"}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default", "price_metered")
+
+	checkout, err := svc.CreateCheckoutSession("cust-1", "test@example.com", CheckoutOptions{PriceID: "price_pro"})
+	require.NoError(t, err)
+	assert.Equal(t, &CheckoutSession{ID: "cs_test", URL: "https://checkout.stripe.com/c/pay/cs_test"}, checkout)
+	assert.Equal(t, "price_pro", form.Get("line_items[0][price]"))
+	assert.Equal(t, "1", form.Get("line_items[0][quantity]"))
+	assert.Equal(t, "price_metered", form.Get("line_items[1][price]"))
+	assert.Empty(t, form.Get("line_items[1][quantity]"))
+	assert.Equal(t, "cust-1", form.Get("metadata[customer_id]"))
+
+	assert.Equal(t, "true", form.Get("allow_promotion_codes"))
+	assert.Empty(t, form.Get("subscription_data[trial_period_days]"))
+
+	_, err = svc.CreateCheckoutSession("cust-2", "test@example.com", CheckoutOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "price_default", form.Get("line_items[0][price]"))
+}
+
+func TestCreateCheckoutSessionTrialAndPromotion(t *testing.T) {
+	var form url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"id":"cs_test","object":"checkout.session","url":"https://checkout.stripe.com/c/pay/cs_test"}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+	_, err := svc.CreateCheckoutSession("cust-1", "test@example.com", CheckoutOptions{
+		TrialDays:       14,
+		PromotionCodeID: "promo_123",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "14", form.Get("subscription_data[trial_period_days]"))
+	assert.Equal(t, "pause", form.Get("subscription_data[trial_settings][end_behavior][missing_payment_method]"))
+	assert.Equal(t, "if_required", form.Get("payment_method_collection"))
+	assert.Equal(t, "promo_123", form.Get("discounts[0][promotion_code]"))
+	assert.Empty(t, form.Get("allow_promotion_codes"))
+}
+
+func TestResolvePromotionCode(t *testing.T) {
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/promotion_codes", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("active"))
+		if r.URL.Query().Get("code") == "LAUNCH20" {
+			w.Write([]byte(`{"object":"list","data":[{"id":"promo_123","object":"promotion_code","code":"LAUNCH20"}]}`))
+			return
+		}
+		w.Write([]byte(`{"object":"list","data":[]}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+
+	id, err := svc.ResolvePromotionCode("LAUNCH20")
+	require.NoError(t, err)
+	assert.Equal(t, "promo_123", id)
+
+	_, err = svc.ResolvePromotionCode("EXPIRED")
+	assert.ErrorIs(t, err, ErrInvalidPromotionCode)
+}
+
+func TestResumeSubscription(t *testing.T) {
+	var path string
+	var form url.Values
+	stubStripeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		_ = r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"id":"sub_123","object":"subscription","status":"active"}`))
+	})
+
+	svc := NewService("sk_test_123", "price_default")
+	require.NoError(t, svc.ResumeSubscription("sub_123"))
+	assert.Equal(t, "/v1/subscriptions/sub_123/resume", path)
+	assert.Equal(t, "now", form.Get("billing_cycle_anchor"))
+}
+
+func TestHandleTrialLifecycle(t *testing.T) {
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	defer database.Close()
+	require.NoError(t, database.Migrate())
+
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "test@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, "cus_test_123", "sub_test_456"))
+
+	prov := &recordingProvisioner{db: database}
+	handler := NewWebhookHandler(database, prov, "whsec_test")
+
+	trialEnd := time.Date(2026, 3, 28, 12, 0, 0, 0, time.UTC)
+	data, _ := json.Marshal(map[string]interface{}{"customer": "cus_test_123", "trial_end": trialEnd.Unix()})
+	require.NoError(t, handler.handleTrialWillEnd(ctx, data))
+
+	notifications, err := database.ListNotifications(ctx, customer.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "trial_will_end", notifications[0].Kind)
+	assert.Contains(t, notifications[0].Message, "March 28, 2026")
+
+	data, _ = json.Marshal(map[string]interface{}{"customer": "cus_test_123"})
+
+	// Resuming a tenant that was never suspended is a no-op
+	require.NoError(t, handler.handleSubscriptionResumed(ctx, data))
+	assert.Empty(t, prov.calls)
+
+	require.NoError(t, handler.handleSubscriptionPaused(ctx, data))
+	updated, err := database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "suspended", updated.Status)
+
+	notifications, err = database.ListNotifications(ctx, customer.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, "trial_ended", notifications[0].Kind)
+
+	require.NoError(t, handler.handleSubscriptionResumed(ctx, data))
+	assert.Equal(t, []string{"suspend", "resume"}, prov.calls)
+
+	missing, _ := json.Marshal(map[string]interface{}{"customer": "cus_missing"})
+	assert.Error(t, handler.handleTrialWillEnd(ctx, missing))
+	assert.Error(t, handler.handleSubscriptionPaused(ctx, missing))
+}
+
+// recordingProvisioner records suspend and resume calls, updating the status
+// as the real provisioner does
+type recordingProvisioner struct {
+	provisioner.Provisioner
+	db    *db.DB
+	calls []string
+}
+
+func (p *recordingProvisioner) Suspend(ctx context.Context, customerID string) error {
+	p.calls = append(p.calls, "suspend")
+	return p.db.UpdateCustomerStatus(ctx, customerID, "suspended")
+}
+
+func (p *recordingProvisioner) Resume(ctx context.Context, customerID string) error {
+	p.calls = append(p.calls, "resume")
+	return p.db.UpdateCustomerStatus(ctx, customerID, "active")
+}
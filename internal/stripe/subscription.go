@@ -0,0 +1,74 @@
+package stripe
+
+import (
+	"fmt"
+
+	stripeSDK "github.com/stripe/stripe-go/v84"
+	"github.com/stripe/stripe-go/v84/subscription"
+)
+
+// PlanMetadataKey names the subscription metadata recording the customer's plan
+const PlanMetadataKey = "plan_id"
+
+// ChangePlan moves a subscription's flat price to priceID, prorating the
+// remainder of the period, and records planID on the subscription. The
+// platform applies the new plan when Stripe confirms with
+// customer.subscription.updated.
+func (s *Service) ChangePlan(subscriptionID, priceID, planID string) error {
+	if priceID == "" {
+		priceID = s.priceID
+	}
+
+	sub, err := subscription.Get(subscriptionID, nil)
+	if err != nil {
+		return fmt.Errorf("get subscription: %w", err)
+	}
+
+	// Metered prices stay; only the flat plan price changes
+	item := s.planItem(sub)
+	if item == nil {
+		return fmt.Errorf("subscription %s has no plan price", subscriptionID)
+	}
+
+	params := &stripeSDK.SubscriptionParams{
+		Items: []*stripeSDK.SubscriptionItemsParams{
+			{
+				ID:    stripeSDK.String(item.ID),
+				Price: stripeSDK.String(priceID),
+			},
+		},
+		ProrationBehavior: stripeSDK.String("create_prorations"),
+	}
+	params.AddMetadata(PlanMetadataKey, planID)
+
+	if _, err := subscription.Update(subscriptionID, params); err != nil {
+		return fmt.Errorf("update subscription: %w", err)
+	}
+
+	return nil
+}
+
+// ResumeSubscription restarts a subscription paused when its trial ended
+// without a payment method, invoicing the customer immediately. The tenant is
+// resumed when Stripe confirms with customer.subscription.resumed.
+func (s *Service) ResumeSubscription(subscriptionID string) error {
+	params := &stripeSDK.SubscriptionResumeParams{
+		BillingCycleAnchor: stripeSDK.String("now"),
+	}
+
+	if _, err := subscription.Resume(subscriptionID, params); err != nil {
+		return fmt.Errorf("resume subscription: %w", err)
+	}
+
+	return nil
+}
+
+// CancelSubscription ends a subscription immediately, without invoicing the
+// remainder of the period
+func (s *Service) CancelSubscription(subscriptionID string) error {
+	if _, err := subscription.Cancel(subscriptionID, nil); err != nil {
+		return fmt.Errorf("cancel subscription: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package stripe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	stripeSDK "github.com/stripe/stripe-go/v84"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+// Metered usage metrics
+const (
+	MetricMessages  = "messages"
+	MetricLLMTokens = "llm_tokens"
+)
+
+// reportGrace is how long after a period closes late usage is still reported.
+// Stripe finalizes the period's invoice about an hour after it ends.
+const reportGrace = time.Hour
+
+// meterEventMaxAge is how far back Stripe accepts meter event timestamps
+const meterEventMaxAge = 35 * 24 * time.Hour
+
+// MeterEvents names the Stripe meter event each metric is reported under; an
+// empty name leaves that metric unbilled
+type MeterEvents struct {
+	Messages  string
+	LLMTokens string
+}
+
+// MetricUsage is one metric's total for a billing period and how much of it Stripe has
+type MetricUsage struct {
+	Metric  string `json:"metric"`
+	Value   int64  `json:"value"`
+	Metered bool   `json:"metered"`
+	// Quota is the plan's allowance for the period; 0 means unlimited
+	Quota      int64      `json:"quota,omitempty"`
+	Reported   int64      `json:"reported"`
+	ReportedAt *time.Time `json:"reported_at,omitempty"`
+}
+
+// PeriodUsage is a customer's metered usage for one billing period
+type PeriodUsage struct {
+	PeriodStart time.Time     `json:"period_start"`
+	PeriodEnd   time.Time     `json:"period_end"`
+	Metrics     []MetricUsage `json:"metrics"`
+}
+
+// UsageReporter reports each customer's metered usage to Stripe as meter events.
+// Every report sends the growth of the period total since the last accepted
+// report, under an identifier derived from the total, so retries are deduplicated.
+type UsageReporter struct {
+	db     *db.DB
+	client *stripeSDK.Client
+	events MeterEvents
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+// NewUsageReporter creates a reporter sending meter events with the given Stripe key
+func NewUsageReporter(database *db.DB, secretKey string, events MeterEvents, logger *zap.Logger) *UsageReporter {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &UsageReporter{
+		db:     database,
+		client: stripeSDK.NewClient(secretKey),
+		events: events,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Enabled reports whether any metric is billed through Stripe
+func (r *UsageReporter) Enabled() bool {
+	return r.events.Messages != "" || r.events.LLMTokens != ""
+}
+
+// BillingPeriod returns the customer's current billing period. It follows the
+// period Stripe last sent, rolled forward monthly if a renewal was missed, and
+// falls back to the calendar month in UTC before the subscription is known.
+func BillingPeriod(customer *db.Customer, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	if customer.CurrentPeriodStart == nil || customer.CurrentPeriodEnd == nil ||
+		!customer.CurrentPeriodEnd.After(*customer.CurrentPeriodStart) {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+
+	start, end := customer.CurrentPeriodStart.UTC(), customer.CurrentPeriodEnd.UTC()
+	for !now.Before(end) {
+		start, end = end, end.AddDate(0, 1, 0)
+	}
+	return start, end
+}
+
+// CurrentUsage returns the customer's usage so far in the current billing period
+func (r *UsageReporter) CurrentUsage(ctx context.Context, customer *db.Customer) (*PeriodUsage, error) {
+	start, end := BillingPeriod(customer, r.now())
+	usage, err := r.db.GetMeteredUsage(ctx, customer.ID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := r.db.GetPlan(ctx, customer.PlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	period := &PeriodUsage{PeriodStart: start, PeriodEnd: end}
+	for _, metric := range []string{MetricMessages, MetricLLMTokens} {
+		m := MetricUsage{
+			Metric:  metric,
+			Value:   metricValue(usage, metric),
+			Metered: r.eventName(metric) != "",
+		}
+		if metric == MetricMessages {
+			m.Quota = int64(plan.MonthlyMessages)
+		}
+		report, err := r.db.GetUsageReport(ctx, customer.ID, metric, start)
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			m.Reported = report.ReportedValue
+			m.ReportedAt = report.ReportedAt
+		}
+		period.Metrics = append(period.Metrics, m)
+	}
+
+	return period, nil
+}
+
+// Run reports usage on the given interval until the context is cancelled
+func (r *UsageReporter) Run(ctx context.Context, interval time.Duration) {
+	if !r.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.ReportAll(ctx); err != nil {
+			r.logger.Error("Usage report failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReportAll reports the current period of every billed customer, then any
+// closed period still inside the grace window or holding an unsent total
+func (r *UsageReporter) ReportAll(ctx context.Context) error {
+	now := r.now().UTC()
+
+	ids, err := r.db.ListCustomerIDsByStatus(ctx, "active", "degraded", "suspended")
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]time.Time, len(ids))
+	for _, id := range ids {
+		customer, err := r.db.GetCustomerByID(ctx, id)
+		if err != nil {
+			r.logger.Warn("Skipping usage report", zap.String("customer_id", id), zap.Error(err))
+			continue
+		}
+		if customer.StripeCustomerID == nil || *customer.StripeCustomerID == "" {
+			continue
+		}
+
+		start, end := BillingPeriod(customer, now)
+		current[id] = start
+		r.reportPeriod(ctx, customer, start, end, now)
+	}
+
+	unsettled, err := r.db.ListUnsettledUsageReports(ctx, now.Add(-reportGrace))
+	if err != nil {
+		return err
+	}
+
+	done := make(map[string]bool)
+	for _, report := range unsettled {
+		key := report.CustomerID + "/" + report.PeriodStart.String()
+		if start, ok := current[report.CustomerID]; (ok && start.Equal(report.PeriodStart)) || done[key] {
+			continue
+		}
+		done[key] = true
+
+		customer, err := r.db.GetCustomerByID(ctx, report.CustomerID)
+		if err != nil || customer.StripeCustomerID == nil || *customer.StripeCustomerID == "" {
+			continue
+		}
+		r.reportPeriod(ctx, customer, report.PeriodStart, report.PeriodEnd, now)
+	}
+
+	return nil
+}
+
+// reportPeriod reports every billed metric for one period, logging failures so
+// one customer cannot hold up the rest
+func (r *UsageReporter) reportPeriod(ctx context.Context, customer *db.Customer, start, end, now time.Time) {
+	var usage *db.MeteredUsage
+	for _, metric := range []string{MetricMessages, MetricLLMTokens} {
+		if r.eventName(metric) == "" {
+			continue
+		}
+
+		report, err := r.db.GetUsageReport(ctx, customer.ID, metric, start)
+		if err != nil {
+			r.logger.Error("Failed to load usage report", zap.String("customer_id", customer.ID), zap.Error(err))
+			return
+		}
+
+		if report == nil || report.PendingValue == nil {
+			if usage == nil {
+				if usage, err = r.db.GetMeteredUsage(ctx, customer.ID, start, end); err != nil {
+					r.logger.Error("Failed to total metered usage", zap.String("customer_id", customer.ID), zap.Error(err))
+					return
+				}
+			}
+			var reported int64
+			if report != nil {
+				reported = report.ReportedValue
+			}
+			total := metricValue(usage, metric)
+			if total <= reported {
+				continue
+			}
+			if err := r.db.SetPendingUsageReport(ctx, customer.ID, metric, start, end, total); err != nil {
+				r.logger.Error("Failed to record pending usage report", zap.String("customer_id", customer.ID), zap.Error(err))
+				return
+			}
+			report = &db.UsageReport{ReportedValue: reported, PendingValue: &total}
+		}
+
+		if err := r.send(ctx, customer, metric, start, end, report, now); err != nil {
+			r.logger.Error("Failed to report usage to Stripe",
+				zap.String("customer_id", customer.ID),
+				zap.String("metric", metric),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		if err := r.db.CompleteUsageReport(ctx, customer.ID, metric, start); err != nil {
+			r.logger.Error("Failed to complete usage report", zap.String("customer_id", customer.ID), zap.Error(err))
+		}
+	}
+}
+
+// send creates the meter event carrying a report's pending growth, timestamped
+// inside the period so Stripe bills it on that period's invoice
+func (r *UsageReporter) send(ctx context.Context, customer *db.Customer, metric string, start, end time.Time, report *db.UsageReport, now time.Time) error {
+	total := *report.PendingValue
+	delta := total - report.ReportedValue
+	if delta <= 0 {
+		return nil
+	}
+
+	at := now
+	if !at.Before(end) {
+		at = end.Add(-time.Second)
+	}
+	if now.Sub(at) > meterEventMaxAge {
+		return fmt.Errorf("period ended %s ago, past what Stripe accepts", now.Sub(end).Round(time.Hour))
+	}
+
+	params := &stripeSDK.BillingMeterEventCreateParams{
+		EventName:  stripeSDK.String(r.eventName(metric)),
+		Identifier: stripeSDK.String(fmt.Sprintf("%s-%s-%d-%d", customer.ID, metric, start.Unix(), total)),
+		Payload: map[string]string{
+			"stripe_customer_id": *customer.StripeCustomerID,
+			"value":              fmt.Sprintf("%d", delta),
+		},
+		Timestamp: stripeSDK.Int64(at.Unix()),
+	}
+
+	if _, err := r.client.V1BillingMeterEvents.Create(ctx, params); err != nil {
+		return fmt.Errorf("create meter event: %w", err)
+	}
+	return nil
+}
+
+func (r *UsageReporter) eventName(metric string) string {
+	switch metric {
+	case MetricMessages:
+		return r.events.Messages
+	case MetricLLMTokens:
+		return r.events.LLMTokens
+	}
+	return ""
+}
+
+func metricValue(usage *db.MeteredUsage, metric string) int64 {
+	switch metric {
+	case MetricMessages:
+		return usage.Messages
+	case MetricLLMTokens:
+		return usage.LLMTokens
+	}
+	return 0
+}
@@ -0,0 +1,305 @@
+package stripe
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripeSDK "github.com/stripe/stripe-go/v84"
+
+	"blytz/internal/db"
+)
+
+type meterEvent struct {
+	EventName  string
+	Identifier string
+	Customer   string
+	Value      int64
+	Timestamp  time.Time
+}
+
+// meterStub stands in for Stripe's meter events API
+type meterStub struct {
+	mu     sync.Mutex
+	events []meterEvent
+	fail   bool
+}
+
+func (s *meterStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.URL.Path != "/v1/billing/meter_events" || r.Method != http.MethodPost {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if s.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error":{"type":"api_error","message":"unavailable"}}`)
+		return
+	}
+
+	_ = r.ParseForm()
+	value, _ := strconv.ParseInt(r.PostForm.Get("payload[value]"), 10, 64)
+	ts, _ := strconv.ParseInt(r.PostForm.Get("timestamp"), 10, 64)
+	s.events = append(s.events, meterEvent{
+		EventName:  r.PostForm.Get("event_name"),
+		Identifier: r.PostForm.Get("identifier"),
+		Customer:   r.PostForm.Get("payload[stripe_customer_id]"),
+		Value:      value,
+		Timestamp:  time.Unix(ts, 0).UTC(),
+	})
+	fmt.Fprintf(w, `{"object":"billing.meter_event","event_name":%q}`, r.PostForm.Get("event_name"))
+}
+
+func (s *meterStub) take() []meterEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+func setupUsageReporter(t *testing.T, events MeterEvents) (*UsageReporter, *db.DB, *meterStub) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	stub := &meterStub{}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	reporter := NewUsageReporter(database, "sk_test_123", events, nil)
+	reporter.client = stripeSDK.NewClient("sk_test_123", stripeSDK.WithBackends(
+		stripeSDK.NewBackendsWithConfig(&stripeSDK.BackendConfig{
+			URL:               stripeSDK.String(srv.URL),
+			MaxNetworkRetries: stripeSDK.Int64(0),
+			LeveledLogger:     &stripeSDK.LeveledLogger{Level: stripeSDK.LevelNull},
+		}),
+	))
+	return reporter, database, stub
+}
+
+func createBilledCustomer(t *testing.T, database *db.DB, email, stripeID string, start, end time.Time) *db.Customer {
+	t.Helper()
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateStripeInfo(ctx, customer.ID, stripeID, "sub_"+stripeID))
+	require.NoError(t, database.UpdateSubscriptionPeriod(ctx, stripeID, "active", start, end))
+
+	customer, err = database.GetCustomerByID(ctx, customer.ID)
+	require.NoError(t, err)
+	return customer
+}
+
+var (
+	testPeriodStart = time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	testPeriodEnd   = testPeriodStart.AddDate(0, 1, 0)
+)
+
+func TestBillingPeriod(t *testing.T) {
+	start, end := testPeriodStart, testPeriodEnd
+
+	tests := []struct {
+		name      string
+		customer  *db.Customer
+		now       time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "no subscription period uses the calendar month",
+			customer:  &db.Customer{},
+			now:       time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "inside the subscription period",
+			customer:  &db.Customer{CurrentPeriodStart: &start, CurrentPeriodEnd: &end},
+			now:       start.Add(time.Hour),
+			wantStart: start,
+			wantEnd:   end,
+		},
+		{
+			name:      "missed renewal rolls forward",
+			customer:  &db.Customer{CurrentPeriodStart: &start, CurrentPeriodEnd: &end},
+			now:       end.AddDate(0, 1, 1),
+			wantStart: end.AddDate(0, 1, 0),
+			wantEnd:   end.AddDate(0, 2, 0),
+		},
+		{
+			name:      "period end belongs to the next period",
+			customer:  &db.Customer{CurrentPeriodStart: &start, CurrentPeriodEnd: &end},
+			now:       end,
+			wantStart: end,
+			wantEnd:   end.AddDate(0, 1, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStart, gotEnd := BillingPeriod(tt.customer, tt.now)
+			assert.True(t, tt.wantStart.Equal(gotStart), "start %s", gotStart)
+			assert.True(t, tt.wantEnd.Equal(gotEnd), "end %s", gotEnd)
+		})
+	}
+}
+
+func TestUsageReporterReportsGrowth(t *testing.T) {
+	reporter, database, stub := setupUsageReporter(t, MeterEvents{Messages: "agent_messages", LLMTokens: "llm_tokens"})
+	ctx := t.Context()
+	now := testPeriodStart.Add(48 * time.Hour)
+	reporter.now = func() time.Time { return now }
+
+	customer := createBilledCustomer(t, database, "metered@example.com", "cus_metered", testPeriodStart, testPeriodEnd)
+	unbilled, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email: "trial@example.com", AssistantName: "Test", CustomInstructions: "Help", TelegramBotToken: "123:abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, database.UpdateCustomerStatus(ctx, unbilled.ID, "active"))
+
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: now.Add(-time.Hour), Messages: 5}}))
+	require.NoError(t, database.RecordUsage(ctx, unbilled.ID, []db.UsageEvent{{OccurredAt: now.Add(-time.Hour), Messages: 9}}))
+	require.NoError(t, database.RecordLLMUsage(ctx, customer.ID, db.LLMUsage{
+		OccurredAt: now.Add(-time.Hour), Provider: "openai", Model: "gpt-4o", InputTokens: 800, OutputTokens: 200,
+	}))
+
+	require.NoError(t, reporter.ReportAll(ctx))
+	events := stub.take()
+	require.Len(t, events, 2)
+	assert.Equal(t, meterEvent{
+		EventName:  "agent_messages",
+		Identifier: fmt.Sprintf("%s-messages-%d-5", customer.ID, testPeriodStart.Unix()),
+		Customer:   "cus_metered",
+		Value:      5,
+		Timestamp:  now,
+	}, events[0])
+	assert.Equal(t, "llm_tokens", events[1].EventName)
+	assert.Equal(t, int64(1000), events[1].Value)
+
+	// Nothing new to report
+	require.NoError(t, reporter.ReportAll(ctx))
+	assert.Empty(t, stub.take())
+
+	// Only the growth since the last report is sent
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: now, Messages: 3}}))
+	require.NoError(t, reporter.ReportAll(ctx))
+	events = stub.take()
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(3), events[0].Value)
+	assert.Equal(t, fmt.Sprintf("%s-messages-%d-8", customer.ID, testPeriodStart.Unix()), events[0].Identifier)
+
+	usage, err := reporter.CurrentUsage(ctx, customer)
+	require.NoError(t, err)
+	assert.True(t, testPeriodStart.Equal(usage.PeriodStart))
+	assert.True(t, testPeriodEnd.Equal(usage.PeriodEnd))
+	require.Len(t, usage.Metrics, 2)
+	assert.Equal(t, MetricUsage{Metric: MetricMessages, Value: 8, Metered: true, Quota: 2000, Reported: 8, ReportedAt: usage.Metrics[0].ReportedAt}, usage.Metrics[0])
+	assert.NotNil(t, usage.Metrics[0].ReportedAt)
+	assert.Equal(t, int64(1000), usage.Metrics[1].Reported)
+}
+
+func TestUsageReporterResendsAfterFailure(t *testing.T) {
+	reporter, database, stub := setupUsageReporter(t, MeterEvents{Messages: "agent_messages"})
+	ctx := t.Context()
+	now := testPeriodStart.Add(48 * time.Hour)
+	reporter.now = func() time.Time { return now }
+
+	customer := createBilledCustomer(t, database, "retry@example.com", "cus_retry", testPeriodStart, testPeriodEnd)
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: now, Messages: 5}}))
+
+	stub.fail = true
+	require.NoError(t, reporter.ReportAll(ctx))
+	assert.Empty(t, stub.take())
+
+	// Usage that arrives after the failed attempt waits for the next report, so
+	// the retry carries the same identifier and Stripe can deduplicate it
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: now, Messages: 2}}))
+	stub.fail = false
+	require.NoError(t, reporter.ReportAll(ctx))
+	events := stub.take()
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(5), events[0].Value)
+	assert.Equal(t, fmt.Sprintf("%s-messages-%d-5", customer.ID, testPeriodStart.Unix()), events[0].Identifier)
+
+	require.NoError(t, reporter.ReportAll(ctx))
+	events = stub.take()
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(2), events[0].Value)
+
+	usage, err := reporter.CurrentUsage(ctx, customer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), usage.Metrics[0].Reported)
+	assert.False(t, usage.Metrics[1].Metered)
+	assert.Zero(t, usage.Metrics[1].Reported)
+}
+
+func TestUsageReporterFinalizesClosedPeriod(t *testing.T) {
+	reporter, database, stub := setupUsageReporter(t, MeterEvents{Messages: "agent_messages"})
+	ctx := t.Context()
+	now := testPeriodEnd.Add(-2 * time.Hour)
+	reporter.now = func() time.Time { return now }
+
+	customer := createBilledCustomer(t, database, "closed@example.com", "cus_closed", testPeriodStart, testPeriodEnd)
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: now, Messages: 4}}))
+	require.NoError(t, reporter.ReportAll(ctx))
+	require.Len(t, stub.take(), 1)
+
+	// Usage from the last hour of the period is reported after it closes,
+	// timestamped inside it so it lands on that period's invoice
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{
+		{OccurredAt: testPeriodEnd.Add(-40 * time.Minute), Messages: 6},
+		{OccurredAt: testPeriodEnd.Add(5 * time.Minute), Messages: 1},
+	}))
+	now = testPeriodEnd.Add(10 * time.Minute)
+	require.NoError(t, reporter.ReportAll(ctx))
+
+	events := stub.take()
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(1), events[0].Value, "new period")
+	assert.Equal(t, now, events[0].Timestamp)
+	assert.Equal(t, int64(6), events[1].Value, "closed period")
+	assert.Equal(t, testPeriodEnd.Add(-time.Second), events[1].Timestamp)
+	assert.Equal(t, fmt.Sprintf("%s-messages-%d-10", customer.ID, testPeriodStart.Unix()), events[1].Identifier)
+
+	// Past the grace window the closed period is left alone
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: testPeriodEnd.Add(-40 * time.Minute), Messages: 1}}))
+	now = testPeriodEnd.Add(2 * time.Hour)
+	require.NoError(t, reporter.ReportAll(ctx))
+	assert.Empty(t, stub.take())
+}
+
+func TestUsageReporterDisabled(t *testing.T) {
+	reporter, database, stub := setupUsageReporter(t, MeterEvents{})
+	ctx := t.Context()
+	assert.False(t, reporter.Enabled())
+
+	customer := createBilledCustomer(t, database, "flat@example.com", "cus_flat", testPeriodStart, testPeriodEnd)
+	reporter.now = func() time.Time { return testPeriodStart.Add(time.Hour) }
+	require.NoError(t, database.RecordUsage(ctx, customer.ID, []db.UsageEvent{{OccurredAt: testPeriodStart.Add(time.Hour), Messages: 5}}))
+
+	require.NoError(t, reporter.ReportAll(ctx))
+	assert.Empty(t, stub.take())
+
+	// Usage is still shown, just not billed
+	usage, err := reporter.CurrentUsage(ctx, customer)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), usage.Metrics[0].Value)
+	assert.False(t, usage.Metrics[0].Metered)
+}
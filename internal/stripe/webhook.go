@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v84/webhook"
 
 	"blytz/internal/db"
 	"blytz/internal/provisioner"
+	"blytz/internal/telemetry"
 )
 
 type WebhookHandler struct {
@@ -37,30 +39,44 @@ func (h *WebhookHandler) HandleWebhook(c *gin.Context) {
 
 	event, err := webhook.ConstructEvent(payload, c.GetHeader("Stripe-Signature"), h.webhookSecret)
 	if err != nil {
+		telemetry.RecordWebhookEvent("unknown", "invalid")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid signature"})
 		return
 	}
 
 	ctx := c.Request.Context()
 
+	var handle func(context.Context, json.RawMessage) error
 	switch event.Type {
 	case "checkout.session.completed":
-		if err := h.handleCheckoutCompleted(ctx, event.Data.Raw); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+		handle = h.handleCheckoutCompleted
+	case "customer.subscription.created", "customer.subscription.updated":
+		handle = h.handleSubscriptionUpdated
 	case "customer.subscription.deleted":
-		if err := h.handleSubscriptionDeleted(ctx, event.Data.Raw); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+		handle = h.handleSubscriptionDeleted
+	case "customer.subscription.trial_will_end":
+		handle = h.handleTrialWillEnd
+	case "customer.subscription.paused":
+		handle = h.handleSubscriptionPaused
+	case "customer.subscription.resumed":
+		handle = h.handleSubscriptionResumed
 	case "invoice.payment_failed":
-		if err := h.handlePaymentFailed(ctx, event.Data.Raw); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
+		handle = h.handlePaymentFailed
+	}
+
+	if handle == nil {
+		telemetry.RecordWebhookEvent(string(event.Type), "ignored")
+		c.JSON(http.StatusOK, gin.H{"received": true})
+		return
 	}
 
+	if err := handle(ctx, event.Data.Raw); err != nil {
+		telemetry.RecordWebhookEvent(string(event.Type), "error")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	telemetry.RecordWebhookEvent(string(event.Type), "processed")
 	c.JSON(http.StatusOK, gin.H{"received": true})
 }
 
@@ -88,6 +104,64 @@ func (h *WebhookHandler) handleCheckoutCompleted(ctx context.Context, data json.
 	return nil
 }
 
+// handleSubscriptionUpdated records the billing period usage is reported against
+// and applies a plan change made through ChangePlan.
+// Newer API versions carry the period on subscription items rather than the subscription.
+// A subscription created before its checkout completes is not matched yet and
+// fails, so Stripe redelivers it.
+func (h *WebhookHandler) handleSubscriptionUpdated(ctx context.Context, data json.RawMessage) error {
+	var subscription struct {
+		Customer           string            `json:"customer"`
+		Status             string            `json:"status"`
+		Metadata           map[string]string `json:"metadata"`
+		CurrentPeriodStart int64             `json:"current_period_start"`
+		CurrentPeriodEnd   int64             `json:"current_period_end"`
+		Items              struct {
+			Data []struct {
+				CurrentPeriodStart int64 `json:"current_period_start"`
+				CurrentPeriodEnd   int64 `json:"current_period_end"`
+			} `json:"data"`
+		} `json:"items"`
+	}
+
+	if err := json.Unmarshal(data, &subscription); err != nil {
+		return fmt.Errorf("unmarshal subscription: %w", err)
+	}
+
+	start, end := subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd
+	if start == 0 && len(subscription.Items.Data) > 0 {
+		start, end = subscription.Items.Data[0].CurrentPeriodStart, subscription.Items.Data[0].CurrentPeriodEnd
+	}
+	if start == 0 || end <= start {
+		return fmt.Errorf("subscription has no billing period")
+	}
+
+	if err := h.db.UpdateSubscriptionPeriod(ctx, subscription.Customer, subscription.Status,
+		time.Unix(start, 0), time.Unix(end, 0)); err != nil {
+		return fmt.Errorf("update subscription period: %w", err)
+	}
+
+	planID := subscription.Metadata[PlanMetadataKey]
+	if planID == "" {
+		return nil
+	}
+
+	customer, err := h.db.GetCustomerByStripeID(ctx, subscription.Customer)
+	if err != nil {
+		return fmt.Errorf("get customer by stripe id: %w", err)
+	}
+
+	// The plan is recorded only once the tenant is resized, so a failed
+	// resize is retried when Stripe redelivers the event
+	if customer.PlanID != planID {
+		if err := h.provisioner.ChangePlan(ctx, customer.ID, planID); err != nil {
+			return fmt.Errorf("change plan: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (h *WebhookHandler) handleSubscriptionDeleted(ctx context.Context, data json.RawMessage) error {
 	var subscription struct {
 		Customer string `json:"customer"`
@@ -133,3 +207,85 @@ func (h *WebhookHandler) handlePaymentFailed(ctx context.Context, data json.RawM
 
 	return nil
 }
+
+// handleTrialWillEnd notifies the customer three days before their trial
+// ends. Trials that end with a payment method convert to paid subscriptions;
+// the rest are paused.
+func (h *WebhookHandler) handleTrialWillEnd(ctx context.Context, data json.RawMessage) error {
+	var subscription struct {
+		Customer string `json:"customer"`
+		TrialEnd int64  `json:"trial_end"`
+	}
+
+	if err := json.Unmarshal(data, &subscription); err != nil {
+		return fmt.Errorf("unmarshal subscription: %w", err)
+	}
+
+	customer, err := h.db.GetCustomerByStripeID(ctx, subscription.Customer)
+	if err != nil {
+		return fmt.Errorf("get customer by stripe id: %w", err)
+	}
+
+	message := fmt.Sprintf("Your free trial ends on %s. Add a payment method in the billing portal to keep your assistant running; without one it will be suspended.",
+		time.Unix(subscription.TrialEnd, 0).UTC().Format("January 2, 2006"))
+	if err := h.db.CreateNotification(ctx, customer.ID, "trial_will_end", message); err != nil {
+		return fmt.Errorf("notify customer: %w", err)
+	}
+
+	return nil
+}
+
+// handleSubscriptionPaused suspends a tenant whose trial ended without a
+// payment method
+func (h *WebhookHandler) handleSubscriptionPaused(ctx context.Context, data json.RawMessage) error {
+	var subscription struct {
+		Customer string `json:"customer"`
+	}
+
+	if err := json.Unmarshal(data, &subscription); err != nil {
+		return fmt.Errorf("unmarshal subscription: %w", err)
+	}
+
+	customer, err := h.db.GetCustomerByStripeID(ctx, subscription.Customer)
+	if err != nil {
+		return fmt.Errorf("get customer by stripe id: %w", err)
+	}
+
+	if err := h.provisioner.Suspend(ctx, customer.ID); err != nil {
+		return fmt.Errorf("suspend container: %w", err)
+	}
+
+	message := "Your free trial has ended and your assistant is suspended. Add a payment method in the billing portal and resume your subscription to restart it."
+	if err := h.db.CreateNotification(ctx, customer.ID, "trial_ended", message); err != nil {
+		return fmt.Errorf("notify customer: %w", err)
+	}
+
+	return nil
+}
+
+// handleSubscriptionResumed restarts a tenant suspended when its subscription
+// was paused
+func (h *WebhookHandler) handleSubscriptionResumed(ctx context.Context, data json.RawMessage) error {
+	var subscription struct {
+		Customer string `json:"customer"`
+	}
+
+	if err := json.Unmarshal(data, &subscription); err != nil {
+		return fmt.Errorf("unmarshal subscription: %w", err)
+	}
+
+	customer, err := h.db.GetCustomerByStripeID(ctx, subscription.Customer)
+	if err != nil {
+		return fmt.Errorf("get customer by stripe id: %w", err)
+	}
+
+	if customer.Status != "suspended" {
+		return nil
+	}
+
+	if err := h.provisioner.Resume(ctx, customer.ID); err != nil {
+		return fmt.Errorf("resume container: %w", err)
+	}
+
+	return nil
+}
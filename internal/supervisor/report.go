@@ -0,0 +1,74 @@
+package supervisor
+
+import (
+	"context"
+	"time"
+
+	"blytz/internal/db"
+)
+
+const (
+	reportWindow = 24 * time.Hour
+	reportChecks = 50
+)
+
+// Report summarises a tenant's health for the dashboard status panel
+type Report struct {
+	CustomerID     string           `json:"customer_id"`
+	Status         string           `json:"status"` // "operational", "failing", "degraded" or "unknown"
+	UptimePercent  *float64         `json:"uptime_percent"`
+	ChecksInWindow int              `json:"checks_in_window"`
+	WindowHours    int              `json:"window_hours"`
+	Current        *TenantState     `json:"current,omitempty"`
+	Incidents      []db.AuditEntry  `json:"incidents"`
+	Recent         []db.HealthCheck `json:"recent"`
+}
+
+// Report builds the last 24 hours of health history for a tenant
+func (s *Supervisor) Report(ctx context.Context, customerID string) (*Report, error) {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	since := s.now().Add(-reportWindow)
+
+	total, healthy, err := s.db.CountHealthChecks(ctx, customerID, since)
+	if err != nil {
+		return nil, err
+	}
+	recent, err := s.db.ListHealthChecks(ctx, customerID, since, reportChecks)
+	if err != nil {
+		return nil, err
+	}
+	incidents, err := s.db.ListHealthEvents(ctx, customerID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		CustomerID:     customerID,
+		ChecksInWindow: total,
+		WindowHours:    int(reportWindow / time.Hour),
+		Current:        s.State(customerID),
+		Incidents:      incidents,
+		Recent:         recent,
+	}
+	if total > 0 {
+		uptime := float64(healthy) / float64(total) * 100
+		report.UptimePercent = &uptime
+	}
+
+	switch {
+	case customer.Status == "degraded":
+		report.Status = "degraded"
+	case len(recent) == 0:
+		report.Status = "unknown"
+	case recent[0].Healthy:
+		report.Status = "operational"
+	default:
+		report.Status = "failing"
+	}
+
+	return report, nil
+}
@@ -0,0 +1,318 @@
+// Package supervisor probes each tenant agent's health endpoint and restarts
+// containers that stop answering, backing off and eventually giving up.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+// supervisedStatuses are the customer statuses whose agents are expected to be running.
+// Degraded tenants are still probed so they can recover, but never restarted.
+var supervisedStatuses = []string{"active", "degraded"}
+
+// Restarter restarts a tenant's containers in place
+type Restarter interface {
+	Restart(ctx context.Context, customerID string) error
+}
+
+// Policy controls when a failing tenant is restarted and when the supervisor gives up
+type Policy struct {
+	// FailureThreshold is the number of consecutive failed probes before a restart
+	FailureThreshold int
+	// BackoffBase is the minimum wait after the first restart; it doubles with each further restart
+	BackoffBase time.Duration
+	// BackoffMax caps the wait between restarts
+	BackoffMax time.Duration
+	// CrashLoopLimit is the number of restarts without a healthy probe before the tenant is marked degraded
+	CrashLoopLimit int
+	// ProbeTimeout bounds a single health request
+	ProbeTimeout time.Duration
+	// Retention is how long probe history is kept
+	Retention time.Duration
+}
+
+// DefaultPolicy returns the policy used when nothing is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		FailureThreshold: 3,
+		BackoffBase:      30 * time.Second,
+		BackoffMax:       10 * time.Minute,
+		CrashLoopLimit:   5,
+		ProbeTimeout:     5 * time.Second,
+		Retention:        7 * 24 * time.Hour,
+	}
+}
+
+// backoff returns how long to wait after the given number of restarts
+func (p Policy) backoff(restarts int) time.Duration {
+	wait := p.BackoffBase
+	for i := 1; i < restarts && wait < p.BackoffMax; i++ {
+		wait *= 2
+	}
+	return min(wait, p.BackoffMax)
+}
+
+// TenantState is the supervisor's in-memory view of one tenant
+type TenantState struct {
+	Healthy             bool            `json:"healthy"`
+	ConsecutiveFailures int             `json:"consecutive_failures"`
+	Restarts            int             `json:"restarts"` // since the last healthy probe
+	NextRestartAt       *time.Time      `json:"next_restart_at,omitempty"`
+	LastCheck           *db.HealthCheck `json:"last_check,omitempty"`
+}
+
+// Supervisor polls tenant health endpoints and applies the restart policy
+type Supervisor struct {
+	db        *db.DB
+	restarter Restarter
+	client    *http.Client
+	host      string
+	policy    Policy
+	logger    *zap.Logger
+	now       func() time.Time
+
+	mu      sync.RWMutex
+	tenants map[string]*TenantState
+}
+
+// New creates a supervisor that probes agents on their allocated host ports,
+// or on their internal network
+func New(database *db.DB, restarter Restarter, policy Policy, logger *zap.Logger) *Supervisor {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return &Supervisor{
+		db:        database,
+		restarter: restarter,
+		client:    &http.Client{Timeout: policy.ProbeTimeout},
+		host:      "127.0.0.1",
+		policy:    policy,
+		logger:    logger,
+		now:       time.Now,
+		tenants:   make(map[string]*TenantState),
+	}
+}
+
+// ProbeAll probes every supervised tenant once, applies the restart policy and
+// prunes expired history. Failures for one tenant do not stop the others.
+func (s *Supervisor) ProbeAll(ctx context.Context) error {
+	ids, err := s.db.ListCustomerIDsByStatus(ctx, supervisedStatuses...)
+	if err != nil {
+		return err
+	}
+
+	endpoints := make(map[string]string)
+	hosts := make(map[string]string)
+	var errs []error
+	for _, id := range ids {
+		if err := s.probeTenant(ctx, id, endpoints, hosts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+	s.forgetExcept(ids)
+
+	if _, err := s.db.PruneHealthChecks(ctx, s.now().Add(-s.policy.Retention)); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Run probes all tenants every interval until ctx is cancelled
+func (s *Supervisor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ProbeAll(ctx); err != nil {
+				s.logger.Warn("Tenant health probing incomplete", zap.Error(err))
+			}
+		}
+	}
+}
+
+// State returns a copy of the supervisor's view of a tenant, or nil if it has not been probed
+func (s *Supervisor) State(customerID string) *TenantState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.tenants[customerID]
+	if !ok {
+		return nil
+	}
+	cp := *state
+	return &cp
+}
+
+// probeTenant probes one tenant, caching health endpoints by agent type and
+// host addresses by node across the round
+func (s *Supervisor) probeTenant(ctx context.Context, customerID string, endpoints, hosts map[string]string) error {
+	customer, err := s.db.GetCustomerByID(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	if customer.ContainerPort == nil {
+		return nil
+	}
+
+	endpoint, ok := endpoints[customer.AgentTypeID]
+	if !ok {
+		agentType, err := s.db.GetAgentType(ctx, customer.AgentTypeID)
+		if err != nil {
+			return err
+		}
+		endpoint = agentType.HealthEndpoint
+		endpoints[customer.AgentTypeID] = endpoint
+	}
+
+	// Tenants on worker nodes publish their port on the node's address;
+	// tenants on an internal network are reached by container name
+	host := s.host
+	if internal := customer.InternalHost(); internal != "" {
+		host = internal
+	} else if customer.NodeID != nil {
+		nodeHost, ok := hosts[*customer.NodeID]
+		if !ok {
+			node, err := s.db.GetNode(ctx, *customer.NodeID)
+			if err != nil {
+				return err
+			}
+			nodeHost = node.Address
+			hosts[*customer.NodeID] = nodeHost
+		}
+		host = nodeHost
+	}
+
+	check := s.probe(ctx, host, *customer.ContainerPort, endpoint)
+	if err := s.db.RecordHealthCheck(ctx, customerID, check); err != nil {
+		return err
+	}
+
+	return s.apply(ctx, customer, check)
+}
+
+func (s *Supervisor) probe(ctx context.Context, host string, port int, endpoint string) db.HealthCheck {
+	check := db.HealthCheck{CheckedAt: s.now()}
+	url := fmt.Sprintf("http://%s:%d%s", host, port, endpoint)
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	resp, err := s.client.Do(req)
+	check.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	resp.Body.Close()
+
+	check.StatusCode = resp.StatusCode
+	check.Healthy = resp.StatusCode >= 200 && resp.StatusCode <= 299
+	if !check.Healthy {
+		check.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	return check
+}
+
+// apply updates the tenant's state with a probe result and restarts or degrades it if the policy says so
+func (s *Supervisor) apply(ctx context.Context, customer *db.Customer, check db.HealthCheck) error {
+	id := customer.ID
+	degraded := customer.Status == "degraded"
+
+	s.mu.Lock()
+	state, ok := s.tenants[id]
+	if !ok {
+		state = &TenantState{}
+		s.tenants[id] = state
+	}
+	state.LastCheck = &check
+	state.Healthy = check.Healthy
+
+	if check.Healthy {
+		state.ConsecutiveFailures = 0
+		state.Restarts = 0
+		state.NextRestartAt = nil
+		s.mu.Unlock()
+
+		if !degraded {
+			return nil
+		}
+		s.logger.Info("Degraded tenant recovered", zap.String("customer_id", id))
+		if err := s.db.UpdateCustomerStatus(ctx, id, "active"); err != nil {
+			return err
+		}
+		return s.db.RecordHealthEvent(ctx, id, "recovered", "")
+	}
+
+	state.ConsecutiveFailures++
+	due := state.ConsecutiveFailures >= s.policy.FailureThreshold &&
+		(state.NextRestartAt == nil || !s.now().Before(*state.NextRestartAt))
+	if degraded || !due {
+		s.mu.Unlock()
+		return nil
+	}
+
+	if state.Restarts >= s.policy.CrashLoopLimit {
+		restarts := state.Restarts
+		s.mu.Unlock()
+
+		s.logger.Warn("Tenant crash-looping, marking degraded",
+			zap.String("customer_id", id), zap.Int("restarts", restarts))
+		if err := s.db.UpdateCustomerStatus(ctx, id, "degraded"); err != nil {
+			return err
+		}
+		return s.db.RecordHealthEvent(ctx, id, "degraded",
+			fmt.Sprintf("%d restarts without a healthy probe", restarts))
+	}
+
+	failures := state.ConsecutiveFailures
+	state.Restarts++
+	state.ConsecutiveFailures = 0
+	next := s.now().Add(s.policy.backoff(state.Restarts))
+	state.NextRestartAt = &next
+	s.mu.Unlock()
+
+	// Restarting shells out to docker and can take a while, so it runs without the lock
+	s.logger.Warn("Restarting unhealthy tenant",
+		zap.String("customer_id", id), zap.Int("consecutive_failures", failures))
+	if err := s.restarter.Restart(ctx, id); err != nil {
+		if logErr := s.db.RecordHealthEvent(ctx, id, "restart_failed", err.Error()); logErr != nil {
+			return errors.Join(err, logErr)
+		}
+		return err
+	}
+	return s.db.RecordHealthEvent(ctx, id, "restart",
+		fmt.Sprintf("after %d consecutive failed probes", failures))
+}
+
+// forgetExcept drops state for tenants that are no longer supervised
+func (s *Supervisor) forgetExcept(ids []string) {
+	keep := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		keep[id] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.tenants {
+		if !keep[id] {
+			delete(s.tenants, id)
+		}
+	}
+}
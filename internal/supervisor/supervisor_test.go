@@ -0,0 +1,336 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/db"
+)
+
+type fakeRestarter struct {
+	restarted []string
+	err       error
+}
+
+func (f *fakeRestarter) Restart(ctx context.Context, customerID string) error {
+	f.restarted = append(f.restarted, customerID)
+	return f.err
+}
+
+// fakeAgent is a tenant health endpoint whose status can be flipped
+type fakeAgent struct {
+	healthy atomic.Bool
+	server  *httptest.Server
+}
+
+func newFakeAgent(t *testing.T) *fakeAgent {
+	agent := &fakeAgent{}
+	agent.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && agent.healthy.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(agent.server.Close)
+	return agent
+}
+
+func (a *fakeAgent) port(t *testing.T) int {
+	u, err := url.Parse(a.server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	return port
+}
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func setup(t *testing.T, policy Policy) (*Supervisor, *db.DB, *fakeRestarter, *fakeAgent, *db.Customer, *testClock) {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+
+	ctx := t.Context()
+	customer, err := database.CreateCustomer(ctx, &db.CreateCustomerRequest{
+		Email:              "probe@example.com",
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+
+	agent := newFakeAgent(t)
+	require.NoError(t, database.UpdateCustomerPort(ctx, customer.ID, agent.port(t)))
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "active"))
+
+	restarter := &fakeRestarter{}
+	clock := &testClock{now: time.Now()}
+	sup := New(database, restarter, policy, nil)
+	sup.now = func() time.Time { return clock.now }
+
+	return sup, database, restarter, agent, customer, clock
+}
+
+func testPolicy() Policy {
+	return Policy{
+		FailureThreshold: 2,
+		BackoffBase:      time.Minute,
+		BackoffMax:       4 * time.Minute,
+		CrashLoopLimit:   2,
+		ProbeTimeout:     time.Second,
+		Retention:        24 * time.Hour,
+	}
+}
+
+func customerStatus(t *testing.T, database *db.DB, id string) string {
+	t.Helper()
+	customer, err := database.GetCustomerByID(t.Context(), id)
+	require.NoError(t, err)
+	return customer.Status
+}
+
+func TestPolicyBackoff(t *testing.T) {
+	p := Policy{BackoffBase: 30 * time.Second, BackoffMax: 5 * time.Minute}
+
+	tests := []struct {
+		restarts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{5, 5 * time.Minute},
+		{50, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, p.backoff(tt.restarts), "restarts=%d", tt.restarts)
+	}
+}
+
+func TestProbeRecordsHistory(t *testing.T) {
+	sup, database, restarter, agent, customer, _ := setup(t, testPolicy())
+	ctx := t.Context()
+
+	agent.healthy.Store(true)
+	require.NoError(t, sup.ProbeAll(ctx))
+
+	checks, err := database.ListHealthChecks(ctx, customer.ID, time.Time{}, 10)
+	require.NoError(t, err)
+	require.Len(t, checks, 1)
+	assert.True(t, checks[0].Healthy)
+	assert.Equal(t, http.StatusOK, checks[0].StatusCode)
+
+	state := sup.State(customer.ID)
+	require.NotNil(t, state)
+	assert.True(t, state.Healthy)
+	assert.Empty(t, restarter.restarted)
+}
+
+func TestProbeUsesNodeAddress(t *testing.T) {
+	sup, database, _, agent, customer, _ := setup(t, testPolicy())
+	ctx := t.Context()
+
+	// Nothing listens on the control-plane host; the agent is on the node
+	sup.host = "127.0.0.2"
+	node := &db.Node{Name: "worker-1", Address: "127.0.0.1", AgentURL: "http://127.0.0.1:9100",
+		MemoryMB: 4096, CPUs: 2, PortStart: 1, PortEnd: 65535}
+	require.NoError(t, database.CreateNode(ctx, node))
+	require.NoError(t, database.AssignNode(ctx, customer.ID, node.ID, agent.port(t)))
+
+	agent.healthy.Store(true)
+	require.NoError(t, sup.ProbeAll(ctx))
+
+	state := sup.State(customer.ID)
+	require.NotNil(t, state)
+	assert.True(t, state.Healthy)
+}
+
+func TestProbeUsesInternalNetwork(t *testing.T) {
+	sup, database, _, agent, customer, _ := setup(t, testPolicy())
+	ctx := t.Context()
+
+	// Resolve the tenant's container name to the fake agent
+	internal := "blytz-" + customer.ID + ":18789"
+	sup.host = "127.0.0.2"
+	sup.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr != internal {
+				return nil, errors.New("unexpected address " + addr)
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, agent.server.Listener.Addr().String())
+		},
+	}
+	require.NoError(t, database.SetCustomerNetwork(ctx, customer.ID, "blytz-tenants", 18789))
+
+	agent.healthy.Store(true)
+	require.NoError(t, sup.ProbeAll(ctx))
+
+	state := sup.State(customer.ID)
+	require.NotNil(t, state)
+	assert.True(t, state.Healthy)
+}
+
+func TestRestartAfterThresholdWithBackoff(t *testing.T) {
+	sup, database, restarter, _, customer, clock := setup(t, testPolicy())
+	ctx := t.Context()
+
+	// First failure is below the threshold
+	require.NoError(t, sup.ProbeAll(ctx))
+	assert.Empty(t, restarter.restarted)
+
+	// Second consecutive failure triggers a restart
+	require.NoError(t, sup.ProbeAll(ctx))
+	assert.Equal(t, []string{customer.ID}, restarter.restarted)
+
+	// Failing again inside the backoff window does not restart
+	require.NoError(t, sup.ProbeAll(ctx))
+	require.NoError(t, sup.ProbeAll(ctx))
+	assert.Len(t, restarter.restarted, 1)
+
+	// Once the one-minute backoff has passed it restarts again
+	clock.advance(time.Minute)
+	require.NoError(t, sup.ProbeAll(ctx))
+	assert.Len(t, restarter.restarted, 2)
+
+	state := sup.State(customer.ID)
+	require.NotNil(t, state)
+	assert.Equal(t, 2, state.Restarts)
+	require.NotNil(t, state.NextRestartAt)
+	assert.Equal(t, clock.now.Add(2*time.Minute), *state.NextRestartAt)
+
+	events, err := database.ListHealthEvents(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "restart", events[0].Action)
+	assert.Equal(t, "active", customerStatus(t, database, customer.ID))
+}
+
+func TestCrashLoopMarksDegradedAndRecovers(t *testing.T) {
+	sup, database, restarter, agent, customer, clock := setup(t, testPolicy())
+	ctx := t.Context()
+
+	// Two restarts use up the crash-loop limit
+	for i := 0; i < 2; i++ {
+		require.NoError(t, sup.ProbeAll(ctx))
+		require.NoError(t, sup.ProbeAll(ctx))
+		clock.advance(time.Hour)
+	}
+	require.Len(t, restarter.restarted, 2)
+
+	// The next due restart marks the tenant degraded instead
+	require.NoError(t, sup.ProbeAll(ctx))
+	require.NoError(t, sup.ProbeAll(ctx))
+	assert.Len(t, restarter.restarted, 2)
+	assert.Equal(t, "degraded", customerStatus(t, database, customer.ID))
+
+	// Degraded tenants are still probed but never restarted
+	clock.advance(time.Hour)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sup.ProbeAll(ctx))
+	}
+	assert.Len(t, restarter.restarted, 2)
+
+	report, err := sup.Report(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "degraded", report.Status)
+
+	// A healthy probe brings it back
+	agent.healthy.Store(true)
+	require.NoError(t, sup.ProbeAll(ctx))
+	assert.Equal(t, "active", customerStatus(t, database, customer.ID))
+	assert.Zero(t, sup.State(customer.ID).Restarts)
+
+	events, err := database.ListHealthEvents(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+	}
+	assert.Equal(t, []string{"restart", "restart", "degraded", "recovered"}, actions)
+}
+
+func TestRestartFailureIsRecorded(t *testing.T) {
+	sup, database, restarter, _, customer, _ := setup(t, testPolicy())
+	ctx := t.Context()
+	restarter.err = errors.New("docker unavailable")
+
+	require.NoError(t, sup.ProbeAll(ctx))
+	err := sup.ProbeAll(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker unavailable")
+
+	events, err := database.ListHealthEvents(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "restart_failed", events[0].Action)
+}
+
+func TestUnsupervisedTenantsAreSkipped(t *testing.T) {
+	sup, database, restarter, _, customer, _ := setup(t, testPolicy())
+	ctx := t.Context()
+
+	require.NoError(t, sup.ProbeAll(ctx))
+	require.NotNil(t, sup.State(customer.ID))
+
+	require.NoError(t, database.UpdateCustomerStatus(ctx, customer.ID, "suspended"))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sup.ProbeAll(ctx))
+	}
+
+	assert.Empty(t, restarter.restarted)
+	assert.Nil(t, sup.State(customer.ID), "state is dropped once a tenant stops being supervised")
+
+	total, _, err := database.CountHealthChecks(ctx, customer.ID, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+}
+
+func TestReport(t *testing.T) {
+	sup, _, _, agent, customer, clock := setup(t, testPolicy())
+	ctx := t.Context()
+
+	report, err := sup.Report(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown", report.Status)
+	assert.Nil(t, report.UptimePercent)
+
+	agent.healthy.Store(true)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sup.ProbeAll(ctx))
+		clock.advance(time.Second)
+	}
+	agent.healthy.Store(false)
+	require.NoError(t, sup.ProbeAll(ctx))
+
+	report, err = sup.Report(ctx, customer.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "failing", report.Status)
+	assert.Equal(t, 4, report.ChecksInWindow)
+	require.NotNil(t, report.UptimePercent)
+	assert.Equal(t, 75.0, *report.UptimePercent)
+	require.Len(t, report.Recent, 4)
+	assert.False(t, report.Recent[0].Healthy, "newest first")
+
+	_, err = sup.Report(ctx, "missing")
+	assert.Error(t, err)
+}
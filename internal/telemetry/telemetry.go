@@ -0,0 +1,258 @@
+// Package telemetry exposes control-plane metrics in Prometheus exposition format
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "blytz"
+
+// Registry holds every control-plane metric. It is separate from the global
+// default registry so tests and other libraries cannot pollute it.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	provisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provisioning_duration_seconds",
+		Help:      "Time taken to provision a tenant, by agent type and outcome.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"agent_type", "outcome"})
+
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Stripe webhook events received, by event type and result.",
+	}, []string{"type", "result"})
+
+	llmProxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_proxy_requests_total",
+		Help:      "Requests to the LLM proxy, by provider and outcome.",
+	}, []string{"provider", "outcome"})
+
+	llmProxyTokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_proxy_tokens_total",
+		Help:      "Tokens metered by the LLM proxy, by provider and direction (input or output).",
+	}, []string{"provider", "direction"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		provisioningDuration,
+		webhookEvents,
+		llmProxyRequests,
+		llmProxyTokens,
+	)
+}
+
+// Handler serves the registry in Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records request counts and latency per matched route.
+// Using the route template rather than the raw path keeps label cardinality bounded.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveProvisioning records how long provisioning a tenant took and whether it succeeded
+func ObserveProvisioning(agentType string, err error, duration time.Duration) {
+	if agentType == "" {
+		agentType = "unknown"
+	}
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	provisioningDuration.WithLabelValues(agentType, outcome).Observe(duration.Seconds())
+}
+
+// RecordWebhookEvent counts a webhook event with its processing result
+// ("processed", "ignored", "error" or "invalid")
+func RecordWebhookEvent(eventType, result string) {
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	webhookEvents.WithLabelValues(eventType, result).Inc()
+}
+
+// RecordLLMProxyRequest counts an LLM proxy request with its outcome
+// ("forwarded", "rate_limited", "spend_capped" or "upstream_error")
+func RecordLLMProxyRequest(provider, outcome string) {
+	llmProxyRequests.WithLabelValues(provider, outcome).Inc()
+}
+
+// AddLLMProxyTokens adds metered tokens for a provider
+func AddLLMProxyTokens(provider string, input, output int) {
+	llmProxyTokens.WithLabelValues(provider, "input").Add(float64(input))
+	llmProxyTokens.WithLabelValues(provider, "output").Add(float64(output))
+}
+
+// StatsProvider is implemented by circuitbreaker.CircuitBreaker and the resilience wrappers
+type StatsProvider interface {
+	Stats() map[string]interface{}
+}
+
+var breakerStateValues = map[string]float64{
+	"closed":    0,
+	"open":      1,
+	"half-open": 2,
+}
+
+// breakerCollector reads circuit breaker stats at scrape time
+type breakerCollector struct {
+	mu       sync.RWMutex
+	breakers map[string]StatsProvider
+	state    *prometheus.Desc
+	failures *prometheus.Desc
+}
+
+var breakers = &breakerCollector{
+	breakers: make(map[string]StatsProvider),
+	state: prometheus.NewDesc(namespace+"_circuit_breaker_state",
+		"Circuit breaker state (0 = closed, 1 = open, 2 = half-open).", []string{"name"}, nil),
+	failures: prometheus.NewDesc(namespace+"_circuit_breaker_failures",
+		"Consecutive failures recorded by the circuit breaker.", []string{"name"}, nil),
+}
+
+func init() {
+	Registry.MustRegister(breakers)
+}
+
+func (b *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.state
+	ch <- b.failures
+}
+
+func (b *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for name, provider := range b.breakers {
+		stats := provider.Stats()
+		if state, ok := breakerStateValues[fmt.Sprint(stats["state"])]; ok {
+			ch <- prometheus.MustNewConstMetric(b.state, prometheus.GaugeValue, state, name)
+		}
+		if failures, ok := stats["failures"].(int); ok {
+			ch <- prometheus.MustNewConstMetric(b.failures, prometheus.GaugeValue, float64(failures), name)
+		}
+	}
+}
+
+// RegisterCircuitBreaker exports a circuit breaker's state under the given name.
+// Registering the same name again replaces the previous breaker.
+func RegisterCircuitBreaker(name string, provider StatsProvider) {
+	breakers.mu.Lock()
+	defer breakers.mu.Unlock()
+	breakers.breakers[name] = provider
+}
+
+// PortPoolFunc reports the number of allocated ports and the size of the pool
+type PortPoolFunc func() (allocated, capacity int)
+
+// CustomerCountFunc reports the number of customers in each status
+type CustomerCountFunc func(ctx context.Context) (map[string]int, error)
+
+// stateCollector reads port pool usage and customer counts at scrape time
+type stateCollector struct {
+	ports     PortPoolFunc
+	customers CustomerCountFunc
+
+	portsAllocated *prometheus.Desc
+	portsCapacity  *prometheus.Desc
+	customerCount  *prometheus.Desc
+	scrapeErrors   prometheus.Counter
+}
+
+// RegisterStateCollectors exports port pool usage and per-status customer counts.
+// Either function may be nil. It must only be called once per process.
+func RegisterStateCollectors(ports PortPoolFunc, customers CustomerCountFunc) error {
+	sc := &stateCollector{
+		ports:     ports,
+		customers: customers,
+		portsAllocated: prometheus.NewDesc(namespace+"_port_pool_allocated",
+			"Host ports currently allocated to tenants.", nil, nil),
+		portsCapacity: prometheus.NewDesc(namespace+"_port_pool_capacity",
+			"Total host ports available for tenants.", nil, nil),
+		customerCount: prometheus.NewDesc(namespace+"_customers",
+			"Customers by lifecycle status.", []string{"status"}, nil),
+		scrapeErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "state_scrape_errors_total",
+			Help:      "Errors reading state for metrics scrapes.",
+		}),
+	}
+	return Registry.Register(sc)
+}
+
+func (s *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.portsAllocated
+	ch <- s.portsCapacity
+	ch <- s.customerCount
+	s.scrapeErrors.Describe(ch)
+}
+
+func (s *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	if s.ports != nil {
+		allocated, capacity := s.ports()
+		ch <- prometheus.MustNewConstMetric(s.portsAllocated, prometheus.GaugeValue, float64(allocated))
+		ch <- prometheus.MustNewConstMetric(s.portsCapacity, prometheus.GaugeValue, float64(capacity))
+	}
+
+	if s.customers != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		counts, err := s.customers(ctx)
+		if err != nil {
+			s.scrapeErrors.Inc()
+		}
+		for status, count := range counts {
+			ch <- prometheus.MustNewConstMetric(s.customerCount, prometheus.GaugeValue, float64(count), status)
+		}
+	}
+
+	s.scrapeErrors.Collect(ch)
+}
@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"blytz/internal/circuitbreaker"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	Handler().ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
+
+func TestMiddlewareRecordsRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/api/status/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/status/:id", "200"))
+	for _, id := range []string{"a", "b", "c"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/status/"+id, nil)
+		router.ServeHTTP(w, req)
+	}
+	after := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/api/status/:id", "200"))
+	assert.Equal(t, 3.0, after-before)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/nope", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 1.0, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "unmatched", "404")))
+
+	assert.Contains(t, scrape(t), `blytz_http_request_duration_seconds_count{method="GET",route="/api/status/:id"}`)
+}
+
+func TestObserveProvisioning(t *testing.T) {
+	ObserveProvisioning("openclaw", nil, 2*time.Second)
+	ObserveProvisioning("myrai", errors.New("boom"), time.Second)
+	ObserveProvisioning("", errors.New("no customer"), time.Millisecond)
+
+	body := scrape(t)
+	assert.Contains(t, body, `blytz_provisioning_duration_seconds_count{agent_type="openclaw",outcome="success"} 1`)
+	assert.Contains(t, body, `blytz_provisioning_duration_seconds_count{agent_type="myrai",outcome="failure"} 1`)
+	assert.Contains(t, body, `blytz_provisioning_duration_seconds_count{agent_type="unknown",outcome="failure"} 1`)
+}
+
+func TestRecordWebhookEvent(t *testing.T) {
+	RecordWebhookEvent("invoice.payment_failed", "processed")
+	RecordWebhookEvent("invoice.payment_failed", "processed")
+	RecordWebhookEvent("", "invalid")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(webhookEvents.WithLabelValues("invoice.payment_failed", "processed")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(webhookEvents.WithLabelValues("unknown", "invalid")))
+}
+
+func TestCircuitBreakerMetrics(t *testing.T) {
+	cb := circuitbreaker.New(circuitbreaker.Config{MaxFailures: 1, Timeout: time.Hour, HalfOpenMax: 1, SuccessThreshold: 1})
+	RegisterCircuitBreaker("test", cb)
+
+	assert.Contains(t, scrape(t), `blytz_circuit_breaker_state{name="test"} 0`)
+
+	_ = cb.Execute(context.Background(), func() error { return errors.New("fail") })
+
+	body := scrape(t)
+	assert.Contains(t, body, `blytz_circuit_breaker_state{name="test"} 1`)
+	assert.Contains(t, body, `blytz_circuit_breaker_failures{name="test"} 1`)
+}
+
+func TestStateCollectors(t *testing.T) {
+	ports := func() (int, int) { return 3, 1000 }
+	customers := func(ctx context.Context) (map[string]int, error) {
+		return map[string]int{"active": 4, "pending": 1}, nil
+	}
+	require.NoError(t, RegisterStateCollectors(ports, customers))
+
+	body := scrape(t)
+	assert.Contains(t, body, "blytz_port_pool_allocated 3")
+	assert.Contains(t, body, "blytz_port_pool_capacity 1000")
+	assert.Contains(t, body, `blytz_customers{status="active"} 4`)
+	assert.Contains(t, body, `blytz_customers{status="pending"} 1`)
+
+	// A second registration in the same process is rejected rather than duplicated
+	assert.Error(t, RegisterStateCollectors(ports, customers))
+	assert.Equal(t, 1, strings.Count(body, "# TYPE blytz_port_pool_allocated"))
+}
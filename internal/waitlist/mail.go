@@ -0,0 +1,80 @@
+package waitlist
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"blytz/internal/db"
+)
+
+// InviteURL is the page an invited signup claims their invitation from
+func InviteURL(baseDomain, id string) string {
+	return fmt.Sprintf("https://%s/waitlist?id=%s", baseDomain, id)
+}
+
+// MailNotifier emails invitations through an SMTP relay
+type MailNotifier struct {
+	addr       string
+	auth       smtp.Auth
+	from       string
+	baseDomain string
+	send       func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewMailNotifier sends invitations from the address from through the relay
+// at addr (host:port), authenticating when username is set. The claim links
+// point at baseDomain.
+func NewMailNotifier(addr, username, password, from, baseDomain string) *MailNotifier {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &MailNotifier{
+		addr:       addr,
+		auth:       auth,
+		from:       from,
+		baseDomain: baseDomain,
+		send:       smtp.SendMail,
+	}
+}
+
+// NotifyInvite emails the invitee their claim link and when it expires
+func (m *MailNotifier) NotifyInvite(ctx context.Context, entry db.WaitlistEntry) error {
+	// The address ends up in a header, so it must not smuggle in others
+	if strings.ContainsAny(entry.Email, "\r\n") {
+		return fmt.Errorf("invalid invitee address %q", entry.Email)
+	}
+
+	if err := m.send(m.addr, m.auth, m.from, []string{entry.Email}, m.inviteMessage(entry)); err != nil {
+		return fmt.Errorf("send invitation: %w", err)
+	}
+	return nil
+}
+
+func (m *MailNotifier) inviteMessage(entry db.WaitlistEntry) []byte {
+	held := "Your spot is held until you claim it."
+	if entry.InviteExpiresAt != nil {
+		held = "Your spot is held until " + entry.InviteExpiresAt.UTC().Format(time.RFC1123) + "."
+	}
+
+	lines := []string{
+		"From: " + m.from,
+		"To: " + entry.Email,
+		"Subject: A spot has opened up for your Blytz assistant",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		"A spot has opened up for " + entry.AssistantName + ". Complete your signup here:",
+		"",
+		InviteURL(m.baseDomain, entry.ID),
+		"",
+		held,
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
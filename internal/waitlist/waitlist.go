@@ -0,0 +1,169 @@
+// Package waitlist queues signups received at capacity and invites them in
+// order as slots free up
+package waitlist
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+// Service admits signups while there is capacity and invites waitlisted
+// signups when slots free up through terminations or a higher MAX_CUSTOMERS
+type Service struct {
+	db           *db.DB
+	maxCustomers int
+	inviteTTL    time.Duration
+	notifier     Notifier
+	logger       *zap.Logger
+	now          func() time.Time
+}
+
+// Notifier tells an invited signup where to claim their invitation
+type Notifier interface {
+	NotifyInvite(ctx context.Context, entry db.WaitlistEntry) error
+}
+
+// NewService creates a waitlist for a platform of maxCustomers tenants.
+// Invitations must be claimed within inviteTTL; zero lets them stand until claimed.
+func NewService(database *db.DB, maxCustomers int, inviteTTL time.Duration, logger *zap.Logger) *Service {
+	return &Service{
+		db:           database,
+		maxCustomers: maxCustomers,
+		inviteTTL:    inviteTTL,
+		logger:       logger,
+		now:          time.Now,
+	}
+}
+
+// UseNotifier sends each invitation to the invitee through n. Until it is
+// set, invitees only learn of their invitation by polling its status.
+func (s *Service) UseNotifier(n Notifier) {
+	s.notifier = n
+}
+
+// Admit reports whether a new signup can proceed straight to checkout. It
+// cannot while the platform is full, counting slots held by open invites, or
+// while others are already waiting.
+func (s *Service) Admit(ctx context.Context) (bool, error) {
+	free, waiting, err := s.capacity(ctx)
+	if err != nil {
+		return false, err
+	}
+	return free > 0 && waiting == 0, nil
+}
+
+// InviteAvailable drops lapsed invitations, then invites as many waiting
+// signups as there are free slots and notifies every invitee not yet told,
+// including those a previous attempt failed to reach. It returns the entries
+// invited.
+func (s *Service) InviteAvailable(ctx context.Context) ([]db.WaitlistEntry, error) {
+	invited, err := s.invite(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.notifyInvites(ctx); err != nil {
+		return invited, err
+	}
+
+	return invited, nil
+}
+
+func (s *Service) invite(ctx context.Context) ([]db.WaitlistEntry, error) {
+	now := s.now()
+
+	expired, err := s.db.ExpireWaitlistInvites(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+	if expired > 0 {
+		s.logger.Info("Waitlist invitations lapsed", zap.Int("count", expired))
+	}
+
+	free, waiting, err := s.capacity(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if free <= 0 || waiting == 0 {
+		return nil, nil
+	}
+
+	var expiresAt *time.Time
+	if s.inviteTTL > 0 {
+		expires := now.Add(s.inviteTTL)
+		expiresAt = &expires
+	}
+
+	invited, err := s.db.InviteFromWaitlist(ctx, free, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range invited {
+		s.logger.Info("Waitlist invitation issued", zap.String("waitlist_id", entry.ID))
+	}
+
+	return invited, nil
+}
+
+// notifyInvites sends the invitations no invitee has been told about yet.
+// Entries that fail stay unnotified and are retried on the next pass.
+func (s *Service) notifyInvites(ctx context.Context) error {
+	if s.notifier == nil {
+		return nil
+	}
+
+	pending, err := s.db.ListUnnotifiedWaitlistInvites(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range pending {
+		if err := s.notifier.NotifyInvite(ctx, entry); err != nil {
+			s.logger.Error("Failed to send waitlist invitation", zap.String("waitlist_id", entry.ID), zap.Error(err))
+			continue
+		}
+		if err := s.db.MarkWaitlistInviteNotified(ctx, entry.ID, s.now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run issues invitations every interval until ctx is cancelled
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.InviteAvailable(ctx); err != nil {
+			s.logger.Error("Failed to invite from waitlist", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// capacity returns the free slots, net of open invitations, and how many
+// signups are waiting
+func (s *Service) capacity(ctx context.Context) (free, waiting int, err error) {
+	active, err := s.db.CountActiveCustomers(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	waiting, invited, err := s.db.CountWaitlist(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return s.maxCustomers - active - invited, waiting, nil
+}
@@ -0,0 +1,186 @@
+package waitlist
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"blytz/internal/db"
+)
+
+func newTestDB(t *testing.T) *db.DB {
+	t.Helper()
+	database, err := db.New(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { database.Close() })
+	require.NoError(t, database.Migrate())
+	return database
+}
+
+func createCustomer(t *testing.T, database *db.DB, email string) *db.Customer {
+	t.Helper()
+	customer, err := database.CreateCustomer(t.Context(), &db.CreateCustomerRequest{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	})
+	require.NoError(t, err)
+	return customer
+}
+
+func addWaitlisted(t *testing.T, database *db.DB, email string) *db.WaitlistEntry {
+	t.Helper()
+	entry := &db.WaitlistEntry{
+		Email:              email,
+		AssistantName:      "Test",
+		CustomInstructions: "Help me",
+		TelegramBotToken:   "123:abc",
+	}
+	require.NoError(t, database.AddToWaitlist(t.Context(), entry))
+	return entry
+}
+
+func TestAdmit(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	svc := NewService(database, 2, time.Hour, zap.NewNop())
+
+	admit, err := svc.Admit(ctx)
+	require.NoError(t, err)
+	assert.True(t, admit)
+
+	createCustomer(t, database, "a@example.com")
+	first := createCustomer(t, database, "b@example.com")
+	admit, err = svc.Admit(ctx)
+	require.NoError(t, err)
+	assert.False(t, admit, "at capacity")
+
+	// A freed slot goes to the queue before new signups
+	addWaitlisted(t, database, "waiting@example.com")
+	require.NoError(t, database.UpdateCustomerStatus(ctx, first.ID, "terminated"))
+	admit, err = svc.Admit(ctx)
+	require.NoError(t, err)
+	assert.False(t, admit, "others are waiting")
+
+	// The invitation holds the slot until it is claimed or lapses
+	invited, err := svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	admit, err = svc.Admit(ctx)
+	require.NoError(t, err)
+	assert.False(t, admit, "slot held by invitation")
+}
+
+func TestInviteAvailable(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	svc := NewService(database, 2, time.Hour, zap.NewNop())
+
+	createCustomer(t, database, "a@example.com")
+	first := addWaitlisted(t, database, "first@example.com")
+	second := addWaitlisted(t, database, "second@example.com")
+	third := addWaitlisted(t, database, "third@example.com")
+
+	invited, err := svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Equal(t, first.ID, invited[0].ID)
+	require.NotNil(t, invited[0].InviteExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *invited[0].InviteExpiresAt, time.Minute)
+
+	// Nothing more until a slot frees up
+	invited, err = svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, invited)
+
+	// Raising the limit invites the next in line
+	svc.maxCustomers = 3
+	invited, err = svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Equal(t, second.ID, invited[0].ID)
+
+	// Lapsed invitations are dropped and their slots passed on
+	svc.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	invited, err = svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Equal(t, third.ID, invited[0].ID)
+
+	_, err = database.GetWaitlistEntry(ctx, first.ID)
+	assert.Error(t, err)
+}
+
+type fakeNotifier struct {
+	sent []string
+	err  error
+}
+
+func (f *fakeNotifier) NotifyInvite(ctx context.Context, entry db.WaitlistEntry) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, entry.Email)
+	return nil
+}
+
+func TestInviteAvailableNotifies(t *testing.T) {
+	database := newTestDB(t)
+	ctx := t.Context()
+	svc := NewService(database, 1, time.Hour, zap.NewNop())
+	notifier := &fakeNotifier{err: errors.New("relay down")}
+	svc.UseNotifier(notifier)
+
+	addWaitlisted(t, database, "first@example.com")
+	addWaitlisted(t, database, "second@example.com")
+
+	// The invitation stands when it cannot be sent
+	invited, err := svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	require.Len(t, invited, 1)
+	assert.Empty(t, notifier.sent)
+
+	// and is sent on a later pass, once only
+	notifier.err = nil
+	invited, err = svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, invited)
+	assert.Equal(t, []string{"first@example.com"}, notifier.sent)
+
+	_, err = svc.InviteAvailable(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first@example.com"}, notifier.sent)
+}
+
+func TestMailNotifier(t *testing.T) {
+	mailer := NewMailNotifier("smtp.example.com:587", "user", "secret", "waitlist@blytz.cloud", "blytz.cloud")
+	var to []string
+	var msg string
+	mailer.send = func(addr string, auth smtp.Auth, from string, rcpt []string, body []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		assert.NotNil(t, auth)
+		assert.Equal(t, "waitlist@blytz.cloud", from)
+		to = rcpt
+		msg = string(body)
+		return nil
+	}
+
+	expires := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry := db.WaitlistEntry{ID: "w1", Email: "a@example.com", AssistantName: "Ada", InviteExpiresAt: &expires}
+	require.NoError(t, mailer.NotifyInvite(t.Context(), entry))
+
+	assert.Equal(t, []string{"a@example.com"}, to)
+	assert.Contains(t, msg, "To: a@example.com\r\n")
+	assert.Contains(t, msg, "https://blytz.cloud/waitlist?id=w1")
+	assert.Contains(t, msg, "Sun, 01 Mar 2026 12:00:00 UTC")
+
+	entry.Email = "a@example.com\r\nBcc: everyone@example.com"
+	assert.Error(t, mailer.NotifyInvite(t.Context(), entry))
+}
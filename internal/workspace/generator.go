@@ -13,6 +13,19 @@ type Generator struct {
 	baseDir      string
 }
 
+// TemplateFile maps a template under the templates directory to the workspace file it renders
+type TemplateFile struct {
+	Name     string
+	Filename string
+}
+
+// Templates lists every template Generate needs to find in the templates directory
+var Templates = []TemplateFile{
+	{"personal-assistant/AGENTS.md.tmpl", "AGENTS.md"},
+	{"personal-assistant/USER.md.tmpl", "USER.md"},
+	{"personal-assistant/SOUL.md.tmpl", "SOUL.md"},
+}
+
 type TemplateData struct {
 	AssistantName        string
 	UserDescription      string
@@ -47,21 +60,12 @@ func (g *Generator) Generate(customerID, assistantName, customInstructions strin
 		return fmt.Errorf("create workspace directory: %w", err)
 	}
 
-	templates := []struct {
-		name     string
-		filename string
-	}{
-		{"personal-assistant/AGENTS.md.tmpl", "AGENTS.md"},
-		{"personal-assistant/USER.md.tmpl", "USER.md"},
-		{"personal-assistant/SOUL.md.tmpl", "SOUL.md"},
-	}
-
-	for _, tmpl := range templates {
-		templatePath := filepath.Join(g.templatesDir, tmpl.name)
-		outputPath := filepath.Join(workspaceDir, tmpl.filename)
+	for _, tmpl := range Templates {
+		templatePath := filepath.Join(g.templatesDir, tmpl.Name)
+		outputPath := filepath.Join(workspaceDir, tmpl.Filename)
 
 		if err := g.generateFile(templatePath, outputPath, data); err != nil {
-			return fmt.Errorf("generate %s: %w", tmpl.filename, err)
+			return fmt.Errorf("generate %s: %w", tmpl.Filename, err)
 		}
 	}
 